@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// An annotation is a comment attached to a range of the local document. The range is
+// anchored to the document's characters, so it follows them as the document is edited.
+type annotation struct {
+	id     string
+	author string
+	text   string
+	anchor crdt.Anchor
+}
+
+var (
+	// annotations holds the session's annotations, in the order in which they were added.
+	annotations []annotation
+
+	// annotationMark is the position at which the range of a new annotation starts, or -1
+	// if no range is being selected.
+	annotationMark = -1
+
+	// showAnnotations indicates whether the annotations panel is shown.
+	showAnnotations bool
+)
+
+// addAnnotation anchors an annotation received from the server (or written locally) to
+// the local document. Annotations with an existing ID replace the existing annotation,
+// and deleted annotations are removed.
+func addAnnotation(a commons.Annotation) error {
+	removeAnnotation(a.ID)
+	if a.Deleted {
+		return nil
+	}
+
+	anchor, err := anchorAnnotation(&a)
+	if err != nil {
+		return err
+	}
+
+	annotations = append(annotations, annotation{id: a.ID, author: a.Author, text: a.Text, anchor: anchor})
+	return nil
+}
+
+// anchorAnnotation returns the anchor of a's range, and sets a's character IDs to the
+// anchor's. The range is anchored to the characters a names by ID, if the local document
+// has them. Annotations from older clients only have positions, and are anchored to the
+// characters at those positions.
+func anchorAnnotation(a *commons.Annotation) (crdt.Anchor, error) {
+	if a.StartID != crdt.IDStart && a.EndID != crdt.IDEnd && doc.Contains(a.StartID) && doc.Contains(a.EndID) {
+		return crdt.Anchor{StartID: a.StartID, EndID: a.EndID}, nil
+	}
+
+	anchor, err := doc.NewAnchor(a.Start, a.End)
+	if err != nil {
+		return crdt.Anchor{}, err
+	}
+	a.StartID, a.EndID = anchor.StartID, anchor.EndID
+	return anchor, nil
+}
+
+// removeAnnotation removes the annotation with the given ID, if it exists.
+func removeAnnotation(id string) {
+	for i, a := range annotations {
+		if a.id == id {
+			annotations = append(annotations[:i], annotations[i+1:]...)
+			return
+		}
+	}
+}
+
+// sessionAnnotations returns the annotations with their current positions, to be sent with
+// the document. Annotations whose characters have all been deleted are left out.
+func sessionAnnotations() []commons.Annotation {
+	var list []commons.Annotation
+	for _, a := range annotations {
+		if start, end, ok := doc.Range(a.anchor); ok {
+			list = append(list, commons.Annotation{ID: a.id, Author: a.author, Text: a.text, Start: start, End: end,
+				StartID: a.anchor.StartID, EndID: a.anchor.EndID})
+		}
+	}
+	return list
+}
+
+// setAnnotations replaces the annotations by the ones received with a document sync.
+func setAnnotations(list []commons.Annotation) {
+	annotations = nil
+	annotationMark = -1
+	for _, a := range list {
+		if err := addAnnotation(a); err != nil {
+			logger.Errorf("failed to anchor annotation %s, err: %v\n", a.ID, err)
+		}
+	}
+}
+
+// annotationAt returns the annotation covering the character after the cursor.
+func annotationAt(cursor int) (annotation, bool) {
+	for _, a := range annotations {
+		if start, end, ok := doc.Range(a.anchor); ok && cursor+1 >= start && cursor+1 <= end {
+			return a, true
+		}
+	}
+	return annotation{}, false
+}
+
+// sendAnnotation sends an annotation to the other clients.
+func sendAnnotation(a commons.Annotation, conn *websocket.Conn) {
+	if !e.IsConnected {
+		return
+	}
+	if err := writeMessage(conn, commons.Message{Type: commons.AnnotationMessage, Annotation: &a}); err != nil {
+		e.IsConnected = false
+		e.StatusChan <- "lost connection!"
+	}
+}
+
+// markAnnotation handles the annotation key. The first press marks the start of the range
+// to annotate, and the second press asks for the comment on the range between the mark and
+// the cursor.
+func markAnnotation(conn *websocket.Conn) {
+	if annotationMark < 0 {
+		annotationMark = e.Cursor
+		e.StatusChan <- "Comment start marked, move to the end of the range and press Ctrl+K again"
+		return
+	}
+
+	// Annotate the characters between the mark and the cursor, or the one after the
+	// cursor if they're at the same position.
+	start, end := annotationMark, e.Cursor
+	annotationMark = -1
+	if start > end {
+		start, end = end, start
+	}
+	if start == end {
+		end++
+	}
+	if end > len(e.GetText()) {
+		e.StatusChan <- "Nothing to comment on"
+		return
+	}
+
+	e.ShowPrompt(&editor.Prompt{
+		Text: "Comment:",
+		Submit: func(text string) error {
+			text = strings.TrimSpace(text)
+			if text == "" {
+				return nil
+			}
+
+			a := commons.Annotation{ID: uuid.NewString(), Author: username, Text: text, Start: start + 1, End: end}
+			if _, err := anchorAnnotation(&a); err != nil {
+				logger.Errorf("failed to add annotation, err: %v\n", err)
+				e.StatusChan <- "Failed to add comment"
+				return nil
+			}
+			if err := addAnnotation(a); err != nil {
+				logger.Errorf("failed to add annotation, err: %v\n", err)
+				e.StatusChan <- "Failed to add comment"
+				return nil
+			}
+			sendAnnotation(a, conn)
+			refreshAnnotations()
+			return nil
+		},
+	})
+}
+
+// deleteAnnotation asks whether to delete the annotation at the cursor.
+func deleteAnnotation(conn *websocket.Conn) {
+	a, ok := annotationAt(e.Cursor)
+	if !ok {
+		e.StatusChan <- "No comment at the cursor"
+		return
+	}
+
+	e.ShowPrompt(&editor.Prompt{
+		Text: fmt.Sprintf("Delete the comment by %s? (y/n)", a.author),
+		Answers: map[rune]func() error{
+			'y': func() error {
+				removeAnnotation(a.id)
+				sendAnnotation(commons.Annotation{ID: a.id, Deleted: true}, conn)
+				refreshAnnotations()
+				return nil
+			},
+			'n': func() error { return nil },
+		},
+	})
+}
+
+// refreshAnnotations underlines the annotated text and the prompts, and updates the annotations panel if
+// it's shown.
+func refreshAnnotations() {
+	var highlights []editor.Range
+	var lines []string
+
+	text := e.GetText()
+	for _, a := range annotations {
+		start, end, ok := doc.Range(a.anchor)
+		if !ok {
+			lines = append(lines, fmt.Sprintf("%s: %s (text deleted)", a.author, a.text))
+			continue
+		}
+
+		highlights = append(highlights, editor.Range{Start: start - 1, End: end})
+		line := 1
+		if start-1 <= len(text) {
+			line += strings.Count(string(text[:start-1]), "\n")
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s (line %d)", a.author, a.text, line))
+	}
+
+	e.SetHighlights(append(highlights, promptHighlights()...))
+
+	if showAnnotations {
+		if len(lines) == 0 {
+			lines = []string{"No comments yet, add one with Ctrl+K"}
+		}
+		e.SetOverlay(&editor.Overlay{Title: "Comments", Lines: lines})
+	}
+}
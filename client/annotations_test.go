@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// TestAddAnnotation checks that annotations are anchored to the characters they name by ID,
+// whatever their positions, and to their positions when they don't name any (as those of
+// older clients don't), or name characters the document doesn't have.
+func TestAddAnnotation(t *testing.T) {
+	doc, _ = crdt.FromText("hello world")
+	world := crdt.IthVisible(doc, 7).ID
+	d := crdt.IthVisible(doc, 11).ID
+
+	tests := []struct {
+		description string
+		annotation  commons.Annotation
+		expected    string
+	}{
+		{"by ID, with positions from before an edit", commons.Annotation{Start: 1, End: 5, StartID: world, EndID: d}, "world"},
+		{"by position", commons.Annotation{Start: 1, End: 5}, "hello"},
+		{"unknown IDs", commons.Annotation{Start: 1, End: 5, StartID: crdt.CharacterID{SiteID: 9, Clock: 1}, EndID: d}, "hello"},
+	}
+
+	for _, tc := range tests {
+		setAnnotations(nil)
+		tc.annotation.ID = "a"
+		if err := addAnnotation(tc.annotation); err != nil {
+			t.Fatalf("%s: %v", tc.description, err)
+		}
+
+		list := sessionAnnotations()
+		if len(list) != 1 {
+			t.Fatalf("%s: got %d annotations, expected 1", tc.description, len(list))
+		}
+		a := list[0]
+		if got := crdt.Content(doc)[a.Start-1 : a.End]; got != tc.expected {
+			t.Errorf("%s: got %q annotated, expected %q", tc.description, got, tc.expected)
+		}
+		if a.StartID != crdt.IthVisible(doc, a.Start).ID || a.EndID != crdt.IthVisible(doc, a.End).ID {
+			t.Errorf("%s: got IDs %v-%v, expected those of positions %d-%d", tc.description, a.StartID, a.EndID, a.Start, a.End)
+		}
+	}
+}
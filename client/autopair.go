@@ -0,0 +1,63 @@
+package main
+
+import (
+	"unicode"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// autoPair enables inserting the closing bracket or quote after an opening one.
+	autoPair bool
+
+	// autoPairs maps the opening brackets and quotes to their closing ones.
+	autoPairs = map[rune]rune{'(': ')', '[': ']', '{': '}', '"': '"', '\'': '\'', '`': '`'}
+)
+
+// isCloser reports whether r closes a pair.
+func isCloser(r rune) bool {
+	for _, closer := range autoPairs {
+		if r == closer {
+			return true
+		}
+	}
+	return false
+}
+
+// autoPairRune handles a typed rune when auto-pairing is enabled, and reports whether
+// it did. Typing a closing bracket or quote before the same one moves the cursor over it.
+// Typing an opening one before whitespace, a closing one or the end of the document
+// inserts it and its closing one, as two inserts the other users get too, and places the
+// cursor between them.
+func autoPairRune(ch rune, conn *websocket.Conn) bool {
+	if !autoPair {
+		return false
+	}
+	text, cursor := e.GetText(), e.Cursor
+
+	if isCloser(ch) && cursor < len(text) && text[cursor] == ch {
+		e.FollowCursor()
+		e.MoveCursor(1, 0)
+		return true
+	}
+
+	closer, ok := autoPairs[ch]
+	if !ok {
+		return false
+	}
+	if cursor < len(text) && !unicode.IsSpace(text[cursor]) && !isCloser(text[cursor]) {
+		return false
+	}
+	// Quotes after letters and digits are more likely apostrophes, or close a string.
+	if closer == ch && cursor > 0 && (unicode.IsLetter(text[cursor-1]) || unicode.IsDigit(text[cursor-1])) {
+		return false
+	}
+
+	if !performOperation(OperationInsert, ch, conn) {
+		return true
+	}
+	if performOperation(OperationInsert, closer, conn) {
+		e.MoveCursorRunes(-1)
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// TestAutoPairRune checks that opening brackets and quotes are paired where they're
+// likely to be, and that closing ones are typed over.
+func TestAutoPairRune(t *testing.T) {
+	conn := sink(t)
+	autoPair, readOnly = true, false
+	setPrompts(nil)
+	defer func() { autoPair = false }()
+
+	tests := []struct {
+		text     string
+		cursor   int
+		typed    string
+		expected string
+		cursorAt int
+	}{
+		{"", 0, "(", "()", 1},
+		{"", 0, "(x)", "(x)", 3},
+		{"f ", 2, "[{", "f [{}]", 4},
+		{"x", 0, "(", "(x", 1},
+		{"don", 3, "'t", "don't", 5},
+		{"", 0, "\"a\"", "\"a\"", 3},
+		{"a)", 1, ")", "a)", 2},
+	}
+
+	for _, tc := range tests {
+		doc, _ = crdt.FromText(tc.text)
+		e = editor.NewEditor(editor.EditorConfig{})
+		e.SetText(crdt.Content(doc))
+		e.SetX(tc.cursor)
+
+		for _, ch := range tc.typed {
+			if !autoPairRune(ch, conn) {
+				performOperation(OperationInsert, ch, conn)
+			}
+		}
+		if got := crdt.Content(doc); got != tc.expected || string(e.GetText()) != tc.expected {
+			t.Errorf("%q, typing %q: got %q, expected %q", tc.text, tc.typed, got, tc.expected)
+		}
+		if e.Cursor != tc.cursorAt {
+			t.Errorf("%q, typing %q: got cursor %d, expected %d", tc.text, tc.typed, e.Cursor, tc.cursorAt)
+		}
+	}
+}
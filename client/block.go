@@ -0,0 +1,242 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gdamore/tcell/v2"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// blockMode is set while a block (rectangular) selection is made. The block spans the
+	// lines and columns between its mark and the cursor.
+	blockMode bool
+
+	// blockLine and blockCol are the line and column, from 0, at which the block starts.
+	blockLine, blockCol int
+)
+
+// A block is a rectangular part of the document: the columns from left up to, but not
+// including, right, of the lines from top to bottom.
+type block struct {
+	top, bottom int
+	left, right int
+}
+
+// lineCol returns the line and column, from 0, of the rune of text at index.
+func lineCol(text []rune, index int) (line, col int) {
+	for _, r := range text[:index] {
+		col++
+		if r == '\n' {
+			line, col = line+1, 0
+		}
+	}
+	return line, col
+}
+
+// lineBounds returns the index of the first rune of the line of text with the given index,
+// from 0, and the index of the newline ending it, or the length of text for the last line.
+func lineBounds(text []rune, line int) (start, end int) {
+	start = lineStart(text, line)
+	end = start
+	for end < len(text) && text[end] != '\n' {
+		end++
+	}
+	return start, end
+}
+
+// currentBlock returns the block between the block's mark and the cursor.
+func currentBlock() block {
+	line, col := lineCol(e.GetText(), e.Cursor)
+	b := block{top: blockLine, bottom: line, left: blockCol, right: col}
+	if b.top > b.bottom {
+		b.top, b.bottom = b.bottom, b.top
+	}
+	if b.left > b.right {
+		b.left, b.right = b.right, b.left
+	}
+	return b
+}
+
+// blockRanges returns the ranges of text covered by the block, one for each of its lines
+// which reaches its left column.
+func blockRanges(text []rune, b block) []editor.Range {
+	var ranges []editor.Range
+	for line := b.top; line <= b.bottom; line++ {
+		start, end := lineBounds(text, line)
+		if start+b.left >= end {
+			continue
+		}
+		right := start + b.right
+		if right > end {
+			right = end
+		}
+		ranges = append(ranges, editor.Range{Start: start + b.left, End: right})
+	}
+	return ranges
+}
+
+// blockOps returns the operations replacing the block's content with value on each of its
+// lines, or deleting the rune before its left column if value is empty and the block has
+// no width. Lines ending before the block's columns are left alone, and lines ending at
+// them are only extended by blocks without width. The operations are
+// grouped by line, from the bottom line up, so each line's positions are unchanged by the
+// operations before them.
+func blockOps(text []rune, b block, value string) [][]commons.Operation {
+	var lines [][]commons.Operation
+	for line := b.bottom; line >= b.top; line-- {
+		start, end := lineBounds(text, line)
+		if start+b.left > end || (b.right > b.left && start+b.left == end) {
+			continue
+		}
+
+		var ops []commons.Operation
+		right := start + b.right
+		if right > end {
+			right = end
+		}
+		for i := right - 1; i >= start+b.left; i-- {
+			ops = append(ops, commons.Operation{Type: "delete", Position: i + 1})
+		}
+		switch {
+		case value != "":
+			ops = append(ops, commons.Operation{Type: "insert", Position: start + b.left + 1, Value: value})
+		case b.left == b.right && b.left > 0:
+			ops = append(ops, commons.Operation{Type: "delete", Position: start + b.left})
+		}
+		if len(ops) > 0 {
+			lines = append(lines, ops)
+		}
+	}
+	return lines
+}
+
+// toggleBlock handles the block selection key. The first press starts a block selection
+// at the cursor, and the second press ends it.
+func toggleBlock() {
+	if blockMode {
+		blockMode = false
+		return
+	}
+	blockMode, selectionMark = true, -1
+	blockLine, blockCol = lineCol(e.GetText(), e.Cursor)
+	e.StatusChan <- "Block selection started, move the cursor to select, and type to edit every line"
+}
+
+// handleBlockKey handles the keys editing the block selection: typed runes replace the
+// block's content on every line, Backspace and Delete delete it, or the rune before it if
+// it has no width, and Esc ends the block selection. Keys starting other edits end it
+// too. It reports whether the key was handled.
+func handleBlockKey(ev *tcell.EventKey, conn *websocket.Conn) bool {
+	switch ev.Key() {
+	case tcell.KeyEsc:
+		blockMode = false
+		return true
+	case tcell.KeyBackspace, tcell.KeyBackspace2, tcell.KeyDelete:
+		editBlock("", conn)
+		return true
+	case tcell.KeyEnter, tcell.KeyTab:
+		blockMode = false
+		return false
+	}
+	if ev.Key() != tcell.KeyRune {
+		return false
+	}
+	if ch, ok := composeRune(ev.Rune()); ok {
+		editBlock(string(ch), conn)
+	}
+	return true
+}
+
+// editBlock replaces the block's content with value, or deletes it, on each of its lines
+// outside of the prompts the user can't edit, and sends the operations to the other
+// clients together. The block keeps its lines, and is left without width after the
+// edit.
+func editBlock(value string, conn *websocket.Conn) {
+	e.FollowCursor()
+	if !isInterviewer() && readOnly {
+		e.StatusChan <- "You don't have edit access"
+		return
+	}
+
+	b := currentBlock()
+	cursorLine, _ := lineCol(e.GetText(), e.Cursor)
+	var ops []commons.Operation
+	skipped := false
+	for _, line := range blockOps(e.GetText(), b, value) {
+		if !blockEditable(line) {
+			skipped = true
+			continue
+		}
+		ops = append(ops, line...)
+	}
+	if skipped {
+		e.StatusChan <- "Prompts are read-only, their lines weren't edited"
+	}
+
+	col := b.left
+	if value != "" {
+		col += utf8.RuneCountInString(value)
+	} else if b.left == b.right && b.left > 0 {
+		col--
+	}
+	applyBatch(ops, conn)
+
+	// Lines shorter than the block's column keep the cursor at their end.
+	start, end := lineBounds(e.GetText(), cursorLine)
+	cursor := start + col
+	if cursor > end {
+		cursor = end
+	}
+	e.SetX(cursor)
+	blockCol = col
+}
+
+// blockEditable reports whether the user can perform a line's operations, which none
+// of the prompts they can't edit cover.
+func blockEditable(ops []commons.Operation) bool {
+	for _, op := range ops {
+		// Inserts before the first character of a prompt go before it, as at the cursor.
+		if inPrompt(op.Position) && (op.Type == "delete" || inPrompt(op.Position-1)) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyBatch performs the operations on the local document, in order, and sends them to
+// the other clients. The editor's text is updated once, after all of them.
+func applyBatch(ops []commons.Operation, conn *websocket.Conn) {
+	if len(ops) == 0 {
+		return
+	}
+
+	applied := 0
+	for i, op := range ops {
+		generated, err := doc.GenerateOperation(op)
+		if err != nil {
+			logger.Errorf("CRDT error: %v\n", err)
+			break
+		}
+		ops[i] = generated
+		applied++
+	}
+	e.SetText(crdt.Content(doc))
+	e.SetDirty(true)
+
+	for _, op := range ops[:applied] {
+		if !e.IsConnected {
+			if flags.Debug {
+				tracker.next()
+			}
+			continue
+		}
+		if err := sendOperation(op, conn); err != nil {
+			e.IsConnected = false
+			e.StatusChan <- "lost connection!"
+		}
+	}
+}
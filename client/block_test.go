@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// TestBlockRanges checks that the block covers the same columns of each of its lines,
+// leaving out the lines which don't reach them.
+func TestBlockRanges(t *testing.T) {
+	text := []rune("abcd\nx\nefgh")
+	got := blockRanges(text, block{top: 0, bottom: 2, left: 1, right: 3})
+	expected := []editor.Range{{Start: 1, End: 3}, {Start: 8, End: 10}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+// TestEditBlock checks that typing in a block replaces its content on every line, and
+// that deleting without width deletes the column before it.
+func TestEditBlock(t *testing.T) {
+	conn := sink(t)
+	readOnly = false
+	setPrompts(nil)
+
+	tests := []struct {
+		text       string
+		mark       [2]int
+		cursor     int
+		values     []string
+		expected   string
+		expectedAt int
+	}{
+		// The block covers "bc" and "fg", and the short line in between is left alone.
+		{"abcd\nx\nefgh", [2]int{0, 1}, 10, []string{"-", "-"}, "a--d\nx\ne--h", 10},
+		{"abc\nabc", [2]int{0, 3}, 7, []string{"", ""}, "a\na", 3},
+		{"ab\ncd", [2]int{0, 0}, 3, []string{"> "}, "> ab\n> cd", 7},
+	}
+
+	for _, tc := range tests {
+		doc, _ = crdt.FromText(tc.text)
+		e = editor.NewEditor(editor.EditorConfig{})
+		e.SetText(crdt.Content(doc))
+		blockMode, blockLine, blockCol = true, tc.mark[0], tc.mark[1]
+		e.SetX(tc.cursor)
+
+		for _, value := range tc.values {
+			editBlock(value, conn)
+		}
+		if got := crdt.Content(doc); got != tc.expected || string(e.GetText()) != tc.expected {
+			t.Errorf("%q: got %q, expected %q", tc.text, got, tc.expected)
+		}
+		if e.Cursor != tc.expectedAt {
+			t.Errorf("%q: got cursor %d, expected %d", tc.text, e.Cursor, tc.expectedAt)
+		}
+	}
+	blockMode = false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/burntcarrot/pairpad/client/plugin"
+)
+
+// cleanupPlugin returns a plugin cleaning the document up on save: with trim set, it
+// removes the spaces and tabs at the end of lines, and with finalNewline set, it ends the
+// document with a newline. The changes are applied as operations, so the other clients get
+// the cleaned up document.
+func cleanupPlugin(trim, finalNewline bool) plugin.Plugin {
+	return plugin.Plugin{
+		Name: "cleanup",
+		OnSave: func(s plugin.Session, fileName string) error {
+			text := s.Text()
+			if cleaned := cleanText(text, trim, finalNewline); cleaned != text {
+				s.SetText(cleaned)
+			}
+			return nil
+		},
+	}
+}
+
+// cleanText returns text without the spaces and tabs at the end of its lines if trim is
+// set, and ending with a newline if finalNewline is set. Empty documents are left empty.
+func cleanText(text string, trim, finalNewline bool) string {
+	if trim {
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(line, " \t")
+		}
+		text = strings.Join(lines, "\n")
+	}
+	if finalNewline && text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+// TestCleanText tests the trimming of trailing whitespace, and the final newline added on
+// save.
+func TestCleanText(t *testing.T) {
+	tests := []struct {
+		text               string
+		trim, finalNewline bool
+		expected           string
+	}{
+		{"a  \nb\t\n", false, false, "a  \nb\t\n"},
+		{"a  \nb\t\n", true, false, "a\nb\n"},
+		{"a \t \n  b\n \n", true, false, "a\n  b\n\n"},
+		{"a \nb ", true, false, "a\nb"},
+		{"a\nb", false, true, "a\nb\n"},
+		{"a\nb\n", false, true, "a\nb\n"},
+		{"a \nb ", true, true, "a\nb\n"},
+		{"", true, true, ""},
+		{"   ", true, true, ""},
+	}
+
+	for _, tc := range tests {
+		if got := cleanText(tc.text, tc.trim, tc.finalNewline); got != tc.expected {
+			t.Errorf("cleanText(%q, %v, %v): got %q, expected %q", tc.text, tc.trim, tc.finalNewline, got, tc.expected)
+		}
+	}
+}
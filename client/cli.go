@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The subcommands are parsed with the flag package, as the flags of the server and the
+// other tools are. CLI frameworks built on POSIX-style flags would read the single-dash
+// flags existing scripts pass, such as -server, as groups of one-letter flags.
+
+// A command is a subcommand of the client, such as "join".
+type command struct {
+	name string
+
+	// args describes the command's arguments, in the usage.
+	args string
+
+	// desc describes what the command does.
+	desc string
+
+	// parse sets flags from the command's arguments.
+	parse func(flags *Flags, args []string) error
+
+	// flags, if set, adds the command's own flags to fs, and returns a function setting them
+	// in flags once fs is parsed.
+	flags func(fs *flag.FlagSet) func(flags *Flags)
+}
+
+// commands holds the client's subcommands. Without one, the client is started with the
+// flags alone, which can also be given to the commands.
+var commands = []command{
+	{
+		name: "join",
+		args: "<server> [room]",
+		desc: "Join a room (the server's default one, if left out) on a server, or one found on the local network with -discover",
+		parse: func(flags *Flags, args []string) error {
+			if flags.Discover {
+				if len(args) > 0 {
+					return errors.New("join -discover takes no arguments")
+				}
+				flags.SessionSet = true
+				return nil
+			}
+			if len(args) < 1 || len(args) > 2 {
+				return errors.New("join takes a server and, optionally, a room")
+			}
+			flags.Server, flags.SessionSet = args[0], true
+			if len(args) == 2 {
+				flags.Room = args[1]
+			}
+			return nil
+		},
+		flags: func(fs *flag.FlagSet) func(flags *Flags) {
+			discover := fs.Bool("discover", false, "List the sessions advertised on the local network, to pick one to join")
+			return func(flags *Flags) { flags.Discover = *discover }
+		},
+	},
+	{
+		name: "host",
+		args: "[room]",
+		desc: "Host a room on a server started by the client, which others on the network can join",
+		parse: func(flags *Flags, args []string) error {
+			if len(args) > 1 {
+				return errors.New("host takes a room, optionally")
+			}
+			if len(args) == 1 {
+				flags.Room = args[0]
+			}
+			flags.SessionSet = true
+			return nil
+		},
+		flags: func(fs *flag.FlagSet) func(flags *Flags) {
+			port := fs.Int("port", defaultHostPort, "The port the server listens on (0 picks a free one)")
+			advertise := fs.Bool("advertise", true, "Advertise the session on the local network with mDNS, for pairpad join -discover")
+			return func(flags *Flags) { flags.HostPort, flags.Advertise = *port, *advertise }
+		},
+	},
+	{
+		name: "open",
+		args: "<file>",
+		desc: "Edit a file, offering to rejoin the session it was last edited in",
+		parse: func(flags *Flags, args []string) error {
+			if len(args) != 1 {
+				return errors.New("open takes a file")
+			}
+			flags.File = args[0]
+			return nil
+		},
+	},
+	{
+		name: "config",
+		desc: "Print the path of the config file, and the settings read from it",
+		parse: func(flags *Flags, args []string) error {
+			if len(args) != 0 {
+				return errors.New("config takes no arguments")
+			}
+			return nil
+		},
+	},
+}
+
+// findCommand returns the subcommand with the given name, if there's one.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// newFlagSet returns a flag set holding the client's flags, and a function returning their
+// values once the set is parsed. Errors and the usage are written to output.
+func newFlagSet(name string, output io.Writer) (*flag.FlagSet, func() Flags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	serverAddr := fs.String("server", "localhost:8080", "The network address of the server")
+	room := fs.String("room", "", "The room (editing session) to join on the server")
+	useSecureConn := fs.Bool("secure", false, "Enable a secure WebSocket connection (wss://)")
+	enableDebug := fs.Bool("debug", false, "Enable debugging mode to show more verbose logs")
+	enableLogin := fs.Bool("login", false, "Enable the login prompt for the server")
+	file := fs.String("file", "", "The file to load the pairpad content from, and save it to (*.pairpad files keep the CRDT state)")
+	enableScroll := fs.Bool("scroll", true, "Enable scrolling with the cursor")
+	configPath := fs.String("config", defaultConfigPath(), "The config file to read settings from")
+	interviewer := fs.String("interviewer", "", "Join as an interviewer, with the server's interviewer token")
+	recordInput := fs.String("record-input", "", "Record the editor's events and messages to a file, for bug reports")
+	replayInput := fs.String("replay-input", "", "Replay a file written by -record-input, without connecting to a server")
+	transcript := fs.String("transcript", "", "Write a Markdown transcript of the session (timeline and final document) to a file on exit")
+	transcriptOps := fs.Bool("transcript-ops", false, "Include the number of operations of each user in the transcript")
+	ignoreVersion := fs.Bool("ignore-version", false, "Connect to servers speaking another version of the protocol, instead of exiting")
+	recent := fs.Bool("recent", false, "List the recently joined sessions and edited files, to pick one to resume")
+	ssh := fs.String("ssh", "", "Connect through an SSH tunnel to this machine (user@host), from which -server is reached")
+	logDir := fs.String("log-dir", "", "The directory to write the logs to, instead of ~/.pairpad")
+	maxMessageSize := fs.Int64("max-message-size", defaultMaxMessageSize, "Maximum size of a message from the server, in bytes, such as a document (0 means no limit)")
+
+	return fs, func() Flags {
+		sessionSet := false
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "server", "room", "secure", "ssh":
+				sessionSet = true
+			}
+		})
+
+		return Flags{
+			Server:         *serverAddr,
+			Room:           *room,
+			Secure:         *useSecureConn,
+			Debug:          *enableDebug,
+			Login:          *enableLogin,
+			File:           *file,
+			Scroll:         *enableScroll,
+			Config:         *configPath,
+			Interviewer:    *interviewer,
+			RecordInput:    *recordInput,
+			ReplayInput:    *replayInput,
+			Transcript:     *transcript,
+			TranscriptOps:  *transcriptOps,
+			IgnoreVersion:  *ignoreVersion,
+			MaxMessageSize: *maxMessageSize,
+			Recent:         *recent,
+			LogDir:         *logDir,
+			SSH:            *ssh,
+			SessionSet:     sessionSet,
+		}
+	}
+}
+
+// parseArgs parses the client's arguments: a subcommand, its arguments and the flags,
+// which may come before, between or after the arguments, or the flags alone. Errors are
+// written to output, with the usage.
+func parseArgs(args []string, output io.Writer) (Flags, error) {
+	name := "pairpad"
+	cmd, isCommand := command{}, false
+	if len(args) > 0 {
+		if cmd, isCommand = findCommand(args[0]); isCommand {
+			name += " " + cmd.name
+			args = args[1:]
+		}
+	}
+
+	fs, parsed := newFlagSet(name, output)
+	setCommandFlags := func(*Flags) {}
+	if isCommand && cmd.flags != nil {
+		setCommandFlags = cmd.flags(fs)
+	}
+	fs.Usage = func() { printUsage(fs, cmd, isCommand) }
+
+	// The flag package stops at the first argument which isn't a flag, so the flags after
+	// it are parsed again.
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return Flags{}, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	flags := parsed()
+	setCommandFlags(&flags)
+	var err error
+	if isCommand {
+		flags.Command = cmd.name
+		err = cmd.parse(&flags, positional)
+	} else if len(positional) > 0 {
+		err = fmt.Errorf("unknown command %q", positional[0])
+	}
+	if err != nil {
+		fmt.Fprintln(output, err)
+		fs.Usage()
+	}
+	return flags, err
+}
+
+// printUsage writes the usage of the client, or of one of its commands, to the flag set's
+// output.
+func printUsage(fs *flag.FlagSet, cmd command, isCommand bool) {
+	out := fs.Output()
+	if isCommand {
+		fmt.Fprintf(out, "Usage: pairpad %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, cmd.desc)
+		fs.PrintDefaults()
+		return
+	}
+
+	fmt.Fprintf(out, "Usage:\n  pairpad [flags]\n")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %s\n", strings.TrimSpace(fmt.Sprintf("pairpad %s [flags] %s", c.name, c.args)))
+	}
+	fmt.Fprintf(out, "\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-8s%s\n", c.name, c.desc)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	fs.PrintDefaults()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+// TestParseArgs checks that subcommands set the flags from their arguments, with flags
+// before or after them, and that the flags alone still work.
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		check   func(Flags) bool
+		wantErr bool
+	}{
+		{[]string{"-server", "pair.example.com", "-room", "team-a"}, func(f Flags) bool {
+			return f.Command == "" && f.Server == "pair.example.com" && f.Room == "team-a" && f.SessionSet
+		}, false},
+		{[]string{}, func(f Flags) bool { return f.Server == "localhost:8080" && !f.SessionSet && f.Scroll }, false},
+		{[]string{"join", "pair.example.com"}, func(f Flags) bool {
+			return f.Command == "join" && f.Server == "pair.example.com" && f.Room == "" && f.SessionSet
+		}, false},
+		{[]string{"join", "-secure", "pair.example.com", "team-a", "-file", "notes.md"}, func(f Flags) bool {
+			return f.Server == "pair.example.com" && f.Room == "team-a" && f.Secure && f.File == "notes.md"
+		}, false},
+		{[]string{"open", "notes.md", "-login"}, func(f Flags) bool {
+			return f.Command == "open" && f.File == "notes.md" && f.Login && !f.SessionSet
+		}, false},
+		{[]string{"host", "-port", "9000", "team-a"}, func(f Flags) bool {
+			return f.Command == "host" && f.HostPort == 9000 && f.Room == "team-a" && f.SessionSet && f.Advertise
+		}, false},
+		{[]string{"host"}, func(f Flags) bool { return f.HostPort == defaultHostPort && f.Room == "" }, false},
+		{[]string{"join", "-port", "9000", "pair.example.com"}, nil, true},
+		{[]string{"join", "-discover"}, func(f Flags) bool { return f.Discover && f.SessionSet }, false},
+		{[]string{"join", "-discover", "pair.example.com"}, nil, true},
+		{[]string{"host", "-advertise=false"}, func(f Flags) bool { return !f.Advertise }, false},
+		{[]string{"config", "-config", "other.toml"}, func(f Flags) bool { return f.Command == "config" && f.Config == "other.toml" }, false},
+		{[]string{"join"}, nil, true},
+		{[]string{"join", "a", "b", "c"}, nil, true},
+		{[]string{"open"}, nil, true},
+		{[]string{"config", "x"}, nil, true},
+		{[]string{"frobnicate"}, nil, true},
+		{[]string{"join", "-nope"}, nil, true},
+	}
+
+	for _, tc := range tests {
+		flags, err := parseArgs(tc.args, io.Discard)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: got error %v, expected an error: %v", tc.args, err, tc.wantErr)
+			continue
+		}
+		if tc.check != nil && !tc.check(flags) {
+			t.Errorf("%q: got unexpected flags %+v", tc.args, flags)
+		}
+	}
+}
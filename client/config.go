@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/gdamore/tcell/v2"
+)
+
+// Config holds the client's settings, read from the config file.
+type Config struct {
+	// ShowWhitespace shows trailing whitespace, tabs and non-breaking spaces with visible glyphs.
+	ShowWhitespace bool `toml:"show_whitespace"`
+
+	// MatchBrackets highlights the bracket matching the one at the cursor.
+	MatchBrackets bool `toml:"match_brackets"`
+
+	// AutoPair inserts the closing bracket or quote after an opening one, and moves the
+	// cursor over the closing one when it's typed.
+	AutoPair bool `toml:"auto_pair"`
+
+	// SpellCheck underlines misspelled words.
+	SpellCheck bool `toml:"spell_check"`
+
+	// Dictionary is the path of the dictionary used to check spelling, a hunspell ".dic"
+	// file or a list of words. The bundled English dictionary is used if it's empty.
+	Dictionary string `toml:"dictionary"`
+
+	// Scrollbar shows a scrollbar at the right edge, with markers for the other users' cursors.
+	Scrollbar bool `toml:"scrollbar"`
+
+	// ScrollOff is the number of lines kept visible above and below the cursor.
+	ScrollOff int `toml:"scroll_off"`
+
+	// HighlightLine highlights the line the cursor is on with a subtle background color.
+	HighlightLine bool `toml:"highlight_line"`
+
+	// Background is the terminal's background, "dark" or "light", for which the colors
+	// are picked. It's "dark" if empty.
+	Background string `toml:"background"`
+
+	// Palette tells users apart: "default", "colorblind" (colors which are told apart
+	// with color blindness) or "monochrome" (symbols instead of colors). It's "default" if
+	// empty.
+	Palette string `toml:"palette"`
+
+	// Colors holds the 24-bit colors used instead of the palette's and the line highlight's.
+	Colors ColorsConfig `toml:"colors"`
+
+	// StatusBar is the layout of the info bar, in which segments are written "{users}",
+	// "{file}", "{position}", "{latency}", "{time}", "{sync}" or "{debug}", such as
+	// "{users}| {file} | {position}". It's editor.DefaultInfoBar if empty.
+	StatusBar string `toml:"status_bar"`
+
+	// MaxFPS is the most times per second the editor is redrawn, however many operations
+	// come in. It's 60 if zero; lower values save CPU, and bandwidth over SSH.
+	MaxFPS int `toml:"max_fps"`
+
+	// Bell rings the terminal bell when another user asks for attention (with Ctrl+T).
+	Bell bool `toml:"bell"`
+
+	// Git enables committing the saved file to its git repository with Ctrl+W, and shows the
+	// repository's branch in the status bar, marked with "*" if the file has uncommitted
+	// changes.
+	Git bool `toml:"git"`
+
+	// FormatOnSave maps file extensions, such as ".go", to the commands formatting the document
+	// on save.
+	FormatOnSave map[string]string `toml:"format_on_save"`
+
+	// TrimTrailingWhitespace removes the spaces and tabs at the end of lines on save.
+	TrimTrailingWhitespace bool `toml:"trim_trailing_whitespace"`
+
+	// EnsureTrailingNewline ends the document with a newline on save, unless it's empty.
+	EnsureTrailingNewline bool `toml:"ensure_trailing_newline"`
+
+	// Backups is the number of previous versions of the file kept when it's saved, as
+	// "notes.txt.~1~" (the latest) to "notes.txt.~N~".
+	Backups int `toml:"backups"`
+
+	// FileMode is the permissions of the files created on save, such as "0600", which the
+	// umask applies to. It's 0644 if zero; existing files keep their permissions.
+	FileMode FileMode `toml:"file_mode"`
+
+	// LogDirMode is the permissions of the directory holding the logs, ~/.pairpad or the
+	// -log-dir one, which is changed to them if it exists. It's created with 0700 if zero.
+	LogDirMode FileMode `toml:"log_dir_mode"`
+
+	// Snippets maps the words which are expanded by Tab to their expansions, in which "$0"
+	// marks where the cursor is placed.
+	Snippets map[string]string `toml:"snippets"`
+
+	// Plugins lists the paths of the Go plugins to load.
+	Plugins []string `toml:"plugins"`
+
+	// Hooks holds the commands run on editor events.
+	Hooks HooksConfig `toml:"hooks"`
+
+	// Connection holds the settings of the connection to the server.
+	Connection ConnectionConfig `toml:"connection"`
+
+	// Log holds the limits of the log files.
+	Log LogConfig `toml:"log"`
+
+	// Telemetry holds the settings of the anonymous usage reports, which are off unless
+	// enabled.
+	Telemetry TelemetryConfig `toml:"telemetry"`
+}
+
+// A FileMode is the permissions of a file or directory, written in octal in the config
+// file, such as "0640".
+type FileMode fs.FileMode
+
+// UnmarshalText parses the permissions from octal.
+func (m *FileMode) UnmarshalText(text []byte) error {
+	n, err := strconv.ParseUint(string(text), 8, 32)
+	if err != nil || n > 0777 {
+		return fmt.Errorf("invalid permissions %q, expected octal ones such as \"0644\"", text)
+	}
+	*m = FileMode(n)
+	return nil
+}
+
+// MarshalText writes the permissions in octal.
+func (m FileMode) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%04o", uint32(m))), nil
+}
+
+// defaultConfigPath returns the path of the config file used if the -config flag isn't
+// set: pairpad/config.toml in the user's config directory.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pairpad", "config.toml")
+}
+
+// loadConfig reads the config file at path. If path is empty, or the file doesn't exist,
+// the default settings are returned.
+func loadConfig(path string) (Config, error) {
+	conf := Config{Connection: defaultConnection, Log: defaultLog}
+	if path == "" {
+		return conf, nil
+	}
+
+	_, err := toml.DecodeFile(path, &conf)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Config{Connection: defaultConnection, Log: defaultLog}, nil
+	}
+	if err != nil {
+		return conf, err
+	}
+
+	switch conf.Background {
+	case "", "dark", "light":
+	default:
+		return conf, fmt.Errorf("background must be \"dark\" or \"light\", not %q", conf.Background)
+	}
+	if err := editor.ParseInfoBar(conf.StatusBar); err != nil {
+		return conf, err
+	}
+	if !editor.ValidPalette(conf.Palette) {
+		return conf, fmt.Errorf("palette must be %q, %q or %q, not %q", editor.PaletteDefault, editor.PaletteColorblind, editor.PaletteMonochrome, conf.Palette)
+	}
+	if err := conf.Colors.validate(); err != nil {
+		return conf, err
+	}
+	if conf.Backups < 0 {
+		return conf, errors.New("backups can't be negative")
+	}
+	if conf.MaxFPS < 0 {
+		return conf, errors.New("max_fps can't be negative")
+	}
+	if err := validateSnippets(conf.Snippets); err != nil {
+		return conf, err
+	}
+	if err := conf.Connection.validate(); err != nil {
+		return conf, err
+	}
+	if err := conf.Log.validate(); err != nil {
+		return conf, err
+	}
+	if err := conf.Telemetry.validate(); err != nil {
+		return conf, err
+	}
+	return conf, nil
+}
+
+// printConfig writes the path of the config file, and the settings read from it, as TOML,
+// to out.
+func printConfig(path string, out io.Writer) error {
+	conf, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	switch _, err := os.Stat(path); {
+	case path == "":
+		fmt.Fprintln(out, "# No config file, the default settings are used.")
+	case errors.Is(err, fs.ErrNotExist):
+		fmt.Fprintf(out, "# %s doesn't exist, the default settings are used.\n", path)
+	default:
+		fmt.Fprintf(out, "# %s\n", path)
+	}
+	return toml.NewEncoder(out).Encode(conf)
+}
+
+// lineHighlight returns the background color of the cursor's line, which stands out a
+// little from the terminal's background, or tcell.ColorDefault if it isn't highlighted.
+func (c Config) lineHighlight() tcell.Color {
+	switch {
+	case !c.HighlightLine:
+		return tcell.ColorDefault
+	case c.Colors.LineHighlight != "":
+		return tcell.GetColor(c.Colors.LineHighlight)
+	case c.Background == "light":
+		return tcell.ColorSilver
+	default:
+		return tcell.ColorBlack
+	}
+}
+
+// ColorsConfig holds 24-bit colors, written "#rrggbb". On terminals without true color,
+// they're shown as the nearest of the colors the terminal has.
+type ColorsConfig struct {
+	// LineHighlight is the background color of the cursor's line, if highlight_line is set.
+	LineHighlight string `toml:"line_highlight"`
+
+	// Users are the colors users are told apart with, instead of the palette's.
+	Users []string `toml:"users"`
+}
+
+// validate checks that the colors are written "#rrggbb".
+func (c ColorsConfig) validate() error {
+	for _, color := range append([]string{c.LineHighlight}, c.Users...) {
+		if color != "" && !validHexColor(color) {
+			return fmt.Errorf("colors must be written \"#rrggbb\", not %q", color)
+		}
+	}
+	return nil
+}
+
+// userColors returns the users' colors, or nil if the palette's are used.
+func (c ColorsConfig) userColors() []tcell.Color {
+	var colors []tcell.Color
+	for _, color := range c.Users {
+		colors = append(colors, tcell.GetColor(color))
+	}
+	return colors
+}
+
+// validHexColor reports whether s is a color written "#rrggbb".
+func validHexColor(s string) bool {
+	if len(s) != 7 || s[0] != '#' {
+		return false
+	}
+	_, err := strconv.ParseUint(s[1:], 16, 32)
+	return err == nil
+}
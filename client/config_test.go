@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// TestLoadConfig tests that settings are read from the config file, that a missing file
+// gives the default settings, and that invalid backgrounds, palettes, colors, status bar
+// layouts, permissions, snippets, connection settings, log limits and telemetry endpoints
+// are rejected.
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	conf, err := loadConfig(filepath.Join(dir, "missing.toml"))
+	if err != nil || conf.lineHighlight() != tcell.ColorDefault {
+		t.Errorf("missing file: got %v, %v, expected the default settings", conf, err)
+	}
+
+	tests := []struct {
+		content  string
+		expected tcell.Color
+		wantErr  bool
+	}{
+		{"scroll_off = 3", tcell.ColorDefault, false},
+		{"highlight_line = true", tcell.ColorBlack, false},
+		{"highlight_line = true\nbackground = \"light\"", tcell.ColorSilver, false},
+		{"highlight_line = true\nbackground = \"blue\"", tcell.ColorDefault, true},
+		{"palette = \"monochrome\"", tcell.ColorDefault, false},
+		{"palette = \"sepia\"", tcell.ColorDefault, true},
+		{"highlight_line = true\n[colors]\nline_highlight = \"#30302e\"\nusers = [\"#e06c75\"]", tcell.NewHexColor(0x30302e), false},
+		{"[colors]\nline_highlight = \"#30302e\"", tcell.ColorDefault, false},
+		{"[colors]\nusers = [\"red\"]", tcell.ColorDefault, true},
+		{"[colors]\nline_highlight = \"#30302\"", tcell.ColorDefault, true},
+		{"status_bar = \"{users}{file} {time}\"", tcell.ColorDefault, false},
+		{"status_bar = \"{clock}\"", tcell.ColorDefault, true},
+		{"backups = 3", tcell.ColorDefault, false},
+		{"backups = -1", tcell.ColorDefault, true},
+		{"max_fps = 30", tcell.ColorDefault, false},
+		{"file_mode = \"0600\"\nlog_dir_mode = \"0750\"", tcell.ColorDefault, false},
+		{"file_mode = \"644\"", tcell.ColorDefault, false},
+		{"file_mode = \"0999\"", tcell.ColorDefault, true},
+		{"log_dir_mode = \"01777\"", tcell.ColorDefault, true},
+		{"max_fps = -1", tcell.ColorDefault, true},
+		{"[snippets]\nfori = \"for i := 0; i < $0; i++ {\\n}\"", tcell.ColorDefault, false},
+		{"[snippets]\n\"two words\" = \"x\"", tcell.ColorDefault, true},
+		{"[connection]\nhandshake_timeout = \"10s\"\nping_interval = \"30s\"", tcell.ColorDefault, false},
+		{"[connection]\nread_timeout = \"-1s\"", tcell.ColorDefault, true},
+		{"[connection]\nretry_delay = \"2m\"", tcell.ColorDefault, true},
+		{"[log]\nmax_size = 1\nmax_files = 0\nmax_age = \"24h\"", tcell.ColorDefault, false},
+		{"[log]\nmax_size = -1", tcell.ColorDefault, true},
+		{"[log]\nmax_age = \"-1h\"", tcell.ColorDefault, true},
+		{"[telemetry]\nenabled = true\nendpoint = \"https://pairpad.test/usage\"", tcell.ColorDefault, false},
+		{"[telemetry]\nenabled = true", tcell.ColorDefault, true},
+		{"[telemetry]\nenabled = true\nendpoint = \"pairpad.test\"", tcell.ColorDefault, true},
+	}
+
+	for _, tc := range tests {
+		path := filepath.Join(dir, "config.toml")
+		if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		conf, err := loadConfig(path)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: got error %v, expected error: %v", tc.content, err, tc.wantErr)
+			continue
+		}
+		if err == nil && conf.lineHighlight() != tc.expected {
+			t.Errorf("%q: got highlight %v, expected %v", tc.content, conf.lineHighlight(), tc.expected)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ConnectionConfig holds the settings of the connection to the server, which can be
+// tuned for flaky or slow networks.
+type ConnectionConfig struct {
+	// HandshakeTimeout is how long opening the connection to the server may take.
+	HandshakeTimeout time.Duration `toml:"handshake_timeout"`
+
+	// ReadTimeout is how long the server may stay silent before the connection is taken
+	// as lost. The server pings clients every 10 seconds, so it should be longer than
+	// that. 0 means no limit.
+	ReadTimeout time.Duration `toml:"read_timeout"`
+
+	// WriteTimeout is how long sending a message to the server may take before the
+	// connection is taken as lost. 0 means no limit.
+	WriteTimeout time.Duration `toml:"write_timeout"`
+
+	// PingInterval is how often the client pings the server, to keep the connection open
+	// through proxies closing idle connections. 0 means never.
+	PingInterval time.Duration `toml:"ping_interval"`
+
+	// JoinRetries is the number of times the client connects again after being turned
+	// away with an error it can retry after, such as a full room.
+	JoinRetries int `toml:"join_retries"`
+
+	// RetryDelay is the time waited before connecting again the first time. It's doubled
+	// for each retry after it, up to MaxRetryDelay.
+	RetryDelay    time.Duration `toml:"retry_delay"`
+	MaxRetryDelay time.Duration `toml:"max_retry_delay"`
+}
+
+// defaultConnection holds the connection settings used unless the config file sets them.
+var defaultConnection = ConnectionConfig{
+	HandshakeTimeout: 2 * time.Minute,
+	ReadTimeout:      time.Minute,
+	WriteTimeout:     10 * time.Second,
+	JoinRetries:      5,
+	RetryDelay:       5 * time.Second,
+	MaxRetryDelay:    time.Minute,
+}
+
+// connection holds the connection settings read from the config file.
+var connection = defaultConnection
+
+// validate checks that the settings make sense.
+func (c ConnectionConfig) validate() error {
+	for name, d := range map[string]time.Duration{
+		"handshake_timeout": c.HandshakeTimeout,
+		"read_timeout":      c.ReadTimeout,
+		"write_timeout":     c.WriteTimeout,
+		"ping_interval":     c.PingInterval,
+		"retry_delay":       c.RetryDelay,
+		"max_retry_delay":   c.MaxRetryDelay,
+	} {
+		if d < 0 {
+			return fmt.Errorf("connection.%s can't be negative", name)
+		}
+	}
+	if c.JoinRetries < 0 {
+		return errors.New("connection.join_retries can't be negative")
+	}
+	if c.MaxRetryDelay < c.RetryDelay {
+		return errors.New("connection.max_retry_delay can't be shorter than connection.retry_delay")
+	}
+	return nil
+}
+
+// retryDelay returns the time waited before the retry with the given number, from 0.
+func (c ConnectionConfig) retryDelay(retry int) time.Duration {
+	delay := c.RetryDelay
+	for i := 0; i < retry && delay < c.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.MaxRetryDelay {
+		delay = c.MaxRetryDelay
+	}
+	return delay
+}
+
+// extendReadDeadline gives the server another ReadTimeout to send something.
+func extendReadDeadline(conn *websocket.Conn) {
+	if connection.ReadTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(connection.ReadTimeout))
+	}
+}
+
+// writeDeadline returns the deadline of a write starting now, or the zero time if writes
+// have no limit.
+func writeDeadline() time.Time {
+	if connection.WriteTimeout == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(connection.WriteTimeout)
+}
+
+// keepAlive keeps the connection alive while the server keeps answering: its pings and
+// pongs push the read deadline back, as its messages do, and the client pings it every
+// PingInterval. It returns the function stopping the pings.
+func keepAlive(conn *websocket.Conn) (stop func()) {
+	extendReadDeadline(conn)
+	conn.SetPingHandler(func(data string) error {
+		extendReadDeadline(conn)
+		// As the default handler, ignore failing to answer, which the next write reports.
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		var netErr net.Error
+		if errors.Is(err, websocket.ErrCloseSent) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return nil
+		}
+		return err
+	})
+	conn.SetPongHandler(func(string) error {
+		extendReadDeadline(conn)
+		return nil
+	})
+
+	done := make(chan struct{})
+	if connection.PingInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(connection.PingInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if err := conn.WriteControl(websocket.PingMessage, nil, writeDeadline()); err != nil {
+						logger.Errorf("failed to ping the server: %v", err)
+					}
+				}
+			}
+		}()
+	}
+	return func() { close(done) }
+}
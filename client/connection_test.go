@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestRetryDelay checks that the delay doubles with each retry, up to the maximum.
+func TestRetryDelay(t *testing.T) {
+	c := ConnectionConfig{RetryDelay: 5 * time.Second, MaxRetryDelay: 30 * time.Second}
+	for retry, expected := range []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second} {
+		if got := c.retryDelay(retry); got != expected {
+			t.Errorf("retry %d: got %s, expected %s", retry, got, expected)
+		}
+	}
+}
+
+// TestLoadConnectionConfig checks that the settings left out of the config file keep
+// their defaults.
+func TestLoadConnectionConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("[connection]\nhandshake_timeout = \"10s\"\nwrite_timeout = \"0s\""), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := defaultConnection
+	expected.HandshakeTimeout, expected.WriteTimeout = 10*time.Second, 0
+	if conf.Connection != expected {
+		t.Errorf("got %+v, expected %+v", conf.Connection, expected)
+	}
+}
+
+// TestKeepAlive checks that the server's pings keep a connection without messages open,
+// and that it's taken as lost once the server goes silent.
+func TestKeepAlive(t *testing.T) {
+	defer func(c ConnectionConfig) { connection = c }(connection)
+	connection.ReadTimeout = 100 * time.Millisecond
+
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go func() {
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		// Ping for longer than the read timeout, then send a message, and go silent.
+		for i := 0; i < 6; i++ {
+			time.Sleep(50 * time.Millisecond)
+			_ = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+		}
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"notice","text":"hello"}`))
+		time.Sleep(time.Second)
+	}))
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stop := keepAlive(conn)
+	defer stop()
+
+	if msg, err := readMessage(conn); err != nil || msg.Text != "hello" {
+		t.Fatalf("got %+v, %v, expected the message sent after the pings", msg, err)
+	}
+	extendReadDeadline(conn)
+	start := time.Now()
+	if _, err := readMessage(conn); err == nil {
+		t.Error("got a message, expected the read to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("got the read failing after %s, expected it after the read timeout", elapsed)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/burntcarrot/pairpad/server/discovery"
+)
+
+// discoverTime is how long join -discover waits for the sessions on the local network to
+// answer.
+const discoverTime = 2 * time.Second
+
+// pickDiscovered looks for the sessions advertised on the local network, and joins the one
+// picked by its number. It reports whether one was picked.
+func pickDiscovered(flags *Flags, in *bufio.Scanner, out io.Writer) (bool, error) {
+	fmt.Fprintln(out, "Looking for sessions on the local network...")
+	ctx, cancel := context.WithTimeout(context.Background(), discoverTime)
+	defer cancel()
+	sessions, err := discovery.Browse(ctx)
+	if err != nil {
+		return false, err
+	}
+	return pickSession(sessions, flags, in, out), nil
+}
+
+// pickSession lists the sessions, and sets flags to join the one picked by its number. It
+// reports whether one was picked.
+func pickSession(sessions []discovery.Session, flags *Flags, in *bufio.Scanner, out io.Writer) bool {
+	if len(sessions) == 0 {
+		fmt.Fprintln(out, "No sessions found on the local network. Join one with pairpad join <server> [room].")
+		return false
+	}
+
+	fmt.Fprintln(out, "Sessions on the local network:")
+	for i, s := range sessions {
+		fmt.Fprintf(out, "%3d. %s\n", i+1, s)
+	}
+	fmt.Fprintf(out, "Join which? [1-%d, Enter to quit] ", len(sessions))
+	in.Scan()
+	n, err := strconv.Atoi(strings.TrimSpace(in.Text()))
+	if err != nil || n < 1 || n > len(sessions) {
+		return false
+	}
+
+	s := sessions[n-1]
+	flags.Server, flags.Room, flags.Secure = s.Addr, s.Room, s.Secure
+	return true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/server/discovery"
+)
+
+// TestPickSession checks that picking a discovered session joins it, and that other
+// answers don't.
+func TestPickSession(t *testing.T) {
+	sessions := []discovery.Session{
+		{Instance: "alice on laptop", Addr: "192.168.1.20:8080", Port: 8080, Room: "team-a"},
+		{Instance: "build", Addr: "192.168.1.30:443", Port: 443, Secure: true},
+	}
+
+	var out strings.Builder
+	flags := Flags{Server: "localhost:8080"}
+	if !pickSession(sessions, &flags, bufio.NewScanner(strings.NewReader("2\n")), &out) {
+		t.Fatal("got no session picked, expected the second one")
+	}
+	expected := "Sessions on the local network:\n  1. room team-a on 192.168.1.20:8080 (alice on laptop)\n  2. the default room on 192.168.1.30:443 (build), over TLS\nJoin which? [1-2, Enter to quit] "
+	if got := out.String(); got != expected {
+		t.Errorf("got listing %q, expected %q", got, expected)
+	}
+	if expected := (Flags{Server: "192.168.1.30:443", Secure: true}); flags != expected {
+		t.Errorf("got %+v, expected %+v", flags, expected)
+	}
+
+	for _, answer := range []string{"\n", "3\n"} {
+		flags := Flags{Server: "localhost:8080"}
+		if pickSession(sessions, &flags, bufio.NewScanner(strings.NewReader(answer)), io.Discard) || flags != (Flags{Server: "localhost:8080"}) {
+			t.Errorf("answering %q: got %+v, expected no session picked", answer, flags)
+		}
+	}
+	if pickSession(nil, &flags, bufio.NewScanner(strings.NewReader("1\n")), io.Discard) {
+		t.Error("got a session picked without sessions")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+var (
+	// showDocStats indicates whether the document statistics overlay is shown.
+	showDocStats bool
+
+	// siteNames holds the names of the users seen in the room, keyed by site ID, so the
+	// characters of users who have left are still attributed to them.
+	siteNames = make(map[string]string)
+)
+
+// refreshDocStats refreshes the document statistics overlay, if it's shown.
+func refreshDocStats() {
+	if !showDocStats {
+		return
+	}
+
+	names := make(map[string]string, len(siteNames)+1)
+	for site, name := range siteNames {
+		names[site] = name
+	}
+	names[strconv.Itoa(crdt.SiteID)] = username
+	e.SetOverlay(docStatsOverlay(crdt.Summarize(doc), names))
+}
+
+// docStatsOverlay returns an overlay displaying the document's statistics, and the share
+// of its characters inserted by each user, whose names are keyed by site ID.
+func docStatsOverlay(s crdt.Summary, names map[string]string) *editor.Overlay {
+	lines := []string{
+		fmt.Sprintf("characters: %d", s.Characters),
+		fmt.Sprintf("words:      %d", s.Words),
+		fmt.Sprintf("lines:      %d", s.Lines),
+		fmt.Sprintf("tombstones: %d", s.Tombstones),
+		fmt.Sprintf("memory:     %s", formatSize(s.Bytes)),
+	}
+	if s.Characters == 0 {
+		return &editor.Overlay{Title: "Document", Lines: lines}
+	}
+
+	sites := make([]int, 0, len(s.BySite))
+	for site := range s.BySite {
+		sites = append(sites, site)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if s.BySite[sites[i]] != s.BySite[sites[j]] {
+			return s.BySite[sites[i]] > s.BySite[sites[j]]
+		}
+		return sites[i] < sites[j]
+	})
+
+	lines = append(lines, "", "contributions:")
+	for _, site := range sites {
+		name, ok := names[strconv.Itoa(site)]
+		if !ok {
+			name = fmt.Sprintf("site %d", site)
+		}
+		lines = append(lines, fmt.Sprintf("  %5.1f%%  %s", float64(s.BySite[site])*100/float64(s.Characters), name))
+	}
+	return &editor.Overlay{Title: "Document", Lines: lines}
+}
+
+// formatSize formats a number of bytes with a binary unit.
+func formatSize(n int) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	size, exp := float64(n)/unit, 0
+	for size >= unit && exp < 2 {
+		size /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", size, "KMG"[exp])
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// TestDocStatsOverlay checks that contributions are listed by share, with the users'
+// names, or their site IDs once they're unknown.
+func TestDocStatsOverlay(t *testing.T) {
+	s := crdt.Summary{Characters: 8, Words: 2, Lines: 1, Tombstones: 3, BySite: map[int]int{1: 2, 2: 6}, Bytes: 2048}
+	got := docStatsOverlay(s, map[string]string{"2": "alice"}).Lines
+	expected := []string{
+		"characters: 8",
+		"words:      2",
+		"lines:      1",
+		"tombstones: 3",
+		"memory:     2.0 KiB",
+		"",
+		"contributions:",
+		"   75.0%  alice",
+		"   25.0%  site 1",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+// TestFormatSize checks the units of the sizes shown.
+func TestFormatSize(t *testing.T) {
+	for n, expected := range map[int]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 5 << 20: "5.0 MiB", 3 << 30: "3.0 GiB"} {
+		if got := formatSize(n); got != expected {
+			t.Errorf("formatSize(%d): got %q, expected %q", n, got, expected)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package editor
+
+// brackets maps each opening and closing bracket to its partner.
+var brackets = map[rune]rune{
+	'(': ')', ')': '(',
+	'[': ']', ']': '[',
+	'{': '}', '}': '{',
+}
+
+// isOpening reports whether r is an opening bracket.
+func isOpening(r rune) bool {
+	return r == '(' || r == '[' || r == '{'
+}
+
+// matchBracket returns the index of the bracket matching the one at index in text, taking
+// nesting into account. It returns -1 if there's no bracket at index, or if it isn't matched.
+func matchBracket(text []rune, index int) int {
+	if index < 0 || index >= len(text) {
+		return -1
+	}
+
+	open := text[index]
+	partner, ok := brackets[open]
+	if !ok {
+		return -1
+	}
+
+	step := 1
+	if !isOpening(open) {
+		step = -1
+	}
+
+	depth := 0
+	for i := index; i >= 0 && i < len(text); i += step {
+		switch text[i] {
+		case open:
+			depth++
+		case partner:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// bracketPair returns the indexes of the bracket at the cursor and of its match. The
+// character after the cursor is checked first, then the one before it. Both indexes are
+// -1 if the cursor isn't next to a matched bracket.
+func (e *Editor) bracketPair(cursor int) (bracket, match int) {
+	text := e.GetText()
+
+	for _, i := range []int{cursor, cursor - 1} {
+		if m := matchBracket(text, i); m >= 0 {
+			return i, m
+		}
+	}
+
+	return -1, -1
+}
@@ -0,0 +1,210 @@
+package editor
+
+import (
+	"math"
+	"sort"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// A damage holds the lines of the text which changed since the editor was last drawn, so
+// that Draw only draws their rows again.
+type damage struct {
+	// lines holds the ranges of changed lines, counted from 0.
+	lines []lineRange
+}
+
+// A lineRange is a range of lines, from start up to, but not including, end.
+type lineRange struct {
+	start, end int
+}
+
+// add marks the lines from start up to, but not including, end as changed.
+func (d *damage) add(start, end int) {
+	if start < end {
+		d.lines = append(d.lines, lineRange{start, end})
+	}
+}
+
+// contains reports whether line changed. Every line of a nil damage changed.
+func (d *damage) contains(line int) bool {
+	if d == nil {
+		return true
+	}
+	for _, r := range d.lines {
+		if line >= r.start && line < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// changedLines returns the lines of next which differ from those of prev, from start up to,
+// but not including, end. When lines were added or removed, the lines after them moved,
+// so they're all changed.
+func changedLines(prev, next []rune) (start, end int) {
+	p := 0
+	for p < len(prev) && p < len(next) && prev[p] == next[p] {
+		p++
+	}
+	if p == len(prev) && p == len(next) {
+		return 0, 0
+	}
+	s := 0
+	for s < len(prev)-p && s < len(next)-p && prev[len(prev)-1-s] == next[len(next)-1-s] {
+		s++
+	}
+
+	start = countLines(next[:p]) - 1
+	removed, added := countLines(prev[p:len(prev)-s]), countLines(next[p:len(next)-s])
+	if removed != added {
+		return start, math.MaxInt
+	}
+	return start, start + added
+}
+
+// countLines returns the number of lines text spans, which is one more than its newlines.
+func countLines(text []rune) int {
+	n := 1
+	for _, r := range text {
+		if r == '\n' {
+			n++
+		}
+	}
+	return n
+}
+
+// A frame holds what drawing the whole editor depends on, apart from the text and its
+// decorations. The editor is drawn again entirely when it changes.
+type frame struct {
+	width, height  int
+	rowOff, colOff int
+	split          bool
+	overlay        *Overlay
+	showWhitespace bool
+	scrollbar      bool
+	lineHighlight  tcell.Color
+
+	// termWidth and termHeight are the size of the screen's buffers, which are cleared
+	// when the terminal is resized, before the editor is told.
+	termWidth, termHeight int
+}
+
+// currentFrame returns the editor's frame, as of now.
+func (e *Editor) currentFrame() frame {
+	var termWidth, termHeight int
+	if e.screen != nil {
+		termWidth, termHeight = e.screen.Size()
+	}
+	e.StatusMu.Lock()
+	overlay := e.overlay
+	e.StatusMu.Unlock()
+	return frame{
+		width:          e.Width,
+		height:         e.Height,
+		rowOff:         e.GetRowOff(),
+		colOff:         e.GetColOff(),
+		split:          e.split,
+		overlay:        overlay,
+		showWhitespace: e.ShowWhitespace,
+		scrollbar:      e.Scrollbar,
+		lineHighlight:  e.LineHighlight,
+		termWidth:      termWidth,
+		termHeight:     termHeight,
+	}
+}
+
+// Kinds of decorations drawn over the text.
+const (
+	markLine = iota
+	markBracket
+	markSelection
+	markHighlight
+	markTypo
+)
+
+// A mark is a decoration drawn over a part of a line, from the rune start up to, but not
+// including, end, counted from the start of the line.
+type mark struct {
+	kind       int
+	start, end int
+
+	// color is the color of a selection's user.
+	color tcell.Color
+}
+
+// lineMarks returns the decorations of the lines of c's text from first up to, but not
+// including, last, whose ranges are relative to each line's start.
+func (e *Editor) lineMarks(c *paneContent, first, last int) [][]mark {
+	marks := make([][]mark, last-first)
+
+	// starts holds the starts of the lines, and of the line after them.
+	var starts []int
+	line := 0
+	if first == 0 {
+		starts = append(starts, 0)
+	}
+	for i, r := range c.text {
+		if line >= last {
+			break
+		}
+		if r == '\n' {
+			line++
+			if line >= first {
+				starts = append(starts, i+1)
+			}
+		}
+	}
+	for len(starts) < last-first+1 {
+		starts = append(starts, len(c.text)+1)
+	}
+
+	// add marks the parts of the lines covered by the runes from start up to end.
+	add := func(kind, start, end int, color tcell.Color) {
+		i := sort.Search(last-first, func(i int) bool { return starts[i+1] > start })
+		for ; i < last-first && starts[i] < end; i++ {
+			// Ranges spanning lines are cut at their ends, so a line's marks only change
+			// with its text.
+			from, to := start-starts[i], end-starts[i]
+			if from < 0 {
+				from = 0
+			}
+			if lineEnd := starts[i+1] - starts[i]; to > lineEnd {
+				to = lineEnd
+			}
+			marks[i] = append(marks[i], mark{kind: kind, start: from, end: to, color: color})
+		}
+	}
+
+	if c.line >= first && c.line < last {
+		marks[c.line-first] = append(marks[c.line-first], mark{kind: markLine})
+	}
+	for _, i := range []int{c.bracket, c.match} {
+		if i >= 0 {
+			add(markBracket, i, i+1, 0)
+		}
+	}
+	for _, sel := range c.selections {
+		add(markSelection, sel.Start, sel.End, e.UserColor(sel.User))
+	}
+	for _, r := range c.highlights {
+		add(markHighlight, r.Start, r.End, 0)
+	}
+	for _, r := range c.typos {
+		add(markTypo, r.Start, r.End, 0)
+	}
+	return marks
+}
+
+// sameMarks reports whether a and b are the same decorations, in the same order.
+func sameMarks(a, b []mark) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,23 @@
+// Package editor is the text editor widget of pairpad's terminal client, drawn with tcell
+// on the screen set by EditorConfig.Screen. It doesn't know what it edits, or how the text
+// is shared: the application applies its edits to its own model and sets the resulting
+// text with SetText, so the package can be used by other tcell applications.
+//
+// An Editor is made of:
+//
+//   - the text, as runes, and the cursor, an index into the text. MoveCursor moves it by
+//     grapheme clusters horizontally, and by lines vertically; MoveCursorRunes moves it
+//     over the runes inserted or deleted before it.
+//   - the viewport, the part of the text shown in the window, scrolled to keep the cursor
+//     visible. Its size is set with SetSize or Resize, as the terminal is resized.
+//   - the status bar, on the last row, showing the messages sent to StatusChan, a Prompt
+//     asking a question, or else the info bar: the users, the file name and its dirty
+//     flag, and the cursor position.
+//   - decorations drawn over the text: highlighted ranges, the users' selections, matching
+//     brackets, misspelled words, visible whitespace and an Overlay.
+//
+// Drawing happens in DrawLoop, which draws the editor when SendDraw is called, so that the
+// editor can be changed from several goroutines while it's drawn from one. The draws
+// requested in quick succession are coalesced, up to EditorConfig.MaxFPS draws per second.
+// StatusLoop times the status messages.
+package editor
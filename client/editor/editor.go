@@ -3,13 +3,62 @@ package editor
 import (
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/gdamore/tcell/v2"
 	"github.com/mattn/go-runewidth"
-	"github.com/nsf/termbox-go"
 )
 
+// EditorConfig holds the settings of an editor.
 type EditorConfig struct {
+	// Screen is the terminal screen the editor is drawn on, which the application
+	// initializes and reads events from. Nothing is drawn if it's nil.
+	Screen tcell.Screen
+
 	ScrollEnabled bool
+
+	// StatusDuration is how long each status message is shown. Zero means 3 seconds.
+	StatusDuration time.Duration
+
+	// OnStatus, if not nil, is called with each status message as it's shown, for example
+	// to log it.
+	OnStatus func(msg string)
+
+	// ShowWhitespace draws trailing whitespace, tabs and non-breaking spaces with visible glyphs.
+	ShowWhitespace bool
+
+	// MatchBrackets highlights the bracket matching the one at the cursor.
+	MatchBrackets bool
+
+	// SpellCheck underlines the words it doesn't know, if it isn't nil.
+	SpellCheck SpellChecker
+
+	// Scrollbar draws a scrollbar in the rightmost column, showing the part of the
+	// document in the window and the other users' cursors.
+	Scrollbar bool
+
+	// ScrollOff is the number of lines kept in the window above and below the cursor as it
+	// moves, when the editor scrolls.
+	ScrollOff int
+
+	// LineHighlight is the background color of the line the cursor is on. The line isn't
+	// highlighted if it's tcell.ColorDefault.
+	LineHighlight tcell.Color
+
+	// Palette is the name of the palette telling users apart, such as PaletteColorblind.
+	// It's PaletteDefault if empty.
+	Palette string
+
+	// UserColors, if not empty, are the colors users are displayed in instead of the
+	// palette's, such as 24-bit colors. They're ignored with PaletteMonochrome.
+	UserColors []tcell.Color
+
+	// InfoBar is the layout of the info bar (see ParseInfoBar). It's DefaultInfoBar if
+	// empty or invalid.
+	InfoBar string
+
+	// MaxFPS is the most times per second DrawLoop draws the editor. Zero means 60.
+	MaxFPS int
 }
 
 // Editor represents the editor's skeleton.
@@ -47,42 +96,247 @@ type Editor struct {
 	// StatusMu protects against concurrent reads and writes to status bar info.
 	StatusMu sync.Mutex
 
-	// Users holds the names of all users connected to the server, displayed in the status bar.
-	Users []string
+	// Users holds all users connected to the server, displayed in the status bar.
+	Users []User
 
 	// ScrollEnabled determines whether or not the user can scroll past the initial editor
 	// window. It is set by the EditorConfig.
 	ScrollEnabled bool
 
+	// ShowWhitespace determines whether trailing whitespace, tabs and non-breaking spaces are
+	// drawn with visible glyphs. It is set by the EditorConfig.
+	ShowWhitespace bool
+
+	// MatchBrackets determines whether the bracket matching the one at the cursor is
+	// highlighted. It is set by the EditorConfig.
+	MatchBrackets bool
+
+	// SpellCheck is used to underline misspelled words, if it isn't nil. It is set by the
+	// EditorConfig.
+	SpellCheck SpellChecker
+
+	// Scrollbar determines whether the scrollbar is drawn in the rightmost column. It is set
+	// by the EditorConfig.
+	Scrollbar bool
+
+	// ScrollOff is the number of lines kept in the window above and below the cursor. It
+	// is set by the EditorConfig.
+	ScrollOff int
+
+	// LineHighlight is the background color of the cursor's line, if it isn't
+	// tcell.ColorDefault. It is set by the EditorConfig.
+	LineHighlight tcell.Color
+
 	// IsConnected shows whether the editor is currently connected to the server.
 	IsConnected bool
 
 	// DrawChan is used to send and receive signals to update the terminal display.
 	DrawChan chan int
 
+	// FileName is the name of the file the document is saved to, shown in the status bar.
+	// It's protected by StatusMu.
+	FileName string
+
+	// gitStatus describes the state of the file's git repository, shown after the file name.
+	// It's protected by StatusMu.
+	gitStatus string
+
+	// fileFormat describes the file's line endings and encoding, shown after the file name.
+	// It's protected by StatusMu.
+	fileFormat string
+
+	// dirty is set when the document has changed since it was last saved or loaded. It's
+	// protected by StatusMu.
+	dirty bool
+
+	// highlights holds the ranges of the text which are underlined, for example, annotated
+	// text. It's protected by StatusMu.
+	highlights []Range
+
+	// selections holds the users' selections, drawn with the users' colors as background.
+	// It's protected by StatusMu.
+	selections []Selection
+
+	// cursors holds the other users' cursors, marked on the scrollbar. It's protected by
+	// StatusMu.
+	cursors []Cursor
+
+	// syncStatus describes the state of the last local operation, shown in the info bar in
+	// debugging mode. It's protected by StatusMu.
+	syncStatus string
+
+	// prompt is the question shown in the status bar, if any. It's protected by StatusMu.
+	prompt *Prompt
+
+	// overlay is drawn over the text area if it isn't nil. It's protected by StatusMu.
+	overlay *Overlay
+
+	// split is set when the text area is split into two panes, one above the other. The
+	// focused pane uses RowOff, ColOff and Cursor; other holds the other pane's, and
+	// splitTop is set when the focused pane is the top one.
+	split    bool
+	splitTop bool
+	other    pane
+
+	// detached is set when the focused pane was scrolled away from the cursor with
+	// ScrollView, so it doesn't follow the cursor until FollowCursor is called.
+	detached bool
+
+	// statusDuration is how long each status message is shown, and onStatus is called
+	// with each one. They're set by the EditorConfig.
+	statusDuration time.Duration
+	onStatus       func(msg string)
+
+	// screen is the screen the editor is drawn on, set by the EditorConfig.
+	screen tcell.Screen
+
+	// palette holds the colors users are displayed in, unless monochrome is set. They're
+	// set by the EditorConfig.
+	palette    []tcell.Color
+	monochrome bool
+
+	// infoBar holds the items of the info bar's layout, set by the EditorConfig.
+	infoBar []infoBarItem
+
+	// frameInterval is the shortest time between two draws of DrawLoop, set by the
+	// EditorConfig's MaxFPS.
+	frameInterval time.Duration
+
+	// latency is the round-trip time to the server, shown in the info bar. It's protected
+	// by StatusMu.
+	latency time.Duration
+
+	// damage holds the lines of the text changed since the editor was last drawn. It's
+	// protected by mu.
+	damage damage
+
+	// drawn is the frame the editor was last drawn in, and drawnMarks the decorations of
+	// the lines it showed, to find the lines to draw again. They're only used by Draw.
+	drawn      *frame
+	drawnMarks [][]mark
+
+	// draws counts the times the editor was drawn, and drawTime adds up the time drawing
+	// took. They're protected by StatusMu.
+	draws    int
+	drawTime time.Duration
+
 	// mu prevents concurrent reads and writes to the editor state.
 	mu sync.RWMutex
 }
 
-var userColors = []termbox.Attribute{
-	termbox.ColorGreen,
-	termbox.ColorYellow,
-	termbox.ColorBlue,
-	termbox.ColorMagenta,
-	termbox.ColorCyan,
-	termbox.ColorLightYellow,
-	termbox.ColorLightMagenta,
-	termbox.ColorLightGreen,
-	termbox.ColorLightRed,
-	termbox.ColorRed,
+// A User is a user connected to the editing session.
+type User struct {
+	// Name is the user's name.
+	Name string
+
+	// Color is the index of the user's color in the editor's palette. It's assigned by the
+	// server, and wraps around if there are more users than colors.
+	Color int
+}
+
+// A Range is a range of the editor's text, from the rune at index Start up to, but not
+// including, the rune at index End.
+type Range struct {
+	Start, End int
+}
+
+// inRanges reports whether the rune at index is in one of the ranges.
+func inRanges(ranges []Range, index int) bool {
+	for _, r := range ranges {
+		if index >= r.Start && index < r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHighlights sets the ranges of the text which are underlined.
+func (e *Editor) SetHighlights(ranges []Range) {
+	e.StatusMu.Lock()
+	e.highlights = ranges
+	e.StatusMu.Unlock()
+}
+
+// A Selection is a range of the text selected by a user.
+type Selection struct {
+	Range
+
+	// User is the user who selected the range.
+	User User
+}
+
+// SetSelections sets the users' selections, including the local user's.
+func (e *Editor) SetSelections(selections []Selection) {
+	e.StatusMu.Lock()
+	e.selections = selections
+	e.StatusMu.Unlock()
+}
+
+// selectionAt returns the selection containing the rune at index, if any. Later selections
+// are drawn over earlier ones.
+func selectionAt(selections []Selection, index int) (Selection, bool) {
+	for i := len(selections) - 1; i >= 0; i-- {
+		if r := selections[i].Range; index >= r.Start && index < r.End {
+			return selections[i], true
+		}
+	}
+	return Selection{}, false
+}
+
+// An Overlay is a box of text drawn over the top right corner of the text area. It's
+// used to display information, like debugging counters, without changing the document.
+type Overlay struct {
+	// Title is drawn in the overlay's top border.
+	Title string
+
+	// Lines holds the overlay's content.
+	Lines []string
+
+	// Colors holds the colors of the lines at the same indexes. Lines without one are drawn
+	// in the default color.
+	Colors []tcell.Color
 }
 
 // NewEditor returns a new instance of the editor.
 func NewEditor(conf EditorConfig) *Editor {
+	statusDuration := conf.StatusDuration
+	if statusDuration == 0 {
+		statusDuration = defaultStatusDuration
+	}
+
+	palette, ok := palettes[conf.Palette]
+	if !ok {
+		palette = palettes[PaletteDefault]
+	}
+	if len(conf.UserColors) > 0 {
+		palette = conf.UserColors
+	}
+	infoBar, err := parseInfoBar(conf.InfoBar)
+	if err != nil || conf.InfoBar == "" {
+		infoBar, _ = parseInfoBar(DefaultInfoBar)
+	}
+	maxFPS := conf.MaxFPS
+	if maxFPS <= 0 {
+		maxFPS = defaultMaxFPS
+	}
+
 	return &Editor{
-		ScrollEnabled: conf.ScrollEnabled,
-		StatusChan:    make(chan string, 100),
-		DrawChan:      make(chan int, 10000),
+		ScrollEnabled:  conf.ScrollEnabled,
+		ShowWhitespace: conf.ShowWhitespace,
+		MatchBrackets:  conf.MatchBrackets,
+		SpellCheck:     conf.SpellCheck,
+		Scrollbar:      conf.Scrollbar,
+		ScrollOff:      conf.ScrollOff,
+		LineHighlight:  conf.LineHighlight,
+		statusDuration: statusDuration,
+		onStatus:       conf.OnStatus,
+		screen:         conf.Screen,
+		palette:        palette,
+		monochrome:     conf.Palette == PaletteMonochrome,
+		infoBar:        infoBar,
+		frameInterval:  time.Second / time.Duration(maxFPS),
+		StatusChan:     make(chan string, 100),
+		DrawChan:       make(chan int, 10000),
 	}
 }
 
@@ -95,8 +349,10 @@ func (e *Editor) GetText() []rune {
 
 // SetText sets the given string as the editor's content.
 func (e *Editor) SetText(text string) {
+	next := []rune(text)
 	e.mu.Lock()
-	e.Text = []rune(text)
+	e.damage.add(changedLines(e.Text, next))
+	e.Text = next
 	e.mu.Unlock()
 }
 
@@ -133,6 +389,23 @@ func (e *Editor) SetSize(w, h int) {
 	e.Height = h
 }
 
+// Resize sets the editor size after the terminal has been resized, and moves the editor
+// window so that the cursor stays visible.
+func (e *Editor) Resize(w, h int) {
+	e.SetSize(w, h)
+
+	if e.RowOff < 0 {
+		e.RowOff = 0
+	}
+	if e.ColOff < 0 {
+		e.ColOff = 0
+	}
+
+	if e.ScrollEnabled {
+		e.scroll(e.calcXY(e.Cursor))
+	}
+}
+
 // GetRowOff returns the vertical offset of the editor window from the start of the text.
 func (e *Editor) GetRowOff() int {
 	return e.RowOff
@@ -155,19 +428,94 @@ func (e *Editor) IncColOff(inc int) {
 	e.ColOff += inc
 }
 
+// SetOverlay sets the overlay drawn over the text area. Setting a nil overlay hides it.
+func (e *Editor) SetOverlay(o *Overlay) {
+	e.StatusMu.Lock()
+	e.overlay = o
+	e.StatusMu.Unlock()
+}
+
+// OverlayVisible reports whether an overlay is currently drawn over the text area.
+func (e *Editor) OverlayVisible() bool {
+	e.StatusMu.Lock()
+	defer e.StatusMu.Unlock()
+	return e.overlay != nil
+}
+
+// SetFileName sets the name of the file shown in the status bar.
+func (e *Editor) SetFileName(name string) {
+	e.StatusMu.Lock()
+	e.FileName = name
+	e.StatusMu.Unlock()
+}
+
+// SetGitStatus sets the description of the state of the file's git repository, such as
+// its branch, shown in the info bar after the file name.
+func (e *Editor) SetGitStatus(status string) {
+	e.StatusMu.Lock()
+	e.gitStatus = status
+	e.StatusMu.Unlock()
+}
+
+// SetFileFormat sets the description of the file's line endings and encoding, such as
+// "LF UTF-8", shown in the info bar after the file name.
+func (e *Editor) SetFileFormat(format string) {
+	e.StatusMu.Lock()
+	e.fileFormat = format
+	e.StatusMu.Unlock()
+}
+
+// SetSyncStatus sets the description of the state of the last local operation, shown in
+// the info bar.
+func (e *Editor) SetSyncStatus(status string) {
+	e.StatusMu.Lock()
+	e.syncStatus = status
+	e.StatusMu.Unlock()
+}
+
+// SetDirty marks the document as changed (or unchanged) since it was last saved.
+func (e *Editor) SetDirty(dirty bool) {
+	e.StatusMu.Lock()
+	e.dirty = dirty
+	e.StatusMu.Unlock()
+}
+
+// IsDirty reports whether the document has unsaved changes.
+func (e *Editor) IsDirty() bool {
+	e.StatusMu.Lock()
+	defer e.StatusMu.Unlock()
+	return e.dirty
+}
+
 // SendDraw sends a draw signal to the drawLoop. Use this function to
 // ensure concurrency safety for rendering the editor.
 func (e *Editor) SendDraw() {
 	e.DrawChan <- 1
 }
 
+// DrawStats returns the number of times the editor was drawn, and the total time drawing
+// took, to measure its frame time.
+func (e *Editor) DrawStats() (draws int, total time.Duration) {
+	e.StatusMu.Lock()
+	defer e.StatusMu.Unlock()
+	return e.draws, e.drawTime
+}
+
 // Draw updates the UI by setting cells with the editor's content.
+//
+// Only the rows of the lines whose text or decorations (such as selections, or the
+// cursor's line) changed since the last draw are drawn again, with the status bar and the
+// scrollbar, unless the editor was scrolled, resized, split or its overlay changed. Then
+// the screen only writes the cells which changed to the terminal.
 func (e *Editor) Draw() {
-	_ = termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	start := time.Now()
+	f := e.currentFrame()
 
-	e.mu.RLock()
+	e.mu.Lock()
 	cursor := e.Cursor
-	e.mu.RUnlock()
+	changed := e.damage
+	e.damage = damage{}
+	e.mu.Unlock()
 
 	cx, cy := e.calcXY(cursor)
 
@@ -181,100 +529,370 @@ func (e *Editor) Draw() {
 		cy -= e.GetRowOff()
 	}
 
-	termbox.SetCursor(cx-1, cy-1)
+	top, rows := e.paneRows(true)
+	if e.screen != nil {
+		if e.cursorVisible() {
+			e.screen.ShowCursor(cx-1, top+cy-1)
+		} else {
+			e.screen.HideCursor()
+		}
+	}
 
-	// find the starting and ending row of the termbox window.
-	yStart := e.GetRowOff()
-	yEnd := yStart + e.GetHeight() - 1 // -1 accounts for the status bar
+	text := e.GetText()
+	c := paneContent{text: text, bounds: graphemeBounds(text), bracket: -1, match: -1, line: -1}
 
-	// find the starting ending column of the termbox window.
-	xStart := e.GetColOff()
+	if e.LineHighlight != tcell.ColorDefault {
+		_, y := e.calcXY(cursor)
+		c.line, c.lineBg = y-1, e.LineHighlight
+	}
+
+	if e.ShowWhitespace {
+		c.trailing = trailingWhitespace(text, c.bounds)
+	}
+
+	// bracket and match are the indexes of the bracket pair to highlight, if any.
+	if e.MatchBrackets {
+		c.bracket, c.match = e.bracketPair(cursor)
+	}
+
+	if e.SpellCheck != nil {
+		c.typos = misspelled(text, cursor, e.SpellCheck)
+	}
+
+	e.StatusMu.Lock()
+	c.highlights = e.highlights
+	c.selections = e.selections
+	e.StatusMu.Unlock()
+
+	// only holds the lines drawn again, or is nil if the whole editor is. Split panes are
+	// always drawn entirely.
+	var only *damage
+	marks := e.lineMarks(&c, f.rowOff, f.rowOff+rows)
+	if e.drawn != nil && *e.drawn == f && !f.split {
+		only = &changed
+		for i := range marks {
+			if !sameMarks(marks[i], e.drawnMarks[i]) {
+				only.add(f.rowOff+i, f.rowOff+i+1)
+			}
+		}
+	}
+	e.drawn, e.drawnMarks = &f, marks
+
+	if only == nil {
+		if e.screen != nil {
+			e.screen.Clear()
+		}
+	} else {
+		e.clearRows(only, top, rows, f.rowOff)
+	}
+
+	e.drawPane(&c, top, rows, f.rowOff, f.colOff, only)
+	if e.split {
+		// The cursor's line is only highlighted in the focused pane.
+		other := c
+		other.line = -1
+		otherTop, otherRows := e.paneRows(false)
+		e.drawPane(&other, otherTop, otherRows, e.other.rowOff, e.other.colOff, nil)
+		e.drawDivider()
+	}
+
+	if e.Scrollbar {
+		e.drawScrollbar(text)
+	}
+
+	e.DrawOverlay()
+
+	e.DrawStatusBar()
+
+	// Show the changed cells!
+	if e.screen != nil {
+		e.screen.Show()
+	}
+
+	e.StatusMu.Lock()
+	e.draws++
+	e.drawTime += time.Since(start)
+	e.StatusMu.Unlock()
+}
+
+// paneContent holds the text drawn in the panes, and the ranges of it which are drawn
+// differently.
+type paneContent struct {
+	text     []rune
+	bounds   []int
+	trailing []bool
+
+	// bracket and match are the indexes of the bracket pair to highlight, or -1.
+	bracket, match int
+
+	typos, highlights []Range
+	selections        []Selection
+
+	// line is the line highlighted with the background color lineBg, counted from 0, or
+	// -1.
+	line   int
+	lineBg tcell.Color
+}
+
+// setCell draws r, with the given style, in the cell at column x and row y of the screen,
+// if the editor has one.
+func (e *Editor) setCell(x, y int, r rune, style tcell.Style) {
+	e.setContent(x, y, r, nil, style)
+}
+
+// setContent draws a grapheme cluster, made of primary and the runes combining with it, in
+// the cell at column x and row y of the screen, if the editor has one.
+func (e *Editor) setContent(x, y int, primary rune, combining []rune, style tcell.Style) {
+	if e.screen != nil {
+		e.screen.SetContent(x, y, primary, combining, style)
+	}
+}
+
+// clearRows clears the rows of the lines of the pane drawn again, in rows rows of the screen
+// from top, scrolled by rowOff rows, and the status bar.
+func (e *Editor) clearRows(only *damage, top, rows, rowOff int) {
+	for row := 0; row < rows; row++ {
+		if !only.contains(rowOff + row) {
+			continue
+		}
+		for x := 0; x < e.textWidth(); x++ {
+			e.setCell(x, top+row, ' ', tcell.StyleDefault)
+		}
+	}
+	for x := 0; x < e.Width; x++ {
+		e.setCell(x, e.Height-1, ' ', tcell.StyleDefault)
+	}
+}
+
+// drawPane draws the text in rows rows of the screen from top, scrolled by rowOff rows and
+// colOff columns. Only the lines in only are drawn, unless it's nil.
+func (e *Editor) drawPane(c *paneContent, top, rows, rowOff, colOff int, only *damage) {
+	yEnd := rowOff + rows
+	typos := c.typos
+
+	// The highlighted line is filled up to the edge of the text area, past its end.
+	if c.line >= rowOff && c.line < yEnd && only.contains(c.line) {
+		for x := 0; x < e.textWidth(); x++ {
+			e.setCell(x, top+c.line-rowOff, ' ', tcell.StyleDefault.Background(c.lineBg))
+		}
+	}
+
+	// left and right are set when the current line has content hidden past the left or
+	// right edge of the window.
+	left, right := false, false
 
 	x, y := 0, 0
-	for i := 0; i < len(e.Text) && y < yEnd; i++ {
-		if e.Text[i] == rune('\n') {
+	drawn := only.contains(y)
+	for i := 0; i < len(c.bounds)-1 && y < yEnd; i++ {
+		cluster := c.text[c.bounds[i]:c.bounds[i+1]]
+		if cluster[0] == rune('\n') {
+			if drawn {
+				e.drawScrollMarkers(top, y-rowOff, left, right)
+			}
+			left, right = false, false
 			x = 0
 			y++
+			drawn = only.contains(y)
 		} else {
-			// Set cell content. setX and setY account for the window offset.
-			setY := y - yStart
-			setX := x - xStart
-			termbox.SetCell(setX, setY, e.Text[i], termbox.ColorDefault, termbox.ColorDefault)
+			// Set cell content. setX and setY account for the window offset. The runes of
+			// the cluster after the first one are drawn combined with it.
+			setY := top + y - rowOff
+			setX := x - colOff
+			width := clusterWidth(cluster)
+			switch {
+			case width == 0, y < rowOff, !drawn:
+			case setX < 0:
+				left = true
+			case setX+width > e.textWidth():
+				right = true
+			default:
+				ch, combining, style := cluster[0], cluster[1:], tcell.StyleDefault
+				if y == c.line {
+					style = style.Background(c.lineBg)
+				}
+				if e.ShowWhitespace {
+					if glyph, fg, ok := whitespaceGlyph(cluster, c.trailing[i]); ok {
+						ch, combining, style = glyph, nil, style.Foreground(fg)
+					}
+				}
+				if sel, ok := selectionAt(c.selections, c.bounds[i]); ok {
+					if e.monochrome {
+						style = tcell.StyleDefault.Reverse(true)
+					} else {
+						style = tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(e.UserColor(sel.User))
+					}
+				}
+				if c.bounds[i] == c.bracket || c.bounds[i] == c.match {
+					style = style.Bold(true).Background(tcell.ColorTeal)
+				}
+				if inRanges(c.highlights, c.bounds[i]) {
+					style = style.Underline(true)
+				}
+				// typos is sorted, so the typos before the cluster are dropped as it goes.
+				for len(typos) > 0 && typos[0].End <= c.bounds[i] {
+					typos = typos[1:]
+				}
+				if len(typos) > 0 && c.bounds[i] >= typos[0].Start {
+					style = style.Foreground(tcell.ColorMaroon).Underline(true)
+				}
+				e.setContent(setX, setY, ch, combining, style)
+			}
+
+			// Update x by the cluster's width.
+			x = x + width
+		}
+	}
+	if y < yEnd && drawn {
+		e.drawScrollMarkers(top, y-rowOff, left, right)
+	}
+}
+
+// drawScrollMarkers draws "<" and ">" at the edges of a row of a pane starting at the
+// screen's row top, to show that the line continues past the left or right edge of the
+// window.
+func (e *Editor) drawScrollMarkers(top, row int, left, right bool) {
+	if row < 0 {
+		return
+	}
+	if left {
+		e.setCell(0, top+row, '<', tcell.StyleDefault.Foreground(tcell.ColorTeal))
+	}
+	if right {
+		e.setCell(e.textWidth()-1, top+row, '>', tcell.StyleDefault.Foreground(tcell.ColorTeal))
+	}
+}
+
+// DrawOverlay draws the editor's overlay, if any, in a box at the top right corner of the
+// text area.
+func (e *Editor) DrawOverlay() {
+	e.StatusMu.Lock()
+	o := e.overlay
+	e.StatusMu.Unlock()
+	if o == nil {
+		return
+	}
 
-			// Update x by rune's width.
-			x = x + runewidth.RuneWidth(e.Text[i])
+	// The box is as wide as its widest line, with a space of padding on each side.
+	inner := runewidth.StringWidth(o.Title) + 2
+	for _, line := range o.Lines {
+		if w := runewidth.StringWidth(line) + 2; w > inner {
+			inner = w
 		}
 	}
 
-	e.DrawStatusBar()
+	left := e.Width - inner - 2
+	if left < 0 {
+		left = 0
+	}
+	bottom := len(o.Lines) + 1
+	if bottom > e.Height-2 {
+		bottom = e.Height - 2 // keep the status bar visible
+	}
 
-	// Flush back buffer!
-	termbox.Flush()
+	style := tcell.StyleDefault
+	for y := 0; y <= bottom; y++ {
+		for x := left; x < left+inner+2; x++ {
+			r := ' '
+			switch {
+			case (y == 0 || y == bottom) && (x == left || x == left+inner+1):
+				r = '+'
+			case y == 0 || y == bottom:
+				r = '-'
+			case x == left || x == left+inner+1:
+				r = '|'
+			}
+			e.setCell(x, y, r, style)
+		}
+	}
+
+	text := func(x, y int, s string) {
+		for _, r := range s {
+			if x >= left+inner+1 {
+				return
+			}
+			e.setCell(x, y, r, style)
+			x += runewidth.RuneWidth(r)
+		}
+	}
+
+	text(left+2, 0, o.Title)
+	for i, line := range o.Lines {
+		if i+1 >= bottom {
+			break
+		}
+		style = tcell.StyleDefault
+		if i < len(o.Colors) {
+			style = style.Foreground(o.Colors[i])
+		}
+		text(left+2, i+1, line)
+	}
 }
 
 // DrawStatusBar shows all status and debug information on the bottom line of the editor.
 func (e *Editor) DrawStatusBar() {
 	e.StatusMu.Lock()
 	showMsg := e.ShowMsg
+	showPrompt := e.prompt != nil
 	e.StatusMu.Unlock()
-	if showMsg {
+	if showPrompt {
+		e.DrawPrompt()
+	} else if showMsg {
 		e.DrawStatusMsg()
 	} else {
 		e.DrawInfoBar()
 	}
 
 	// Render connection indicator
+	indicator := tcell.ColorMaroon
 	if e.IsConnected {
-		termbox.SetBg(e.Width-1, e.Height-1, termbox.ColorGreen)
-	} else {
-		termbox.SetBg(e.Width-1, e.Height-1, termbox.ColorRed)
+		indicator = tcell.ColorGreen
+	}
+	if e.screen != nil {
+		r, combining, style, _ := e.screen.GetContent(e.Width-1, e.Height-1)
+		e.screen.SetContent(e.Width-1, e.Height-1, r, combining, style.Background(indicator))
 	}
 }
 
 // DrawStatusMsg draws the editor's status message at the bottom of the
-// termbox window.
+// screen.
 func (e *Editor) DrawStatusMsg() {
 	e.StatusMu.Lock()
 	statusMsg := e.StatusMsg
 	e.StatusMu.Unlock()
-	for i, r := range []rune(statusMsg) {
-		termbox.SetCell(i, e.Height-1, r, termbox.ColorDefault, termbox.ColorDefault)
+	x := 0
+	for _, r := range statusMsg {
+		e.setCell(x, e.Height-1, r, tcell.StyleDefault)
+		x += runewidth.RuneWidth(r)
 	}
 }
 
-// DrawInfoBar draws the editor's debug information and the names of the
-// active users in the editing session at the bottom of the termbox window.
-func (e *Editor) DrawInfoBar() {
-	e.StatusMu.Lock()
-	users := e.Users
-	e.StatusMu.Unlock()
-
-	e.mu.RLock()
-	length := len(e.Text)
-	e.mu.RUnlock()
+// fitUsers returns the number of users whose names, each followed by a space, fit in width
+// columns, or fit with the count of the others, which is returned as well ("+3 others ").
+func fitUsers(users []User, width int) (shown int, more string) {
+	widths := make([]int, len(users))
+	total := 0
+	for i, u := range users {
+		widths[i] = runewidth.StringWidth(u.Name) + 1
+		total += widths[i]
+	}
+	if total <= width {
+		return len(users), ""
+	}
 
-	x := 0
-	for i, user := range users {
-		for _, r := range user {
-			colorIdx := i % len(userColors)
-			termbox.SetCell(x, e.Height-1, r, userColors[colorIdx], termbox.ColorDefault)
-			x++
+	for shown = len(users) - 1; shown >= 0; shown-- {
+		total -= widths[shown]
+		more = fmt.Sprintf("+%d others ", len(users)-shown)
+		if len(users)-shown == 1 {
+			more = "+1 other "
+		}
+		if total+len(more) <= width {
+			break
 		}
-		termbox.SetCell(x, e.Height-1, ' ', termbox.ColorDefault, termbox.ColorDefault)
-		x++
 	}
-
-	e.mu.RLock()
-	cursor := e.Cursor
-	e.mu.RUnlock()
-
-	cx, cy := e.calcXY(cursor)
-	debugInfo := fmt.Sprintf(" x=%d, y=%d, cursor=%d, len(text)=%d", cx, cy, e.Cursor, length)
-
-	for _, r := range debugInfo {
-		termbox.SetCell(x, e.Height-1, r, termbox.ColorDefault, termbox.ColorDefault)
-		x++
+	if shown < 0 {
+		shown = 0
 	}
+	return shown, more
 }
 
 // MoveCursor updates the cursor position horizontally by a given x increment, and
@@ -285,43 +903,23 @@ func (e *Editor) MoveCursor(x, y int) {
 	if len(e.Text) == 0 && e.Cursor == 0 {
 		return
 	}
-	// Move cursor horizontally.
-	newCursor := e.Cursor + x
+	// Move cursor horizontally, by whole grapheme clusters.
+	newCursor := e.Cursor
+	if x != 0 {
+		newCursor = moveByClusters(e.Text, e.Cursor, x)
+	}
 
-	// Move cursor vertically.
+	// Move cursor vertically, and make sure it doesn't end up inside a grapheme cluster.
 	if y > 0 {
-		newCursor = e.calcCursorDown()
+		newCursor = clusterStart(e.Text, e.calcCursorDown())
 	}
 
 	if y < 0 {
-		newCursor = e.calcCursorUp()
+		newCursor = clusterStart(e.Text, e.calcCursorUp())
 	}
 
 	if e.ScrollEnabled {
-		cx, cy := e.calcXY(newCursor)
-
-		// move the window to adjust for the cursor
-		rowStart := e.GetRowOff()
-		rowEnd := e.GetRowOff() + e.GetHeight() - 1
-
-		if cy <= rowStart { // scroll up
-			e.IncRowOff(cy - rowStart - 1)
-		}
-
-		if cy > rowEnd { // scroll down
-			e.IncRowOff(cy - rowEnd)
-		}
-
-		colStart := e.GetColOff()
-		colEnd := e.GetColOff() + e.GetWidth()
-
-		if cx <= colStart { // scroll left
-			e.IncColOff(cx - (colStart + 1))
-		}
-
-		if cx > colEnd { // scroll right
-			e.IncColOff(cx - colEnd)
-		}
+		e.scroll(e.calcXY(newCursor))
 	}
 
 	// Reset to bounds.
@@ -337,11 +935,150 @@ func (e *Editor) MoveCursor(x, y int) {
 	e.mu.Unlock()
 }
 
+// MoveCursorRunes moves the cursor by n runes, to the right if n is positive. This is used
+// to follow the runes inserted and deleted before the cursor, which needn't be whole grapheme
+// clusters: a combining mark inserted after the cursor's rune joins its cluster.
+func (e *Editor) MoveCursorRunes(n int) {
+	newCursor := e.Cursor + n
+	if newCursor > len(e.Text) {
+		newCursor = len(e.Text)
+	}
+	if newCursor < 0 {
+		newCursor = 0
+	}
+
+	if e.ScrollEnabled {
+		e.scroll(e.calcXY(newCursor))
+	}
+	e.mu.Lock()
+	e.Cursor = newCursor
+	e.mu.Unlock()
+}
+
+// MoveCursorWords moves the cursor over n words, to the right if n is positive: to the end
+// of the next word, or to the start of the previous one.
+func (e *Editor) MoveCursorWords(n int) {
+	newCursor := moveByWords(e.Text, e.Cursor, n)
+	if e.ScrollEnabled {
+		e.scroll(e.calcXY(newCursor))
+	}
+	e.mu.Lock()
+	e.Cursor = newCursor
+	e.mu.Unlock()
+}
+
+// moveByWords returns the index reached by moving over n words from index in text.
+func moveByWords(text []rune, index, n int) int {
+	if index > len(text) {
+		index = len(text)
+	}
+	for ; n > 0; n-- {
+		for index < len(text) && !isWordRune(text[index]) {
+			index++
+		}
+		for index < len(text) && isWordRune(text[index]) {
+			index++
+		}
+	}
+	for ; n < 0 && index > 0; n++ {
+		for index > 0 && !isWordRune(text[index-1]) {
+			index--
+		}
+		for index > 0 && isWordRune(text[index-1]) {
+			index--
+		}
+	}
+	return index
+}
+
 // For the functions calcCursorUp and calcCursorDown, newline characters are found by iterating backward and forward from the current cursor position.
 // These characters are taken as the "start" and "end" of the current line.
 // The "offset" from the start of the current line to the cursor is calculated and used to determine the final cursor position on the target line, based on whether the offset is greater than the length of the target line.
 // "pos" is used as a placeholder variable for the cursor.
 
+// scroll moves the editor window, so that the cursor position (cx, cy) is visible, unless
+// it was scrolled away from the cursor.
+func (e *Editor) scroll(cx, cy int) {
+	if e.detached {
+		return
+	}
+
+	rowStart := e.GetRowOff()
+	rowEnd := e.GetRowOff() + e.textHeight()
+
+	// The window scrolls as the cursor gets within the margins of its edges, rather than
+	// when it reaches them.
+	above, below := e.scrollMargins(cy)
+
+	if cy-above <= rowStart { // scroll up
+		e.IncRowOff(cy - above - rowStart - 1)
+	}
+
+	if cy+below > rowEnd { // scroll down
+		e.IncRowOff(cy + below - rowEnd)
+	}
+
+	if e.RowOff < 0 {
+		e.RowOff = 0
+	}
+
+	colStart := e.GetColOff()
+	colEnd := e.GetColOff() + e.textWidth()
+
+	// Scroll horizontally by several columns at once, so that the window doesn't move on
+	// every key press along a long line.
+	jump := e.scrollJump()
+
+	if cx <= colStart { // scroll left
+		e.IncColOff(cx - (colStart + 1) - (jump - 1))
+	}
+
+	if cx > colEnd { // scroll right
+		e.IncColOff(cx - colEnd + jump - 1)
+	}
+
+	if e.ColOff < 0 {
+		e.ColOff = 0
+	}
+}
+
+// scrollMargins returns the number of lines to keep in the window above and below the
+// cursor on line cy: ScrollOff, or as many as fit in the window with the cursor's line,
+// and no more below it than the document has.
+func (e *Editor) scrollMargins(cy int) (above, below int) {
+	margin := e.ScrollOff
+	if max := (e.textHeight() - 1) / 2; margin > max {
+		margin = max
+	}
+	if margin <= 0 {
+		return 0, 0
+	}
+
+	e.mu.RLock()
+	lines := 1
+	for _, r := range e.Text {
+		if r == '\n' {
+			lines++
+		}
+	}
+	e.mu.RUnlock()
+
+	below = margin
+	if rest := lines - cy; below > rest {
+		below = rest
+	}
+	return margin, below
+}
+
+// scrollJump returns the number of columns by which the window scrolls horizontally: a
+// quarter of the window's width, and at least one column.
+func (e *Editor) scrollJump() int {
+	if jump := e.textWidth() / 4; jump > 1 {
+		return jump
+	}
+	return 1
+}
+
 // calcCursorUp calculates and returns the intended cursor position after moving the cursor up one line.
 func (e *Editor) calcCursorUp() int {
 	pos := e.Cursor
@@ -457,22 +1194,22 @@ func (e *Editor) calcXY(index int) (int, int) {
 	}
 
 	e.mu.RLock()
-	length := len(e.Text)
-	e.mu.RUnlock()
+	defer e.mu.RUnlock()
 
-	if index > length {
-		index = length
+	if index > len(e.Text) {
+		index = len(e.Text)
 	}
 
-	for i := 0; i < index; i++ {
-		e.mu.RLock()
-		r := e.Text[i]
-		e.mu.RUnlock()
-		if r == rune('\n') {
+	// Wide characters and grapheme clusters spanning multiple runes are measured as a whole.
+	text := e.Text[:index]
+	bounds := graphemeBounds(text)
+	for i := 0; i < len(bounds)-1; i++ {
+		cluster := text[bounds[i]:bounds[i+1]]
+		if cluster[0] == rune('\n') {
 			x = 1
 			y++
 		} else {
-			x = x + runewidth.RuneWidth(r)
+			x = x + clusterWidth(cluster)
 		}
 	}
 	return x, y
@@ -1,8 +1,14 @@
 package editor
 
 import (
+	"math"
+	"math/rand"
+	"reflect"
 	"testing"
+	"testing/quick"
+	"time"
 
+	"github.com/gdamore/tcell/v2"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -232,3 +238,956 @@ func TestScroll(t *testing.T) {
 		}
 	}
 }
+
+func TestGraphemeClusters(t *testing.T) {
+	tests := []struct {
+		description    string
+		cursor         int
+		x              int
+		y              int
+		expectedCursor int
+		expectedX      int
+		text           []rune
+	}{
+		{description: "move over combining character", cursor: 1, x: 1, expectedCursor: 3, expectedX: 3,
+			text: []rune("ae\u0301b")},
+		{description: "move back over combining character", cursor: 3, x: -1, expectedCursor: 1, expectedX: 2,
+			text: []rune("ae\u0301b")},
+		{description: "move over ZWJ emoji sequence", cursor: 0, x: 1, expectedCursor: 5, expectedX: 3,
+			text: []rune("\U0001F469\u200D\U0001F469\u200D\U0001F467x")},
+		{description: "move back over ZWJ emoji sequence", cursor: 6, x: -1, expectedCursor: 5, expectedX: 3,
+			text: []rune("\U0001F469\u200D\U0001F469\u200D\U0001F467x")},
+		{description: "move over wide character", cursor: 0, x: 1, expectedCursor: 1, expectedX: 3,
+			text: []rune("\u65E5\u672C")},
+		{description: "move from inside a cluster", cursor: 2, x: -1, expectedCursor: 1, expectedX: 2,
+			text: []rune("ae\u0301b")},
+		{description: "move down into a cluster", cursor: 1, y: 1, expectedCursor: 3, expectedX: 1,
+			text: []rune("ab\ne\u0301f")},
+	}
+
+	e := NewEditor(EditorConfig{})
+
+	for _, tc := range tests {
+		e.Cursor = tc.cursor
+		e.Text = tc.text
+		e.MoveCursor(tc.x, tc.y)
+
+		if !cmp.Equal(e.Cursor, tc.expectedCursor) {
+			t.Errorf("(%s) Wrong cursor: got != expected, diff: %v\n", tc.description, cmp.Diff(e.Cursor, tc.expectedCursor))
+		}
+
+		x, _ := e.calcXY(e.Cursor)
+		if !cmp.Equal(x, tc.expectedX) {
+			t.Errorf("(%s) Wrong x: got != expected, diff: %v\n", tc.description, cmp.Diff(x, tc.expectedX))
+		}
+	}
+}
+
+func TestMoveCursorRunes(t *testing.T) {
+	tests := []struct {
+		description    string
+		cursor         int
+		n              int
+		expectedCursor int
+		text           []rune
+	}{
+		{description: "after a combining sequence inserted before the cursor", cursor: 0, n: 2, expectedCursor: 2,
+			text: []rune("e\u0301ab")},
+		{description: "after a combining mark inserted before the cursor", cursor: 1, n: 1, expectedCursor: 2,
+			text: []rune("e\u0301ab")},
+		{description: "after a combining mark deleted before the cursor", cursor: 3, n: -1, expectedCursor: 2,
+			text: []rune("ea")},
+		{description: "past the end", cursor: 2, n: 3, expectedCursor: 3,
+			text: []rune("abc")},
+		{description: "past the start", cursor: 1, n: -2, expectedCursor: 0,
+			text: []rune("abc")},
+	}
+
+	e := NewEditor(EditorConfig{})
+
+	for _, tc := range tests {
+		e.Cursor = tc.cursor
+		e.Text = tc.text
+		e.MoveCursorRunes(tc.n)
+
+		if !cmp.Equal(e.Cursor, tc.expectedCursor) {
+			t.Errorf("(%s) Wrong cursor: got != expected, diff: %v\n", tc.description, cmp.Diff(e.Cursor, tc.expectedCursor))
+		}
+	}
+}
+
+func TestMoveCursorWords(t *testing.T) {
+	tests := []struct {
+		description    string
+		cursor         int
+		n              int
+		expectedCursor int
+	}{
+		{description: "to the end of the word", cursor: 1, n: 1, expectedCursor: 5},
+		{description: "to the end of the next word", cursor: 5, n: 1, expectedCursor: 14},
+		{description: "over two words", cursor: 0, n: 2, expectedCursor: 14},
+		{description: "to the start of the word", cursor: 9, n: -1, expectedCursor: 7},
+		{description: "to the start of the previous word", cursor: 7, n: -1, expectedCursor: 0},
+		{description: "past the end", cursor: 13, n: 3, expectedCursor: 18},
+		{description: "past the start", cursor: 2, n: -3, expectedCursor: 0},
+	}
+
+	e := NewEditor(EditorConfig{})
+	e.Text = []rune("hello, wörld_2\n(x)")
+
+	for _, tc := range tests {
+		e.Cursor = tc.cursor
+		e.MoveCursorWords(tc.n)
+
+		if e.Cursor != tc.expectedCursor {
+			t.Errorf("(%s) got cursor %d, expected %d\n", tc.description, e.Cursor, tc.expectedCursor)
+		}
+	}
+}
+
+// TestInsertCombiningMark types a combining mark after the cursor's character, as the client
+// does: the mark is inserted at the cursor, which then moves past it. The mark joins the
+// character's grapheme cluster, so moving by clusters would skip the next character too.
+func TestInsertCombiningMark(t *testing.T) {
+	tests := []struct {
+		description    string
+		text           string
+		cursor         int
+		inserted       string
+		expectedCursor int
+	}{
+		{description: "combining acute accent", text: "eab", cursor: 1, inserted: "\u0301", expectedCursor: 2},
+		{description: "second combining mark", text: "e\u0301ab", cursor: 2, inserted: "\u0323", expectedCursor: 3},
+		{description: "zero width joiner", text: "\U0001F469x", cursor: 1, inserted: "\u200d", expectedCursor: 2},
+		{description: "at the end", text: "e", cursor: 1, inserted: "\u0301", expectedCursor: 2},
+	}
+
+	e := NewEditor(EditorConfig{})
+
+	for _, tc := range tests {
+		e.SetText(tc.text)
+		e.Cursor = tc.cursor
+
+		for _, r := range tc.inserted {
+			text := []rune(string(e.Text))
+			text = append(text[:e.Cursor], append([]rune{r}, text[e.Cursor:]...)...)
+			e.SetText(string(text))
+			e.MoveCursorRunes(1)
+		}
+
+		if !cmp.Equal(e.Cursor, tc.expectedCursor) {
+			t.Errorf("(%s) Wrong cursor: got != expected, diff: %v\n", tc.description, cmp.Diff(e.Cursor, tc.expectedCursor))
+		}
+	}
+}
+
+func TestClusterLenBefore(t *testing.T) {
+	tests := []struct {
+		description string
+		cursor      int
+		expected    int
+		text        []rune
+	}{
+		{description: "start of text", cursor: 0, expected: 0, text: []rune("abc")},
+		{description: "single rune", cursor: 1, expected: 1, text: []rune("abc")},
+		{description: "combining character", cursor: 3, expected: 2, text: []rune("ae\u0301b")},
+		{description: "ZWJ emoji sequence", cursor: 5, expected: 5, text: []rune("\U0001F469\u200D\U0001F469\u200D\U0001F467")},
+		{description: "out of bounds", cursor: 10, expected: 1, text: []rune("abc")},
+	}
+
+	e := NewEditor(EditorConfig{})
+
+	for _, tc := range tests {
+		e.Cursor = tc.cursor
+		e.Text = tc.text
+
+		got := e.ClusterLenBefore()
+		if !cmp.Equal(got, tc.expected) {
+			t.Errorf("(%s) got != expected, diff: %v\n", tc.description, cmp.Diff(got, tc.expected))
+		}
+	}
+}
+
+func TestAnswerPrompt(t *testing.T) {
+	var answered string
+	answer := func(s string) func() error {
+		return func() error {
+			answered = s
+			return nil
+		}
+	}
+
+	tests := []struct {
+		description string
+		ev          *tcell.EventKey
+		expected    string
+		active      bool
+	}{
+		{description: "answer", ev: tcell.NewEventKey(tcell.KeyRune, 'y', tcell.ModNone), expected: "yes"},
+		{description: "answer (upper case)", ev: tcell.NewEventKey(tcell.KeyRune, 'N', tcell.ModShift), expected: "no"},
+		{description: "cancel", ev: tcell.NewEventKey(tcell.KeyEsc, 0, tcell.ModNone), expected: "cancel"},
+		{description: "other key", ev: tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModNone), expected: "", active: true},
+		{description: "key without a rune", ev: tcell.NewEventKey(tcell.KeyF1, 0, tcell.ModNone), expected: "", active: true},
+	}
+
+	e := NewEditor(EditorConfig{})
+
+	for _, tc := range tests {
+		answered = ""
+		e.ShowPrompt(&Prompt{
+			Text:    "Continue? (y/n)",
+			Answers: map[rune]func() error{'y': answer("yes"), 'n': answer("no")},
+			Cancel:  answer("cancel"),
+		})
+
+		if err := e.AnswerPrompt(tc.ev); err != nil {
+			t.Errorf("(%s) error: %v\n", tc.description, err)
+		}
+
+		if answered != tc.expected || e.PromptActive() != tc.active {
+			t.Errorf("(%s) got answer %q (active: %v), expected %q (active: %v)\n", tc.description, answered, e.PromptActive(), tc.expected, tc.active)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		description    string
+		width          int
+		height         int
+		colOff         int
+		expectedColOff int
+		rowOff         int
+		expectedRowOff int
+		cursor         int
+	}{
+		{description: "grow", width: 10, height: 10,
+			colOff: 0, expectedColOff: 0,
+			rowOff: 1, expectedRowOff: 1,
+			cursor: 4},
+		{description: "shrink height", width: 5, height: 2,
+			colOff: 0, expectedColOff: 0,
+			rowOff: 0, expectedRowOff: 3,
+			cursor: 6},
+		{description: "shrink width", width: 2, height: 5,
+			colOff: 0, expectedColOff: 3,
+			rowOff: 0, expectedRowOff: 1,
+			cursor: 13},
+		{description: "negative offsets", width: 5, height: 5,
+			colOff: -3, expectedColOff: 0,
+			rowOff: -2, expectedRowOff: 0,
+			cursor: 0},
+	}
+
+	e := NewEditor(EditorConfig{ScrollEnabled: true})
+	e.Text = []rune("a\nb\nc\nd\nabcd")
+
+	for _, tc := range tests {
+		e.Width, e.Height = 5, 5
+		e.ColOff = tc.colOff
+		e.RowOff = tc.rowOff
+		e.Cursor = tc.cursor
+
+		e.Resize(tc.width, tc.height)
+
+		got := []int{e.Width, e.Height, e.ColOff, e.RowOff}
+		expected := []int{tc.width, tc.height, tc.expectedColOff, tc.expectedRowOff}
+
+		if !cmp.Equal(got, expected) {
+			t.Errorf("(%s) got != expected, diff: %v\n", tc.description, cmp.Diff(got, expected))
+		}
+	}
+}
+
+func TestScrollJump(t *testing.T) {
+	tests := []struct {
+		description    string
+		x              int
+		colOff         int
+		expectedColOff int
+		cursor         int
+	}{
+		{description: "scroll right", x: 1, colOff: 0, expectedColOff: 5, cursor: 19},
+		{description: "scroll left", x: -1, colOff: 10, expectedColOff: 5, cursor: 10},
+		{description: "scroll left (start of line)", x: -1, colOff: 2, expectedColOff: 0, cursor: 2},
+	}
+
+	e := NewEditor(EditorConfig{ScrollEnabled: true})
+	e.Width = 20
+	e.Height = 5
+	e.Text = []rune("abcdefghijklmnopqrstuvwxyz0123")
+
+	for _, tc := range tests {
+		e.ColOff = tc.colOff
+		e.Cursor = tc.cursor
+
+		e.MoveCursor(tc.x, 0)
+
+		if e.ColOff != tc.expectedColOff {
+			t.Errorf("(%s) Wrong col offset: got %d, expected %d\n", tc.description, e.ColOff, tc.expectedColOff)
+		}
+	}
+}
+
+func TestTrailingWhitespace(t *testing.T) {
+	tests := []struct {
+		description string
+		text        string
+		expected    []bool
+	}{
+		{description: "no whitespace", text: "ab", expected: []bool{false, false}},
+		{description: "inner space", text: "a b", expected: []bool{false, false, false}},
+		{description: "trailing space", text: "a \t", expected: []bool{false, true, true}},
+		{description: "before newline", text: "a \nb", expected: []bool{false, true, false, false}},
+		{description: "blank line", text: "  \n", expected: []bool{true, true, false}},
+		{description: "non-breaking space", text: "a\u00a0", expected: []bool{false, true}},
+	}
+
+	for _, tc := range tests {
+		text := []rune(tc.text)
+		got := trailingWhitespace(text, graphemeBounds(text))
+
+		if !cmp.Equal(got, tc.expected) {
+			t.Errorf("(%s) got != expected, diff: %v\n", tc.description, cmp.Diff(got, tc.expected))
+		}
+	}
+}
+
+func TestMatchBracket(t *testing.T) {
+	tests := []struct {
+		description string
+		text        string
+		index       int
+		expected    int
+	}{
+		{description: "not a bracket", text: "(a)", index: 1, expected: -1},
+		{description: "opening bracket", text: "(a)", index: 0, expected: 2},
+		{description: "closing bracket", text: "(a)", index: 2, expected: 0},
+		{description: "nested brackets", text: "{[()]()}", index: 0, expected: 7},
+		{description: "inner brackets", text: "{[()]()}", index: 5, expected: 6},
+		{description: "across lines", text: "f() {\n\tx\n}", index: 4, expected: 9},
+		{description: "other bracket types", text: "(])", index: 0, expected: 2},
+		{description: "unmatched bracket", text: "((a)", index: 0, expected: -1},
+		{description: "out of bounds", text: "()", index: 2, expected: -1},
+	}
+
+	for _, tc := range tests {
+		if got := matchBracket([]rune(tc.text), tc.index); got != tc.expected {
+			t.Errorf("(%s) got %d, expected %d\n", tc.description, got, tc.expected)
+		}
+	}
+}
+
+func TestSelectionAt(t *testing.T) {
+	alice, bob := User{Name: "alice", Color: 0}, User{Name: "bob", Color: 1}
+	selections := []Selection{
+		{Range: Range{Start: 0, End: 4}, User: alice},
+		{Range: Range{Start: 2, End: 6}, User: bob},
+	}
+
+	tests := []struct {
+		description string
+		index       int
+		expected    string // The name of the user whose selection is drawn, or "".
+	}{
+		{description: "first selection", index: 1, expected: "alice"},
+		{description: "overlap", index: 3, expected: "bob"},
+		{description: "end is excluded", index: 6, expected: ""},
+	}
+
+	for _, tc := range tests {
+		got, _ := selectionAt(selections, tc.index)
+		if got.User.Name != tc.expected {
+			t.Errorf("(%s) got %q, expected %q\n", tc.description, got.User.Name, tc.expected)
+		}
+	}
+}
+
+// words is a SpellChecker knowing a few words.
+type words map[string]bool
+
+func (w words) Correct(word string) bool { return w[word] }
+
+func TestMisspelled(t *testing.T) {
+	known := words{"the": true, "cat": true, "don't": true}
+
+	tests := []struct {
+		description string
+		text        string
+		cursor      int
+		expected    []Range
+	}{
+		{description: "known words", text: "the cat", cursor: -1},
+		{description: "typo", text: "teh cat", cursor: -1, expected: []Range{{Start: 0, End: 3}}},
+		{description: "apostrophe", text: "don't dont", cursor: -1, expected: []Range{{Start: 6, End: 10}}},
+		{description: "quotes", text: "'cat' 'dgo'", cursor: -1, expected: []Range{{Start: 7, End: 10}}},
+		{description: "being typed", text: "the ca", cursor: 6},
+		{description: "typed", text: "the ca ", cursor: 7, expected: []Range{{Start: 4, End: 6}}},
+		{description: "code", text: "camelCase snake_case x2 HTTP", cursor: -1},
+		{description: "single letter", text: "x y", cursor: -1},
+	}
+
+	for _, tc := range tests {
+		got := misspelled([]rune(tc.text), tc.cursor, known)
+
+		if !cmp.Equal(got, tc.expected) {
+			t.Errorf("(%s) got != expected, diff: %v\n", tc.description, cmp.Diff(got, tc.expected))
+		}
+	}
+}
+
+func TestAnswerPrompt_Input(t *testing.T) {
+	var submitted string
+
+	e := NewEditor(EditorConfig{})
+	e.ShowPrompt(&Prompt{
+		Text: "Comment:",
+		Submit: func(text string) error {
+			submitted = text
+			return nil
+		},
+	})
+
+	key := func(k tcell.Key, ch rune) *tcell.EventKey { return tcell.NewEventKey(k, ch, tcell.ModNone) }
+	events := []*tcell.EventKey{
+		key(tcell.KeyRune, 'h'), key(tcell.KeyRune, 'x'), key(tcell.KeyBackspace2, 0), key(tcell.KeyRune, 'i'),
+		key(tcell.KeyRune, ' '), key(tcell.KeyRune, 'y'), key(tcell.KeyEnter, 0),
+	}
+	for _, ev := range events {
+		if err := e.AnswerPrompt(ev); err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+	}
+
+	if submitted != "hi y" || e.PromptActive() {
+		t.Errorf("got %q (active: %v), expected %q (active: false)\n", submitted, e.PromptActive(), "hi y")
+	}
+}
+
+// propertyText is a text generated for property-based tests, made of short lines mixing
+// ASCII, wide characters and a combining sequence, with empty lines and sometimes a
+// trailing newline.
+type propertyText []rune
+
+func (propertyText) Generate(r *rand.Rand, size int) reflect.Value {
+	pieces := []string{"a", "b", " ", "\n", "\n", "世", "é", "\t"}
+	var text []rune
+	for n := r.Intn(size + 1); n > 0; n-- {
+		text = append(text, []rune(pieces[r.Intn(len(pieces))])...)
+	}
+	return reflect.ValueOf(propertyText(text))
+}
+
+// propertyEditor returns an editor holding text, with the cursor at the start of the cluster
+// nearest to the given position.
+func propertyEditor(text propertyText, pos uint) *Editor {
+	e := NewEditor(EditorConfig{ScrollEnabled: true})
+	e.SetSize(20, 10)
+	e.Text = []rune(text)
+	e.Cursor = clusterStart(e.Text, int(pos%uint(len(text)+1)))
+	return e
+}
+
+// isClusterStart reports whether index is the start of a grapheme cluster of text, or its
+// end.
+func isClusterStart(text []rune, index int) bool {
+	for _, b := range graphemeBounds(text) {
+		if b == index {
+			return true
+		}
+	}
+	return index == 0
+}
+
+// lineOf returns the line of the cursor, counted from 1.
+func lineOf(e *Editor) int {
+	_, y := e.calcXY(e.Cursor)
+	return y
+}
+
+// TestCursorBounds checks that the cursor stays within the text, and at the start of a
+// grapheme cluster, whichever way it's moved.
+func TestCursorBounds(t *testing.T) {
+	property := func(text propertyText, pos uint, moves []int8) bool {
+		e := propertyEditor(text, pos)
+		for _, m := range moves {
+			// Moves are up, down, or left or right by up to 2 clusters.
+			switch m % 4 {
+			case 0:
+				e.MoveCursor(0, -1)
+			case 1:
+				e.MoveCursor(0, 1)
+			default:
+				e.MoveCursor(int(m%3), 0)
+			}
+
+			if e.Cursor < 0 || e.Cursor > len(e.Text) || !isClusterStart(e.Text, e.Cursor) {
+				t.Logf("text %q: cursor at %d after move %d", string(text), e.Cursor, m)
+				return false
+			}
+			cx, cy := e.calcXY(e.Cursor)
+			if cy <= e.RowOff || cy > e.RowOff+e.Height-1 || cx <= e.ColOff || cx > e.ColOff+e.Width {
+				t.Logf("text %q: cursor at (%d, %d) outside the window at (%d, %d)", string(text), cx, cy, e.ColOff, e.RowOff)
+				return false
+			}
+		}
+		return true
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCursorUpDown checks that moving up then down returns to the same line, at or before
+// the original column, unless the cursor started on the first line, and likewise for down
+// then up.
+func TestCursorUpDown(t *testing.T) {
+	property := func(text propertyText, pos uint, down bool) bool {
+		e := propertyEditor(text, pos)
+		first, last := 1, lineOf(propertyEditor(text, uint(len(text))))
+		x, y := e.calcXY(e.Cursor)
+
+		dir := -1
+		if down {
+			dir = 1
+		}
+		if (dir < 0 && y == first) || (dir > 0 && y == last) {
+			return true
+		}
+		e.MoveCursor(0, dir)
+		if lineOf(e) != y+dir {
+			t.Logf("text %q: moved from line %d to %d", string(text), y, lineOf(e))
+			return false
+		}
+		e.MoveCursor(0, -dir)
+
+		gotX, gotY := e.calcXY(e.Cursor)
+		if gotY != y || gotX > x {
+			t.Logf("text %q: started at (%d, %d), got back to (%d, %d)", string(text), x, y, gotX, gotY)
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCalcXYMonotone checks that calcXY orders indexes like the text: later indexes are on
+// later lines, or further right on the same line.
+func TestCalcXYMonotone(t *testing.T) {
+	property := func(text propertyText, i, j uint) bool {
+		e := propertyEditor(text, 0)
+		a, b := int(i%uint(len(text)+1)), int(j%uint(len(text)+1))
+		if a > b {
+			a, b = b, a
+		}
+
+		ax, ay := e.calcXY(a)
+		bx, by := e.calcXY(b)
+		if ay > by || (ay == by && ax > bx) {
+			t.Logf("text %q: index %d at (%d, %d), index %d at (%d, %d)", string(text), a, ax, ay, b, bx, by)
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestStatusLoop checks that status messages are shown one after the other, each for the
+// configured duration.
+func TestStatusLoop(t *testing.T) {
+	var shown []string
+	e := NewEditor(EditorConfig{StatusDuration: time.Millisecond, OnStatus: func(msg string) { shown = append(shown, msg) }})
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		e.StatusLoop(done)
+		close(stopped)
+	}()
+
+	e.StatusChan <- "hello"
+	e.StatusChan <- "world"
+
+	// Each message is shown, then hidden, so there are two draws per message.
+	for i := 0; i < 4; i++ {
+		<-e.DrawChan
+	}
+	close(done)
+	<-stopped
+
+	if expected := []string{"hello", "world"}; !reflect.DeepEqual(shown, expected) {
+		t.Errorf("got messages %q, expected %q", shown, expected)
+	}
+	if e.ShowMsg || e.StatusMsg != "world" {
+		t.Errorf("got message %q shown=%v, expected %q hidden", e.StatusMsg, e.ShowMsg, "world")
+	}
+}
+
+// TestCoalesce checks that a draw requested after a pause is done at once, and that the
+// draws requested during the next frame are coalesced into one at its end.
+func TestCoalesce(t *testing.T) {
+	signals := make(chan int, 100)
+	drawn := make(chan time.Time, 100)
+	done := make(chan struct{})
+	defer close(done)
+	const interval = 100 * time.Millisecond
+	go coalesce(signals, done, interval, func() { drawn <- time.Now() })
+
+	start := time.Now()
+	signals <- 1
+	first := <-drawn
+	if d := first.Sub(start); d > interval/2 {
+		t.Errorf("got the first draw after %s, expected it at once", d)
+	}
+
+	for i := 0; i < 10; i++ {
+		signals <- 1
+	}
+	second := <-drawn
+	if d := second.Sub(first); d < interval {
+		t.Errorf("got the second draw %s after the first, expected at least %s", d, interval)
+	}
+	select {
+	case <-drawn:
+		t.Error("got a third draw, expected the signals to be coalesced into the second")
+	case <-time.After(2 * interval):
+	}
+}
+
+// TestChangedLines checks that the lines whose text changed are found, and that the lines
+// after lines added or removed are all changed.
+func TestChangedLines(t *testing.T) {
+	tests := []struct {
+		prev, next string
+		start, end int
+	}{
+		{"one\ntwo\nthree", "one\ntwo\nthree", 0, 0},
+		{"one\ntwo\nthree", "one\ntwos\nthree", 1, 2},
+		{"one\ntwo\nthree", "one\nto\nthree", 1, 2},
+		{"one\ntwo\nthree", "one\ntwo\nthree!", 2, 3},
+		{"one\ntwo\nthree", "one\ntw\no\nthree", 1, math.MaxInt},
+		{"one\ntwo\nthree", "one\ntwothree", 1, math.MaxInt},
+		{"one\ntwo", "", 0, math.MaxInt},
+		{"aa\nbb\naa", "aa\nbX\nXa", 1, 3},
+	}
+	for _, tc := range tests {
+		start, end := changedLines([]rune(tc.prev), []rune(tc.next))
+		if start != tc.start || end != tc.end {
+			t.Errorf("%q to %q: got lines %d to %d, expected %d to %d", tc.prev, tc.next, start, end, tc.start, tc.end)
+		}
+	}
+}
+
+// TestLineMarks checks that decorations are found on the lines they cover, relative to the
+// lines' starts, so that lines whose position in the text moved keep their marks.
+func TestLineMarks(t *testing.T) {
+	e := NewEditor(EditorConfig{})
+	c := paneContent{
+		text:       []rune("ab\ncdef\ngh\nij"),
+		bracket:    -1,
+		match:      -1,
+		line:       2,
+		typos:      []Range{{Start: 3, End: 5}},
+		highlights: []Range{{Start: 4, End: 10}},
+	}
+	got := e.lineMarks(&c, 1, 3)
+	expected := [][]mark{
+		{{kind: markHighlight, start: 1, end: 5}, {kind: markTypo, start: 0, end: 2}},
+		{{kind: markLine}, {kind: markHighlight, start: 0, end: 2}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got marks %+v, expected %+v", got, expected)
+	}
+
+	// The same decorations, with a character inserted on the first line, are the same marks.
+	c.text = []rune("abc\ncdef\ngh\nij")
+	c.typos, c.highlights = []Range{{Start: 4, End: 6}}, []Range{{Start: 5, End: 11}}
+	moved := e.lineMarks(&c, 1, 3)
+	for i := range moved {
+		if !sameMarks(moved[i], got[i]) {
+			t.Errorf("line %d: got marks %+v after the insert, expected %+v", i+1, moved[i], got[i])
+		}
+	}
+}
+
+// TestScrollbar checks the rows of the scrollbar covered by the window, and those standing
+// for the lines with the other users' cursors.
+func TestScrollbar(t *testing.T) {
+	tests := []struct {
+		description        string
+		lines, top, height int
+		start, end         int
+	}{
+		{description: "short document", lines: 3, top: 0, height: 10, start: 0, end: 10},
+		{description: "top of a long document", lines: 100, top: 0, height: 10, start: 0, end: 1},
+		{description: "middle of a long document", lines: 100, top: 45, height: 10, start: 4, end: 6},
+		{description: "end of a long document", lines: 100, top: 90, height: 10, start: 9, end: 10},
+		{description: "past the end of the document", lines: 20, top: 15, height: 10, start: 6, end: 10},
+	}
+
+	for _, tc := range tests {
+		start, end := scrollbarThumb(tc.lines, tc.top, tc.height)
+		if start != tc.start || end != tc.end {
+			t.Errorf("%s: got thumb %d-%d, expected %d-%d", tc.description, start, end, tc.start, tc.end)
+		}
+	}
+
+	for _, tc := range []struct{ line, row int }{{0, 0}, {49, 4}, {99, 9}} {
+		if row := scrollbarRow(tc.line, 100, 0, 10); row != tc.row {
+			t.Errorf("line %d: got row %d, expected %d", tc.line, row, tc.row)
+		}
+	}
+}
+
+// TestSplit tests that the panes of a split window keep their own scroll offsets and
+// cursors.
+func TestSplit(t *testing.T) {
+	e := NewEditor(EditorConfig{ScrollEnabled: true})
+	e.Width, e.Height = 10, 10
+	e.Text = []rune("0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15")
+
+	e.ToggleSplit()
+	if top, rows := e.paneRows(true); top != 0 || rows != 4 {
+		t.Errorf("focused pane: got rows %d from %d, expected 4 from 0", rows, top)
+	}
+	if top, rows := e.paneRows(false); top != 5 || rows != 4 {
+		t.Errorf("other pane: got rows %d from %d, expected 4 from 5", rows, top)
+	}
+
+	// Move down to line 10 in the top pane, which scrolls it, but not the bottom one.
+	for i := 0; i < 10; i++ {
+		e.MoveCursor(0, 1)
+	}
+	if e.RowOff != 7 || e.other.rowOff != 0 {
+		t.Errorf("got row offsets %d and %d, expected 7 and 0", e.RowOff, e.other.rowOff)
+	}
+
+	e.SwitchPane()
+	if e.Cursor != 0 || e.RowOff != 0 || e.splitTop {
+		t.Errorf("after switching: got cursor %d, row offset %d, top %v, expected 0, 0, false", e.Cursor, e.RowOff, e.splitTop)
+	}
+	if top, _ := e.paneRows(true); top != 5 {
+		t.Errorf("after switching: got focused pane from %d, expected 5", top)
+	}
+
+	// The text shrinks while the top pane doesn't have the focus, so its cursor is moved to
+	// the end, and the pane scrolls up to it.
+	e.Text = e.Text[:5]
+	e.SwitchPane()
+	if e.Cursor != 5 || e.RowOff != 2 {
+		t.Errorf("after switching back: got cursor %d, row offset %d, expected 5, 2", e.Cursor, e.RowOff)
+	}
+
+	e.ToggleSplit()
+	if e.IsSplit() || e.textHeight() != 9 {
+		t.Errorf("after joining: got split %v, height %d, expected false, 9", e.IsSplit(), e.textHeight())
+	}
+}
+
+// TestScrollView tests that scrolling the window leaves the cursor where it is, until it's
+// moved into the window by FollowCursor.
+func TestScrollView(t *testing.T) {
+	tests := []struct {
+		description    string
+		cursor         int
+		scroll         int
+		expectedRowOff int
+		expectedCursor int
+	}{
+		{description: "cursor stays visible", cursor: 3, scroll: 1, expectedRowOff: 1, expectedCursor: 3},
+		{description: "cursor above the window", cursor: 1, scroll: 3, expectedRowOff: 3, expectedCursor: 10},
+		{description: "cursor below the window", cursor: 22, scroll: 1, expectedRowOff: 1, expectedCursor: 11},
+		{description: "past the end", cursor: 0, scroll: 20, expectedRowOff: 7, expectedCursor: 19},
+		{description: "past the start", cursor: 0, scroll: -2, expectedRowOff: 0, expectedCursor: 0},
+	}
+
+	for _, tc := range tests {
+		e := NewEditor(EditorConfig{ScrollEnabled: true})
+		e.Width, e.Height = 10, 4
+		e.Text = []rune("ab\nab\nab\nab\nab\nc\nd\nefghij")
+		e.Cursor = tc.cursor
+
+		e.ScrollView(tc.scroll)
+		if e.Cursor != tc.cursor || e.RowOff != tc.expectedRowOff {
+			t.Errorf("(%s) after scrolling: got cursor %d, row offset %d, expected %d, %d", tc.description, e.Cursor, e.RowOff, tc.cursor, tc.expectedRowOff)
+		}
+
+		// The window doesn't follow the cursor until it's moved.
+		e.scroll(e.calcXY(e.Cursor))
+		if e.RowOff != tc.expectedRowOff {
+			t.Errorf("(%s) got row offset %d, expected %d", tc.description, e.RowOff, tc.expectedRowOff)
+		}
+
+		e.FollowCursor()
+		if e.Cursor != tc.expectedCursor {
+			t.Errorf("(%s) after following: got cursor %d, expected %d", tc.description, e.Cursor, tc.expectedCursor)
+		}
+	}
+}
+
+// TestScrollOff tests that the window scrolls to keep ScrollOff lines visible around the
+// cursor, as far as the document goes.
+func TestScrollOff(t *testing.T) {
+	tests := []struct {
+		description    string
+		scrollOff      int
+		y              int
+		rowOff         int
+		expectedRowOff int
+		cursor         int
+	}{
+		{description: "no margin", scrollOff: 0, y: 1, rowOff: 0, expectedRowOff: 0, cursor: 4},
+		{description: "scroll down before the edge", scrollOff: 2, y: 1, rowOff: 0, expectedRowOff: 1, cursor: 4},
+		{description: "scroll up before the edge", scrollOff: 2, y: -1, rowOff: 4, expectedRowOff: 3, cursor: 12},
+		{description: "margin wider than the window", scrollOff: 10, y: 1, rowOff: 0, expectedRowOff: 1, cursor: 4},
+		{description: "end of the document", scrollOff: 2, y: 1, rowOff: 6, expectedRowOff: 7, cursor: 20},
+	}
+
+	for _, tc := range tests {
+		e := NewEditor(EditorConfig{ScrollEnabled: true, ScrollOff: tc.scrollOff})
+		e.Width, e.Height = 10, 6
+		e.Text = []rune("0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11")
+		e.RowOff = tc.rowOff
+		e.Cursor = tc.cursor
+
+		e.MoveCursor(0, tc.y)
+
+		if e.RowOff != tc.expectedRowOff {
+			t.Errorf("(%s) got row offset %d, expected %d", tc.description, e.RowOff, tc.expectedRowOff)
+		}
+	}
+}
+
+// TestFitUsers tests that the users who don't fit in the info bar are counted instead.
+func TestFitUsers(t *testing.T) {
+	users := []User{{Name: "alice"}, {Name: "bob"}, {Name: "carol"}, {Name: "dave"}}
+	tests := []struct {
+		width    int
+		shown    int
+		expected string
+	}{
+		{width: 22, shown: 4, expected: ""},
+		{width: 21, shown: 4, expected: ""},
+		{width: 20, shown: 2, expected: "+2 others "},
+		{width: 16, shown: 1, expected: "+3 others "},
+		{width: 15, shown: 0, expected: "+4 others "},
+		{width: 3, shown: 0, expected: "+4 others "},
+	}
+
+	for _, tc := range tests {
+		shown, more := fitUsers(users, tc.width)
+		if shown != tc.shown || more != tc.expected {
+			t.Errorf("width %d: got %d, %q, expected %d, %q", tc.width, shown, more, tc.shown, tc.expected)
+		}
+	}
+}
+
+// TestDrawScreen checks that the editor is drawn on its screen: grapheme clusters in a
+// single cell with their combining marks, the cursor's line in its 24-bit background, and
+// the cursor itself.
+func TestDrawScreen(t *testing.T) {
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer screen.Fini()
+	screen.SetSize(20, 4)
+
+	highlight := tcell.NewHexColor(0x2c323c)
+	e := NewEditor(EditorConfig{Screen: screen, LineHighlight: highlight})
+	e.SetSize(screen.Size())
+	e.SetText("e\u0301x\nab")
+	e.Cursor = 2
+	e.Draw()
+
+	cells, width, _ := screen.GetContents()
+	cell := func(x, y int) tcell.SimCell { return cells[y*width+x] }
+	if got := string(cell(0, 0).Runes); got != "e\u0301" {
+		t.Errorf("got %q in the first cell, expected the cluster %q", got, "e\u0301")
+	}
+	if got := string(cell(1, 0).Runes); got != "x" {
+		t.Errorf("got %q in the second cell, expected %q", got, "x")
+	}
+	if _, bg, _ := cell(5, 0).Style.Decompose(); bg != highlight {
+		t.Errorf("got background %v on the cursor's line, expected %v", bg, highlight)
+	}
+	if _, bg, _ := cell(0, 1).Style.Decompose(); bg != tcell.ColorDefault {
+		t.Errorf("got background %v on the other line, expected the default one", bg)
+	}
+	if x, y, visible := screen.GetCursor(); x != 1 || y != 0 || !visible {
+		t.Errorf("got cursor at (%d, %d) (visible: %v), expected (1, 0)", x, y, visible)
+	}
+}
+
+// TestPalette checks that users are told apart by the palette's colors, wrapping around,
+// or by markers with the monochrome palette.
+func TestPalette(t *testing.T) {
+	alice, ninth := User{Name: "alice", Color: 0}, User{Name: "ninth", Color: 8}
+	red, blue := tcell.NewHexColor(0xe06c75), tcell.NewHexColor(0x61afef)
+	tests := []struct {
+		palette           string
+		userColors        []tcell.Color
+		color, ninthColor tcell.Color
+		label, ninthLabel string
+	}{
+		{palette: "", color: tcell.ColorGreen, label: "alice", ninthLabel: "ninth", ninthColor: tcell.ColorRed},
+		{palette: PaletteColorblind, color: tcell.ColorNavy, label: "alice", ninthLabel: "ninth", ninthColor: tcell.ColorOlive},
+		{palette: PaletteMonochrome, color: tcell.ColorDefault, label: "*alice", ninthLabel: "*ninth", ninthColor: tcell.ColorDefault},
+		{palette: "", userColors: []tcell.Color{red, blue, blue}, color: red, label: "alice", ninthLabel: "ninth", ninthColor: blue},
+		{palette: PaletteMonochrome, userColors: []tcell.Color{red}, color: tcell.ColorDefault, label: "*alice", ninthLabel: "*ninth", ninthColor: tcell.ColorDefault},
+	}
+
+	for _, tc := range tests {
+		e := NewEditor(EditorConfig{Palette: tc.palette, UserColors: tc.userColors})
+		if got := e.UserColor(alice); got != tc.color {
+			t.Errorf("%q: got color %v, expected %v", tc.palette, got, tc.color)
+		}
+		if got := e.UserColor(ninth); got != tc.ninthColor {
+			t.Errorf("%q: got color %v for the ninth user, expected %v", tc.palette, got, tc.ninthColor)
+		}
+		if got := e.UserLabel(alice); got != tc.label {
+			t.Errorf("%q: got label %q, expected %q", tc.palette, got, tc.label)
+		}
+		if got := e.UserLabel(ninth); got != tc.ninthLabel {
+			t.Errorf("%q: got label %q for the ninth user, expected %q", tc.palette, got, tc.ninthLabel)
+		}
+	}
+
+	if ValidPalette("sepia") {
+		t.Errorf("got palette %q valid, expected invalid", "sepia")
+	}
+}
+
+// TestParseInfoBar checks that layouts of the info bar are split into text and segments,
+// and that invalid layouts are rejected.
+func TestParseInfoBar(t *testing.T) {
+	tests := []struct {
+		layout   string
+		expected []infoBarItem
+		wantErr  bool
+	}{
+		{layout: DefaultInfoBar, expected: []infoBarItem{{segment: "users"}, {segment: "file"}, {text: " "}, {segment: "debug"}}},
+		{layout: "{time} | {position}", expected: []infoBarItem{{segment: "time"}, {text: " | "}, {segment: "position"}}},
+		{layout: "pairpad", expected: []infoBarItem{{text: "pairpad"}}},
+		{layout: "{clock}", wantErr: true},
+		{layout: "{users", wantErr: true},
+		{layout: "users}", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		items, err := parseInfoBar(tc.layout)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: got error %v, expected error: %v", tc.layout, err, tc.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(items, tc.expected) {
+			t.Errorf("%q: got %+v, expected %+v", tc.layout, items, tc.expected)
+		}
+	}
+}
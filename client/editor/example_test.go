@@ -0,0 +1,60 @@
+package editor_test
+
+import (
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/gdamore/tcell/v2"
+)
+
+// This example is a minimal editor of a plain text, kept by the application: the editor
+// only draws it, and moves the cursor.
+func Example() {
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return
+	}
+	if err := screen.Init(); err != nil {
+		return
+	}
+	defer screen.Fini()
+
+	e := editor.NewEditor(editor.EditorConfig{Screen: screen})
+	e.SetSize(screen.Size())
+	e.IsConnected = true
+
+	done := make(chan struct{})
+	defer close(done)
+	go e.DrawLoop(done)
+	go e.StatusLoop(done)
+	e.StatusChan <- "Press Esc to exit"
+
+	var text []rune
+	for {
+		e.SendDraw()
+
+		switch ev := screen.PollEvent().(type) {
+		case *tcell.EventResize:
+			e.Resize(ev.Size())
+		case *tcell.EventKey:
+			switch ev.Key() {
+			case tcell.KeyEsc:
+				return
+			case tcell.KeyLeft:
+				e.MoveCursor(-1, 0)
+			case tcell.KeyRight:
+				e.MoveCursor(1, 0)
+			case tcell.KeyUp:
+				e.MoveCursor(0, -1)
+			case tcell.KeyDown:
+				e.MoveCursor(0, 1)
+			case tcell.KeyRune, tcell.KeyEnter:
+				ch := ev.Rune()
+				if ev.Key() == tcell.KeyEnter {
+					ch = '\n'
+				}
+				text = append(text[:e.Cursor], append([]rune{ch}, text[e.Cursor:]...)...)
+				e.SetText(string(text))
+				e.MoveCursor(1, 0)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package editor
+
+import (
+	"github.com/mattn/go-runewidth"
+	"github.com/rivo/uniseg"
+)
+
+// The editor's text is stored as runes, but a single user-perceived character (a grapheme
+// cluster) can be made up of several runes, such as a letter followed by combining marks,
+// or emoji joined with zero-width joiners. The functions below are used to move the cursor
+// over, measure, and draw whole grapheme clusters.
+
+// graphemeBounds returns the indexes in text at which grapheme clusters start. The last
+// element is always len(text).
+func graphemeBounds(text []rune) []int {
+	bounds := make([]int, 0, len(text)+1)
+
+	i := 0
+	g := uniseg.NewGraphemes(string(text))
+	for g.Next() {
+		bounds = append(bounds, i)
+		i += len(g.Runes())
+	}
+
+	return append(bounds, i)
+}
+
+// clusterWidth returns the number of cells a grapheme cluster occupies in the terminal.
+func clusterWidth(cluster []rune) int {
+	if cluster[0] == '\t' {
+		return tabWidth
+	}
+	if len(cluster) == 1 {
+		return runewidth.RuneWidth(cluster[0])
+	}
+	return runewidth.StringWidth(string(cluster))
+}
+
+// moveByClusters returns the index reached by moving n grapheme clusters from index in
+// text. Moving from the middle of a cluster counts its remaining part as a cluster.
+func moveByClusters(text []rune, index, n int) int {
+	bounds := graphemeBounds(text)
+
+	for ; n > 0; n-- {
+		next := len(text)
+		for _, b := range bounds {
+			if b > index {
+				next = b
+				break
+			}
+		}
+		index = next
+	}
+
+	for ; n < 0; n++ {
+		prev := 0
+		for _, b := range bounds {
+			if b >= index {
+				break
+			}
+			prev = b
+		}
+		index = prev
+	}
+
+	return index
+}
+
+// clusterStart returns the index at which the grapheme cluster containing index starts.
+func clusterStart(text []rune, index int) int {
+	start := 0
+	for _, b := range graphemeBounds(text) {
+		if b > index {
+			break
+		}
+		start = b
+	}
+	return start
+}
+
+// ClusterLenBefore returns the number of runes in the grapheme cluster which ends at the cursor.
+// This is used to delete whole grapheme clusters.
+func (e *Editor) ClusterLenBefore() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	cursor := e.Cursor
+	if cursor > len(e.Text) {
+		cursor = len(e.Text)
+	}
+	if cursor <= 0 {
+		return 0
+	}
+
+	return cursor - moveByClusters(e.Text, cursor, -1)
+}
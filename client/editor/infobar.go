@@ -0,0 +1,194 @@
+package editor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// DefaultInfoBar is the layout of the info bar used when EditorConfig.InfoBar is empty.
+const DefaultInfoBar = "{users}{file} {debug}"
+
+// The segments of the info bar, written as "{name}" in its layout.
+const (
+	// segmentUsers is the names of the users in their colors, each followed by a space,
+	// and the count of those who don't fit ("+3 others ").
+	segmentUsers = "users"
+
+	// segmentFile is the file name, followed by "[+]" if there are unsaved changes, the
+	// state of its git repository, and its line endings and encoding.
+	segmentFile = "file"
+
+	// segmentPosition is the cursor's line and column, such as "Ln 3, Col 14".
+	segmentPosition = "position"
+
+	// segmentLatency is the round-trip time to the server, such as "42ms", once it's known.
+	segmentLatency = "latency"
+
+	// segmentTime is the time of day, such as "15:04".
+	segmentTime = "time"
+
+	// segmentSync is the state of the last local edit.
+	segmentSync = "sync"
+
+	// segmentDebug is the editor's debug information, followed by the state of the last
+	// local edit.
+	segmentDebug = "debug"
+)
+
+// segments holds the names of the info bar's segments.
+var segments = map[string]bool{
+	segmentUsers: true, segmentFile: true, segmentPosition: true, segmentLatency: true,
+	segmentTime: true, segmentSync: true, segmentDebug: true,
+}
+
+// An infoBarItem is a part of the info bar's layout: a segment, or text shown as it is if
+// segment is empty.
+type infoBarItem struct {
+	segment string
+	text    string
+}
+
+// ParseInfoBar checks the layout of an info bar: text in which segments are written
+// "{users}", "{file}", "{position}", "{latency}", "{time}", "{sync}" or "{debug}".
+func ParseInfoBar(layout string) error {
+	_, err := parseInfoBar(layout)
+	return err
+}
+
+// parseInfoBar returns the items of the info bar's layout.
+func parseInfoBar(layout string) ([]infoBarItem, error) {
+	var items []infoBarItem
+	for rest := layout; rest != ""; {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			items = append(items, infoBarItem{text: rest})
+			break
+		}
+		if rest[open] == '}' {
+			return nil, fmt.Errorf("unexpected \"}\" in info bar layout %q", layout)
+		}
+		if open > 0 {
+			items = append(items, infoBarItem{text: rest[:open]})
+		}
+
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed \"{\" in info bar layout %q", layout)
+		}
+		name := rest[open+1 : open+end]
+		if !segments[name] {
+			return nil, fmt.Errorf("unknown segment {%s} in info bar layout %q", name, layout)
+		}
+		items = append(items, infoBarItem{segment: name})
+		rest = rest[open+end+1:]
+	}
+	return items, nil
+}
+
+// SetLatency sets the round-trip time to the server, shown in the info bar's latency
+// segment. Zero means it isn't known.
+func (e *Editor) SetLatency(latency time.Duration) {
+	e.StatusMu.Lock()
+	e.latency = latency
+	e.StatusMu.Unlock()
+}
+
+// showsTime reports whether the info bar shows the time of day.
+func (e *Editor) showsTime() bool {
+	for _, item := range e.infoBar {
+		if item.segment == segmentTime {
+			return true
+		}
+	}
+	return false
+}
+
+// DrawInfoBar draws the segments of the info bar's layout at the bottom of the screen,
+// such as the names of the active users in the editing session and the file name.
+func (e *Editor) DrawInfoBar() {
+	e.StatusMu.Lock()
+	users := make([]User, len(e.Users))
+	for i, u := range e.Users {
+		users[i] = User{Name: e.UserLabel(u), Color: u.Color}
+	}
+	fileName := e.FileName
+	gitStatus := e.gitStatus
+	fileFormat := e.fileFormat
+	dirty := e.dirty
+	syncStatus := e.syncStatus
+	latency := e.latency
+	e.StatusMu.Unlock()
+
+	e.mu.RLock()
+	length := len(e.Text)
+	cursor := e.Cursor
+	e.mu.RUnlock()
+
+	if dirty {
+		fileName += " [+]"
+	}
+	if gitStatus != "" {
+		fileName += " (" + gitStatus + ")"
+	}
+	if fileFormat != "" {
+		fileName += " [" + fileFormat + "]"
+	}
+
+	cx, cy := e.calcXY(cursor)
+	debugInfo := fmt.Sprintf("x=%d, y=%d, cursor=%d, len(text)=%d", cx, cy, cursor, length)
+	if syncStatus != "" {
+		debugInfo += ", " + syncStatus
+	}
+
+	values := map[string]string{
+		segmentFile:     fileName,
+		segmentPosition: fmt.Sprintf("Ln %d, Col %d", cy, cx+1),
+		segmentTime:     time.Now().Format("15:04"),
+		segmentSync:     syncStatus,
+		segmentDebug:    debugInfo,
+	}
+	if latency > 0 {
+		values[segmentLatency] = fmt.Sprintf("%dms", latency.Milliseconds())
+	}
+
+	items := e.infoBar
+	if items == nil {
+		items, _ = parseInfoBar(DefaultInfoBar)
+	}
+
+	// The users get the room left by the rest of the bar, and at least a third of it. The
+	// -1 accounts for the connection indicator.
+	room := e.Width - 1
+	for _, item := range items {
+		if item.segment != segmentUsers {
+			room -= runewidth.StringWidth(item.text + values[item.segment])
+		}
+	}
+	if room < e.Width/3 {
+		room = e.Width / 3
+	}
+	shown, more := fitUsers(users, room)
+
+	x := 0
+	draw := func(s string, fg tcell.Color) {
+		for _, r := range s {
+			e.setCell(x, e.Height-1, r, tcell.StyleDefault.Foreground(fg))
+			x += runewidth.RuneWidth(r)
+		}
+	}
+	for _, item := range items {
+		if item.segment != segmentUsers {
+			draw(item.text+values[item.segment], tcell.ColorDefault)
+			continue
+		}
+		for _, user := range users[:shown] {
+			draw(user.Name, e.UserColor(user))
+			draw(" ", tcell.ColorDefault)
+		}
+		draw(more, tcell.ColorDefault)
+	}
+}
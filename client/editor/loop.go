@@ -0,0 +1,99 @@
+package editor
+
+import "time"
+
+const (
+	// defaultStatusDuration is used when EditorConfig.StatusDuration is zero.
+	defaultStatusDuration = 3 * time.Second
+
+	// defaultMaxFPS is used when EditorConfig.MaxFPS is zero.
+	defaultMaxFPS = 60
+)
+
+// DrawLoop draws the editor when SendDraw is called, until done is closed. Only DrawLoop
+// should draw the editor, so that drawing is never concurrent.
+//
+// The editor is drawn at most MaxFPS times per second: the draws requested while a frame
+// is waited for are coalesced into a single draw at its end, so a burst of operations
+// doesn't draw the editor for each of them. A draw requested after a pause is done at once.
+func (e *Editor) DrawLoop(done <-chan struct{}) {
+	coalesce(e.DrawChan, done, e.frameInterval, e.Draw)
+}
+
+// coalesce calls draw for the signals received from signals, at most once per interval,
+// until done is closed. The signals received before a draw are all handled by it.
+func coalesce(signals chan int, done <-chan struct{}, interval time.Duration, draw func()) {
+	var last time.Time
+	for {
+		select {
+		case <-done:
+			return
+		case <-signals:
+		}
+
+		if wait := interval - time.Since(last); wait > 0 {
+			select {
+			case <-done:
+				return
+			case <-time.After(wait):
+			}
+		}
+		for n := len(signals); n > 0; n-- {
+			<-signals
+		}
+
+		draw()
+		last = time.Now()
+	}
+}
+
+// StatusLoop shows the messages sent to StatusChan in the status bar, one after the other,
+// each for the configured StatusDuration, until done is closed.
+func (e *Editor) StatusLoop(done <-chan struct{}) {
+	for {
+		var msg string
+		select {
+		case <-done:
+			return
+		case msg = <-e.StatusChan:
+		}
+
+		e.StatusMu.Lock()
+		e.StatusMsg = msg
+		e.ShowMsg = true
+		e.StatusMu.Unlock()
+		if e.onStatus != nil {
+			e.onStatus(msg)
+		}
+		e.SendDraw()
+
+		select {
+		case <-done:
+			return
+		case <-time.After(e.statusDuration):
+		}
+
+		e.StatusMu.Lock()
+		e.ShowMsg = false
+		e.StatusMu.Unlock()
+		e.SendDraw()
+	}
+}
+
+// ClockLoop draws the editor at the start of every minute, so the time shown in the info
+// bar stays current, until done is closed. It returns at once if the info bar doesn't show
+// the time.
+func (e *Editor) ClockLoop(done <-chan struct{}) {
+	if !e.showsTime() {
+		return
+	}
+	for {
+		now := time.Now()
+		select {
+		case <-done:
+			return
+		case <-time.After(now.Truncate(time.Minute).Add(time.Minute).Sub(now)):
+			e.SendDraw()
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package editor
+
+import "github.com/gdamore/tcell/v2"
+
+// The palettes telling users apart, selected by EditorConfig.Palette.
+const (
+	// PaletteDefault displays users in ten colors.
+	PaletteDefault = "default"
+
+	// PaletteColorblind displays users in colors which are told apart with the common
+	// forms of color blindness: it leaves out red and green.
+	PaletteColorblind = "colorblind"
+
+	// PaletteMonochrome displays users without colors, marking their names and cursors
+	// with symbols instead.
+	PaletteMonochrome = "monochrome"
+)
+
+// palettes holds the colors of the palettes with colors. They're the terminal's 16 standard
+// colors, so they follow its theme: tcell names them after the colors they usually are,
+// such as tcell.ColorOlive for the terminal's yellow, and tcell.ColorYellow for its bright
+// yellow.
+var palettes = map[string][]tcell.Color{
+	PaletteDefault: {
+		tcell.ColorGreen,
+		tcell.ColorOlive,
+		tcell.ColorNavy,
+		tcell.ColorPurple,
+		tcell.ColorTeal,
+		tcell.ColorYellow,
+		tcell.ColorFuchsia,
+		tcell.ColorLime,
+		tcell.ColorRed,
+		tcell.ColorMaroon,
+	},
+	PaletteColorblind: {
+		tcell.ColorNavy,
+		tcell.ColorOlive,
+		tcell.ColorTeal,
+		tcell.ColorFuchsia,
+		tcell.ColorSilver,
+		tcell.ColorBlue,
+		tcell.ColorYellow,
+	},
+}
+
+// userMarkers are the symbols telling users apart in the monochrome palette.
+var userMarkers = []rune("*+#@%&~^")
+
+// ValidPalette reports whether name is the name of a palette, or empty for the default one.
+func ValidPalette(name string) bool {
+	_, ok := palettes[name]
+	return ok || name == "" || name == PaletteMonochrome
+}
+
+// wrap returns the index of a user's color in a palette of n colors or markers.
+func wrap(color, n int) int {
+	idx := color % n
+	if idx < 0 {
+		idx += n
+	}
+	return idx
+}
+
+// UserColor returns the color in which a user is displayed, which is tcell.ColorDefault
+// with the monochrome palette.
+func (e *Editor) UserColor(u User) tcell.Color {
+	if e.monochrome {
+		return tcell.ColorDefault
+	}
+	palette := e.palette
+	if palette == nil {
+		palette = palettes[PaletteDefault]
+	}
+	return palette[wrap(u.Color, len(palette))]
+}
+
+// UserMarker returns the symbol marking a user with the monochrome palette, or 0 with the
+// other palettes.
+func (e *Editor) UserMarker(u User) rune {
+	if !e.monochrome {
+		return 0
+	}
+	return userMarkers[wrap(u.Color, len(userMarkers))]
+}
+
+// UserLabel returns the name of a user, preceded by its marker with the monochrome palette.
+func (e *Editor) UserLabel(u User) string {
+	if m := e.UserMarker(u); m != 0 {
+		return string(m) + u.Name
+	}
+	return u.Name
+}
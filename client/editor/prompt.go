@@ -0,0 +1,112 @@
+package editor
+
+import (
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// A Prompt is a question shown in the status bar. While a prompt is shown, key presses
+// answer it instead of editing the document.
+type Prompt struct {
+	// Text is the question, including the accepted answers.
+	Text string
+
+	// Answers maps the keys which answer the prompt to the functions called when they're
+	// pressed. Keys are matched case-insensitively.
+	Answers map[rune]func() error
+
+	// Cancel is called if the prompt is dismissed with Esc or Ctrl+C. It may be nil.
+	Cancel func() error
+
+	// Submit, if not nil, makes the prompt read a line of text instead of a single key.
+	// It's called with the text when Enter is pressed, and Answers is ignored.
+	Submit func(text string) error
+
+	// input holds the text typed so far, if Submit is set.
+	input []rune
+}
+
+// ShowPrompt shows p in the status bar, replacing any previous prompt.
+func (e *Editor) ShowPrompt(p *Prompt) {
+	e.StatusMu.Lock()
+	e.prompt = p
+	e.StatusMu.Unlock()
+}
+
+// PromptActive reports whether a prompt is shown.
+func (e *Editor) PromptActive() bool {
+	e.StatusMu.Lock()
+	defer e.StatusMu.Unlock()
+	return e.prompt != nil
+}
+
+// AnswerPrompt answers the shown prompt with a key press. If the key answers the prompt,
+// or dismisses it, the prompt is closed and the answer's function is called, returning its
+// error. For prompts reading text, other keys edit the text, and are otherwise ignored.
+func (e *Editor) AnswerPrompt(ev *tcell.EventKey) error {
+	e.StatusMu.Lock()
+	p := e.prompt
+	if p == nil {
+		e.StatusMu.Unlock()
+		return nil
+	}
+
+	var answer func() error
+	if ev.Key() == tcell.KeyEsc || ev.Key() == tcell.KeyCtrlC {
+		answer = p.Cancel
+	} else if p.Submit != nil {
+		switch ev.Key() {
+		case tcell.KeyEnter:
+			text := string(p.input)
+			answer = func() error { return p.Submit(text) }
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if len(p.input) > 0 {
+				p.input = p.input[:len(p.input)-1]
+			}
+		case tcell.KeyRune:
+			p.input = append(p.input, ev.Rune())
+		}
+		if answer == nil {
+			e.StatusMu.Unlock()
+			return nil
+		}
+	} else if f, ok := p.Answers[unicode.ToLower(ev.Rune())]; ok && ev.Key() == tcell.KeyRune {
+		answer = f
+	} else {
+		e.StatusMu.Unlock()
+		return nil
+	}
+	e.prompt = nil
+	e.StatusMu.Unlock()
+
+	if answer == nil {
+		return nil
+	}
+	return answer()
+}
+
+// DrawPrompt draws the shown prompt at the bottom of the screen, and moves the
+// cursor after it.
+func (e *Editor) DrawPrompt() {
+	e.StatusMu.Lock()
+	p := e.prompt
+	e.StatusMu.Unlock()
+	if p == nil {
+		return
+	}
+
+	e.StatusMu.Lock()
+	input := string(p.input)
+	e.StatusMu.Unlock()
+
+	x := 0
+	for _, r := range p.Text + " " + input {
+		e.setCell(x, e.Height-1, r, tcell.StyleDefault.Bold(true))
+		x += runewidth.RuneWidth(r)
+	}
+	if e.screen != nil {
+		e.screen.ShowCursor(x, e.Height-1)
+	}
+}
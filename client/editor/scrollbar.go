@@ -0,0 +1,105 @@
+package editor
+
+import "github.com/gdamore/tcell/v2"
+
+// A Cursor is another user's cursor, shown as a marker on the scrollbar.
+type Cursor struct {
+	// Index is the index of the rune after the cursor.
+	Index int
+
+	// User is the user whose cursor it is.
+	User User
+}
+
+// SetCursors sets the other users' cursors.
+func (e *Editor) SetCursors(cursors []Cursor) {
+	e.StatusMu.Lock()
+	e.cursors = cursors
+	e.StatusMu.Unlock()
+}
+
+// textWidth returns the number of columns of the text area, which leaves the rightmost
+// column to the scrollbar if it's enabled.
+func (e *Editor) textWidth() int {
+	if e.Scrollbar && e.Width > 1 {
+		return e.Width - 1
+	}
+	return e.Width
+}
+
+// scrollbarThumb returns the rows of a scrollbar of the given height covered by the
+// window, from start up to, but not including, end. top is the first line in the window,
+// and lines is the number of lines in the document.
+func scrollbarThumb(lines, top, height int) (start, end int) {
+	total := scrollbarTotal(lines, top, height)
+	start = top * height / total
+	end = ((top+height)*height + total - 1) / total
+	if end <= start {
+		end = start + 1
+	}
+	if end > height {
+		end = height
+	}
+	return start, end
+}
+
+// scrollbarRow returns the row of a scrollbar of the given height standing for line.
+func scrollbarRow(line, lines, top, height int) int {
+	row := line * height / scrollbarTotal(lines, top, height)
+	if row >= height {
+		row = height - 1
+	}
+	return row
+}
+
+// scrollbarTotal returns the number of lines the scrollbar stands for: all of the
+// document's lines, and the empty lines shown past its end.
+func scrollbarTotal(lines, top, height int) int {
+	if top+height > lines {
+		return top + height
+	}
+	return lines
+}
+
+// drawScrollbar draws the scrollbar in the rightmost column of the focused pane: the thumb
+// shows the part of the document in the pane, and the other users' cursors are marked
+// in their colors, or with their markers with the monochrome palette.
+func (e *Editor) drawScrollbar(text []rune) {
+	paneTop, height := e.paneRows(true)
+	if height < 1 || e.Width < 2 {
+		return
+	}
+
+	lines := 1
+	for _, r := range text {
+		if r == '\n' {
+			lines++
+		}
+	}
+
+	x := e.Width - 1
+	top := e.GetRowOff()
+	start, end := scrollbarThumb(lines, top, height)
+	bg := make([]tcell.Style, height)
+	for row := range bg {
+		ch := '│'
+		if row >= start && row < end {
+			ch, bg[row] = ' ', tcell.StyleDefault.Background(tcell.ColorSilver)
+		}
+		e.setCell(x, paneTop+row, ch, bg[row])
+	}
+
+	e.StatusMu.Lock()
+	cursors := e.cursors
+	e.StatusMu.Unlock()
+
+	for _, c := range cursors {
+		_, y := e.calcXY(c.Index)
+		row := scrollbarRow(y-1, lines, top, height)
+		marker := '='
+		if m := e.UserMarker(c.User); m != 0 {
+			marker = m
+		}
+		e.setCell(x, paneTop+row, marker, bg[row].Foreground(e.UserColor(c.User)).Bold(true))
+	}
+}
@@ -0,0 +1,54 @@
+package editor
+
+import "unicode"
+
+// A SpellChecker reports whether words are spelled correctly.
+type SpellChecker interface {
+	Correct(word string) bool
+}
+
+// misspelled returns the ranges of the words of text which checker doesn't know. Words
+// which look like code, because they contain digits or underscores, or capitals after
+// their first letter, are skipped, and so are single letters and the word ending at the
+// cursor, which is still being typed.
+func misspelled(text []rune, cursor int, checker SpellChecker) []Range {
+	var ranges []Range
+	for i := 0; i < len(text); {
+		if !isWordRune(text[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		code := false
+		for ; i < len(text); i++ {
+			r := text[i]
+			if isApostrophe(r) && i+1 < len(text) && unicode.IsLetter(text[i+1]) {
+				// An apostrophe between letters, as in "don't".
+				continue
+			}
+			if !isWordRune(r) {
+				break
+			}
+			code = code || unicode.IsDigit(r) || r == '_' || (i > start && unicode.IsUpper(r))
+		}
+
+		if code || i-start < 2 || i == cursor {
+			continue
+		}
+		if !checker.Correct(string(text[start:i])) {
+			ranges = append(ranges, Range{Start: start, End: i})
+		}
+	}
+	return ranges
+}
+
+// isWordRune reports whether r is part of a word, or of an identifier.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// isApostrophe reports whether r is an apostrophe, which may be part of a word.
+func isApostrophe(r rune) bool {
+	return r == '\'' || r == '’'
+}
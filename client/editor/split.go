@@ -0,0 +1,89 @@
+package editor
+
+import "github.com/gdamore/tcell/v2"
+
+// A pane is a viewport of the document, which keeps its scroll offsets and its cursor
+// while the other pane has the focus.
+type pane struct {
+	rowOff, colOff, cursor int
+}
+
+// ToggleSplit splits the text area into two panes, one above the other, or joins them back
+// into the focused one. Both panes start out showing the same part of the document, and
+// the top one has the focus.
+func (e *Editor) ToggleSplit() {
+	if e.split {
+		e.split = false
+	} else {
+		e.split, e.splitTop = true, true
+		e.other = pane{rowOff: e.RowOff, colOff: e.ColOff, cursor: e.Cursor}
+	}
+
+	if e.ScrollEnabled {
+		e.scroll(e.calcXY(e.Cursor))
+	}
+}
+
+// IsSplit reports whether the text area is split into two panes.
+func (e *Editor) IsSplit() bool {
+	return e.split
+}
+
+// SwitchPane gives the focus to the other pane, moving the cursor back to where it was in
+// it. It does nothing if the text area isn't split.
+func (e *Editor) SwitchPane() {
+	if !e.split {
+		return
+	}
+
+	next := e.other
+	e.other = pane{rowOff: e.RowOff, colOff: e.ColOff, cursor: e.Cursor}
+	e.RowOff, e.ColOff = next.rowOff, next.colOff
+	e.splitTop = !e.splitTop
+	e.detached = false
+
+	// The document may have changed since the pane had the focus.
+	e.mu.Lock()
+	if next.cursor > len(e.Text) {
+		next.cursor = len(e.Text)
+	}
+	e.Cursor = clusterStart(e.Text, next.cursor)
+	e.mu.Unlock()
+
+	if e.ScrollEnabled {
+		e.scroll(e.calcXY(e.Cursor))
+	}
+}
+
+// paneRows returns the first row on the screen, and the number of rows, of the focused
+// pane if focused is set, or of the other one. Without a split, the pane is the whole text
+// area above the status bar. The panes are separated by a divider row, and the top one
+// gets the extra row when they can't be as high.
+func (e *Editor) paneRows(focused bool) (top, rows int) {
+	total := e.Height - 1 // -1 accounts for the status bar
+	if !e.split {
+		return 0, total
+	}
+
+	upper := total / 2
+	lower := total - upper - 1
+	if focused == e.splitTop {
+		return 0, upper
+	}
+	return upper + 1, lower
+}
+
+// textHeight returns the number of rows of the focused pane.
+func (e *Editor) textHeight() int {
+	_, rows := e.paneRows(true)
+	return rows
+}
+
+// drawDivider draws the row between the two panes.
+func (e *Editor) drawDivider() {
+	// The top pane is the focused one if splitTop is set.
+	_, rows := e.paneRows(e.splitTop)
+	for x := 0; x < e.Width; x++ {
+		e.setCell(x, rows, '─', tcell.StyleDefault)
+	}
+}
@@ -0,0 +1,80 @@
+package editor
+
+// ScrollView scrolls the focused pane by rows rows, down if rows is positive, without
+// moving the cursor, as long as the document's last line stays in the pane. The cursor may
+// be left out of the pane, until FollowCursor brings it back.
+func (e *Editor) ScrollView(rows int) {
+	if !e.ScrollEnabled {
+		return
+	}
+
+	e.mu.RLock()
+	lines := 1
+	for _, r := range e.Text {
+		if r == '\n' {
+			lines++
+		}
+	}
+	e.mu.RUnlock()
+
+	e.RowOff += rows
+	if e.RowOff > lines-1 {
+		e.RowOff = lines - 1
+	}
+	if e.RowOff < 0 {
+		e.RowOff = 0
+	}
+	e.detached = true
+}
+
+// FollowCursor moves the cursor into the focused pane, to the nearest line in it, if the
+// pane was scrolled away from it with ScrollView. It's called before the user types or
+// moves the cursor, so that they edit the part of the document they see.
+func (e *Editor) FollowCursor() {
+	if !e.detached {
+		return
+	}
+	e.detached = false
+
+	x, y := e.calcXY(e.Cursor)
+	first, last := e.RowOff+1, e.RowOff+e.textHeight()
+	switch {
+	case y < first:
+		y = first
+	case y > last:
+		y = last
+	default:
+		return
+	}
+
+	e.mu.Lock()
+	e.Cursor = indexAt(e.Text, x, y)
+	e.mu.Unlock()
+}
+
+// cursorVisible reports whether the cursor is in the focused pane's rows.
+func (e *Editor) cursorVisible() bool {
+	_, y := e.calcXY(e.Cursor)
+	return y > e.RowOff && y <= e.RowOff+e.textHeight()
+}
+
+// indexAt returns the index of the grapheme cluster at the cell (x, y), as returned by
+// calcXY, or of the end of the line if it's shorter, or of the end of the text if it has
+// fewer lines.
+func indexAt(text []rune, x, y int) int {
+	bounds := graphemeBounds(text)
+	cx, cy := 1, 1
+	for i := 0; i < len(bounds)-1; i++ {
+		cluster := text[bounds[i]:bounds[i+1]]
+		if cy == y && (cx >= x || cluster[0] == '\n') {
+			return bounds[i]
+		}
+		if cluster[0] == '\n' {
+			cx = 1
+			cy++
+		} else {
+			cx += clusterWidth(cluster)
+		}
+	}
+	return len(text)
+}
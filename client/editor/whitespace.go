@@ -0,0 +1,55 @@
+package editor
+
+import "github.com/gdamore/tcell/v2"
+
+// tabWidth is the number of cells a tab character occupies.
+const tabWidth = 4
+
+// Glyphs used to draw whitespace when the editor's ShowWhitespace option is set.
+const (
+	trailingSpaceGlyph = '·'
+	tabGlyph           = '→'
+	nbspGlyph          = '␣'
+)
+
+// trailingWhitespace reports, for each grapheme cluster delimited by bounds, whether it's
+// whitespace at the end of a line.
+func trailingWhitespace(text []rune, bounds []int) []bool {
+	trailing := make([]bool, len(bounds)-1)
+
+	inTrail := true
+	for i := len(bounds) - 2; i >= 0; i-- {
+		switch text[bounds[i]] {
+		case '\n':
+			inTrail = true
+		case ' ', '\t', '\u00a0':
+			trailing[i] = inTrail
+		default:
+			inTrail = false
+		}
+	}
+
+	return trailing
+}
+
+// whitespaceGlyph returns the glyph and color used to draw a whitespace cluster when
+// ShowWhitespace is set. It returns false if the cluster is drawn as usual.
+func whitespaceGlyph(cluster []rune, trailing bool) (rune, tcell.Color, bool) {
+	fg := tcell.ColorNavy
+	if trailing {
+		fg = tcell.ColorMaroon
+	}
+
+	switch cluster[0] {
+	case '\t':
+		return tabGlyph, fg, true
+	case '\u00a0':
+		return nbspGlyph, fg, true
+	case ' ':
+		if trailing {
+			return trailingSpaceGlyph, fg, true
+		}
+	}
+
+	return 0, 0, false
+}
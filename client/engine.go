@@ -5,135 +5,377 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf16"
+	"unicode/utf8"
 
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/client/plugin"
 	"github.com/burntcarrot/pairpad/commons"
 	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gdamore/tcell/v2"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"github.com/nsf/termbox-go"
 	"github.com/sirupsen/logrus"
 )
 
-// handleTermboxEvent handles key input by updating the local CRDT document
-// and sending a message over the WebSocket connection.
-func handleTermboxEvent(ev termbox.Event, conn *websocket.Conn) error {
-	// We only want to deal with termbox key events (EventKey).
-	if ev.Type == termbox.EventKey {
-		switch ev.Key {
-
-		// The default keys for exiting an session are Esc and Ctrl+C.
-		case termbox.KeyEsc, termbox.KeyCtrlC:
-			// Return an error with the prefix "pairpad", so that it gets treated as an exit "event".
-			return errors.New("pairpad: exiting")
-
-		// The default key for saving the editor's contents is Ctrl+S.
-		case termbox.KeyCtrlS:
-			// If no file name is specified, set filename to "pairpad-content.txt"
-			if fileName == "" {
-				fileName = "pairpad-content.txt"
-			}
+// handleEvent handles the terminal's events: resizes, pastes, and key input (see
+// handleKey).
+func handleEvent(ev tcell.Event, conn *websocket.Conn) error {
+	switch ev := ev.(type) {
+	// Redraw the editor at its new size when the terminal is resized.
+	case *tcell.EventResize:
+		e.Resize(ev.Size())
+		e.SendDraw()
+
+	// The text of a bracketed paste is inserted at once when it ends.
+	case *tcell.EventPaste:
+		if handlePaste(ev, conn) {
+			refreshView(conn)
+		}
+
+	case *tcell.EventKey:
+		if collectPaste(ev) {
+			return nil
+		}
+		return handleKey(ev, conn)
+	}
+	return nil
+}
+
+// handleKey handles key input by updating the local CRDT document and sending a message
+// over the WebSocket connection.
+func handleKey(ev *tcell.EventKey, conn *websocket.Conn) error {
+	// While a prompt is shown, keys answer it.
+	if e.PromptActive() {
+		err := e.AnswerPrompt(ev)
+		e.SendDraw()
+		return err
+	}
+
+	// While the outline is shown, the arrow keys, Enter and Esc move around it.
+	if showOutline && handleOutlineKey(ev) {
+		refreshOutline()
+		e.SendDraw()
+		return nil
+	}
+
+	// While a block is selected, typing edits each of its lines.
+	if blockMode && handleBlockKey(ev, conn) {
+		refreshView(conn)
+		return nil
+	}
 
-			// Save the CRDT to a file.
-			err := crdt.Save(fileName, &doc)
+	telemetry.countKey(ev.Key())
+	switch ev.Key() {
+
+	// The default keys for exiting an session are Esc and Ctrl+C.
+	case tcell.KeyEsc, tcell.KeyCtrlC:
+		if !e.IsDirty() {
+			return errExit
+		}
+
+		// With unsaved changes, ask whether to save them first.
+		e.ShowPrompt(&editor.Prompt{
+			Text: "Save before exit? (y/n/cancel)",
+			Answers: map[rune]func() error{
+				'y': func() error {
+					// If saving fails, stay in the editor, so the changes aren't lost.
+					return saveThen(conn, func() error { return errExit })
+				},
+				'n': func() error { return errExit },
+				'c': func() error { return nil },
+			},
+		})
+
+	// The default key for saving the editor's contents is Ctrl+S.
+	case tcell.KeyCtrlS:
+		save(conn)
+
+	// The default key for loading content from a file is Ctrl+L.
+	case tcell.KeyCtrlL:
+		if fileName != "" {
+			logger.Log(logrus.InfoLevel, "LOADING DOCUMENT")
+			newDoc, err := loadFile(fileName)
 			if err != nil {
-				logrus.Errorf("Failed to save to %s", fileName)
-				e.StatusChan <- fmt.Sprintf("Failed to save to %s", fileName)
+				logrus.Errorf("failed to load file %s", fileName)
+				e.StatusChan <- fmt.Sprintf("Failed to load %s", fileName)
 				return err
 			}
+			e.StatusChan <- fmt.Sprintf("Loading %s", fileName)
+			doc = newDoc
+			crdt.SyncClock(doc)
+			e.SetX(0)
+			e.SetText(crdt.Content(doc))
+			e.SetDirty(false)
+			refreshFileFormat()
+
+			// The loaded document's characters have new IDs, so the comments and the
+			// other users' selections can't be anchored anymore.
+			setAnnotations(nil)
+			clearRemoteSelections()
+
+			logger.Log(logrus.InfoLevel, "SENDING DOCUMENT")
+			docMsg := commons.NewDocSyncMessage(doc, uuid.Nil)
+			_ = writeMessage(conn, docMsg)
+		} else {
+			e.StatusChan <- "No file to load!"
+		}
 
-			// Set the status bar.
-			e.StatusChan <- fmt.Sprintf("Saved document to %s", fileName)
-
-		// The default key for loading content from a file is Ctrl+L.
-		case termbox.KeyCtrlL:
-			if fileName != "" {
-				logger.Log(logrus.InfoLevel, "LOADING DOCUMENT")
-				newDoc, err := crdt.Load(fileName)
-				if err != nil {
-					logrus.Errorf("failed to load file %s", fileName)
-					e.StatusChan <- fmt.Sprintf("Failed to load %s", fileName)
-					return err
-				}
-				e.StatusChan <- fmt.Sprintf("Loading %s", fileName)
-				doc = newDoc
-				e.SetX(0)
-				e.SetText(crdt.Content(doc))
-
-				logger.Log(logrus.InfoLevel, "SENDING DOCUMENT")
-				docMsg := commons.Message{Type: commons.DocSyncMessage, Document: doc}
-				_ = conn.WriteJSON(&docMsg)
+	// In debugging mode, Ctrl+O toggles an overlay showing the CRDT's conflict counters.
+	case tcell.KeyCtrlO:
+		if flags.Debug {
+			showStats = !showStats
+			showAnnotations, showDocStats, showParticipants, showOutline, showPerf = false, false, false, false, false
+			if showStats {
+				printStats()
 			} else {
-				e.StatusChan <- "No file to load!"
+				e.SetOverlay(nil)
 			}
+		}
 
-		// The default keys for moving left inside the text area are the left arrow key, and Ctrl+B (move backward).
-		case termbox.KeyArrowLeft, termbox.KeyCtrlB:
-			e.MoveCursor(-1, 0)
+	// Ctrl+K marks the start of a range to comment on, and then asks for the comment.
+	case tcell.KeyCtrlK:
+		markAnnotation(conn)
 
-		// The default keys for moving right inside the text area are the right arrow key, and Ctrl+F (move forward).
-		case termbox.KeyArrowRight, termbox.KeyCtrlF:
-			e.MoveCursor(1, 0)
+	// Ctrl+D deletes the comment at the cursor.
+	case tcell.KeyCtrlD:
+		deleteAnnotation(conn)
 
-		// The default keys for moving up inside the text area are the up arrow key, and Ctrl+P (move to previous line).
-		case termbox.KeyArrowUp, termbox.KeyCtrlP:
-			e.MoveCursor(0, -1)
+	// For interviewers, Ctrl+Q inserts a read-only question prompt at the cursor.
+	case tcell.KeyCtrlQ:
+		insertPrompt(conn)
 
-		// The default keys for moving down inside the text area are the down arrow key, and Ctrl+N (move to next line).
-		case termbox.KeyArrowDown, termbox.KeyCtrlN:
-			e.MoveCursor(0, 1)
+	// For interviewers, Ctrl+E gives or removes the candidates' edit access.
+	case tcell.KeyCtrlE:
+		toggleAccess(conn)
 
-		// Home key, moves cursor to initial position (X=0).
-		case termbox.KeyHome:
-			e.SetX(0)
+	// Ctrl+W commits the saved file to its git repository, if git is enabled.
+	case tcell.KeyCtrlW:
+		commitFile(conn)
 
-		// End key, moves cursor to final position (X= length of text).
-		case termbox.KeyEnd:
-			e.SetX(len(e.Text))
-
-		// The default keys for deleting a character are Backspace and Delete.
-		case termbox.KeyBackspace, termbox.KeyBackspace2:
-			performOperation(OperationDelete, ev, conn)
-		case termbox.KeyDelete:
-			performOperation(OperationDelete, ev, conn)
-
-		// The Tab key inserts 4 spaces to simulate a "tab".
-		case termbox.KeyTab:
-			for i := 0; i < 4; i++ {
-				ev.Ch = ' '
-				performOperation(OperationInsert, ev, conn)
-			}
+	// Ctrl+T asks the other users for their attention.
+	case tcell.KeyCtrlT:
+		sendPing(conn)
 
-		// The Enter key inserts a newline character to the editor's content.
-		case termbox.KeyEnter:
-			ev.Ch = '\n'
-			performOperation(OperationInsert, ev, conn)
+	// Ctrl+G toggles the panel listing the comments.
+	case tcell.KeyCtrlG:
+		showAnnotations = !showAnnotations
+		showStats, showDocStats, showParticipants, showOutline, showPerf = false, false, false, false, false
+		if !showAnnotations {
+			e.SetOverlay(nil)
+		}
 
-		// The Space key inserts a space character to the editor's content.
-		case termbox.KeySpace:
-			ev.Ch = ' '
-			performOperation(OperationInsert, ev, conn)
+	// Ctrl+U toggles an overlay showing the document's statistics.
+	case tcell.KeyCtrlU:
+		showDocStats = !showDocStats
+		showStats, showAnnotations, showParticipants, showOutline, showPerf = false, false, false, false, false
+		if !showDocStats {
+			e.SetOverlay(nil)
+		}
 
-		// Every other key is eligible to be a candidate for insertion.
-		default:
-			if ev.Ch != 0 {
-				performOperation(OperationInsert, ev, conn)
+	// Ctrl+A toggles an overlay listing the participants, with their roles and latencies.
+	case tcell.KeyCtrlA:
+		showParticipants = !showParticipants
+		showStats, showAnnotations, showDocStats, showOutline, showPerf = false, false, false, false, false
+		if !showParticipants {
+			e.SetOverlay(nil)
+		}
+
+	// F12 toggles an overlay showing the frame time, the rates of operations and the
+	// queues, to find out why the editor feels slow.
+	case tcell.KeyF12:
+		togglePerf()
+
+	// F3 starts a block (rectangular) selection, whose lines are all edited by typing, or
+	// ends it.
+	case tcell.KeyF3:
+		toggleBlock()
+
+	// In Markdown files, F2 toggles an outline of the document's headings, to jump between
+	// its sections.
+	case tcell.KeyF2:
+		toggleOutline()
+
+	// Ctrl+V splits the window into two panes showing different parts of the document,
+	// or joins them back, and F6 moves the focus to the other pane.
+	case tcell.KeyCtrlV:
+		e.ToggleSplit()
+	case tcell.KeyF6:
+		e.SwitchPane()
+
+	// Ctrl+X converts the file's line endings between LF and CRLF.
+	case tcell.KeyCtrlX:
+		convertNewlines(conn)
+
+	// The default keys for moving left inside the text area are the left arrow key, and Ctrl+B (move backward).
+	// With Ctrl or Alt, the left arrow key moves to the start of the previous word.
+	case tcell.KeyLeft, tcell.KeyCtrlB:
+		e.FollowCursor()
+		if ev.Key() == tcell.KeyLeft && wordModifier(ev) {
+			e.MoveCursorWords(-1)
+		} else {
+			e.MoveCursor(-1, 0)
+		}
+
+	// The default keys for moving right inside the text area are the right arrow key, and Ctrl+F (move forward).
+	// With Ctrl or Alt, the right arrow key moves to the end of the next word.
+	case tcell.KeyRight, tcell.KeyCtrlF:
+		e.FollowCursor()
+		if ev.Key() == tcell.KeyRight && wordModifier(ev) {
+			e.MoveCursorWords(1)
+		} else {
+			e.MoveCursor(1, 0)
+		}
+
+	// The default keys for moving up inside the text area are the up arrow key, and Ctrl+P (move to previous line).
+	case tcell.KeyUp, tcell.KeyCtrlP:
+		e.FollowCursor()
+		e.MoveCursor(0, -1)
+
+	// The default keys for moving down inside the text area are the down arrow key, and Ctrl+N (move to next line).
+	case tcell.KeyDown, tcell.KeyCtrlN:
+		e.FollowCursor()
+		e.MoveCursor(0, 1)
+
+	// Ctrl+Y and Ctrl+Z scroll the window up and down by a line, leaving the cursor where
+	// it is. It's moved into the window once the user types or moves it.
+	case tcell.KeyCtrlY:
+		e.ScrollView(-1)
+	case tcell.KeyCtrlZ:
+		e.ScrollView(1)
+
+	// Home key, moves cursor to initial position (X=0).
+	case tcell.KeyHome:
+		e.SetX(0)
+
+	// End key, moves cursor to final position (X= length of text).
+	case tcell.KeyEnd:
+		e.SetX(len(e.Text))
+
+	// The default keys for deleting a character are Backspace and Delete.
+	// The whole grapheme cluster before the cursor is deleted, one rune at a time.
+	case tcell.KeyBackspace, tcell.KeyBackspace2, tcell.KeyDelete:
+		for n := e.ClusterLenBefore(); n > 0; n-- {
+			if !performOperation(OperationDelete, 0, conn) {
+				break
 			}
 		}
+
+	// The Tab key expands the snippet whose trigger is before the cursor, or else inserts
+	// 4 spaces to simulate a "tab".
+	case tcell.KeyTab:
+		if expandSnippet(conn) {
+			telemetry.count("snippet")
+			break
+		}
+		for i := 0; i < 4; i++ {
+			if !performOperation(OperationInsert, ' ', conn) {
+				break
+			}
+		}
+
+	// The Enter key inserts a newline character to the editor's content.
+	case tcell.KeyEnter:
+		performOperation(OperationInsert, '\n', conn)
+
+	// Ctrl+Space starts or clears the selection shown to the other users. Terminals send it
+	// as a NUL, but some report a space with the Ctrl modifier instead.
+	case tcell.KeyCtrlSpace:
+		toggleSelection()
+
+	// Every other key typing a rune inserts it, the space bar included.
+	case tcell.KeyRune:
+		if ev.Rune() == ' ' && ev.Modifiers()&tcell.ModCtrl != 0 {
+			toggleSelection()
+			break
+		}
+		ch, ok := composeRune(ev.Rune())
+		if !ok {
+			return nil
+		}
+		if !autoPairRune(ch, conn) {
+			performOperation(OperationInsert, ch, conn)
+		}
 	}
 
-	e.SendDraw()
+	refreshView(conn)
 	return nil
 }
 
+// refreshView refreshes the overlays and selections after a key, sends the local selection
+// to the other clients, and redraws the editor.
+func refreshView(conn *websocket.Conn) {
+	refreshAnnotations()
+	refreshDocStats()
+	refreshParticipants()
+	refreshOutline()
+	sendSelection(conn)
+	refreshSelections()
+	e.SendDraw()
+}
+
+// maxDocReqRetries is the number of times a corrupted document is requested again.
+const maxDocReqRetries = 3
+
+// docReqRetries is the number of times the document has been requested again since a
+// valid document was last received.
+var docReqRetries int
+
+// errExit is returned by handleKey when the user exits the editor. It has the
+// prefix "pairpad", so that it gets treated as an exit "event".
+var errExit = errors.New("pairpad: exiting")
+
+// pendingSurrogate holds the first half of a UTF-16 surrogate pair. On Windows, tcell
+// reports each half of a character outside the Basic Multilingual Plane (for example,
+// emoji and CJK extension characters committed by an IME) as a separate key event.
+var pendingSurrogate rune
+
+// composeRune combines the halves of UTF-16 surrogate pairs into a single rune, so that
+// they're inserted as one character. It returns false if ch doesn't complete a character.
+func composeRune(ch rune) (rune, bool) {
+	if !utf16.IsSurrogate(ch) {
+		pendingSurrogate = 0
+		return ch, true
+	}
+
+	if pendingSurrogate == 0 {
+		pendingSurrogate = ch
+		return 0, false
+	}
+
+	r := utf16.DecodeRune(pendingSurrogate, ch)
+	pendingSurrogate = 0
+	if r == utf8.RuneError {
+		logger.Errorf("dropping invalid surrogate pair in input")
+		return 0, false
+	}
+	return r, true
+}
+
+// wordModifier reports whether a key is pressed with Ctrl or Alt, which make the left and
+// right arrow keys move by words. Terminals differ in which of them they report.
+func wordModifier(ev *tcell.EventKey) bool {
+	return ev.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) != 0
+}
+
 const (
 	OperationInsert = iota
 	OperationDelete
 )
 
 // performOperation performs a CRDT insert or delete operation on the local document and sends a message over the WebSocket connection.
-func performOperation(opType int, ev termbox.Event, conn *websocket.Conn) {
+// It returns false if the user isn't allowed to edit the document at the cursor.
+func performOperation(opType int, r rune, conn *websocket.Conn) bool {
+	e.FollowCursor()
+	if reason := editDenied(opType); reason != "" {
+		e.StatusChan <- reason
+		return false
+	}
+
 	// Get position and value.
-	ch := string(ev.Ch)
+	ch := string(r)
 
 	var msg commons.Message
 
@@ -142,15 +384,14 @@ func performOperation(opType int, ev termbox.Event, conn *websocket.Conn) {
 	case OperationInsert:
 		logger.Infof("LOCAL INSERT: %s at cursor position %v\n", ch, e.Cursor)
 
-		text, err := doc.Insert(e.Cursor+1, ch)
+		op, err := doc.GenerateOperation(commons.Operation{Type: "insert", Position: e.Cursor + 1, Value: ch})
 		if err != nil {
-			e.SetText(text)
 			logger.Errorf("CRDT error: %v\n", err)
 		}
-		e.SetText(text)
+		e.SetText(crdt.Content(doc))
 
-		e.MoveCursor(1, 0)
-		msg = commons.Message{Type: "operation", Operation: commons.Operation{Type: "insert", Position: e.Cursor, Value: ch}}
+		e.MoveCursorRunes(1)
+		msg = commons.Message{Type: commons.OperationMessage, Operation: op}
 
 	case OperationDelete:
 		logger.Infof("LOCAL DELETE: cursor position %v\n", e.Cursor)
@@ -159,50 +400,112 @@ func performOperation(opType int, ev termbox.Event, conn *websocket.Conn) {
 			e.Cursor = 0
 		}
 
-		text := doc.Delete(e.Cursor)
-		e.SetText(text)
+		op, _ := doc.GenerateOperation(commons.Operation{Type: "delete", Position: e.Cursor})
+		e.SetText(crdt.Content(doc))
 
-		msg = commons.Message{Type: "operation", Operation: commons.Operation{Type: "delete", Position: e.Cursor}}
-		e.MoveCursor(-1, 0)
+		msg = commons.Message{Type: commons.OperationMessage, Operation: op}
+		e.MoveCursorRunes(-1)
 	}
+	e.SetDirty(true)
+	validateDoc(doc, fmt.Sprintf("local %s at %d", msg.Operation.Type, msg.Operation.Position))
 
 	// Send the message.
 	if e.IsConnected {
-		err := conn.WriteJSON(msg)
+		err := sendOperation(msg.Operation, conn)
 		if err != nil {
 			e.IsConnected = false
 			e.StatusChan <- "lost connection!"
 		}
+	} else if flags.Debug {
+		// The operation is never sent, which shows as a pending sync.
+		tracker.next()
+	}
+
+	if opType == OperationInsert {
+		plugin.LocalInsert(clientSession{conn}, msg.Operation.Position, ch)
 	}
+	return true
 }
 
-// getTermboxChan returns a channel of termbox Events repeatedly waiting on user input.
-func getTermboxChan() chan termbox.Event {
-	termboxChan := make(chan termbox.Event)
+// getEventChan returns a channel of the screen's events, repeatedly waiting on user input
+// until the screen is finalized.
+func getEventChan(screen tcell.Screen) chan tcell.Event {
+	eventChan := make(chan tcell.Event)
 
 	go func() {
 		for {
-			termboxChan <- termbox.PollEvent()
+			ev := screen.PollEvent()
+			if ev == nil {
+				return
+			}
+			eventChan <- ev
 		}
 	}()
 
-	return termboxChan
+	return eventChan
 }
 
 // handleMsg updates the CRDT document with the contents of the message.
 func handleMsg(msg commons.Message, conn *websocket.Conn) {
+	// Drop malformed messages (which may come from other implementations of the protocol),
+	// rather than applying them to the document.
+	if err := msg.Validate(); err != nil {
+		logger.Errorf("dropping malformed message %+v: %v\n", msg, err)
+		return
+	}
+
 	switch msg.Type {
 	case commons.DocSyncMessage:
 		logger.Infof("DOCSYNC RECEIVED, updating local doc %+v\n", msg.Document)
 
+		// Check that the document wasn't corrupted on the way. If it was, keep the local
+		// document, and request the document again.
+		if err := msg.Verify(); err != nil {
+			logger.Errorf("INTEGRITY ERROR: received a corrupted document: %v\n", err)
+			if docReqRetries >= maxDocReqRetries {
+				e.StatusChan <- "Received a corrupted document, giving up"
+				break
+			}
+			docReqRetries++
+			e.StatusChan <- "Received a corrupted document, requesting it again"
+			_ = writeMessage(conn, commons.Message{Type: commons.DocReqMessage})
+			break
+		}
+		docReqRetries = 0
+
+		// The session's document differs from the saved one if it changes the content.
+		content := crdt.Content(msg.Document)
+		if content != string(e.GetText()) {
+			e.SetDirty(true)
+		}
+
 		doc = msg.Document
-		e.SetText(crdt.Content(doc))
+		crdt.SyncClock(doc)
+		e.SetText(content)
+		setAnnotations(msg.Annotations)
+		setPrompts(msg.Prompts)
+		clearRemoteSelections()
+
+		// A file imported before joining is shared with the session if the session's
+		// document is empty. Otherwise, the session's document is kept.
+		if importPending {
+			importPending = false
+			if crdt.Content(doc) == "" {
+				shareText(importText, conn)
+			} else if recovered {
+				e.StatusChan <- keepRecovered()
+			} else {
+				e.StatusChan <- fmt.Sprintf("Joined a session with existing content, %s wasn't imported", fileName)
+			}
+		}
 
 	case commons.DocReqMessage:
 		logger.Infof("DOCREQ RECEIVED, sending local document to %v\n", msg.ID)
 
-		docMsg := commons.Message{Type: commons.DocSyncMessage, Document: doc, ID: msg.ID}
-		_ = conn.WriteJSON(&docMsg)
+		docMsg := commons.NewDocSyncMessage(doc, msg.ID)
+		docMsg.Annotations = sessionAnnotations()
+		docMsg.Prompts = sessionPrompts()
+		_ = writeMessage(conn, docMsg)
 
 	case commons.SiteIDMessage:
 		siteID, err := strconv.Atoi(msg.Text)
@@ -213,36 +516,185 @@ func handleMsg(msg commons.Message, conn *websocket.Conn) {
 		crdt.SiteID = siteID
 		logger.Infof("SITE ID %v, INTENDED SITE ID: %v", crdt.SiteID, siteID)
 
+		// A client which kept its site ID continues from the clock of its last character,
+		// so its new characters don't reuse the IDs of the previous ones. The site ID may
+		// also have been used to generate characters of the document before.
+		siteToken = msg.Token
+		if prevSite != nil && prevSite.SiteID == msg.Text {
+			crdt.AdvanceClock(prevSite.Clock)
+		}
+		crdt.SyncClock(doc)
+
+		// Now that the site ID is known, import the plain text file, if any.
+		if importText != "" {
+			imported, err := crdt.FromText(importText)
+			if err != nil {
+				logger.Errorf("failed to import %s, err: %v\n", fileName, err)
+				e.StatusChan <- fmt.Sprintf("Failed to import %s", fileName)
+				break
+			}
+			doc = imported
+			importPending = true
+			e.SetText(crdt.Content(doc))
+		}
+
 	case commons.JoinMessage:
 		e.StatusChan <- fmt.Sprintf("%s has joined the session!", msg.Username)
+		plugin.UserJoin(clientSession{conn}, msg.Username)
+
+	case commons.JoinAckMessage:
+		nameToken = msg.Token
+		if msg.Username != username {
+			e.StatusChan <- fmt.Sprintf("The name %s is already taken, so you joined as %s", username, msg.Username)
+			username = msg.Username
+		}
+
+	case commons.AnnotationMessage:
+		if msg.Annotation == nil {
+			break
+		}
+		if err := addAnnotation(*msg.Annotation); err != nil {
+			logger.Errorf("failed to anchor annotation %s, err: %v\n", msg.Annotation.ID, err)
+			break
+		}
+		if !msg.Annotation.Deleted {
+			e.StatusChan <- fmt.Sprintf("%s commented: %s", msg.Annotation.Author, msg.Annotation.Text)
+		}
+
+	case commons.PingMessage:
+		handlePing(msg.Username, time.Now())
+
+	case commons.SelectionMessage:
+		if err := setRemoteSelection(msg.ID, msg.Username, *msg.Selection); err != nil {
+			logger.Errorf("failed to anchor the selection of %s, err: %v\n", msg.Username, err)
+		}
+
+	case commons.LeaveMessage:
+		delete(remoteSelections, msg.ID)
+		switch msg.Text {
+		case commons.LeaveReasonKicked:
+			e.StatusChan <- fmt.Sprintf("%s was kicked from the session", msg.Username)
+		case commons.LeaveReasonConnectionLost:
+			e.StatusChan <- fmt.Sprintf("%s lost connection to the session", msg.Username)
+		default:
+			e.StatusChan <- fmt.Sprintf("%s has left the session", msg.Username)
+		}
 
 	case commons.UsersMessage:
+		var users []editor.User
+		participants = msg.Users
+		if len(msg.Users) > 0 {
+			for _, u := range msg.Users {
+				users = append(users, editor.User{Name: u.Name, Color: u.Color})
+				siteNames[u.SiteID] = u.Name
+
+				if u.SiteID == strconv.Itoa(crdt.SiteID) {
+					e.SetLatency(time.Duration(u.Latency) * time.Millisecond)
+
+					// Clients joining while candidates are read-only learn it from the list.
+					if !isInterviewer() {
+						readOnly = u.ReadOnly
+					}
+				}
+			}
+		} else {
+			// Older servers only send the names, so colors are assigned by position.
+			for i, name := range strings.Split(msg.Text, ",") {
+				users = append(users, editor.User{Name: name, Color: i})
+				participants = append(participants, commons.User{Name: name, Color: i})
+			}
+		}
+
 		e.StatusMu.Lock()
-		e.Users = strings.Split(msg.Text, ",")
+		e.Users = users
 		e.StatusMu.Unlock()
 
+	case commons.NoticeMessage:
+		e.StatusChan <- msg.Text
+
+	case commons.PromptMessage:
+		if err := addPrompt(*msg.Annotation); err != nil {
+			logger.Errorf("failed to anchor prompt %s, err: %v\n", msg.Annotation.ID, err)
+		}
+
+	case commons.AccessMessage:
+		if isInterviewer() || (msg.Username != "" && msg.Username != username) {
+			break
+		}
+		readOnly = msg.Text == commons.AccessReadOnly
+		if readOnly {
+			e.StatusChan <- "An interviewer made the document read-only"
+		} else {
+			e.StatusChan <- "An interviewer gave you edit access"
+		}
+
+	case commons.AckMessage:
+		tracker.update(msg.Seq, syncAcked)
+
+	case commons.ErrorMessage:
+		logger.Errorf("server error: %s", msg.Text)
+		e.StatusChan <- fmt.Sprintf("Server error: %s", msg.Text)
+		if msg.Seq > 0 {
+			tracker.update(msg.Seq, syncRejected)
+		}
+
+		// Undo inserts rejected by the server, since the other clients never received them.
+		// Deleted characters can't be restored locally, so the document is requested again.
+		switch msg.Operation.Type {
+		case "insert":
+			// The inserted characters are deleted wherever they are now, if the server sent
+			// them back.
+			if insert := msg.Operation.Insert; insert != nil {
+				for _, c := range insert.Characters {
+					if position, _ := (crdt.DeleteOp{Character: c}).Apply(&doc); position > 0 && position <= e.Cursor {
+						e.MoveCursorRunes(-1)
+					}
+				}
+			} else {
+				_ = doc.Delete(msg.Operation.Position)
+				if msg.Operation.Position <= e.Cursor {
+					e.MoveCursorRunes(-1)
+				}
+			}
+			e.SetText(crdt.Content(doc))
+		case "delete":
+			_ = writeMessage(conn, commons.Message{Type: commons.DocReqMessage})
+		}
+
 	default:
+		atomic.AddInt64(&opsReceived, 1)
+		// The operation's characters may have moved since the sender made it, so the cursor
+		// is moved according to the position it was applied at here.
+		position, err := msg.Operation.Apply(&doc)
+		if errors.Is(err, crdt.ErrCharacterNotFound) {
+			// The sender had characters which this document doesn't have, so it's out of
+			// date: it's replaced with the server's, or another client's.
+			logger.Errorf("failed to apply operation, err: %v\n", err)
+			e.StatusChan <- "Missed an edit, requesting the document again"
+			_ = writeMessage(conn, commons.Message{Type: commons.DocReqMessage})
+		}
 		switch msg.Operation.Type {
 		case "insert":
-			_, err := doc.Insert(msg.Operation.Position, msg.Operation.Value)
-			if err != nil {
+			if err != nil && !errors.Is(err, crdt.ErrCharacterNotFound) {
 				logger.Errorf("failed to insert, err: %v\n", err)
 			}
 
 			e.SetText(crdt.Content(doc))
-			if msg.Operation.Position-1 <= e.Cursor {
-				e.MoveCursor(len(msg.Operation.Value), 0)
+			if position > 0 && position-1 <= e.Cursor {
+				e.MoveCursorRunes(utf8.RuneCountInString(msg.Operation.Value))
 			}
-			logger.Infof("REMOTE INSERT: %s at position %v\n", msg.Operation.Value, msg.Operation.Position)
+			e.SetDirty(true)
+			logger.Infof("REMOTE INSERT: %s at position %v\n", msg.Operation.Value, position)
 
 		case "delete":
-			_ = doc.Delete(msg.Operation.Position)
 			e.SetText(crdt.Content(doc))
-			if msg.Operation.Position-1 <= e.Cursor {
-				e.MoveCursor(-len(msg.Operation.Value), 0)
+			if position > 0 && position <= e.Cursor {
+				e.MoveCursorRunes(-1)
 			}
-			logger.Infof("REMOTE DELETE: position %v\n", msg.Operation.Position)
+			e.SetDirty(true)
+			logger.Infof("REMOTE DELETE: position %v\n", position)
 		}
+		plugin.RemoteOperation(clientSession{conn}, msg.Operation)
 	}
 
 	// printDoc is used for debugging purposes. Don't comment this out.
@@ -250,29 +702,79 @@ func handleMsg(msg commons.Message, conn *websocket.Conn) {
 	// The default behavior for printDoc is to NOT log anything.
 	// This is to ensure that the debug logs don't take up much space on the user's filesystem, and can be toggled on demand.
 	printDoc(doc)
+	validateDoc(doc, fmt.Sprintf("%s message from %s", msg.Type, msg.Username))
+	printStats()
+	refreshAnnotations()
+	refreshDocStats()
+	refreshParticipants()
+	refreshOutline()
+	refreshSelections()
 
 	e.SendDraw()
 }
 
+// shareText inserts text at the start of the local document, and sends the inserts to
+// the other clients.
+func shareText(text string, conn *websocket.Conn) {
+	for i, r := range []rune(text) {
+		op, err := doc.GenerateOperation(commons.Operation{Type: "insert", Position: i + 1, Value: string(r)})
+		if err != nil {
+			logger.Errorf("CRDT error: %v\n", err)
+			break
+		}
+
+		if err := sendOperation(op, conn); err != nil {
+			e.IsConnected = false
+			e.StatusChan <- "lost connection!"
+			break
+		}
+	}
+
+	e.SetText(crdt.Content(doc))
+}
+
 // getMsgChan returns a message channel that repeatedly reads from a websocket connection.
 func getMsgChan(conn *websocket.Conn) chan commons.Message {
 	messageChan := make(chan commons.Message)
 	go func() {
-		for {
-			var msg commons.Message
+		if greeting != nil {
+			messageChan <- *greeting
+			greeting = nil
+		}
+
+		// Replays never hear from their stand-in server.
+		if replay == nil {
+			stop := keepAlive(conn)
+			defer stop()
+		}
 
+		for {
 			// Read message.
-			err := conn.ReadJSON(&msg)
+			msg, err := readMessage(conn)
 			if err != nil {
 				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 					logger.Errorf("websocket error: %v", err)
 				}
 				e.IsConnected = false
-				e.StatusChan <- "lost connection!"
+				// Show why the server closed the connection, if it said.
+				var closeErr *websocket.CloseError
+				if isReadLimit(err) {
+					logger.Errorf("message too large: %v", err)
+					e.StatusChan <- tooLargeStatus()
+				} else if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseMessageTooBig {
+					e.StatusChan <- "disconnected: the server doesn't accept messages this large"
+				} else if errors.As(err, &closeErr) && closeErr.Text != "" {
+					e.StatusChan <- "disconnected: " + closeErr.Text
+				} else {
+					e.StatusChan <- "lost connection!"
+				}
 				break
 			}
 
 			logger.Infof("message received: %+v\n", msg)
+			if replay == nil {
+				extendReadDeadline(conn)
+			}
 
 			// send message through channel
 			messageChan <- msg
@@ -281,33 +783,3 @@ func getMsgChan(conn *websocket.Conn) chan commons.Message {
 	}()
 	return messageChan
 }
-
-// handleStatusMsg asynchronously waits for messages from e.StatusChan and
-// displays the message when it arrives.
-func handleStatusMsg() {
-	for msg := range e.StatusChan {
-		e.StatusMu.Lock()
-		e.StatusMsg = msg
-		e.ShowMsg = true
-		e.StatusMu.Unlock()
-
-		logger.Infof("got status message: %s", e.StatusMsg)
-
-		e.SendDraw()
-		time.Sleep(3 * time.Second)
-
-		e.StatusMu.Lock()
-		e.ShowMsg = false
-		e.StatusMu.Unlock()
-
-		e.SendDraw()
-	}
-
-}
-
-func drawLoop() {
-	for {
-		<-e.DrawChan
-		e.Draw()
-	}
-}
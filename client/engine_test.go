@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// sink returns a connection to a WebSocket server which discards the messages it gets.
+func sink(t testing.TB) *websocket.Conn {
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// FuzzHandleMsg feeds a stream of arbitrary messages to handleMsg, as getMsgChan decodes
+// them, and checks that it doesn't panic, and that the editor stays in sync with a
+// well-formed document.
+func FuzzHandleMsg(f *testing.F) {
+	logger.SetOutput(io.Discard)
+	conn := sink(f)
+
+	hello, _ := crdt.FromText("hello")
+	var seed []byte
+	for _, msg := range []commons.Message{
+		{Type: commons.SiteIDMessage, Text: "2"},
+		commons.NewDocSyncMessage(hello, uuid.Nil),
+		{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: 6, Value: "!"}},
+		{Type: commons.AnnotationMessage, Annotation: &commons.Annotation{ID: "a", Start: 1, End: 5, Text: "hi"}},
+		{Type: commons.PromptMessage, Annotation: &commons.Annotation{ID: "p", Start: 2, End: 3}},
+		{Type: commons.OperationMessage, Operation: commons.Operation{Type: "delete", Position: 1}},
+		{Type: commons.ErrorMessage, Text: "rejected", Seq: 1, Operation: commons.Operation{Type: "insert", Position: 1, Value: "x"}},
+		{Type: commons.DocReqMessage, ID: uuid.New()},
+		{Type: commons.UsersMessage, Users: []commons.User{{Name: "a", SiteID: "2"}}},
+		{Type: commons.AccessMessage, Text: commons.AccessReadOnly},
+		{Type: commons.SelectionMessage, ID: uuid.New(), Username: "a", Selection: &commons.Selection{Start: 2, End: 4}},
+		{Type: commons.PingMessage, Username: "a"},
+	} {
+		data, _ := json.Marshal(msg)
+		seed = append(seed, data...)
+	}
+	f.Add(seed)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		doc, e = crdt.New(), editor.NewEditor(editor.EditorConfig{})
+		username, importText, importPending, docReqRetries, readOnly = "fuzz", "", false, 0, false
+		setAnnotations(nil)
+		setPrompts(nil)
+		clearRemoteSelections()
+		selectionMark, sentSelection = -1, commons.Selection{}
+
+		// Nothing shows the status messages and draws, so drain them.
+		done := make(chan struct{})
+		defer close(done)
+		go func(e *editor.Editor) {
+			for {
+				select {
+				case <-e.StatusChan:
+				case <-e.DrawChan:
+				case <-done:
+					return
+				}
+			}
+		}(e)
+
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var msg commons.Message
+			if err := dec.Decode(&msg); err != nil {
+				break
+			}
+			handleMsg(msg, conn)
+
+			if got, expected := string(e.GetText()), crdt.Content(doc); got != expected {
+				t.Fatalf("got editor text %q, expected %q after %+v", got, expected, msg)
+			}
+			if e.Cursor < 0 || e.Cursor > len(e.GetText()) {
+				t.Fatalf("got cursor %d, expected it within [0, %d] after %+v", e.Cursor, len(e.GetText()), msg)
+			}
+		}
+
+		if err := commons.NewDocSyncMessage(doc, uuid.Nil).Validate(); err != nil {
+			t.Errorf("invalid document after %s: %v", data, err)
+		}
+	})
+}
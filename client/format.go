@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/burntcarrot/pairpad/client/plugin"
+)
+
+// formatTimeout bounds the time a formatter may take, since the editor waits for it.
+const formatTimeout = 10 * time.Second
+
+// formatPlugin returns a plugin formatting the document on save, with the command configured
+// for the file's extension in commands. The command is run by the shell, with the document on
+// its standard input and the file name in PAIRPAD_FILE, and prints the formatted document.
+// The changes are applied as operations, so the other clients get the formatted document.
+func formatPlugin(commands map[string]string) plugin.Plugin {
+	return plugin.Plugin{
+		Name: "format",
+		OnSave: func(s plugin.Session, fileName string) error {
+			command, ok := commands[filepath.Ext(fileName)]
+			if !ok {
+				return nil
+			}
+
+			text := s.Text()
+			formatted, err := format(command, fileName, text)
+			if err != nil {
+				return err
+			}
+			if formatted != text {
+				s.SetText(formatted)
+			}
+			return nil
+		},
+	}
+}
+
+// format runs a formatter's command on text, and returns its output.
+func format(command, fileName, text string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), formatTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := shellCommand(ctx, command)
+	cmd.Env = append(os.Environ(), "PAIRPAD_FILE="+fileName)
+	cmd.Stdin = strings.NewReader(text)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("%s timed out", command)
+		}
+		// Formatters report syntax errors on their standard error: show the first one.
+		if msg, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); msg != "" {
+			return "", fmt.Errorf("%s: %s", command, msg)
+		}
+		return "", fmt.Errorf("%s: %w", command, err)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/gorilla/websocket"
+)
+
+// gitTimeout bounds the time a git command may take, since the editor waits for it.
+const gitTimeout = 10 * time.Second
+
+// useGit is set by the config file to commit the saved file to its git repository with
+// Ctrl+W, and show the repository's state in the status bar.
+var useGit bool
+
+// git runs git with args in the directory of the file, and returns its output. Errors
+// include the first line git printed on its standard error.
+func git(file string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = filepath.Dir(file)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("git %s timed out", args[0])
+		}
+		if msg, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// gitStatus returns the branch of the repository containing file, and whether the file has
+// changes which aren't committed. ok is false if the file isn't inside a repository.
+func gitStatus(file string) (branch string, dirty, ok bool) {
+	branch, err := git(file, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		// A repository without commits has no HEAD yet, but its branch has a name.
+		if branch, err = git(file, "symbolic-ref", "--short", "HEAD"); err != nil {
+			return "", false, false
+		}
+	}
+
+	changes, err := git(file, "status", "--porcelain", "--", filepath.Base(file))
+	return branch, err == nil && changes != "", true
+}
+
+// gitCommit stages file and commits it, alone, with message.
+func gitCommit(file, message string) error {
+	base := filepath.Base(file)
+	if _, err := git(file, "add", "--", base); err != nil {
+		return err
+	}
+	_, err := git(file, "commit", "-m", message, "--", base)
+	return err
+}
+
+// refreshGitStatus shows the branch and state of the saved file's repository in the status
+// bar, if git is enabled.
+func refreshGitStatus() {
+	if !useGit || fileName == "" {
+		return
+	}
+
+	status := ""
+	if branch, dirty, ok := gitStatus(fileName); ok {
+		status = branch
+		if dirty {
+			status += "*"
+		}
+	}
+	e.SetGitStatus(status)
+}
+
+// commitFile asks for a commit message, then saves the document and commits the file to its
+// git repository.
+func commitFile(conn *websocket.Conn) {
+	if !useGit {
+		e.StatusChan <- "Enable git in the config file to commit from the editor"
+		return
+	}
+	if replay != nil {
+		e.StatusChan <- "Not committed while replaying"
+		return
+	}
+
+	e.ShowPrompt(&editor.Prompt{
+		Text: "Commit message: ",
+		Submit: func(message string) error {
+			message = strings.TrimSpace(message)
+			if message == "" {
+				e.StatusChan <- "Not committed: the commit message is empty"
+				return nil
+			}
+
+			// The file is saved first, so the commit has the document as it's shown.
+			return saveThen(conn, func() error {
+				if err := gitCommit(fileName, message); err != nil {
+					logger.Errorf("failed to commit %s: %v\n", fileName, err)
+					e.StatusChan <- fmt.Sprintf("Failed to commit: %v", err)
+				} else {
+					e.StatusChan <- fmt.Sprintf("Committed %s", fileName)
+				}
+				refreshGitStatus()
+				return nil
+			})
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestGit checks that the state of a file's repository is reported, and that committing
+// the file leaves it without uncommitted changes.
+func TestGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "alice")
+	t.Setenv("GIT_AUTHOR_EMAIL", "alice@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "alice")
+	t.Setenv("GIT_COMMITTER_EMAIL", "alice@example.com")
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "notes.txt")
+	if _, _, ok := gitStatus(file); ok {
+		t.Fatal("got a repository, expected none")
+	}
+
+	if _, err := git(file, "init", "--initial-branch=main"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if branch, dirty, ok := gitStatus(file); !ok || branch != "main" || !dirty {
+		t.Errorf("got branch %q (dirty: %v, ok: %v), expected main with changes", branch, dirty, ok)
+	}
+
+	if err := gitCommit(file, "Add notes"); err != nil {
+		t.Fatal(err)
+	}
+	if branch, dirty, ok := gitStatus(file); !ok || branch != "main" || dirty {
+		t.Errorf("got branch %q (dirty: %v, ok: %v), expected main without changes", branch, dirty, ok)
+	}
+	if msg, err := git(file, "log", "-1", "--format=%s"); err != nil || msg != "Add notes" {
+		t.Errorf("got last commit %q (%v), expected %q", msg, err, "Add notes")
+	}
+}
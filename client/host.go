@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/burntcarrot/pairpad/server"
+	"github.com/burntcarrot/pairpad/server/discovery"
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultHostPort is the port the embedded server listens on, unless -port is set.
+const defaultHostPort = 8080
+
+// A host is a server embedded in the client, started by pairpad host.
+type host struct {
+	server *server.Server
+	http   *http.Server
+	port   int
+
+	// logs is the writer the server logs to, into the client's logger.
+	logs *io.PipeWriter
+
+	// advertiser advertises the session on the local network, if it's advertised.
+	advertiser *discovery.Advertiser
+}
+
+// startHost starts a server listening on port, or on a free port if it's 0, and serves it
+// in the background. The server's logs go to the client's logger, so they don't show over
+// the editor.
+func startHost(port int) (*host, error) {
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return nil, err
+	}
+
+	logs := logger.WriterLevel(logrus.InfoLevel)
+	log.SetOutput(logs)
+	color.Output, color.NoColor = logs, true
+
+	s := server.New(server.Config{})
+	h := &host{
+		server: s,
+		http:   &http.Server{ReadHeaderTimeout: 10 * time.Second, IdleTimeout: 2 * time.Minute, Handler: s.Handler()},
+		port:   l.Addr().(*net.TCPAddr).Port,
+		logs:   logs,
+	}
+	go func() {
+		if err := h.http.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Errorf("embedded server failed: %v", err)
+		}
+	}()
+	return h, nil
+}
+
+// stop closes the clients' connections, and stops the server. The logs of the shutdown are
+// dropped, as the client's log files are closed by then.
+func (h *host) stop() {
+	if h.advertiser != nil {
+		_ = h.advertiser.Close()
+	}
+	log.SetOutput(io.Discard)
+	color.Output = io.Discard
+	_ = h.logs.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = h.http.Shutdown(ctx)
+	_ = h.server.Shutdown(ctx)
+}
+
+// advertise advertises the session of the room on the local network, named after the user
+// and the machine.
+func (h *host) advertise(room string) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	instance := fmt.Sprintf("%s on %s", username, hostname)
+	h.advertiser, err = discovery.Advertise(discovery.Session{Instance: instance, Port: h.port, Room: room})
+	return err
+}
+
+// addresses returns the addresses at which the other machines can reach the server: the
+// IPv4 addresses of the network interfaces other than the loopback one, with its port.
+func (h *host) addresses() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var hosts []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		hosts = append(hosts, net.JoinHostPort(ipNet.IP.String(), strconv.Itoa(h.port)))
+	}
+	return hosts
+}
+
+// printInvite tells how the others can join the hosted room, and waits for Enter, so the
+// addresses can be copied before the editor starts.
+func (h *host) printInvite(room string, in *bufio.Scanner, out io.Writer) {
+	fmt.Fprintf(out, "Hosting %s on port %d.", describeRoom(room), h.port)
+	addrs := h.addresses()
+	if len(addrs) == 0 {
+		addrs = []string{net.JoinHostPort("localhost", strconv.Itoa(h.port))}
+		fmt.Fprint(out, " No network interface was found, so only this machine can join.")
+	}
+	fmt.Fprint(out, " Others can join with:\n\n")
+	for _, addr := range addrs {
+		join := "pairpad join " + addr
+		web := "http://" + addr + "/web/"
+		if room != "" {
+			join += " " + room
+			web += "?room=" + url.QueryEscape(room)
+		}
+		fmt.Fprintf(out, "  %s\n  %s (in a browser)\n\n", join, web)
+	}
+	fmt.Fprint(out, "The session ends when you exit the editor. Press Enter to start it.")
+	in.Scan()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+)
+
+// TestHost checks that the client connects to the server it hosts, and that the invite
+// names the room.
+func TestHost(t *testing.T) {
+	h, err := startHost(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		h.stop()
+		log.SetOutput(os.Stderr)
+	})
+
+	var out strings.Builder
+	h.printInvite("team-a", bufio.NewScanner(strings.NewReader("\n")), &out)
+	if !strings.Contains(out.String(), "room team-a on port "+strconv.Itoa(h.port)) || !strings.Contains(out.String(), "?room=team-a") {
+		t.Errorf("got invite %q, expected it to name the room and the port", out.String())
+	}
+
+	flags, err := parseArgs([]string{"host", "-port", "0", "team-a"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags.Server = "localhost:" + strconv.Itoa(h.port)
+	conn, err := connect(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if greeting == nil || greeting.Type != commons.SiteIDMessage {
+		t.Errorf("got greeting %+v, expected the site ID", greeting)
+	}
+}
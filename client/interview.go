@@ -0,0 +1,196 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// A prompt is a read-only block of the local document, added by an interviewer. Like
+// annotations, it's anchored to the document's characters.
+type prompt struct {
+	id     string
+	author string
+	text   string
+	anchor crdt.Anchor
+}
+
+var (
+	// prompts holds the session's prompt blocks.
+	prompts []prompt
+
+	// readOnly is set while an interviewer has removed this client's edit access.
+	readOnly bool
+
+	// candidatesReadOnly is set while this interviewer has removed the candidates' edit
+	// access.
+	candidatesReadOnly bool
+)
+
+// isInterviewer reports whether this client joined as an interviewer.
+func isInterviewer() bool {
+	return flags.Interviewer != ""
+}
+
+// addPrompt anchors a prompt block received from the server (or added locally) to the local
+// document. Prompts with an existing ID replace the existing prompt, and deleted prompts
+// are removed.
+func addPrompt(a commons.Annotation) error {
+	for i, p := range prompts {
+		if p.id == a.ID {
+			prompts = append(prompts[:i], prompts[i+1:]...)
+			break
+		}
+	}
+	if a.Deleted {
+		return nil
+	}
+
+	anchor, err := anchorAnnotation(&a)
+	if err != nil {
+		return err
+	}
+	prompts = append(prompts, prompt{id: a.ID, author: a.Author, text: a.Text, anchor: anchor})
+	return nil
+}
+
+// sessionPrompts returns the prompt blocks with their current positions, to be sent with
+// the document.
+func sessionPrompts() []commons.Annotation {
+	var list []commons.Annotation
+	for _, p := range prompts {
+		if start, end, ok := doc.Range(p.anchor); ok {
+			list = append(list, commons.Annotation{ID: p.id, Author: p.author, Text: p.text, Start: start, End: end,
+				StartID: p.anchor.StartID, EndID: p.anchor.EndID})
+		}
+	}
+	return list
+}
+
+// setPrompts replaces the prompt blocks by the ones received with a document sync.
+func setPrompts(list []commons.Annotation) {
+	prompts = nil
+	for _, a := range list {
+		if err := addPrompt(a); err != nil {
+			logger.Errorf("failed to anchor prompt %s, err: %v\n", a.ID, err)
+		}
+	}
+}
+
+// editDenied returns the reason for which the user can't perform a local operation at the
+// cursor, or an empty string if they can: candidates can't edit the document without edit
+// access, nor inside prompt blocks. The server rejects these edits as well.
+func editDenied(opType int) string {
+	if isInterviewer() {
+		return ""
+	}
+	if readOnly {
+		return "You don't have edit access"
+	}
+
+	for _, p := range prompts {
+		start, end, ok := doc.Range(p.anchor)
+		if !ok {
+			continue
+		}
+		// Inserts at the cursor go before the character after it, and deletes remove the
+		// character before it.
+		if (opType == OperationInsert && e.Cursor+1 > start && e.Cursor+1 <= end) ||
+			(opType == OperationDelete && e.Cursor >= start && e.Cursor <= end) {
+			return "Prompts are read-only"
+		}
+	}
+	return ""
+}
+
+// insertPrompt handles the prompt key, for interviewers. It asks for a question, inserts it
+// at the cursor as a line of its own, and makes it a read-only prompt block.
+func insertPrompt(conn *websocket.Conn) {
+	if !isInterviewer() {
+		return
+	}
+
+	e.ShowPrompt(&editor.Prompt{
+		Text: "Question:",
+		Submit: func(text string) error {
+			text = strings.TrimSpace(text)
+			if text == "" {
+				return nil
+			}
+
+			start := e.Cursor + 1
+			value := []rune(text + "\n")
+			for i, r := range value {
+				op, err := doc.GenerateOperation(commons.Operation{Type: "insert", Position: start + i, Value: string(r)})
+				if err != nil {
+					logger.Errorf("CRDT error: %v\n", err)
+					e.StatusChan <- "Failed to add prompt"
+					return nil
+				}
+				if err := sendOperation(op, conn); err != nil {
+					e.IsConnected = false
+					e.StatusChan <- "lost connection!"
+					return nil
+				}
+			}
+			e.SetText(crdt.Content(doc))
+			e.MoveCursorRunes(len(value))
+			e.SetDirty(true)
+
+			a := commons.Annotation{ID: uuid.NewString(), Author: username, Text: text, Start: start, End: start + len(value) - 1}
+			if _, err := anchorAnnotation(&a); err != nil {
+				logger.Errorf("failed to add prompt, err: %v\n", err)
+				return nil
+			}
+			if err := addPrompt(a); err != nil {
+				logger.Errorf("failed to add prompt, err: %v\n", err)
+				return nil
+			}
+			if err := writeMessage(conn, commons.Message{Type: commons.PromptMessage, Annotation: &a}); err != nil {
+				e.IsConnected = false
+				e.StatusChan <- "lost connection!"
+			}
+			refreshAnnotations()
+			return nil
+		},
+	})
+}
+
+// toggleAccess handles the access key, for interviewers. It gives or removes the edit
+// access of all candidates.
+func toggleAccess(conn *websocket.Conn) {
+	if !isInterviewer() {
+		return
+	}
+
+	candidatesReadOnly = !candidatesReadOnly
+	access := commons.AccessEdit
+	if candidatesReadOnly {
+		access = commons.AccessReadOnly
+	}
+	if err := writeMessage(conn, commons.Message{Type: commons.AccessMessage, Text: access}); err != nil {
+		e.IsConnected = false
+		e.StatusChan <- "lost connection!"
+		return
+	}
+	if candidatesReadOnly {
+		e.StatusChan <- "Candidates can't edit the document anymore"
+	} else {
+		e.StatusChan <- "Candidates can edit the document"
+	}
+}
+
+// promptHighlights returns the ranges of the prompt blocks, to be underlined.
+func promptHighlights() []editor.Range {
+	var ranges []editor.Range
+	for _, p := range prompts {
+		if start, end, ok := doc.Range(p.anchor); ok {
+			ranges = append(ranges, editor.Range{Start: start - 1, End: end})
+		}
+	}
+	return ranges
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogConfig holds the limits of the client's log files, which are rotated once they grow
+// too large: pairpad.log is renamed to pairpad.log.1, pairpad.log.1 to pairpad.log.2, and
+// so on, and a new pairpad.log is started.
+type LogConfig struct {
+	// MaxSize is the size, in megabytes, a log file may grow to before it's rotated. 0
+	// means no limit.
+	MaxSize int `toml:"max_size"`
+
+	// MaxFiles is the number of rotated log files kept, besides the current one.
+	MaxFiles int `toml:"max_files"`
+
+	// MaxAge is how long rotated log files are kept. 0 means until there are more than
+	// MaxFiles of them.
+	MaxAge time.Duration `toml:"max_age"`
+}
+
+// defaultLog holds the log limits used unless the config file sets them.
+var defaultLog = LogConfig{
+	MaxSize:  10,
+	MaxFiles: 3,
+}
+
+// validate checks that the limits make sense.
+func (c LogConfig) validate() error {
+	if c.MaxSize < 0 {
+		return errors.New("log.max_size can't be negative")
+	}
+	if c.MaxFiles < 0 {
+		return errors.New("log.max_files can't be negative")
+	}
+	if c.MaxAge < 0 {
+		return errors.New("log.max_age can't be negative")
+	}
+	return nil
+}
+
+// A logFile is a log file which is rotated once it grows past the size limit. It's safe
+// for concurrent use.
+type logFile struct {
+	path   string
+	limits LogConfig
+
+	// maxSize is the size limit in bytes, which tests lower.
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// openLogFile opens the log file at path, creating it if it doesn't exist, and removes the
+// rotated files older than the limits allow.
+func openLogFile(path string, limits LogConfig) (*logFile, error) {
+	l := &logFile{path: path, limits: limits, maxSize: int64(limits.MaxSize) << 20}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	l.removeExpired()
+	return l, nil
+}
+
+// open opens the current log file, to append to it.
+func (l *logFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644) // skipcq: GSC-G302
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// Write appends p to the log file, rotating it first if p would take it past the size
+// limit. The writes of logrus are whole entries, so entries aren't split across files.
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.f.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotatedName returns the name of the nth rotated log file, from 1 for the latest.
+func (l *logFile) rotatedName(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// rotate renames the current log file to the first rotated one, shifting the others and
+// dropping the oldest, and starts a new one.
+func (l *logFile) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	if l.limits.MaxFiles == 0 {
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	} else {
+		if err := os.Remove(l.rotatedName(l.limits.MaxFiles)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		for n := l.limits.MaxFiles - 1; n >= 1; n-- {
+			if err := os.Rename(l.rotatedName(n), l.rotatedName(n+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Rename(l.path, l.rotatedName(1)); err != nil {
+			return err
+		}
+	}
+
+	l.removeExpired()
+	return l.open()
+}
+
+// removeExpired removes the rotated log files last written to longer than MaxAge ago, and
+// those past MaxFiles, which are left when the limit is lowered.
+func (l *logFile) removeExpired() {
+	names, _ := filepath.Glob(l.path + ".*")
+	for _, name := range names {
+		n, err := strconv.Atoi(strings.TrimPrefix(name, l.path+"."))
+		if err != nil || n < 1 {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		if n > l.limits.MaxFiles || (l.limits.MaxAge > 0 && time.Since(info.ModTime()) > l.limits.MaxAge) {
+			_ = os.Remove(name)
+		}
+	}
+}
+
+// Close closes the log file.
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestLogFile checks that log files are rotated once they reach the size limit, keeping
+// the number of rotated files allowed, and that rotated files older than the age limit
+// are removed.
+func TestLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pairpad.log")
+
+	// A rotated file left from a previous run is removed when it's too old.
+	old := path + ".2"
+	if err := os.WriteFile(old, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(old, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".1", []byte("recent\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := openLogFile(path, LogConfig{MaxSize: 1, MaxFiles: 2, MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := os.Stat(old); err == nil {
+		t.Error("got the expired rotated file kept, expected it removed")
+	}
+
+	l.maxSize = 8
+	for _, entry := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := l.Write([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, expected := range map[string]string{
+		path:        "third\n",
+		path + ".1": "second\n",
+		path + ".2": "first\n",
+	} {
+		if content, err := os.ReadFile(name); err != nil || string(content) != expected {
+			t.Errorf("%s: got %q, %v, expected %q", filepath.Base(name), content, err, expected)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("got a third rotated file, expected two at most")
+	}
+}
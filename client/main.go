@@ -3,13 +3,20 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/client/plugin"
+	"github.com/burntcarrot/pairpad/client/spell"
 	"github.com/burntcarrot/pairpad/commons"
 	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 )
 
@@ -20,44 +27,201 @@ var (
 	// Centralized logger.
 	logger = logrus.New()
 
-	// termbox-based editor.
+	// tcell-based editor.
 	e = editor.NewEditor(editor.EditorConfig{})
 
+	// The user's name. The server may change it, if another user has the same name.
+	username string
+
 	// The name of the file to load from and save to.
 	fileName string
 
 	// Parsed flags.
 	flags Flags
+
+	// importText holds the content of the plain text file to import, once the server has
+	// assigned this client's site ID.
+	importText string
+
+	// importPending is set once importText has been imported into the local document,
+	// until the session's document is received.
+	importPending bool
+
+	// showStats indicates whether the CRDT conflict stats overlay is shown (debugging mode only).
+	showStats bool
 )
 
 func main() {
 	// Parse flags.
 	flags = parseFlags()
 
+	if flags.Command == "config" {
+		if err := printConfig(flags.Config, os.Stdout); err != nil {
+			fmt.Printf("failed to read config file: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	conf, err := loadConfig(flags.Config)
+	if err != nil {
+		fmt.Printf("failed to read config file: %s\n", err)
+		return
+	}
+	connection = conf.Connection
+
 	s := bufio.NewScanner(os.Stdin)
 
 	// Generate a random username.
-	name := randomdata.SillyName()
+	username = randomdata.SillyName()
 
 	// Read username based if login flag is set to true.
-	if flags.Login {
+	if flags.Login && flags.ReplayInput == "" {
 		fmt.Print("Enter your name: ")
 		s.Scan()
-		name = s.Text()
+		username = strings.TrimSpace(s.Text())
+	}
+
+	// Start the server of the hosted room, and connect to it.
+	if flags.Command == "host" {
+		h, err := startHost(flags.HostPort)
+		if err != nil {
+			fmt.Printf("Failed to start the server: %s\n", err)
+			return
+		}
+		defer h.stop()
+		if flags.Advertise {
+			if err := h.advertise(flags.Room); err != nil {
+				fmt.Printf("Failed to advertise the session on the local network: %s\n", err)
+			}
+		}
+		flags.Server = "localhost:" + strconv.Itoa(h.port)
+		h.printInvite(flags.Room, s, os.Stdout)
+	}
+
+	// Join a session found on the local network, if one is picked.
+	if flags.Discover {
+		picked, err := pickDiscovered(&flags, s, os.Stdout)
+		if err != nil {
+			fmt.Printf("Failed to look for sessions on the local network: %s\n", err)
+			return
+		}
+		if !picked {
+			return
+		}
+	}
+
+	// Resume a recent session, if one is picked.
+	if flags.Recent && flags.ReplayInput == "" {
+		if err := pickRecent(&flags, s, os.Stdout); err != nil {
+			fmt.Printf("Failed to read the recent sessions: %s\n", err)
+		}
+	}
+
+	// The file is found by its absolute path, so that it's remembered by it, and saved to
+	// it, wherever the client is started from.
+	if flags.File != "" {
+		if flags.File, err = expandPath(flags.File); err != nil {
+			fmt.Printf("failed to find the file: %s\n", err)
+			return
+		}
+	}
+	if flags.LogDir != "" {
+		if flags.LogDir, err = expandPath(flags.LogDir); err != nil {
+			fmt.Printf("failed to find the log directory: %s\n", err)
+			return
+		}
+	}
+
+	// Offer to join the session the file was last edited in, unless another one was asked for.
+	if flags.File != "" && !flags.SessionSet && flags.ReplayInput == "" {
+		if err := offerRejoin(&flags, s, os.Stdout); err != nil {
+			fmt.Printf("Failed to read the file's last session: %s\n", err)
+		}
+	}
+
+	// Offer to recover the unsaved changes of a client which crashed.
+	var swapDoc *crdt.File
+	if flags.ReplayInput == "" {
+		if swapFile, err = swapPath(flags.File, siteKey(flags)); err != nil {
+			fmt.Printf("Failed to find the swap file: %s\n", err)
+			swapFile = ""
+		} else if swapDoc, err = checkSwap(swapFile, s, os.Stdout); err != nil {
+			fmt.Printf("Failed to recover the swap file: %s\n", err)
+		}
+	}
+
+	if flags.RecordInput != "" {
+		if flags.ReplayInput != "" {
+			fmt.Println("-record-input and -replay-input can't be used together")
+			return
+		}
+		if rec, err = newRecorder(flags.RecordInput); err != nil {
+			fmt.Printf("failed to record input: %s\n", err)
+			return
+		}
+		defer rec.close()
+	}
+
+	if flags.Transcript != "" {
+		trans = newTranscript(time.Now(), flags.TranscriptOps)
+		defer func() {
+			if err := trans.write(flags.Transcript, flags.Room, crdt.Content(doc), time.Now()); err != nil {
+				fmt.Printf("Failed to write the transcript: %s\n", err)
+			}
+		}()
 	}
 
-	conn, _, err := createConn(flags)
+	var conn *websocket.Conn
+	if flags.ReplayInput != "" {
+		// Replays start from the recorded state, and stand in for the server.
+		if replay, err = loadReplay(flags.ReplayInput); err != nil {
+			fmt.Printf("failed to load recording: %s\n", err)
+			return
+		}
+		username, flags.Debug, flags.File = replay.start.Username, replay.start.Debug, ""
+		conn, err = replay.connect()
+	} else {
+		key := siteKey(flags)
+		if prevSite, err = takeSite(key); err != nil {
+			fmt.Printf("Failed to read the previous site ID: %s\n", err)
+		}
+		defer func() {
+			if err := keepSite(key); err != nil {
+				fmt.Printf("Failed to keep the site ID: %s\n", err)
+			}
+		}()
+		if flags.SSH != "" {
+			if tunnel, err = openTunnel(flags.SSH, flags.Server, flags.Secure); err != nil {
+				fmt.Printf("Failed to open the SSH tunnel, exiting: %s\n", err)
+				return
+			}
+			defer tunnel.close()
+		}
+		conn, err = connect(flags)
+	}
 	if err != nil {
 		fmt.Printf("Connection error, exiting: %s\n", err)
 		return
 	}
 	defer conn.Close()
 
+	if replay == nil {
+		if flags.File != "" {
+			if err := rememberFileSession(flags.File, flags, time.Now()); err != nil {
+				fmt.Printf("Failed to remember the file's session: %s\n", err)
+			}
+		}
+		if err := addRecent(flags, time.Now()); err != nil {
+			fmt.Printf("Failed to remember the session: %s\n", err)
+		}
+	}
+
 	// Send joining message.
-	msg := commons.Message{Username: name, Text: "has joined the session.", Type: commons.JoinMessage}
-	_ = conn.WriteJSON(msg)
+	msg := commons.Message{Username: username, Text: "has joined the session.", Type: commons.JoinMessage, Token: prevNameToken(username)}
+	_ = writeMessage(conn, msg)
 
-	logFile, debugLogFile, err := setupLogger(logger)
+	logFile, debugLogFile, err := setupLogger(logger, flags.LogDir, fs.FileMode(conf.LogDirMode), conf.Log)
 	if err != nil {
 		fmt.Printf("Failed to setup logger, exiting: %s\n", err)
 		return
@@ -65,15 +229,92 @@ func main() {
 	defer closeLogFiles(logFile, debugLogFile)
 
 	if flags.File != "" {
-		if doc, err = crdt.Load(flags.File); err != nil {
-			fmt.Printf("failed to load document: %s\n", err)
-			return
+		fileName, ownFile = flags.File, flags.File
+		if filepath.Ext(fileName) == stateFileExt {
+			if doc, err = loadFile(fileName); err != nil {
+				fmt.Printf("failed to load document: %s\n", err)
+				return
+			}
+		} else {
+			// Plain text files are imported once the server has assigned a site ID, so
+			// that the imported characters are attributed to this client.
+			content, err := os.ReadFile(fileName)
+			if err != nil {
+				fmt.Printf("failed to load document: %s\n", err)
+				return
+			}
+			importText = decodeText(content)
+		}
+	}
+
+	// The recovered content is imported like a plain text file, in place of the file's.
+	if swapDoc != nil {
+		importText, recovered = crdt.Content(swapDoc.Document), true
+	}
+
+	if replay != nil {
+		fileName, doc, importText = replay.start.FileName, replay.start.Document, replay.start.ImportText
+	}
+
+	if err := loadPlugins(conf.Plugins); err != nil {
+		fmt.Println(err)
+		return
+	}
+	ringBell = conf.Bell
+	useGit = conf.Git
+	fileBackups = conf.Backups
+	if conf.FileMode != 0 {
+		fileMode = fs.FileMode(conf.FileMode)
+	}
+	snippets = conf.Snippets
+	autoPair = conf.AutoPair
+	plugin.Register(formatPlugin(conf.FormatOnSave))
+	plugin.Register(cleanupPlugin(conf.TrimTrailingWhitespace, conf.EnsureTrailingNewline))
+	plugin.Register(hooksPlugin(conf.Hooks))
+
+	// Only with telemetry enabled, report how the editor was used on exit. Replays aren't
+	// reported, as they were when recorded.
+	if conf.Telemetry.Enabled && replay == nil {
+		telemetry = startUsage(conf.Telemetry.Endpoint)
+		defer reportUsage()
+		for feature, used := range map[string]bool{
+			"host":        flags.Command == "host",
+			"discover":    flags.Discover,
+			"ssh":         flags.SSH != "",
+			"interviewer": flags.Interviewer != "",
+			"plugins":     len(conf.Plugins) > 0,
+		} {
+			if used {
+				telemetry.count(feature)
+			}
+		}
+	}
+
+	var spellCheck editor.SpellChecker
+	if conf.SpellCheck {
+		dict := spell.Default()
+		if conf.Dictionary != "" {
+			if dict, err = spell.Load(conf.Dictionary); err != nil {
+				fmt.Printf("failed to load dictionary: %s\n", err)
+				return
+			}
 		}
+		spellCheck = dict
 	}
 
 	uiConfig := UIConfig{
 		EditorConfig: editor.EditorConfig{
-			ScrollEnabled: flags.Scroll,
+			ScrollEnabled:  flags.Scroll,
+			ShowWhitespace: conf.ShowWhitespace,
+			MatchBrackets:  conf.MatchBrackets,
+			SpellCheck:     spellCheck,
+			Scrollbar:      conf.Scrollbar,
+			ScrollOff:      conf.ScrollOff,
+			LineHighlight:  conf.lineHighlight(),
+			Palette:        conf.Palette,
+			UserColors:     conf.Colors.userColors(),
+			InfoBar:        conf.StatusBar,
+			MaxFPS:         conf.MaxFPS,
 		},
 	}
 
@@ -82,6 +323,11 @@ func main() {
 		// If error has the prefix "pairpad", then it was triggered by an event that wasn't an error, for example, exiting the editor.
 		// It's a hacky solution since the UI returns an error only.
 		if strings.HasPrefix(err.Error(), "pairpad") {
+			// Close the connection cleanly, so the other clients are told that we left.
+			_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			if err := removeSwap(); err != nil {
+				fmt.Printf("Failed to remove the swap file: %s\n", err)
+			}
 			fmt.Println("exiting session.")
 			return
 		}
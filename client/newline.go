@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gorilla/websocket"
+)
+
+// The line endings of plain text files.
+const (
+	newlineLF   = "\n"
+	newlineCRLF = "\r\n"
+)
+
+var (
+	// fileNewline is the line ending written when the file is saved as plain text: the one
+	// of most of the file's lines when it was loaded. The document always has LF line
+	// endings, so every client sees the same lines.
+	fileNewline = newlineLF
+
+	// fileUTF8 is cleared when the loaded file wasn't valid UTF-8. Its invalid bytes were
+	// replaced with U+FFFD, which is what's saved.
+	fileUTF8 = true
+)
+
+// detectNewline returns the line ending of most of the lines of text.
+func detectNewline(text string) string {
+	crlf := strings.Count(text, "\r\n")
+	if crlf > strings.Count(text, "\n")-crlf {
+		return newlineCRLF
+	}
+	return newlineLF
+}
+
+// decodeText returns the content of a plain text file with LF line endings, and remembers
+// the file's line ending and whether it's valid UTF-8, to show them and save the file alike.
+func decodeText(content []byte) string {
+	text := string(content)
+	fileNewline = detectNewline(text)
+	fileUTF8 = utf8.ValidString(text)
+	return strings.ReplaceAll(text, "\r\n", "\n")
+}
+
+// encodeText returns text with the given line endings.
+func encodeText(text, newline string) string {
+	if newline == newlineLF {
+		return text
+	}
+	return strings.ReplaceAll(text, "\n", newline)
+}
+
+// fileFormat describes the line endings and encoding of the file, or returns "" for files
+// which hold the CRDT state.
+func fileFormat() string {
+	if fileName == "" || filepath.Ext(fileName) == stateFileExt {
+		return ""
+	}
+
+	format := "LF"
+	if fileNewline == newlineCRLF {
+		format = "CRLF"
+	}
+	if fileUTF8 {
+		return format + " UTF-8"
+	}
+	return format + " not UTF-8"
+}
+
+// refreshFileFormat shows the file's line endings and encoding in the info bar.
+func refreshFileFormat() {
+	e.SetFileFormat(fileFormat())
+}
+
+// convertNewlines switches the line endings the file is saved with between LF and CRLF.
+// Carriage returns before newlines, which other clients may have inserted, are deleted
+// from the document, so their lines end alike.
+func convertNewlines(conn *websocket.Conn) {
+	if filepath.Ext(fileName) == stateFileExt {
+		e.StatusChan <- "Files holding the CRDT state always have LF line endings"
+		return
+	}
+
+	if fileNewline == newlineLF {
+		fileNewline = newlineCRLF
+	} else {
+		fileNewline = newlineLF
+	}
+	removed := 0
+	if isInterviewer() || !readOnly {
+		removed = deleteCarriageReturns(conn)
+	}
+
+	e.SetDirty(true)
+	refreshFileFormat()
+	msg := fmt.Sprintf("Line endings converted to %s, save to write them", strings.Fields(fileFormat())[0])
+	if removed > 0 {
+		msg += fmt.Sprintf(" (%d carriage returns removed)", removed)
+	}
+	e.StatusChan <- msg
+}
+
+// deleteCarriageReturns deletes the carriage returns followed by newlines from the
+// document, outside of the prompts for candidates, and sends the deletes to the server. It
+// returns the number of deleted characters.
+func deleteCarriageReturns(conn *websocket.Conn) int {
+	text := []rune(crdt.Content(doc))
+	removed := 0
+
+	// Characters are deleted from the end, so the positions before them don't change.
+	for i := len(text) - 2; i >= 0; i-- {
+		if text[i] != '\r' || text[i+1] != '\n' || inPrompt(i+1) {
+			continue
+		}
+		op, _ := doc.GenerateOperation(commons.Operation{Type: "delete", Position: i + 1})
+		removed++
+		if i < e.Cursor {
+			e.Cursor--
+		}
+		if err := sendOperation(op, conn); err != nil {
+			e.IsConnected = false
+			e.StatusChan <- "lost connection!"
+			break
+		}
+	}
+
+	if removed > 0 {
+		e.SetText(crdt.Content(doc))
+	}
+	return removed
+}
+
+// inPrompt reports whether the character at position is part of a prompt which the user
+// can't edit.
+func inPrompt(position int) bool {
+	if isInterviewer() {
+		return false
+	}
+	for _, p := range prompts {
+		if start, end, ok := doc.Range(p.anchor); ok && position >= start && position <= end {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+// TestDecodeText tests that files are loaded with LF line endings, and saved with the
+// line endings of most of their lines.
+func TestDecodeText(t *testing.T) {
+	prevNewline, prevUTF8 := fileNewline, fileUTF8
+	defer func() { fileNewline, fileUTF8 = prevNewline, prevUTF8 }()
+
+	tests := []struct {
+		name, content, text, newline, saved string
+		utf8                                bool
+	}{
+		{"empty", "", "", newlineLF, "", true},
+		{"LF", "a\nb\n", "a\nb\n", newlineLF, "a\nb\n", true},
+		{"CRLF", "a\r\nb\r\n", "a\nb\n", newlineCRLF, "a\r\nb\r\n", true},
+		{"mostly CRLF", "a\r\nb\r\nc\n", "a\nb\nc\n", newlineCRLF, "a\r\nb\r\nc\r\n", true},
+		{"mostly LF", "a\r\nb\nc\n", "a\nb\nc\n", newlineLF, "a\nb\nc\n", true},
+		{"lone carriage return", "a\rb", "a\rb", newlineLF, "a\rb", true},
+		{"invalid UTF-8", "a\xffb\r\n", "a\xffb\n", newlineCRLF, "a\xffb\r\n", false},
+	}
+
+	for _, tc := range tests {
+		text := decodeText([]byte(tc.content))
+		if text != tc.text {
+			t.Errorf("%s: got text %q, expected %q", tc.name, text, tc.text)
+		}
+		if fileNewline != tc.newline {
+			t.Errorf("%s: got newline %q, expected %q", tc.name, fileNewline, tc.newline)
+		}
+		if fileUTF8 != tc.utf8 {
+			t.Errorf("%s: got UTF-8 %v, expected %v", tc.name, fileUTF8, tc.utf8)
+		}
+		if saved := encodeText(text, fileNewline); saved != tc.saved {
+			t.Errorf("%s: got saved %q, expected %q", tc.name, saved, tc.saved)
+		}
+	}
+}
+
+// TestFileFormat tests the description of the file's format shown in the info bar.
+func TestFileFormat(t *testing.T) {
+	prevName, prevNewline, prevUTF8 := fileName, fileNewline, fileUTF8
+	defer func() { fileName, fileNewline, fileUTF8 = prevName, prevNewline, prevUTF8 }()
+
+	tests := []struct {
+		name, newline string
+		utf8          bool
+		expected      string
+	}{
+		{"", newlineLF, true, ""},
+		{"notes.txt", newlineLF, true, "LF UTF-8"},
+		{"notes.txt", newlineCRLF, false, "CRLF not UTF-8"},
+		{"notes.pairpad", newlineCRLF, true, ""},
+	}
+
+	for _, tc := range tests {
+		fileName, fileNewline, fileUTF8 = tc.name, tc.newline, tc.utf8
+		if got := fileFormat(); got != tc.expected {
+			t.Errorf("%q: got %q, expected %q", tc.name, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/gdamore/tcell/v2"
+)
+
+var (
+	// showOutline indicates whether the overlay listing the document's headings is shown.
+	showOutline bool
+
+	// docOutline holds the headings of the document, kept up to date while a Markdown file
+	// is edited.
+	docOutline outline
+
+	// outlineSelected is the index of the heading selected in the outline overlay.
+	outlineSelected int
+)
+
+// A heading is a Markdown heading of the document.
+type heading struct {
+	// line is the index of the heading's line, from 0.
+	line int
+
+	// level is the heading's level, from 1 (#) to 6 (######).
+	level int
+
+	// title is the heading's text, without its markers.
+	title string
+}
+
+// An outline holds the ATX headings (lines starting with #) of a Markdown document. It's
+// updated incrementally: only the lines which changed since the last update are parsed
+// again, along with the lines after them whose fenced code blocks they opened or closed.
+type outline struct {
+	// lines holds the document's lines at the last update.
+	lines []string
+
+	// fences holds the fence of the code block open at the end of each line, or "" outside
+	// of code blocks.
+	fences []string
+
+	// headings holds the headings, ordered by line.
+	headings []heading
+}
+
+// update updates the outline to the document's new text.
+func (o *outline) update(text string) {
+	lines := strings.Split(text, "\n")
+
+	// Find the changed lines by skipping the ones common to the start and end of both texts.
+	prefix := 0
+	for prefix < len(lines) && prefix < len(o.lines) && lines[prefix] == o.lines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(lines)-prefix && suffix < len(o.lines)-prefix && lines[len(lines)-1-suffix] == o.lines[len(o.lines)-1-suffix] {
+		suffix++
+	}
+	delta := len(lines) - len(o.lines)
+
+	fence := o.fenceBefore(prefix)
+
+	// Parse the changed lines, and the unchanged ones after them until their code blocks are
+	// the same as before.
+	var headings []heading
+	var fences []string
+	end := len(lines)
+	for i := prefix; i < len(lines); i++ {
+		if i >= len(lines)-suffix && fence == o.fenceBefore(i-delta) {
+			end = i
+			break
+		}
+		var h heading
+		var ok bool
+		fence, h, ok = parseOutlineLine(lines[i], fence)
+		if ok {
+			h.line = i
+			headings = append(headings, h)
+		}
+		fences = append(fences, fence)
+	}
+
+	// Keep the headings before and after the parsed lines, shifting the ones after.
+	var kept []heading
+	for _, h := range o.headings {
+		if h.line < prefix {
+			kept = append(kept, h)
+		}
+	}
+	kept = append(kept, headings...)
+	for _, h := range o.headings {
+		if h.line >= end-delta {
+			h.line += delta
+			kept = append(kept, h)
+		}
+	}
+
+	o.headings = kept
+	o.fences = append(append(append([]string(nil), o.fences[:prefix]...), fences...), o.fences[end-delta:]...)
+	o.lines = lines
+}
+
+// fenceBefore returns the fence of the code block open at the start of the line with the
+// given index at the last update.
+func (o *outline) fenceBefore(line int) string {
+	if line == 0 {
+		return ""
+	}
+	return o.fences[line-1]
+}
+
+// parseOutlineLine parses a line inside the code block opened by fence, or outside of code
+// blocks if it's "". It returns the fence of the code block open after the line, and the
+// line's heading, if it's one.
+func parseOutlineLine(line, fence string) (string, heading, bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return fence, heading{}, false
+	}
+
+	// Code blocks are opened by 3 or more backticks or tildes, and closed by at least as many
+	// of the same character, followed by nothing else.
+	if fence != "" {
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]+" \t") == "" {
+			return "", heading{}, false
+		}
+		return fence, heading{}, false
+	}
+	for _, marker := range []string{"```", "~~~"} {
+		if strings.HasPrefix(trimmed, marker) {
+			return trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, marker[:1]))], heading{}, false
+		}
+	}
+
+	level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+	if level < 1 || level > 6 {
+		return "", heading{}, false
+	}
+	title := trimmed[level:]
+	if title != "" && title[0] != ' ' && title[0] != '\t' {
+		return "", heading{}, false
+	}
+
+	// A closing sequence of #s is left out, if it's preceded by a space.
+	title = strings.TrimSpace(title)
+	if closing := strings.TrimRight(title, "#"); closing == "" || strings.HasSuffix(closing, " ") {
+		title = strings.TrimSpace(closing)
+	}
+	return "", heading{level: level, title: title}, true
+}
+
+// isMarkdown reports whether the file is a Markdown file.
+func isMarkdown(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// refreshOutline updates the outline of Markdown files, and refreshes the outline overlay
+// if it's shown.
+func refreshOutline() {
+	if !isMarkdown(fileName) {
+		return
+	}
+	docOutline.update(string(e.GetText()))
+	if !showOutline {
+		return
+	}
+	if outlineSelected >= len(docOutline.headings) {
+		outlineSelected = len(docOutline.headings) - 1
+	}
+	if outlineSelected < 0 {
+		outlineSelected = 0
+	}
+	e.SetOverlay(outlineOverlay(docOutline.headings, outlineSelected, e.GetY()-1))
+}
+
+// toggleOutline shows or hides the outline overlay, selecting the section the cursor is in.
+func toggleOutline() {
+	if !isMarkdown(fileName) {
+		e.StatusChan <- "The outline is only available for Markdown files"
+		return
+	}
+	showOutline = !showOutline
+	showStats, showAnnotations, showDocStats, showParticipants, showPerf = false, false, false, false, false
+	if !showOutline {
+		e.SetOverlay(nil)
+		return
+	}
+	docOutline.update(string(e.GetText()))
+	outlineSelected = outlineSection(docOutline.headings, e.GetY()-1)
+}
+
+// handleOutlineKey handles the keys of the outline overlay: the up and down arrow keys
+// select a heading, Enter moves the cursor to it, and Esc hides the overlay. It reports
+// whether the key was handled.
+func handleOutlineKey(ev *tcell.EventKey) bool {
+	switch ev.Key() {
+	case tcell.KeyUp:
+		if outlineSelected > 0 {
+			outlineSelected--
+		}
+	case tcell.KeyDown:
+		if outlineSelected < len(docOutline.headings)-1 {
+			outlineSelected++
+		}
+	case tcell.KeyEnter:
+		if outlineSelected < len(docOutline.headings) {
+			e.FollowCursor()
+			e.SetX(lineStart(e.GetText(), docOutline.headings[outlineSelected].line))
+		}
+		showOutline = false
+		e.SetOverlay(nil)
+	case tcell.KeyEsc:
+		showOutline = false
+		e.SetOverlay(nil)
+	default:
+		return false
+	}
+	return true
+}
+
+// outlineSection returns the index of the heading of the section holding line, or 0 if
+// the line is before the first heading.
+func outlineSection(headings []heading, line int) int {
+	section := 0
+	for i, h := range headings {
+		if h.line > line {
+			break
+		}
+		section = i
+	}
+	return section
+}
+
+// outlineOverlay returns an overlay listing the headings, indented by level. The selected
+// heading is marked with ">", and the one of the section holding the cursor's line is
+// highlighted.
+func outlineOverlay(headings []heading, selected, cursorLine int) *editor.Overlay {
+	o := &editor.Overlay{Title: "Outline"}
+	if len(headings) == 0 {
+		o.Lines = []string{"no headings"}
+		return o
+	}
+
+	current := outlineSection(headings, cursorLine)
+	for i, h := range headings {
+		marker := " "
+		if i == selected {
+			marker = ">"
+		}
+		o.Lines = append(o.Lines, fmt.Sprintf("%s %s%s", marker, strings.Repeat("  ", h.level-1), h.title))
+		color := tcell.ColorDefault
+		if i == current && h.line <= cursorLine {
+			color = tcell.ColorTeal
+		}
+		o.Colors = append(o.Colors, color)
+	}
+	return o
+}
+
+// lineStart returns the index of the first rune of the line of text with the given index,
+// from 0.
+func lineStart(text []rune, line int) int {
+	for i, r := range text {
+		if line == 0 {
+			return i
+		}
+		if r == '\n' {
+			line--
+		}
+	}
+	return len(text)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParseOutlineLine checks which lines are headings, and their levels and titles.
+func TestParseOutlineLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected heading
+		ok       bool
+	}{
+		{"# Title", heading{level: 1, title: "Title"}, true},
+		{"### Usage ###", heading{level: 3, title: "Usage"}, true},
+		{"  ## C# notes", heading{level: 2, title: "C# notes"}, true},
+		{"#", heading{level: 1}, true},
+		{"#hashtag", heading{}, false},
+		{"####### Too deep", heading{}, false},
+		{"    # Indented code", heading{}, false},
+		{"Text with a # sign", heading{}, false},
+	}
+
+	for _, tc := range tests {
+		_, got, ok := parseOutlineLine(tc.line, "")
+		if got != tc.expected || ok != tc.ok {
+			t.Errorf("%q: got %+v, %v, expected %+v, %v", tc.line, got, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+// TestOutlineUpdate checks that incremental updates give the same headings as parsing the
+// text again, including when code blocks are opened and closed.
+func TestOutlineUpdate(t *testing.T) {
+	texts := []string{
+		"# A\ntext\n## B\n",
+		"# A\ntext\n## B\nmore\n# C",
+		"# Intro\n# A\ntext\n## B\nmore\n# C",
+		"# Intro\n```\n# A\ntext\n## B\nmore\n# C",
+		"# Intro\n```\n# A\ntext\n```\n## B\nmore\n# C",
+		"# Intro\n````\n# A\ntext\n```\n## B\nmore\n# C",
+		"# Intro\n# A\ntext\n```\n## B\nmore\n# C",
+		"# Intro\n# C",
+		"",
+		"~~~\n# Not a heading\n~~~\n# Heading",
+	}
+
+	var o outline
+	for _, text := range texts {
+		o.update(text)
+
+		var fresh outline
+		fresh.update(text)
+		if !reflect.DeepEqual(o.headings, fresh.headings) || !reflect.DeepEqual(o.fences, fresh.fences) {
+			t.Errorf("%q: got %+v, expected %+v", text, o.headings, fresh.headings)
+		}
+	}
+
+	o.update("# Intro\n```go\n# A\n```\n## B")
+	var titles []string
+	for _, h := range o.headings {
+		titles = append(titles, strings.Repeat("#", h.level)+" "+h.title)
+	}
+	if expected := []string{"# Intro", "## B"}; !reflect.DeepEqual(titles, expected) {
+		t.Errorf("got %q, expected %q", titles, expected)
+	}
+}
+
+// TestOutlineOverlay checks that headings are indented by level, with the selected one
+// marked.
+func TestOutlineOverlay(t *testing.T) {
+	headings := []heading{{line: 0, level: 1, title: "Title"}, {line: 4, level: 2, title: "Usage"}, {line: 9, level: 3, title: "Flags"}}
+	got := outlineOverlay(headings, 1, 5).Lines
+	expected := []string{"  Title", ">   Usage", "      Flags"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+
+	if got := outlineSection(headings, 8); got != 1 {
+		t.Errorf("got section %d, expected 1", got)
+	}
+}
+
+// TestLineStart checks the indexes of the lines' first runes.
+func TestLineStart(t *testing.T) {
+	text := []rune("ab\n\ncd\n")
+	for line, expected := range []int{0, 3, 4, 7} {
+		if got := lineStart(text, line); got != expected {
+			t.Errorf("line %d: got %d, expected %d", line, got, expected)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/mattn/go-runewidth"
+)
+
+var (
+	// showParticipants indicates whether the overlay listing the participants is shown.
+	showParticipants bool
+
+	// participants holds the users in the room, from the last users message.
+	participants []commons.User
+)
+
+// refreshParticipants refreshes the participants overlay, if it's shown.
+func refreshParticipants() {
+	if !showParticipants {
+		return
+	}
+	e.SetOverlay(participantsOverlay(e, participants, strconv.Itoa(crdt.SiteID)))
+}
+
+// participantsOverlay returns an overlay listing users in their colors in ed, or with their
+// markers, with their roles and latencies. The user with the site ID self is marked as
+// "(you)".
+func participantsOverlay(ed *editor.Editor, users []commons.User, self string) *editor.Overlay {
+	width := 0
+	for _, u := range users {
+		if w := runewidth.StringWidth(ed.UserLabel(editor.User{Name: u.Name, Color: u.Color})); w > width {
+			width = w
+		}
+	}
+
+	o := &editor.Overlay{Title: fmt.Sprintf("Participants (%d)", len(users))}
+	for _, u := range users {
+		user := editor.User{Name: u.Name, Color: u.Color}
+		name := ed.UserLabel(user)
+		if u.SiteID == self {
+			name += " (you)"
+		}
+		latency := "-"
+		if u.Latency > 0 {
+			latency = fmt.Sprintf("%dms", u.Latency)
+		}
+
+		o.Lines = append(o.Lines, fmt.Sprintf("%s  %-11s  %6s", runewidth.FillRight(name, width+6), participantRole(u), latency))
+		o.Colors = append(o.Colors, ed.UserColor(user))
+	}
+	return o
+}
+
+// participantRole describes the role of a user in the session.
+func participantRole(u commons.User) string {
+	switch {
+	case u.Hidden:
+		return "interviewer"
+	case u.ReadOnly:
+		return "read-only"
+	default:
+		return "editor"
+	}
+}
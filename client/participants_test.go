@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
+)
+
+// TestParticipantsOverlay checks that the participants are listed in their colors, with
+// their roles and latencies.
+func TestParticipantsOverlay(t *testing.T) {
+	users := []commons.User{
+		{Name: "alice", SiteID: "1", Color: 0, Hidden: true, Latency: 12},
+		{Name: "bob", SiteID: "2", Color: 1},
+		{Name: "carol", SiteID: "3", Color: 2, ReadOnly: true, Latency: 240},
+	}
+	ed := editor.NewEditor(editor.EditorConfig{})
+	o := participantsOverlay(ed, users, "2")
+
+	if o.Title != "Participants (3)" {
+		t.Errorf("got title %q, expected %q", o.Title, "Participants (3)")
+	}
+	expected := []string{
+		"alice        interviewer    12ms",
+		"bob (you)    editor            -",
+		"carol        read-only     240ms",
+	}
+	if !reflect.DeepEqual(o.Lines, expected) {
+		t.Errorf("got lines %q, expected %q", o.Lines, expected)
+	}
+	for i, u := range users {
+		if color := ed.UserColor(editor.User{Color: u.Color}); o.Colors[i] != color {
+			t.Errorf("%s: got color %v, expected %v", u.Name, o.Colors[i], color)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// pasting is set between the start and the end of a bracketed paste, while its text is
+	// collected.
+	pasting bool
+
+	// pasted holds the text of the bracketed paste being collected.
+	pasted strings.Builder
+)
+
+// collectPaste adds the key of a bracketed paste to its text, and reports whether it did.
+// Pasted newlines and tabs arrive as the Enter and Tab keys; other keys are dropped, as
+// they can't be pasted.
+func collectPaste(ev *tcell.EventKey) bool {
+	if !pasting {
+		return false
+	}
+	switch ev.Key() {
+	case tcell.KeyRune:
+		if ch, ok := composeRune(ev.Rune()); ok {
+			pasted.WriteRune(ch)
+		}
+	case tcell.KeyEnter, tcell.KeyCtrlJ:
+		pasted.WriteRune('\n')
+	case tcell.KeyTab:
+		pasted.WriteRune('\t')
+	}
+	return true
+}
+
+// handlePaste handles the start and the end of a bracketed paste. The text pasted is
+// inserted as one operation when the paste ends, instead of a key at a time, and without
+// auto-pairing or the indentation of Tab. While a prompt, the outline or a block selection
+// is shown, the pasted keys are handled as typed instead. It reports whether a collected
+// paste ended.
+func handlePaste(ev *tcell.EventPaste, conn *websocket.Conn) bool {
+	if ev.Start() {
+		pasting = !e.PromptActive() && !showOutline && !blockMode
+		pasted.Reset()
+		return false
+	}
+	if !pasting {
+		return false
+	}
+	pasting = false
+	if text := pasted.String(); text != "" {
+		insertText(text, conn)
+	}
+	pasted.Reset()
+	return true
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gdamore/tcell/v2"
+)
+
+// TestHandlePaste checks that the text of a bracketed paste is inserted at once when it
+// ends, with its newlines and tabs, without auto-pairing or the indentation of Tab, and
+// that the keys Ctrl and Alt modify move the cursor by words.
+func TestHandlePaste(t *testing.T) {
+	conn := sink(t)
+	autoPair, readOnly = true, false
+	setPrompts(nil)
+	defer func() { autoPair, pasting = false, false }()
+
+	doc, _ = crdt.FromText("ab")
+	e = editor.NewEditor(editor.EditorConfig{})
+	e.SetText(crdt.Content(doc))
+	e.SetX(1)
+
+	keys := []tcell.Event{
+		tcell.NewEventPaste(true),
+		tcell.NewEventKey(tcell.KeyRune, '(', tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyEnter, '\r', tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyTab, '\t', tcell.ModNone),
+		tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModNone),
+	}
+	for _, ev := range keys {
+		_ = handleEvent(ev, conn)
+		if got := crdt.Content(doc); got != "ab" {
+			t.Fatalf("got %q during the paste, expected the document unchanged", got)
+		}
+	}
+	_ = handleEvent(tcell.NewEventPaste(false), conn)
+	if got, expected := crdt.Content(doc), "a(x\n\tb"; got != expected || string(e.GetText()) != expected {
+		t.Errorf("got %q after the paste, expected %q", got, expected)
+	}
+	if e.Cursor != 5 {
+		t.Errorf("got cursor %d after the paste, expected 5", e.Cursor)
+	}
+
+	// Keys after the paste are typed again.
+	_ = handleEvent(tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModCtrl), conn)
+	if e.Cursor != 2 {
+		t.Errorf("got cursor %d after Ctrl+Left, expected 2", e.Cursor)
+	}
+	_ = handleEvent(tcell.NewEventKey(tcell.KeyRight, 0, tcell.ModAlt), conn)
+	if e.Cursor != 3 {
+		t.Errorf("got cursor %d after Alt+Right, expected 3", e.Cursor)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// perfInterval is how often the performance overlay is refreshed, while it's shown.
+const perfInterval = time.Second
+
+var (
+	// showPerf indicates whether the performance overlay is shown.
+	showPerf bool
+
+	// opsSent and opsReceived count the operations sent to the server, and received from
+	// it. They're updated atomically.
+	opsSent, opsReceived int64
+
+	// lastPerf is the sample the rates shown in the performance overlay are measured from.
+	lastPerf perfSample
+)
+
+// A perfSample holds the editor's counters at some time, to diagnose an editor feeling
+// slow: whether it's drawing, the network or the document.
+type perfSample struct {
+	at time.Time
+
+	// draws is the number of times the editor was drawn, and drawTime the total time it
+	// took.
+	draws    int
+	drawTime time.Duration
+
+	sent, received int64
+
+	// drawQueue and statusQueue are the numbers of draws and status messages waiting.
+	drawQueue, statusQueue int
+
+	characters, tombstones int
+}
+
+// samplePerf returns the editor's counters as of now.
+func samplePerf() perfSample {
+	s := crdt.Summarize(doc)
+	draws, drawTime := e.DrawStats()
+	return perfSample{
+		at:          time.Now(),
+		draws:       draws,
+		drawTime:    drawTime,
+		sent:        atomic.LoadInt64(&opsSent),
+		received:    atomic.LoadInt64(&opsReceived),
+		drawQueue:   len(e.DrawChan),
+		statusQueue: len(e.StatusChan),
+		characters:  s.Characters,
+		tombstones:  s.Tombstones,
+	}
+}
+
+// togglePerf shows or hides the performance overlay.
+func togglePerf() {
+	showPerf = !showPerf
+	showStats, showAnnotations, showDocStats, showParticipants, showOutline = false, false, false, false, false
+	if !showPerf {
+		e.SetOverlay(nil)
+		return
+	}
+	// The rates are shown from the next refresh.
+	lastPerf = samplePerf()
+	e.SetOverlay(perfOverlay(lastPerf, lastPerf))
+}
+
+// refreshPerf refreshes the performance overlay, if it's shown, with the rates since it
+// was last refreshed.
+func refreshPerf() {
+	if !showPerf {
+		return
+	}
+	cur := samplePerf()
+	e.SetOverlay(perfOverlay(lastPerf, cur))
+	lastPerf = cur
+	e.SendDraw()
+}
+
+// perfOverlay returns an overlay displaying the counters of cur, and their rates since
+// prev.
+func perfOverlay(prev, cur perfSample) *editor.Overlay {
+	elapsed := cur.at.Sub(prev.at).Seconds()
+	rate := func(n int64) string {
+		if elapsed <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f", float64(n)/elapsed)
+	}
+
+	frameTime := "-"
+	if draws := cur.draws - prev.draws; draws > 0 {
+		frameTime = ((cur.drawTime - prev.drawTime) / time.Duration(draws)).Round(time.Microsecond).String()
+	}
+
+	return &editor.Overlay{
+		Title: "Performance",
+		Lines: []string{
+			fmt.Sprintf("frame time:   %s", frameTime),
+			fmt.Sprintf("draws/s:      %s", rate(int64(cur.draws-prev.draws))),
+			fmt.Sprintf("ops sent/s:   %s", rate(cur.sent-prev.sent)),
+			fmt.Sprintf("ops recv/s:   %s", rate(cur.received-prev.received)),
+			fmt.Sprintf("draw queue:   %d", cur.drawQueue),
+			fmt.Sprintf("status queue: %d", cur.statusQueue),
+			fmt.Sprintf("characters:   %d", cur.characters),
+			fmt.Sprintf("tombstones:   %d", cur.tombstones),
+		},
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestPerfOverlay checks that the rates are measured between the samples, and that they
+// aren't shown before there are two samples to measure them from.
+func TestPerfOverlay(t *testing.T) {
+	start := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	prev := perfSample{at: start, draws: 10, drawTime: 20 * time.Millisecond, sent: 5, received: 7}
+	cur := perfSample{
+		at:          start.Add(2 * time.Second),
+		draws:       14,
+		drawTime:    30 * time.Millisecond,
+		sent:        9,
+		received:    27,
+		drawQueue:   3,
+		statusQueue: 1,
+		characters:  120,
+		tombstones:  8,
+	}
+	got := perfOverlay(prev, cur).Lines
+	expected := []string{
+		"frame time:   2.5ms",
+		"draws/s:      2.0",
+		"ops sent/s:   2.0",
+		"ops recv/s:   10.0",
+		"draw queue:   3",
+		"status queue: 1",
+		"characters:   120",
+		"tombstones:   8",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+
+	got = perfOverlay(cur, cur).Lines
+	for i, line := range []string{"frame time:   -", "draws/s:      -", "ops sent/s:   -", "ops recv/s:   -"} {
+		if got[i] != line {
+			t.Errorf("got line %q, expected %q", got[i], line)
+		}
+	}
+}
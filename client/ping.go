@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/gorilla/websocket"
+)
+
+// bellInterval is the minimum time between two bells rung for pings, so a user pinging
+// repeatedly doesn't keep the terminal ringing.
+const bellInterval = 5 * time.Second
+
+var (
+	// ringBell is set by the config file to ring the terminal bell when another user asks
+	// for attention.
+	ringBell bool
+
+	// bellOut is where the bell is written.
+	bellOut io.Writer = os.Stdout
+
+	// lastBell is the time the bell was last rung.
+	lastBell time.Time
+)
+
+// sendPing asks the other users for their attention.
+func sendPing(conn *websocket.Conn) {
+	if !e.IsConnected {
+		e.StatusChan <- "Not connected, nobody to ask for attention"
+		return
+	}
+	if err := writeMessage(conn, commons.Message{Type: commons.PingMessage, Username: username}); err != nil {
+		e.IsConnected = false
+		e.StatusChan <- "lost connection!"
+		return
+	}
+	e.StatusChan <- "Asked the others for their attention"
+}
+
+// handlePing shows that another user is asking for attention, and rings the bell if the
+// config file asks for it.
+func handlePing(name string, now time.Time) {
+	e.StatusChan <- fmt.Sprintf(">>> %s is asking for your attention <<<", name)
+	if ringBell && now.Sub(lastBell) >= bellInterval {
+		lastBell = now
+		_, _ = io.WriteString(bellOut, "\a")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+)
+
+// TestHandlePing checks that pings are shown in the status bar, and that the bell isn't
+// rung more than once per bellInterval.
+func TestHandlePing(t *testing.T) {
+	var out strings.Builder
+	defer func(w io.Writer, ring bool) { bellOut, ringBell, lastBell = w, ring, time.Time{} }(bellOut, ringBell)
+	bellOut, ringBell, lastBell = &out, true, time.Time{}
+
+	e = editor.NewEditor(editor.EditorConfig{})
+	go func(e *editor.Editor) {
+		for range e.StatusChan {
+		}
+	}(e)
+	defer close(e.StatusChan)
+
+	now := time.Now()
+	for _, at := range []time.Duration{0, time.Second, bellInterval - time.Millisecond, bellInterval, bellInterval + time.Second} {
+		handlePing("alice", now.Add(at))
+	}
+	if got := strings.Count(out.String(), "\a"); got != 2 {
+		t.Errorf("got %d bells, expected 2", got)
+	}
+}
@@ -0,0 +1,127 @@
+// Package plugin lets the pairpad client be extended with hooks, which are called on editor
+// events, for example to lint the document, format it or send notifications.
+//
+// Plugins register themselves with Register, usually from an init function. They're either
+// compiled into a client which imports them, or built as Go plugins
+// (go build -buildmode=plugin) and listed in the plugins setting of the client's config
+// file. The client can also run commands on events, configured in its [hooks] section.
+package plugin
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/burntcarrot/pairpad/commons"
+)
+
+// A Session is the editing session, as seen by plugins. Hooks are run by the client's event
+// loop, one at a time, and the session's methods must only be called from hooks.
+type Session interface {
+	// Text returns the document's content.
+	Text() string
+
+	// SetText replaces the document's content, and sends the changes to the other users.
+	SetText(text string)
+
+	// Status shows a message in the status bar.
+	Status(msg string)
+
+	// FileName returns the name of the file the document is saved to, or an empty string.
+	FileName() string
+
+	// Username returns the user's name in the session.
+	Username() string
+}
+
+// A Plugin is a set of hooks. Hooks which aren't needed are left nil.
+type Plugin struct {
+	// Name identifies the plugin in error messages.
+	Name string
+
+	// OnLocalInsert is called after the user has inserted value, with its first character
+	// at position (counted from 1, as in operations).
+	OnLocalInsert func(s Session, position int, value string)
+
+	// OnRemoteOperation is called after an operation from another user has been applied
+	// to the document.
+	OnRemoteOperation func(s Session, op commons.Operation)
+
+	// OnSave is called before the document is saved to fileName. If it returns an error,
+	// the error is shown in the status bar, and the document is saved anyway.
+	OnSave func(s Session, fileName string) error
+
+	// OnUserJoin is called when another user joins the session.
+	OnUserJoin func(s Session, name string)
+}
+
+var (
+	// mu protects plugins.
+	mu sync.Mutex
+
+	// plugins holds the registered plugins, in the order in which they were registered.
+	plugins []Plugin
+)
+
+// Register registers a plugin. Its hooks are called after those of the plugins registered
+// before it.
+func Register(p Plugin) {
+	mu.Lock()
+	defer mu.Unlock()
+	plugins = append(plugins, p)
+}
+
+// Plugins returns the registered plugins.
+func Plugins() []Plugin {
+	mu.Lock()
+	defer mu.Unlock()
+	return append([]Plugin(nil), plugins...)
+}
+
+// LocalInsert calls the OnLocalInsert hooks of the registered plugins.
+func LocalInsert(s Session, position int, value string) {
+	for _, p := range Plugins() {
+		if p.OnLocalInsert != nil {
+			run(s, p, func() error { p.OnLocalInsert(s, position, value); return nil })
+		}
+	}
+}
+
+// RemoteOperation calls the OnRemoteOperation hooks of the registered plugins.
+func RemoteOperation(s Session, op commons.Operation) {
+	for _, p := range Plugins() {
+		if p.OnRemoteOperation != nil {
+			run(s, p, func() error { p.OnRemoteOperation(s, op); return nil })
+		}
+	}
+}
+
+// Save calls the OnSave hooks of the registered plugins.
+func Save(s Session, fileName string) {
+	for _, p := range Plugins() {
+		if p.OnSave != nil {
+			run(s, p, func() error { return p.OnSave(s, fileName) })
+		}
+	}
+}
+
+// UserJoin calls the OnUserJoin hooks of the registered plugins.
+func UserJoin(s Session, name string) {
+	for _, p := range Plugins() {
+		if p.OnUserJoin != nil {
+			run(s, p, func() error { p.OnUserJoin(s, name); return nil })
+		}
+	}
+}
+
+// run calls a hook of p. Errors, and panics, are shown in the status bar, so that a broken
+// plugin doesn't stop the editor.
+func run(s Session, p Plugin, hook func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.Status(fmt.Sprintf("Plugin %s failed: %v", p.Name, r))
+		}
+	}()
+	if err := hook(); err != nil {
+		s.Status(fmt.Sprintf("Plugin %s: %s", p.Name, err))
+	}
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/google/go-cmp/cmp"
+)
+
+// testSession is a Session recording the status messages.
+type testSession struct {
+	text     string
+	statuses []string
+}
+
+func (s *testSession) Text() string        { return s.text }
+func (s *testSession) SetText(text string) { s.text = text }
+func (s *testSession) Status(msg string)   { s.statuses = append(s.statuses, msg) }
+func (s *testSession) FileName() string    { return "notes.txt" }
+func (s *testSession) Username() string    { return "alice" }
+
+func TestHooks(t *testing.T) {
+	defer func() { plugins = nil }()
+
+	var events []string
+	Register(Plugin{
+		Name: "recorder",
+		OnLocalInsert: func(s Session, position int, value string) {
+			events = append(events, "insert "+value)
+		},
+		OnRemoteOperation: func(s Session, op commons.Operation) {
+			events = append(events, "remote "+op.Type)
+		},
+		OnSave: func(s Session, fileName string) error {
+			s.SetText(s.Text() + "\n")
+			events = append(events, "save "+fileName)
+			return errors.New("lint failed")
+		},
+		OnUserJoin: func(s Session, name string) {
+			events = append(events, "join "+name)
+		},
+	})
+	Register(Plugin{
+		Name:       "broken",
+		OnUserJoin: func(s Session, name string) { panic("oops") },
+	})
+
+	s := &testSession{text: "a"}
+	LocalInsert(s, 1, "a")
+	RemoteOperation(s, commons.Operation{Type: "delete", Position: 1})
+	Save(s, s.FileName())
+	UserJoin(s, "bob")
+
+	expected := []string{"insert a", "remote delete", "save notes.txt", "join bob"}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+	if s.text != "a\n" {
+		t.Errorf("got text %q, expected %q", s.text, "a\n")
+	}
+
+	// Errors and panics are shown, rather than stopping the editor.
+	expected = []string{"Plugin recorder: lint failed", "Plugin broken failed: oops"}
+	if diff := cmp.Diff(expected, s.statuses); diff != "" {
+		t.Errorf("statuses mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	goplugin "plugin"
+	"runtime"
+	"unicode/utf8"
+
+	"github.com/burntcarrot/pairpad/client/plugin"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gorilla/websocket"
+)
+
+// HooksConfig holds the commands run on editor events, from the [hooks] section of the
+// config file. Commands are run by the shell, in the background, with the event as JSON on
+// their standard input, and in the PAIRPAD_EVENT, PAIRPAD_FILE and PAIRPAD_USER environment
+// variables.
+type HooksConfig struct {
+	OnLocalInsert     string `toml:"on_local_insert"`
+	OnRemoteOperation string `toml:"on_remote_operation"`
+	OnSave            string `toml:"on_save"`
+	OnUserJoin        string `toml:"on_user_join"`
+}
+
+// A hookEvent is sent to the commands run on editor events.
+type hookEvent struct {
+	Event     string             `json:"event"`
+	File      string             `json:"file,omitempty"`
+	User      string             `json:"user"`
+	Position  int                `json:"position,omitempty"`
+	Value     string             `json:"value,omitempty"`
+	Operation *commons.Operation `json:"operation,omitempty"`
+	Name      string             `json:"name,omitempty"`
+}
+
+// clientSession gives plugins access to the editing session.
+type clientSession struct {
+	conn *websocket.Conn
+}
+
+func (s clientSession) Text() string        { return crdt.Content(doc) }
+func (s clientSession) SetText(text string) { replaceText(text, s.conn) }
+func (s clientSession) Status(msg string)   { e.StatusChan <- msg }
+func (s clientSession) FileName() string    { return fileName }
+func (s clientSession) Username() string    { return username }
+
+// replaceText replaces the document's content with text. Only the characters which differ
+// are deleted and inserted, with the operations sent to the other clients, so the rest of
+// the document, and the other clients' edits to it, are kept.
+func replaceText(text string, conn *websocket.Conn) {
+	ops := commons.Diff(crdt.Content(doc), text)
+	if len(ops) == 0 {
+		return
+	}
+
+	// Keep the cursor on the same character.
+	cursor := e.Cursor
+	for i, op := range ops {
+		generated, err := doc.GenerateOperation(op)
+		if err != nil {
+			logger.Errorf("CRDT error: %v\n", err)
+			ops = ops[:i]
+			break
+		}
+		ops[i] = generated
+
+		if op.Type == "delete" {
+			if op.Position <= cursor {
+				cursor--
+			}
+			continue
+		}
+		if op.Position <= cursor {
+			cursor += utf8.RuneCountInString(op.Value)
+		}
+	}
+
+	e.SetText(crdt.Content(doc))
+	e.MoveCursorRunes(cursor - e.Cursor)
+	e.SetDirty(true)
+
+	for _, op := range ops {
+		if !e.IsConnected {
+			break
+		}
+		if err := sendOperation(op, conn); err != nil {
+			e.IsConnected = false
+			e.StatusChan <- "lost connection!"
+		}
+	}
+}
+
+// loadPlugins opens the Go plugins at paths, which register their hooks when they're
+// opened.
+func loadPlugins(paths []string) error {
+	for _, path := range paths {
+		if _, err := goplugin.Open(path); err != nil {
+			return fmt.Errorf("failed to load plugin %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// hooksPlugin returns a plugin running the commands configured for editor events.
+func hooksPlugin(conf HooksConfig) plugin.Plugin {
+	p := plugin.Plugin{Name: "hooks"}
+	if conf.OnLocalInsert != "" {
+		p.OnLocalInsert = func(s plugin.Session, position int, value string) {
+			runHook(conf.OnLocalInsert, hookEvent{Event: "local_insert", Position: position, Value: value})
+		}
+	}
+	if conf.OnRemoteOperation != "" {
+		p.OnRemoteOperation = func(s plugin.Session, op commons.Operation) {
+			runHook(conf.OnRemoteOperation, hookEvent{Event: "remote_operation", Operation: &op})
+		}
+	}
+	if conf.OnSave != "" {
+		p.OnSave = func(s plugin.Session, fileName string) error {
+			runHook(conf.OnSave, hookEvent{Event: "save"})
+			return nil
+		}
+	}
+	if conf.OnUserJoin != "" {
+		p.OnUserJoin = func(s plugin.Session, name string) {
+			runHook(conf.OnUserJoin, hookEvent{Event: "user_join", Name: name})
+		}
+	}
+	return p
+}
+
+// runHook runs a hook's command in the background, without waiting for it.
+func runHook(command string, ev hookEvent) {
+	ev.File, ev.User = fileName, username
+	data, err := json.Marshal(ev)
+	if err != nil {
+		logger.Errorf("failed to encode %s event: %v\n", ev.Event, err)
+		return
+	}
+
+	cmd := shellCommand(context.Background(), command)
+	cmd.Env = append(os.Environ(), "PAIRPAD_EVENT="+ev.Event, "PAIRPAD_FILE="+ev.File, "PAIRPAD_USER="+ev.User)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		logger.Errorf("failed to run %s hook: %v\n", ev.Event, err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		logger.Errorf("failed to run %s hook: %v\n", ev.Event, err)
+		return
+	}
+
+	go func() {
+		_, _ = stdin.Write(data)
+		stdin.Close()
+		if err := cmd.Wait(); err != nil {
+			logger.Errorf("%s hook failed: %v\n", ev.Event, err)
+		}
+	}()
+}
+
+// shellCommand returns the command running command with the shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
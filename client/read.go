@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gorilla/websocket"
+)
+
+// defaultMaxMessageSize is the default maximum size of a message from the server, in bytes.
+// It's far larger than the documents people edit together, and far smaller than the
+// memory of their machines.
+const defaultMaxMessageSize = 64 << 20
+
+// maxMessageSize is the maximum size of a message from the server, set on the connection
+// by limitReads. Zero means no limit.
+var maxMessageSize int64
+
+// limitReads makes conn fail reading messages larger than limit bytes, so a malicious
+// server or peer can't exhaust the client's memory with a gigantic document. A limit of
+// zero removes it.
+func limitReads(conn *websocket.Conn, limit int64) {
+	maxMessageSize = limit
+	conn.SetReadLimit(limit)
+}
+
+// readMessage reads a message from conn. Document syncs are decoded as they're read,
+// without holding the whole message in memory. A message larger than the connection's read
+// limit fails with websocket.ErrReadLimit, and the connection is closed.
+func readMessage(conn *websocket.Conn) (commons.Message, error) {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return commons.Message{}, err
+	}
+	return decodeMessage(r)
+}
+
+// tooLargeStatus describes the failure to read a message larger than maxMessageSize, for
+// the status bar.
+func tooLargeStatus() string {
+	return fmt.Sprintf("disconnected: the server sent a message larger than %s (see -max-message-size)", formatBytes(maxMessageSize))
+}
+
+// formatBytes formats a number of bytes in the largest unit which divides it, up to MiB.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%d MiB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%d KiB", n>>10)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// decodeMessage decodes a JSON-encoded message from r. The characters of its document, if
+// any, are decoded one at a time; the other fields are decoded as json.Unmarshal would.
+func decodeMessage(r io.Reader) (commons.Message, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return commons.Message{}, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	var doc crdt.Document
+	for dec.More() {
+		key, err := decodeKey(dec)
+		if err != nil {
+			return commons.Message{}, err
+		}
+		if strings.EqualFold(key, "document") {
+			if doc, err = decodeDocument(dec); err != nil {
+				return commons.Message{}, fmt.Errorf("document: %w", err)
+			}
+			continue
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return commons.Message{}, err
+		}
+		fields[key] = raw
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return commons.Message{}, err
+	}
+
+	// The other fields are small, so they're decoded together.
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return commons.Message{}, err
+	}
+	var msg commons.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return commons.Message{}, err
+	}
+	msg.Document = doc
+	return msg, nil
+}
+
+// decodeDocument decodes a JSON-encoded document from dec, one character at a time.
+func decodeDocument(dec *json.Decoder) (crdt.Document, error) {
+	var doc crdt.Document
+	t, err := dec.Token()
+	if err != nil || t == nil {
+		return doc, err
+	}
+	if d, ok := t.(json.Delim); !ok || d != '{' {
+		return doc, fmt.Errorf("got %v, expected an object", t)
+	}
+
+	for dec.More() {
+		key, err := decodeKey(dec)
+		if err != nil {
+			return doc, err
+		}
+		if !strings.EqualFold(key, "characters") {
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return doc, err
+			}
+			continue
+		}
+
+		t, err := dec.Token()
+		if err != nil {
+			return doc, err
+		}
+		if t == nil {
+			continue
+		}
+		if d, ok := t.(json.Delim); !ok || d != '[' {
+			return doc, fmt.Errorf("characters: got %v, expected an array", t)
+		}
+		for dec.More() {
+			var c crdt.Character
+			if err := dec.Decode(&c); err != nil {
+				return doc, fmt.Errorf("characters: %w", err)
+			}
+			doc.Characters = append(doc.Characters, c)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return doc, err
+		}
+	}
+	return doc, expectDelim(dec, '}')
+}
+
+// decodeKey decodes the key of an object's member from dec.
+func decodeKey(dec *json.Decoder) (string, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := t.(string)
+	if !ok {
+		return "", fmt.Errorf("got %v, expected a key", t)
+	}
+	return key, nil
+}
+
+// expectDelim decodes the delimiter delim from dec.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := t.(json.Delim); !ok || d != delim {
+		return fmt.Errorf("got %v, expected %v", t, delim)
+	}
+	return nil
+}
+
+// isReadLimit reports whether err is the failure to read a message larger than the
+// connection's read limit.
+func isReadLimit(err error) bool {
+	return errors.Is(err, websocket.ErrReadLimit)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// TestDecodeMessage checks that messages are decoded as json.Unmarshal decodes them.
+func TestDecodeMessage(t *testing.T) {
+	doc, _ := crdt.FromText("hello")
+	sync := commons.NewDocSyncMessage(doc, uuid.New())
+	sync.Annotations = []commons.Annotation{{ID: "1", Author: "alice", Text: "hi", Start: 1, End: 2}}
+	data, _ := json.Marshal(sync)
+
+	tests := []struct {
+		description string
+		data        string
+	}{
+		{description: "document sync", data: string(data)},
+		{description: "operation", data: `{"type":"operation","operation":{"type":"insert","position":1,"value":"a"},"ID":"` + uuid.NewString() + `"}`},
+		{description: "null document", data: `{"type":"docSync","document":null}`},
+		{description: "keys in another case", data: `{"Type":"docSync","Document":{"characters":[{"ID":"start"}],"extra":1}}`},
+	}
+
+	for _, tc := range tests {
+		var expected commons.Message
+		if err := json.Unmarshal([]byte(tc.data), &expected); err != nil {
+			t.Fatalf("%s: %s", tc.description, err)
+		}
+		got, err := decodeMessage(strings.NewReader(tc.data))
+		if err != nil {
+			t.Errorf("%s: got error %s", tc.description, err)
+			continue
+		}
+		if diff := cmp.Diff(expected, got); diff != "" {
+			t.Errorf("%s: got a different message (-expected +got):\n%s", tc.description, diff)
+		}
+	}
+
+	for _, data := range []string{`[]`, `{"type":"docSync","document":{"Characters":{}}}`, `{"document":`} {
+		if _, err := decodeMessage(strings.NewReader(data)); err == nil {
+			t.Errorf("got no error decoding %s", data)
+		}
+	}
+}
+
+// TestReadLimit checks that messages larger than the read limit fail to be read.
+func TestReadLimit(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteJSON(commons.Message{Type: commons.NoticeMessage, Text: "hi"})
+		_ = conn.WriteJSON(commons.Message{Type: commons.NoticeMessage, Text: strings.Repeat("a", 2048)})
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	defer func(limit int64) { maxMessageSize = limit }(maxMessageSize)
+	limitReads(conn, 1024)
+
+	if msg, err := readMessage(conn); err != nil || msg.Text != "hi" {
+		t.Fatalf("got message %+v, error %v, expected the first notice", msg, err)
+	}
+	if _, err := readMessage(conn); !isReadLimit(err) {
+		t.Errorf("got error %v, expected %v", err, websocket.ErrReadLimit)
+	}
+	if expected := "disconnected: the server sent a message larger than 1 KiB (see -max-message-size)"; tooLargeStatus() != expected {
+		t.Errorf("got status %q, expected %q", tooLargeStatus(), expected)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// recentFile is the name of the state file holding the recently joined sessions.
+	recentFile = "recent.json"
+
+	// maxRecent is the number of recent sessions kept.
+	maxRecent = 10
+)
+
+// A recentSession is a session the client joined recently, with the file it edited, if any.
+type recentSession struct {
+	File   string    `json:"file,omitempty"`
+	Server string    `json:"server"`
+	Room   string    `json:"room"`
+	Secure bool      `json:"secure,omitempty"`
+	SSH    string    `json:"ssh,omitempty"`
+	Joined time.Time `json:"joined"`
+}
+
+// readRecent returns the recently joined sessions, from the most recent.
+func readRecent() ([]recentSession, error) {
+	var recent []recentSession
+	return recent, readState(recentFile, &recent)
+}
+
+// addRecent records joining the session of flags, with its file, as the most recent one.
+// The same session, with the same file, is only listed once.
+func addRecent(flags Flags, now time.Time) error {
+	file := flags.File
+	if file != "" {
+		var err error
+		if file, err = filepath.Abs(file); err != nil {
+			return err
+		}
+	}
+	recent, err := readRecent()
+	if err != nil {
+		return err
+	}
+
+	s := recentSession{File: file, Server: flags.Server, Room: flags.Room, Secure: flags.Secure, SSH: flags.SSH, Joined: now}
+	kept := []recentSession{s}
+	for _, r := range recent {
+		if r.File == s.File && r.Server == s.Server && r.Room == s.Room && r.Secure == s.Secure && r.SSH == s.SSH {
+			continue
+		}
+		if len(kept) == maxRecent {
+			break
+		}
+		kept = append(kept, r)
+	}
+	return writeState(recentFile, kept)
+}
+
+// String describes the session, as listed by the picker.
+func (r recentSession) String() string {
+	server := r.Server
+	if r.SSH != "" {
+		server += " through " + r.SSH
+	}
+	desc := fmt.Sprintf("%s on %s (%s)", describeRoom(r.Room), server, r.Joined.Format("Jan 2 15:04"))
+	if r.File != "" {
+		desc = r.File + " in " + desc
+	}
+	return desc
+}
+
+// pickRecent lists the recently joined sessions, and resumes the one picked by its number:
+// flags are set to join it, and to edit its file. Any other answer keeps flags as they are.
+func pickRecent(flags *Flags, in *bufio.Scanner, out io.Writer) error {
+	recent, err := readRecent()
+	if err != nil {
+		return err
+	}
+	if len(recent) == 0 {
+		fmt.Fprintln(out, "No recent sessions.")
+		return nil
+	}
+
+	fmt.Fprintln(out, "Recent sessions:")
+	for i, r := range recent {
+		fmt.Fprintf(out, "%3d. %s\n", i+1, r)
+	}
+	fmt.Fprintf(out, "Resume which? [1-%d, Enter to skip] ", len(recent))
+	in.Scan()
+	n, err := strconv.Atoi(strings.TrimSpace(in.Text()))
+	if err != nil || n < 1 || n > len(recent) {
+		return nil
+	}
+
+	r := recent[n-1]
+	flags.Server, flags.Room, flags.Secure, flags.SSH, flags.File = r.Server, r.Room, r.Secure, r.SSH, r.File
+	flags.SessionSet = true
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRecent checks that recent sessions are listed from the most recent, once each, and
+// that picking one resumes it.
+func TestRecent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	file := filepath.Join(t.TempDir(), "notes.md")
+
+	at := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	for i, flags := range []Flags{
+		{Server: "localhost:8080"},
+		{Server: "pair.example.com", Room: "team-a", Secure: true, File: file},
+		{Server: "localhost:8080"},
+	} {
+		if err := addRecent(flags, at.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out strings.Builder
+	flags := Flags{Server: "localhost:8080"}
+	if err := pickRecent(&flags, bufio.NewScanner(strings.NewReader("2\n")), &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("Recent sessions:\n  1. the default room on localhost:8080 (Oct 1 11:00)\n  2. %s in room team-a on pair.example.com (Oct 1 10:00)\nResume which? [1-2, Enter to skip] ", file)
+	if got := out.String(); got != expected {
+		t.Errorf("got listing %q, expected %q", got, expected)
+	}
+	if expected := (Flags{Server: "pair.example.com", Room: "team-a", Secure: true, File: file, SessionSet: true}); flags != expected {
+		t.Errorf("got %+v, expected %+v", flags, expected)
+	}
+
+	// Other answers keep the flags.
+	for _, answer := range []string{"\n", "3\n", "x\n"} {
+		flags := Flags{Server: "localhost:8080"}
+		if err := pickRecent(&flags, bufio.NewScanner(strings.NewReader(answer)), io.Discard); err != nil || flags != (Flags{Server: "localhost:8080"}) {
+			t.Errorf("answering %q: got %+v, %v, expected the flags unchanged", answer, flags, err)
+		}
+	}
+}
+
+// TestAddRecentLimit checks that only the most recent sessions are kept.
+func TestAddRecentLimit(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	for i := 0; i < maxRecent+3; i++ {
+		if err := addRecent(Flags{Server: "localhost:8080", Room: fmt.Sprint(i)}, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recent, err := readRecent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != maxRecent || recent[0].Room != fmt.Sprint(maxRecent+2) {
+		t.Errorf("got %d sessions, from room %q, expected %d, from room %q", len(recent), recent[0].Room, maxRecent, fmt.Sprint(maxRecent+2))
+	}
+}
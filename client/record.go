@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gdamore/tcell/v2"
+	"github.com/gorilla/websocket"
+)
+
+// maxReplayGap bounds the time waited between two entries of a replayed recording.
+const maxReplayGap = time.Second
+
+// The kinds of entries of input recordings.
+const (
+	entryStart = "start" // The client's state when the editor starts.
+	entryEvent = "event" // A key, resize or paste event.
+	entryIn    = "in"    // A message received from the server.
+	entryOut   = "out"   // A message sent to the server.
+)
+
+// A recordEntry is a line of an input recording, written by -record-input and read by
+// -replay-input.
+type recordEntry struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+
+	// Start is set for start entries.
+	Start *recordStart `json:"start,omitempty"`
+
+	// Event is set for event entries.
+	Event *recordEvent `json:"event,omitempty"`
+
+	// Message is set for in and out entries.
+	Message *commons.Message `json:"message,omitempty"`
+}
+
+// recordStart holds the client's state when the editor starts.
+type recordStart struct {
+	Username   string        `json:"username"`
+	FileName   string        `json:"fileName"`
+	Document   crdt.Document `json:"document"`
+	ImportText string        `json:"importText,omitempty"`
+	Width      int           `json:"width"`
+	Height     int           `json:"height"`
+	Debug      bool          `json:"debug,omitempty"`
+}
+
+// The types of recorded events.
+const (
+	eventKey    = "key"
+	eventResize = "resize"
+	eventPaste  = "paste"
+)
+
+// recordEvent holds the fields of a key, resize or paste event.
+type recordEvent struct {
+	Type   string        `json:"type"`
+	Mod    tcell.ModMask `json:"mod,omitempty"`
+	Key    tcell.Key     `json:"key,omitempty"`
+	Ch     rune          `json:"ch,omitempty"`
+	Width  int           `json:"width,omitempty"`
+	Height int           `json:"height,omitempty"`
+
+	// Start is set for the events starting a paste.
+	Start bool `json:"start,omitempty"`
+}
+
+// newRecordEvent returns the fields of ev, or nil if it isn't a key, resize or paste
+// event.
+func newRecordEvent(ev tcell.Event) *recordEvent {
+	switch ev := ev.(type) {
+	case *tcell.EventKey:
+		return &recordEvent{Type: eventKey, Mod: ev.Modifiers(), Key: ev.Key(), Ch: ev.Rune()}
+	case *tcell.EventResize:
+		width, height := ev.Size()
+		return &recordEvent{Type: eventResize, Width: width, Height: height}
+	case *tcell.EventPaste:
+		return &recordEvent{Type: eventPaste, Start: ev.Start()}
+	}
+	return nil
+}
+
+// event returns the recorded event, or nil if its type is unknown.
+func (r recordEvent) event() tcell.Event {
+	switch r.Type {
+	case eventKey:
+		return tcell.NewEventKey(r.Key, r.Ch, r.Mod)
+	case eventResize:
+		return tcell.NewEventResize(r.Width, r.Height)
+	case eventPaste:
+		return tcell.NewEventPaste(r.Start)
+	}
+	return nil
+}
+
+// A recorder writes an input recording. Its methods do nothing on a nil recorder, so they
+// can be called whether or not input is being recorded.
+type recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// rec records the client's input, if -record-input is set.
+var rec *recorder
+
+// newRecorder creates the recording file at path.
+func newRecorder(path string) (*recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// write appends an entry to the recording.
+func (r *recorder) write(entry recordEntry) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry.Time = time.Now()
+	if err := r.enc.Encode(entry); err != nil {
+		logger.Errorf("failed to record input: %v\n", err)
+	}
+}
+
+// start records the client's state when the editor starts.
+func (r *recorder) start(width, height int) {
+	r.write(recordEntry{Kind: entryStart, Start: &recordStart{
+		Username:   username,
+		FileName:   fileName,
+		Document:   doc,
+		ImportText: importText,
+		Width:      width,
+		Height:     height,
+		Debug:      flags.Debug,
+	}})
+}
+
+// event records a key, resize or paste event. Other events aren't recorded.
+func (r *recorder) event(ev tcell.Event) {
+	if recorded := newRecordEvent(ev); recorded != nil {
+		r.write(recordEntry{Kind: entryEvent, Event: recorded})
+	}
+}
+
+// message records a message received from, or sent to, the server.
+func (r *recorder) message(kind string, msg commons.Message) {
+	r.write(recordEntry{Kind: kind, Message: &msg})
+}
+
+// close closes the recording file.
+func (r *recorder) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.f.Close(); err != nil {
+		fmt.Printf("Failed to close input recording: %s\n", err)
+	}
+}
+
+// writeMessage sends a message to the server, and records it if input is being recorded,
+// and in the transcript.
+func writeMessage(conn *websocket.Conn, msg commons.Message) error {
+	rec.message(entryOut, msg)
+	trans.message(entryOut, msg, time.Now())
+	_ = conn.SetWriteDeadline(writeDeadline())
+	return conn.WriteJSON(msg)
+}
+
+// A replayer replays an input recording against a fresh editor: it feeds the recorded
+// events and received messages to the main loop, in their order, and compares the
+// messages the client sends with the recorded ones.
+type replayer struct {
+	start   recordStart
+	entries []recordEntry
+
+	// out holds the recorded messages sent by the client.
+	out []commons.Message
+
+	// events and msgs carry the replayed events and messages to the main loop.
+	events chan tcell.Event
+	msgs   chan commons.Message
+
+	mu sync.Mutex
+
+	// sent counts the messages the client has sent so far.
+	sent int
+
+	// diverged describes the first sent message differing from the recording, if any.
+	diverged string
+}
+
+// replay is the recording being replayed, if -replay-input is set.
+var replay *replayer
+
+// loadReplay reads the recording at path.
+func loadReplay(path string) (*replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &replayer{events: make(chan tcell.Event), msgs: make(chan commons.Message)}
+	started := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var entry recordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Recordings made before the client used tcell hold termbox events, whose
+			// types are numbers.
+			var typeErr *json.UnmarshalTypeError
+			if entry.Kind == entryEvent && errors.As(err, &typeErr) && typeErr.Value == "number" {
+				return nil, fmt.Errorf("line %d: the recording holds termbox events, which this version of pairpad can't replay", line)
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if entry.Kind == entryStart && entry.Start != nil {
+			r.start, started = *entry.Start, true
+			continue
+		}
+		r.entries = append(r.entries, entry)
+		if entry.Kind == entryOut && entry.Message != nil {
+			r.out = append(r.out, *entry.Message)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !started {
+		return nil, errors.New("no start entry in recording")
+	}
+	return r, nil
+}
+
+// run feeds the recorded events and messages to the main loop, waiting as long between
+// them as when they were recorded, up to maxReplayGap.
+func (r *replayer) run() {
+	var last time.Time
+	for _, entry := range r.entries {
+		if !last.IsZero() {
+			gap := entry.Time.Sub(last)
+			if gap > maxReplayGap {
+				gap = maxReplayGap
+			}
+			time.Sleep(gap)
+		}
+		last = entry.Time
+
+		switch {
+		case entry.Kind == entryEvent && entry.Event != nil:
+			if ev := entry.Event.event(); ev != nil {
+				r.events <- ev
+			}
+		case entry.Kind == entryIn && entry.Message != nil:
+			r.msgs <- *entry.Message
+		}
+	}
+
+	// Give the last messages sent by the client time to be checked.
+	time.Sleep(100 * time.Millisecond)
+
+	r.mu.Lock()
+	diverged := r.diverged
+	r.mu.Unlock()
+	if diverged != "" {
+		e.StatusChan <- "Replay finished, diverged: " + diverged
+	} else {
+		e.StatusChan <- "Replay finished"
+	}
+}
+
+// check compares a message sent by the client with the recorded one.
+func (r *replayer) check(msg commons.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.sent
+	r.sent++
+	if r.diverged != "" {
+		return
+	}
+
+	got, _ := json.Marshal(msg)
+	if n >= len(r.out) {
+		r.diverged = fmt.Sprintf("unexpected message %d: %s", r.sent, got)
+	} else if expected, _ := json.Marshal(r.out[n]); string(got) != string(expected) {
+		r.diverged = fmt.Sprintf("message %d: got %s, recorded %s", r.sent, got, expected)
+	}
+	if r.diverged != "" {
+		logger.Errorf("replay diverged at %s\n", r.diverged)
+	}
+}
+
+// connect returns a connection to a local WebSocket server standing in for the server,
+// which checks the messages it gets against the recording and never sends any.
+func (r *replayer) connect() (*websocket.Conn, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	upgrader := websocket.Upgrader{}
+	go func() {
+		_ = http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			conn, err := upgrader.Upgrade(w, req, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for {
+				var msg commons.Message
+				if err := conn.ReadJSON(&msg); err != nil {
+					return
+				}
+				r.check(msg)
+			}
+		}))
+	}()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/", nil)
+	return conn, err
+}
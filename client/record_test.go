@@ -0,0 +1,121 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gdamore/tcell/v2"
+)
+
+// TestRecordReplay checks that replaying an input recording against a fresh editor sends
+// the recorded messages and ends with the recorded document, and that replays sending
+// other messages are reported as diverged.
+func TestRecordReplay(t *testing.T) {
+	prevSiteID, prevClock := crdt.SiteID, crdt.LocalClock
+	defer func() {
+		crdt.SiteID, crdt.LocalClock = prevSiteID, prevClock
+		rec, replay, username = nil, nil, ""
+	}()
+	path := filepath.Join(t.TempDir(), "input.jsonl")
+
+	key := func(ch rune) recordEntry {
+		return recordEntry{Kind: entryEvent, Event: &recordEvent{Type: eventKey, Key: tcell.KeyRune, Ch: ch}}
+	}
+	remote := commons.Operation{Type: "insert", Position: 1, Value: ">"}
+	session := []recordEntry{
+		key('h'),
+		key('i'),
+		{Kind: entryIn, Message: &commons.Message{Type: commons.OperationMessage, Operation: remote}},
+		{Kind: entryEvent, Event: &recordEvent{Type: eventKey, Key: tcell.KeyBackspace2}},
+		key('!'),
+		{Kind: entryEvent, Event: &recordEvent{Type: eventPaste, Start: true}},
+		key('?'),
+		{Kind: entryEvent, Event: &recordEvent{Type: eventKey, Key: tcell.KeyEnter, Ch: '\r'}},
+		{Kind: entryEvent, Event: &recordEvent{Type: eventPaste}},
+	}
+
+	// feed passes the events and messages of entries to the editor, as the main loop does.
+	feed := func(entries []recordEntry, record bool) {
+		conn := sink(t)
+		if replay != nil {
+			var err error
+			if conn, err = replay.connect(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, entry := range entries {
+			switch entry.Kind {
+			case entryEvent:
+				ev := entry.Event.event()
+				if record {
+					rec.event(ev)
+				}
+				_ = handleEvent(ev, conn)
+			case entryIn:
+				if record {
+					rec.message(entryIn, *entry.Message)
+				}
+				handleMsg(*entry.Message, conn)
+			}
+		}
+	}
+
+	// Record the session.
+	crdt.SiteID, crdt.LocalClock = 2, 0
+	doc, e, username = crdt.New(), editor.NewEditor(editor.EditorConfig{}), "alice"
+	e.IsConnected = true
+	var err error
+	if rec, err = newRecorder(path); err != nil {
+		t.Fatal(err)
+	}
+	rec.start(80, 24)
+	feed(session, true)
+	rec.close()
+	rec = nil
+	recorded := crdt.Content(doc)
+	if recorded != ">h!?\n" {
+		t.Fatalf("got %q recorded, expected %q", recorded, ">h!?\n")
+	}
+
+	// Replay it from the recorded state.
+	if replay, err = loadReplay(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(replay.entries) - len(replay.out); got != len(session) || len(replay.out) == 0 {
+		t.Fatalf("got %d events and messages received, and %d sent, expected %d, and the messages sent", got, len(replay.out), len(session))
+	}
+	crdt.SiteID, crdt.LocalClock = 2, 0
+	doc, e, username = replay.start.Document, editor.NewEditor(editor.EditorConfig{}), replay.start.Username
+	e.IsConnected = true
+	feed(replay.entries, false)
+
+	if got := crdt.Content(doc); got != recorded {
+		t.Errorf("got %q replayed, expected %q", got, recorded)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		replay.mu.Lock()
+		sent, diverged := replay.sent, replay.diverged
+		replay.mu.Unlock()
+		if diverged != "" {
+			t.Fatalf("got replay diverged at %s, expected it to send the recorded messages", diverged)
+		}
+		if sent == len(replay.out) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d messages sent, expected %d", sent, len(replay.out))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Sending anything else diverges.
+	replay.check(commons.Message{Type: commons.PingMessage})
+	if replay.diverged == "" {
+		t.Error("got no divergence after an unexpected message, expected one")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/client/plugin"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gorilla/websocket"
+)
+
+// saveProgressDelay is how long a save may take before the status bar shows it's under way.
+const saveProgressDelay = 500 * time.Millisecond
+
+// A saveJob writes a snapshot of the document to its file in the background, so that
+// saving a large document doesn't freeze the editor.
+type saveJob struct {
+	name string
+	doc  crdt.Document
+
+	// newline is the line ending of plain text files.
+	newline string
+
+	// content is the document's content when it was saved, to tell whether it was edited
+	// while it was written.
+	content string
+
+	err error
+}
+
+var (
+	// saving is the save being written, if any, and saveQueued is set when the document is
+	// saved again once it's written. They're only used by the main loop.
+	saving     *saveJob
+	saveQueued bool
+
+	// savedChan receives the saves once they're written.
+	savedChan = make(chan *saveJob, 1)
+
+	// ownFile is the file the document was loaded from or saved to. Saving to another file
+	// which exists asks first, so that an unrelated file isn't overwritten without notice.
+	ownFile string
+)
+
+// save saves the document to fileName in the background, and shows the result in the status
+// bar. The plugins' OnSave hooks are called first. If the document is already being saved,
+// it's saved again once that save is written.
+func save(conn *websocket.Conn) {
+	if !prepareSave(conn, func() error { save(conn); return nil }) {
+		return
+	}
+	if saving != nil {
+		saveQueued = true
+		return
+	}
+	startSave(fileName)
+}
+
+// saveThen saves the document as save does, but waits until it's written, and then calls
+// then, returning its error. If the save fails, or is called off, then isn't called. Replays
+// aren't saved, but then is still called.
+func saveThen(conn *websocket.Conn, then func() error) error {
+	if !prepareSave(conn, func() error { return saveThen(conn, then) }) {
+		if replay != nil {
+			return then()
+		}
+		return nil
+	}
+	saveQueued = false
+	waitSaves()
+	job := startSave(fileName)
+	waitSaves()
+	if job.err != nil {
+		return nil
+	}
+	return then()
+}
+
+// prepareSave sets the name of the file saved, and calls the plugins' OnSave hooks. It
+// returns false if the document isn't saved. If the file exists, but isn't ownFile, it asks
+// whether to overwrite it, and calls retry if so.
+func prepareSave(conn *websocket.Conn, retry func() error) bool {
+	// If no file name is specified, set filename to "pairpad-content.txt"
+	if fileName == "" {
+		fileName = "pairpad-content.txt"
+	}
+
+	// Replays don't overwrite the file they were recorded with.
+	if replay != nil {
+		e.StatusChan <- "Not saved while replaying"
+		return false
+	}
+
+	if _, err := os.Stat(fileName); fileName != ownFile && err == nil {
+		e.ShowPrompt(&editor.Prompt{
+			Text: fmt.Sprintf("%s already exists, overwrite it? (y/n)", fileName),
+			Answers: map[rune]func() error{
+				'y': func() error {
+					ownFile = fileName
+					return retry()
+				},
+				'n': func() error {
+					e.StatusChan <- "Not saved"
+					return nil
+				},
+			},
+		})
+		return false
+	}
+
+	plugin.Save(clientSession{conn}, fileName)
+	e.SetFileName(fileName)
+	return true
+}
+
+// startSave starts writing a snapshot of the document to the named file. The save is sent
+// to savedChan once it's written.
+func startSave(name string) *saveJob {
+	job := &saveJob{
+		name:    name,
+		doc:     crdt.Document{Characters: append([]crdt.Character(nil), doc.Characters...)},
+		newline: fileNewline,
+		content: crdt.Content(doc),
+	}
+	saving = job
+	ownFile = name
+
+	go func() {
+		written := make(chan error, 1)
+		go func() { written <- saveFile(job.name, &job.doc, job.newline) }()
+		select {
+		case job.err = <-written:
+		case <-time.After(saveProgressDelay):
+			e.StatusChan <- fmt.Sprintf("Saving document to %s...", job.name)
+			job.err = <-written
+		}
+		savedChan <- job
+	}()
+	return job
+}
+
+// finishSave shows the result of a save once it's written, and starts the save asked for
+// meanwhile, if any.
+func finishSave(job *saveJob) {
+	saving = nil
+	if job.err != nil {
+		logger.Errorf("failed to save to %s, err: %v\n", job.name, job.err)
+		e.StatusChan <- fmt.Sprintf("Failed to save to %s", job.name)
+	} else {
+		// The edits made while the document was written are still unsaved.
+		if crdt.Content(doc) == job.content {
+			e.SetDirty(false)
+		}
+		refreshGitStatus()
+		refreshFileFormat()
+		e.StatusChan <- fmt.Sprintf("Saved document to %s", job.name)
+	}
+
+	if saveQueued {
+		saveQueued = false
+		startSave(fileName)
+	}
+}
+
+// waitSaves waits until the saves started are written, so that exiting doesn't cut them
+// short.
+func waitSaves() {
+	for saving != nil {
+		finishSave(<-savedChan)
+	}
+}
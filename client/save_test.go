@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gdamore/tcell/v2"
+)
+
+// TestSave checks that documents are written in the background, that a save asked for while
+// another is written is written after it, and that edits made meanwhile stay unsaved.
+func TestSave(t *testing.T) {
+	defer func() { fileName = "" }()
+	fileName = filepath.Join(t.TempDir(), "notes.txt")
+	doc, _ = crdt.FromText("hello")
+	e = editor.NewEditor(editor.EditorConfig{})
+	e.SetDirty(true)
+
+	save(nil)
+	if saving == nil {
+		t.Fatal("got no save under way, expected one")
+	}
+
+	// Saving again while the first save is written queues another one, which writes the
+	// document as it is by then.
+	if _, err := doc.Insert(6, "!"); err != nil {
+		t.Fatal(err)
+	}
+	save(nil)
+	if !saveQueued {
+		t.Error("got no save queued, expected one")
+	}
+	waitSaves()
+
+	if content, err := os.ReadFile(fileName); err != nil || string(content) != "hello!" {
+		t.Errorf("got file %q, %v, expected %q", content, err, "hello!")
+	}
+	if e.IsDirty() {
+		t.Error("got the document dirty, expected it saved")
+	}
+
+	// Edits made while a save is written stay unsaved.
+	startSave(fileName)
+	if _, err := doc.Insert(7, "?"); err != nil {
+		t.Fatal(err)
+	}
+	e.SetDirty(true)
+	waitSaves()
+	if !e.IsDirty() {
+		t.Error("got the document saved, expected the edit made during the save to be unsaved")
+	}
+}
+
+// TestSaveOverwrite checks that saving to an existing file which the document wasn't loaded
+// from asks first, and only overwrites it if answered yes.
+func TestSaveOverwrite(t *testing.T) {
+	defer func() { fileName, ownFile = "", "" }()
+	fileName, ownFile = filepath.Join(t.TempDir(), "notes.txt"), ""
+	if err := os.WriteFile(fileName, []byte("unrelated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc, _ = crdt.FromText("hello")
+	e = editor.NewEditor(editor.EditorConfig{})
+
+	save(nil)
+	if saving != nil || !e.PromptActive() {
+		t.Fatal("got the file saved, expected to be asked first")
+	}
+	if err := e.AnswerPrompt(tcell.NewEventKey(tcell.KeyRune, 'n', tcell.ModNone)); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(fileName); string(content) != "unrelated" {
+		t.Errorf("got file %q after answering no, expected it unchanged", content)
+	}
+
+	save(nil)
+	if err := e.AnswerPrompt(tcell.NewEventKey(tcell.KeyRune, 'y', tcell.ModNone)); err != nil {
+		t.Fatal(err)
+	}
+	waitSaves()
+	if content, _ := os.ReadFile(fileName); string(content) != "hello" {
+		t.Errorf("got file %q after answering yes, expected the document", content)
+	}
+
+	// Once saved, the file is the document's, and saving again doesn't ask.
+	save(nil)
+	if e.PromptActive() {
+		t.Error("got asked again, expected the file saved")
+	}
+	waitSaves()
+}
+
+// TestExitUnsaved checks that local and remote edits mark the document unsaved, and that
+// exiting then asks whether to save it first.
+func TestExitUnsaved(t *testing.T) {
+	conn := sink(t)
+	esc := tcell.NewEventKey(tcell.KeyEsc, 0, tcell.ModNone)
+	answer := func(ch rune) error {
+		return handleEvent(tcell.NewEventKey(tcell.KeyRune, ch, tcell.ModNone), conn)
+	}
+
+	tests := []struct {
+		description string
+		edit        func()
+	}{
+		{"local edit", func() { _ = answer('a') }},
+		{"remote edit", func() {
+			handleMsg(commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: 1, Value: "b"}}, conn)
+		}},
+	}
+
+	for _, tc := range tests {
+		doc, e = crdt.New(), editor.NewEditor(editor.EditorConfig{})
+		if err := handleEvent(esc, conn); err != errExit {
+			t.Fatalf("(%s) got %v exiting before editing, expected to exit", tc.description, err)
+		}
+
+		tc.edit()
+		if !e.IsDirty() {
+			t.Fatalf("(%s) got the document saved, expected it unsaved", tc.description)
+		}
+
+		// Cancelling stays in the editor, and not saving exits.
+		if err := handleEvent(esc, conn); err != nil || !e.PromptActive() {
+			t.Fatalf("(%s) got %v and prompt shown: %t, expected the save prompt", tc.description, err, e.PromptActive())
+		}
+		if err := answer('c'); err != nil || e.PromptActive() {
+			t.Errorf("(%s) got %v and prompt shown: %t cancelling, expected to stay in the editor", tc.description, err, e.PromptActive())
+		}
+		_ = handleEvent(esc, conn)
+		if err := answer('n'); err != errExit {
+			t.Errorf("(%s) got %v not saving, expected to exit", tc.description, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// A remoteSelection is the range selected by another user, and their cursor, anchored to the
+// local document's characters, so they follow them as the document is edited.
+type remoteSelection struct {
+	name string
+
+	// anchor is the selected range, if selected is set.
+	anchor   crdt.Anchor
+	selected bool
+
+	// cursor is anchored to the character before the user's cursor, if hasCursor is set.
+	// It's the zero anchor if the cursor is at the start of the document.
+	cursor    crdt.Anchor
+	hasCursor bool
+}
+
+var (
+	// selectionMark is the position at which the local selection starts, or -1 if nothing
+	// is selected. The selection is the range between the mark and the cursor.
+	selectionMark = -1
+
+	// sentSelection is the last selection sent to the other clients.
+	sentSelection commons.Selection
+
+	// remoteSelections holds the other users' selections, keyed by their client ID.
+	remoteSelections = make(map[uuid.UUID]remoteSelection)
+)
+
+// toggleSelection handles the selection key. The first press starts selecting at the cursor,
+// and the second press clears the selection.
+func toggleSelection() {
+	if selectionMark >= 0 {
+		selectionMark = -1
+		return
+	}
+	selectionMark, blockMode = e.Cursor, false
+	e.StatusChan <- "Selection started, move the cursor to select, and press Ctrl+Space again to clear it"
+}
+
+// localSelection returns the local selection and cursor, as sent to the other clients.
+func localSelection() commons.Selection {
+	sel := selectedRange()
+	sel.Cursor = e.Cursor + 1
+	return sel
+}
+
+// selectedRange returns the range of the local selection, counted from 1.
+func selectedRange() commons.Selection {
+	if selectionMark < 0 {
+		return commons.Selection{}
+	}
+
+	start, end := selectionMark, e.Cursor
+	if start > end {
+		start, end = end, start
+	}
+	if n := len(e.GetText()); end > n {
+		end = n
+	}
+	if start >= end {
+		return commons.Selection{}
+	}
+	return commons.Selection{Start: start + 1, End: end}
+}
+
+// sendSelection sends the local selection and cursor to the other clients, if they have
+// changed since they were last sent.
+func sendSelection(conn *websocket.Conn) {
+	sel := localSelection()
+	if sel == sentSelection || !e.IsConnected {
+		return
+	}
+	sentSelection = sel
+	if err := writeMessage(conn, commons.Message{Type: commons.SelectionMessage, Username: username, Selection: &sel}); err != nil {
+		e.IsConnected = false
+		e.StatusChan <- "lost connection!"
+	}
+}
+
+// setRemoteSelection anchors the selection and cursor of another user, received from the
+// server, to the local document. Empty selections without a cursor remove the user's
+// selection.
+func setRemoteSelection(id uuid.UUID, name string, sel commons.Selection) error {
+	delete(remoteSelections, id)
+	if sel.Start == 0 && sel.Cursor == 0 {
+		return nil
+	}
+
+	s := remoteSelection{name: name}
+	if sel.Start > 0 {
+		anchor, err := doc.NewAnchor(sel.Start, sel.End)
+		if err != nil {
+			return err
+		}
+		s.anchor, s.selected = anchor, true
+	}
+	if sel.Cursor > 1 {
+		anchor, err := doc.NewAnchor(sel.Cursor-1, sel.Cursor-1)
+		if err != nil {
+			return err
+		}
+		s.cursor = anchor
+	}
+	s.hasCursor = sel.Cursor > 0
+	remoteSelections[id] = s
+	return nil
+}
+
+// cursorIndex returns the index of the rune after the cursor of another user, or false if
+// the character before it was deleted.
+func (s remoteSelection) cursorIndex() (int, bool) {
+	if !s.hasCursor {
+		return 0, false
+	}
+	if s.cursor == (crdt.Anchor{}) {
+		return 0, true
+	}
+	_, end, ok := doc.Range(s.cursor)
+	return end, ok
+}
+
+// clearRemoteSelections removes the other users' selections, whose anchors aren't valid
+// anymore once the local document is replaced.
+func clearRemoteSelections() {
+	remoteSelections = make(map[uuid.UUID]remoteSelection)
+}
+
+// refreshSelections shows the local selection and the other users' selections and cursors,
+// in the users' colors.
+func refreshSelections() {
+	e.StatusMu.Lock()
+	users := e.Users
+	e.StatusMu.Unlock()
+
+	// Sort the selections, so that overlapping ones are always drawn in the same order.
+	ids := make([]uuid.UUID, 0, len(remoteSelections))
+	for id := range remoteSelections {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	var selections []editor.Selection
+	var cursors []editor.Cursor
+	for _, id := range ids {
+		s := remoteSelections[id]
+		if start, end, ok := doc.Range(s.anchor); s.selected && ok {
+			selections = append(selections, editor.Selection{Range: editor.Range{Start: start - 1, End: end}, User: findUser(users, s.name)})
+		}
+		if index, ok := s.cursorIndex(); ok {
+			cursors = append(cursors, editor.Cursor{Index: index, User: findUser(users, s.name)})
+		}
+	}
+
+	// The local selection is drawn over the others.
+	if sel := selectedRange(); sel.Start > 0 {
+		selections = append(selections, editor.Selection{Range: editor.Range{Start: sel.Start - 1, End: sel.End}, User: findUser(users, username)})
+	}
+	if blockMode {
+		for _, r := range blockRanges(e.GetText(), currentBlock()) {
+			selections = append(selections, editor.Selection{Range: r, User: findUser(users, username)})
+		}
+	}
+
+	e.SetSelections(selections)
+	e.SetCursors(cursors)
+}
+
+// findUser returns the user with the given name, or a user with the first color if the
+// name isn't in the list of users.
+func findUser(users []editor.User, name string) editor.User {
+	for _, u := range users {
+		if u.Name == name {
+			return u
+		}
+	}
+	return editor.User{Name: name}
+}
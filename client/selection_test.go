@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/google/uuid"
+)
+
+// TestSelections checks that the local selection and cursor are sent as positions, and that
+// the other users' selections and cursors follow their characters as the document is edited.
+func TestSelections(t *testing.T) {
+	defer func() { selectionMark = -1; clearRemoteSelections() }()
+
+	var err error
+	doc, err = crdt.FromText("hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = editor.NewEditor(editor.EditorConfig{})
+	e.SetText(crdt.Content(doc))
+
+	// The selection is between the mark and the cursor, whichever comes first.
+	e.SetX(5)
+	selectionMark = 0
+	if got, expected := localSelection(), (commons.Selection{Start: 1, End: 5, Cursor: 6}); got != expected {
+		t.Errorf("got selection %+v, expected %+v", got, expected)
+	}
+	selectionMark = 8
+	if got, expected := selectedRange(), (commons.Selection{Start: 6, End: 8}); got != expected {
+		t.Errorf("got selection %+v, expected %+v", got, expected)
+	}
+	selectionMark = 5
+	if got := selectedRange(); got != (commons.Selection{}) {
+		t.Errorf("got selection %+v, expected none", got)
+	}
+
+	// Another user selects "world", with the cursor after it, and text is inserted before it.
+	id := uuid.New()
+	if err := setRemoteSelection(id, "bob", commons.Selection{Start: 7, End: 11, Cursor: 12}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doc.Insert(1, ">"); err != nil {
+		t.Fatal(err)
+	}
+	if start, end, ok := doc.Range(remoteSelections[id].anchor); !ok || start != 8 || end != 12 {
+		t.Errorf("got range %d-%d (%v), expected 8-12", start, end, ok)
+	}
+	if index, ok := remoteSelections[id].cursorIndex(); !ok || index != 12 {
+		t.Errorf("got cursor %d (%v), expected 12", index, ok)
+	}
+
+	// A user at the start of the document only sends the cursor.
+	if err := setRemoteSelection(id, "bob", commons.Selection{Cursor: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if s := remoteSelections[id]; s.selected {
+		t.Error("got a selection, expected only the cursor")
+	} else if index, ok := s.cursorIndex(); !ok || index != 0 {
+		t.Errorf("got cursor %d (%v), expected 0", index, ok)
+	}
+
+	// Clearing the selection and cursor removes them.
+	if err := setRemoteSelection(id, "bob", commons.Selection{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := remoteSelections[id]; ok {
+		t.Error("got a cleared selection")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// A siteIdentity is a site ID given to the client by a server, with the token with which
+// the client can keep it when it reconnects, and the clock of its last character. It also
+// holds the name the client was given, with the token with which it can keep the name.
+type siteIdentity struct {
+	SiteID    string `json:"siteID"`
+	Token     string `json:"token"`
+	Clock     int    `json:"clock"`
+	Name      string `json:"name,omitempty"`
+	NameToken string `json:"nameToken,omitempty"`
+}
+
+var (
+	// prevSite is the site ID the client had the last time it joined the room, if it exited
+	// cleanly then.
+	prevSite *siteIdentity
+
+	// siteToken is the token of the client's site ID, given by the server.
+	siteToken string
+
+	// nameToken is the token of the client's name, given by the server when it joined.
+	nameToken string
+)
+
+// sitesPath returns the path of the file in which the client keeps its site IDs, keyed by
+// server and room.
+func sitesPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".pairpad", "sites.json"), nil
+}
+
+// siteKey returns the key of the site ID used to join a room. Servers reached through SSH
+// tunnels are told apart by the tunnel's machine, as their addresses are relative to it.
+func siteKey(flags Flags) string {
+	if flags.SSH != "" {
+		return flags.SSH + ":" + flags.Server + "/" + flags.Room
+	}
+	return flags.Server + "/" + flags.Room
+}
+
+// readSites reads the site IDs kept by the client.
+func readSites() (map[string]siteIdentity, error) {
+	path, err := sitesPath()
+	if err != nil {
+		return nil, err
+	}
+	sites := make(map[string]siteIdentity)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return sites, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sites, json.Unmarshal(data, &sites)
+}
+
+// writeSites writes the site IDs kept by the client.
+func writeSites(sites map[string]siteIdentity) error {
+	path, err := sitesPath()
+	if err != nil {
+		return err
+	}
+	if _, err := ensureDirExists(filepath.Dir(path)); err != nil {
+		return err
+	}
+	data, err := json.Marshal(sites)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// takeSite returns the site ID the client had in the room, and forgets it until the client
+// exits: a client which doesn't exit cleanly doesn't know the clock of its last character,
+// so it can't reuse its site ID without reusing character IDs.
+func takeSite(key string) (*siteIdentity, error) {
+	sites, err := readSites()
+	if err != nil {
+		return nil, err
+	}
+	site, ok := sites[key]
+	if !ok {
+		return nil, nil
+	}
+	delete(sites, key)
+	return &site, writeSites(sites)
+}
+
+// prevNameToken returns the token of the name the client had in the room, if it asks for
+// the same name again.
+func prevNameToken(name string) string {
+	if prevSite == nil || !strings.EqualFold(prevSite.Name, name) {
+		return ""
+	}
+	return prevSite.NameToken
+}
+
+// keepSite remembers the client's site ID in the room, with the clock of its last
+// character, so the client can keep it the next time it joins the room. If the server
+// didn't give the client a site ID, the previous one is kept.
+func keepSite(key string) error {
+	site := prevSite
+	if siteToken != "" {
+		site = &siteIdentity{SiteID: strconv.Itoa(crdt.SiteID), Token: siteToken, Clock: crdt.LocalClock, Name: username, NameToken: nameToken}
+	}
+	if site == nil {
+		return nil
+	}
+
+	sites, err := readSites()
+	if err != nil {
+		return err
+	}
+	sites[key] = *site
+	return writeSites(sites)
+}
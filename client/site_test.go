@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// TestSites checks that site IDs are kept when the client exits, and forgotten while it
+// runs.
+func TestSites(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	defer func(siteID, clock int) { crdt.SiteID, crdt.LocalClock = siteID, clock }(crdt.SiteID, crdt.LocalClock)
+	defer func(name string) { prevSite, siteToken, nameToken, username = nil, "", "", name }(username)
+
+	const key = "localhost:8080/team-a"
+	if site, err := takeSite(key); err != nil || site != nil {
+		t.Fatalf("got site %+v, error %v, expected none", site, err)
+	}
+
+	crdt.SiteID, crdt.LocalClock, siteToken = 3, 42, "token"
+	username, nameToken = "alice", "name token"
+	if err := keepSite(key); err != nil {
+		t.Fatal(err)
+	}
+	site, err := takeSite(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (siteIdentity{SiteID: "3", Token: "token", Clock: 42, Name: "alice", NameToken: "name token"}); site == nil || *site != expected {
+		t.Fatalf("got site %+v, expected %+v", site, expected)
+	}
+
+	// The name's token is only presented when joining with the same name.
+	prevSite = site
+	for name, expected := range map[string]string{"Alice": "name token", "bob": ""} {
+		if got := prevNameToken(name); got != expected {
+			t.Errorf("got token %q for %s, expected %q", got, name, expected)
+		}
+	}
+
+	// The site ID is forgotten until the client exits.
+	if site, err := takeSite(key); err != nil || site != nil {
+		t.Errorf("got site %+v, error %v, expected none", site, err)
+	}
+
+	// A client which never got a site ID keeps the previous one.
+	prevSite, siteToken = site, ""
+	if err := keepSite(key); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := takeSite(key); got == nil || *got != *site {
+		t.Errorf("got site %+v, expected %+v", got, site)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/burntcarrot/pairpad/client/plugin"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gorilla/websocket"
+)
+
+// snippetCursor marks where the cursor is placed in a snippet's expansion. Without it, the
+// cursor is placed after the expansion.
+const snippetCursor = "$0"
+
+// snippets maps the triggers of the user's snippets to their expansions.
+var snippets map[string]string
+
+// validateSnippets checks that the snippets' triggers are words, and that their expansions
+// place the cursor once at most.
+func validateSnippets(snippets map[string]string) error {
+	for trigger, expansion := range snippets {
+		if trigger == "" || strings.IndexFunc(trigger, unicode.IsSpace) >= 0 {
+			return fmt.Errorf("snippet trigger %q must be a word, without spaces", trigger)
+		}
+		if strings.Count(expansion, snippetCursor) > 1 {
+			return fmt.Errorf("snippet %q has more than one %s", trigger, snippetCursor)
+		}
+	}
+	return nil
+}
+
+// snippetTrigger returns the trigger of the snippet ending before the rune of text at
+// cursor, and its expansion, if there's one.
+func snippetTrigger(text []rune, cursor int, snippets map[string]string) (string, string, bool) {
+	start := cursor
+	for start > 0 && !unicode.IsSpace(text[start-1]) {
+		start--
+	}
+	word := string(text[start:cursor])
+
+	// Triggers may follow other characters, such as brackets, so the longest one ending
+	// the word is expanded.
+	for i := range word {
+		if expansion, ok := snippets[word[i:]]; ok {
+			return word[i:], expansion, true
+		}
+	}
+	return "", "", false
+}
+
+// expandSnippet replaces the snippet trigger before the cursor with its expansion, and
+// reports whether there was one. The trigger's characters are deleted, and the expansion
+// is inserted with a single operation.
+func expandSnippet(conn *websocket.Conn) bool {
+	trigger, expansion, ok := snippetTrigger(e.GetText(), e.Cursor, snippets)
+	if !ok {
+		return false
+	}
+	if reason := editDenied(OperationDelete); reason != "" {
+		e.StatusChan <- reason
+		return true
+	}
+
+	value, before := expansion, expansion
+	if i := strings.Index(expansion, snippetCursor); i >= 0 {
+		value = expansion[:i] + expansion[i+len(snippetCursor):]
+		before = expansion[:i]
+	}
+
+	for n := utf8.RuneCountInString(trigger); n > 0; n-- {
+		if !performOperation(OperationDelete, 0, conn) {
+			return true
+		}
+	}
+	start := e.Cursor
+	if value != "" && insertText(value, conn) {
+		e.SetX(start + utf8.RuneCountInString(before))
+	}
+	return true
+}
+
+// insertText inserts value at the cursor, and sends it to the other clients as a single
+// insert, unless the user can't edit there. It reports whether the whole value was
+// inserted.
+func insertText(value string, conn *websocket.Conn) bool {
+	e.FollowCursor()
+	if reason := editDenied(OperationInsert); reason != "" {
+		e.StatusChan <- reason
+		return false
+	}
+
+	position := e.Cursor + 1
+	op, err := doc.GenerateOperation(commons.Operation{Type: "insert", Position: position, Value: value})
+	if err != nil {
+		// The runes inserted before the error are sent to the other clients too.
+		logger.Errorf("CRDT error: %v\n", err)
+	}
+	value = op.Value
+	e.SetText(crdt.Content(doc))
+	e.SetX(e.Cursor + utf8.RuneCountInString(value))
+	e.SetDirty(true)
+	if value == "" {
+		return false
+	}
+
+	if e.IsConnected {
+		if err := sendOperation(op, conn); err != nil {
+			e.IsConnected = false
+			e.StatusChan <- "lost connection!"
+		}
+	} else if flags.Debug {
+		tracker.next()
+	}
+	plugin.LocalInsert(clientSession{conn}, position, value)
+	return err == nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// TestSnippetTrigger checks that the longest trigger ending the word before the cursor is
+// found.
+func TestSnippetTrigger(t *testing.T) {
+	snippets := map[string]string{"fn": "func $0() {\n}", "err": "err != nil", "iferr": "if err != nil {\n}"}
+	tests := []struct {
+		text     string
+		cursor   int
+		expected string
+		ok       bool
+	}{
+		{"fn", 2, "fn", true},
+		{"x fn", 4, "fn", true},
+		{"iferr", 5, "iferr", true},
+		{"(err", 4, "err", true},
+		{"fn x", 4, "", false},
+		{"fnord", 2, "fn", true},
+		{"fnord", 5, "", false},
+		{"", 0, "", false},
+	}
+
+	for _, tc := range tests {
+		got, expansion, ok := snippetTrigger([]rune(tc.text), tc.cursor, snippets)
+		if got != tc.expected || ok != tc.ok || (ok && expansion != snippets[got]) {
+			t.Errorf("%q at %d: got %q, %v, expected %q, %v", tc.text, tc.cursor, got, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+// TestValidateSnippets checks that triggers with spaces and expansions placing the cursor
+// twice are rejected.
+func TestValidateSnippets(t *testing.T) {
+	tests := []struct {
+		snippets map[string]string
+		wantErr  bool
+	}{
+		{map[string]string{"fn": "func $0() {\n}", "todo": "// TODO: "}, false},
+		{map[string]string{"": "x"}, true},
+		{map[string]string{"a b": "x"}, true},
+		{map[string]string{"pair": "($0, $0)"}, true},
+	}
+
+	for _, tc := range tests {
+		if err := validateSnippets(tc.snippets); (err != nil) != tc.wantErr {
+			t.Errorf("%q: got %v, expected an error: %v", tc.snippets, err, tc.wantErr)
+		}
+	}
+}
+
+// TestExpandSnippet checks that the trigger before the cursor is replaced with its
+// expansion, and that the cursor is placed at the expansion's $0.
+func TestExpandSnippet(t *testing.T) {
+	conn := sink(t)
+	doc, _ = crdt.FromText("x := fn")
+	e = editor.NewEditor(editor.EditorConfig{})
+	e.SetText(crdt.Content(doc))
+	e.SetX(7)
+	readOnly = false
+	setPrompts(nil)
+	snippets = map[string]string{"fn": "func($0) {}"}
+	defer func() { snippets = nil }()
+
+	if !expandSnippet(conn) {
+		t.Fatal("got no snippet expanded, expected fn to be")
+	}
+	if got, expected := crdt.Content(doc), "x := func() {}"; got != expected || string(e.GetText()) != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	if got, expected := e.Cursor, 10; got != expected {
+		t.Errorf("got cursor %d, expected %d", got, expected)
+	}
+
+	if expandSnippet(conn) {
+		t.Error("got a snippet expanded after \"func(\", expected none")
+	}
+}
@@ -0,0 +1,318 @@
+// Package spell checks the spelling of words, against a bundled English dictionary or a
+// hunspell dictionary.
+package spell
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The bundled dictionary is the en_US hunspell dictionary of SCOWL (Spell Checker Oriented
+// Word Lists, http://wordlist.aspell.net), version 2020.12.07, which holds the words of SCOWL
+// size 60. It's distributed under SCOWL's license, found in LICENSE.en_US; see README.md.
+var (
+	//go:embed en_US.dic
+	bundledDic string
+
+	//go:embed en_US.aff
+	bundledAff string
+)
+
+// A Dictionary holds the correctly spelled words.
+type Dictionary struct {
+	words map[string]bool
+}
+
+var (
+	defaultOnce sync.Once
+	defaultDict *Dictionary
+)
+
+// Default returns the bundled dictionary.
+func Default() *Dictionary {
+	defaultOnce.Do(func() {
+		aff := newAffixes()
+		if err := aff.read(strings.NewReader(bundledAff)); err != nil {
+			panic(fmt.Sprintf("spell: invalid bundled affix file: %v", err))
+		}
+		defaultDict = &Dictionary{words: make(map[string]bool)}
+		if err := defaultDict.readDic(strings.NewReader(bundledDic), aff); err != nil {
+			panic(fmt.Sprintf("spell: invalid bundled dictionary: %v", err))
+		}
+	})
+	return defaultDict
+}
+
+// Load reads a dictionary from a file. Hunspell dictionaries (".dic" files) are supported,
+// with the affix rules of the ".aff" file next to them. Other files are read as word lists,
+// one word per line.
+func Load(path string) (*Dictionary, error) {
+	d := &Dictionary{words: make(map[string]bool)}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if !strings.HasSuffix(path, ".dic") {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			d.Add(scanner.Text())
+		}
+		return d, scanner.Err()
+	}
+
+	aff := newAffixes()
+	if af, err := os.Open(strings.TrimSuffix(path, ".dic") + ".aff"); err == nil {
+		err = aff.read(af)
+		af.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid affix file: %w", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if err := d.readDic(f, aff); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// readDic adds the words of a hunspell dictionary file to d, along with the words formed by
+// applying aff's rules to them.
+func (d *Dictionary) readDic(r io.Reader, aff *affixes) error {
+	scanner := bufio.NewScanner(r)
+	for first := true; scanner.Scan(); first = false {
+		line := strings.TrimSpace(scanner.Text())
+		// The first line holds the number of words.
+		if _, err := strconv.Atoi(line); first && err == nil {
+			continue
+		}
+		// Words may be followed by morphological fields, separated by whitespace.
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			line = line[:i]
+		}
+		word, flags, _ := strings.Cut(line, "/")
+		if word == "" {
+			continue
+		}
+		parsed := aff.parseFlags(flags)
+		if aff.onlyInCompound != "" && contains(parsed, aff.onlyInCompound) {
+			continue
+		}
+		d.Add(aff.expand(word, parsed)...)
+	}
+	return scanner.Err()
+}
+
+// contains reports whether flags holds flag.
+func contains(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds words to the dictionary.
+func (d *Dictionary) Add(words ...string) {
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			d.words[normalize(w)] = true
+		}
+	}
+}
+
+// Correct reports whether word is spelled correctly. Case is ignored, and so is the "'s" of
+// possessives.
+func (d *Dictionary) Correct(word string) bool {
+	word = normalize(word)
+	if d.words[word] {
+		return true
+	}
+	if stem := strings.TrimSuffix(word, "'s"); stem != word {
+		return d.words[stem]
+	}
+	return false
+}
+
+// normalize returns word in lower case, with typographic apostrophes replaced by ASCII ones.
+func normalize(word string) string {
+	return strings.ReplaceAll(strings.ToLower(word), "’", "'")
+}
+
+// An affix is a prefix or suffix rule of a hunspell affix file: strip is removed from the
+// word, and add is added in its place, if the word matches the condition.
+type affix struct {
+	strip, add string
+	condition  *regexp.Regexp
+}
+
+// An affixClass holds the rules of an affix flag.
+type affixClass struct {
+	prefix bool
+
+	// cross is set if the rules can be combined with the rules of the other kind of affix.
+	cross bool
+
+	rules []affix
+}
+
+// affixes holds the rules of a hunspell affix file.
+type affixes struct {
+	// flagType is the format of the flags: "" for single characters, "long" for pairs of
+	// characters, and "num" for comma-separated numbers.
+	flagType string
+
+	// onlyInCompound is the flag of the words which are only valid in compound words, such
+	// as "1th" in "21th". These words aren't added to dictionaries.
+	onlyInCompound string
+
+	classes map[string]*affixClass
+}
+
+func newAffixes() *affixes {
+	return &affixes{classes: make(map[string]*affixClass)}
+}
+
+// read reads the affix rules from an affix file. Options other than FLAG, ONLYINCOMPOUND,
+// PFX and SFX are ignored.
+func (a *affixes) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "FLAG":
+			a.flagType = fields[1]
+			if a.flagType == "UTF-8" {
+				a.flagType = ""
+			}
+
+		case "ONLYINCOMPOUND":
+			a.onlyInCompound = fields[1]
+
+		case "PFX", "SFX":
+			if len(fields) < 4 {
+				continue
+			}
+			flag := fields[1]
+			class, ok := a.classes[flag]
+			if !ok {
+				// The header of the class: its flag, cross product and number of rules.
+				a.classes[flag] = &affixClass{prefix: fields[0] == "PFX", cross: fields[2] == "Y"}
+				continue
+			}
+
+			rule := affix{strip: fields[2], add: fields[3]}
+			if rule.strip == "0" {
+				rule.strip = ""
+			}
+			// Flags continuing the affixes aren't supported.
+			rule.add, _, _ = strings.Cut(rule.add, "/")
+			if rule.add == "0" {
+				rule.add = ""
+			}
+			condition := "."
+			if len(fields) > 4 {
+				condition = fields[4]
+			}
+			re := "^" + condition
+			if !class.prefix {
+				re = condition + "$"
+			}
+			var err error
+			if rule.condition, err = regexp.Compile(re); err != nil {
+				return fmt.Errorf("invalid condition %q: %w", condition, err)
+			}
+			class.rules = append(class.rules, rule)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseFlags splits the flags of a word.
+func (a *affixes) parseFlags(flags string) []string {
+	var split []string
+	switch a.flagType {
+	case "long":
+		runes := []rune(flags)
+		for i := 0; i+1 < len(runes); i += 2 {
+			split = append(split, string(runes[i:i+2]))
+		}
+	case "num":
+		for _, f := range strings.Split(flags, ",") {
+			if f != "" {
+				split = append(split, f)
+			}
+		}
+	default:
+		for _, r := range flags {
+			split = append(split, string(r))
+		}
+	}
+	return split
+}
+
+// expand returns word and the words formed by applying the affix rules of its flags.
+func (a *affixes) expand(word string, flags []string) []string {
+	forms := []string{word}
+	var suffixed []string
+	for _, flag := range flags {
+		class, ok := a.classes[flag]
+		if !ok || class.prefix {
+			continue
+		}
+		for _, form := range class.apply(word) {
+			forms = append(forms, form)
+			if class.cross {
+				suffixed = append(suffixed, form)
+			}
+		}
+	}
+
+	for _, flag := range flags {
+		class, ok := a.classes[flag]
+		if !ok || !class.prefix {
+			continue
+		}
+		forms = append(forms, class.apply(word)...)
+		if class.cross {
+			for _, form := range suffixed {
+				forms = append(forms, class.apply(form)...)
+			}
+		}
+	}
+	return forms
+}
+
+// apply returns the words formed by applying the class's rules to word.
+func (c *affixClass) apply(word string) []string {
+	var forms []string
+	for _, rule := range c.rules {
+		if !rule.condition.MatchString(word) {
+			continue
+		}
+		if c.prefix && strings.HasPrefix(word, rule.strip) {
+			forms = append(forms, rule.add+word[len(rule.strip):])
+		} else if !c.prefix && strings.HasSuffix(word, rule.strip) {
+			forms = append(forms, word[:len(word)-len(rule.strip)]+rule.add)
+		}
+	}
+	return forms
+}
@@ -0,0 +1,88 @@
+package spell
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefault(t *testing.T) {
+	tests := []struct {
+		word    string
+		correct bool
+	}{
+		{word: "the", correct: true},
+		{word: "Documentation", correct: true},
+		{word: "kitchen", correct: true},
+		{word: "doesn't", correct: true},
+		{word: "doesn’t", correct: true},
+		{word: "editor's", correct: true},
+		{word: "recieve", correct: false},
+		{word: "seperate", correct: false},
+		{word: "teh", correct: false},
+		{word: "recipes", correct: true},
+		{word: "unhappiness", correct: true},
+		{word: "aaaaaaaaaaaaaaa", correct: false},
+		{word: "sprintf", correct: false},
+		{word: "errorf", correct: false},
+		{word: "allowmultiplevcs", correct: false},
+		{word: "1th", correct: false},
+	}
+
+	d := Default()
+	for _, tt := range tests {
+		if got := d.Correct(tt.word); got != tt.correct {
+			t.Errorf("%s: got %v, expected %v", tt.word, got, tt.correct)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	aff := `SET UTF-8
+
+PFX U Y 1
+PFX U 0 un .
+
+SFX S Y 2
+SFX S y ies [^aeiou]y
+SFX S 0 s [^y]
+
+SFX D N 1
+SFX D 0 ed .
+`
+	dic := `3
+happy/U
+story/S
+do/US
+`
+	if err := os.WriteFile(filepath.Join(dir, "en.aff"), []byte(aff), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "en.dic"), []byte(dic), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Load(filepath.Join(dir, "en.dic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		word    string
+		correct bool
+	}{
+		{word: "happy", correct: true},
+		{word: "unhappy", correct: true},
+		{word: "stories", correct: true},
+		{word: "storys", correct: false},
+		{word: "undos", correct: true},
+		{word: "happies", correct: false},
+		{word: "3", correct: false},
+	}
+	for _, tt := range tests {
+		if got := d.Correct(tt.word); got != tt.correct {
+			t.Errorf("%s: got %v, expected %v", tt.word, got, tt.correct)
+		}
+	}
+}
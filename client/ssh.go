@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sshCommand is the SSH client run to open tunnels.
+var sshCommand = "ssh"
+
+// tunnelTimeout is how long the client waits for an SSH tunnel to open, which includes the
+// time taken to type a password or passphrase.
+const tunnelTimeout = 2 * time.Minute
+
+// tunnel is the SSH tunnel the connection to the server goes through, if -ssh is set.
+var tunnel *sshTunnel
+
+// An sshTunnel forwards a local port to the server, through an SSH connection to the
+// machine it runs on (or another one which can reach it).
+type sshTunnel struct {
+	cmd *exec.Cmd
+
+	// addr is the local address forwarded to the server.
+	addr string
+
+	// exited receives the error ssh exited with.
+	exited chan error
+
+	out *tunnelOutput
+}
+
+// tunnelTarget returns the address of the server, with the default port of its scheme if
+// it has none.
+func tunnelTarget(server string, secure bool) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	if secure {
+		return net.JoinHostPort(server, "443")
+	}
+	return net.JoinHostPort(server, "80")
+}
+
+// tunnelArgs returns the arguments of the ssh command forwarding the local port to target,
+// as seen from dest, without running a remote command.
+func tunnelArgs(dest, target string, port int) []string {
+	return []string{
+		"-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-L", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) + ":" + target,
+		dest,
+	}
+}
+
+// openTunnel runs ssh to forward a free local port to the server through dest, such as
+// user@host, and waits until the port is forwarded. The server's address is resolved by
+// dest, so "localhost:8080" is a server running on dest itself. ssh asks for passwords, and
+// to check host keys, on the terminal, before the editor starts.
+func openTunnel(dest, server string, secure bool) (*sshTunnel, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	t := &sshTunnel{
+		cmd:    exec.Command(sshCommand, tunnelArgs(dest, tunnelTarget(server, secure), port)...),
+		addr:   net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		exited: make(chan error, 1),
+		out:    &tunnelOutput{w: os.Stderr},
+	}
+	t.cmd.Stdout, t.cmd.Stderr = t.out, t.out
+	if err := t.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() { t.exited <- t.cmd.Wait() }()
+
+	if err := t.wait(tunnelTimeout); err != nil {
+		t.close()
+		return nil, err
+	}
+
+	// ssh's warnings would be drawn over the editor.
+	t.out.redirect(logger.Writer())
+	return t, nil
+}
+
+// wait waits until the tunnel's local port accepts connections.
+func (t *sshTunnel) wait(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-t.exited:
+			t.exited <- err
+			if err == nil {
+				err = errors.New("ssh exited")
+			}
+			return fmt.Errorf("ssh failed to open the tunnel: %w", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if conn, err := net.Dial("tcp", t.addr); err == nil {
+			conn.Close()
+			return nil
+		}
+	}
+	return fmt.Errorf("ssh didn't open the tunnel within %s", timeout)
+}
+
+// close stops ssh, closing the tunnel.
+func (t *sshTunnel) close() {
+	select {
+	case <-t.exited:
+		return
+	default:
+	}
+	_ = t.cmd.Process.Kill()
+	<-t.exited
+}
+
+// dial connects to the server through the tunnel, whatever the address asked for, which
+// is the server's address as seen from the other end of the tunnel.
+func (t *sshTunnel) dial(network, _ string) (net.Conn, error) {
+	return net.Dial(network, t.addr)
+}
+
+// A tunnelOutput is where ssh writes its output, which can be redirected while it runs.
+type tunnelOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (o *tunnelOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.w.Write(p)
+}
+
+// redirect sends the output written from now on to w.
+func (o *tunnelOutput) redirect(w io.Writer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.w = w
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
+)
+
+// TestTunnelArgs checks that the local port is forwarded to the server, with the default
+// port of its scheme if it has none.
+func TestTunnelArgs(t *testing.T) {
+	tests := []struct {
+		server   string
+		secure   bool
+		expected string
+	}{
+		{"localhost:8080", false, "localhost:8080"},
+		{"pair.internal", false, "pair.internal:80"},
+		{"pair.internal", true, "pair.internal:443"},
+	}
+	for _, tc := range tests {
+		args := tunnelArgs("alice@devbox", tunnelTarget(tc.server, tc.secure), 4000)
+		expected := []string{"-N", "-o", "ExitOnForwardFailure=yes", "-L", "127.0.0.1:4000:" + tc.expected, "alice@devbox"}
+		if diff := cmp.Diff(expected, args); diff != "" {
+			t.Errorf("%s: got unexpected args (-expected +got):\n%s", tc.server, diff)
+		}
+	}
+}
+
+// TestTunnelDial checks that the connection goes through the tunnel, asking for the server
+// by its address at the other end of the tunnel.
+func TestTunnelDial(t *testing.T) {
+	defer func() { tunnel, greeting = nil, nil }()
+
+	var host string
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteJSON(commons.Message{Type: commons.SiteIDMessage, Text: "1"})
+	}))
+	defer ts.Close()
+
+	tunnel = &sshTunnel{addr: strings.TrimPrefix(ts.URL, "http://")}
+	conn, err := connect(Flags{Server: "localhost:8080", SSH: "alice@devbox"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if host != "localhost:8080" {
+		t.Errorf("got host %q, expected localhost:8080", host)
+	}
+}
+
+// TestOpenTunnelFailure checks that ssh exiting before the port is forwarded is reported.
+func TestOpenTunnelFailure(t *testing.T) {
+	defer func(cmd string) { sshCommand = cmd }(sshCommand)
+	sshCommand = filepath.Join(t.TempDir(), "ssh")
+	if err := os.WriteFile(sshCommand, []byte("#!/bin/sh\nexit 255\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := openTunnel("alice@devbox", "localhost:8080", false); err == nil || !strings.Contains(err.Error(), "exit status 255") {
+		t.Errorf("got error %v, expected ssh's exit status", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("got the failure reported late, expected it once ssh exited")
+	}
+}
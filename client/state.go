@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A fileSession is the session a file was last edited in.
+type fileSession struct {
+	Server string    `json:"server"`
+	Room   string    `json:"room"`
+	Secure bool      `json:"secure,omitempty"`
+	SSH    string    `json:"ssh,omitempty"`
+	Joined time.Time `json:"joined"`
+}
+
+// stateDir returns the directory in which the client keeps its state between runs, and
+// creates it if it doesn't exist.
+func stateDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".pairpad", "state")
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// readState decodes the JSON file with the given name in the state directory into v. A
+// missing file leaves v as it is.
+func readState(name string, v interface{}) error {
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeState writes v as JSON to the file with the given name in the state directory.
+func writeState(name string, v interface{}) error {
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), data, 0600)
+}
+
+// fileSessionsFile is the name of the state file holding the sessions files were last
+// edited in, keyed by their absolute paths.
+const fileSessionsFile = "files.json"
+
+// rememberFileSession remembers that the file is edited in the session joined with flags.
+func rememberFileSession(file string, flags Flags, now time.Time) error {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	sessions := make(map[string]fileSession)
+	if err := readState(fileSessionsFile, &sessions); err != nil {
+		return err
+	}
+	sessions[path] = fileSession{Server: flags.Server, Room: flags.Room, Secure: flags.Secure, SSH: flags.SSH, Joined: now}
+	return writeState(fileSessionsFile, sessions)
+}
+
+// lastFileSession returns the session the file was last edited in, if any.
+func lastFileSession(file string) (*fileSession, error) {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	var sessions map[string]fileSession
+	if err := readState(fileSessionsFile, &sessions); err != nil {
+		return nil, err
+	}
+	s, ok := sessions[path]
+	if !ok {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// describeRoom names a room for the user.
+func describeRoom(room string) string {
+	if room == "" {
+		return "the default room"
+	}
+	return "room " + room
+}
+
+// offerRejoin asks whether to join the session in which the file given with -file was
+// last edited, if it differs from the one flags join, and joins it unless the answer is
+// no.
+func offerRejoin(flags *Flags, in *bufio.Scanner, out io.Writer) error {
+	s, err := lastFileSession(flags.File)
+	if err != nil || s == nil {
+		return err
+	}
+	if s.Server == flags.Server && s.Room == flags.Room && s.Secure == flags.Secure && s.SSH == flags.SSH {
+		return nil
+	}
+
+	server := s.Server
+	if s.SSH != "" {
+		server += " through " + s.SSH
+	}
+	fmt.Fprintf(out, "%s was last edited in %s on %s, %s. Rejoin it? [Y/n] ", flags.File, describeRoom(s.Room), server, s.Joined.Format("Jan 2 15:04"))
+	in.Scan()
+	if answer := strings.ToLower(strings.TrimSpace(in.Text())); strings.HasPrefix(answer, "n") {
+		return nil
+	}
+	flags.Server, flags.Room, flags.Secure, flags.SSH = s.Server, s.Room, s.Secure, s.SSH
+	return nil
+}
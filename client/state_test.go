@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOfferRejoin checks that the session a file was last edited in is offered, and joined
+// unless the user declines.
+func TestOfferRejoin(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	file := filepath.Join(t.TempDir(), "notes.md")
+
+	joined := time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC)
+	if err := rememberFileSession(file, Flags{Server: "pair.example.com", Room: "team-a", Secure: true}, joined); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		answer   string
+		expected Flags
+	}{
+		{"\n", Flags{File: file, Server: "pair.example.com", Room: "team-a", Secure: true}},
+		{"yes\n", Flags{File: file, Server: "pair.example.com", Room: "team-a", Secure: true}},
+		{"n\n", Flags{File: file, Server: "localhost:8080"}},
+	}
+
+	for _, tc := range tests {
+		flags := Flags{File: file, Server: "localhost:8080"}
+		var out strings.Builder
+		if err := offerRejoin(&flags, bufio.NewScanner(strings.NewReader(tc.answer)), &out); err != nil {
+			t.Fatal(err)
+		}
+		if flags != tc.expected {
+			t.Errorf("answering %q: got %+v, expected %+v", tc.answer, flags, tc.expected)
+		}
+		if expected := "notes.md was last edited in room team-a on pair.example.com, Oct 1 09:30. Rejoin it? [Y/n] "; !strings.HasSuffix(out.String(), expected) {
+			t.Errorf("got question %q, expected it to end with %q", out.String(), expected)
+		}
+	}
+
+	// Nothing is asked for files edited in the same session, or never edited in one.
+	for _, name := range []string{file, filepath.Join(t.TempDir(), "new.md")} {
+		flags := Flags{File: name, Server: "pair.example.com", Room: "team-a", Secure: true}
+		if name != file {
+			flags.Server = "localhost:8080"
+		}
+		expected := flags
+		if err := offerRejoin(&flags, bufio.NewScanner(strings.NewReader("n\n")), io.Discard); err != nil || flags != expected {
+			t.Errorf("%s: got %+v, %v, expected %+v", name, flags, err, expected)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// swapInterval is how often the swap file is written while the document has unsaved
+// changes.
+const swapInterval = 15 * time.Second
+
+var (
+	// swapFile is the path of the file to which the document's CRDT state is written while
+	// it has unsaved changes, so they can be recovered if the client crashes. It's removed
+	// once the changes are saved, and when the client exits cleanly.
+	swapFile string
+
+	// recovered is set if the content of the swap file was recovered, in importText.
+	recovered bool
+)
+
+// swapPath returns the path of the swap file of file, next to it as in vim, or of the
+// session joined with the given site key, in the state directory, if there's no file.
+func swapPath(file, key string) (string, error) {
+	if file != "" {
+		return filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".swp"), nil
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strings.NewReplacer("/", "_", ":", "_").Replace(key)+".swp"), nil
+}
+
+// checkSwap asks whether to recover the document of the swap file at path, if there's one,
+// which is left by a client which crashed. It returns the document if it's recovered, and
+// removes the swap file otherwise.
+func checkSwap(path string, in *bufio.Scanner, out io.Writer) (*crdt.File, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := crdt.LoadDocument(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the swap file %s: %w", path, err)
+	}
+
+	fmt.Fprintf(out, "Found a swap file with unsaved changes, written %s: %s. Recover them? [Y/n] ", info.ModTime().Format("Jan 2 15:04"), path)
+	in.Scan()
+	if answer := strings.ToLower(strings.TrimSpace(in.Text())); strings.HasPrefix(answer, "n") {
+		return nil, os.Remove(path)
+	}
+	return &f, nil
+}
+
+// writeSwap writes the document to the swap file if it has unsaved changes, and removes
+// the swap file otherwise. The file is replaced at once, so a crash while it's written
+// leaves the previous one.
+func writeSwap() error {
+	if !e.IsDirty() {
+		return removeSwap()
+	}
+
+	data, err := crdt.EncodeFile(&doc)
+	if err != nil {
+		return err
+	}
+	tmp := swapFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, swapFile)
+}
+
+// removeSwap removes the swap file, if any.
+func removeSwap() error {
+	if swapFile == "" {
+		return nil
+	}
+	if err := os.Remove(swapFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// keepRecovered writes the content recovered from the swap file next to the file, when
+// the session's document, which has content, is kept instead. It returns the message
+// telling the user where the content went.
+func keepRecovered() string {
+	name := fileName
+	if name == "" {
+		name = "pairpad-content.txt"
+	}
+	name += ".recovered"
+	if err := os.WriteFile(name, []byte(encodeText(importText, fileNewline)), 0600); err != nil {
+		logger.Errorf("failed to write the recovered content, err: %v\n", err)
+		return fmt.Sprintf("Joined a session with existing content, and failed to write the recovered changes to %s", name)
+	}
+	return fmt.Sprintf("Joined a session with existing content, the recovered changes were written to %s", name)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// TestSwapPath checks that swap files are written next to files, or in the state directory
+// for sessions without one.
+func TestSwapPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	tests := []struct {
+		file, key, expected string
+	}{
+		{filepath.Join("notes", "todo.md"), "localhost:8080/", filepath.Join("notes", ".todo.md.swp")},
+		{"", "localhost:8080/team-a", filepath.Join(home, ".pairpad", "state", "localhost_8080_team-a.swp")},
+	}
+
+	for _, tc := range tests {
+		if got, err := swapPath(tc.file, tc.key); err != nil || got != tc.expected {
+			t.Errorf("%q, %q: got %q, %v, expected %q", tc.file, tc.key, got, err, tc.expected)
+		}
+	}
+}
+
+// TestSwap checks that the swap file holds the document while it has unsaved changes, and
+// that it's recovered, or removed if the user declines.
+func TestSwap(t *testing.T) {
+	defer func() { swapFile = "" }()
+	swapFile = filepath.Join(t.TempDir(), ".notes.md.swp")
+	doc, _ = crdt.FromText("unsaved")
+	e = editor.NewEditor(editor.EditorConfig{})
+
+	// Nothing is written without unsaved changes.
+	if err := writeSwap(); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := checkSwap(swapFile, bufio.NewScanner(strings.NewReader("")), io.Discard); err != nil || f != nil {
+		t.Fatalf("got %v, %v, expected no swap file", f, err)
+	}
+
+	e.SetDirty(true)
+	if err := writeSwap(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := checkSwap(swapFile, bufio.NewScanner(strings.NewReader("\n")), io.Discard)
+	if err != nil || f == nil {
+		t.Fatalf("got %v, %v, expected the swap file", f, err)
+	}
+	if got := crdt.Content(f.Document); got != "unsaved" {
+		t.Errorf("got %q, expected %q", got, "unsaved")
+	}
+
+	// Declining removes the swap file.
+	if f, err := checkSwap(swapFile, bufio.NewScanner(strings.NewReader("n\n")), io.Discard); err != nil || f != nil {
+		t.Fatalf("got %v, %v, expected nothing recovered", f, err)
+	}
+	if _, err := os.Stat(swapFile); !os.IsNotExist(err) {
+		t.Errorf("got %v, expected the swap file to be removed", err)
+	}
+
+	// Saving the changes removes it too.
+	if err := writeSwap(); err != nil {
+		t.Fatal(err)
+	}
+	e.SetDirty(false)
+	if err := writeSwap(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(swapFile); !os.IsNotExist(err) {
+		t.Errorf("got %v, expected the swap file to be removed", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/gorilla/websocket"
+)
+
+// stallTimeout is how long an operation may wait for its ack before the sync is shown as
+// stalled.
+const stallTimeout = 5 * time.Second
+
+// The states of the last local operation, shown in the info bar in debugging mode.
+const (
+	syncPending  = "pending"
+	syncSent     = "sent"
+	syncAcked    = "acked"
+	syncRejected = "rejected"
+)
+
+// syncTracker follows the last local operation, in debugging mode: it's pending until it's
+// written to the server, sent until the server acks it, and then acked. Operations are
+// numbered with their seq, so the server acks them.
+type syncTracker struct {
+	mu sync.Mutex
+
+	// seq is the seq of the last local operation.
+	seq int
+
+	state string
+
+	// since is the time at which the operation entered its state.
+	since time.Time
+}
+
+// tracker follows the client's operations.
+var tracker syncTracker
+
+// next numbers a new local operation, which is pending.
+func (t *syncTracker) next() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	t.set(syncPending)
+	return t.seq
+}
+
+// update sets the state of the operation numbered seq, if it's still the last one.
+func (t *syncTracker) update(seq int, state string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if seq == t.seq {
+		t.set(state)
+	}
+}
+
+// set sets the state of the last operation, and shows it. t.mu must be held.
+func (t *syncTracker) set(state string) {
+	t.state, t.since = state, time.Now()
+	e.SetSyncStatus(t.status(t.since))
+	e.SendDraw()
+}
+
+// status describes the state of the last operation, as of now. t.mu must be held.
+func (t *syncTracker) status(now time.Time) string {
+	if t.state == "" {
+		return ""
+	}
+	status := fmt.Sprintf("sync: op %d %s", t.seq, t.state)
+	if waited := now.Sub(t.since); t.state != syncAcked && waited >= stallTimeout {
+		status += fmt.Sprintf(" for %ds, stalled?", int(waited.Seconds()))
+	}
+	return status
+}
+
+// watch refreshes the state shown in the info bar every second, so that stalled operations
+// show up even if nothing else happens.
+func (t *syncTracker) watch() {
+	for range time.Tick(time.Second) {
+		t.mu.Lock()
+		if t.state != syncAcked && t.state != "" {
+			e.SetSyncStatus(t.status(time.Now()))
+			e.SendDraw()
+		}
+		t.mu.Unlock()
+	}
+}
+
+// sendOperation sends a local operation to the server. In debugging mode, the operation is
+// numbered and tracked until the server acks it.
+func sendOperation(op commons.Operation, conn *websocket.Conn) error {
+	msg := commons.Message{Type: commons.OperationMessage, Operation: op}
+	if flags.Debug {
+		msg.Seq = tracker.next()
+	}
+	if err := writeMessage(conn, msg); err != nil {
+		return err
+	}
+	atomic.AddInt64(&opsSent, 1)
+	if flags.Debug {
+		tracker.update(msg.Seq, syncSent)
+	}
+	return nil
+}
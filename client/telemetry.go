@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gdamore/tcell/v2"
+)
+
+// telemetryTimeout bounds the time spent sending the usage report on exit.
+const telemetryTimeout = 5 * time.Second
+
+// TelemetryConfig holds the settings of the usage reports, which are off unless enabled.
+type TelemetryConfig struct {
+	// Enabled sends a usage report to Endpoint when the editor exits. The report is
+	// anonymous: it holds how long the session lasted, the rough size of the document and
+	// how many times features were used, but no names, addresses or content.
+	Enabled bool `toml:"enabled"`
+
+	// Endpoint is the http:// or https:// URL the reports are posted to, as JSON.
+	Endpoint string `toml:"endpoint"`
+}
+
+// validate checks that the settings make sense.
+func (c TelemetryConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Endpoint == "" {
+		return errors.New("telemetry.endpoint must be set when telemetry is enabled")
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("telemetry.endpoint must be an http:// or https:// URL, not %q", c.Endpoint)
+	}
+	return nil
+}
+
+// featureKeys names the features counted in the usage report by the keys using them.
+var featureKeys = map[tcell.Key]string{
+	tcell.KeyCtrlS: "save",
+	tcell.KeyCtrlL: "load",
+	tcell.KeyCtrlK: "comment",
+	tcell.KeyCtrlG: "comments_panel",
+	tcell.KeyCtrlW: "git_commit",
+	tcell.KeyCtrlT: "ping",
+	tcell.KeyCtrlU: "document_stats",
+	tcell.KeyCtrlA: "participants",
+	tcell.KeyCtrlV: "split",
+	tcell.KeyCtrlX: "convert_newlines",
+	tcell.KeyF2:    "outline",
+	tcell.KeyF3:    "block_selection",
+	tcell.KeyF12:   "performance",
+}
+
+// A usageReport is the anonymous report sent when the editor exits, if telemetry is
+// enabled.
+type usageReport struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	OS              string `json:"os"`
+
+	// SessionMinutes is how long the editor was open, in whole minutes.
+	SessionMinutes int `json:"session_minutes"`
+
+	// DocumentSize is the range of the document's size on exit, such as "1KB-10KB".
+	DocumentSize string `json:"document_size"`
+
+	// Features counts the times each feature was used.
+	Features map[string]int `json:"features,omitempty"`
+}
+
+// A usage tracks the use of the editor during a session, for its usage report. A nil
+// *usage, as when telemetry is off, tracks nothing.
+type usage struct {
+	endpoint string
+	started  time.Time
+
+	mu       sync.Mutex
+	features map[string]int
+}
+
+// telemetry tracks the session's usage, if telemetry is enabled.
+var telemetry *usage
+
+// startUsage starts tracking the usage of the session, to report to endpoint.
+func startUsage(endpoint string) *usage {
+	return &usage{endpoint: endpoint, started: time.Now(), features: map[string]int{}}
+}
+
+// count counts a use of the named feature.
+func (u *usage) count(feature string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	u.features[feature]++
+	u.mu.Unlock()
+}
+
+// countKey counts a use of the feature of a key, if it has one.
+func (u *usage) countKey(key tcell.Key) {
+	if feature, ok := featureKeys[key]; ok {
+		u.count(feature)
+	}
+}
+
+// report returns the usage report of the session, as of now, for a document of the given
+// size in bytes, which is only reported by range.
+func (u *usage) report(size int) usageReport {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	features := make(map[string]int, len(u.features))
+	for name, n := range u.features {
+		features[name] = n
+	}
+	return usageReport{
+		ProtocolVersion: commons.ProtocolVersion,
+		OS:              runtime.GOOS,
+		SessionMinutes:  int(time.Since(u.started) / time.Minute),
+		DocumentSize:    sizeBucket(size),
+		Features:        features,
+	}
+}
+
+// send posts the usage report of the session, for a document of the given size in bytes,
+// to the endpoint.
+func (u *usage) send(size int) error {
+	if u == nil {
+		return nil
+	}
+	data, err := json.Marshal(u.report(size))
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: telemetryTimeout}
+	resp, err := client.Post(u.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("telemetry endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// sizeBucket returns the range a document's size, in bytes, falls in, so that the report
+// doesn't tell documents apart by their exact size.
+func sizeBucket(size int) string {
+	switch {
+	case size == 0:
+		return "empty"
+	case size < 1<<10:
+		return "<1KB"
+	case size < 10<<10:
+		return "1KB-10KB"
+	case size < 100<<10:
+		return "10KB-100KB"
+	case size < 1<<20:
+		return "100KB-1MB"
+	default:
+		return ">1MB"
+	}
+}
+
+// reportUsage sends the session's usage report, if telemetry is enabled. Failures are only
+// logged, so they don't get in the way of exiting.
+func reportUsage() {
+	if err := telemetry.send(len(crdt.Content(doc))); err != nil {
+		logger.Errorf("failed to send the usage report: %v\n", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// TestUsageReport checks that the usage report counts the features used, gives the
+// document's size as a range, and holds nothing else.
+func TestUsageReport(t *testing.T) {
+	var got map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	u := startUsage(ts.URL)
+	u.countKey(tcell.KeyCtrlS)
+	u.countKey(tcell.KeyCtrlS)
+	u.countKey(tcell.KeyLeft)
+	u.count("snippet")
+	if err := u.send(2000); err != nil {
+		t.Fatal(err)
+	}
+
+	if got["document_size"] != "1KB-10KB" || got["session_minutes"] != 0.0 {
+		t.Errorf("got report %v, expected a document of 1KB-10KB, and a session of 0 minutes", got)
+	}
+	features, _ := got["features"].(map[string]interface{})
+	if len(features) != 2 || features["save"] != 2.0 || features["snippet"] != 1.0 {
+		t.Errorf("got features %v, expected 2 saves and a snippet", features)
+	}
+	for key := range got {
+		switch key {
+		case "protocol_version", "os", "session_minutes", "document_size", "features":
+		default:
+			t.Errorf("got %q in the report, expected only the anonymous counters", key)
+		}
+	}
+
+	// Without telemetry, nothing is tracked or sent.
+	var off *usage
+	off.countKey(tcell.KeyCtrlS)
+	if err := off.send(1); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestSizeBucket checks the ranges documents' sizes are reported in.
+func TestSizeBucket(t *testing.T) {
+	for size, expected := range map[int]string{
+		0:       "empty",
+		1:       "<1KB",
+		1 << 10: "1KB-10KB",
+		50000:   "10KB-100KB",
+		1 << 20: ">1MB",
+	} {
+		if got := sizeBucket(size); got != expected {
+			t.Errorf("%d bytes: got %q, expected %q", size, got, expected)
+		}
+	}
+}
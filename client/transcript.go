@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/google/uuid"
+)
+
+// A transcript records the events of a session: users joining and leaving, comments,
+// questions, pings, access changes and notices, and the number of operations of each user.
+// It's written as Markdown, with the final document, when the client exits. Its methods do
+// nothing on a nil transcript, so they can be called whether or not it's kept.
+type transcript struct {
+	mu sync.Mutex
+
+	// start is the time at which the client joined the session.
+	start time.Time
+
+	// withOps adds the number of operations of each user to the transcript.
+	withOps bool
+
+	events []transcriptEvent
+
+	// ops holds the number of operations of each client, keyed by client ID. The local
+	// client's are keyed by uuid.Nil.
+	ops map[uuid.UUID]int
+
+	// names holds the names of the clients seen, keyed by client ID.
+	names map[uuid.UUID]string
+}
+
+// A transcriptEvent is a line of a transcript's timeline.
+type transcriptEvent struct {
+	at   time.Time
+	text string
+}
+
+// trans records the session's transcript, if -transcript is set.
+var trans *transcript
+
+// newTranscript returns a transcript of a session joined at start.
+func newTranscript(start time.Time, withOps bool) *transcript {
+	return &transcript{
+		start:   start,
+		withOps: withOps,
+		ops:     make(map[uuid.UUID]int),
+		names:   make(map[uuid.UUID]string),
+	}
+}
+
+// message records the events of a message received from (entryIn), or sent to (entryOut),
+// the server at now.
+func (t *transcript) message(kind string, msg commons.Message, now time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Messages sent by the client don't carry its ID.
+	id := msg.ID
+	if kind == entryOut {
+		id = uuid.Nil
+	}
+	if kind == entryIn && msg.Username != "" {
+		switch msg.Type {
+		case commons.JoinMessage, commons.LeaveMessage, commons.PingMessage, commons.SelectionMessage:
+			t.names[id] = msg.Username
+		}
+	}
+
+	event := ""
+	switch msg.Type {
+	case commons.OperationMessage:
+		t.ops[id]++
+	case commons.JoinMessage:
+		if kind == entryIn {
+			event = fmt.Sprintf("%s joined the session", msg.Username)
+		}
+	case commons.JoinAckMessage:
+		event = fmt.Sprintf("You joined the session as %s", msg.Username)
+	case commons.LeaveMessage:
+		switch msg.Text {
+		case commons.LeaveReasonKicked:
+			event = fmt.Sprintf("%s was kicked from the session", msg.Username)
+		case commons.LeaveReasonConnectionLost:
+			event = fmt.Sprintf("%s lost connection to the session", msg.Username)
+		default:
+			event = fmt.Sprintf("%s left the session", msg.Username)
+		}
+	case commons.AnnotationMessage:
+		if a := msg.Annotation; a != nil && !a.Deleted {
+			event = fmt.Sprintf("%s commented: %s", a.Author, a.Text)
+		}
+	case commons.PromptMessage:
+		if a := msg.Annotation; a != nil && !a.Deleted {
+			event = fmt.Sprintf("%s asked: %s", a.Author, a.Text)
+		}
+	case commons.PingMessage:
+		event = fmt.Sprintf("%s asked for attention", t.name(id))
+	case commons.AccessMessage:
+		who := "The candidates"
+		if msg.Username != "" {
+			who = msg.Username
+		}
+		if msg.Text == commons.AccessReadOnly {
+			event = fmt.Sprintf("%s lost edit access", who)
+		} else {
+			event = fmt.Sprintf("%s got edit access", who)
+		}
+	case commons.NoticeMessage:
+		event = fmt.Sprintf("Notice: %s", msg.Text)
+	}
+
+	if event != "" {
+		t.events = append(t.events, transcriptEvent{at: now, text: event})
+	}
+}
+
+// name returns the name of the client with id. t.mu must be held.
+func (t *transcript) name(id uuid.UUID) string {
+	if id == uuid.Nil {
+		return username
+	}
+	if name, ok := t.names[id]; ok {
+		return name
+	}
+	return "unknown user " + id.String()[:8]
+}
+
+// markdown returns the transcript of the session ended at end, with the final document.
+func (t *transcript) markdown(room, document string, end time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if room == "" {
+		room = "(default)"
+	}
+
+	var b strings.Builder
+	b.WriteString("# pairpad session transcript\n\n")
+	fmt.Fprintf(&b, "- Room: %s\n", room)
+	fmt.Fprintf(&b, "- Started: %s\n", t.start.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "- Ended: %s\n", end.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&b, "- Duration: %s\n", end.Sub(t.start).Round(time.Second))
+
+	b.WriteString("\n## Timeline\n\n")
+	for _, ev := range t.events {
+		// Each event is a single list item, so the texts' newlines are flattened.
+		fmt.Fprintf(&b, "- `%s` %s\n", ev.at.Format("15:04:05"), strings.Join(strings.Fields(ev.text), " "))
+	}
+	fmt.Fprintf(&b, "- `%s` You left the session\n", end.Format("15:04:05"))
+
+	if t.withOps {
+		// Clients reconnecting get a new ID, so the operations are counted by name.
+		counts := make(map[string]int)
+		for id, n := range t.ops {
+			counts[t.name(id)] += n
+		}
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if counts[names[i]] != counts[names[j]] {
+				return counts[names[i]] > counts[names[j]]
+			}
+			return names[i] < names[j]
+		})
+
+		b.WriteString("\n## Operations\n\n| User | Operations |\n| --- | ---: |\n")
+		for _, name := range names {
+			fmt.Fprintf(&b, "| %s | %d |\n", strings.ReplaceAll(name, "|", `\|`), counts[name])
+		}
+	}
+
+	// The fence is longer than any run of backticks in the document.
+	fence := "```"
+	for strings.Contains(document, fence) {
+		fence += "`"
+	}
+	fmt.Fprintf(&b, "\n## Document\n\n%s\n%s", fence, document)
+	if !strings.HasSuffix(document, "\n") {
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "%s\n", fence)
+	return b.String()
+}
+
+// write writes the transcript of the session ended at end to path.
+func (t *transcript) write(path, room, document string, end time.Time) error {
+	if t == nil {
+		return nil
+	}
+	return os.WriteFile(path, []byte(t.markdown(room, document, end)), 0644)
+}
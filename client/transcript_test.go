@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/google/uuid"
+)
+
+// TestTranscript checks that the session's events are listed in the transcript's timeline,
+// with the operations counted by user, and the final document fenced.
+func TestTranscript(t *testing.T) {
+	defer func(name string) { username = name }(username)
+	username = "alice"
+
+	start := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	at := func(min int) time.Time { return start.Add(time.Duration(min) * time.Minute) }
+	bob, carol := uuid.New(), uuid.New()
+
+	tr := newTranscript(start, true)
+	for _, m := range []struct {
+		kind string
+		msg  commons.Message
+	}{
+		{entryOut, commons.Message{Type: commons.JoinMessage, Username: "alice"}},
+		{entryIn, commons.Message{Type: commons.JoinAckMessage, Username: "alice"}},
+		{entryIn, commons.Message{Type: commons.JoinMessage, Username: "bob", ID: bob}},
+		{entryOut, commons.Message{Type: commons.OperationMessage}},
+		{entryIn, commons.Message{Type: commons.OperationMessage, ID: bob}},
+		{entryIn, commons.Message{Type: commons.OperationMessage, ID: bob}},
+		{entryIn, commons.Message{Type: commons.OperationMessage, ID: carol}},
+		{entryOut, commons.Message{Type: commons.AnnotationMessage, Annotation: &commons.Annotation{Author: "alice", Text: "why\nthis?"}}},
+		{entryIn, commons.Message{Type: commons.PingMessage, Username: "bob", ID: bob}},
+		{entryIn, commons.Message{Type: commons.LeaveMessage, Username: "bob", ID: bob, Text: commons.LeaveReasonConnectionLost}},
+	} {
+		tr.message(m.kind, m.msg, at(1))
+	}
+
+	got := tr.markdown("interview", "x := \"```\"", at(5))
+	for _, expected := range []string{
+		"- Room: interview\n",
+		"- Duration: 5m0s\n",
+		"- `10:01:00` You joined the session as alice\n- `10:01:00` bob joined the session\n",
+		"- `10:01:00` alice commented: why this?\n",
+		"- `10:01:00` bob asked for attention\n- `10:01:00` bob lost connection to the session\n- `10:05:00` You left the session\n",
+		"| bob | 2 |\n| alice | 1 |\n| unknown user " + carol.String()[:8] + " | 1 |\n",
+		"\n````\nx := \"```\"\n````\n",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("transcript doesn't contain %q:\n%s", expected, got)
+		}
+	}
+	if strings.Contains(got, "alice joined") {
+		t.Errorf("the client's own join message is listed:\n%s", got)
+	}
+
+	// A transcript which isn't kept does nothing.
+	var none *transcript
+	none.message(entryIn, commons.Message{Type: commons.JoinMessage}, start)
+	if err := none.write("", "", "", start); err != nil {
+		t.Errorf("got error %v, expected none", err)
+	}
+}
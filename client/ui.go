@@ -1,38 +1,64 @@
 package main
 
 import (
+	"errors"
+	"time"
+
 	"github.com/burntcarrot/pairpad/client/editor"
 	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gdamore/tcell/v2"
 	"github.com/gorilla/websocket"
-	"github.com/nsf/termbox-go"
 )
 
 type UIConfig struct {
 	EditorConfig editor.EditorConfig
 }
 
-// TUI is built using termbox-go.
-// termbox allows us to set any content to individual cells, and hence, the basic building block of the editor is a "cell".
+// TUI is built using tcell.
+// tcell allows us to set any content to individual cells, and hence, the basic building block of the editor is a "cell".
+// It draws in 24-bit colors where the terminal supports them, and reports modifier keys and bracketed pastes.
 
 // initUI creates a new editor view and runs the main loop.
 func initUI(conn *websocket.Conn, conf UIConfig) error {
-	err := termbox.Init()
+	screen, err := tcell.NewScreen()
 	if err != nil {
 		return err
 	}
-	defer termbox.Close()
+	if err := screen.Init(); err != nil {
+		return err
+	}
+	defer screen.Fini()
+	screen.EnablePaste()
 
+	conf.EditorConfig.Screen = screen
+	conf.EditorConfig.OnStatus = func(msg string) { logger.Infof("got status message: %s", msg) }
 	e = editor.NewEditor(conf.EditorConfig)
-	e.SetSize(termbox.Size())
+	e.SetSize(screen.Size())
+	if replay != nil {
+		e.SetSize(replay.start.Width, replay.start.Height)
+	}
+	rec.start(e.GetWidth(), e.GetHeight())
 	e.SetText(crdt.Content(doc))
+	e.SetFileName(fileName)
+	e.SetDirty(recovered)
+	refreshGitStatus()
+	refreshFileFormat()
 	e.SendDraw()
 	e.IsConnected = true
 
-	go handleStatusMsg()
+	go e.StatusLoop(nil)
+	if versionWarning != "" {
+		e.StatusChan <- versionWarning
+	}
 
-	go drawLoop()
+	if flags.Debug {
+		go tracker.watch()
+	}
+
+	go e.DrawLoop(nil)
+	go e.ClockLoop(nil)
 
-	err = mainLoop(conn)
+	err = mainLoop(conn, screen)
 	if err != nil {
 		return err
 	}
@@ -41,22 +67,67 @@ func initUI(conn *websocket.Conn, conf UIConfig) error {
 }
 
 // mainLoop is the main update loop for the UI.
-func mainLoop(conn *websocket.Conn) error {
-	// termboxChan is used for sending and receiving termbox events.
-	termboxChan := getTermboxChan()
+func mainLoop(conn *websocket.Conn, screen tcell.Screen) error {
+	// eventChan is used for receiving the terminal's events.
+	eventChan := getEventChan(screen)
 
 	// msgChan is used for sending and receiving messages.
 	msgChan := getMsgChan(conn)
 
+	// swapTicks is used for writing the swap file periodically.
+	var swapTicks <-chan time.Time
+	if swapFile != "" && replay == nil {
+		ticker := time.NewTicker(swapInterval)
+		defer ticker.Stop()
+		swapTicks = ticker.C
+	}
+
+	// perfTicker is used for refreshing the performance overlay.
+	perfTicker := time.NewTicker(perfInterval)
+	defer perfTicker.Stop()
+
+	// When replaying, the recorded events and messages come in their own channels. Real
+	// events are still handled, so the editor can be exited.
+	var replayEvents chan tcell.Event
+	if replay != nil {
+		replayEvents, msgChan = replay.events, replay.msgs
+		go replay.run()
+	}
+
 	for {
+		var event tcell.Event
+		replayed := false
 		select {
-		case termboxEvent := <-termboxChan:
-			err := handleTermboxEvent(termboxEvent, conn)
-			if err != nil {
-				return err
-			}
+		case event = <-eventChan:
+		case event = <-replayEvents:
+			replayed = true
 		case msg := <-msgChan:
+			rec.message(entryIn, msg)
+			trans.message(entryIn, msg, time.Now())
 			handleMsg(msg, conn)
+			continue
+		case job := <-savedChan:
+			finishSave(job)
+			continue
+		case <-perfTicker.C:
+			refreshPerf()
+			continue
+		case <-swapTicks:
+			if err := writeSwap(); err != nil {
+				logger.Errorf("failed to write the swap file, err: %v\n", err)
+			}
+			continue
+		}
+
+		rec.event(event)
+		err := handleEvent(event, conn)
+		if errors.Is(err, errExit) && replayed {
+			// Stay in the editor at the end of the replay, to see its result.
+			continue
+		}
+		if err != nil {
+			waitSaves()
+			return err
 		}
 	}
 }
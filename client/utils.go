@@ -1,15 +1,23 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
 	"github.com/burntcarrot/pairpad/crdt"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
@@ -18,33 +26,57 @@ import (
 
 // Flags represents the command-line flags that are passed to pairpad's client.
 type Flags struct {
-	Server string
-	Secure bool
-	Login  bool
-	File   string
-	Debug  bool
-	Scroll bool
-}
+	Server         string
+	Room           string
+	Secure         bool
+	Login          bool
+	File           string
+	Debug          bool
+	Scroll         bool
+	Config         string
+	Interviewer    string
+	RecordInput    string
+	ReplayInput    string
+	Transcript     string
+	TranscriptOps  bool
+	IgnoreVersion  bool
+	MaxMessageSize int64
+	Recent         bool
 
-// parseFlags parses command-line flags.
-func parseFlags() Flags {
-	serverAddr := flag.String("server", "localhost:8080", "The network address of the server")
-	useSecureConn := flag.Bool("secure", false, "Enable a secure WebSocket connection (wss://)")
-	enableDebug := flag.Bool("debug", false, "Enable debugging mode to show more verbose logs")
-	enableLogin := flag.Bool("login", false, "Enable the login prompt for the server")
-	file := flag.String("file", "", "The file to load the pairpad content from")
-	enableScroll := flag.Bool("scroll", true, "Enable scrolling with the cursor")
+	// LogDir is the directory the logs are written to, or "" for ~/.pairpad.
+	LogDir string
+
+	// Command is the subcommand the client was started with, or "" without one.
+	Command string
+
+	// HostPort is the port of the server started by the host command.
+	HostPort int
 
-	flag.Parse()
+	// Advertise is set if the host command advertises its session on the local network.
+	Advertise bool
 
-	return Flags{
-		Server: *serverAddr,
-		Secure: *useSecureConn,
-		Debug:  *enableDebug,
-		Login:  *enableLogin,
-		File:   *file,
-		Scroll: *enableScroll,
+	// Discover is set if the join command picks a session advertised on the local network.
+	Discover bool
+
+	// SSH is the machine, as user@host, through which the connection to the server is
+	// tunneled, or "" to connect directly.
+	SSH string
+
+	// SessionSet is set if the session to join was given with -server, -room or -secure.
+	SessionSet bool
+}
+
+// parseFlags parses the command line: a subcommand and its arguments, or the flags alone,
+// as before there were subcommands. It exits on errors, after printing the usage.
+func parseFlags() Flags {
+	flags, err := parseArgs(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
 	}
+	return flags
 }
 
 // createConn creates a WebSocket connection.
@@ -56,14 +88,187 @@ func createConn(flags Flags) (*websocket.Conn, *http.Response, error) {
 		u = url.URL{Scheme: "ws", Host: flags.Server, Path: "/"}
 	}
 
+	// Join the requested room, or the server's default room.
+	query := url.Values{}
+	if sendProtocol {
+		query.Set("protocol", strconv.Itoa(commons.ProtocolVersion))
+	}
+	if flags.Room != "" {
+		query.Set("room", flags.Room)
+	}
+	if flags.Interviewer != "" {
+		query.Set("interviewer", flags.Interviewer)
+	}
+	// Keep the site ID the client had in the room, if any.
+	if prevSite != nil {
+		query.Set("site", prevSite.SiteID)
+		query.Set("token", prevSite.Token)
+	}
+	u.RawQuery = query.Encode()
+
 	// Get WebSocket connection.
 	dialer := websocket.Dialer{
-		HandshakeTimeout: 2 * time.Minute,
+		HandshakeTimeout: connection.HandshakeTimeout,
+	}
+	if tunnel != nil {
+		dialer.NetDial = tunnel.dial
 	}
 
 	return dialer.Dial(u.String(), nil)
 }
 
+// greeting is the server's first message, read by connect before the editor starts. It's
+// handled as the first message received.
+var greeting *commons.Message
+
+var (
+	// sendProtocol is cleared to connect to older servers, which don't speak the client's
+	// protocol version, as a client predating versions: such servers assume version 1.
+	sendProtocol = true
+
+	// versionWarning is shown in the status bar once the editor starts, if the server speaks
+	// another protocol version, and -ignore-version is set.
+	versionWarning string
+)
+
+// serverProtocol returns the protocol version the server speaks, from its handshake
+// response.
+func serverProtocol(resp *http.Response) int {
+	if resp == nil {
+		return 1
+	}
+	v, err := strconv.Atoi(resp.Header.Get(commons.ProtocolHeader))
+	if err != nil {
+		return 1
+	}
+	return v
+}
+
+// versionMismatch describes the difference between the client's protocol version and the
+// server's, or returns "" if they're the same.
+func versionMismatch(server int) string {
+	switch {
+	case server > commons.ProtocolVersion:
+		return fmt.Sprintf("The server speaks a newer protocol (version %d) than this client (version %d): update pairpad", server, commons.ProtocolVersion)
+	case server < commons.ProtocolVersion:
+		return fmt.Sprintf("The server speaks an older protocol (version %d) than this client (version %d): some features won't work", server, commons.ProtocolVersion)
+	}
+	return ""
+}
+
+// connect connects to the server. The server's first message tells whether the client was
+// turned away: if the error can be retried, connect tries again a few times, waiting longer
+// each time (see ConnectionConfig), and otherwise
+// it returns the error. Servers speaking another protocol version are an error too, unless
+// flags.IgnoreVersion is set.
+func connect(flags Flags) (*websocket.Conn, error) {
+	for retries := 0; ; {
+		conn, resp, err := createConn(flags)
+		if err != nil {
+			return nil, err
+		}
+
+		if mismatch := versionMismatch(serverProtocol(resp)); mismatch != "" {
+			if !flags.IgnoreVersion {
+				conn.Close()
+				return nil, fmt.Errorf("%s (or pass -ignore-version to connect anyway)", mismatch)
+			}
+			versionWarning = mismatch
+		}
+
+		limitReads(conn, flags.MaxMessageSize)
+		extendReadDeadline(conn)
+		msg, err := readMessage(conn)
+		_ = conn.SetReadDeadline(time.Time{})
+		if isReadLimit(err) {
+			conn.Close()
+			return nil, fmt.Errorf("the server sent a message larger than %s (or pass a larger -max-message-size)", formatBytes(maxMessageSize))
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if msg.Type != commons.ErrorMessage {
+			greeting = &msg
+			return conn, nil
+		}
+
+		conn.Close()
+
+		// Servers turning away the client's version are older, and assume version 1
+		// without it.
+		if msg.Code == commons.ErrorVersionMismatch && flags.IgnoreVersion && sendProtocol {
+			sendProtocol = false
+			versionWarning = fmt.Sprintf("The server doesn't speak this client's protocol (version %d): some features won't work", commons.ProtocolVersion)
+			continue
+		}
+		if msg.Code == commons.ErrorVersionMismatch {
+			return nil, fmt.Errorf("%s (or pass -ignore-version to connect anyway)", msg.Text)
+		}
+		if !msg.Code.Retryable() || retries == connection.JoinRetries {
+			return nil, errors.New(msg.Text)
+		}
+		delay := connection.retryDelay(retries)
+		fmt.Printf("%s, trying again in %s\n", msg.Text, delay)
+		time.Sleep(delay)
+		retries++
+	}
+}
+
+// stateFileExt is the extension of files which hold the document's CRDT state (see
+// crdt.SaveDocument), rather than only its content.
+const stateFileExt = ".pairpad"
+
+// loadFile loads a document from the named file. Files with the stateFileExt extension
+// hold the document's CRDT state; other files are read as plain text, with their line
+// endings normalized (see decodeText).
+func loadFile(name string) (crdt.Document, error) {
+	if filepath.Ext(name) != stateFileExt {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return crdt.New(), err
+		}
+		return crdt.FromText(decodeText(content))
+	}
+
+	f, err := crdt.LoadDocument(name)
+	if err != nil {
+		return crdt.New(), err
+	}
+	crdt.AdvanceClock(f.Clock)
+	return f.Document, nil
+}
+
+// saveFile saves the document to the named file, using the same format as loadFile. Plain
+// text files are saved with the given line endings. The file is replaced atomically, and
+// its previous versions are kept as fileBackups backups.
+func saveFile(name string, doc *crdt.Document, newline string) error {
+	var data []byte
+	if filepath.Ext(name) != stateFileExt {
+		data = []byte(encodeText(crdt.Content(*doc), newline))
+	} else {
+		var err error
+		if data, err = crdt.EncodeFile(doc); err != nil {
+			return err
+		}
+	}
+	return writeFile(name, data, fileMode, fileBackups)
+}
+
+// expandPath returns the absolute path of a path given on the command line, in which a
+// leading "~" stands for the home directory, so that "-file=~/notes.txt" works as it does
+// when the shell expands it.
+func expandPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~"+string(filepath.Separator)) || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	return filepath.Abs(path)
+}
+
 // ensureDirExists ensures that a directory exists, and if it isn't present, it tries to create a new one.
 func ensureDirExists(path string) (bool, error) {
 	// Check if the directory exists
@@ -80,41 +285,57 @@ func ensureDirExists(path string) (bool, error) {
 	return true, nil
 }
 
-// setupLogger initializes the client's logger (logrus).
-func setupLogger(logger *logrus.Logger) (*os.File, *os.File, error) {
+// setupLogger initializes the client's logger (logrus), writing to log files in logDir, or
+// in ~/.pairpad if it's empty, which are rotated within limits. If dirMode isn't zero, the
+// log directory's permissions are set to it.
+func setupLogger(logger *logrus.Logger, logDir string, dirMode fs.FileMode, limits LogConfig) (*logFile, *logFile, error) {
 	// define log file paths, based on the home directory.
 	logPath := "pairpad.log"
 	debugLogPath := "pairpad-debug.log"
 
-	// Get the home directory.
-	homeDirExists := true
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		homeDirExists = false
-	}
+	if logDir != "" {
+		if err := os.MkdirAll(logDir, 0700); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		// Get the home directory.
+		homeDirExists := true
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			homeDirExists = false
+		}
 
-	pairpadDir := filepath.Join(homeDir, ".pairpad")
+		pairpadDir := filepath.Join(homeDir, ".pairpad")
 
-	dirExists, err := ensureDirExists(pairpadDir)
-	if err != nil {
-		return nil, nil, err
-	}
+		dirExists, err := ensureDirExists(pairpadDir)
+		if err != nil {
+			return nil, nil, err
+		}
 
-	// Get log paths based on the home directory.
-	if dirExists && homeDirExists {
-		logPath = filepath.Join(pairpadDir, "pairpad.log")
-		debugLogPath = filepath.Join(pairpadDir, "pairpad-debug.log")
+		// Get log paths based on the home directory.
+		if dirExists && homeDirExists {
+			logDir = pairpadDir
+		}
+	}
+	if logDir != "" {
+		if dirMode != 0 {
+			if err := os.Chmod(logDir, dirMode); err != nil {
+				return nil, nil, err
+			}
+		}
+		logPath = filepath.Join(logDir, "pairpad.log")
+		debugLogPath = filepath.Join(logDir, "pairpad-debug.log")
 	}
 
 	// Open the log file and create if it does not exist.
-	logFile, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644) // skipcq: GSC-G302
+	logFile, err := openLogFile(logPath, limits)
 	if err != nil {
 		fmt.Printf("Logger error, exiting: %s", err)
 		return nil, nil, err
 	}
 
 	// Create a separate log file for verbose logs.
-	debugLogFile, err := os.OpenFile(debugLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644) // skipcq: GSC-G302
+	debugLogFile, err := openLogFile(debugLogPath, limits)
 	if err != nil {
 		fmt.Printf("Logger error, exiting: %s", err)
 		return nil, nil, err
@@ -145,7 +366,7 @@ func setupLogger(logger *logrus.Logger) (*os.File, *os.File, error) {
 
 // closeLogFiles closes the log files created by the client.
 // closeLogFiles is meant to be used for defer calls.
-func closeLogFiles(logFile, debugLogFile *os.File) {
+func closeLogFiles(logFile, debugLogFile *logFile) {
 	if err := logFile.Close(); err != nil {
 		fmt.Printf("Failed to close log file: %s", err)
 		return
@@ -166,3 +387,77 @@ func printDoc(doc crdt.Document) {
 		}
 	}
 }
+
+// validateDoc checks the document's invariants in debugging mode, after what was applied
+// to it, and logs the problems found, to tell which operation broke it.
+func validateDoc(doc crdt.Document, after string) {
+	if !flags.Debug {
+		return
+	}
+	if err := doc.Validate(); err != nil {
+		logger.Errorf("document invalid after the %s: %v\n", after, err)
+	}
+}
+
+// lastStats holds the conflict counters that were last logged by printStats.
+var lastStats crdt.ConflictStats
+
+// printStats logs the CRDT's conflict counters whenever they change, and refreshes the
+// stats overlay if it's being shown. Like printDoc, it only logs in debugging mode.
+func printStats() {
+	if !flags.Debug {
+		return
+	}
+
+	stats := crdt.Stats()
+	if !reflect.DeepEqual(stats, lastStats) {
+		logger.Infof("CONFLICT STATS: conflicts=%d recursions=%d maxDepth=%d insertedWins=%d existingWins=%d winsBySite=%v",
+			stats.Conflicts, stats.Recursions, stats.MaxDepth, stats.InsertedWins, stats.ExistingWins, stats.WinsBySite)
+		lastStats = stats
+	}
+
+	if showStats {
+		names := make(map[string]string, len(siteNames)+1)
+		for site, name := range siteNames {
+			names[site] = name
+		}
+		names[strconv.Itoa(crdt.SiteID)] = username
+		e.SetOverlay(statsOverlay(stats, names))
+	}
+}
+
+// statsOverlay returns an overlay displaying the CRDT's conflict counters, and the
+// comparisons won by each user's characters, whose names are keyed by site ID.
+func statsOverlay(stats crdt.ConflictStats, names map[string]string) *editor.Overlay {
+	lines := []string{
+		fmt.Sprintf("conflicts:     %d", stats.Conflicts),
+		fmt.Sprintf("recursions:    %d", stats.Recursions),
+		fmt.Sprintf("max depth:     %d", stats.MaxDepth),
+		fmt.Sprintf("inserted wins: %d", stats.InsertedWins),
+		fmt.Sprintf("existing wins: %d", stats.ExistingWins),
+	}
+	if len(stats.WinsBySite) == 0 {
+		return &editor.Overlay{Title: "CRDT conflicts", Lines: lines}
+	}
+
+	sites := make([]int, 0, len(stats.WinsBySite))
+	for site := range stats.WinsBySite {
+		sites = append(sites, site)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if stats.WinsBySite[sites[i]] != stats.WinsBySite[sites[j]] {
+			return stats.WinsBySite[sites[i]] > stats.WinsBySite[sites[j]]
+		}
+		return sites[i] < sites[j]
+	})
+
+	lines = append(lines, "", "wins by site:")
+	for _, site := range sites {
+		name, ok := names[strconv.Itoa(site)]
+		if !ok {
+			name = fmt.Sprintf("site %d", site)
+		}
+		lines = append(lines, fmt.Sprintf("  %5d  %s", stats.WinsBySite[site], name))
+	}
+	return &editor.Overlay{Title: "CRDT conflicts", Lines: lines}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gorilla/websocket"
+)
+
+// TestConnect checks that the client tries connecting again while the server turns it away
+// with an error it can retry after, and gives up on the other errors.
+func TestConnect(t *testing.T) {
+	defer func(c ConnectionConfig) { connection, greeting = c, nil }(connection)
+	connection.RetryDelay = 0
+
+	// The server turns the client away with the errors, then lets it in.
+	var mu sync.Mutex
+	var errs []commons.ErrorCode
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(errs) > 0 {
+			_ = conn.WriteJSON(commons.Message{Type: commons.ErrorMessage, Code: errs[0], Text: string(errs[0])})
+			errs = errs[1:]
+			return
+		}
+		_ = conn.WriteJSON(commons.Message{Type: commons.SiteIDMessage, Text: "1"})
+	}))
+	defer ts.Close()
+	flags := Flags{Server: strings.TrimPrefix(ts.URL, "http://")}
+
+	mu.Lock()
+	errs = []commons.ErrorCode{commons.ErrorRoomFull, commons.ErrorRateLimited}
+	mu.Unlock()
+	conn, err := connect(flags)
+	if err != nil {
+		t.Fatalf("got error %v, expected to connect after retrying", err)
+	}
+	conn.Close()
+	if greeting == nil || greeting.Type != commons.SiteIDMessage {
+		t.Errorf("got greeting %+v, expected the site ID message", greeting)
+	}
+
+	mu.Lock()
+	errs = []commons.ErrorCode{commons.ErrorRoomFull, commons.ErrorAuthFailed}
+	mu.Unlock()
+	if _, err := connect(flags); err == nil || err.Error() != string(commons.ErrorAuthFailed) {
+		t.Errorf("got error %v, expected %q", err, commons.ErrorAuthFailed)
+	}
+}
+
+// TestConnectVersion checks that the client refuses servers speaking another protocol
+// version, unless -ignore-version is set, in which case it warns about them.
+func TestConnectVersion(t *testing.T) {
+	defer func() { greeting, sendProtocol, versionWarning = nil, true, "" }()
+
+	upgrader := websocket.Upgrader{}
+	newer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, http.Header{commons.ProtocolHeader: {"2"}})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteJSON(commons.Message{Type: commons.SiteIDMessage, Text: "1"})
+	}))
+	defer newer.Close()
+
+	// An older server turns away the versions it doesn't know, and assumes version 1
+	// without one.
+	older := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if r.URL.Query().Get("protocol") != "" {
+			_ = conn.WriteJSON(commons.Message{Type: commons.ErrorMessage, Code: commons.ErrorVersionMismatch, Text: "unsupported protocol version"})
+			return
+		}
+		_ = conn.WriteJSON(commons.Message{Type: commons.SiteIDMessage, Text: "1"})
+	}))
+	defer older.Close()
+
+	tests := []struct {
+		description string
+		url         string
+		expected    string
+	}{
+		{description: "newer server", url: newer.URL, expected: "newer protocol"},
+		{description: "older server", url: older.URL, expected: "-ignore-version"},
+	}
+	for _, tc := range tests {
+		greeting, sendProtocol, versionWarning = nil, true, ""
+		flags := Flags{Server: strings.TrimPrefix(tc.url, "http://")}
+		if _, err := connect(flags); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("(%s) got error %v, expected one containing %q", tc.description, err, tc.expected)
+		}
+
+		flags.IgnoreVersion = true
+		conn, err := connect(flags)
+		if err != nil {
+			t.Errorf("(%s) got error %v, expected to connect with -ignore-version", tc.description, err)
+			continue
+		}
+		conn.Close()
+		if versionWarning == "" {
+			t.Errorf("(%s) got no warning about the server's version", tc.description)
+		}
+	}
+}
+
+// TestExpandPath checks that "~" stands for the home directory, and that relative paths
+// are made absolute.
+func TestExpandPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, expected string
+	}{
+		{"~", home},
+		{"~/notes.txt", filepath.Join(home, "notes.txt")},
+		{"notes.txt", filepath.Join(wd, "notes.txt")},
+		{"../notes.txt", filepath.Join(filepath.Dir(wd), "notes.txt")},
+		{"~notes.txt", filepath.Join(wd, "~notes.txt")},
+		{"/tmp/notes.txt", "/tmp/notes.txt"},
+	}
+	for _, tc := range tests {
+		if got, err := expandPath(tc.path); err != nil || got != tc.expected {
+			t.Errorf("%q: got %q, %v, expected %q", tc.path, got, err, tc.expected)
+		}
+	}
+}
+
+// TestStatsOverlay checks that the wins of each site are listed by count, with the users'
+// names, or their site IDs once they're unknown.
+func TestStatsOverlay(t *testing.T) {
+	stats := crdt.ConflictStats{Conflicts: 2, Recursions: 3, MaxDepth: 2, InsertedWins: 1, ExistingWins: 4, WinsBySite: map[int]int{1: 1, 2: 4}}
+	got := statsOverlay(stats, map[string]string{"2": "alice"}).Lines
+	expected := []string{
+		"conflicts:     2",
+		"recursions:    3",
+		"max depth:     2",
+		"inserted wins: 1",
+		"existing wins: 4",
+		"",
+		"wins by site:",
+		"      4  alice",
+		"      1  site 1",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
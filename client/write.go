@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// fileBackups is set by the config file to the number of previous versions of a file kept
+// when it's saved.
+var fileBackups int
+
+// fileMode is the permissions of the files created on save. The config file may change it.
+var fileMode fs.FileMode = 0644
+
+// backupName returns the name of the nth previous version of the named file, from 1 for
+// the latest: "notes.txt.~1~", as GNU cp and mv number their backups.
+func backupName(name string, n int) string {
+	return fmt.Sprintf("%s.~%d~", name, n)
+}
+
+// writeFile replaces the named file's content with data, keeping backups previous versions
+// of it. The data is written to a temporary file in the same directory, synced to disk, and
+// renamed over the file, so a crash while saving leaves either the previous version or the
+// new one in place, never a part of it. A new file gets the permissions perm; an existing
+// one keeps its own.
+func writeFile(name string, data []byte, perm fs.FileMode, backups int) error {
+	// Symbolic links are kept, and the file they point to is replaced.
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+	if info, err := os.Stat(name); err == nil {
+		perm = info.Mode().Perm()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	dir := filepath.Dir(name)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if backups > 0 {
+		if err := rotateBackups(name, backups); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", name, err)
+		}
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// rotateBackups shifts the backups of the named file by one, dropping the oldest of the n
+// kept, and makes the file the latest backup. The file itself stays in place until it's
+// replaced.
+func rotateBackups(name string, n int) error {
+	if _, err := os.Stat(name); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err := os.Remove(backupName(name, n)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for i := n - 1; i >= 1; i-- {
+		if err := os.Rename(backupName(name, i), backupName(name, i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	// A hard link keeps the file's content as the backup without copying it, and the
+	// file stays in place if writing the new version fails.
+	if err := os.Link(name, backupName(name, 1)); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(backupName(name, 1), data, info.Mode().Perm())
+}
+
+// syncDir syncs the directory to disk, so that a file renamed in it stays renamed after a
+// crash. It's not supported everywhere (such as on Windows), so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	d.Close()
+}
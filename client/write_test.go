@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestWriteFile checks that files are replaced, keeping their permissions and the given
+// number of backups, and that symbolic links are kept.
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "notes.txt")
+
+	for _, content := range []string{"one", "two", "three", "four"} {
+		if err := writeFile(name, []byte(content), 0600, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file, expected := range map[string]string{name: "four", backupName(name, 1): "three", backupName(name, 2): "two"} {
+		if got, err := os.ReadFile(file); err != nil || string(got) != expected {
+			t.Errorf("%s: got %q, %v, expected %q", file, got, err, expected)
+		}
+	}
+	if _, err := os.Stat(backupName(name, 3)); !os.IsNotExist(err) {
+		t.Errorf("got a third backup (%v), expected only two to be kept", err)
+	}
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(name); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("got mode %v, %v, expected the new file's permissions, 0600", info.Mode(), err)
+		}
+	}
+
+	// The temporary files are gone.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("got %d files, expected the file and its backups", len(entries))
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink(name, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(link, []byte("five"), 0644, 0); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("got %v, %v, expected the link to be kept", info.Mode(), err)
+	}
+	if got, _ := os.ReadFile(name); string(got) != "five" {
+		t.Errorf("got %q, expected the link's target to be written", got)
+	}
+}
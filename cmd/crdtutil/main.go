@@ -0,0 +1,243 @@
+// Command crdtutil works on documents saved with their CRDT state, as .pairpad files, by
+// the client or by pairpad-server's dir store, offline:
+//
+//	crdtutil text notes.pairpad                  # print the visible content
+//	crdtutil import -site 3 notes.txt            # convert a text file to CRDT state
+//	crdtutil merge a.pairpad b.pairpad           # merge two replicas of a document
+//	crdtutil validate notes.pairpad              # check the document's structure
+//	crdtutil compact -o small.pairpad notes.pairpad
+//
+// The documents written are printed, unless -o names a file to write them to.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// A command is one of crdtutil's subcommands.
+type command struct {
+	name, args, desc string
+
+	// nargs is the number of files the command takes.
+	nargs int
+
+	// flags, if not nil, adds the command's own flags.
+	flags func(fs *flag.FlagSet)
+
+	// run runs the command on the files, writing its output to out.
+	run func(files []string, out io.Writer) error
+}
+
+// importSite is the site ID the characters imported by the import command are attributed to.
+var importSite int
+
+// errInvalid is returned by validate when the document is broken, once the problems are
+// printed.
+var errInvalid = errors.New("the document is invalid")
+
+var commands = []command{
+	{name: "text", args: "<file.pairpad>", desc: "Print the document's visible content", nargs: 1, run: runText},
+	{name: "import", args: "<file>", desc: "Convert a text file to a document, with each rune a character of -site", nargs: 1, run: runImport, flags: func(fs *flag.FlagSet) {
+		fs.IntVar(&importSite, "site", 0, "The site ID the imported characters are attributed to")
+	}},
+	{name: "merge", args: "<a.pairpad> <b.pairpad>", desc: "Merge two replicas of a document, keeping the edits of both", nargs: 2, run: runMerge},
+	{name: "validate", args: "<file.pairpad>", desc: "Check the document's structure, printing the problems found", nargs: 1, run: runValidate},
+	{name: "compact", args: "<file.pairpad>", desc: "Drop the document's tombstones, once every replica stopped editing it", nargs: 1, run: runCompact},
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s <command> [flags] <files>\n\nCommands:\n", os.Args[0])
+		for _, cmd := range commands {
+			fmt.Fprintf(flag.CommandLine.Output(), "  %-8s %s\n", cmd.name, cmd.desc)
+		}
+	}
+	if len(os.Args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == os.Args[1] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	output := fs.String("o", "", "Write the output to this file, instead of printing it")
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s [flags] %s\n\n%s.\n\n", os.Args[0], cmd.name, cmd.args, cmd.desc)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(os.Args[2:])
+	if fs.NArg() != cmd.nargs {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create output: %s\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := cmd.run(fs.Args(), out); err != nil {
+		if !errors.Is(err, errInvalid) {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err)
+		}
+		os.Exit(1)
+	}
+}
+
+// load reads a file holding a document's CRDT state.
+func load(name string) (crdt.File, error) {
+	f, err := crdt.LoadDocument(name)
+	if err != nil {
+		return f, fmt.Errorf("failed to load %s: %w", name, err)
+	}
+	return f, nil
+}
+
+// write writes a document's CRDT state, as saved by the site with the given clock.
+func write(out io.Writer, doc crdt.Document, siteID, clock int) error {
+	data, err := json.Marshal(crdt.File{
+		Version:  crdt.FormatVersion,
+		Type:     crdt.FormatType,
+		SiteID:   siteID,
+		Clock:    clock,
+		SavedAt:  time.Now().UTC(),
+		Document: doc,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+// maxClock returns the highest clock of the characters of doc generated by the site.
+func maxClock(doc crdt.Document, siteID, clock int) int {
+	for _, c := range doc.Characters {
+		if c.ID.SiteID == siteID && c.ID.Clock > clock {
+			clock = c.ID.Clock
+		}
+	}
+	return clock
+}
+
+func runText(files []string, out io.Writer) error {
+	f, err := load(files[0])
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, crdt.Content(f.Document))
+	return err
+}
+
+func runImport(files []string, out io.Writer) error {
+	content, err := os.ReadFile(files[0])
+	if err != nil {
+		return err
+	}
+	crdt.SiteID = importSite
+	doc, err := crdt.FromText(string(content))
+	if err != nil {
+		return err
+	}
+	return write(out, doc, crdt.SiteID, crdt.LocalClock)
+}
+
+func runMerge(files []string, out io.Writer) error {
+	a, err := load(files[0])
+	if err != nil {
+		return err
+	}
+	b, err := load(files[1])
+	if err != nil {
+		return err
+	}
+	if err := a.Document.Merge(&b.Document); err != nil {
+		return err
+	}
+	return write(out, a.Document, a.SiteID, maxClock(a.Document, a.SiteID, a.Clock))
+}
+
+func runValidate(files []string, out io.Writer) error {
+	f, err := load(files[0])
+	if err != nil {
+		return err
+	}
+	problems := validate(f.Document)
+	for _, p := range problems {
+		fmt.Fprintln(out, p)
+	}
+	if len(problems) > 0 {
+		return errInvalid
+	}
+	fmt.Fprintf(out, "%s: %d characters, no problems found\n", files[0], len(f.Document.Characters))
+	return nil
+}
+
+func runCompact(files []string, out io.Writer) error {
+	f, err := load(files[0])
+	if err != nil {
+		return err
+	}
+	if problems := validate(f.Document); len(problems) > 0 {
+		return fmt.Errorf("%s is invalid, validate it for the problems: %s", files[0], problems[0])
+	}
+	return write(out, compact(f.Document), f.SiteID, f.Clock)
+}
+
+// validate returns the structural problems of doc, found by crdt.Document.Validate.
+func validate(doc crdt.Document) []string {
+	var v *crdt.ValidationError
+	if err := doc.Validate(); errors.As(err, &v) {
+		return v.Problems
+	}
+	return nil
+}
+
+// compact returns doc without its tombstones. The characters are linked to their new
+// neighbours, as the ones they were inserted between may be gone. Replicas editing the
+// document may still refer to the tombstones, so every client must load the compacted
+// document before editing it again.
+func compact(doc crdt.Document) crdt.Document {
+	chars := make([]crdt.Character, 0, len(doc.Characters))
+	for _, c := range doc.Characters {
+		if c.Visible || c.ID == crdt.IDStart || c.ID == crdt.IDEnd {
+			chars = append(chars, c)
+		}
+	}
+	for i := range chars {
+		chars[i].IDPrevious, chars[i].IDNext = crdt.CharacterID{}, crdt.CharacterID{}
+		if i > 0 {
+			chars[i].IDPrevious = chars[i-1].ID
+		}
+		if i < len(chars)-1 {
+			chars[i].IDNext = chars[i+1].ID
+		}
+	}
+	return crdt.Document{Characters: chars}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// TestMergeCompact checks that merging two replicas of a document keeps the edits of both,
+// and that compacting the result drops its tombstones, and nothing else.
+func TestMergeCompact(t *testing.T) {
+	prevSiteID, prevClock := crdt.SiteID, crdt.LocalClock
+	defer func() { crdt.SiteID, crdt.LocalClock = prevSiteID, prevClock }()
+
+	// Both replicas start from the same document. Site 1 appends to it, and site 2 deletes
+	// its first character.
+	crdt.SiteID, crdt.LocalClock = 1, 0
+	base, err := crdt.FromText("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.pairpad"), filepath.Join(dir, "b.pairpad")
+
+	docA := crdt.Document{Characters: append([]crdt.Character(nil), base.Characters...)}
+	if _, err := docA.InsertRange(6, " world"); err != nil {
+		t.Fatal(err)
+	}
+	if err := crdt.SaveDocument(a, &docA); err != nil {
+		t.Fatal(err)
+	}
+
+	crdt.SiteID = 2
+	docB := crdt.Document{Characters: append([]crdt.Character(nil), base.Characters...)}
+	docB.Delete(1)
+	if err := crdt.SaveDocument(b, &docB); err != nil {
+		t.Fatal(err)
+	}
+
+	var merged bytes.Buffer
+	if err := runMerge([]string{a, b}, &merged); err != nil {
+		t.Fatal(err)
+	}
+	f, err := crdt.DecodeFile(merged.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := crdt.Content(f.Document); got != "ello world" {
+		t.Errorf("got %q merged, expected %q", got, "ello world")
+	}
+	if problems := validate(f.Document); len(problems) > 0 {
+		t.Errorf("got problems %q in the merged document, expected none", problems)
+	}
+
+	compacted := compact(f.Document)
+	if got := crdt.Content(compacted); got != "ello world" {
+		t.Errorf("got %q compacted, expected %q", got, "ello world")
+	}
+	if got, expected := len(compacted.Characters), len("ello world")+2; got != expected {
+		t.Errorf("got %d characters compacted, expected %d, without the tombstone", got, expected)
+	}
+	if problems := validate(compacted); len(problems) > 0 {
+		t.Errorf("got problems %q in the compacted document, expected none", problems)
+	}
+}
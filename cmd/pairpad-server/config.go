@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// envPrefix is the prefix of the environment variables setting the flags: -max-clients is
+// set by PAIRPAD_MAX_CLIENTS.
+const envPrefix = "PAIRPAD_"
+
+// envName returns the name of the environment variable setting the flag with the given name.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyConfig sets the flags of fs which weren't set on the command line, from their
+// environment variables, or else from the TOML config file at path, if it isn't empty.
+// The file's keys are the flags' names, such as max-clients = 10; lists, such as
+// allowed-origins, can be TOML arrays.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	values := make(map[string]interface{})
+	if path != "" {
+		md, err := toml.DecodeFile(path, &values)
+		if err != nil {
+			return fmt.Errorf("reading config file: %w", err)
+		}
+		for _, key := range md.Keys() {
+			if name := key.String(); fs.Lookup(name) == nil || name == "config" {
+				return fmt.Errorf("reading config file: unknown setting %q", name)
+			}
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+
+		if env, ok := os.LookupEnv(envName(f.Name)); ok {
+			if setErr := fs.Set(f.Name, env); setErr != nil {
+				err = fmt.Errorf("invalid %s: %w", envName(f.Name), setErr)
+			}
+			return
+		}
+
+		if v, ok := values[f.Name]; ok {
+			if setErr := fs.Set(f.Name, configValue(v)); setErr != nil {
+				err = fmt.Errorf("invalid %s in config file: %w", f.Name, setErr)
+			}
+		}
+	})
+	return err
+}
+
+// configValue formats a value of the config file as it would be written on the command
+// line. Arrays are joined with commas.
+func configValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = configValue(item)
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/burntcarrot/pairpad/server/store"
+)
+
+// inspect runs the inspect subcommand, printing what a document persisted by the server
+// holds, to debug a session whose document looks wrong. It returns the exit code.
+func inspect(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	content := fs.Bool("content", true, "Print the document's visible content")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s inspect [flags] <snapshot>\n\nPrints the metadata, content, tombstones and contributors of a .pairpad document saved by -store dir:PATH.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading snapshot: %s\n", err)
+		return 1
+	}
+	f, err := crdt.DecodeFile(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error decoding snapshot %s: %s\n", path, err)
+		return 1
+	}
+
+	// The operations logged since the snapshot, by the dir store, are next to it.
+	logged := -1
+	if room := strings.TrimSuffix(filepath.Base(path), ".pairpad"); room != filepath.Base(path) {
+		d := store.Dir{Path: filepath.Dir(path)}
+		_, ops, err := d.LoadLog(context.Background(), room)
+		switch {
+		case err == nil:
+			logged = len(ops)
+		case !errors.Is(err, store.ErrNotFound):
+			fmt.Fprintf(os.Stderr, "Error reading the snapshot's log: %s\n", err)
+		}
+	}
+
+	printInspection(out, path, f, logged)
+	if *content {
+		fmt.Fprintf(out, "\nContent:\n%s\n", crdt.Content(f.Document))
+	}
+	return 0
+}
+
+// printInspection writes the metadata of a snapshot, and the statistics of its document.
+// logged is the number of operations logged since the snapshot, or -1 if it has no log.
+func printInspection(out io.Writer, path string, f crdt.File, logged int) {
+	s := crdt.Summarize(f.Document)
+
+	// The tombstones are attributed to the sites which inserted the deleted characters.
+	deleted := map[int]int{}
+	for _, c := range f.Document.Characters {
+		if !c.Visible && c.ID != crdt.IDStart && c.ID != crdt.IDEnd {
+			deleted[c.ID.SiteID]++
+		}
+	}
+
+	fmt.Fprintf(out, "Snapshot:    %s\n", path)
+	fmt.Fprintf(out, "Format:      version %d, %s\n", f.Version, f.Type)
+	if f.SavedAt.IsZero() {
+		fmt.Fprintf(out, "Saved:       unknown\n")
+	} else {
+		fmt.Fprintf(out, "Saved:       %s, by site %d (clock %d)\n", f.SavedAt.Format("2006-01-02 15:04:05 MST"), f.SiteID, f.Clock)
+	}
+	fmt.Fprintf(out, "Content:     %d characters, %d words, %d lines\n", s.Characters, s.Words, s.Lines)
+	fmt.Fprintf(out, "Tombstones:  %d (%s of the characters)\n", s.Tombstones, percent(s.Tombstones, s.Characters+s.Tombstones))
+	fmt.Fprintf(out, "Memory:      %d bytes\n", s.Bytes)
+	if logged >= 0 {
+		fmt.Fprintf(out, "Log:         %d operations since the snapshot\n", logged)
+	}
+
+	sites := make([]int, 0, len(s.BySite)+len(deleted))
+	for site := range s.BySite {
+		sites = append(sites, site)
+	}
+	for site := range deleted {
+		if _, ok := s.BySite[site]; !ok {
+			sites = append(sites, site)
+		}
+	}
+	sort.Ints(sites)
+
+	fmt.Fprintf(out, "\nSites:\n")
+	for _, site := range sites {
+		fmt.Fprintf(out, "  site %-5d %6d characters (%s), %d deleted\n", site, s.BySite[site], percent(s.BySite[site], s.Characters), deleted[site])
+	}
+}
+
+// percent returns n as a percentage of total.
+func percent(n, total int) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
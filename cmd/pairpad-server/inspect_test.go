@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/burntcarrot/pairpad/server/store"
+)
+
+// TestInspect checks that inspect prints a snapshot's content, tombstones and the
+// contributions of each site, and counts the operations logged since it.
+func TestInspect(t *testing.T) {
+	prevSiteID, prevClock := crdt.SiteID, crdt.LocalClock
+	defer func() { crdt.SiteID, crdt.LocalClock = prevSiteID, prevClock }()
+
+	// Site 1 writes "hello", then site 2 deletes the "h" and adds "!".
+	crdt.SiteID, crdt.LocalClock = 1, 0
+	doc, err := crdt.FromText("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crdt.SiteID = 2
+	doc.Delete(1)
+	if _, err := doc.Insert(5, "!"); err != nil {
+		t.Fatal(err)
+	}
+
+	d := store.Dir{Path: t.TempDir()}
+	ctx := context.Background()
+	if err := d.Save(ctx, "notes", doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Append(ctx, "notes", []commons.Operation{{Type: "insert", Position: 1, Value: "a"}, {Type: "delete", Position: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if code := inspect([]string{filepath.Join(d.Path, "notes.pairpad")}, &out); code != 0 {
+		t.Fatalf("got exit code %d, expected 0", code)
+	}
+	for _, line := range []string{
+		"Content:     5 characters, 1 words, 1 lines",
+		"Tombstones:  1 (16.7% of the characters)",
+		"Log:         2 operations since the snapshot",
+		"  site 1          4 characters (80.0%), 1 deleted",
+		"  site 2          1 characters (20.0%), 0 deleted",
+		"Content:\nello!\n",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("got output:\n%s\nexpected it to contain %q", out.String(), line)
+		}
+	}
+
+	if code := inspect([]string{filepath.Join(d.Path, "missing.pairpad")}, &out); code != 1 {
+		t.Errorf("got exit code %d for a missing snapshot, expected 1", code)
+	}
+}
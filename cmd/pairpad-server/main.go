@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/burntcarrot/pairpad/server"
+	"github.com/burntcarrot/pairpad/server/broker"
+	"github.com/burntcarrot/pairpad/server/discovery"
+	"github.com/burntcarrot/pairpad/server/trace"
+	"github.com/fatih/color"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		os.Exit(inspect(os.Args[2:], os.Stdout))
+	}
+
+	configPath := flag.String("config", "", "Read the settings which aren't set by flags or environment variables from this TOML file, whose keys are the flags' names")
+	addr := flag.String("addr", ":8080", "Server's network address")
+	tlsCert := flag.String("tls-cert", "", "Serve HTTPS (and WSS) with the certificate in this PEM file, along with -tls-key")
+	tlsKey := flag.String("tls-key", "", "Private key of the -tls-cert certificate, in a PEM file")
+	metricsAddr := flag.String("metrics-addr", "", "Serve metrics in the Prometheus format at /metrics on this network address, apart from the clients")
+	accessLogPath := flag.String("access-log", "", "Append a line describing each request and WebSocket connection to this file, as JSON")
+	logFile := flag.String("log-file", "", "Append the server's logs to this file, instead of printing them")
+	allowedOrigins := flag.String("allowed-origins", "", "Comma-separated origins allowed to connect, or \"*\" for any origin; localhost only if empty")
+	maxClients := flag.Int("max-clients", 0, "Maximum number of clients per room (0 means no limit)")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "Maximum number of connections open at once from an IP address (0 means no limit)")
+	connRatePerIP := flag.Int("conn-rate-per-ip", 0, "Maximum number of connections opened per minute from an IP address (0 means no limit)")
+	maxDocSize := flag.Int("max-doc-size", 0, "Maximum number of characters in a room's document (0 means no limit)")
+	maxMessageSize := flag.Int64("max-message-size", 0, "Maximum size of a message from a client, in bytes (0 means no limit)")
+	recordPath := flag.String("record", "", "Append every operation to a session recording at this path (see cmd/replay)")
+	auditLogPath := flag.String("audit-log", "", "Record who performed each operation, and when, in this append-only file, queried through the API at /rooms/{room}/audit")
+	noWeb := flag.Bool("no-web", false, "Don't serve the web client at /web/")
+	storeSpec := flag.String("store", "", "Persist the rooms' documents in a store: dir:PATH, sqlite:PATH or s3://BUCKET[/PREFIX][?endpoint=URL&region=REGION]")
+	brokerURL := flag.String("broker", "", "Share rooms with the other servers using the Redis server at this URL (redis://[:password@]host[:port][/db])")
+	saveInterval := flag.Duration("save-interval", 10*time.Second, "How often changed documents are saved to the store")
+	snapshotOps := flag.Int("snapshot-ops", 1000, "Save documents whole after logging this many operations, with the dir and sqlite stores")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Close rooms after this long without activity, saving their documents (0 means never)")
+	maxSession := flag.Duration("max-session", 0, "Maximum duration of a room's session, after which its clients are disconnected (0 means no limit)")
+	interviewerToken := flag.String("interviewer-token", "", "Enable interview mode: clients connecting with this token join as interviewers")
+	apiToken := flag.String("api-token", "", "Enable the HTTP API reading and seeding the rooms' documents at /rooms/{room}/content (needs -store), and reading the audit log, for requests with this bearer token")
+	watermark := flag.String("watermark", "", "Embed the session's ID, participants and the time in a comment in the documents exported through the API from the rooms matching this pattern, such as \"interview-*\" or \"*\"")
+	watermarkComment := flag.String("watermark-comment", "//", "Start the lines of watermarks with this comment marker, unless exports ask for another")
+	watermarkFooter := flag.Bool("watermark-footer", false, "Put watermarks at the end of the exported documents, rather than the start")
+	writeTimeout := flag.Duration("write-timeout", 10*time.Second, "Disconnect clients which take longer than this to receive a message, or each 32 KiB of a large one")
+	readTimeout := flag.Duration("read-timeout", time.Minute, "Disconnect clients which send nothing, not even answers to the server's pings, for this long")
+	outboxSize := flag.Int("outbox-size", 1024, "Disconnect clients which fall this many messages behind")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "Export traces of the handling of the clients' messages to this OTLP/HTTP collector, such as http://localhost:4318")
+	traceRooms := flag.String("trace-rooms", "", "Only trace the messages of the rooms matching this pattern, such as \"interview-*\" (all rooms if empty)")
+	advertise := flag.Bool("advertise", false, "Advertise the server on the local network with mDNS, for pairpad join -discover")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n%s inspect <snapshot> prints what a document saved by -store dir:PATH holds.\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "\nEvery flag can also be set by an environment variable, such as %s for -max-clients.\n", envName("max-clients"))
+	}
+	flag.Parse()
+
+	// Flags override environment variables, which override the config file.
+	configFile := *configPath
+	if configFile == "" {
+		configFile = os.Getenv(envName("config"))
+	}
+	if err := applyConfig(flag.CommandLine, configFile); err != nil {
+		log.Fatal(err)
+	}
+
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("Error opening log file: %s", err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+		color.Output, color.NoColor = f, true
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be set together")
+	}
+
+	conf := server.Config{
+		AllowedOrigins:     splitList(*allowedOrigins),
+		MaxClientsPerRoom:  *maxClients,
+		MaxConnsPerIP:      *maxConnsPerIP,
+		ConnRatePerIP:      *connRatePerIP,
+		MaxDocumentSize:    *maxDocSize,
+		MaxMessageSize:     *maxMessageSize,
+		DisableWebClient:   *noWeb,
+		SaveInterval:       *saveInterval,
+		SnapshotOps:        *snapshotOps,
+		IdleTimeout:        *idleTimeout,
+		MaxSessionDuration: *maxSession,
+		InterviewerToken:   *interviewerToken,
+		APIToken:           *apiToken,
+		WriteTimeout:       *writeTimeout,
+		ReadTimeout:        *readTimeout,
+		OutboxSize:         *outboxSize,
+	}
+
+	if *watermark != "" {
+		if _, err := path.Match(*watermark, ""); err != nil {
+			log.Fatalf("Invalid -watermark pattern: %s", err)
+		}
+		conf.Watermark = &server.Watermark{Rooms: *watermark, Comment: *watermarkComment, Footer: *watermarkFooter}
+	}
+
+	if *otlpEndpoint != "" {
+		if _, err := path.Match(*traceRooms, ""); err != nil {
+			log.Fatalf("Invalid -trace-rooms pattern: %s", err)
+		}
+		tracer, err := trace.New(*otlpEndpoint, "pairpad-server")
+		if err != nil {
+			log.Fatalf("Error setting up tracing: %s", err)
+		}
+		conf.Tracer, conf.TraceRooms = tracer, *traceRooms
+	}
+
+	if *recordPath != "" {
+		f, err := os.OpenFile(*recordPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("Error opening recording: %s", err)
+		}
+		defer f.Close()
+		conf.Record = f
+	}
+
+	if *auditLogPath != "" {
+		audit, err := server.OpenAuditLog(*auditLogPath)
+		if err != nil {
+			log.Fatalf("Error opening audit log: %s", err)
+		}
+		defer audit.Close()
+		conf.AuditLog = audit
+	}
+
+	if *accessLogPath != "" {
+		f, err := os.OpenFile(*accessLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("Error opening access log: %s", err)
+		}
+		defer f.Close()
+		conf.AccessLog = f
+	}
+
+	if *brokerURL != "" {
+		b, err := broker.NewRedis(*brokerURL, "pairpad:")
+		if err != nil {
+			log.Fatalf("Error connecting to the broker: %s", err)
+		}
+		conf.Broker = b
+	}
+
+	if *storeSpec != "" {
+		st, closeStore, err := openStore(*storeSpec)
+		if err != nil {
+			log.Fatalf("Error opening store: %s", err)
+		}
+		defer closeStore()
+		conf.Store = st
+	}
+
+	s := server.New(conf)
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.MetricsHandler())
+		go func() {
+			log.Printf("Serving metrics on %s", *metricsAddr)
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				log.Printf("Error serving metrics: %s", err)
+			}
+		}()
+	}
+
+	if *advertise {
+		a, err := advertiseServer(*addr, *tlsCert != "")
+		if err != nil {
+			log.Fatalf("Error advertising the server: %s", err)
+		}
+		defer a.Close()
+	}
+
+	// The connections' deadlines are set by the pairpad server, for each message: timeouts
+	// set here would also apply to the WebSocket connections, however long they last.
+	httpServer := &http.Server{
+		Addr:              *addr,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		Handler:           s.Handler(),
+	}
+
+	// Shut down gracefully on SIGINT/SIGTERM.
+	shutdownDone := make(chan struct{})
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+		<-sigChan
+
+		log.Printf("Shutting down server")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP server: %s", err)
+		}
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down pairpad server: %s", err)
+		}
+		if err := conf.Tracer.Close(ctx); err != nil {
+			log.Printf("Error exporting the last traces: %s", err)
+		}
+		close(shutdownDone)
+	}()
+
+	// Start the server.
+	log.Printf("Starting server on %s", *addr)
+
+	var err error
+	if *tlsCert != "" {
+		err = httpServer.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal("Error starting server, exiting.", err)
+	}
+
+	<-shutdownDone
+}
+
+// advertiseServer advertises the server listening on addr on the local network, named
+// after the machine.
+func advertiseServer(addr string, secure bool) (*discovery.Advertiser, error) {
+	_, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portText)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return discovery.Advertise(discovery.Session{Instance: hostname, Port: port, Secure: secure})
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
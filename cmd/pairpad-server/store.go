@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/burntcarrot/pairpad/server/store"
+	_ "modernc.org/sqlite"
+)
+
+// openStore opens the document store described by spec, which is one of:
+//
+//	dir:PATH                     a directory on local disk
+//	sqlite:PATH                  an SQLite database file
+//	s3://BUCKET[/PREFIX][?endpoint=URL&region=REGION]
+//	                             an S3-compatible bucket, with the credentials in
+//	                             AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+//
+// The returned function releases the store's resources.
+func openStore(spec string) (store.Store, func() error, error) {
+	noop := func() error { return nil }
+
+	switch {
+	case strings.HasPrefix(spec, "dir:"):
+		return store.Dir{Path: strings.TrimPrefix(spec, "dir:")}, noop, nil
+
+	case strings.HasPrefix(spec, "sqlite:"):
+		db, err := sql.Open("sqlite", strings.TrimPrefix(spec, "sqlite:"))
+		if err != nil {
+			return nil, nil, err
+		}
+		s, err := store.NewSQLite(context.Background(), db)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return s, db.Close, nil
+
+	case strings.HasPrefix(spec, "s3://"):
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		region := u.Query().Get("region")
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if region == "" {
+			region = "us-east-1"
+		}
+		endpoint := u.Query().Get("endpoint")
+		if endpoint == "" {
+			endpoint = "https://s3." + region + ".amazonaws.com"
+		}
+
+		prefix := strings.TrimPrefix(u.Path, "/")
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+
+		return &store.S3{
+			Endpoint:        endpoint,
+			Region:          region,
+			Bucket:          u.Host,
+			Prefix:          prefix,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}, noop, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown store %q (expected dir:PATH, sqlite:PATH or s3://BUCKET)", spec)
+}
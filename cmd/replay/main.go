@@ -0,0 +1,208 @@
+// Command replay reconstructs and plays back sessions recorded by pairpad-server's -record flag.
+//
+// By default, replay prints a room's document as it was at the end of the recording:
+//
+//	replay session.log
+//
+// -at and -n print the document at an earlier point, and -play plays the session back in
+// an editor, starting from that point:
+//
+//	replay -room notes -at 5m session.log
+//	replay -play -speed 10 session.log
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/burntcarrot/pairpad/client/editor"
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/gdamore/tcell/v2"
+)
+
+// errQuit is returned by play when the user stops the playback.
+var errQuit = errors.New("playback stopped")
+
+func main() {
+	room := flag.String("room", "default", "Room to replay")
+	at := flag.Duration("at", -1, "Reconstruct the document this long after the first operation (default: the end of the recording)")
+	n := flag.Int("n", -1, "Reconstruct the document after the first n operations (default: all operations)")
+	play := flag.Bool("play", false, "Play the session back in an editor")
+	speed := flag.Float64("speed", 1, "Playback speed, relative to real time")
+	maxPause := flag.Duration("max-pause", 5*time.Second, "Longest pause between two operations during playback, in real time (0 means no limit)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] recording\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *speed <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	records, err := readRoom(flag.Arg(0), *room)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read recording: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Find the number of operations to apply before printing or playing.
+	start := len(records)
+	if *n >= 0 && *n < start {
+		start = *n
+	}
+	if *at >= 0 && len(records) > 0 {
+		until := records[0].Time.Add(*at)
+		for i, rec := range records[:start] {
+			if rec.Time.After(until) {
+				start = i
+				break
+			}
+		}
+	}
+
+	doc := crdt.New()
+	for _, rec := range records[:start] {
+		apply(&doc, rec.Operation)
+	}
+
+	if !*play {
+		fmt.Print(crdt.Content(doc))
+		return
+	}
+
+	err = playback(&doc, records, start, *speed, *maxPause)
+	if err != nil && !errors.Is(err, errQuit) {
+		fmt.Fprintf(os.Stderr, "playback failed: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// readRoom reads the records of a room from the recording at path.
+func readRoom(path, room string) ([]commons.Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	all, err := commons.ReadRecords(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []commons.Record
+	for _, rec := range all {
+		if rec.Room == room {
+			records = append(records, rec)
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no operations recorded in room %q", room)
+	}
+
+	return records, nil
+}
+
+// apply applies a recorded operation to doc, in the same way as a client receiving it
+// from the server. It returns the editor's cursor position after the operation.
+func apply(doc *crdt.Document, op commons.Operation) int {
+	// Operations which changed nothing leave the cursor where they were made.
+	position, _ := op.Apply(doc)
+	if position == 0 {
+		position = op.Position
+	}
+
+	switch op.Type {
+	case "insert":
+		return position - 1 + utf8.RuneCountInString(op.Value)
+	case "delete":
+		return position - 1
+	}
+
+	return 0
+}
+
+// playback shows doc in an editor, and applies records[start:] to it, waiting between
+// operations as long as the recorded session did, divided by speed. Pauses are capped at
+// maxPause, if it's positive. Pressing Esc, q or Ctrl+C stops the playback.
+func playback(doc *crdt.Document, records []commons.Record, start int, speed float64, maxPause time.Duration) error {
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return err
+	}
+	if err := screen.Init(); err != nil {
+		return err
+	}
+	defer screen.Fini()
+
+	e := editor.NewEditor(editor.EditorConfig{Screen: screen, ScrollEnabled: true})
+	e.SetSize(screen.Size())
+	e.SetText(crdt.Content(*doc))
+
+	quit := make(chan struct{})
+	go func() {
+		for {
+			switch ev := screen.PollEvent().(type) {
+			case nil:
+				return
+			case *tcell.EventResize:
+				e.SetSize(ev.Size())
+			case *tcell.EventKey:
+				if ev.Key() == tcell.KeyEsc || ev.Key() == tcell.KeyCtrlC || (ev.Key() == tcell.KeyRune && ev.Rune() == 'q') {
+					close(quit)
+					return
+				}
+			}
+		}
+	}()
+
+	status := func(i int) {
+		e.StatusMu.Lock()
+		e.ShowMsg = true
+		e.StatusMsg = fmt.Sprintf("Replaying %d/%d (x%g), press q to quit", i, len(records), speed)
+		if i > 0 {
+			rec := records[i-1]
+			e.StatusMsg += fmt.Sprintf(" | %s %s by %s", rec.Time.Local().Format("15:04:05"), rec.Operation.Type, rec.Username)
+		}
+		e.StatusMu.Unlock()
+		e.Draw()
+	}
+	status(start)
+
+	for i := start; i < len(records); i++ {
+		if i > 0 {
+			wait := time.Duration(float64(records[i].Time.Sub(records[i-1].Time)) / speed)
+			if maxPause > 0 && wait > maxPause {
+				wait = maxPause
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-quit:
+				return errQuit
+			}
+		}
+
+		cursor := apply(doc, records[i].Operation)
+		e.SetText(crdt.Content(*doc))
+		if length := len(e.GetText()); cursor > length {
+			cursor = length
+		}
+		if cursor < 0 {
+			cursor = 0
+		}
+		e.Cursor = cursor
+		status(i + 1)
+	}
+
+	// Keep the final document on screen until the user quits.
+	<-quit
+	return errQuit
+}
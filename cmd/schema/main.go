@@ -0,0 +1,17 @@
+// Command schema writes the JSON Schema of pairpad's wire protocol, generated from the
+// commons.Message type:
+//
+//	go run ./cmd/schema > protocol.schema.json
+package main
+
+import (
+	"os"
+
+	"github.com/burntcarrot/pairpad/commons"
+)
+
+func main() {
+	if _, err := os.Stdout.Write(commons.Schema()); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,204 @@
+package commons
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDiffEdits bounds the number of edits searched for between two sequences. Beyond it,
+// the differing parts are replaced as a whole, rather than spending quadratic time and
+// memory comparing them.
+var maxDiffEdits = 1000
+
+// An edit is a step of an edit script, turning one sequence into another.
+type edit byte
+
+const (
+	editEqual edit = iota
+	editDelete
+	editInsert
+)
+
+// Diff returns the operations turning old into new, to be applied in order. The lines of the
+// texts are compared first, and then the characters of the lines which changed, so the
+// characters which are in both texts are kept, along with the annotations and concurrent
+// edits anchored to them.
+func Diff(old, new string) []Operation {
+	a, b := splitLines(old), splitLines(new)
+
+	var ops []Operation
+	pos, i, j := 0, 0, 0
+	script := editScript(a, b)
+	for k := 0; k < len(script); {
+		if script[k] == editEqual {
+			pos += utf8.RuneCountInString(a[i])
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+
+		// Compare the characters of a run of changed lines.
+		var removed, added strings.Builder
+		for ; k < len(script) && script[k] != editEqual; k++ {
+			if script[k] == editDelete {
+				removed.WriteString(a[i])
+				i++
+			} else {
+				added.WriteString(b[j])
+				j++
+			}
+		}
+		ops, pos = diffRunes(ops, []rune(removed.String()), []rune(added.String()), pos)
+	}
+	return ops
+}
+
+// diffRunes appends the operations turning a into b to ops, given the number of characters
+// before them. It returns the operations, and the number of characters after b.
+func diffRunes(ops []Operation, a, b []rune, pos int) ([]Operation, int) {
+	j := 0
+	script := editScript(a, b)
+	for k := 0; k < len(script); {
+		switch script[k] {
+		case editEqual:
+			pos, j, k = pos+1, j+1, k+1
+		case editDelete:
+			ops = append(ops, Operation{Type: "delete", Position: pos + 1})
+			k++
+		case editInsert:
+			// Consecutive characters are inserted together.
+			start := j
+			for ; k < len(script) && script[k] == editInsert; k++ {
+				j++
+			}
+			ops = append(ops, Operation{Type: "insert", Position: pos + 1, Value: string(b[start:j])})
+			pos += j - start
+		}
+	}
+	return ops, pos
+}
+
+// splitLines splits text into lines, keeping their line endings.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// editScript returns a shortest edit script turning a into b, using Myers' algorithm
+// ("An O(ND) Difference Algorithm and Its Variations", 1986). The elements of a are
+// deleted before the elements of b are inserted in their place.
+func editScript[T comparable](a, b []T) []edit {
+	// The common prefix and suffix are usually most of the sequences.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	script := make([]edit, 0, len(a)+len(b)-prefix-suffix)
+	for i := 0; i < prefix; i++ {
+		script = append(script, editEqual)
+	}
+	script = append(script, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for i := 0; i < suffix; i++ {
+		script = append(script, editEqual)
+	}
+	return script
+}
+
+// myers returns a shortest edit script turning a into b, or, if it's longer than
+// maxDiffEdits, the script deleting all of a and inserting all of b.
+func myers[T comparable](a, b []T) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	// v[offset+k] is the furthest x reached on diagonal k (where k = x - y). trace[d] holds
+	// v's values for k in [-d-1, d+1] before step d, to find the path back.
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		if d > maxDiffEdits {
+			return replaceScript(n, m)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Insert an element of b.
+			} else {
+				x = v[offset+k-1] + 1 // Delete an element of a.
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, n, m)
+			}
+		}
+	}
+	return replaceScript(n, m)
+}
+
+// backtrack follows the path found by myers back from (n, m), and returns its edit script.
+func backtrack(trace [][]int, n, m int) []edit {
+	var script []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			script = append(script, editEqual)
+			x, y = x-1, y-1
+		}
+		if d > 0 {
+			if x == prevX {
+				script = append(script, editInsert)
+			} else {
+				script = append(script, editDelete)
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(script)-1; i < j; i, j = i+1, j-1 {
+		script[i], script[j] = script[j], script[i]
+	}
+	return script
+}
+
+// replaceScript returns the edit script deleting n elements and inserting m elements.
+func replaceScript(n, m int) []edit {
+	script := make([]edit, 0, n+m)
+	for i := 0; i < n; i++ {
+		script = append(script, editDelete)
+	}
+	for i := 0; i < m; i++ {
+		script = append(script, editInsert)
+	}
+	return script
+}
@@ -0,0 +1,75 @@
+package commons
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// apply applies operations to text, the way clients do.
+func apply(text string, ops []Operation) string {
+	runes := []rune(text)
+	for _, op := range ops {
+		i := op.Position - 1
+		if op.Type == "insert" {
+			runes = append(runes[:i], append([]rune(op.Value), runes[i:]...)...)
+		} else {
+			runes = append(runes[:i], runes[i+1:]...)
+		}
+	}
+	return string(runes)
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		description string
+		old, new    string
+		ops         []Operation // The expected operations, or nil to only check the result.
+	}{
+		{description: "equal", old: "abc", new: "abc", ops: []Operation{}},
+		{description: "insert", old: "ac", new: "abbc", ops: []Operation{{Type: "insert", Position: 2, Value: "bb"}}},
+		{description: "delete", old: "abbc", new: "ac", ops: []Operation{{Type: "delete", Position: 2}, {Type: "delete", Position: 2}}},
+		{description: "replace", old: "a-c", new: "a+c", ops: []Operation{{Type: "delete", Position: 2}, {Type: "insert", Position: 2, Value: "+"}}},
+		{description: "from empty", old: "", new: "ab\ncd\n"},
+		{description: "to empty", old: "ab\ncd", new: ""},
+		{description: "indent", old: "func f() {\nreturn 1\n}\n", new: "func f() {\n\treturn 1\n}\n", ops: []Operation{{Type: "insert", Position: 12, Value: "\t"}}},
+		{description: "lines", old: "a\nb\nc\nd\n", new: "a\nc\nb\nd\ne"},
+		{description: "unicode", old: "héllo wörld", new: "hello, wörld!"},
+		{description: "no newline", old: "a\nb", new: "a\nb\n"},
+	}
+
+	for _, tt := range tests {
+		ops := Diff(tt.old, tt.new)
+		if got := apply(tt.old, ops); got != tt.new {
+			t.Errorf("%s: got %q, expected %q", tt.description, got, tt.new)
+		}
+		if tt.ops != nil {
+			if diff := cmp.Diff(tt.ops, append([]Operation{}, ops...)); diff != "" {
+				t.Errorf("%s: unexpected operations (-expected +got):\n%s", tt.description, diff)
+			}
+		}
+	}
+}
+
+// TestDiffRandom checks Diff on random edits, including ones too long to be searched for.
+func TestDiffRandom(t *testing.T) {
+	defer func(max int) { maxDiffEdits = max }(maxDiffEdits)
+	maxDiffEdits = 20
+
+	r := rand.New(rand.NewSource(1))
+	text := func(n int) string {
+		runes := make([]rune, n)
+		for i := range runes {
+			runes[i] = []rune("ab\né")[r.Intn(4)]
+		}
+		return string(runes)
+	}
+
+	for i := 0; i < 500; i++ {
+		old, new := text(r.Intn(60)), text(r.Intn(60))
+		if got := apply(old, Diff(old, new)); got != new {
+			t.Fatalf("got %q, expected %q, from %q", got, new, old)
+		}
+	}
+}
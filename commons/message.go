@@ -1,6 +1,8 @@
 package commons
 
 import (
+	"fmt"
+
 	"github.com/burntcarrot/pairpad/crdt"
 	"github.com/google/uuid"
 )
@@ -9,7 +11,7 @@ import (
 type Message struct {
 	Username string `json:"username"`
 
-	// Text represents the body of the message. This is currently used for joining messages, the siteID, and the list of active users.
+	// Text represents the body of the message. This is currently used for joining messages, the siteID, the list of active users (as comma-separated names), errors, and notices.
 	Text string `json:"text"`
 
 	// Type represents the message type.
@@ -18,27 +20,259 @@ type Message struct {
 	// ID represents the client's UUID.
 	ID uuid.UUID `json:"ID"`
 
-	// Operation represents the CRDT operation.
+	// Operation represents the CRDT operation. For error messages, this is the operation that was rejected, if any.
 	Operation Operation `json:"operation"`
 
+	// Token is the token of the client's site ID, for siteID messages, or of its name, for joinAck messages. A client reconnecting to the server presents them, with its site ID and in its join message, to keep its site ID and name.
+	Token string `json:"token,omitempty"`
+
+	// Code tells why the server sent an error message, so clients can act on it. Text holds the error's description, for users.
+	Code ErrorCode `json:"code,omitempty"`
+
+	// Seq numbers the operations of clients which want them acknowledged. The server acks operations with a Seq once it has relayed them, and sets the Seq of the operation in the error sent when rejecting one.
+	Seq int `json:"seq,omitempty"`
+
+	// Users holds the active users, for users messages.
+	Users []User `json:"users,omitempty"`
+
 	// Document represents the client's document. This is not used frequently, and should be only used when necessary, due to the large size of documents.
 	Document crdt.Document `json:"document"`
+
+	// Annotation is the added or removed annotation, for annotation messages, or prompt
+	// block, for prompt messages.
+	Annotation *Annotation `json:"annotation,omitempty"`
+
+	// Selection is the sender's selected range, for selection messages.
+	Selection *Selection `json:"selection,omitempty"`
+
+	// Annotations holds the sender's annotations, for document syncs.
+	Annotations []Annotation `json:"annotations,omitempty"`
+
+	// Prompts holds the session's read-only prompt blocks, for document syncs.
+	Prompts []Annotation `json:"prompts,omitempty"`
+
+	// Checksum holds the checksums of Document, for document syncs. Receivers verify them, to detect documents which were corrupted on the way.
+	Checksum *Checksum `json:"checksum,omitempty"`
+}
+
+// An Annotation is a comment attached to a range of the document.
+//
+// The range is sent as the IDs of its first and last characters, which every client's
+// document shares, so receivers anchor the annotation to the same characters whatever the
+// edits made meanwhile. Its positions, valid when the message is sent, are sent too: older
+// clients only send those, and receivers missing the characters fall back to them.
+type Annotation struct {
+	// ID identifies the annotation.
+	ID string `json:"id"`
+
+	// Author is the name of the user who wrote the annotation.
+	Author string `json:"author"`
+
+	// Text is the annotation's comment.
+	Text string `json:"text"`
+
+	// Start and End are the positions of the first and last annotated characters,
+	// counted from 1 as with operations.
+	Start int `json:"start"`
+	End   int `json:"end"`
+
+	// StartID and EndID are the IDs of the first and last annotated characters. They're
+	// zero in annotations sent by older clients.
+	StartID crdt.CharacterID `json:"startID,omitempty"`
+	EndID   crdt.CharacterID `json:"endID,omitempty"`
+
+	// Deleted is set to remove the annotation.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// A Selection is the range of the document selected by a user, so the other users can see
+// it. Like annotation ranges, it's sent as positions, valid when the message is sent.
+type Selection struct {
+	// Start and End are the positions of the first and last selected characters, counted
+	// from 1 as with operations. Both are 0 if nothing is selected.
+	Start int `json:"start"`
+	End   int `json:"end"`
+
+	// Cursor is the position of the sender's cursor, counted from 1: 1 is before the first
+	// character. It's 0 if the sender doesn't send it, as older clients don't.
+	Cursor int `json:"cursor,omitempty"`
+}
+
+// Checksum holds the checksums of a document, computed with crdt.ContentChecksum and crdt.StateChecksum.
+type Checksum struct {
+	// Content is the checksum of the document's visible content.
+	Content string `json:"content"`
+
+	// State is the checksum of all of the document's characters.
+	State string `json:"state"`
+}
+
+// NewDocSyncMessage returns a document sync message for the client with the given ID, including the document's checksums.
+func NewDocSyncMessage(doc crdt.Document, id uuid.UUID) Message {
+	return Message{
+		Type:     DocSyncMessage,
+		Document: doc,
+		ID:       id,
+		Checksum: &Checksum{Content: crdt.ContentChecksum(doc), State: crdt.StateChecksum(doc)},
+	}
+}
+
+// Verify checks the document of a document sync message against its checksums. Messages without checksums (sent by older clients) aren't checked.
+func (m Message) Verify() error {
+	if m.Checksum == nil {
+		return nil
+	}
+
+	if sum := crdt.ContentChecksum(m.Document); sum != m.Checksum.Content {
+		return fmt.Errorf("content checksum mismatch: got %s, expected %s", sum, m.Checksum.Content)
+	}
+	if sum := crdt.StateChecksum(m.Document); sum != m.Checksum.State {
+		return fmt.Errorf("state checksum mismatch: got %s, expected %s", sum, m.Checksum.State)
+	}
+	return nil
+}
+
+// User describes a client connected to a room.
+type User struct {
+	// Name is the user's name.
+	Name string `json:"name"`
+
+	// SiteID is the user's site ID.
+	SiteID string `json:"siteID"`
+
+	// Color is the index of the user's color, assigned by the server when the user joins.
+	// It stays the same while the user is connected, and clients map it to colors of their
+	// own palette.
+	Color int `json:"color"`
+
+	// Hidden is set for interviewers, who are only listed to the other interviewers.
+	Hidden bool `json:"hidden,omitempty"`
+
+	// ReadOnly is set for users whose edit access has been removed by an interviewer.
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// Latency is the round-trip time between the server and the user's client, in
+	// milliseconds, as last measured. It's zero until it has been measured.
+	Latency int64 `json:"latency,omitempty"`
 }
 
 // MessageType represents the type of the message.
 type MessageType string
 
-// Currently, pairpad supports 5 message types:
+// Currently, pairpad supports 16 message types:
+// - operation (for inserts and deletes)
 // - docSync (for syncing documents)
-// - docReq (for requesting documents)
+// - docReq (for requesting documents, sent by the server when a client joins, or by a client to request the document again)
 // - SiteID (for generating site IDs)
 // - join (for joining messages)
+// - joinAck (for telling a client the name it was given, which may differ from the one it asked for)
 // - users (for the list of active users)
+// - error (for joins or operations rejected by the server, with a code telling why)
+// - leave (for clients leaving the session, with the reason in the text)
+// - annotation (for adding or removing annotations)
+// - notice (for announcements from the server, such as the end of the session approaching)
+// - prompt (for adding or removing read-only prompt blocks, sent by interviewers)
+// - access (for giving or removing edit access, sent by interviewers)
+// - ack (for acknowledging the operations with a seq, once the server has relayed them)
+// - selection (for showing the range of the document a user has selected)
+// - ping (for asking the other users for their attention, like raising a hand)
+
+const (
+	OperationMessage  MessageType = "operation"
+	DocSyncMessage    MessageType = "docSync"
+	DocReqMessage     MessageType = "docReq"
+	SiteIDMessage     MessageType = "SiteID"
+	JoinMessage       MessageType = "join"
+	JoinAckMessage    MessageType = "joinAck"
+	UsersMessage      MessageType = "users"
+	ErrorMessage      MessageType = "error"
+	LeaveMessage      MessageType = "leave"
+	AnnotationMessage MessageType = "annotation"
+	NoticeMessage     MessageType = "notice"
+	PromptMessage     MessageType = "prompt"
+	AccessMessage     MessageType = "access"
+	AckMessage        MessageType = "ack"
+	SelectionMessage  MessageType = "selection"
+	PingMessage       MessageType = "ping"
+)
 
+// MessageTypes lists all message types.
+var MessageTypes = []MessageType{
+	OperationMessage, DocSyncMessage, DocReqMessage, SiteIDMessage, JoinMessage,
+	JoinAckMessage, UsersMessage, ErrorMessage, LeaveMessage, AnnotationMessage,
+	NoticeMessage, PromptMessage, AccessMessage, AckMessage, SelectionMessage,
+	PingMessage,
+}
+
+// The reasons for which a client leaves a session, sent as the text of leave messages.
 const (
-	DocSyncMessage MessageType = "docSync"
-	DocReqMessage  MessageType = "docReq"
-	SiteIDMessage  MessageType = "SiteID"
-	JoinMessage    MessageType = "join"
-	UsersMessage   MessageType = "users"
+	// LeaveReasonLeft means the client closed its connection.
+	LeaveReasonLeft = "left"
+
+	// LeaveReasonConnectionLost means the connection to the client broke.
+	LeaveReasonConnectionLost = "connection lost"
+
+	// LeaveReasonKicked means the server disconnected the client.
+	LeaveReasonKicked = "kicked"
+)
+
+// ProtocolVersion is the version of the protocol spoken by this package. Clients send it
+// when connecting, and servers turn away clients with versions they don't know.
+const ProtocolVersion = 1
+
+// ProtocolHeader is the header of the WebSocket handshake's response in which servers send
+// the version of the protocol they speak, so clients can tell when they're talking to a
+// newer or older server. Servers which don't send it speak version 1.
+const ProtocolHeader = "Pairpad-Protocol"
+
+// An ErrorCode tells why the server sent an error message.
+type ErrorCode string
+
+// The codes of error messages. Clients should treat unknown codes as ErrorInternal.
+const (
+	// ErrorAuthFailed means the client's credentials, such as an interviewer token, were
+	// refused. The connection is closed, and retrying with the same credentials fails.
+	ErrorAuthFailed ErrorCode = "auth-failed"
+
+	// ErrorRoomFull means the room has as many clients as the server allows. The
+	// connection is closed; the client can try again later.
+	ErrorRoomFull ErrorCode = "room-full"
+
+	// ErrorSessionEnded means the session in the room has ended, and it can't be joined
+	// anymore. The connection is closed.
+	ErrorSessionEnded ErrorCode = "session-ended"
+
+	// ErrorRateLimited means the client went over the server's limits on connections or
+	// messages. The connection is closed; the client can try again later.
+	ErrorRateLimited ErrorCode = "rate-limited"
+
+	// ErrorInvalidOperation means a message from the client was rejected, and not
+	// relayed. The session goes on.
+	ErrorInvalidOperation ErrorCode = "invalid-operation"
+
+	// ErrorInvalidUsername means the username in the client's join message was refused.
+	// The connection is closed; the client can connect again with another name.
+	ErrorInvalidUsername ErrorCode = "invalid-username"
+
+	// ErrorVersionMismatch means the server doesn't speak the client's version of the
+	// protocol. The connection is closed.
+	ErrorVersionMismatch ErrorCode = "version-mismatch"
+
+	// ErrorInternal means the server failed to handle the client. The connection is closed.
+	ErrorInternal ErrorCode = "internal"
+)
+
+// Retryable reports whether a client turned away with the error code can try connecting
+// again later.
+func (c ErrorCode) Retryable() bool {
+	return c == ErrorRoomFull || c == ErrorRateLimited
+}
+
+// The levels of access given by access messages, sent as their text.
+const (
+	// AccessEdit lets users edit the document.
+	AccessEdit = "edit"
+
+	// AccessReadOnly stops users from editing the document.
+	AccessReadOnly = "read-only"
 )
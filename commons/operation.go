@@ -1,13 +1,7 @@
 package commons
 
-// Operation represents a CRDT operation.
-type Operation struct {
-	// Type represents the operation type, for example, insert, delete.
-	Type string `json:"type"`
+import "github.com/burntcarrot/pairpad/crdt"
 
-	// Position represents the position at which the operation has been made.
-	Position int `json:"position"`
-
-	// Value represents the content of the operation. Mostly a character.
-	Value string `json:"value"`
-}
+// Operation represents a CRDT operation. It's defined by the crdt package, so documents can
+// apply the operations received from other sites (see crdt.CRDT's ApplyRemote).
+type Operation = crdt.Operation
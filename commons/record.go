@@ -0,0 +1,49 @@
+package commons
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A Record is an entry in a session recording. The server writes one JSON-encoded Record
+// per line for every operation it relays, and cmd/replay reads them back.
+type Record struct {
+	// Time is the time at which the server relayed the operation.
+	Time time.Time `json:"time"`
+
+	// Room is the room in which the operation was made.
+	Room string `json:"room"`
+
+	// SiteID is the site ID of the client which made the operation.
+	SiteID string `json:"siteID"`
+
+	// Username is the name of the client which made the operation.
+	Username string `json:"username"`
+
+	// Operation is the recorded operation.
+	Operation Operation `json:"operation"`
+}
+
+// ReadRecords reads a session recording, in the order in which it was written.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+
+	return records, scanner.Err()
+}
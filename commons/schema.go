@@ -0,0 +1,166 @@
+package commons
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/google/uuid"
+)
+
+// typeSchemas holds the constraints which apply to each message type. Fields which aren't
+// required may be left out, and are then decoded as their zero value. Since Message's
+// fields are shared by all types, the zero values of unused fields (such as the operation
+// of a join message) are valid.
+var typeSchemas = map[MessageType]schema{
+	OperationMessage: {
+		"required": []string{"operation"},
+		"properties": schema{"operation": schema{
+			"required": []string{"type", "position"},
+			"properties": schema{
+				"type":     schema{"enum": []string{"insert", "delete"}},
+				"position": schema{"minimum": 1},
+			},
+		}},
+	},
+	DocSyncMessage:    {"required": []string{"document"}},
+	SiteIDMessage:     {"required": []string{"text"}},
+	JoinMessage:       {"required": []string{"username"}},
+	JoinAckMessage:    {"required": []string{"username"}},
+	UsersMessage:      {"required": []string{"users"}},
+	AnnotationMessage: {"required": []string{"annotation"}},
+	NoticeMessage:     {"required": []string{"text"}},
+	ErrorMessage:      {"required": []string{"text"}},
+	AckMessage: {
+		"required":   []string{"seq"},
+		"properties": schema{"seq": schema{"minimum": 1}},
+	},
+	PromptMessage:    {"required": []string{"annotation"}},
+	SelectionMessage: {"required": []string{"selection"}},
+	PingMessage:      {"required": []string{"username"}},
+	AccessMessage: {
+		"required":   []string{"text"},
+		"properties": schema{"text": schema{"enum": []string{AccessEdit, AccessReadOnly}}},
+	},
+}
+
+// fieldSchemas overrides the schemas generated for some fields, keyed by the Go type name
+// and the JSON field name.
+var fieldSchemas = map[string]schema{
+	"User.color":       {"type": "integer", "minimum": 0},
+	"Message.seq":      {"type": "integer", "minimum": 0},
+	"Selection.start":  {"type": "integer", "minimum": 0},
+	"Selection.end":    {"type": "integer", "minimum": 0},
+	"Selection.cursor": {"type": "integer", "minimum": 0},
+}
+
+// A schema is a JSON Schema object.
+type schema map[string]interface{}
+
+// Schema returns a JSON Schema (draft 2020-12) describing messages, generated from the
+// Message type. It's published as protocol.schema.json, which is written by cmd/schema.
+//
+// The schema describes the structure of messages; Message.Validate performs the same
+// checks, and a few the schema can't express, such as unique character IDs.
+func Schema() []byte {
+	g := schemaGenerator{defs: make(map[string]schema)}
+	root := g.generate(reflect.TypeOf(Message{}))
+
+	var conditions []schema
+	for _, t := range MessageTypes {
+		if then, ok := typeSchemas[t]; ok {
+			conditions = append(conditions, schema{
+				"if":   schema{"properties": schema{"type": schema{"const": t}}},
+				"then": then,
+			})
+		}
+	}
+
+	s := schema{
+		"$schema":  "https://json-schema.org/draft/2020-12/schema",
+		"$id":      "https://github.com/burntcarrot/pairpad/protocol.schema.json",
+		"title":    "pairpad message",
+		"$ref":     root["$ref"],
+		"required": []string{"type"},
+		"allOf":    conditions,
+		"$defs":    g.defs,
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return append(data, '\n')
+}
+
+// A schemaGenerator generates schemas for Go types, collecting the schemas of structs in defs.
+type schemaGenerator struct {
+	defs map[string]schema
+}
+
+var (
+	uuidType        = reflect.TypeOf(uuid.UUID{})
+	messageTypeType = reflect.TypeOf(MessageType(""))
+	characterIDType = reflect.TypeOf(crdt.CharacterID{})
+)
+
+// generate returns the schema of values of type t, as encoded by encoding/json.
+func (g *schemaGenerator) generate(t reflect.Type) schema {
+	switch t {
+	case uuidType:
+		return schema{"type": "string", "format": "uuid"}
+	case messageTypeType:
+		return schema{"type": "string", "enum": MessageTypes}
+	case characterIDType:
+		// Character IDs are encoded as text; see crdt.CharacterID.
+		return schema{"type": "string", "pattern": `^(start|end|[0-9]+(\.[0-9]+)?)?$`}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.generate(t.Elem())
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return schema{"type": "integer"}
+	case reflect.Slice:
+		// Nil slices are encoded as null.
+		return schema{"type": []string{"array", "null"}, "items": g.generate(t.Elem())}
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = g.generateStruct(t)
+		}
+		return schema{"$ref": "#/$defs/" + t.Name()}
+	}
+
+	panic(fmt.Sprintf("commons: no schema for type %s", t))
+}
+
+// generateStruct returns the schema of a struct type's exported fields.
+func (g *schemaGenerator) generateStruct(t reflect.Type) schema {
+	properties := make(schema)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+
+		if s, ok := fieldSchemas[t.Name()+"."+name]; ok {
+			properties[name] = s
+		} else {
+			properties[name] = g.generate(f.Type)
+		}
+	}
+	return schema{"type": "object", "properties": properties}
+}
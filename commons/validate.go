@@ -0,0 +1,229 @@
+package commons
+
+import (
+	"fmt"
+
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// A ValidationError describes why a message is malformed.
+type ValidationError struct {
+	// Field is the JSON path of the invalid field, e.g. "operation.position".
+	Field string `json:"field"`
+
+	// Reason describes what's wrong with the field.
+	Reason string `json:"reason"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid message: %s: %s", e.Field, e.Reason)
+}
+
+// invalid returns a ValidationError for field.
+func invalid(field, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Validate checks that a message is well-formed: that its type is known, and that the
+// fields used by its type are valid. It returns a *ValidationError describing the first
+// problem found. Validate doesn't check limits, such as the length of usernames, which
+// are up to the server.
+func (m Message) Validate() error {
+	if !m.Type.valid() {
+		return invalid("type", "unknown message type %q", m.Type)
+	}
+
+	switch m.Type {
+	case OperationMessage:
+		if m.Seq < 0 {
+			return invalid("seq", "negative seq %d", m.Seq)
+		}
+		return validateOperation(m.Operation)
+
+	case JoinMessage, JoinAckMessage, PingMessage:
+		if m.Username == "" {
+			return invalid("username", "missing username")
+		}
+
+	case SiteIDMessage:
+		if m.Text == "" {
+			return invalid("text", "missing site ID")
+		}
+
+	case NoticeMessage:
+		if m.Text == "" {
+			return invalid("text", "missing notice")
+		}
+
+	case ErrorMessage:
+		if m.Text == "" {
+			return invalid("text", "missing error")
+		}
+
+	case AckMessage:
+		if m.Seq < 1 {
+			return invalid("seq", "missing seq")
+		}
+
+	case UsersMessage:
+		for i, u := range m.Users {
+			if u.Color < 0 {
+				return invalid(fmt.Sprintf("users[%d].color", i), "negative color %d", u.Color)
+			}
+		}
+
+	case AnnotationMessage, PromptMessage:
+		if m.Annotation == nil {
+			return invalid("annotation", "missing annotation")
+		}
+		return validateAnnotation("annotation", *m.Annotation)
+
+	case SelectionMessage:
+		if m.Selection == nil {
+			return invalid("selection", "missing selection")
+		}
+		return validateSelection(*m.Selection)
+
+	case AccessMessage:
+		if m.Text != AccessEdit && m.Text != AccessReadOnly {
+			return invalid("text", "unknown access %q", m.Text)
+		}
+
+	case DocSyncMessage:
+		if err := validateDocument(m.Document); err != nil {
+			return err
+		}
+		for i, a := range m.Annotations {
+			if err := validateAnnotation(fmt.Sprintf("annotations[%d]", i), a); err != nil {
+				return err
+			}
+		}
+		for i, a := range m.Prompts {
+			if err := validateAnnotation(fmt.Sprintf("prompts[%d]", i), a); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// valid reports whether t is one of the known message types.
+func (t MessageType) valid() bool {
+	for _, known := range MessageTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// validateOperation checks an insert or delete operation.
+func validateOperation(op Operation) error {
+	switch op.Type {
+	case "insert":
+		if op.Value == "" {
+			return invalid("operation.value", "missing value")
+		}
+	case "delete":
+	default:
+		return invalid("operation.type", "unknown operation type %q", op.Type)
+	}
+
+	if op.Position < 1 {
+		return invalid("operation.position", "position %d is less than 1", op.Position)
+	}
+
+	if op.Insert != nil {
+		if op.Type != "insert" || op.Delete != nil {
+			return invalid("operation.insert", "insert characters in a %s operation", op.Type)
+		}
+		runes := []rune(op.Value)
+		if len(op.Insert.Characters) != len(runes) {
+			return invalid("operation.insert.characters", "%d characters inserted for %d runes", len(op.Insert.Characters), len(runes))
+		}
+		for i, c := range op.Insert.Characters {
+			if err := validateCharacter(fmt.Sprintf("operation.insert.characters[%d]", i), c); err != nil {
+				return err
+			}
+			if c.Value != string(runes[i]) || !c.Visible {
+				return invalid(fmt.Sprintf("operation.insert.characters[%d]", i), "character isn't the visible rune %q of the value", runes[i])
+			}
+		}
+	}
+	if op.Delete != nil {
+		if op.Type != "delete" {
+			return invalid("operation.delete", "deleted character in a %s operation", op.Type)
+		}
+		if err := validateCharacter("operation.delete.character", op.Delete.Character); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCharacter checks a character inserted or deleted by an operation, found at field,
+// which can't be the start or end character, and must have the neighbours it was inserted
+// between.
+func validateCharacter(field string, c crdt.Character) error {
+	switch {
+	case c.ID.IsZero() || c.ID == crdt.IDStart || c.ID == crdt.IDEnd:
+		return invalid(field+".ID", "invalid character ID %q", c.ID)
+	case c.IDPrevious.IsZero() || c.IDNext.IsZero():
+		return invalid(field, "missing neighbour IDs")
+	}
+	return nil
+}
+
+// validateAnnotation checks an annotation found at field.
+func validateAnnotation(field string, a Annotation) error {
+	switch {
+	case a.ID == "":
+		return invalid(field+".id", "missing ID")
+	case a.Deleted:
+		return nil
+	case a.Start < 1 || a.End < a.Start:
+		return invalid(field, "invalid range %d-%d", a.Start, a.End)
+	}
+	return nil
+}
+
+// validateSelection checks a selection's range, which is empty if nothing is selected, and
+// its cursor.
+func validateSelection(s Selection) error {
+	if s.Cursor < 0 {
+		return invalid("selection", "invalid cursor %d", s.Cursor)
+	}
+	if (s.Start == 0 && s.End == 0) || (s.Start >= 1 && s.End >= s.Start) {
+		return nil
+	}
+	return invalid("selection", "invalid range %d-%d", s.Start, s.End)
+}
+
+// validateDocument checks that a document's characters have unique IDs, and that it
+// starts with crdt.CharacterStart and ends with crdt.CharacterEnd.
+func validateDocument(doc crdt.Document) error {
+	chars := doc.Characters
+	if len(chars) < 2 {
+		return invalid("document.Characters", "missing start and end characters")
+	}
+	if chars[0].ID != crdt.CharacterStart.ID {
+		return invalid("document.Characters[0].ID", "expected %q, got %q", crdt.CharacterStart.ID, chars[0].ID)
+	}
+	if last := len(chars) - 1; chars[last].ID != crdt.CharacterEnd.ID {
+		return invalid(fmt.Sprintf("document.Characters[%d].ID", last), "expected %q, got %q", crdt.CharacterEnd.ID, chars[last].ID)
+	}
+
+	seen := make(map[crdt.CharacterID]bool, len(chars))
+	for i, c := range chars {
+		field := fmt.Sprintf("document.Characters[%d]", i)
+		switch {
+		case c.ID.IsZero():
+			return invalid(field+".ID", "missing ID")
+		case seen[c.ID]:
+			return invalid(field+".ID", "duplicate ID %q", c.ID)
+		}
+		seen[c.ID] = true
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package commons
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/google/uuid"
+)
+
+func TestValidate(t *testing.T) {
+	doc, _ := crdt.FromText("ab")
+	dupDoc := crdt.New()
+	dupDoc.Characters = append(dupDoc.Characters[:1], crdt.Character{ID: crdt.CharacterID{SiteID: 1, Clock: 1}}, crdt.Character{ID: crdt.CharacterID{SiteID: 1, Clock: 1}}, crdt.CharacterEnd)
+
+	// char returns a character generated by site 1, as operations carry them.
+	char := func(clock int, value string) crdt.Character {
+		return crdt.Character{ID: crdt.CharacterID{SiteID: 1, Clock: clock}, Visible: true, Value: value, IDPrevious: crdt.IDStart, IDNext: crdt.IDEnd}
+	}
+
+	tests := []struct {
+		description string
+		msg         Message
+		field       string // The invalid field, or "" if the message is valid.
+	}{
+		{description: "insert", msg: Message{Type: OperationMessage, Operation: Operation{Type: "insert", Position: 1, Value: "a"}}},
+		{description: "delete", msg: Message{Type: OperationMessage, Operation: Operation{Type: "delete", Position: 3}}},
+		{description: "numbered operation", msg: Message{Type: OperationMessage, Operation: Operation{Type: "delete", Position: 3}, Seq: 7}},
+		{description: "negative seq", msg: Message{Type: OperationMessage, Operation: Operation{Type: "delete", Position: 3}, Seq: -1}, field: "seq"},
+		{description: "ack", msg: Message{Type: AckMessage, Seq: 7}},
+		{description: "ack without seq", msg: Message{Type: AckMessage}, field: "seq"},
+		{description: "join", msg: Message{Type: JoinMessage, Username: "alice"}},
+		{description: "document sync", msg: NewDocSyncMessage(doc, uuid.Nil)},
+		{description: "unknown type", msg: Message{Type: "frobnicate"}, field: "type"},
+		{description: "missing type", msg: Message{}, field: "type"},
+		{description: "unknown operation", msg: Message{Type: OperationMessage, Operation: Operation{Type: "move", Position: 1}}, field: "operation.type"},
+		{description: "position 0", msg: Message{Type: OperationMessage, Operation: Operation{Type: "delete"}}, field: "operation.position"},
+		{description: "empty insert", msg: Message{Type: OperationMessage, Operation: Operation{Type: "insert", Position: 1}}, field: "operation.value"},
+		{description: "insert characters", msg: Message{Type: OperationMessage, Operation: Operation{Type: "insert", Position: 1, Value: "ab", Insert: &crdt.InsertOp{Characters: []crdt.Character{char(1, "a"), char(2, "b")}}}}},
+		{description: "missing insert characters", msg: Message{Type: OperationMessage, Operation: Operation{Type: "insert", Position: 1, Value: "ab", Insert: &crdt.InsertOp{Characters: []crdt.Character{char(1, "a")}}}}, field: "operation.insert.characters"},
+		{description: "wrong insert character", msg: Message{Type: OperationMessage, Operation: Operation{Type: "insert", Position: 1, Value: "a", Insert: &crdt.InsertOp{Characters: []crdt.Character{char(1, "b")}}}}, field: "operation.insert.characters[0]"},
+		{description: "insert character without ID", msg: Message{Type: OperationMessage, Operation: Operation{Type: "insert", Position: 1, Value: "a", Insert: &crdt.InsertOp{Characters: []crdt.Character{{Visible: true, Value: "a", IDPrevious: crdt.IDStart, IDNext: crdt.IDEnd}}}}}, field: "operation.insert.characters[0].ID"},
+		{description: "deleted character", msg: Message{Type: OperationMessage, Operation: Operation{Type: "delete", Position: 1, Delete: &crdt.DeleteOp{Character: char(1, "a")}}}},
+		{description: "deleted start character", msg: Message{Type: OperationMessage, Operation: Operation{Type: "delete", Position: 1, Delete: &crdt.DeleteOp{Character: crdt.CharacterStart}}}, field: "operation.delete.character.ID"},
+		{description: "insert with deleted character", msg: Message{Type: OperationMessage, Operation: Operation{Type: "insert", Position: 1, Value: "a", Delete: &crdt.DeleteOp{Character: char(1, "a")}}}, field: "operation.delete"},
+		{description: "join without name", msg: Message{Type: JoinMessage}, field: "username"},
+		{description: "ping", msg: Message{Type: PingMessage, Username: "alice"}},
+		{description: "ping without name", msg: Message{Type: PingMessage}, field: "username"},
+		{description: "empty notice", msg: Message{Type: NoticeMessage}, field: "text"},
+		{description: "error", msg: Message{Type: ErrorMessage, Code: ErrorRoomFull, Text: "room is full"}},
+		{description: "empty error", msg: Message{Type: ErrorMessage, Code: ErrorInternal}, field: "text"},
+		{description: "prompt", msg: Message{Type: PromptMessage, Annotation: &Annotation{ID: "p", Start: 1, End: 4}}},
+		{description: "missing prompt", msg: Message{Type: PromptMessage}, field: "annotation"},
+		{description: "read-only access", msg: Message{Type: AccessMessage, Text: AccessReadOnly}},
+		{description: "unknown access", msg: Message{Type: AccessMessage, Text: "admin"}, field: "text"},
+		{description: "missing annotation", msg: Message{Type: AnnotationMessage}, field: "annotation"},
+		{description: "annotation without ID", msg: Message{Type: AnnotationMessage, Annotation: &Annotation{Start: 1, End: 1}}, field: "annotation.id"},
+		{description: "deleted annotation", msg: Message{Type: AnnotationMessage, Annotation: &Annotation{ID: "a", Deleted: true}}},
+		{description: "invalid range", msg: Message{Type: AnnotationMessage, Annotation: &Annotation{ID: "a", Start: 3, End: 2}}, field: "annotation"},
+		{description: "selection", msg: Message{Type: SelectionMessage, Selection: &Selection{Start: 2, End: 5}}},
+		{description: "cleared selection", msg: Message{Type: SelectionMessage, Selection: &Selection{}}},
+		{description: "missing selection", msg: Message{Type: SelectionMessage}, field: "selection"},
+		{description: "invalid selection", msg: Message{Type: SelectionMessage, Selection: &Selection{Start: 0, End: 3}}, field: "selection"},
+		{description: "cursor", msg: Message{Type: SelectionMessage, Selection: &Selection{Cursor: 4}}},
+		{description: "invalid cursor", msg: Message{Type: SelectionMessage, Selection: &Selection{Cursor: -1}}, field: "selection"},
+		{description: "empty document", msg: Message{Type: DocSyncMessage}, field: "document.Characters"},
+		{description: "duplicate IDs", msg: Message{Type: DocSyncMessage, Document: dupDoc}, field: "document.Characters[2].ID"},
+		{description: "invalid sync annotation", msg: Message{Type: DocSyncMessage, Document: doc, Annotations: []Annotation{{ID: "a"}}}, field: "annotations[0]"},
+		{description: "invalid sync prompt", msg: Message{Type: DocSyncMessage, Document: doc, Prompts: []Annotation{{Start: 1, End: 1}}}, field: "prompts[0].id"},
+	}
+
+	for _, tc := range tests {
+		err := tc.msg.Validate()
+		if tc.field == "" {
+			if err != nil {
+				t.Errorf("(%s) got error %v, expected nil\n", tc.description, err)
+			}
+			continue
+		}
+
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("(%s) got error %v, expected a *ValidationError\n", tc.description, err)
+			continue
+		}
+		if verr.Field != tc.field {
+			t.Errorf("(%s) got field %q, expected %q\n", tc.description, verr.Field, tc.field)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	got := Schema()
+
+	var s map[string]interface{}
+	if err := json.Unmarshal(got, &s); err != nil {
+		t.Fatalf("schema isn't valid JSON: %v", err)
+	}
+
+	expected, err := os.ReadFile("../protocol.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("protocol.schema.json is out of date; run: go run ./cmd/schema > protocol.schema.json")
+	}
+}
+
+// FuzzValidate checks that decoding and validating arbitrary messages doesn't panic, and
+// that valid messages stay valid once encoded and decoded again.
+func FuzzValidate(f *testing.F) {
+	doc, _ := crdt.FromText("ab")
+	for _, msg := range []Message{
+		{Type: OperationMessage, Operation: Operation{Type: "insert", Position: 1, Value: "a"}},
+		{Type: JoinMessage, Username: "alice"},
+		{Type: AnnotationMessage, Annotation: &Annotation{ID: "a", Start: 1, End: 2, Text: "hi"}},
+		NewDocSyncMessage(doc, uuid.Nil),
+	} {
+		data, _ := json.Marshal(msg)
+		f.Add(data)
+	}
+	f.Add([]byte(`{"type":"docSync","document":{"Characters":[{"ID":"start"}]}}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return
+		}
+		if err := msg.Validate(); err != nil {
+			return
+		}
+		_ = msg.Verify()
+
+		encoded, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatalf("failed to encode a valid message: %v", err)
+		}
+		var decoded Message
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("failed to decode a valid message: %v", err)
+		}
+		if err := decoded.Validate(); err != nil {
+			t.Errorf("valid message %s is invalid once encoded again: %v", data, err)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package crdt
+
+import "errors"
+
+// ErrEmptyAnchor is returned by NewAnchor if the range doesn't contain any characters.
+var ErrEmptyAnchor = errors.New("anchor range is empty")
+
+// An Anchor identifies a range of characters by their IDs, so that it follows the
+// characters as the document is edited around and inside the range.
+type Anchor struct {
+	// StartID is the ID of the first character in the range.
+	StartID CharacterID
+
+	// EndID is the ID of the last character in the range.
+	EndID CharacterID
+}
+
+// NewAnchor returns an anchor for the visible characters from position start to position
+// end, inclusive. Positions are counted from 1, as with Insert and Delete.
+func (doc *Document) NewAnchor(start, end int) (Anchor, error) {
+	if start > end {
+		return Anchor{}, ErrEmptyAnchor
+	}
+
+	startChar, endChar := IthVisible(*doc, start), IthVisible(*doc, end)
+	if startChar.ID.IsZero() || endChar.ID.IsZero() {
+		return Anchor{}, ErrPositionOutOfBounds
+	}
+
+	return Anchor{StartID: startChar.ID, EndID: endChar.ID}, nil
+}
+
+// Range returns the positions of the first and last visible characters covered by the
+// anchor, counted from 1. Characters inserted inside the range are covered, and deleted
+// characters aren't. ok is false if none of the characters are visible anymore, or if
+// the anchor's characters aren't in the document.
+func (doc *Document) Range(a Anchor) (start, end int, ok bool) {
+	visible := 0
+	inside := false
+
+	for _, char := range doc.Characters {
+		if char.ID == a.StartID {
+			inside = true
+		}
+		if char.Visible {
+			visible++
+			if inside {
+				if start == 0 {
+					start = visible
+				}
+				end = visible
+			}
+		}
+		if char.ID == a.EndID {
+			return start, end, inside && start > 0
+		}
+	}
+
+	return 0, 0, false
+}
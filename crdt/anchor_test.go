@@ -0,0 +1,49 @@
+package crdt
+
+import "testing"
+
+func TestAnchor(t *testing.T) {
+	doc := New()
+	for i, ch := range "hello world" {
+		if _, err := doc.Insert(i+1, string(ch)); err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+	}
+
+	// Anchor "world".
+	a, err := doc.NewAnchor(7, 11)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	tests := []struct {
+		description string
+		edit        func()
+		start, end  int
+		ok          bool
+	}{
+		{description: "unchanged", edit: func() {}, start: 7, end: 11, ok: true},
+		{description: "insert before", edit: func() { _, _ = doc.Insert(1, ">") }, start: 8, end: 12, ok: true},
+		{description: "insert inside", edit: func() { _, _ = doc.Insert(10, "-") }, start: 8, end: 13, ok: true},
+		{description: "delete first character", edit: func() { _ = doc.Delete(8) }, start: 8, end: 12, ok: true},
+		{description: "insert after", edit: func() { _, _ = doc.Insert(13, "!") }, start: 8, end: 12, ok: true},
+		{description: "delete everything", edit: func() {
+			for i := 0; i < 5; i++ {
+				_ = doc.Delete(8)
+			}
+		}, ok: false},
+	}
+
+	for _, tc := range tests {
+		tc.edit()
+
+		start, end, ok := doc.Range(a)
+		if ok != tc.ok || (ok && (start != tc.start || end != tc.end)) {
+			t.Errorf("(%s) got (%d, %d, %v), expected (%d, %d, %v)\n", tc.description, start, end, ok, tc.start, tc.end, tc.ok)
+		}
+	}
+
+	if _, err := doc.NewAnchor(3, 2); err != ErrEmptyAnchor {
+		t.Errorf("got error %v, expected %v\n", err, ErrEmptyAnchor)
+	}
+}
@@ -0,0 +1,34 @@
+package crdt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+)
+
+// ContentChecksum returns a checksum of the document's visible content.
+func ContentChecksum(doc Document) string {
+	sum := sha256.Sum256([]byte(Content(doc)))
+	return hex.EncodeToString(sum[:])
+}
+
+// StateChecksum returns a checksum of all of the document's characters, including deleted
+// characters and character IDs. Documents with the same state checksum are identical.
+func StateChecksum(doc Document) string {
+	h := sha256.New()
+	for _, char := range doc.Characters {
+		writeField(h, char.ID.String())
+		writeField(h, fmt.Sprint(char.Visible))
+		writeField(h, char.Value)
+		writeField(h, char.IDPrevious.String())
+		writeField(h, char.IDNext.String())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeField writes a length-prefixed field to h, so that different characters can't
+// produce the same input.
+func writeField(h hash.Hash, field string) {
+	fmt.Fprintf(h, "%d:%s", len(field), field)
+}
@@ -0,0 +1,35 @@
+package crdt
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	doc := New()
+	if _, err := doc.Insert(1, "a"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if _, err := doc.Insert(2, "b"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	// Copy the document, and delete a character from the copy.
+	other := Document{Characters: append([]Character(nil), doc.Characters...)}
+	if ContentChecksum(other) != ContentChecksum(doc) || StateChecksum(other) != StateChecksum(doc) {
+		t.Errorf("checksums of identical documents differ\n")
+	}
+
+	other.Delete(2)
+	if ContentChecksum(other) == ContentChecksum(doc) {
+		t.Errorf("content checksums of different documents are equal\n")
+	}
+
+	// Documents with the same content can have different states.
+	if _, err := other.Insert(2, "b"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if ContentChecksum(other) != ContentChecksum(doc) {
+		t.Errorf("content checksums of documents with the same content differ\n")
+	}
+	if StateChecksum(other) == StateChecksum(doc) {
+		t.Errorf("state checksums of different documents are equal\n")
+	}
+}
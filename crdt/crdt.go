@@ -1,12 +1,50 @@
 package crdt
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
+// ErrUnknownOperation is returned by ApplyRemote for operations which aren't inserts or
+// deletes.
+var ErrUnknownOperation = errors.New("unknown operation type")
+
+// ErrIncompatibleMerge is returned by Merge if the other document isn't of the same
+// implementation, or its characters can't be integrated.
+var ErrIncompatibleMerge = errors.New("documents can't be merged")
+
+// CRDT is a replicated text document. Positions are counted from 1, in runes, and values
+// are inserted one character per rune. Document implements it with WOOT; other
+// algorithms, like Logoot or RGA, can implement it too.
 type CRDT interface {
+	// Insert inserts value at position, and returns the document's new content.
 	Insert(position int, value string) (string, error)
+
+	// Delete deletes the character at position, and returns the document's new content.
 	Delete(position int) string
+
+	// InsertRange inserts the runes of value, one character each, with the first at
+	// position, and returns the document's new content. If a rune can't be inserted, the
+	// runes after it aren't either.
+	InsertRange(position int, value string) (string, error)
+
+	// DeleteRange deletes n characters from position, and returns the document's new
+	// content. Characters past the end of the document are ignored.
+	DeleteRange(position, n int) string
+
+	// ApplyRemote applies an operation received from another site.
+	ApplyRemote(op Operation) error
+
+	// Snapshot returns a copy of the document, which doesn't change with it.
+	Snapshot() CRDT
+
+	// Merge integrates the characters inserted and deleted in other, a replica of the same
+	// document, which the document doesn't have yet.
+	Merge(other CRDT) error
 }
 
+var _ CRDT = (*Document)(nil)
+
 func IsCRDT(c CRDT) {
 	// temporary code to check if the CRDT works.
 	fmt.Println(c.Insert(1, "a"))
@@ -0,0 +1,142 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// FormatVersion is the version of the file format written by SaveDocument.
+const FormatVersion = 1
+
+// FormatType identifies the CRDT used by the documents in a file.
+const FormatType = "woot"
+
+// File is the envelope in which a document's CRDT state is persisted. Unlike Save, which
+// only writes the document's content, a File keeps every character (including deleted
+// ones) along with its ID, so editing can continue from the saved state.
+//
+// The envelope records the format version, so files written by older versions of pairpad
+// can be migrated when they're loaded.
+type File struct {
+	// Version is the file's format version.
+	Version int `json:"version"`
+
+	// Type is the CRDT used by the document.
+	Type string `json:"type"`
+
+	// SiteID is the site ID of the client which saved the document.
+	SiteID int `json:"siteID"`
+
+	// Clock is the local clock of the client which saved the document, at the time of the
+	// save. Clients loading the document continue from it (see AdvanceClock), so they
+	// don't generate the IDs of the saved characters again with the same site ID. It's
+	// zero in files written by older versions of pairpad.
+	Clock int `json:"clock,omitempty"`
+
+	// SavedAt is the time at which the document was saved.
+	SavedAt time.Time `json:"savedAt"`
+
+	// Document is the saved document.
+	Document Document `json:"document"`
+}
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported file format version")
+	ErrUnsupportedType    = errors.New("unsupported CRDT type")
+)
+
+// migrations upgrade a file's JSON from the version used as the key to the next version.
+// When the format changes, bump FormatVersion and add a migration from the previous version.
+var migrations = map[int]func(data []byte) ([]byte, error){
+	0: migrateV0,
+}
+
+// migrateV0 migrates version 0 files, which contained the document's JSON without an
+// envelope, to version 1.
+func migrateV0(data []byte) ([]byte, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(File{Version: 1, Type: FormatType, Document: doc})
+}
+
+// EncodeFile returns the JSON encoding of doc in the current file format.
+func EncodeFile(doc *Document) ([]byte, error) {
+	mu.Lock()
+	siteID, clock := SiteID, LocalClock
+	mu.Unlock()
+
+	return json.Marshal(File{
+		Version:  FormatVersion,
+		Type:     FormatType,
+		SiteID:   siteID,
+		Clock:    clock,
+		SavedAt:  time.Now().UTC(),
+		Document: *doc,
+	})
+}
+
+// DecodeFile decodes a file's JSON, migrating it to the current file format if it was
+// written by an older version of pairpad.
+func DecodeFile(data []byte) (File, error) {
+	var header struct {
+		Version int    `json:"version"`
+		Type    string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return File{}, err
+	}
+
+	if header.Version > FormatVersion {
+		return File{}, fmt.Errorf("%w: %d (newest supported version is %d)", ErrUnsupportedVersion, header.Version, FormatVersion)
+	}
+
+	for version := header.Version; version < FormatVersion; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return File{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+		}
+
+		var err error
+		if data, err = migrate(data); err != nil {
+			return File{}, fmt.Errorf("failed to migrate file from version %d: %w", version, err)
+		}
+	}
+
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return File{}, err
+	}
+
+	if f.Type != FormatType {
+		return File{}, fmt.Errorf("%w: %q", ErrUnsupportedType, f.Type)
+	}
+
+	return f, nil
+}
+
+// SaveDocument writes the document's CRDT state to the named file, creating it if necessary.
+// The contents of the file are overwritten.
+func SaveDocument(fileName string, doc *Document) error {
+	data, err := EncodeFile(doc)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fileName, data, 0644)
+}
+
+// LoadDocument reads a file written by SaveDocument (or an older version of it).
+func LoadDocument(fileName string) (File, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return File{}, err
+	}
+
+	return DecodeFile(data)
+}
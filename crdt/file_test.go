@@ -0,0 +1,100 @@
+package crdt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSaveDocument(t *testing.T) {
+	doc := &Document{
+		Characters: []Character{
+			{ID: IDStart, Visible: false, Value: "", IDPrevious: CharacterID{}, IDNext: CharacterID{Clock: 1}},
+			{ID: CharacterID{Clock: 1}, Visible: true, Value: "h", IDPrevious: IDStart, IDNext: CharacterID{Clock: 2}},
+			{ID: CharacterID{Clock: 2}, Visible: false, Value: "x", IDPrevious: CharacterID{Clock: 1}, IDNext: CharacterID{Clock: 3}},
+			{ID: CharacterID{Clock: 3}, Visible: true, Value: "i", IDPrevious: CharacterID{Clock: 2}, IDNext: IDEnd},
+			{ID: IDEnd, Visible: false, Value: "", IDPrevious: CharacterID{Clock: 3}, IDNext: CharacterID{}},
+		},
+	}
+
+	prevClock := LocalClock
+	defer func() { LocalClock = prevClock }()
+	LocalClock = 3
+
+	fileName := filepath.Join(t.TempDir(), "doc.pairpad")
+	if err := SaveDocument(fileName, doc); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	f, err := LoadDocument(fileName)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	if f.Version != FormatVersion || f.Type != FormatType {
+		t.Errorf("wrong envelope: version = %v, type = %v\n", f.Version, f.Type)
+	}
+
+	if f.Clock != 3 {
+		t.Errorf("got clock %v, expected = %v\n", f.Clock, 3)
+	}
+
+	// Deleted characters must be kept, unlike with Save.
+	if !cmp.Equal(&f.Document, doc) {
+		t.Errorf("got != want; diff = %v\n", cmp.Diff(&f.Document, doc))
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	// The files were written by older versions of pairpad, so the character's ID is a
+	// legacy ID.
+	legacyID := CharacterID{SiteID: legacySite, Clock: 1}
+	want := Document{
+		Characters: []Character{
+			{ID: IDStart, Visible: false, Value: "", IDPrevious: CharacterID{}, IDNext: legacyID},
+			{ID: legacyID, Visible: true, Value: "a", IDPrevious: IDStart, IDNext: IDEnd},
+			{ID: IDEnd, Visible: false, Value: "", IDPrevious: legacyID, IDNext: CharacterID{}},
+		},
+	}
+
+	tests := []struct {
+		description string
+		data        string
+		err         error
+	}{
+		{description: "version 0 (no envelope)",
+			data: `{"Characters":[{"ID":"start","Visible":false,"Value":"","IDPrevious":"","IDNext":"1"},{"ID":"1","Visible":true,"Value":"a","IDPrevious":"start","IDNext":"end"},{"ID":"end","Visible":false,"Value":"","IDPrevious":"1","IDNext":""}]}`},
+		{description: "version 1",
+			data: `{"version":1,"type":"woot","siteID":2,"savedAt":"2022-01-01T00:00:00Z","document":{"Characters":[{"ID":"start","Visible":false,"Value":"","IDPrevious":"","IDNext":"1"},{"ID":"1","Visible":true,"Value":"a","IDPrevious":"start","IDNext":"end"},{"ID":"end","Visible":false,"Value":"","IDPrevious":"1","IDNext":""}]}}`},
+		{description: "newer version", data: `{"version":1000,"type":"woot"}`, err: ErrUnsupportedVersion},
+		{description: "other CRDT", data: `{"version":1,"type":"logoot"}`, err: ErrUnsupportedType},
+	}
+
+	for _, tc := range tests {
+		f, err := DecodeFile([]byte(tc.data))
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("(%s) got error %v, expected %v\n", tc.description, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("(%s) error: %v\n", tc.description, err)
+			continue
+		}
+
+		if !cmp.Equal(f.Document, want) {
+			t.Errorf("(%s) got != want; diff = %v\n", tc.description, cmp.Diff(f.Document, want))
+		}
+	}
+}
+
+func TestLoadDocument_NotExist(t *testing.T) {
+	_, err := LoadDocument(filepath.Join(t.TempDir(), "missing.pairpad"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v, expected %v\n", err, os.ErrNotExist)
+	}
+}
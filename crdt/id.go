@@ -0,0 +1,122 @@
+package crdt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A CharacterID identifies a character by the site which generated it, and the site's local
+// clock at the time. IDs are ordered by site ID, then by clock (see Compare), which is the
+// order in which IntegrateInsert places concurrent inserts.
+//
+// IDs are encoded as text (and so as JSON strings) as "<site ID>.<clock>", except for the
+// IDs of CharacterStart and CharacterEnd, which are "start" and "end", and the zero ID,
+// which is "".
+type CharacterID struct {
+	SiteID int
+	Clock  int
+}
+
+// Site IDs which are never given to a site.
+const (
+	// boundarySite is the site ID of IDStart and IDEnd.
+	boundarySite = -1
+
+	// legacySite is the site ID of IDs encoded by older versions of pairpad, which
+	// concatenated the site ID and clock so they can't be told apart again. The
+	// concatenated number is kept as the clock, so the IDs stay distinct.
+	legacySite = -2
+)
+
+var (
+	// IDStart is the ID of CharacterStart.
+	IDStart = CharacterID{SiteID: boundarySite, Clock: 0}
+
+	// IDEnd is the ID of CharacterEnd.
+	IDEnd = CharacterID{SiteID: boundarySite, Clock: 1}
+)
+
+// IsZero reports whether id is the zero ID, which no character has.
+func (id CharacterID) IsZero() bool {
+	return id == CharacterID{}
+}
+
+// Compare returns -1 if id is ordered before other, 1 if it's ordered after other, and 0
+// if they're equal.
+func (id CharacterID) Compare(other CharacterID) int {
+	switch {
+	case id.SiteID < other.SiteID:
+		return -1
+	case id.SiteID > other.SiteID:
+		return 1
+	case id.Clock < other.Clock:
+		return -1
+	case id.Clock > other.Clock:
+		return 1
+	}
+	return 0
+}
+
+// String returns the text encoding of id.
+func (id CharacterID) String() string {
+	switch {
+	case id.IsZero():
+		return ""
+	case id == IDStart:
+		return "start"
+	case id == IDEnd:
+		return "end"
+	case id.SiteID == legacySite:
+		return strconv.Itoa(id.Clock)
+	}
+	return strconv.Itoa(id.SiteID) + "." + strconv.Itoa(id.Clock)
+}
+
+// ParseCharacterID parses the text encoding of an ID. IDs encoded by older versions of
+// pairpad (such as "111", for the character generated by site 1 at clock 11, or by site 11
+// at clock 1) are accepted too.
+func ParseCharacterID(s string) (CharacterID, error) {
+	switch s {
+	case "":
+		return CharacterID{}, nil
+	case "start":
+		return IDStart, nil
+	case "end":
+		return IDEnd, nil
+	}
+
+	site, clock, ok := strings.Cut(s, ".")
+	if !ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return CharacterID{}, fmt.Errorf("invalid character ID %q", s)
+		}
+		return CharacterID{SiteID: legacySite, Clock: n}, nil
+	}
+
+	siteID, err := strconv.Atoi(site)
+	if err != nil || siteID < 0 {
+		return CharacterID{}, fmt.Errorf("invalid site ID in character ID %q", s)
+	}
+	n, err := strconv.Atoi(clock)
+	if err != nil || n < 1 {
+		return CharacterID{}, fmt.Errorf("invalid clock in character ID %q", s)
+	}
+	return CharacterID{SiteID: siteID, Clock: n}, nil
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (id CharacterID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (id *CharacterID) UnmarshalText(text []byte) error {
+	parsed, err := ParseCharacterID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
@@ -0,0 +1,125 @@
+package crdt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseCharacterID(t *testing.T) {
+	tests := []struct {
+		text string
+		want CharacterID
+		err  bool
+	}{
+		{text: "", want: CharacterID{}},
+		{text: "start", want: IDStart},
+		{text: "end", want: IDEnd},
+		{text: "1.10", want: CharacterID{SiteID: 1, Clock: 10}},
+		{text: "0.3", want: CharacterID{SiteID: 0, Clock: 3}},
+
+		// IDs of older versions are kept as they are.
+		{text: "110", want: CharacterID{SiteID: legacySite, Clock: 110}},
+
+		{text: "web1.0", err: true},
+		{text: "1.0", err: true},
+		{text: "-2.5", err: true},
+		{text: "1.x", err: true},
+		{text: "-4", err: true},
+	}
+
+	for _, tc := range tests {
+		got, err := ParseCharacterID(tc.text)
+		if tc.err {
+			if err == nil {
+				t.Errorf("(%q) got %v, expected an error\n", tc.text, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("(%q) error: %v\n", tc.text, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("(%q) got = %#v, expected = %#v\n", tc.text, got, tc.want)
+		}
+		if got.String() != tc.text {
+			t.Errorf("(%q) got string %q, expected %q\n", tc.text, got.String(), tc.text)
+		}
+	}
+}
+
+// TestCompare verifies that IDs are ordered by site ID, then clock, rather than by their
+// text encoding.
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b CharacterID
+		want int
+	}{
+		{CharacterID{SiteID: 2, Clock: 1}, CharacterID{SiteID: 11, Clock: 1}, -1},
+		{CharacterID{SiteID: 1, Clock: 9}, CharacterID{SiteID: 1, Clock: 10}, -1},
+		{CharacterID{SiteID: 3, Clock: 1}, CharacterID{SiteID: 1, Clock: 10}, 1},
+		{CharacterID{SiteID: 1, Clock: 10}, CharacterID{SiteID: 1, Clock: 10}, 0},
+	}
+
+	for _, tc := range tests {
+		if got := tc.a.Compare(tc.b); got != tc.want {
+			t.Errorf("%v.Compare(%v): got = %v, expected = %v\n", tc.a, tc.b, got, tc.want)
+		}
+		if got := tc.b.Compare(tc.a); got != -tc.want {
+			t.Errorf("%v.Compare(%v): got = %v, expected = %v\n", tc.b, tc.a, got, -tc.want)
+		}
+	}
+}
+
+// TestIntegrateInsert_Order verifies that concurrent inserts at the same position are
+// ordered by their IDs, whatever order they're integrated in.
+func TestIntegrateInsert_Order(t *testing.T) {
+	// Ordered by their text encoding, "11.1" would come before "2.1".
+	chars := []Character{
+		{ID: CharacterID{SiteID: 11, Clock: 1}, Visible: true, Value: "c", IDPrevious: IDStart, IDNext: IDEnd},
+		{ID: CharacterID{SiteID: 2, Clock: 1}, Visible: true, Value: "b", IDPrevious: IDStart, IDNext: IDEnd},
+		{ID: CharacterID{SiteID: 1, Clock: 10}, Visible: true, Value: "a", IDPrevious: IDStart, IDNext: IDEnd},
+	}
+
+	for _, order := range [][]int{{0, 1, 2}, {2, 1, 0}, {1, 0, 2}, {2, 0, 1}} {
+		doc := New()
+		for _, i := range order {
+			if _, err := doc.IntegrateInsert(chars[i], doc.Find(IDStart), doc.Find(IDEnd)); err != nil {
+				t.Fatalf("error: %v\n", err)
+			}
+		}
+		if got, want := Content(doc), "abc"; got != want {
+			t.Errorf("(order %v) got = %q, expected = %q\n", order, got, want)
+		}
+	}
+}
+
+func TestCharacterID_JSON(t *testing.T) {
+	doc := New()
+	if _, err := doc.LocalInsert(Character{ID: CharacterID{SiteID: 4, Clock: 2}, Visible: true, Value: "a", IDPrevious: IDStart, IDNext: IDEnd}, 1); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	want := `{"Characters":[{"ID":"start","Visible":false,"Value":"","IDPrevious":"","IDNext":"end"},{"ID":"4.2","Visible":true,"Value":"a","IDPrevious":"start","IDNext":"end"},{"ID":"end","Visible":false,"Value":"","IDPrevious":"start","IDNext":""}]}`
+	if string(data) != want {
+		t.Errorf("got = %s, expected = %s\n", data, want)
+	}
+
+	var got Document
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if !cmp.Equal(got, doc) {
+		t.Errorf("got != want; diff = %v\n", cmp.Diff(got, doc))
+	}
+
+	if err := json.Unmarshal([]byte(`{"Characters":[{"ID":"x"}]}`), &got); err == nil {
+		t.Errorf("got no error for an invalid ID\n")
+	}
+}
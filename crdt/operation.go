@@ -0,0 +1,147 @@
+package crdt
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCharacterNotFound is returned when applying an operation on characters the document
+// doesn't have.
+var ErrCharacterNotFound = errors.New("character not in the document")
+
+// Operation represents a CRDT operation.
+type Operation struct {
+	// Type represents the operation type, for example, insert, delete.
+	Type string `json:"type"`
+
+	// Position represents the position at which the operation has been made.
+	Position int `json:"position"`
+
+	// Value represents the content of the operation. Mostly a character.
+	Value string `json:"value"`
+
+	// Insert holds the characters inserted, for inserts generated by a Document (see
+	// GenerateOperation). Other sites integrate them between the same characters, wherever
+	// those are by then, instead of inserting Value at Position.
+	Insert *InsertOp `json:"insert,omitempty"`
+
+	// Delete holds the character deleted, for deletes generated by a Document.
+	Delete *DeleteOp `json:"delete,omitempty"`
+}
+
+// InsertOp inserts characters into a document, which the site that generated them inserted
+// between their IDPrevious and IDNext.
+type InsertOp struct {
+	Characters []Character `json:"characters"`
+}
+
+// DeleteOp deletes a character from a document.
+type DeleteOp struct {
+	Character Character `json:"character"`
+}
+
+// Apply integrates the characters into doc, and returns the position of the first one,
+// counted from 1. The characters doc already has, as when an operation is received twice,
+// are skipped.
+func (op InsertOp) Apply(doc *Document) (int, error) {
+	position := 0
+	for _, char := range op.Characters {
+		if doc.Contains(char.ID) {
+			continue
+		}
+
+		charPrev, charNext := doc.Find(char.IDPrevious), doc.Find(char.IDNext)
+		if charPrev.ID.IsZero() || charNext.ID.IsZero() {
+			return position, fmt.Errorf("%w: %v was inserted between %v and %v", ErrCharacterNotFound, char.ID, char.IDPrevious, char.IDNext)
+		}
+		if _, err := doc.IntegrateInsert(char, charPrev, charNext); err != nil {
+			return position, err
+		}
+		if position == 0 {
+			position = doc.visiblePosition(char.ID)
+		}
+	}
+
+	return position, nil
+}
+
+// Apply hides the character in doc, and returns the position it had, counted from 1, or 0
+// if it was already deleted.
+func (op DeleteOp) Apply(doc *Document) (int, error) {
+	if !doc.Contains(op.Character.ID) {
+		return 0, fmt.Errorf("%w: %v", ErrCharacterNotFound, op.Character.ID)
+	}
+
+	position := doc.visiblePosition(op.Character.ID)
+	if position > 0 {
+		doc.IntegrateDelete(op.Character)
+	}
+	return position, nil
+}
+
+// Apply applies the operation to doc, and returns the position it was applied at, counted
+// from 1, or 0 if it changed nothing.
+//
+// The characters of the operations generated by a Document are integrated as they were
+// generated. If doc doesn't have the characters they refer to, which the sender had, doc
+// is out of date, and ErrCharacterNotFound is returned: applying them at Position would
+// give the characters different neighbours, or different IDs, than on the other sites. The
+// operations of older clients, which don't carry characters, generate characters of their
+// own at Position.
+func (op Operation) Apply(doc *Document) (int, error) {
+	switch {
+	case op.Type == "insert" && op.Insert != nil:
+		return op.Insert.Apply(doc)
+	case op.Type == "delete" && op.Delete != nil:
+		return op.Delete.Apply(doc)
+	}
+
+	switch op.Type {
+	case "insert":
+		if _, err := doc.InsertRange(op.Position, op.Value); err != nil {
+			return 0, err
+		}
+		return op.Position, nil
+	case "delete":
+		if IthVisible(*doc, op.Position).ID.IsZero() {
+			return 0, nil
+		}
+		doc.Delete(op.Position)
+		return op.Position, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrUnknownOperation, op.Type)
+	}
+}
+
+// GenerateOperation performs op, an insert or delete made by the local site at op.Position,
+// on doc, and returns it with the characters it inserted or deleted, for the other sites to
+// apply. Deleting a position past the end of the document deletes nothing, and returns op
+// as it is. If a rune can't be inserted, the operation returned inserts the runes before it.
+func (doc *Document) GenerateOperation(op Operation) (Operation, error) {
+	switch op.Type {
+	case "insert":
+		runes := []rune(op.Value)
+		op.Insert = &InsertOp{}
+		for i, r := range runes {
+			char, err := doc.generateInsert(op.Position+i, string(r))
+			if err != nil {
+				op.Value = string(runes[:i])
+				return op, err
+			}
+			op.Insert.Characters = append(op.Insert.Characters, char)
+		}
+		return op, nil
+
+	case "delete":
+		char := IthVisible(*doc, op.Position)
+		if char.ID.IsZero() {
+			return op, nil
+		}
+		doc.IntegrateDelete(char)
+		op.Delete = &DeleteOp{Character: char}
+		return op, nil
+
+	default:
+		return op, fmt.Errorf("%w: %q", ErrUnknownOperation, op.Type)
+	}
+}
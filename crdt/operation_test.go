@@ -0,0 +1,131 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestGenerateOperation verifies that the operations generated by a site's document carry
+// its characters, which other sites apply as they were generated, after a round trip
+// through JSON, even once the positions they were made at have changed.
+func TestGenerateOperation(t *testing.T) {
+	prevSiteID, prevClock := SiteID, LocalClock
+	defer func() { SiteID, LocalClock = prevSiteID, prevClock }()
+
+	SiteID, LocalClock = 1, 0
+	a, err := FromText("ad")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	b := a.Snapshot().(*Document)
+
+	// Site 1 inserts "bc" between "a" and "d", and deletes "a".
+	var ops []Operation
+	for _, op := range []Operation{{Type: "insert", Position: 2, Value: "bc"}, {Type: "delete", Position: 1}} {
+		generated, err := a.GenerateOperation(op)
+		if err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+		ops = append(ops, generated)
+	}
+	if got, want := Content(a), "bcd"; got != want {
+		t.Errorf("got = %q, expected = %q\n", got, want)
+	}
+	if got := len(ops[0].Insert.Characters); got != 2 {
+		t.Errorf("got %d characters inserted, expected = 2\n", got)
+	}
+	if got, want := ops[1].Delete.Character.Value, "a"; got != want {
+		t.Errorf("got %q deleted, expected = %q\n", got, want)
+	}
+
+	// Meanwhile, site 2 inserts "x" at the start, so the positions differ.
+	SiteID, LocalClock = 2, 2
+	if _, err := b.Insert(1, "x"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	for i, op := range ops {
+		data, err := json.Marshal(op)
+		if err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+		var received Operation
+		if err := json.Unmarshal(data, &received); err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+		if diff := cmp.Diff(op, received); diff != "" {
+			t.Errorf("operation changed through JSON (-sent +received):\n%s", diff)
+		}
+
+		position, err := received.Apply(b)
+		if err != nil {
+			t.Fatalf("error: %v\n", err)
+		}
+		if want := []int{3, 2}[i]; position != want {
+			t.Errorf("(%s) got position %d, expected = %d\n", op.Type, position, want)
+		}
+	}
+	if got, want := Content(*b), "xbcd"; got != want {
+		t.Errorf("got = %q, expected = %q\n", got, want)
+	}
+
+	// Operations received twice change nothing.
+	for _, op := range ops {
+		if position, err := op.Apply(b); err != nil || position != 0 {
+			t.Errorf("(%s) got position %d and error %v, expected = 0 and nil\n", op.Type, position, err)
+		}
+	}
+	if got, want := Content(*b), "xbcd"; got != want {
+		t.Errorf("got = %q, expected = %q\n", got, want)
+	}
+
+	// Deleting past the end deletes nothing.
+	if op, err := b.GenerateOperation(Operation{Type: "delete", Position: 10}); err != nil || op.Delete != nil {
+		t.Errorf("got %+v and error %v, expected no delete\n", op, err)
+	}
+	if _, err := b.GenerateOperation(Operation{Type: "move"}); !errors.Is(err, ErrUnknownOperation) {
+		t.Errorf("got error %v, expected = %v\n", err, ErrUnknownOperation)
+	}
+}
+
+// TestOperation_ApplyPosition verifies that operations without characters are applied at
+// their position, and that those on characters the document doesn't have fail, and change
+// nothing.
+func TestOperation_ApplyPosition(t *testing.T) {
+	doc, err := FromText("ac")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	for _, op := range []Operation{
+		{Type: "insert", Position: 2, Value: "b"},
+		{Type: "delete", Position: 1},
+	} {
+		if _, err := op.Apply(&doc); err != nil {
+			t.Errorf("applying %+v: %v\n", op, err)
+		}
+	}
+	if got, want := Content(doc), "bc"; got != want {
+		t.Errorf("got = %q, expected = %q\n", got, want)
+	}
+
+	if position, err := (Operation{Type: "delete", Position: 4}).Apply(&doc); err != nil || position != 0 {
+		t.Errorf("got position %d and error %v, expected = 0 and nil\n", position, err)
+	}
+
+	missing := CharacterID{SiteID: 9, Clock: 1}
+	for _, op := range []Operation{
+		{Type: "insert", Position: 3, Value: "d", Insert: &InsertOp{Characters: []Character{{ID: CharacterID{SiteID: 9, Clock: 2}, Visible: true, Value: "d", IDPrevious: missing, IDNext: IDEnd}}}},
+		{Type: "delete", Position: 1, Delete: &DeleteOp{Character: Character{ID: missing, Value: "b"}}},
+	} {
+		if _, err := op.Apply(&doc); !errors.Is(err, ErrCharacterNotFound) {
+			t.Errorf("applying %+v: got error %v, expected = %v\n", op, err, ErrCharacterNotFound)
+		}
+	}
+	if got, want := Content(doc), "bc"; got != want {
+		t.Errorf("got = %q, expected = %q\n", got, want)
+	}
+}
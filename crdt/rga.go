@@ -0,0 +1,235 @@
+package crdt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RGA is a document implemented as a Replicated Growable Array: each character is inserted
+// after a reference character, and concurrent inserts after the same character are ordered
+// by their timestamps, the latest first. Deleted characters are kept as tombstones, so
+// later inserts can still refer to them.
+//
+// Unlike Document, an insert only needs its reference character, rather than both of its
+// neighbours and the characters between them, so inserts don't slow down as concurrent
+// edits pile up at the same place. Document remains the format of the protocol.
+type RGA struct {
+	// Nodes holds the characters in document order, after the head node, whose ID is
+	// IDStart.
+	Nodes []RGANode
+
+	// clock is the highest clock of the nodes, which the local site's next insert must be
+	// after.
+	clock int
+}
+
+// An RGANode is a character of an RGA.
+type RGANode struct {
+	// ID is the node's timestamp: the local clock of the site which inserted it, which is
+	// ahead of the clocks of the nodes the site had, and its site ID.
+	ID CharacterID
+
+	// Ref is the ID of the node the character was inserted after.
+	Ref CharacterID
+
+	Value string
+
+	// Deleted is set on tombstones.
+	Deleted bool
+}
+
+var _ CRDT = (*RGA)(nil)
+
+// NewRGA returns an empty RGA.
+func NewRGA() RGA {
+	return RGA{Nodes: []RGANode{{ID: IDStart}}}
+}
+
+// rgaAfter reports whether a node with timestamp id is ordered after one with timestamp
+// other. Timestamps are ordered by clock, then by site ID, unlike CharacterID.Compare.
+func rgaAfter(id, other CharacterID) bool {
+	if id.Clock != other.Clock {
+		return id.Clock > other.Clock
+	}
+	return id.SiteID > other.SiteID
+}
+
+// index returns the index of the node with id, or -1 if there isn't one.
+func (r *RGA) index(id CharacterID) int {
+	for i, node := range r.Nodes {
+		if node.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// visibleIndex returns the index of the node of the character at position, counted from 1,
+// or the head node's for position 0. It returns -1 if there isn't one.
+func (r *RGA) visibleIndex(position int) int {
+	if position == 0 {
+		return 0
+	}
+
+	count := 0
+	for i, node := range r.Nodes[1:] {
+		if !node.Deleted {
+			if count++; count == position {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// integrate inserts node after its reference node, and after the nodes following it with
+// later timestamps, which were inserted concurrently or after them.
+func (r *RGA) integrate(node RGANode) error {
+	i := r.index(node.Ref)
+	if i == -1 {
+		return fmt.Errorf("%w: reference %v not present", ErrBoundsNotPresent, node.Ref)
+	}
+
+	i++
+	for i < len(r.Nodes) && rgaAfter(r.Nodes[i].ID, node.ID) {
+		i++
+	}
+
+	r.Nodes = append(r.Nodes, RGANode{})
+	copy(r.Nodes[i+1:], r.Nodes[i:])
+	r.Nodes[i] = node
+
+	if node.ID.Clock > r.clock {
+		r.clock = node.ID.Clock
+	}
+	return nil
+}
+
+// Length returns the number of visible characters.
+func (r *RGA) Length() int {
+	n := 0
+	for _, node := range r.Nodes[1:] {
+		if !node.Deleted {
+			n++
+		}
+	}
+	return n
+}
+
+// String returns the visible content of the document.
+func (r *RGA) String() string {
+	var b strings.Builder
+	for _, node := range r.Nodes[1:] {
+		if !node.Deleted {
+			b.WriteString(node.Value)
+		}
+	}
+	return b.String()
+}
+
+////////////////////////////////
+// Implement the CRDT interface
+////////////////////////////////
+
+func (r *RGA) Insert(position int, value string) (string, error) {
+	ref := r.visibleIndex(position - 1)
+	if position < 1 || ref == -1 {
+		return r.String(), ErrPositionOutOfBounds
+	}
+
+	// The local clock is advanced past the document's, so the node is ordered after the
+	// nodes the site has seen, as with Lamport clocks.
+	mu.Lock()
+	if LocalClock < r.clock {
+		LocalClock = r.clock
+	}
+	LocalClock++
+	id := CharacterID{SiteID: SiteID, Clock: LocalClock}
+	mu.Unlock()
+
+	if err := r.integrate(RGANode{ID: id, Ref: r.Nodes[ref].ID, Value: value}); err != nil {
+		return r.String(), err
+	}
+	return r.String(), nil
+}
+
+func (r *RGA) Delete(position int) string {
+	if i := r.visibleIndex(position); i > 0 {
+		r.Nodes[i].Deleted = true
+	}
+	return r.String()
+}
+
+// InsertRange inserts the runes of value one at a time, each after the one before.
+func (r *RGA) InsertRange(position int, value string) (string, error) {
+	for i, ch := range []rune(value) {
+		if _, err := r.Insert(position+i, string(ch)); err != nil {
+			return r.String(), err
+		}
+	}
+	return r.String(), nil
+}
+
+func (r *RGA) DeleteRange(position, n int) string {
+	for i := 0; i < n; i++ {
+		r.Delete(position)
+	}
+	return r.String()
+}
+
+// ApplyRemote applies an insert or delete received from another site at its position. The
+// characters of operations generated by a Document are ignored, as they're its own.
+func (r *RGA) ApplyRemote(op Operation) error {
+	switch op.Type {
+	case "insert":
+		_, err := r.InsertRange(op.Position, op.Value)
+		return err
+	case "delete":
+		r.Delete(op.Position)
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownOperation, op.Type)
+	}
+}
+
+func (r *RGA) Snapshot() CRDT {
+	return &RGA{Nodes: append([]RGANode(nil), r.Nodes...), clock: r.clock}
+}
+
+// Merge integrates the nodes of other, which must be an *RGA, missing from r, and marks
+// the nodes deleted in other as tombstones. Nodes are integrated in other's order, so
+// their reference nodes are integrated first.
+func (r *RGA) Merge(other CRDT) error {
+	o, ok := other.(*RGA)
+	if !ok {
+		return fmt.Errorf("%w: %T isn't a *crdt.RGA", ErrIncompatibleMerge, other)
+	}
+	if len(o.Nodes) == 0 || len(r.Nodes) == 0 {
+		return fmt.Errorf("%w: missing the head node", ErrIncompatibleMerge)
+	}
+
+	present := make(map[CharacterID]bool, len(r.Nodes))
+	for _, node := range r.Nodes {
+		present[node.ID] = true
+	}
+
+	deleted := make(map[CharacterID]bool)
+	for _, node := range o.Nodes[1:] {
+		if node.Deleted {
+			deleted[node.ID] = true
+		}
+		if !present[node.ID] {
+			if err := r.integrate(node); err != nil {
+				return fmt.Errorf("%w: integrating %v: %v", ErrIncompatibleMerge, node.ID, err)
+			}
+			present[node.ID] = true
+		}
+	}
+
+	for i := range r.Nodes {
+		if deleted[r.Nodes[i].ID] {
+			r.Nodes[i].Deleted = true
+		}
+	}
+	return nil
+}
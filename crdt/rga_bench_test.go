@@ -0,0 +1,88 @@
+package crdt
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// benchRGA returns an RGA containing n visible characters, typed one after the other, like
+// benchDocument.
+func benchRGA(n int) RGA {
+	nodes := make([]RGANode, 0, n+1)
+	nodes = append(nodes, RGANode{ID: IDStart})
+
+	for i := 0; i < n; i++ {
+		ref := benchID(i - 1)
+		if i == 0 {
+			ref = IDStart
+		}
+		nodes = append(nodes, RGANode{ID: benchID(i), Ref: ref, Value: string(rune('a' + i%26))})
+	}
+
+	return RGA{Nodes: nodes, clock: n}
+}
+
+// benchBackends builds documents of each CRDT implementation with n visible characters.
+var benchBackends = []struct {
+	name string
+	new  func(n int) CRDT
+}{
+	{"woot", func(n int) CRDT { doc := benchDocument(n); return &doc }},
+	{"rga", func(n int) CRDT { doc := benchRGA(n); return &doc }},
+}
+
+func BenchmarkRGAInsertAtRandom(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("chars=%d", n), func(b *testing.B) {
+			doc := benchRGA(n)
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := doc.Insert(r.Intn(n+i)+1, "x"); err != nil {
+					b.Fatalf("error: %v\n", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSession simulates an editing session on each backend: each iteration, two sites
+// type a word at the same random place, delete a few characters, and merge each other's
+// edits, as replicas reconnecting after a partition would.
+func BenchmarkSession(b *testing.B) {
+	prevSiteID, prevClock := SiteID, LocalClock
+	defer func() { SiteID, LocalClock = prevSiteID, prevClock }()
+
+	for _, backend := range benchBackends {
+		for _, n := range benchSizes[:2] {
+			b.Run(fmt.Sprintf("%s/chars=%d", backend.name, n), func(b *testing.B) {
+				sites := []CRDT{backend.new(n), backend.new(n)}
+				r := rand.New(rand.NewSource(1))
+				length := n
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					position := r.Intn(length) + 1
+					for site, doc := range sites {
+						SiteID = site + 1
+						if _, err := doc.InsertRange(position, "hello "); err != nil {
+							b.Fatalf("error: %v\n", err)
+						}
+						doc.DeleteRange(r.Intn(length)+1, 2)
+					}
+
+					other := sites[1].Snapshot()
+					if err := sites[1].Merge(sites[0]); err != nil {
+						b.Fatalf("error: %v\n", err)
+					}
+					if err := sites[0].Merge(other); err != nil {
+						b.Fatalf("error: %v\n", err)
+					}
+					length += 8
+				}
+			})
+		}
+	}
+}
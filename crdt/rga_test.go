@@ -0,0 +1,111 @@
+package crdt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestRGA verifies that characters are inserted and deleted at the given positions.
+func TestRGA(t *testing.T) {
+	prevSiteID, prevClock := SiteID, LocalClock
+	defer func() { SiteID, LocalClock = prevSiteID, prevClock }()
+	SiteID, LocalClock = 1, 0
+
+	doc := NewRGA()
+	tests := []struct {
+		name string
+		op   func() (string, error)
+		want string
+	}{
+		{"insert", func() (string, error) { return doc.InsertRange(1, "hllo") }, "hllo"},
+		{"insert in the middle", func() (string, error) { return doc.Insert(2, "é") }, "héllo"},
+		{"delete", func() (string, error) { return doc.Delete(1), nil }, "éllo"},
+		{"delete range", func() (string, error) { return doc.DeleteRange(2, 2), nil }, "éo"},
+		{"delete past the end", func() (string, error) { return doc.DeleteRange(2, 5), nil }, "é"},
+		{"insert at the end", func() (string, error) { return doc.Insert(2, "!") }, "é!"},
+		{"remote insert", func() (string, error) {
+			return "", doc.ApplyRemote(Operation{Type: "insert", Position: 1, Value: "ab"})
+		}, "abé!"},
+		{"remote delete", func() (string, error) { return "", doc.ApplyRemote(Operation{Type: "delete", Position: 4}) }, "abé"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.op(); err != nil {
+				t.Fatalf("error: %v\n", err)
+			}
+			if got := doc.String(); got != tt.want {
+				t.Errorf("got != want; got = %v, expected = %v\n", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := doc.Length(), 3; got != want {
+		t.Errorf("got length %v, expected = %v\n", got, want)
+	}
+	if _, err := doc.Insert(5, "x"); !errors.Is(err, ErrPositionOutOfBounds) {
+		t.Errorf("got error %v, expected = %v\n", err, ErrPositionOutOfBounds)
+	}
+	if err := doc.ApplyRemote(Operation{Type: "move"}); !errors.Is(err, ErrUnknownOperation) {
+		t.Errorf("got error %v, expected = %v\n", err, ErrUnknownOperation)
+	}
+}
+
+// TestRGAMerge verifies that replicas edited concurrently, including at the same place and
+// around deleted characters, converge once merged in either order.
+func TestRGAMerge(t *testing.T) {
+	prevSiteID, prevClock := SiteID, LocalClock
+	defer func() { SiteID, LocalClock = prevSiteID, prevClock }()
+	SiteID, LocalClock = 1, 0
+
+	a := NewRGA()
+	if _, err := a.InsertRange(1, "cat"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	b := a.Snapshot().(*RGA)
+
+	// Site 1 deletes "a" and types after it, while site 2 types at the same place.
+	a.Delete(2)
+	if _, err := a.InsertRange(2, "u"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if got, want := b.String(), "cat"; got != want {
+		t.Errorf("snapshot changed: got = %v, expected = %v\n", got, want)
+	}
+
+	SiteID, LocalClock = 2, 0
+	if _, err := b.InsertRange(2, "oo"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	c := a.Snapshot()
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if err := b.Merge(c); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if a.String() != b.String() {
+		t.Errorf("replicas differ after merging: %q and %q\n", a.String(), b.String())
+	}
+	if diff := cmp.Diff(a.Nodes, b.Nodes); diff != "" {
+		t.Errorf("replicas differ after merging (-a +b):\n%s", diff)
+	}
+	if got, want := a.Length(), 5; got != want {
+		t.Errorf("got length %v, expected = %v\n", got, want)
+	}
+
+	// The next insert is ordered after every node the site has merged.
+	if _, err := b.Insert(1, "x"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if got := b.Nodes[1].ID.Clock; got <= 4 {
+		t.Errorf("got clock %v, expected it to be after the merged nodes' clocks\n", got)
+	}
+
+	if err := a.Merge(&Document{}); !errors.Is(err, ErrIncompatibleMerge) {
+		t.Errorf("got error %v, expected = %v\n", err, ErrIncompatibleMerge)
+	}
+}
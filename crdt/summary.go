@@ -0,0 +1,56 @@
+package crdt
+
+import (
+	"strings"
+	"unicode"
+	"unsafe"
+)
+
+// A Summary describes the content of a document, and the memory its characters take.
+type Summary struct {
+	// Characters, Words and Lines count the visible content. A document has at least one
+	// line, even when it's empty.
+	Characters, Words, Lines int
+
+	// Tombstones is the number of deleted characters, which the document keeps.
+	Tombstones int
+
+	// BySite holds the number of visible characters inserted by each site.
+	BySite map[int]int
+
+	// Bytes estimates the memory taken by the characters, including the tombstones, and
+	// the start and end characters.
+	Bytes int
+}
+
+// Summarize returns a summary of doc.
+func Summarize(doc Document) Summary {
+	s := Summary{Lines: 1, BySite: make(map[int]int)}
+	inWord := false
+
+	for _, char := range doc.Characters {
+		s.Bytes += int(unsafe.Sizeof(char)) + len(char.Value)
+		if char.ID == IDStart || char.ID == IDEnd {
+			continue
+		}
+		if !char.Visible {
+			s.Tombstones++
+			continue
+		}
+
+		s.Characters++
+		s.BySite[char.ID.SiteID]++
+		if char.Value == "\n" {
+			s.Lines++
+		}
+
+		// Characters hold a single rune, so words are counted as strings.Fields would.
+		space := strings.IndexFunc(char.Value, unicode.IsSpace) == 0
+		if !space && !inWord {
+			s.Words++
+		}
+		inWord = !space
+	}
+
+	return s
+}
@@ -0,0 +1,41 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestSummarize verifies that the visible content is counted, by site, apart from the
+// deleted characters.
+func TestSummarize(t *testing.T) {
+	prevSiteID, prevClock := SiteID, LocalClock
+	defer func() { SiteID, LocalClock = prevSiteID, prevClock }()
+	SiteID, LocalClock = 1, 0
+
+	doc, err := FromText("hello  world\nbye")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	SiteID = 2
+	if _, err := doc.InsertRange(17, "!\n"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	doc.DeleteRange(1, 2)
+
+	got := Summarize(doc)
+	if got.Bytes <= doc.Length()*len("x") {
+		t.Errorf("got %v bytes, expected more than the characters' values\n", got.Bytes)
+	}
+	got.Bytes = 0
+
+	want := Summary{Characters: 16, Words: 3, Lines: 3, Tombstones: 2, BySite: map[int]int{1: 14, 2: 2}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("got != want (-want +got):\n%s", diff)
+	}
+
+	// An empty document has a line.
+	if got := Summarize(New()); got.Characters != 0 || got.Words != 0 || got.Lines != 1 {
+		t.Errorf("got %+v, expected an empty document with one line\n", got)
+	}
+}
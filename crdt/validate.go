@@ -0,0 +1,91 @@
+package crdt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidDocument is wrapped by the errors Validate returns.
+var ErrInvalidDocument = errors.New("invalid document")
+
+// A ValidationError lists the structural problems Validate found in a document.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrInvalidDocument, strings.Join(e.Problems, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidDocument
+}
+
+// Validate checks the invariants the operations keep in a document, and returns a
+// *ValidationError listing the broken ones, if any:
+//
+//   - the document starts with CharacterStart and ends with CharacterEnd, which are hidden
+//     and have no neighbours outside it;
+//   - every character has an ID, which no other character has;
+//   - each character's previous and next characters, which it was inserted between, are in
+//     the document, before and after it;
+//   - the cached content, if any, is the content of the characters.
+//
+// A document which fails them was corrupted, by a bug or a damaged file, and operations
+// on it may be placed wrongly or fail.
+func (doc Document) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	chars := doc.Characters
+	if len(chars) < 2 || chars[0].ID != IDStart || chars[len(chars)-1].ID != IDEnd {
+		return &ValidationError{Problems: []string{"the document isn't bounded by the start and end characters"}}
+	}
+	if start := chars[0]; start.Visible || start.Value != "" || !start.IDPrevious.IsZero() {
+		add("the start character changed to %+v", start)
+	}
+	if end := chars[len(chars)-1]; end.Visible || end.Value != "" || !end.IDNext.IsZero() {
+		add("the end character changed to %+v", end)
+	}
+
+	index := make(map[CharacterID]int, len(chars))
+	for i, c := range chars {
+		if c.ID.IsZero() {
+			add("character %d has no ID", i)
+			continue
+		}
+		if j, ok := index[c.ID]; ok {
+			add("characters %d and %d have the same ID %v", j, i, c.ID)
+			continue
+		}
+		index[c.ID] = i
+	}
+
+	for i := 1; i < len(chars)-1; i++ {
+		c := chars[i]
+		if j, ok := index[c.IDPrevious]; !ok {
+			add("character %d (%v) follows %v, which isn't in the document", i, c.ID, c.IDPrevious)
+		} else if j >= i {
+			add("character %d (%v) follows %v, which is after it", i, c.ID, c.IDPrevious)
+		}
+		if j, ok := index[c.IDNext]; !ok {
+			add("character %d (%v) precedes %v, which isn't in the document", i, c.ID, c.IDNext)
+		} else if j <= i {
+			add("character %d (%v) precedes %v, which is before it", i, c.ID, c.IDNext)
+		}
+	}
+
+	if c := doc.content; c != nil && c.valid && c.length == len(chars) {
+		if content := Content(Document{Characters: chars}); c.value != content {
+			add("the cached content %q isn't the characters' %q", c.value, content)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package crdt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestValidate checks that documents built by the operations, including concurrent ones,
+// are valid, and that broken invariants are reported.
+func TestValidate(t *testing.T) {
+	prevSiteID, prevClock := SiteID, LocalClock
+	defer func() { SiteID, LocalClock = prevSiteID, prevClock }()
+	SiteID, LocalClock = 1, 0
+
+	doc, err := FromText("hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.DeleteRange(1, 2)
+	if _, err := doc.Insert(4, "!"); err != nil {
+		t.Fatal(err)
+	}
+	_ = doc.cachedContent()
+
+	// A replica inserting concurrently, at the same place, is merged in.
+	SiteID = 2
+	other := *doc.Snapshot().(*Document)
+	if _, err := other.InsertRange(4, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Merge(&other); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Validate(); err != nil {
+		t.Fatalf("got %v, expected a valid document", err)
+	}
+	if err := New().Validate(); err != nil {
+		t.Errorf("got %v, expected an empty document to be valid", err)
+	}
+
+	tests := []struct {
+		description string
+		corrupt     func(chars []Character) []Character
+		problem     string
+	}{
+		{"no end", func(chars []Character) []Character { return chars[:len(chars)-1] }, "bounded"},
+		{"visible start", func(chars []Character) []Character { chars[0].Visible = true; return chars }, "start character changed"},
+		{"duplicate ID", func(chars []Character) []Character { chars[2].ID = chars[1].ID; return chars }, "same ID"},
+		{"missing ID", func(chars []Character) []Character { chars[3].ID = CharacterID{}; return chars }, "no ID"},
+		{"missing previous", func(chars []Character) []Character {
+			chars[2].IDPrevious = CharacterID{SiteID: 9, Clock: 9}
+			return chars
+		}, "isn't in the document"},
+		{"next before", func(chars []Character) []Character { chars[3].IDNext = chars[1].ID; return chars }, "before it"},
+		{"swapped", func(chars []Character) []Character { chars[2], chars[3] = chars[3], chars[2]; return chars }, "after it"},
+	}
+	for _, tc := range tests {
+		corrupted := Document{Characters: tc.corrupt(append([]Character(nil), doc.Characters...))}
+		err := corrupted.Validate()
+		var v *ValidationError
+		if !errors.Is(err, ErrInvalidDocument) || !errors.As(err, &v) || !strings.Contains(err.Error(), tc.problem) {
+			t.Errorf("(%s) got %v, expected a problem with %q", tc.description, err, tc.problem)
+		}
+	}
+
+	// The cached content must match the characters.
+	stale := Document{Characters: append([]Character(nil), doc.Characters...), content: &contentCache{valid: true, length: len(doc.Characters), value: "stale"}}
+	if err := stale.Validate(); err == nil || !strings.Contains(err.Error(), "cached content") {
+		t.Errorf("got %v, expected the stale cached content reported", err)
+	}
+}
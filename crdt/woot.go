@@ -11,16 +11,30 @@ import (
 // Document is composed of characters.
 type Document struct {
 	Characters []Character
+
+	// content caches the visible content, once a method has built it, until an operation
+	// changes the document. It's shared with copies of the document, which also share its
+	// characters.
+	content *contentCache
+}
+
+// A contentCache holds the visible content of a document with length characters, if valid.
+type contentCache struct {
+	valid  bool
+	length int
+	value  string
 }
 
 // Character represents a character in the document.
 // As per section 3.1, Data Model in the paper (https://hal.inria.fr/inria-00108523/document)
+// IDPrevious and IDNext are the characters it was generated between, which don't change
+// when other characters are inserted around it.
 type Character struct {
-	ID         string
+	ID         CharacterID
 	Visible    bool
 	Value      string
-	IDPrevious string
-	IDNext     string
+	IDPrevious CharacterID
+	IDNext     CharacterID
 }
 
 var (
@@ -33,47 +47,122 @@ var (
 	LocalClock = 0
 
 	// CharacterStart is placed at the start.
-	CharacterStart = Character{ID: "start", Visible: false, Value: "", IDPrevious: "", IDNext: "end"}
+	CharacterStart = Character{ID: IDStart, Visible: false, Value: "", IDPrevious: CharacterID{}, IDNext: IDEnd}
 
 	// CharacterEnd is placed at the end.
-	CharacterEnd = Character{ID: "end", Visible: false, Value: "", IDPrevious: "start", IDNext: ""}
+	CharacterEnd = Character{ID: IDEnd, Visible: false, Value: "", IDPrevious: IDStart, IDNext: CharacterID{}}
+
+	// conflictStats holds the counters returned by Stats.
+	conflictStats ConflictStats
 
 	ErrPositionOutOfBounds = errors.New("position out of bounds")
 	ErrEmptyWCharacter     = errors.New("empty char ID provided")
 	ErrBoundsNotPresent    = errors.New("subsequence bound(s) not present")
 )
 
+// ConflictStats counts how inserts were integrated when other characters (for
+// example, concurrent inserts or deleted characters) were present between the
+// inserted character's neighbours.
+type ConflictStats struct {
+	// Conflicts is the number of inserts which required ordering against other characters.
+	Conflicts int
+
+	// Recursions is the number of recursive IntegrateInsert calls made to order inserts.
+	Recursions int
+
+	// MaxDepth is the deepest recursion reached by a single insert.
+	MaxDepth int
+
+	// InsertedWins is the number of times an inserted character was ordered before an
+	// existing character it was compared with.
+	InsertedWins int
+
+	// ExistingWins is the number of times an existing character was ordered before the
+	// inserted character it was compared with.
+	ExistingWins int
+
+	// WinsBySite counts the comparisons won by the characters of each site, keyed by site
+	// ID: a character wins a comparison when it's ordered before the other character.
+	WinsBySite map[int]int
+}
+
+// Stats returns the conflict counters for all inserts integrated since the program
+// started, or since the last call to ResetStats.
+func Stats() ConflictStats {
+	mu.Lock()
+	defer mu.Unlock()
+
+	stats := conflictStats
+	stats.WinsBySite = make(map[int]int, len(conflictStats.WinsBySite))
+	for site, wins := range conflictStats.WinsBySite {
+		stats.WinsBySite[site] = wins
+	}
+	return stats
+}
+
+// ResetStats resets the conflict counters.
+func ResetStats() {
+	mu.Lock()
+	conflictStats = ConflictStats{}
+	mu.Unlock()
+}
+
+// recordConflict updates the conflict counters for a recursive call, made at the given
+// depth, which orders char after bounded[1:i], and before bounded[i] unless that's the
+// last of bounded, which isn't compared.
+func recordConflict(depth int, char Character, bounded []Character, i int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if depth == 0 {
+		conflictStats.Conflicts++
+	}
+	conflictStats.Recursions++
+	if depth+1 > conflictStats.MaxDepth {
+		conflictStats.MaxDepth = depth + 1
+	}
+
+	if conflictStats.WinsBySite == nil {
+		conflictStats.WinsBySite = make(map[int]int)
+	}
+	for _, c := range bounded[1:i] {
+		conflictStats.WinsBySite[c.ID.SiteID]++
+	}
+	conflictStats.ExistingWins += i - 1
+	if i < len(bounded)-1 {
+		conflictStats.InsertedWins++
+		conflictStats.WinsBySite[char.ID.SiteID]++
+	}
+}
+
 // New returns an initialized document.
 func New() Document {
 	return Document{Characters: []Character{CharacterStart, CharacterEnd}}
 }
 
-// Load reads a text file from disk and converts it into a CRDT document.
+// Load reads a text file from disk and converts it into a CRDT document. Each rune
+// (not byte) of the file is stored as a separate character.
 func Load(fileName string) (Document, error) {
-	doc := New()
 	content, err := os.ReadFile(fileName)
 	if err != nil {
-		return doc, err
+		return New(), err
 	}
-	lines := strings.Split(string(content), "\n")
+	return FromText(string(content))
+}
+
+// FromText converts text into a new CRDT document, with each rune stored as a separate
+// character. The characters are attributed to the local site (SiteID), so FromText should
+// be called once the site ID is known.
+func FromText(text string) (Document, error) {
+	doc := New()
 	pos := 1
-	for i := 0; i < len(lines); i++ {
-		for j := 0; j < len(lines[i]); j++ {
-			_, err := doc.Insert(pos, string(lines[i][j]))
-			if err != nil {
-				return doc, err
-			}
-			pos++
-		}
-		if i < len(lines)-1 { // avoids insertion of '\n' on last line
-			_, err := doc.Insert(pos, "\n")
-			if err != nil {
-				return doc, err
-			}
-			pos++
+	for _, r := range text {
+		if _, err := doc.GenerateInsert(pos, string(r)); err != nil {
+			return doc, err
 		}
+		pos++
 	}
-	return doc, err
+	return doc, nil
 }
 
 // Save writes data to the named file, creating it if necessary. The contents of the file are overwritten.
@@ -91,20 +180,61 @@ func (doc *Document) SetText(newDoc Document) {
 		c := Character{ID: char.ID, Visible: char.Visible, Value: char.Value, IDPrevious: char.IDPrevious, IDNext: char.IDNext}
 		doc.Characters = append(doc.Characters, c)
 	}
+	doc.invalidate()
+}
+
+// Equal reports whether the documents have the same characters, regardless of what they've
+// cached.
+func (doc Document) Equal(other Document) bool {
+	if len(doc.Characters) != len(other.Characters) {
+		return false
+	}
+	for i, char := range doc.Characters {
+		if char != other.Characters[i] {
+			return false
+		}
+	}
+	return true
 }
 
 // Content returns the content of the document.
 func Content(doc Document) string {
-	value := ""
+	if c := doc.content; c != nil && c.valid && c.length == len(doc.Characters) {
+		return c.value
+	}
+
+	var b strings.Builder
 	for _, char := range doc.Characters {
 		if char.Visible {
-			value += char.Value
+			b.WriteString(char.Value)
 		}
 	}
-	return value
+	return b.String()
+}
+
+// cachedContent returns the content of the document, and caches it until the next
+// operation.
+func (doc *Document) cachedContent() string {
+	if doc.content == nil {
+		doc.content = &contentCache{}
+	}
+	c := doc.content
+	if !c.valid || c.length != len(doc.Characters) {
+		c.value = Content(*doc)
+		c.valid, c.length = true, len(doc.Characters)
+	}
+	return c.value
+}
+
+// invalidate forgets the cached content, after an operation.
+func (doc *Document) invalidate() {
+	if doc.content != nil {
+		doc.content.valid = false
+	}
 }
 
-// IthVisible returns the ith visible character in the document.
+// IthVisible returns the ith visible character in the document, or a character with the
+// zero ID if there isn't one.
 func IthVisible(doc Document, position int) Character {
 	count := 0
 
@@ -117,7 +247,7 @@ func IthVisible(doc Document, position int) Character {
 		}
 	}
 
-	return Character{ID: "-1"}
+	return Character{}
 }
 
 // Length returns the length of the document.
@@ -135,7 +265,7 @@ func (doc *Document) ElementAt(position int) (Character, error) {
 }
 
 // Position returns the position of the character.
-func (doc *Document) Position(charID string) int {
+func (doc *Document) Position(charID CharacterID) int {
 	for position, char := range doc.Characters {
 		if charID == char.ID {
 			return position + 1
@@ -145,7 +275,7 @@ func (doc *Document) Position(charID string) int {
 	return -1
 }
 
-func (doc *Document) Left(charID string) string {
+func (doc *Document) Left(charID CharacterID) CharacterID {
 	i := doc.Position(charID)
 	if i <= 0 {
 		return doc.Characters[i].ID
@@ -153,7 +283,7 @@ func (doc *Document) Left(charID string) string {
 	return doc.Characters[i-1].ID
 }
 
-func (doc *Document) Right(charID string) string {
+func (doc *Document) Right(charID CharacterID) CharacterID {
 	i := doc.Position(charID)
 	if i >= len(doc.Characters)-1 {
 		return doc.Characters[i-1].ID
@@ -161,21 +291,40 @@ func (doc *Document) Right(charID string) string {
 	return doc.Characters[i+1].ID
 }
 
+// visiblePosition returns the position of the character among the visible characters,
+// counted from 1, or 0 if it's hidden or not in the document.
+func (doc *Document) visiblePosition(charID CharacterID) int {
+	visible := 0
+	for _, char := range doc.Characters {
+		if char.Visible {
+			visible++
+		}
+		if char.ID == charID {
+			if !char.Visible {
+				return 0
+			}
+			return visible
+		}
+	}
+
+	return 0
+}
+
 // Contains checks if a character is present in the document.
-func (doc *Document) Contains(charID string) bool {
+func (doc *Document) Contains(charID CharacterID) bool {
 	position := doc.Position(charID)
 	return position != -1
 }
 
-// Find returns the character at the ID.
-func (doc *Document) Find(id string) Character {
+// Find returns the character at the ID, or a character with the zero ID if there isn't one.
+func (doc *Document) Find(id CharacterID) Character {
 	for _, char := range doc.Characters {
 		if char.ID == id {
 			return char
 		}
 	}
 
-	return Character{ID: "-1"}
+	return Character{}
 }
 
 // Subseq returns the content between the positions.
@@ -202,30 +351,42 @@ func (doc *Document) Subseq(wcharacterStart, wcharacterEnd Character) ([]Charact
 // Operations
 ///////////////
 
-// LocalInsert inserts the character into the document.
+// LocalInsert inserts the character into the document at the index position, between the
+// characters at indexes position-1 and position, so position must be between 1 and
+// Length()-1. The characters keep the IDPrevious and IDNext they were generated with, so
+// neither the character nor its neighbours are relinked.
 func (doc *Document) LocalInsert(char Character, position int) (*Document, error) {
 	if position <= 0 || position >= doc.Length() {
 		return doc, ErrPositionOutOfBounds
 	}
 
-	if char.ID == "" {
+	if char.ID.IsZero() {
 		return doc, ErrEmptyWCharacter
 	}
 
-	doc.Characters = append(doc.Characters[:position],
-		append([]Character{char}, doc.Characters[position:]...)...,
-	)
-
-	// Update next and previous pointers.
-	doc.Characters[position-1].IDNext = char.ID
-	doc.Characters[position+1].IDPrevious = char.ID
+	doc.Characters = append(doc.Characters, Character{})
+	copy(doc.Characters[position+1:], doc.Characters[position:])
+	doc.Characters[position] = char
+	doc.invalidate()
 
 	return doc, nil
 }
 
 // IntegrateInsert inserts the given Character into the Document
 // Characters based off of the previous & next Character
+//
+// Characters inserted concurrently between the same neighbours are ordered by ID, as in
+// section 3.3 of the paper: the character is compared only with the characters between
+// charPrev and charNext which were generated between them (or around them), and is then
+// integrated recursively between the two it falls between, so the characters generated
+// between those are ordered alike by every site, whichever order it receives them in.
 func (doc *Document) IntegrateInsert(char, charPrev, charNext Character) (*Document, error) {
+	return doc.integrateInsert(char, charPrev, charNext, 0)
+}
+
+// integrateInsert implements IntegrateInsert. depth is the number of recursive calls
+// made so far, and is used for the conflict counters.
+func (doc *Document) integrateInsert(char, charPrev, charNext Character, depth int) (*Document, error) {
 	// Get the subsequence.
 
 	// panic happens when charPrev > charNext
@@ -243,24 +404,49 @@ func (doc *Document) IntegrateInsert(char, charPrev, charNext Character) (*Docum
 		return doc.LocalInsert(char, position)
 	}
 
-	// If one character is present in the subseqence, insert at previous position.
-	if len(subsequence) == 1 {
-		return doc.LocalInsert(char, position-1)
+	// Otherwise, order the character by ID among the characters in the subsequence whose own
+	// previous and next characters are outside it, bounded by its neighbours, and make a
+	// recursive call. The other characters were generated between two of those, and are
+	// ordered among them by the call.
+	inside := make(map[CharacterID]bool, len(subsequence))
+	for _, c := range subsequence {
+		inside[c.ID] = true
+	}
+	bounded := make([]Character, 0, len(subsequence)+2)
+	bounded = append(bounded, charPrev)
+	for _, c := range subsequence {
+		if !inside[c.IDPrevious] && !inside[c.IDNext] {
+			bounded = append(bounded, c)
+		}
+	}
+	if len(bounded) == 1 {
+		// Some of the characters were generated between charPrev and charNext, unless the
+		// document is corrupted, and the recursion wouldn't end.
+		return doc, fmt.Errorf("%w: none of the characters between %v and %v were inserted between them", ErrInvalidDocument, charPrev.ID, charNext.ID)
 	}
+	bounded = append(bounded, charNext)
 
-	// Make a recursive call.
 	i := 1
-	for i < len(subsequence)-1 && subsequence[i].ID < char.ID {
+	for i < len(bounded)-1 && bounded[i].ID.Compare(char.ID) < 0 {
 		i++
 	}
-	return doc.IntegrateInsert(char, subsequence[i-1], subsequence[i])
+	recordConflict(depth, char, bounded, i)
+	return doc.integrateInsert(char, bounded[i-1], bounded[i], depth+1)
 }
 
 // GenerateInsert generates a character for a given value.
 func (doc *Document) GenerateInsert(position int, value string) (*Document, error) {
-	// Increment local clock.
+	_, err := doc.generateInsert(position, value)
+	return doc, err
+}
+
+// generateInsert implements GenerateInsert, and returns the character generated.
+func (doc *Document) generateInsert(position int, value string) (Character, error) {
+	// Increment local clock. It's read under the lock too, as other documents may be
+	// generating characters at the same time, e.g. for the rooms of a server.
 	mu.Lock()
 	LocalClock++
+	id := CharacterID{SiteID: SiteID, Clock: LocalClock}
 	mu.Unlock()
 
 	// Get previous and next characters.
@@ -268,22 +454,48 @@ func (doc *Document) GenerateInsert(position int, value string) (*Document, erro
 	charNext := IthVisible(*doc, position)
 
 	// Use defaults.
-	if charPrev.ID == "-1" {
-		charPrev = doc.Find("start")
+	if charPrev.ID.IsZero() {
+		charPrev = doc.Find(IDStart)
 	}
-	if charNext.ID == "-1" {
-		charNext = doc.Find("end")
+	if charNext.ID.IsZero() {
+		charNext = doc.Find(IDEnd)
 	}
 
 	char := Character{
-		ID:         fmt.Sprint(SiteID) + fmt.Sprint(LocalClock),
+		ID:         id,
 		Visible:    true,
 		Value:      value,
 		IDPrevious: charPrev.ID,
 		IDNext:     charNext.ID,
 	}
 
-	return doc.IntegrateInsert(char, charPrev, charNext)
+	_, err := doc.IntegrateInsert(char, charPrev, charNext)
+	return char, err
+}
+
+// AdvanceClock advances LocalClock to clock, if it's behind, e.g. to continue from the clock
+// saved with a document.
+func AdvanceClock(clock int) {
+	mu.Lock()
+	defer mu.Unlock()
+	if clock > LocalClock {
+		LocalClock = clock
+	}
+}
+
+// SyncClock advances LocalClock past the clocks of the characters of doc whose IDs the
+// local site could have generated, so GenerateInsert doesn't generate their IDs again.
+// Site IDs can be reused (by a client reconnecting, or by a restarted server), so it
+// should be called whenever SiteID changes, or the local document is replaced.
+func SyncClock(doc Document) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, char := range doc.Characters {
+		if char.ID.SiteID == SiteID && char.ID.Clock > LocalClock {
+			LocalClock = char.ID.Clock
+		}
+	}
 }
 
 // IntegrateDelete finds a character and marks it for deletion.
@@ -295,6 +507,7 @@ func (doc *Document) IntegrateDelete(char Character) *Document {
 
 	// This is how deletion is done.
 	doc.Characters[position-1].Visible = false
+	doc.invalidate()
 
 	return doc
 }
@@ -312,13 +525,94 @@ func (doc *Document) GenerateDelete(position int) *Document {
 func (doc *Document) Insert(position int, value string) (string, error) {
 	newDoc, err := doc.GenerateInsert(position, value)
 	if err != nil {
-		return Content(*doc), err
+		return doc.cachedContent(), err
 	}
 
-	return Content(*newDoc), nil
+	return newDoc.cachedContent(), nil
 }
 
 func (doc *Document) Delete(position int) string {
 	newDoc := doc.GenerateDelete(position)
-	return Content(*newDoc)
+	return newDoc.cachedContent()
+}
+
+// InsertRange inserts the runes of value one at a time, so each rune is stored as a
+// separate character, as the other sites expect.
+func (doc *Document) InsertRange(position int, value string) (string, error) {
+	for i, r := range []rune(value) {
+		if _, err := doc.GenerateInsert(position+i, string(r)); err != nil {
+			return doc.cachedContent(), err
+		}
+	}
+
+	return doc.cachedContent(), nil
+}
+
+func (doc *Document) DeleteRange(position, n int) string {
+	// The characters after a deleted one move back, so each is deleted at position.
+	for i := 0; i < n; i++ {
+		doc.GenerateDelete(position)
+	}
+
+	return doc.cachedContent()
+}
+
+// ApplyRemote applies an insert or delete received from another site (see Operation.Apply).
+// Deleting a position past the end of the document does nothing.
+func (doc *Document) ApplyRemote(op Operation) error {
+	_, err := op.Apply(doc)
+	return err
+}
+
+func (doc *Document) Snapshot() CRDT {
+	return &Document{Characters: append([]Character(nil), doc.Characters...)}
+}
+
+// Merge integrates the characters of other, which must be a *Document, missing from doc,
+// and hides the characters deleted in other. Each missing character is integrated between
+// its nearest neighbours in other which doc has, as the replicas order the characters they
+// both have alike.
+func (doc *Document) Merge(other CRDT) error {
+	o, ok := other.(*Document)
+	if !ok {
+		return fmt.Errorf("%w: %T isn't a *crdt.Document", ErrIncompatibleMerge, other)
+	}
+
+	present := make(map[CharacterID]bool, len(doc.Characters))
+	for _, char := range doc.Characters {
+		present[char.ID] = true
+	}
+	if !present[IDStart] || !present[IDEnd] || len(o.Characters) < 2 || o.Characters[0].ID != IDStart {
+		return fmt.Errorf("%w: missing the start or end character", ErrIncompatibleMerge)
+	}
+
+	prev := o.Characters[0]
+	for i, char := range o.Characters {
+		if present[char.ID] {
+			prev = char
+			continue
+		}
+
+		next := o.Characters[len(o.Characters)-1]
+		for _, c := range o.Characters[i+1:] {
+			if present[c.ID] {
+				next = c
+				break
+			}
+		}
+		if _, err := doc.IntegrateInsert(char, doc.Find(prev.ID), doc.Find(next.ID)); err != nil {
+			return fmt.Errorf("%w: integrating %v: %v", ErrIncompatibleMerge, char.ID, err)
+		}
+		present[char.ID] = true
+		prev = char
+	}
+
+	for _, char := range o.Characters {
+		if !char.Visible && char.ID != IDStart && char.ID != IDEnd {
+			doc.IntegrateDelete(char)
+		}
+	}
+
+	SyncClock(*doc)
+	return nil
 }
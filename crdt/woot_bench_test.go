@@ -0,0 +1,164 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// benchSizes are the document sizes (in characters) used by the benchmarks.
+var benchSizes = []int{10_000, 100_000, 1_000_000}
+
+// benchSite is the site ID of the characters of benchDocument, which is distinct from the
+// local site's, so the benchmarks' inserts don't generate the same IDs.
+const benchSite = 1000
+
+// benchDocument returns a document containing n visible characters. The document is
+// built directly, since generating it with n inserts would take too long for large n.
+func benchDocument(n int) Document {
+	chars := make([]Character, 0, n+2)
+	chars = append(chars, Character{ID: IDStart, IDNext: benchID(0)})
+
+	for i := 0; i < n; i++ {
+		prev, next := benchID(i-1), benchID(i+1)
+		if i == 0 {
+			prev = IDStart
+		}
+		if i == n-1 {
+			next = IDEnd
+		}
+		chars = append(chars, Character{
+			ID:         benchID(i),
+			Visible:    true,
+			Value:      string(rune('a' + i%26)),
+			IDPrevious: prev,
+			IDNext:     next,
+		})
+	}
+
+	chars = append(chars, Character{ID: IDEnd, IDPrevious: benchID(n - 1)})
+	return Document{Characters: chars}
+}
+
+// benchID returns the ID of the ith character of benchDocument.
+func benchID(i int) CharacterID {
+	return CharacterID{SiteID: benchSite, Clock: i + 1}
+}
+
+func BenchmarkInsertAtEnd(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("chars=%d", n), func(b *testing.B) {
+			doc := benchDocument(n)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := doc.GenerateInsert(n+i+1, "x"); err != nil {
+					b.Fatalf("error: %v\n", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkInsertAtRandom(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("chars=%d", n), func(b *testing.B) {
+			doc := benchDocument(n)
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := doc.GenerateInsert(r.Intn(n+i)+1, "x"); err != nil {
+					b.Fatalf("error: %v\n", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDelete(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("chars=%d", n), func(b *testing.B) {
+			doc := benchDocument(n)
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				// Start over once every character has been deleted.
+				if i%n == 0 && i > 0 {
+					b.StopTimer()
+					doc = benchDocument(n)
+					b.StartTimer()
+				}
+				doc.GenerateDelete(r.Intn(n-i%n) + 1)
+			}
+		})
+	}
+}
+
+func BenchmarkContent(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("chars=%d", n), func(b *testing.B) {
+			doc := benchDocument(n)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				_ = Content(doc)
+			}
+		})
+	}
+}
+
+// BenchmarkInsertContent inserts a character and reads the content, as the client does
+// for each key, so the content built by Insert is cached for Content.
+func BenchmarkInsertContent(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("chars=%d", n), func(b *testing.B) {
+			doc := benchDocument(n)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := doc.Insert(n+i+1, "x"); err != nil {
+					b.Fatalf("error: %v\n", err)
+				}
+				_ = Content(doc)
+			}
+		})
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("chars=%d", n), func(b *testing.B) {
+			doc := benchDocument(n)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := json.Marshal(doc); err != nil {
+					b.Fatalf("error: %v\n", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("chars=%d", n), func(b *testing.B) {
+			data, err := json.Marshal(benchDocument(n))
+			if err != nil {
+				b.Fatalf("error: %v\n", err)
+			}
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				var doc Document
+				if err := json.Unmarshal(data, &doc); err != nil {
+					b.Fatalf("error: %v\n", err)
+				}
+			}
+		})
+	}
+}
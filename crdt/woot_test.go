@@ -1,7 +1,11 @@
 package crdt
 
 import (
+	"errors"
+	"math/rand"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -35,9 +39,9 @@ func TestInsert(t *testing.T) {
 	// Generate document for equality assertion.
 	wantDoc := &Document{
 		Characters: []Character{
-			{ID: "start", Visible: false, Value: "", IDPrevious: "", IDNext: "end"},
-			{ID: "1", Visible: true, Value: "a", IDPrevious: "start", IDNext: "end"},
-			{ID: "end", Visible: false, Value: "", IDPrevious: "1", IDNext: ""},
+			{ID: IDStart, Visible: false, Value: "", IDPrevious: CharacterID{}, IDNext: IDEnd},
+			{ID: CharacterID{Clock: 1}, Visible: true, Value: "a", IDPrevious: IDStart, IDNext: IDEnd},
+			{ID: IDEnd, Visible: false, Value: "", IDPrevious: CharacterID{Clock: 1}, IDNext: CharacterID{}},
 		},
 	}
 
@@ -55,18 +59,18 @@ func TestIntegrateInsert_SamePosition(t *testing.T) {
 	// Generate a test document.
 	doc := &Document{
 		Characters: []Character{
-			{ID: "start", Visible: false, Value: "", IDPrevious: "", IDNext: "1"},
-			{ID: "1", Visible: false, Value: "e", IDPrevious: "start", IDNext: "2"},
-			{ID: "2", Visible: false, Value: "n", IDPrevious: "1", IDNext: "end"},
-			{ID: "end", Visible: false, Value: "", IDPrevious: "2", IDNext: ""},
+			{ID: IDStart, Visible: false, Value: "", IDPrevious: CharacterID{}, IDNext: CharacterID{Clock: 1}},
+			{ID: CharacterID{Clock: 1}, Visible: false, Value: "e", IDPrevious: IDStart, IDNext: CharacterID{Clock: 2}},
+			{ID: CharacterID{Clock: 2}, Visible: false, Value: "n", IDPrevious: CharacterID{Clock: 1}, IDNext: IDEnd},
+			{ID: IDEnd, Visible: false, Value: "", IDPrevious: CharacterID{Clock: 2}, IDNext: CharacterID{}},
 		},
 	}
 
 	// Insert a new character at the start. (IDPrevious = start)
-	newChar := Character{ID: "3", Visible: false, Value: "b", IDPrevious: "start", IDNext: "1"}
+	newChar := Character{ID: CharacterID{Clock: 3}, Visible: false, Value: "b", IDPrevious: IDStart, IDNext: CharacterID{Clock: 1}}
 
-	charPrev := Character{ID: "start", Visible: false, Value: "", IDPrevious: "", IDNext: "1"}
-	charNext := Character{ID: "1", Visible: false, Value: "e", IDPrevious: "start", IDNext: "2"}
+	charPrev := Character{ID: IDStart, Visible: false, Value: "", IDPrevious: CharacterID{}, IDNext: CharacterID{Clock: 1}}
+	charNext := Character{ID: CharacterID{Clock: 1}, Visible: false, Value: "e", IDPrevious: IDStart, IDNext: CharacterID{Clock: 2}}
 
 	// Perform insertion.
 	content, err := doc.IntegrateInsert(newChar, charPrev, charNext)
@@ -77,11 +81,11 @@ func TestIntegrateInsert_SamePosition(t *testing.T) {
 	// This should be the final representation of the document.
 	wantDoc := &Document{
 		Characters: []Character{
-			{ID: "start", Visible: false, Value: "", IDPrevious: "", IDNext: "3"},
-			{ID: "3", Visible: false, Value: "b", IDPrevious: "start", IDNext: "1"},
-			{ID: "1", Visible: false, Value: "e", IDPrevious: "3", IDNext: "2"},
-			{ID: "2", Visible: false, Value: "n", IDPrevious: "1", IDNext: "end"},
-			{ID: "end", Visible: false, Value: "", IDPrevious: "2", IDNext: ""},
+			{ID: IDStart, Visible: false, Value: "", IDPrevious: CharacterID{}, IDNext: CharacterID{Clock: 1}},
+			{ID: CharacterID{Clock: 3}, Visible: false, Value: "b", IDPrevious: IDStart, IDNext: CharacterID{Clock: 1}},
+			{ID: CharacterID{Clock: 1}, Visible: false, Value: "e", IDPrevious: IDStart, IDNext: CharacterID{Clock: 2}},
+			{ID: CharacterID{Clock: 2}, Visible: false, Value: "n", IDPrevious: CharacterID{Clock: 1}, IDNext: IDEnd},
+			{ID: IDEnd, Visible: false, Value: "", IDPrevious: CharacterID{Clock: 2}, IDNext: CharacterID{}},
 		},
 	}
 
@@ -99,18 +103,18 @@ func TestIntegrateInsert_BetweenTwoPositions(t *testing.T) {
 	// Generate a test document.
 	doc := &Document{
 		Characters: []Character{
-			{ID: "start", Visible: false, Value: "", IDPrevious: "", IDNext: "1"},
-			{ID: "1", Visible: false, Value: "c", IDPrevious: "start", IDNext: "2"},
-			{ID: "2", Visible: false, Value: "t", IDPrevious: "1", IDNext: "end"},
-			{ID: "end", Visible: false, Value: "", IDPrevious: "2", IDNext: ""},
+			{ID: IDStart, Visible: false, Value: "", IDPrevious: CharacterID{}, IDNext: CharacterID{Clock: 1}},
+			{ID: CharacterID{Clock: 1}, Visible: false, Value: "c", IDPrevious: IDStart, IDNext: CharacterID{Clock: 2}},
+			{ID: CharacterID{Clock: 2}, Visible: false, Value: "t", IDPrevious: CharacterID{Clock: 1}, IDNext: IDEnd},
+			{ID: IDEnd, Visible: false, Value: "", IDPrevious: CharacterID{Clock: 2}, IDNext: CharacterID{}},
 		},
 	}
 
 	// Insert a new character between <"1", "c"> and <"2", "t">.
-	newChar := Character{ID: "3", Visible: false, Value: "a", IDPrevious: "1", IDNext: "2"}
+	newChar := Character{ID: CharacterID{Clock: 3}, Visible: false, Value: "a", IDPrevious: CharacterID{Clock: 1}, IDNext: CharacterID{Clock: 2}}
 
-	charPrev := Character{ID: "1", Visible: false, Value: "c", IDPrevious: "start", IDNext: "2"}
-	charNext := Character{ID: "2", Visible: false, Value: "t", IDPrevious: "1", IDNext: "end"}
+	charPrev := Character{ID: CharacterID{Clock: 1}, Visible: false, Value: "c", IDPrevious: IDStart, IDNext: CharacterID{Clock: 2}}
+	charNext := Character{ID: CharacterID{Clock: 2}, Visible: false, Value: "t", IDPrevious: CharacterID{Clock: 1}, IDNext: IDEnd}
 
 	// Perform insertion.
 	content, err := doc.IntegrateInsert(newChar, charPrev, charNext)
@@ -121,11 +125,11 @@ func TestIntegrateInsert_BetweenTwoPositions(t *testing.T) {
 	// This should be the final representation of the document.
 	wantDoc := &Document{
 		Characters: []Character{
-			{ID: "start", Visible: false, Value: "", IDPrevious: "", IDNext: "1"},
-			{ID: "1", Visible: false, Value: "c", IDPrevious: "start", IDNext: "3"},
-			{ID: "3", Visible: false, Value: "a", IDPrevious: "1", IDNext: "2"},
-			{ID: "2", Visible: false, Value: "t", IDPrevious: "3", IDNext: "end"},
-			{ID: "end", Visible: false, Value: "", IDPrevious: "2", IDNext: ""},
+			{ID: IDStart, Visible: false, Value: "", IDPrevious: CharacterID{}, IDNext: CharacterID{Clock: 1}},
+			{ID: CharacterID{Clock: 1}, Visible: false, Value: "c", IDPrevious: IDStart, IDNext: CharacterID{Clock: 2}},
+			{ID: CharacterID{Clock: 3}, Visible: false, Value: "a", IDPrevious: CharacterID{Clock: 1}, IDNext: CharacterID{Clock: 2}},
+			{ID: CharacterID{Clock: 2}, Visible: false, Value: "t", IDPrevious: CharacterID{Clock: 1}, IDNext: IDEnd},
+			{ID: IDEnd, Visible: false, Value: "", IDPrevious: CharacterID{Clock: 2}, IDNext: CharacterID{}},
 		},
 	}
 
@@ -142,15 +146,15 @@ func TestLoad(t *testing.T) {
 	// create test doc
 	doc := &Document{
 		Characters: []Character{
-			{ID: "start", Visible: false, Value: "", IDPrevious: "", IDNext: "1"},
-			{ID: "1", Visible: true, Value: "c", IDPrevious: "start", IDNext: "3"},
-			{ID: "3", Visible: true, Value: "a", IDPrevious: "1", IDNext: "2"},
-			{ID: "2", Visible: true, Value: "t", IDPrevious: "3", IDNext: "4"},
-			{ID: "4", Visible: true, Value: "\n", IDPrevious: "2", IDNext: "5"},
-			{ID: "5", Visible: true, Value: "d", IDPrevious: "4", IDNext: "6"},
-			{ID: "6", Visible: true, Value: "o", IDPrevious: "5", IDNext: "7"},
-			{ID: "7", Visible: true, Value: "g", IDPrevious: "6", IDNext: "end"},
-			{ID: "end", Visible: false, Value: "", IDPrevious: "7", IDNext: ""},
+			{ID: IDStart, Visible: false, Value: "", IDPrevious: CharacterID{}, IDNext: CharacterID{Clock: 1}},
+			{ID: CharacterID{Clock: 1}, Visible: true, Value: "c", IDPrevious: IDStart, IDNext: CharacterID{Clock: 3}},
+			{ID: CharacterID{Clock: 3}, Visible: true, Value: "a", IDPrevious: CharacterID{Clock: 1}, IDNext: CharacterID{Clock: 2}},
+			{ID: CharacterID{Clock: 2}, Visible: true, Value: "t", IDPrevious: CharacterID{Clock: 3}, IDNext: CharacterID{Clock: 4}},
+			{ID: CharacterID{Clock: 4}, Visible: true, Value: "\n", IDPrevious: CharacterID{Clock: 2}, IDNext: CharacterID{Clock: 5}},
+			{ID: CharacterID{Clock: 5}, Visible: true, Value: "d", IDPrevious: CharacterID{Clock: 4}, IDNext: CharacterID{Clock: 6}},
+			{ID: CharacterID{Clock: 6}, Visible: true, Value: "o", IDPrevious: CharacterID{Clock: 5}, IDNext: CharacterID{Clock: 7}},
+			{ID: CharacterID{Clock: 7}, Visible: true, Value: "g", IDPrevious: CharacterID{Clock: 6}, IDNext: IDEnd},
+			{ID: IDEnd, Visible: false, Value: "", IDPrevious: CharacterID{Clock: 7}, IDNext: CharacterID{}},
 		},
 	}
 
@@ -179,3 +183,448 @@ func TestLoad(t *testing.T) {
 		t.Errorf("got != want; diff = %v\n", cmp.Diff(got, want))
 	}
 }
+
+// TestLoad_MultiByte verifies that multi-byte characters are loaded as single characters.
+func TestLoad_MultiByte(t *testing.T) {
+	content := "日本語\nnaïve 🙂"
+
+	tmp, err := os.CreateTemp("", "ex")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(content); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	tmp.Close()
+
+	doc, err := Load(tmp.Name())
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	if got, want := Content(doc), content; got != want {
+		t.Errorf("got != want; diff = %v\n", cmp.Diff(got, want))
+	}
+
+	// Each rune is a character, in addition to the start and end characters.
+	if got, want := doc.Length(), len([]rune(content))+2; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+}
+
+// TestStats verifies that the conflict counters are updated when an insert has to be
+// ordered against characters between its neighbours.
+func TestStats(t *testing.T) {
+	ResetStats()
+	defer ResetStats()
+
+	// "a", "b" and "c" were inserted concurrently, between the start and end characters.
+	doc := &Document{
+		Characters: []Character{
+			CharacterStart,
+			{ID: CharacterID{SiteID: 1, Clock: 1}, Visible: false, Value: "a", IDPrevious: IDStart, IDNext: IDEnd},
+			{ID: CharacterID{SiteID: 1, Clock: 2}, Visible: false, Value: "b", IDPrevious: IDStart, IDNext: IDEnd},
+			{ID: CharacterID{SiteID: 3, Clock: 3}, Visible: false, Value: "c", IDPrevious: IDStart, IDNext: IDEnd},
+			CharacterEnd,
+		},
+	}
+
+	newChar := Character{ID: CharacterID{SiteID: 2, Clock: 5}, Visible: true, Value: "x", IDPrevious: IDStart, IDNext: IDEnd}
+	if _, err := doc.IntegrateInsert(newChar, doc.Characters[0], doc.Characters[4]); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	got := Stats()
+	want := ConflictStats{Conflicts: 1, Recursions: 1, MaxDepth: 1, InsertedWins: 1, ExistingWins: 2, WinsBySite: map[int]int{1: 2, 2: 1}}
+
+	if !cmp.Equal(got, want) {
+		t.Errorf("got != want; diff = %v\n", cmp.Diff(got, want))
+	}
+}
+
+// TestFromText verifies that imported characters are attributed to the local site.
+func TestFromText(t *testing.T) {
+	prevSiteID, prevClock := SiteID, LocalClock
+	defer func() { SiteID, LocalClock = prevSiteID, prevClock }()
+	SiteID, LocalClock = 7, 0
+
+	doc, err := FromText("hi\n")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	if got, want := Content(doc), "hi\n"; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+
+	for _, char := range doc.Characters[1 : len(doc.Characters)-1] {
+		if char.ID.SiteID != 7 {
+			t.Errorf("character %q has ID %q, expected it to be attributed to site 7\n", char.Value, char.ID)
+		}
+	}
+}
+
+// TestSyncClock verifies that the local site doesn't generate the IDs of characters it
+// generated before its clock was reset.
+func TestSyncClock(t *testing.T) {
+	prevSiteID, prevClock := SiteID, LocalClock
+	defer func() { SiteID, LocalClock = prevSiteID, prevClock }()
+	SiteID, LocalClock = 7, 0
+
+	doc, err := FromText("hello")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	LocalClock = 0
+	SyncClock(doc)
+	if LocalClock != 5 {
+		t.Errorf("got clock %v, expected = %v\n", LocalClock, 5)
+	}
+
+	// A restored clock which is behind doesn't move the clock back.
+	AdvanceClock(3)
+	if LocalClock != 5 {
+		t.Errorf("got clock %v, expected = %v\n", LocalClock, 5)
+	}
+
+	if _, err := doc.Insert(6, "!"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	ids := make(map[CharacterID]bool)
+	for _, char := range doc.Characters {
+		if ids[char.ID] {
+			t.Errorf("duplicate character ID %q\n", char.ID)
+		}
+		ids[char.ID] = true
+	}
+}
+
+// TestFromText_Concurrent verifies that documents generated at the same time, as for the
+// rooms of a server, don't get characters with the same IDs.
+func TestFromText_Concurrent(t *testing.T) {
+	prevSiteID, prevClock := SiteID, LocalClock
+	defer func() { SiteID, LocalClock = prevSiteID, prevClock }()
+	SiteID, LocalClock = 7, 0
+
+	docs := make([]Document, 8)
+	var wg sync.WaitGroup
+	for i := range docs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			docs[i], _ = FromText(strings.Repeat("hello world\n", 20))
+		}(i)
+	}
+	wg.Wait()
+
+	ids := make(map[CharacterID]bool)
+	for _, doc := range docs {
+		for _, char := range doc.Characters[1 : len(doc.Characters)-1] {
+			if ids[char.ID] {
+				t.Errorf("duplicate character ID %q\n", char.ID)
+			}
+			ids[char.ID] = true
+		}
+	}
+}
+
+// TestRanges verifies that ranges are inserted one character per rune, and deleted, and
+// that remote operations are applied.
+func TestRanges(t *testing.T) {
+	doc := New()
+
+	content, err := doc.InsertRange(1, "héllo")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if got, want := content, "héllo"; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+	if got, want := doc.Length(), 7; got != want {
+		t.Errorf("got %v characters, expected = %v\n", got, want)
+	}
+
+	if got, want := doc.DeleteRange(2, 2), "hlo"; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+
+	// Characters past the end of the document are ignored.
+	if got, want := doc.DeleteRange(3, 5), "hl"; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+
+	for _, op := range []Operation{{Type: "insert", Position: 2, Value: "ee"}, {Type: "delete", Position: 1}, {Type: "delete", Position: 10}} {
+		if err := doc.ApplyRemote(op); err != nil {
+			t.Errorf("applying %+v: %v\n", op, err)
+		}
+	}
+	if got, want := Content(doc), "eel"; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+
+	if err := doc.ApplyRemote(Operation{Type: "move"}); !errors.Is(err, ErrUnknownOperation) {
+		t.Errorf("got error %v, expected = %v\n", err, ErrUnknownOperation)
+	}
+}
+
+// TestMerge verifies that replicas edited separately converge once merged, in either order.
+func TestMerge(t *testing.T) {
+	prevSiteID, prevClock := SiteID, LocalClock
+	defer func() { SiteID, LocalClock = prevSiteID, prevClock }()
+	SiteID, LocalClock = 1, 0
+
+	a, err := FromText("cat")
+	if err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	b := a.Snapshot().(*Document)
+
+	if _, err := a.InsertRange(4, "s"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	a.Delete(1)
+
+	// The snapshot doesn't change with the document.
+	if got, want := Content(*b), "cat"; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+
+	SiteID = 2
+	if _, err := b.InsertRange(2, "oa"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+
+	c := a.Snapshot()
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if err := b.Merge(c); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if got, want := Content(a), "oaats"; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+	if diff := cmp.Diff(a.Characters, b.Characters); diff != "" {
+		t.Errorf("replicas differ after merging (-a +b):\n%s", diff)
+	}
+
+	// Merging again changes nothing.
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if got, want := a.Length(), b.Length(); got != want {
+		t.Errorf("got %v characters, expected = %v\n", got, want)
+	}
+
+	// A document without the start and end characters can't be merged.
+	if err := a.Merge(&Document{}); !errors.Is(err, ErrIncompatibleMerge) {
+		t.Errorf("got error %v, expected = %v\n", err, ErrIncompatibleMerge)
+	}
+}
+
+// TestContentCache verifies that the content cached by the operations is updated by the
+// next operation, and isn't used by snapshots which change separately.
+func TestContentCache(t *testing.T) {
+	doc := New()
+	if _, err := doc.InsertRange(1, "abc"); err != nil {
+		t.Fatalf("error: %v\n", err)
+	}
+	if got, want := Content(doc), "abc"; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+
+	snapshot := doc.Snapshot().(*Document)
+	doc.IntegrateDelete(IthVisible(doc, 2))
+	if got, want := Content(doc), "ac"; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+
+	if got, want := snapshot.Delete(1), "bc"; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+	if got, want := Content(doc), "ac"; got != want {
+		t.Errorf("got != want; got = %v, expected = %v\n", got, want)
+	}
+}
+
+// TestLocalInsert checks that characters are inserted at the head, in the middle and at the
+// tail of a document, and next to tombstones, keeping the links they were generated with,
+// and that positions outside the start and end characters are rejected.
+func TestLocalInsert(t *testing.T) {
+	id := func(clock int) CharacterID { return CharacterID{SiteID: 1, Clock: clock} }
+	char := func(clock int, value string, prev, next CharacterID) Character {
+		return Character{ID: id(clock), Visible: true, Value: value, IDPrevious: prev, IDNext: next}
+	}
+
+	doc := New()
+	for _, tc := range []struct {
+		description string
+		char        Character
+		position    int
+		content     string
+	}{
+		{"into an empty document", char(1, "b", IDStart, IDEnd), 1, "b"},
+		{"at the head", char(2, "a", IDStart, id(1)), 1, "ab"},
+		{"at the tail", char(3, "d", id(1), IDEnd), 3, "abd"},
+		{"in the middle", char(4, "c", id(1), id(3)), 3, "abcd"},
+	} {
+		if _, err := doc.LocalInsert(tc.char, tc.position); err != nil {
+			t.Fatalf("(%s) error: %v", tc.description, err)
+		}
+		if got := Content(doc); got != tc.content {
+			t.Errorf("(%s) got %q, expected %q", tc.description, got, tc.content)
+		}
+		if got := doc.Characters[tc.position]; got != tc.char {
+			t.Errorf("(%s) got %+v at %d, expected %+v", tc.description, got, tc.position, tc.char)
+		}
+	}
+
+	// The characters around the inserted ones keep their links too.
+	if got, want := doc.Find(id(1)), char(1, "b", IDStart, IDEnd); got != want {
+		t.Errorf("got %+v, expected %+v", got, want)
+	}
+	if got, want := doc.Find(IDStart), CharacterStart; got != want {
+		t.Errorf("got %+v, expected %+v", got, want)
+	}
+
+	// Next to tombstones, the character is inserted between them, and keeps the links to the
+	// visible characters it was generated between.
+	doc.DeleteRange(2, 2)
+	x := char(5, "x", id(2), id(3))
+	if _, err := doc.LocalInsert(x, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := Content(doc); got != "axd" {
+		t.Errorf("got %q, expected %q", got, "axd")
+	}
+	if got := doc.Find(x.ID); got != x {
+		t.Errorf("got %+v, expected %+v", got, x)
+	}
+	if err := doc.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	length := doc.Length()
+	for _, position := range []int{0, length, -1} {
+		if _, err := doc.LocalInsert(char(6, "y", IDStart, IDEnd), position); !errors.Is(err, ErrPositionOutOfBounds) {
+			t.Errorf("position %d: got %v, expected %v", position, err, ErrPositionOutOfBounds)
+		}
+	}
+	if _, err := doc.LocalInsert(Character{Value: "y"}, 1); !errors.Is(err, ErrEmptyWCharacter) {
+		t.Errorf("got %v, expected %v", err, ErrEmptyWCharacter)
+	}
+	if got := doc.Length(); got != length {
+		t.Errorf("got %d characters after the rejected inserts, expected %d", got, length)
+	}
+}
+
+// TestIntegrateInsert_Subsequence checks that an insert is only ordered against the
+// characters generated between the same neighbours, not against the characters inserted
+// between those. Site 1 inserts "b", then site 3 inserts "a" before it, while site 2 inserts
+// "c" concurrently: comparing 2.1 with 3.1 as well would place "c" first on the sites which
+// receive "a" before "c", and last on the others.
+func TestIntegrateInsert_Subsequence(t *testing.T) {
+	b := Character{ID: CharacterID{SiteID: 1, Clock: 1}, Visible: true, Value: "b", IDPrevious: IDStart, IDNext: IDEnd}
+	a := Character{ID: CharacterID{SiteID: 3, Clock: 1}, Visible: true, Value: "a", IDPrevious: IDStart, IDNext: b.ID}
+	c := Character{ID: CharacterID{SiteID: 2, Clock: 1}, Visible: true, Value: "c", IDPrevious: IDStart, IDNext: IDEnd}
+
+	for _, order := range [][]Character{{b, a, c}, {b, c, a}, {c, b, a}} {
+		doc := New()
+		for _, char := range order {
+			if _, err := doc.IntegrateInsert(char, doc.Find(char.IDPrevious), doc.Find(char.IDNext)); err != nil {
+				t.Fatalf("integrating %v: %v", char.ID, err)
+			}
+		}
+		if got, want := Content(doc), "abc"; got != want {
+			t.Errorf("(order %v, %v, %v) got %q, expected %q", order[0].ID, order[1].ID, order[2].ID, got, want)
+		}
+	}
+}
+
+// TestIntegrateInsert_Converge checks that sites inserting and deleting concurrently end up
+// with the same document, whatever order they receive each other's operations in.
+func TestIntegrateInsert_Converge(t *testing.T) {
+	prevSiteID, prevClock := SiteID, LocalClock
+	defer func() { SiteID, LocalClock = prevSiteID, prevClock }()
+
+	// An op is an insert, or, if deleted is set, a delete, of a character.
+	type op struct {
+		char    Character
+		deleted bool
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for run := 0; run < 200; run++ {
+		SiteID, LocalClock = 1, 0
+		base, err := FromText("ab")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sites := []int{1, 2, 3}
+		replicas := map[int]*Document{}
+		clocks := map[int]int{}
+		for _, site := range sites {
+			replicas[site] = base.Snapshot().(*Document)
+			clocks[site] = LocalClock
+		}
+
+		// In each round, every site edits its replica, then receives the edits of the others,
+		// one site's edits in the order they were made, but interleaved at random.
+		for round := 0; round < 3; round++ {
+			ops := map[int][]op{}
+			for _, site := range sites {
+				r := replicas[site]
+				SiteID, LocalClock = site, clocks[site]
+				for i := rng.Intn(4); i >= 0; i-- {
+					visible := len([]rune(Content(*r)))
+					if visible > 0 && rng.Intn(3) == 0 {
+						c := IthVisible(*r, 1+rng.Intn(visible))
+						r.IntegrateDelete(c)
+						ops[site] = append(ops[site], op{char: c, deleted: true})
+						continue
+					}
+					if _, err := r.GenerateInsert(1+rng.Intn(visible+1), string(rune('a'+rng.Intn(26)))); err != nil {
+						t.Fatal(err)
+					}
+					ops[site] = append(ops[site], op{char: r.Find(CharacterID{SiteID: site, Clock: LocalClock})})
+				}
+				clocks[site] = LocalClock
+			}
+
+			for _, site := range sites {
+				r := replicas[site]
+				pending := map[int][]op{}
+				for _, other := range sites {
+					if other != site && len(ops[other]) > 0 {
+						pending[other] = ops[other]
+					}
+				}
+				for len(pending) > 0 {
+					other := sites[rng.Intn(len(sites))]
+					if len(pending[other]) == 0 {
+						continue
+					}
+					o := pending[other][0]
+					if pending[other] = pending[other][1:]; len(pending[other]) == 0 {
+						delete(pending, other)
+					}
+					if o.deleted {
+						r.IntegrateDelete(o.char)
+					} else if _, err := r.IntegrateInsert(o.char, r.Find(o.char.IDPrevious), r.Find(o.char.IDNext)); err != nil {
+						t.Fatalf("(run %d) integrating %v on site %d: %v", run, o.char.ID, site, err)
+					}
+				}
+			}
+
+			for _, site := range sites[1:] {
+				if got, want := StateChecksum(*replicas[site]), StateChecksum(*replicas[sites[0]]); got != want {
+					t.Fatalf("(run %d, round %d) site %d has %q, expected %q, as site %d", run, round, site, Content(*replicas[site]), Content(*replicas[sites[0]]), sites[0])
+				}
+			}
+		}
+	}
+}
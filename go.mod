@@ -3,21 +3,39 @@ module github.com/burntcarrot/pairpad
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/Pallinder/go-randomdata v1.2.0
 	github.com/fatih/color v1.13.0
+	github.com/gdamore/tcell/v2 v2.5.4
 	github.com/google/go-cmp v0.5.9
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/websocket v1.5.0
-	github.com/mattn/go-runewidth v0.0.13
-	github.com/nsf/termbox-go v1.1.1
+	github.com/mattn/go-runewidth v0.0.14
+	github.com/rivo/uniseg v0.2.0
 	github.com/sirupsen/logrus v1.9.0
+	modernc.org/sqlite v1.20.4
 )
 
 require (
-	github.com/Pallinder/go-randomdata v1.2.0 // indirect
+	github.com/dustin/go-humanize v1.0.0 // indirect
+	github.com/gdamore/encoding v1.0.0 // indirect
+	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
+	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-colorable v0.1.9 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
-	github.com/rivo/uniseg v0.2.0 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20200410134404-eec4a21b6bb0 // indirect
+	golang.org/x/mod v0.6.0-dev.0.20220419223038-86c51ed26bb4 // indirect
 	golang.org/x/sys v0.0.0-20220811171246-fbc7d0a398ab // indirect
+	golang.org/x/term v0.0.0-20210927222741-03fcf44c2211 // indirect
 	golang.org/x/text v0.5.0 // indirect
+	golang.org/x/tools v0.1.12 // indirect
+	lukechampine.com/uint128 v1.2.0 // indirect
+	modernc.org/cc/v3 v3.40.0 // indirect
+	modernc.org/ccgo/v3 v3.16.13 // indirect
+	modernc.org/libc v1.22.2 // indirect
+	modernc.org/mathutil v1.5.0 // indirect
+	modernc.org/memory v1.4.0 // indirect
+	modernc.org/opt v0.1.3 // indirect
+	modernc.org/strutil v1.1.3 // indirect
+	modernc.org/token v1.0.1 // indirect
 )
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// An accessEntry is a line of the access log, describing a request. For WebSocket
+// connections, it's written when the connection is closed.
+type accessEntry struct {
+	// Time is the time at which the request was received.
+	Time time.Time `json:"time"`
+
+	// Remote is the network address of the client.
+	Remote string `json:"remote"`
+
+	// UserAgent is the client's User-Agent header.
+	UserAgent string `json:"userAgent,omitempty"`
+
+	Method string `json:"method"`
+	Path   string `json:"path"`
+
+	// Status is the HTTP status of the response, which is 101 for upgraded connections.
+	Status int `json:"status"`
+
+	// Upgraded is set if the connection was upgraded to a WebSocket.
+	Upgraded bool `json:"upgraded"`
+
+	// Rejected is the code of the error the client was turned away with, if any.
+	Rejected string `json:"rejected,omitempty"`
+
+	// Room, SiteID and Username identify the client's session, once it has joined a room.
+	Room     string `json:"room,omitempty"`
+	SiteID   string `json:"siteID,omitempty"`
+	Username string `json:"username,omitempty"`
+
+	// Duration is how long the request (or the connection) lasted, in milliseconds.
+	Duration int64 `json:"durationMs"`
+
+	// BytesIn and BytesOut are the numbers of bytes received from and sent to the client,
+	// including the WebSocket frames of upgraded connections.
+	BytesIn  int64 `json:"bytesIn"`
+	BytesOut int64 `json:"bytesOut"`
+}
+
+// An accessLog writes the access log.
+type accessLog struct {
+	// mu protects against concurrent writes from several requests.
+	mu sync.Mutex
+
+	// enc writes the entries to the log.
+	enc *json.Encoder
+}
+
+// newAccessLog returns an accessLog which writes to w. If w is nil, it returns nil, and
+// nothing is logged.
+func newAccessLog(w io.Writer) *accessLog {
+	if w == nil {
+		return nil
+	}
+	return &accessLog{enc: json.NewEncoder(w)}
+}
+
+// accessKey is the context key of the entry describing a request, which handlers fill in.
+type accessKey struct{}
+
+// accessInfo is the part of an access log entry filled in by the handlers, as they learn
+// about the client.
+type accessInfo struct {
+	// mu protects the fields, which are set by the handler while the entry is written.
+	mu                               sync.Mutex
+	rejected, room, siteID, username string
+}
+
+// setAccessInfo updates the information about the request's session, if its access is
+// logged. Empty values are left unchanged.
+func setAccessInfo(ctx context.Context, rejected, room, siteID, username string) {
+	info, ok := ctx.Value(accessKey{}).(*accessInfo)
+	if !ok {
+		return
+	}
+
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	for _, f := range []struct {
+		dst *string
+		src string
+	}{{&info.rejected, rejected}, {&info.room, room}, {&info.siteID, siteID}, {&info.username, username}} {
+		if f.src != "" {
+			*f.dst = f.src
+		}
+	}
+}
+
+// handler logs the requests served by next, if l isn't nil.
+func (l *accessLog) handler(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &accessInfo{}
+		aw := &accessWriter{ResponseWriter: w}
+		body := &countingReader{r: r.Body}
+		r.Body = body
+
+		next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), accessKey{}, info)))
+
+		entry := accessEntry{
+			Time:      start.UTC(),
+			Remote:    r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    aw.status,
+			Duration:  time.Since(start).Milliseconds(),
+			BytesIn:   atomic.LoadInt64(&body.n),
+			BytesOut:  atomic.LoadInt64(&aw.n),
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		if aw.conn != nil {
+			entry.Status, entry.Upgraded = http.StatusSwitchingProtocols, true
+			entry.BytesIn += atomic.LoadInt64(&aw.conn.in)
+			entry.BytesOut += atomic.LoadInt64(&aw.conn.out)
+		}
+		info.mu.Lock()
+		entry.Rejected, entry.Room, entry.SiteID, entry.Username = info.rejected, info.room, info.siteID, info.username
+		info.mu.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if err := l.enc.Encode(entry); err != nil {
+			color.Red("Failed to write the access log: %s", err)
+		}
+	})
+}
+
+// An accessWriter records the status and size of a response, and counts the bytes sent
+// and received over the connection if it's hijacked to be upgraded to a WebSocket.
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+	conn   *countingConn
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	atomic.AddInt64(&w.n, int64(n))
+	return n, err
+}
+
+func (w *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be hijacked")
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = &countingConn{Conn: conn}
+	return w.conn, brw, nil
+}
+
+// A countingConn counts the bytes read from and written to a connection.
+type countingConn struct {
+	net.Conn
+	in, out int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.in, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.out, int64(n))
+	return n, err
+}
+
+// A countingReader counts the bytes read from a request's body.
+type countingReader struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(&r.n, int64(n))
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	return r.r.Close()
+}
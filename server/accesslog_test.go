@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+)
+
+// TestAccessLog checks that requests are logged, and that WebSocket connections are logged
+// when they're closed, with the client's session and the bytes sent over the connection.
+func TestAccessLog(t *testing.T) {
+	pr, pw := io.Pipe()
+	entries := make(chan accessEntry, 10)
+	go func() {
+		dec := json.NewDecoder(pr)
+		for {
+			var entry accessEntry
+			if err := dec.Decode(&entry); err != nil {
+				return
+			}
+			entries <- entry
+		}
+	}()
+	next := func() accessEntry {
+		t.Helper()
+		select {
+		case entry := <-entries:
+			return entry
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for an access log entry")
+			return accessEntry{}
+		}
+	}
+
+	ts := httptest.NewServer(New(Config{AccessLog: pw}).Handler())
+	defer ts.Close()
+	defer pw.Close()
+
+	resp, err := http.Get(ts.URL + "/web/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if entry := next(); entry.Path != "/web/" || entry.Status != http.StatusOK || entry.Upgraded || entry.BytesOut == 0 {
+		t.Errorf("got entry %+v, expected a request for /web/", entry)
+	}
+
+	conn := dial(t, ts.URL+"/?room=team")
+	_ = conn.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+	readUntil(t, conn, commons.JoinAckMessage)
+	conn.Close()
+
+	entry := next()
+	if !entry.Upgraded || entry.Status != http.StatusSwitchingProtocols || entry.Room != "team" || entry.SiteID == "" || entry.Username != "alice" {
+		t.Errorf("got entry %+v, expected alice's connection to room team", entry)
+	}
+	if entry.BytesIn == 0 || entry.BytesOut == 0 {
+		t.Errorf("got %d bytes in and %d bytes out, expected the connection's traffic", entry.BytesIn, entry.BytesOut)
+	}
+}
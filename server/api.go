@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/burntcarrot/pairpad/server/store"
+	"github.com/fatih/color"
+)
+
+// defaultMaxContentSize bounds the size of the documents seeded through the API, in
+// bytes, when Config.MaxMessageSize is zero.
+const defaultMaxContentSize = 10 << 20
+
+// validAPIToken reports whether the request carries the server's API token, as a bearer
+// token.
+func (s *Server) validAPIToken(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.conf.APIToken)) == 1
+}
+
+// handleAPI serves the rooms' content at /rooms/{room}/content: GET returns the visible
+// text of the room's document, and POST replaces it with the request's body, to seed the
+// document of a session before its clients join. GET /rooms/{room}/audit returns the
+// room's operations recorded in the audit log.
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	if !s.validAPIToken(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "invalid API token", http.StatusUnauthorized)
+		return
+	}
+
+	name, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/rooms/"), "/")
+	if !roomNameRegexp.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch resource {
+	case "content":
+		s.handleContent(w, r, name)
+	case "audit":
+		if s.conf.AuditLog == nil {
+			http.Error(w, "this server has no audit log", http.StatusNotImplemented)
+			return
+		}
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.getAudit(w, r, name)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleContent serves the content of the room name.
+func (s *Server) handleContent(w http.ResponseWriter, r *http.Request, name string) {
+	// The server only keeps the rooms' documents when they're persisted.
+	if s.conf.Store == nil {
+		http.Error(w, "documents aren't persisted by this server", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.getContent(w, r, name)
+	case http.MethodPost:
+		s.seedContent(w, r, name)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getContent writes the visible text of a room's document: the room's own copy if it's
+// open, or the stored document otherwise. It's watermarked if conf.Watermark matches the
+// room.
+func (s *Server) getContent(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	room, open := s.rooms[name]
+	s.mu.Unlock()
+
+	var doc crdt.Document
+	var info *sessionInfo
+	if open {
+		room.docMu.Lock()
+		loaded := room.loadDocument()
+		doc = copyDocument(room.doc)
+		room.docMu.Unlock()
+		if !loaded {
+			http.Error(w, "failed to load the document", http.StatusInternalServerError)
+			return
+		}
+		session := room.sessionInfo()
+		info = &session
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+		defer cancel()
+
+		var err error
+		doc, _, err = loadStored(ctx, s.conf.Store, name)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			http.Error(w, "room not found", http.StatusNotFound)
+			return
+		case err != nil:
+			color.Red("[%s] Failed to load the document: %s", name, err)
+			http.Error(w, "failed to load the document", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	text := crdt.Content(doc)
+	if s.conf.Watermark.matches(name) {
+		text = s.conf.Watermark.watermark(text, name, info, r.URL.Query().Get("comment"), time.Now())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, text)
+}
+
+// seedContent replaces the document of a room without clients with the request's body,
+// and saves it. The room is opened, as if a client had joined it, so its session starts.
+func (s *Server) seedContent(w http.ResponseWriter, r *http.Request, name string) {
+	limit := s.conf.MaxMessageSize
+	if limit == 0 {
+		limit = defaultMaxContentSize
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("content larger than %d bytes", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !utf8.Valid(body) {
+		http.Error(w, "content isn't valid UTF-8", http.StatusBadRequest)
+		return
+	}
+	text := string(body)
+	length := utf8.RuneCountInString(text)
+	if s.conf.MaxDocumentSize > 0 && length > s.conf.MaxDocumentSize {
+		http.Error(w, fmt.Sprintf("content longer than the maximum document size of %d characters", s.conf.MaxDocumentSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	doc, err := crdt.FromText(text)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// s.mu is held while the document is replaced, so no client joins the room meanwhile.
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	room := s.room(name)
+	if status, msg := room.seed(doc, length); status != http.StatusOK {
+		s.mu.Unlock()
+		http.Error(w, msg, status)
+		return
+	}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+	defer cancel()
+	if err := room.save(ctx, true); err != nil {
+		color.Red("[%s] Failed to save the seeded document: %s", name, err)
+		http.Error(w, "failed to save the document", http.StatusInternalServerError)
+		return
+	}
+	color.Green("[%s] Seeded the document with %d characters", name, length)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// seed replaces the room's document with doc, which has length visible characters, if the
+// room has no clients. Otherwise, it returns the HTTP status and message of the error.
+// s.mu must be held by the caller, so no client joins the room meanwhile.
+func (r *room) seed(doc crdt.Document, length int) (int, string) {
+	r.mu.Lock()
+	switch {
+	case r.ended:
+		r.mu.Unlock()
+		return http.StatusConflict, fmt.Sprintf("the session in room %q has ended", r.name)
+	case r.numClients > 0:
+		r.mu.Unlock()
+		return http.StatusConflict, fmt.Sprintf("room %q has clients", r.name)
+	}
+	r.docLength = length
+	r.prompts = nil
+	r.mu.Unlock()
+
+	// r.mu isn't held, since loadDocument takes it while holding r.docMu.
+	r.docMu.Lock()
+	r.doc = doc
+	r.docState = docLoaded
+	r.docChanged = true
+	r.unlogged = nil
+	r.snapshotDue = true
+	r.docMu.Unlock()
+	return http.StatusOK, ""
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/burntcarrot/pairpad/server/store"
+)
+
+// TestContentAPI checks that a room's document can be seeded and read through the API,
+// with the API token, and that clients joining the room get the seeded document.
+func TestContentAPI(t *testing.T) {
+	ts := httptest.NewServer(New(Config{Store: store.Dir{Path: t.TempDir()}, APIToken: "secret"}).Handler())
+	defer ts.Close()
+
+	do := func(method, path, token, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	tests := []struct {
+		description  string
+		method, path string
+		token, body  string
+		status       int
+		expectedBody string
+	}{
+		{description: "no token", method: http.MethodGet, path: "/rooms/team/content", status: http.StatusUnauthorized},
+		{description: "wrong token", method: http.MethodGet, path: "/rooms/team/content", token: "guess", status: http.StatusUnauthorized},
+		{description: "unknown room", method: http.MethodGet, path: "/rooms/team/content", token: "secret", status: http.StatusNotFound},
+		{description: "invalid room name", method: http.MethodGet, path: "/rooms/a b/content", token: "secret", status: http.StatusNotFound},
+		{description: "seed", method: http.MethodPost, path: "/rooms/team/content", token: "secret", body: "func main() {}\n", status: http.StatusNoContent},
+		{description: "read", method: http.MethodGet, path: "/rooms/team/content", token: "secret", status: http.StatusOK, expectedBody: "func main() {}\n"},
+		{description: "unsupported method", method: http.MethodDelete, path: "/rooms/team/content", token: "secret", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range tests {
+		status, body := do(tc.method, strings.ReplaceAll(tc.path, " ", "%20"), tc.token, tc.body)
+		if status != tc.status {
+			t.Errorf("%s: got status %d, expected %d", tc.description, status, tc.status)
+		}
+		if tc.expectedBody != "" && body != tc.expectedBody {
+			t.Errorf("%s: got body %q, expected %q", tc.description, body, tc.expectedBody)
+		}
+	}
+
+	// Clients joining the room get the seeded document, and it can't be seeded again while
+	// they're in it.
+	conn := dial(t, ts.URL+"/?room=team")
+	if sync := readUntil(t, conn, commons.DocSyncMessage); crdt.Content(sync.Document) != "func main() {}\n" {
+		t.Errorf("got document %q, expected the seeded document", crdt.Content(sync.Document))
+	}
+	if status, _ := do(http.MethodPost, "/rooms/team/content", "secret", "other"); status != http.StatusConflict {
+		t.Errorf("got status %d, expected %d", status, http.StatusConflict)
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/fatih/color"
+)
+
+// An AuditLog records who performed each operation in the rooms, and when, in a file
+// which is only appended to. Each line is a JSON-encoded commons.Record. It's queried
+// through the API, at /rooms/{room}/audit.
+type AuditLog struct {
+	path string
+
+	// f is the file, opened for appending.
+	f *os.File
+
+	// rec writes the records to f.
+	rec *recorder
+}
+
+// OpenAuditLog opens the audit log at path, creating it if it doesn't exist.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{path: path, f: f, rec: newRecorder(f)}, nil
+}
+
+// Close closes the audit log's file.
+func (a *AuditLog) Close() error {
+	return a.f.Close()
+}
+
+// record appends an operation made by sender in the given room to the audit log, if a
+// isn't nil.
+func (a *AuditLog) record(room string, sender *client, op commons.Operation) {
+	if a != nil {
+		a.rec.record(room, sender, op)
+	}
+}
+
+// An auditQuery selects records of the audit log. Its empty fields match every record.
+type auditQuery struct {
+	room, siteID, username string
+
+	// since and until bound the records' times, since included.
+	since, until time.Time
+
+	// limit is the maximum number of records returned, the first ones. Zero means no limit.
+	limit int
+}
+
+// parseAuditQuery returns the query of an audit request for the records of room, from its
+// site, user, since, until and limit parameters.
+func parseAuditQuery(r *http.Request, room string) (auditQuery, error) {
+	params := r.URL.Query()
+	q := auditQuery{room: room, siteID: params.Get("site"), username: params.Get("user")}
+
+	for _, t := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &q.since}, {"until", &q.until}} {
+		if v := params.Get(t.name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("%s must be an RFC 3339 time, such as 2006-01-02T15:04:05Z", t.name)
+			}
+			*t.dst = parsed
+		}
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return q, fmt.Errorf("limit must be a positive number")
+		}
+		q.limit = limit
+	}
+	return q, nil
+}
+
+// matches reports whether rec is selected by q.
+func (q auditQuery) matches(rec commons.Record) bool {
+	switch {
+	case rec.Room != q.room:
+		return false
+	case q.siteID != "" && rec.SiteID != q.siteID:
+		return false
+	case q.username != "" && rec.Username != q.username:
+		return false
+	case !q.since.IsZero() && rec.Time.Before(q.since):
+		return false
+	case !q.until.IsZero() && !rec.Time.Before(q.until):
+		return false
+	}
+	return true
+}
+
+// query returns the records of the audit log selected by q, in the order in which they
+// were written.
+func (a *AuditLog) query(q auditQuery) ([]commons.Record, error) {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records := []commons.Record{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec commons.Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A line being written while the log is read is incomplete.
+			continue
+		}
+		if q.matches(rec) {
+			records = append(records, rec)
+			if q.limit > 0 && len(records) == q.limit {
+				break
+			}
+		}
+	}
+	return records, scanner.Err()
+}
+
+// getAudit writes the records of the audit log selected by the request's parameters, for
+// the room name, as a JSON array.
+func (s *Server) getAudit(w http.ResponseWriter, r *http.Request, name string) {
+	q, err := parseAuditQuery(r, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := s.conf.AuditLog.query(q)
+	if err != nil {
+		color.Red("[%s] Failed to read the audit log: %s", name, err)
+		http.Error(w, "failed to read the audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(records)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+)
+
+// TestAuditAPI checks that the operations of a room are recorded in the audit log with
+// their authors, and can be filtered through the API.
+func TestAuditAPI(t *testing.T) {
+	audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+
+	ts := httptest.NewServer(New(Config{APIToken: "secret", AuditLog: audit}).Handler())
+	defer ts.Close()
+
+	for i, name := range []string{"alice", "bob"} {
+		conn := dial(t, ts.URL+"/?room=team")
+		_ = conn.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: name})
+		readUntil(t, conn, commons.JoinAckMessage)
+		_ = conn.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: i + 1, Value: name[:1]}, Seq: 1})
+		readUntil(t, conn, commons.AckMessage)
+	}
+
+	get := func(path string) (int, []commons.Record) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var records []commons.Record
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, records
+	}
+
+	tests := []struct {
+		description string
+		path        string
+		status      int
+		expected    []string
+	}{
+		{description: "all", path: "/rooms/team/audit", status: http.StatusOK, expected: []string{"alice a", "bob b"}},
+		{description: "by user", path: "/rooms/team/audit?user=bob", status: http.StatusOK, expected: []string{"bob b"}},
+		{description: "limit", path: "/rooms/team/audit?limit=1", status: http.StatusOK, expected: []string{"alice a"}},
+		{description: "since", path: "/rooms/team/audit?since=2100-01-01T00:00:00Z", status: http.StatusOK, expected: []string{}},
+		{description: "other room", path: "/rooms/other/audit", status: http.StatusOK, expected: []string{}},
+		{description: "invalid time", path: "/rooms/team/audit?until=yesterday", status: http.StatusBadRequest},
+		{description: "unknown resource", path: "/rooms/team/history", status: http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		status, records := get(tc.path)
+		if status != tc.status {
+			t.Errorf("%s: got status %d, expected %d", tc.description, status, tc.status)
+			continue
+		}
+		if tc.expected == nil {
+			continue
+		}
+		got := []string{}
+		for _, rec := range records {
+			got = append(got, rec.Username+" "+rec.Operation.Value)
+		}
+		if len(got) != len(tc.expected) {
+			t.Errorf("%s: got records %q, expected %q", tc.description, got, tc.expected)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.expected[i] {
+				t.Errorf("%s: got records %q, expected %q", tc.description, got, tc.expected)
+				break
+			}
+		}
+	}
+}
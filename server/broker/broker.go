@@ -0,0 +1,96 @@
+// Package broker provides the message brokers through which pairpad-server instances
+// share rooms, so clients connected to different instances behind a load balancer can
+// collaborate.
+//
+// Each room has its own channel. Instances publish the messages of their clients to it,
+// and relay the messages published by the other instances to their own clients.
+package broker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// A Broker delivers messages between server instances. Implementations must be safe for
+// concurrent use.
+type Broker interface {
+	// Publish sends a message to the subscribers of a room, on all instances (including
+	// the publishing one).
+	Publish(ctx context.Context, room string, msg []byte) error
+
+	// Subscribe returns a channel receiving the messages published to a room, in the
+	// order in which they were published. The subscription ends, and the channel is
+	// closed, when ctx is done.
+	Subscribe(ctx context.Context, room string) (<-chan []byte, error)
+
+	// NextSiteID returns a site ID which hasn't been given by any instance yet.
+	NextSiteID(ctx context.Context) (int, error)
+}
+
+// subscriptionBuffer is the number of messages buffered for each subscriber.
+const subscriptionBuffer = 1024
+
+// deliverTo sends a message to a subscriber. If the subscriber has fallen too far behind,
+// the message is dropped, rather than holding up the other subscribers.
+func deliverTo(sub chan []byte, msg []byte) {
+	select {
+	case sub <- msg:
+	default:
+		color.Red("Dropping a message for a subscriber which is %d messages behind", subscriptionBuffer)
+	}
+}
+
+// Memory is a broker for server instances running in the same process, mostly useful for
+// tests. The zero value is ready to use.
+type Memory struct {
+	mu     sync.Mutex
+	subs   map[string]map[chan []byte]bool
+	siteID int
+}
+
+// Publish sends a message to the subscribers of a room.
+func (m *Memory) Publish(ctx context.Context, room string, msg []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for sub := range m.subs[room] {
+		deliverTo(sub, msg)
+	}
+	return nil
+}
+
+// Subscribe subscribes to the messages published to a room.
+func (m *Memory) Subscribe(ctx context.Context, room string) (<-chan []byte, error) {
+	sub := make(chan []byte, subscriptionBuffer)
+
+	m.mu.Lock()
+	if m.subs == nil {
+		m.subs = make(map[string]map[chan []byte]bool)
+	}
+	if m.subs[room] == nil {
+		m.subs[room] = make(map[chan []byte]bool)
+	}
+	m.subs[room][sub] = true
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		delete(m.subs[room], sub)
+		close(sub)
+		m.mu.Unlock()
+	}()
+
+	return sub, nil
+}
+
+// NextSiteID returns the next site ID.
+func (m *Memory) NextSiteID(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.siteID++
+	return m.siteID, nil
+}
@@ -0,0 +1,184 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testBroker checks that a broker delivers the messages published to a room to its
+// subscribers only, and gives increasing site IDs.
+func testBroker(t *testing.T, b Broker) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a1, err := b.Subscribe(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, _ := b.Subscribe(ctx, "a")
+	other, _ := b.Subscribe(ctx, "b")
+
+	// Subscriptions may take effect asynchronously.
+	time.Sleep(50 * time.Millisecond)
+
+	if err := b.Publish(ctx, "a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	for _, sub := range []<-chan []byte{a1, a2} {
+		select {
+		case msg := <-sub:
+			if string(msg) != "hello" {
+				t.Errorf("got message %q, expected %q", msg, "hello")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message not delivered")
+		}
+	}
+	select {
+	case msg := <-other:
+		t.Errorf("got message %q on another room's subscription", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first, err := b.NextSiteID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := b.NextSiteID(ctx)
+	if second <= first {
+		t.Errorf("got site IDs %d, then %d, expected increasing IDs", first, second)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-a1:
+		if ok {
+			t.Error("got a message after the subscription ended")
+		}
+	case <-time.After(time.Second):
+		t.Error("channel not closed after the subscription ended")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testBroker(t, &Memory{})
+}
+
+func TestRedis(t *testing.T) {
+	addr := fakeRedis(t)
+	b, err := NewRedis("redis://"+addr, "pairpad:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBroker(t, b)
+}
+
+func TestReadReply(t *testing.T) {
+	input := "+OK\r\n-ERR wrong\r\n:42\r\n$5\r\nhe\r\no\r\n$-1\r\n*2\r\n$7\r\nmessage\r\n:1\r\n"
+	expected := []interface{}{"OK", redisError("ERR wrong"), int64(42), "he\r\no", nil, []interface{}{"message", int64(1)}}
+
+	r := bufio.NewReader(strings.NewReader(input))
+	for i, want := range expected {
+		got, err := readReply(r)
+		if err != nil {
+			t.Fatalf("reply %d: %v", i, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("reply %d: (-want +got)\n%s", i, diff)
+		}
+	}
+}
+
+// fakeRedis starts a server handling the PING, PUBLISH, SUBSCRIBE, UNSUBSCRIBE and INCR
+// commands like Redis, and returns its address.
+func fakeRedis(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	var mu sync.Mutex
+	subs := make(map[string]map[net.Conn]bool)
+	counters := make(map[string]int)
+
+	write := func(conn net.Conn, s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = conn.Write([]byte(s))
+	}
+	bulk := func(s string) string {
+		return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					reply, err := readReply(r)
+					if err != nil {
+						return
+					}
+					args, _ := reply.([]interface{})
+					cmd, _ := args[0].(string)
+
+					switch strings.ToUpper(cmd) {
+					case "PING":
+						write(conn, "+PONG\r\n")
+					case "INCR":
+						mu.Lock()
+						counters[args[1].(string)]++
+						n := counters[args[1].(string)]
+						mu.Unlock()
+						write(conn, ":"+strconv.Itoa(n)+"\r\n")
+					case "PUBLISH":
+						channel, msg := args[1].(string), args[2].(string)
+						mu.Lock()
+						receivers := make([]net.Conn, 0, len(subs[channel]))
+						for sub := range subs[channel] {
+							receivers = append(receivers, sub)
+						}
+						mu.Unlock()
+						for _, sub := range receivers {
+							write(sub, "*3\r\n"+bulk("message")+bulk(channel)+bulk(msg))
+						}
+						write(conn, ":"+strconv.Itoa(len(receivers))+"\r\n")
+					case "SUBSCRIBE", "UNSUBSCRIBE":
+						for _, arg := range args[1:] {
+							channel := arg.(string)
+							mu.Lock()
+							if subs[channel] == nil {
+								subs[channel] = make(map[net.Conn]bool)
+							}
+							if strings.ToUpper(cmd) == "SUBSCRIBE" {
+								subs[channel][conn] = true
+							} else {
+								delete(subs[channel], conn)
+							}
+							mu.Unlock()
+							write(conn, "*3\r\n"+bulk(strings.ToLower(cmd))+bulk(channel)+":1\r\n")
+						}
+					default:
+						write(conn, "-ERR unknown command\r\n")
+					}
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
@@ -0,0 +1,361 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Redis is a broker using Redis pub/sub. Each room is published on the channel
+// "<prefix>room:<name>", and site IDs are generated by incrementing the key
+// "<prefix>siteID".
+//
+// Messages are published on a connection shared by all rooms, and received on a second
+// connection holding the subscriptions. If the subscription connection breaks, it's
+// reopened, and the rooms are subscribed to again; messages published in the meantime
+// are lost, as with any Redis pub/sub subscriber.
+type Redis struct {
+	// addr is the Redis server's address, and password and db the credentials and
+	// database given in its URL.
+	addr     string
+	password string
+	db       int
+
+	// prefix is prepended to the channel and key names.
+	prefix string
+
+	// mu protects conn, which is used for commands. It's opened when needed.
+	mu   sync.Mutex
+	conn *redisConn
+
+	// subMu protects subConn and subs.
+	subMu   sync.Mutex
+	subConn *redisConn
+	subs    map[string]map[chan []byte]bool
+
+	// subOnce starts the goroutine holding the subscription connection.
+	subOnce sync.Once
+}
+
+// dialTimeout bounds the time spent connecting to Redis.
+const dialTimeout = 5 * time.Second
+
+// NewRedis returns a broker connected to the Redis server at rawURL, in the form
+// redis://[:password@]host[:port][/db]. Names are prefixed with prefix, e.g. "pairpad:".
+func NewRedis(rawURL, prefix string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("invalid Redis URL %q: expected redis://", rawURL)
+	}
+
+	r := &Redis{addr: u.Host, prefix: prefix, subs: make(map[string]map[chan []byte]bool)}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+
+	// Check that the server can be reached.
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if _, err := r.command(ctx, "PING"); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// channel returns the name of a room's channel.
+func (r *Redis) channel(room string) string {
+	return r.prefix + "room:" + room
+}
+
+// Publish publishes a message on the room's channel.
+func (r *Redis) Publish(ctx context.Context, room string, msg []byte) error {
+	_, err := r.command(ctx, "PUBLISH", r.channel(room), string(msg))
+	return err
+}
+
+// NextSiteID increments the site ID key.
+func (r *Redis) NextSiteID(ctx context.Context) (int, error) {
+	reply, err := r.command(ctx, "INCR", r.prefix+"siteID")
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply to INCR: %v", reply)
+	}
+	return int(n), nil
+}
+
+// command sends a command on the command connection, and returns its reply. If the
+// connection was broken, it's reopened.
+func (r *Redis) command(ctx context.Context, args ...string) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		conn, err := r.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.conn = conn
+	}
+
+	reply, err := r.conn.do(ctx, args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection is broken. Open a new one next time.
+		r.conn.Close()
+		r.conn = nil
+	}
+	return reply, err
+}
+
+// dial opens a connection, and authenticates and selects the database if needed.
+func (r *Redis) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: dialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if r.password != "" {
+		if _, err := conn.do(ctx, "AUTH", r.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err := conn.do(ctx, "SELECT", strconv.Itoa(r.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Subscribe subscribes to the room's channel.
+func (r *Redis) Subscribe(ctx context.Context, room string) (<-chan []byte, error) {
+	r.subOnce.Do(func() { go r.receive() })
+
+	sub := make(chan []byte, subscriptionBuffer)
+	channel := r.channel(room)
+
+	r.subMu.Lock()
+	first := len(r.subs[channel]) == 0
+	if first {
+		r.subs[channel] = make(map[chan []byte]bool)
+	}
+	r.subs[channel][sub] = true
+	if first && r.subConn != nil {
+		// If this fails, the connection is broken, and receive subscribes again once
+		// it has reopened it.
+		_ = r.subConn.send("SUBSCRIBE", channel)
+	}
+	r.subMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		r.subMu.Lock()
+		delete(r.subs[channel], sub)
+		if len(r.subs[channel]) == 0 {
+			delete(r.subs, channel)
+			if r.subConn != nil {
+				_ = r.subConn.send("UNSUBSCRIBE", channel)
+			}
+		}
+		close(sub)
+		r.subMu.Unlock()
+	}()
+
+	return sub, nil
+}
+
+// receive keeps the subscription connection open, and delivers the messages received on
+// it to the subscribers.
+func (r *Redis) receive() {
+	backoff := time.Second
+	for {
+		conn, err := r.dial(context.Background())
+		if err != nil {
+			color.Red("Failed to connect to Redis: %s", err)
+			time.Sleep(backoff)
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+
+		// Subscribe to the rooms' channels, including those subscribed to while the
+		// connection was down.
+		r.subMu.Lock()
+		r.subConn = conn
+		channels := make([]string, 0, len(r.subs))
+		for channel := range r.subs {
+			channels = append(channels, channel)
+		}
+		if len(channels) > 0 {
+			err = conn.send(append([]string{"SUBSCRIBE"}, channels...)...)
+		}
+		r.subMu.Unlock()
+
+		if err == nil {
+			err = r.deliver(conn)
+		}
+		color.Red("Lost the Redis subscription connection: %s", err)
+
+		r.subMu.Lock()
+		r.subConn = nil
+		r.subMu.Unlock()
+		conn.Close()
+	}
+}
+
+// deliver reads the messages pushed on the subscription connection, until it fails.
+func (r *Redis) deliver(conn *redisConn) error {
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+
+		// Messages are pushed as ["message", channel, payload]. Replies to SUBSCRIBE
+		// and UNSUBSCRIBE are ignored.
+		push, ok := reply.([]interface{})
+		if !ok || len(push) != 3 || push[0] != "message" {
+			continue
+		}
+		channel, _ := push[1].(string)
+		payload, _ := push[2].(string)
+
+		r.subMu.Lock()
+		for sub := range r.subs[channel] {
+			deliverTo(sub, []byte(payload))
+		}
+		r.subMu.Unlock()
+	}
+}
+
+// A redisError is an error reply from the Redis server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// A redisConn is a connection to a Redis server, speaking RESP
+// (https://redis.io/docs/reference/protocol-spec/).
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// do sends a command and reads its reply.
+func (c *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.SetDeadline(deadline)
+		defer c.SetDeadline(time.Time{})
+	}
+
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	reply, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// send writes a command, as an array of bulk strings.
+func (c *redisConn) send(args ...string) error {
+	_, err := c.Write(encodeCommand(args))
+	return err
+}
+
+// encodeCommand encodes a command as a RESP array of bulk strings.
+func encodeCommand(args []string) []byte {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	return buf
+}
+
+// read reads a reply. Simple and bulk strings are returned as strings, integers as
+// int64, arrays as []interface{}, errors as redisError, and nulls as nil.
+func (c *redisConn) read() (interface{}, error) {
+	return readReply(c.r)
+}
+
+// readReply reads a RESP reply from r.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return redisError(line), nil
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("redis: invalid reply type %q", kind)
+}
@@ -0,0 +1,634 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// pingInterval is how often clients are pinged, to measure their latency, and to check
+// that they're still there (see client.readTimeout).
+const pingInterval = 10 * time.Second
+
+// nameReservation is how long the name of a client which left is kept for it: others
+// joining meanwhile get another name, unless they present the name's token.
+const nameReservation = 5 * time.Minute
+
+// Clients is used to store, reference, and update information about all connected clients.
+type Clients struct {
+	// list stores information about active clients.
+	list map[uuid.UUID]*client
+
+	// clientsMu protects against concurrent read/write access to the activeClients map.
+	mu sync.RWMutex
+
+	// deleteRequests indicates which clients to delete from the list of clients.
+	deleteRequests chan deleteRequest
+
+	// readRequests indicates which clients to retrieve from the list of clients.
+	readRequests chan readRequest
+
+	// addRequests is used to send clients to add to the list of clients.
+	addRequests chan *client
+
+	// nameUpdateRequests is used to update a client with their username.
+	nameUpdateRequests chan nameUpdate
+
+	// left holds the lowercase names of the clients which left, with the time they left,
+	// for nameReservation. It's only accessed by handle.
+	left map[string]time.Time
+
+	// syncChan is used to send the list of usernames whenever it changes.
+	syncChan chan<- commons.Message
+}
+
+// NewClients returns a new instance of a Clients struct. Username updates are sent to syncChan.
+func NewClients(syncChan chan<- commons.Message) *Clients {
+	return &Clients{
+		list:               make(map[uuid.UUID]*client),
+		mu:                 sync.RWMutex{},
+		deleteRequests:     make(chan deleteRequest),
+		readRequests:       make(chan readRequest, 10000),
+		addRequests:        make(chan *client),
+		nameUpdateRequests: make(chan nameUpdate),
+		left:               make(map[string]time.Time),
+		syncChan:           syncChan,
+	}
+}
+
+// a client holds the information of a connected client.
+type client struct {
+	Conn   *websocket.Conn
+	SiteID string
+	id     uuid.UUID
+
+	// outbox holds the messages waiting to be written to Conn by writeLoop, which is the
+	// only goroutine writing messages to Conn.
+	outbox chan interface{}
+
+	// writeTimeout bounds the time spent writing each part of a message (see write).
+	writeTimeout time.Duration
+
+	// readTimeout is how long the client may stay silent before its connection is closed.
+	// Its messages and pongs push the read deadline back.
+	readTimeout time.Duration
+
+	// writeDone is closed when writeLoop returns.
+	writeDone chan struct{}
+
+	// mu protects against data races on a client's info
+	mu sync.Mutex
+
+	Username string
+
+	// kicked is set when the server disconnects the client.
+	kicked bool
+
+	// color is the index of the client's color, assigned when it's added to the list of
+	// clients. It's the lowest index not used by another client in the room.
+	color int
+
+	// interviewer is set for clients which joined as interviewers. It doesn't change.
+	interviewer bool
+
+	// readOnly is set while an interviewer has removed the client's edit access.
+	readOnly bool
+
+	// latency is the round-trip time of the last ping answered by the client, and
+	// reportedLatency the one sent in the last list of users.
+	latency, reportedLatency time.Duration
+}
+
+// handle acts as a monitor for a Clients type. handle attempts to ensure concurrency safety
+// for accessing the Clients struct. handle returns when done is closed.
+func (c *Clients) handle(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case req := <-c.deleteRequests:
+			c.close(req.id)
+			req.done <- 1
+			close(req.done)
+		case req := <-c.readRequests:
+			if req.readAll {
+				for _, client := range c.list {
+					req.resp <- client
+				}
+				close(req.resp)
+			} else {
+				req.resp <- c.list[req.id]
+				close(req.resp)
+			}
+		case client := <-c.addRequests:
+			c.mu.Lock()
+			client.mu.Lock()
+			client.color = c.freeColor()
+			client.mu.Unlock()
+			c.list[client.id] = client
+			c.mu.Unlock()
+		case n := <-c.nameUpdateRequests:
+			client, ok := c.list[n.id]
+			if !ok {
+				close(n.resp)
+				continue
+			}
+
+			// Make the name unique, so users can be told apart. The names of the clients
+			// which left recently are kept for them, so they can't be impersonated.
+			taken := make(map[string]bool, len(c.list)+len(c.left))
+			now := time.Now()
+			for name, t := range c.left {
+				if now.Sub(t) > nameReservation {
+					delete(c.left, name)
+				} else if !n.verified || name != strings.ToLower(n.newName) {
+					taken[name] = true
+				}
+			}
+			for id, other := range c.list {
+				if id != n.id {
+					other.mu.Lock()
+					taken[strings.ToLower(other.Username)] = true
+					other.mu.Unlock()
+				}
+			}
+			name := uniqueName(n.newName, taken)
+
+			client.mu.Lock()
+			client.Username = name
+			client.mu.Unlock()
+			n.resp <- name
+			close(n.resp)
+		}
+	}
+}
+
+// freeColor returns the lowest color index which isn't used by any client in the list.
+// Colors of clients which have left are reused, but the colors of the remaining clients
+// never change. It must only be called by handle.
+func (c *Clients) freeColor() int {
+	used := make(map[int]bool, len(c.list))
+	for _, client := range c.list {
+		client.mu.Lock()
+		used[client.color] = true
+		client.mu.Unlock()
+	}
+
+	color := 0
+	for used[color] {
+		color++
+	}
+	return color
+}
+
+// A deleteRequest is used to delete clients from the list of clients.
+type deleteRequest struct {
+	// id is the ID of the client to be deleted.
+	id uuid.UUID
+
+	// done is used to signal that a delete request has been fulfilled.
+	done chan int
+}
+
+// A readRequest is used to help callers retrieve information about clients.
+type readRequest struct {
+	// readAll indicates whether the caller want's to receive all clients.
+	readAll bool
+
+	// id is the id of the client to be retrieved from the list of clients. id is the
+	//  zero value of uuid.UUID if readAll is true.
+	id uuid.UUID
+
+	// resp is the channel from which requesters can read the response.
+	resp chan *client
+}
+
+// getAll requests all active clients from the clients list and returns a channel containing
+// all clients.
+func (c *Clients) getAll() chan *client {
+	c.mu.RLock()
+	resp := make(chan *client, len(c.list))
+	c.mu.RUnlock()
+	c.readRequests <- readRequest{readAll: true, resp: resp}
+	return resp
+}
+
+// get requests a client with the given id, and returns a channel containing the client. If
+// the client doesn't exist, the channel will be empty
+func (c *Clients) get(id uuid.UUID) chan *client {
+	resp := make(chan *client)
+
+	c.readRequests <- readRequest{readAll: false, id: id, resp: resp}
+	return resp
+}
+
+// add adds a client to the list of clients.
+func (c *Clients) add(client *client) {
+	c.addRequests <- client
+}
+
+// A nameUpdate is used as a message to update the name of a client.
+type nameUpdate struct {
+	id      uuid.UUID
+	newName string
+
+	// verified is set if the client presented the token of newName, which lets it take the
+	// name while it's kept for the client which left with it.
+	verified bool
+
+	// resp receives the name given to the client.
+	resp chan string
+}
+
+// updateName updates the name field of a client with the given id. If another client has
+// the same name, or a client left with it less than nameReservation ago and the client
+// isn't verified to be it, a numeric suffix is added. updateName returns the name given to
+// the client, or an empty string if the client doesn't exist.
+func (c *Clients) updateName(id uuid.UUID, newName string, verified bool) string {
+	resp := make(chan string, 1)
+	c.nameUpdateRequests <- nameUpdate{id: id, newName: newName, verified: verified, resp: resp}
+	return <-resp
+}
+
+// attribute sets the sender's name in a message from the client to the name the server
+// gave it, so clients can't send messages on behalf of others.
+func (c *client) attribute(msg *commons.Message) {
+	c.mu.Lock()
+	name := c.Username
+	c.mu.Unlock()
+
+	switch msg.Type {
+	case commons.JoinMessage, commons.AccessMessage:
+		// The name is the one the client asks for, or the candidate's whose access is set.
+		return
+	}
+	msg.Username = name
+
+	switch msg.Type {
+	case commons.AnnotationMessage, commons.PromptMessage:
+		// Comments and prompts keep their author when they're removed, possibly by
+		// someone else.
+		if msg.Annotation != nil && !msg.Annotation.Deleted {
+			msg.Annotation.Author = name
+		}
+	}
+}
+
+// delete deletes a client from the list of active clients.
+func (c *Clients) delete(id uuid.UUID) {
+	req := deleteRequest{id, make(chan int)}
+	c.deleteRequests <- req
+	<-req.done
+	c.sendUsernames()
+}
+
+// broadcastAll sends a message to all active clients.
+func (c *Clients) broadcastAll(msg commons.Message) {
+	color.Blue("sending message to all users. Text: %s", msg.Text)
+	for client := range c.getAll() {
+		if err := client.send(msg); err != nil {
+			color.Red("ERROR: %s", err)
+		}
+	}
+}
+
+// broadcastAllExcept sends a message to all clients except for the one whose ID
+// matches except.
+func (c *Clients) broadcastAllExcept(msg commons.Message, except uuid.UUID) {
+	for client := range c.getAll() {
+		if client.id == except {
+			continue
+		}
+		if err := client.send(msg); err != nil {
+			color.Red("ERROR: %s", err)
+		}
+	}
+}
+
+// broadcastRole sends a message to the interviewers if interviewer is set, or else to the
+// other clients, except for the one whose ID matches except.
+func (c *Clients) broadcastRole(msg commons.Message, except uuid.UUID, interviewer bool) {
+	for client := range c.getAll() {
+		if client.id == except || client.interviewer != interviewer {
+			continue
+		}
+		if err := client.send(msg); err != nil {
+			color.Red("ERROR: %s", err)
+		}
+	}
+}
+
+// broadcastOne sends a message to a single client with the ID matching dst.
+func (c *Clients) broadcastOne(msg commons.Message, dst uuid.UUID) {
+	client := <-c.get(dst)
+	if client == nil {
+		color.Red("ERROR: client %s not found", dst)
+		return
+	}
+	if err := client.send(msg); err != nil {
+		color.Red("ERROR: %s", err)
+	}
+}
+
+// close closes a WebSocket connection and removes it from the list of clients in a
+// concurrency safe manner.
+func (c *Clients) close(id uuid.UUID) {
+	c.mu.RLock()
+	client, ok := c.list[id]
+	if !ok {
+		c.mu.RUnlock()
+		color.Red("Couldn't close connection: client not in list")
+		return
+	}
+	if err := client.Conn.Close(); err != nil {
+		color.Red("Error closing connection: %s\n", err)
+	}
+	color.Red("Removing %v from client list.\n", client.Username)
+	client.mu.Lock()
+	if client.Username != "" {
+		c.left[strings.ToLower(client.Username)] = time.Now()
+	}
+	client.mu.Unlock()
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	delete(c.list, id)
+	c.mu.Unlock()
+}
+
+// read reads a message over the client Conn, and stores the result in msg. A message
+// larger than the connection's read limit (see Config.MaxMessageSize) fails with
+// websocket.ErrReadLimit, and the connection is closed.
+func (c *client) read(msg *commons.Message) error {
+	_, r, err := c.Conn.NextReader()
+	if err == nil {
+		var data []byte
+		data, err = io.ReadAll(r)
+		if err == nil {
+			c.extendReadDeadline()
+			err = json.Unmarshal(data, msg)
+		}
+	}
+
+	c.mu.Lock()
+	name := c.Username
+	c.mu.Unlock()
+
+	if err != nil {
+		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			color.Red("Failed to read message from client %s: %v", name, err)
+		}
+		color.Red("client %v disconnected", name)
+		return err
+	}
+	return nil
+}
+
+var (
+	// errOutboxFull is returned by send if the client's outbox is full, which means the
+	// client doesn't keep up with the messages sent to it.
+	errOutboxFull = errors.New("client's outbox is full")
+
+	// errClientGone is returned by send once the client's connection can't be written to.
+	errClientGone = errors.New("client's connection is closed")
+)
+
+// send queues a message to be written to the client Conn by writeLoop. It never waits: if
+// the client's outbox is full, the client has fallen too far behind, and its connection is
+// closed. Either way, a client which can't be sent messages is removed from the room by
+// its handler, once its connection is closed.
+func (c *client) send(v interface{}) error {
+	select {
+	case <-c.writeDone:
+		return errClientGone
+	default:
+	}
+
+	select {
+	case c.outbox <- v:
+		return nil
+	default:
+		_ = c.Conn.Close()
+		return errOutboxFull
+	}
+}
+
+// A closeRequest, queued by send, makes writeLoop close the client's connection with the
+// close code and text, once the messages queued before it are written.
+type closeRequest struct {
+	code int
+	text string
+}
+
+// refuse sends the client an error message with the code, and then closes its connection.
+func (c *client) refuse(code commons.ErrorCode, text string, status int) {
+	_ = c.send(commons.Message{Type: commons.ErrorMessage, Code: code, Text: text})
+	_ = c.send(closeRequest{code: status, text: text})
+}
+
+// writeLoop writes the messages queued by send to the client Conn, until stop is closed.
+// If a write fails, or doesn't complete within the client's write timeout, the connection
+// is closed, which ends the client's handler.
+func (c *client) writeLoop(stop <-chan struct{}) {
+	defer close(c.writeDone)
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	for {
+		var v interface{}
+		select {
+		case v = <-c.outbox:
+		case <-ping.C:
+			// The ping carries the time at which it was sent, which the pong echoes.
+			payload := strconv.FormatInt(time.Now().UnixNano(), 10)
+			if err := c.Conn.WriteControl(websocket.PingMessage, []byte(payload), time.Now().Add(c.writeTimeout)); err != nil {
+				_ = c.Conn.Close()
+				return
+			}
+			continue
+		case <-stop:
+			return
+		}
+
+		if req, ok := v.(closeRequest); ok {
+			closeMsg := websocket.FormatCloseMessage(req.code, req.text)
+			_ = c.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(c.writeTimeout))
+			_ = c.Conn.Close()
+			return
+		}
+
+		if err := c.write(v); err != nil {
+			c.mu.Lock()
+			name := c.Username
+			c.mu.Unlock()
+			color.Red("Failed to write message to client %s: %v", name, err)
+			_ = c.Conn.Close()
+			return
+		}
+	}
+}
+
+// writeChunk is the size of the parts of a message the client is given writeTimeout to
+// accept.
+const writeChunk = 32 << 10
+
+// write writes a message to the client Conn, as JSON. The write deadline is pushed back for
+// each part of the message, so a slow client receiving a large document isn't
+// disconnected as long as it keeps taking it in, while a stalled one is.
+func (c *client) write(v interface{}) error {
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	w, err := c.Conn.NextWriter(websocket.TextMessage)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(&deadlineWriter{w: w, c: c}).Encode(v)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// A deadlineWriter writes to a client's message writer in chunks of writeChunk bytes,
+// pushing the connection's write deadline back before each of them.
+type deadlineWriter struct {
+	w io.Writer
+	c *client
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > writeChunk {
+			chunk = chunk[:writeChunk]
+		}
+		_ = d.c.Conn.SetWriteDeadline(time.Now().Add(d.c.writeTimeout))
+		n, err := d.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// extendReadDeadline gives the client another readTimeout to send something.
+func (c *client) extendReadDeadline() {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+}
+
+// pong records the latency measured by a pong answering one of writeLoop's pings, whose
+// payload is the time at which the ping was sent. Other pongs are ignored.
+func (c *client) pong(payload string) error {
+	sent, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	c.latency = time.Since(time.Unix(0, sent))
+	c.mu.Unlock()
+	return nil
+}
+
+// kick marks the client as kicked, and closes its connection.
+func (c *client) kick() {
+	c.mu.Lock()
+	c.kicked = true
+	c.mu.Unlock()
+
+	closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "kicked from the session")
+	_ = c.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+	_ = c.Conn.Close()
+}
+
+// leaveReason returns the reason for which the client left, given the error which ended
+// its connection.
+func (c *client) leaveReason(err error) string {
+	c.mu.Lock()
+	kicked := c.kicked
+	c.mu.Unlock()
+
+	switch {
+	case kicked:
+		return commons.LeaveReasonKicked
+	case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		return commons.LeaveReasonLeft
+	default:
+		return commons.LeaveReasonConnectionLost
+	}
+}
+
+// sendError sends an error message to the client for a rejected message, with the operation of the rejected message and its seq, if any.
+func (c *client) sendError(text string, rejected commons.Message) {
+	if err := c.send(commons.Message{Type: commons.ErrorMessage, Code: commons.ErrorInvalidOperation, Text: text, Operation: rejected.Operation, Seq: rejected.Seq}); err != nil {
+		color.Red("ERROR: %s", err)
+	}
+}
+
+// sendUsernames sends a message containing the names and colors of all active clients
+// to the syncChan, to be broadcast to all clients and displayed in their editor.
+func (c *Clients) sendUsernames() {
+	c.syncChan <- c.usersMessage()
+}
+
+// usersMessage returns a users message listing the active clients, in the order in which
+// they joined, with their latencies, which are then reported.
+func (c *Clients) usersMessage() commons.Message {
+	var list []commons.User
+	for client := range c.getAll() {
+		client.mu.Lock()
+		list = append(list, commons.User{
+			Name:     client.Username,
+			SiteID:   client.SiteID,
+			Color:    client.color,
+			Hidden:   client.interviewer,
+			ReadOnly: client.readOnly,
+			Latency:  client.latency.Milliseconds(),
+		})
+		client.reportedLatency = client.latency
+		client.mu.Unlock()
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		a, _ := strconv.Atoi(list[i].SiteID)
+		b, _ := strconv.Atoi(list[j].SiteID)
+		return a < b
+	})
+
+	var users string
+	for _, u := range list {
+		users += u.Name + ","
+	}
+
+	return commons.Message{Text: users, Users: list, Type: commons.UsersMessage}
+}
+
+// latencyChanged reports whether the latency of a client has changed noticeably since it
+// was last reported: by a fifth, and at least 10ms.
+func (c *Clients) latencyChanged() bool {
+	changed := false
+	for client := range c.getAll() {
+		client.mu.Lock()
+		diff := client.latency - client.reportedLatency
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff >= 10*time.Millisecond && diff >= client.reportedLatency/5 {
+			changed = true
+		}
+		client.mu.Unlock()
+	}
+	return changed
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFreeColor(t *testing.T) {
+	tests := []struct {
+		description string
+		colors      []int
+		expected    int
+	}{
+		{description: "no clients", colors: nil, expected: 0},
+		{description: "consecutive colors", colors: []int{0, 1, 2}, expected: 3},
+		{description: "reuse a color", colors: []int{0, 2}, expected: 1},
+		{description: "reuse the first color", colors: []int{1, 2}, expected: 0},
+	}
+
+	for _, tc := range tests {
+		c := NewClients(nil)
+		for _, color := range tc.colors {
+			client := &client{id: uuid.New(), color: color}
+			c.list[client.id] = client
+		}
+
+		if got := c.freeColor(); got != tc.expected {
+			t.Errorf("(%s) got %d, expected %d\n", tc.description, got, tc.expected)
+		}
+	}
+}
+
+// TestLatency tests that pongs update the clients' latencies, which are sent in the list of
+// users once they've changed noticeably.
+func TestLatency(t *testing.T) {
+	c := NewClients(nil)
+	done := make(chan struct{})
+	defer close(done)
+	go c.handle(done)
+
+	client := &client{id: uuid.New(), SiteID: "1", Username: "alice"}
+	c.list[client.id] = client
+
+	sent := time.Now().Add(-30 * time.Millisecond)
+	_ = client.pong(strconv.FormatInt(sent.UnixNano(), 10))
+	_ = client.pong("not a ping")
+	if !c.latencyChanged() {
+		t.Errorf("got latency unchanged after the first pong, expected changed")
+	}
+
+	msg := c.usersMessage()
+	if len(msg.Users) != 1 || msg.Users[0].Latency < 30 {
+		t.Fatalf("got users %+v, expected alice with a latency of at least 30ms", msg.Users)
+	}
+	if c.latencyChanged() {
+		t.Errorf("got latency changed after the list was sent, expected unchanged")
+	}
+
+	// A slightly higher latency isn't worth sending the list again.
+	client.mu.Lock()
+	client.latency += 3 * time.Millisecond
+	client.mu.Unlock()
+	if c.latencyChanged() {
+		t.Errorf("got latency changed after 3ms, expected unchanged")
+	}
+}
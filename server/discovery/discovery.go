@@ -0,0 +1,302 @@
+// Package discovery advertises pairpad sessions on the local network, and finds the
+// advertised ones, with multicast DNS (mDNS) and DNS service discovery (DNS-SD).
+//
+// A session is advertised as an instance of the _pairpad._tcp service, with an SRV record
+// for its port, and a TXT record for its room and whether it's served over TLS. Only the
+// parts of mDNS needed for that are implemented: advertisers answer the queries for the
+// service, and browsers query it, and take the address of each answer's sender.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Service is the DNS-SD service type pairpad sessions are advertised as.
+const Service = "_pairpad._tcp.local."
+
+// ttl is the time to live of the advertised records, in seconds.
+const ttl = 120
+
+// mdnsAddr is the address mDNS queries and multicast responses are sent to.
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// A Session is a pairpad session advertised on the local network.
+type Session struct {
+	// Instance names the session, such as the host's name.
+	Instance string
+
+	// Addr is the address of the session's server, as host:port. Browse sets it to the
+	// address the advertisement was received from.
+	Addr string
+
+	// Port is the port of the session's server.
+	Port int
+
+	// Room is the session's room, or "" for the server's default room.
+	Room string
+
+	// Secure is set if the server is served over TLS.
+	Secure bool
+}
+
+// String describes the session, as listed by pairpad join -discover.
+func (s Session) String() string {
+	room := "the default room"
+	if s.Room != "" {
+		room = "room " + s.Room
+	}
+	desc := fmt.Sprintf("%s on %s (%s)", room, s.Addr, s.Instance)
+	if s.Secure {
+		desc += ", over TLS"
+	}
+	return desc
+}
+
+// An Advertiser answers the mDNS queries for pairpad sessions with its session, until
+// it's closed.
+type Advertiser struct {
+	conn *net.UDPConn
+	resp message
+	wg   sync.WaitGroup
+}
+
+// Advertise starts advertising the session on the local network, on port 5353. The
+// session's Addr is ignored: browsers find it from the advertiser's address.
+func Advertise(s Session) (*Advertiser, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsAddr)
+	if err != nil {
+		return nil, err
+	}
+	return serve(conn, s)
+}
+
+// serve answers the queries received on conn with the session's records.
+func serve(conn *net.UDPConn, s Session) (*Advertiser, error) {
+	resp, err := response(s)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	a := &Advertiser{conn: conn, resp: resp}
+	a.wg.Add(1)
+	go a.run()
+	return a, nil
+}
+
+// Close stops answering queries.
+func (a *Advertiser) Close() error {
+	err := a.conn.Close()
+	a.wg.Wait()
+	return err
+}
+
+// run reads queries until the connection is closed, and answers those asking for the
+// service.
+func (a *Advertiser) run() {
+	defer a.wg.Done()
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		m, err := decodeMessage(buf[:n])
+		if err != nil || m.flags&flagResponse != 0 {
+			continue
+		}
+		to, ok := a.answer(m, from)
+		if !ok {
+			continue
+		}
+		resp := a.resp
+		resp.id, resp.questions = m.id, m.questions
+		if b, err := resp.encode(); err == nil {
+			_, _ = a.conn.WriteToUDP(b, to)
+		}
+	}
+}
+
+// answer reports whether the query asks for the service, and returns the address the
+// response goes to: the querier's, for queries asking for a unicast response and those
+// not sent from the mDNS port (RFC 6762, section 6.7), or the mDNS group's.
+func (a *Advertiser) answer(m *message, from *net.UDPAddr) (*net.UDPAddr, bool) {
+	service := parseName(Service)
+	for _, q := range m.questions {
+		if !q.name.equal(service) || (q.qtype != typePTR && q.qtype != typeANY) {
+			continue
+		}
+		if q.class&classUnicast != 0 || from.Port != mdnsAddr.Port {
+			return from, true
+		}
+		return mdnsAddr, true
+	}
+	return nil, false
+}
+
+// response returns the response advertising the session: the PTR record naming it as an
+// instance of the service, and the SRV, TXT and A records describing it.
+func response(s Session) (message, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return message{}, err
+	}
+	host := name{strings.SplitN(hostname, ".", 2)[0], "local"}
+	instance := append(name{s.Instance}, parseName(Service)...)
+
+	txt := []string{"txtvers=1"}
+	if s.Room != "" {
+		txt = append(txt, "room="+s.Room)
+	}
+	if s.Secure {
+		txt = append(txt, "secure=1")
+	}
+
+	m := message{
+		flags:   flagResponse | flagAuthoritative,
+		answers: []record{{name: parseName(Service), rtype: typePTR, class: classIN, ttl: ttl, target: instance}},
+		extra: []record{
+			{name: instance, rtype: typeSRV, class: classIN, ttl: ttl, port: uint16(s.Port), target: host},
+			{name: instance, rtype: typeTXT, class: classIN, ttl: ttl, txt: txt},
+		},
+	}
+	for _, ip := range localIPs() {
+		m.extra = append(m.extra, record{name: host, rtype: typeA, class: classIN, ttl: ttl, ip: ip})
+	}
+
+	// Check that the names fit in DNS labels.
+	if _, err := m.encode(); err != nil {
+		return message{}, err
+	}
+	return m, nil
+}
+
+// localIPs returns the IPv4 addresses of the network interfaces other than the loopback
+// one.
+func localIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			ips = append(ips, ipNet.IP.To4())
+		}
+	}
+	return ips
+}
+
+// Browse queries the local network for pairpad sessions, and returns those advertised
+// before ctx is done, in the order their advertisements were received.
+func Browse(ctx context.Context) ([]Session, error) {
+	return browse(ctx, mdnsAddr)
+}
+
+// browse sends the queries for the service to addr, and collects the sessions in the
+// responses. The query is repeated every second, as multicast packets may be lost.
+func browse(ctx context.Context, addr *net.UDPAddr) ([]Session, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	query, err := (&message{questions: []question{{name: parseName(Service), qtype: typePTR, class: classIN}}}).encode()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(query, addr); err != nil {
+		return nil, err
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				// Unblock the read.
+				_ = conn.SetReadDeadline(time.Now())
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+				_, _ = conn.WriteToUDP(query, addr)
+			}
+		}
+	}()
+
+	var sessions []Session
+	seen := make(map[Session]bool)
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return sessions, nil
+			}
+			return sessions, err
+		}
+		m, err := decodeMessage(buf[:n])
+		if err != nil || m.flags&flagResponse == 0 {
+			continue
+		}
+		for _, s := range sessionsOf(m, from.IP) {
+			if !seen[s] {
+				seen[s] = true
+				sessions = append(sessions, s)
+			}
+		}
+	}
+}
+
+// sessionsOf returns the sessions advertised by a response received from ip: the
+// instances of the service with an SRV record in the response.
+func sessionsOf(m *message, ip net.IP) []Session {
+	service := parseName(Service)
+	var sessions []Session
+	for _, ptr := range m.answers {
+		if ptr.rtype != typePTR || !ptr.name.equal(service) || len(ptr.target) == 0 {
+			continue
+		}
+		s := Session{Instance: ptr.target[0]}
+		found := false
+		for _, r := range m.answers {
+			if !r.name.equal(ptr.target) {
+				continue
+			}
+			switch r.rtype {
+			case typeSRV:
+				s.Port, found = int(r.port), true
+			case typeTXT:
+				for _, kv := range r.txt {
+					key, value, _ := strings.Cut(kv, "=")
+					switch strings.ToLower(key) {
+					case "room":
+						s.Room = value
+					case "secure":
+						s.Secure = value == "1"
+					}
+				}
+			}
+		}
+		if found {
+			s.Addr = net.JoinHostPort(ip.String(), strconv.Itoa(s.Port))
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
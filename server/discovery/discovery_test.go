@@ -0,0 +1,66 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// TestBrowse checks that browsing finds the session of an advertiser answering the
+// queries sent to it, with its room, at the address it answered from.
+func TestBrowse(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := serve(conn, Session{Instance: "alice on laptop.home", Port: 8080, Room: "team-a", Secure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	sessions, err := browse(ctx, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Session{{Instance: "alice on laptop.home", Addr: "127.0.0.1:8080", Port: 8080, Room: "team-a", Secure: true}}
+	if diff := cmp.Diff(expected, sessions); diff != "" {
+		t.Errorf("got unexpected sessions (-expected +got):\n%s", diff)
+	}
+}
+
+// TestDecodeMessage checks that compressed names are decoded, and that pointer loops and
+// truncated messages are rejected.
+func TestDecodeMessage(t *testing.T) {
+	// A response whose PTR record points back at the question's name for its own name,
+	// and at the service's labels for its target's.
+	b := []byte{
+		0, 0, 0x84, 0, 0, 1, 0, 1, 0, 0, 0, 0,
+		8, '_', 'p', 'a', 'i', 'r', 'p', 'a', 'd', 4, '_', 't', 'c', 'p', 5, 'l', 'o', 'c', 'a', 'l', 0,
+		0, typePTR, 0, classIN,
+		0xC0, 12, 0, typePTR, 0, classIN, 0, 0, 0, 120, 0, 5,
+		3, 'b', 'o', 'b', 0xC0, 12,
+	}
+	m, err := decodeMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.answers) != 1 || !m.answers[0].name.equal(parseName(Service)) || !m.answers[0].target.equal(parseName("bob."+Service)) {
+		t.Errorf("got answers %+v, expected bob's instance of the service", m.answers)
+	}
+
+	for _, bad := range [][]byte{
+		b[:40],
+		{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xC0, 12, 0, 1, 0, 1},
+	} {
+		if _, err := decodeMessage(bad); err == nil {
+			t.Errorf("got no error decoding %v, expected one", bad)
+		}
+	}
+}
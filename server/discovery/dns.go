@@ -0,0 +1,292 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// DNS record types and classes used by DNS-SD.
+const (
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN = 1
+
+	// classUnicast is the top bit of a question's class, set by queriers asking for a
+	// unicast response (RFC 6762, section 5.4). In records, the same bit flushes caches.
+	classUnicast = 0x8000
+
+	// flagResponse is set in the header flags of responses, and flagAuthoritative in
+	// those of mDNS responses.
+	flagResponse      = 0x8000
+	flagAuthoritative = 0x0400
+)
+
+// errMalformed is returned when decoding a message which isn't valid DNS.
+var errMalformed = errors.New("malformed DNS message")
+
+// A name is a domain name, as its labels. Labels are kept apart rather than joined with
+// dots, as DNS-SD instance names may contain dots themselves.
+type name []string
+
+// parseName splits a domain name, such as "_pairpad._tcp.local.", into its labels.
+func parseName(s string) name {
+	return strings.Split(strings.TrimSuffix(s, "."), ".")
+}
+
+// equal reports whether the names are the same, ignoring case as DNS does.
+func (n name) equal(other name) bool {
+	if len(n) != len(other) {
+		return false
+	}
+	for i := range n {
+		if !strings.EqualFold(n[i], other[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// A question asks for the records of a name with a type.
+type question struct {
+	name  name
+	qtype uint16
+	class uint16
+}
+
+// A record is a resource record, with the data of the types used by DNS-SD decoded: the
+// target of PTR and SRV records, the port of SRV records, the strings of TXT records and
+// the address of A records.
+type record struct {
+	name   name
+	rtype  uint16
+	class  uint16
+	ttl    uint32
+	target name
+	port   uint16
+	txt    []string
+	ip     net.IP
+}
+
+// A message is a DNS message. Records of all sections are read into answers, as mDNS
+// responders put the records they answer with in either.
+type message struct {
+	id        uint16
+	flags     uint16
+	questions []question
+	answers   []record
+	extra     []record
+}
+
+// encode returns the message in the DNS wire format, without name compression.
+func (m *message) encode() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.id)
+	binary.BigEndian.PutUint16(b[2:], m.flags)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.answers)))
+	binary.BigEndian.PutUint16(b[10:], uint16(len(m.extra)))
+
+	var err error
+	for _, q := range m.questions {
+		if b, err = appendName(b, q.name); err != nil {
+			return nil, err
+		}
+		b = appendUint16(b, q.qtype)
+		b = appendUint16(b, q.class)
+	}
+	for _, r := range append(m.answers, m.extra...) {
+		if b, err = appendRecord(b, r); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// appendName appends a name as its length-prefixed labels, ended by the empty label.
+func appendName(b []byte, n name) ([]byte, error) {
+	for _, label := range n {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errors.New("invalid DNS label: " + label)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// appendRecord appends a record, with its data encoded according to its type.
+func appendRecord(b []byte, r record) ([]byte, error) {
+	var err error
+	if b, err = appendName(b, r.name); err != nil {
+		return nil, err
+	}
+	b = appendUint16(b, r.rtype)
+	b = appendUint16(b, r.class)
+	b = append(b, byte(r.ttl>>24), byte(r.ttl>>16), byte(r.ttl>>8), byte(r.ttl))
+
+	// The data's length is filled in once it's appended.
+	lengthAt := len(b)
+	b = append(b, 0, 0)
+	switch r.rtype {
+	case typePTR:
+		b, err = appendName(b, r.target)
+	case typeSRV:
+		b = append(b, 0, 0, 0, 0) // priority and weight
+		b = appendUint16(b, r.port)
+		b, err = appendName(b, r.target)
+	case typeTXT:
+		for _, s := range r.txt {
+			if len(s) > 255 {
+				return nil, errors.New("TXT string too long")
+			}
+			b = append(b, byte(len(s)))
+			b = append(b, s...)
+		}
+	case typeA:
+		b = append(b, r.ip.To4()...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(b[lengthAt:], uint16(len(b)-lengthAt-2))
+	return b, nil
+}
+
+// decodeMessage decodes a message in the DNS wire format. The data of records of types
+// other than PTR, SRV, TXT and A is skipped.
+func decodeMessage(b []byte) (*message, error) {
+	if len(b) < 12 {
+		return nil, errMalformed
+	}
+	m := &message{
+		id:    binary.BigEndian.Uint16(b[0:]),
+		flags: binary.BigEndian.Uint16(b[2:]),
+	}
+	questions := int(binary.BigEndian.Uint16(b[4:]))
+	records := int(binary.BigEndian.Uint16(b[6:])) + int(binary.BigEndian.Uint16(b[8:])) + int(binary.BigEndian.Uint16(b[10:]))
+
+	off := 12
+	for i := 0; i < questions; i++ {
+		n, next, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(b) {
+			return nil, errMalformed
+		}
+		m.questions = append(m.questions, question{
+			name:  n,
+			qtype: binary.BigEndian.Uint16(b[next:]),
+			class: binary.BigEndian.Uint16(b[next+2:]),
+		})
+		off = next + 4
+	}
+	for i := 0; i < records; i++ {
+		r, next, err := readRecord(b, off)
+		if err != nil {
+			return nil, err
+		}
+		m.answers = append(m.answers, r)
+		off = next
+	}
+	return m, nil
+}
+
+// readRecord reads the record at off, and returns it with the offset following it.
+func readRecord(b []byte, off int) (record, int, error) {
+	var r record
+	var err error
+	if r.name, off, err = readName(b, off); err != nil {
+		return r, 0, err
+	}
+	if off+10 > len(b) {
+		return r, 0, errMalformed
+	}
+	r.rtype = binary.BigEndian.Uint16(b[off:])
+	r.class = binary.BigEndian.Uint16(b[off+2:])
+	r.ttl = binary.BigEndian.Uint32(b[off+4:])
+	length := int(binary.BigEndian.Uint16(b[off+8:]))
+	start, end := off+10, off+10+length
+	if end > len(b) {
+		return r, 0, errMalformed
+	}
+
+	switch r.rtype {
+	case typePTR:
+		r.target, _, err = readName(b, start)
+	case typeSRV:
+		if length < 7 {
+			return r, 0, errMalformed
+		}
+		r.port = binary.BigEndian.Uint16(b[start+4:])
+		r.target, _, err = readName(b, start+6)
+	case typeTXT:
+		for i := start; i < end; {
+			n := int(b[i])
+			if i+1+n > end {
+				return r, 0, errMalformed
+			}
+			r.txt = append(r.txt, string(b[i+1:i+1+n]))
+			i += 1 + n
+		}
+	case typeA:
+		if length != net.IPv4len {
+			return r, 0, errMalformed
+		}
+		r.ip = net.IP(append([]byte(nil), b[start:end]...))
+	}
+	if err != nil {
+		return r, 0, err
+	}
+	return r, end, nil
+}
+
+// readName reads the name at off, following compression pointers, and returns it with
+// the offset following it in place.
+func readName(b []byte, off int) (name, int, error) {
+	var n name
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return nil, 0, errMalformed
+		}
+		length := int(b[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return n, next, nil
+		case length&0xC0 == 0xC0:
+			if off+1 >= len(b) {
+				return nil, 0, errMalformed
+			}
+			// Pointers can only loop, and names can't have more labels than bytes.
+			if jumps++; jumps > len(b) {
+				return nil, 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+		case length > 63:
+			return nil, 0, errMalformed
+		default:
+			if off+1+length > len(b) {
+				return nil, 0, errMalformed
+			}
+			n = append(n, string(b[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
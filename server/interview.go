@@ -0,0 +1,164 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/google/uuid"
+)
+
+// interviewerParam is the query parameter holding the interviewer token, for clients
+// joining as interviewers.
+const interviewerParam = "interviewer"
+
+// A promptBlock is a read-only range of a room's document, added by an interviewer. Its
+// positions are kept up to date with the operations relayed through the room.
+type promptBlock struct {
+	id         string
+	start, end int
+}
+
+// validInterviewerToken reports whether token is the server's interviewer token.
+func (s *Server) validInterviewerToken(token string) bool {
+	want := s.conf.InterviewerToken
+	return want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// checkEdit returns an error if the client isn't allowed to perform op: candidates can only
+// edit the document while they have edit access, and never inside prompt blocks. r.mu must
+// be held.
+func (r *room) checkEdit(c *client, op commons.Operation) error {
+	if c.interviewer {
+		return nil
+	}
+
+	c.mu.Lock()
+	readOnly := c.readOnly
+	c.mu.Unlock()
+	if readOnly {
+		return errors.New("you don't have edit access")
+	}
+
+	for _, p := range r.prompts {
+		inside := op.Position >= p.start && op.Position <= p.end
+		if op.Type == "insert" {
+			// Inserting at the block's start puts the text before the block.
+			inside = op.Position > p.start && op.Position <= p.end
+		}
+		if inside {
+			return errors.New("prompts are read-only")
+		}
+	}
+	return nil
+}
+
+// shiftPrompts moves the prompt blocks to account for an operation. Blocks whose text has
+// been deleted are removed. r.mu must be held.
+func (r *room) shiftPrompts(op commons.Operation) {
+	prompts := r.prompts[:0]
+	for _, p := range r.prompts {
+		switch op.Type {
+		case "insert":
+			n := len([]rune(op.Value))
+			if op.Position <= p.start {
+				p.start += n
+			}
+			if op.Position <= p.end {
+				p.end += n
+			}
+		case "delete":
+			if op.Position < p.start {
+				p.start--
+			}
+			if op.Position <= p.end {
+				p.end--
+			}
+		}
+		if p.end >= p.start {
+			prompts = append(prompts, p)
+		}
+	}
+	r.prompts = prompts
+}
+
+// setPrompt adds a prompt block, replaces the one with the same ID, or removes it if it's
+// deleted. r.mu must be held.
+func (r *room) setPrompt(a commons.Annotation) {
+	for i, p := range r.prompts {
+		if p.id == a.ID {
+			r.prompts = append(r.prompts[:i], r.prompts[i+1:]...)
+			break
+		}
+	}
+	if !a.Deleted {
+		r.prompts = append(r.prompts, promptBlock{id: a.ID, start: a.Start, end: a.End})
+	}
+}
+
+// setPrompts sets the prompt blocks from a document sync, unless the room already has some,
+// so that candidates can't remove them by sending a document. r.mu must be held.
+func (r *room) setPrompts(list []commons.Annotation) {
+	if len(r.prompts) > 0 {
+		return
+	}
+	for _, a := range list {
+		r.setPrompt(a)
+	}
+}
+
+// setAccess gives or removes the edit access of the candidate named in an access message,
+// or of all candidates (including those joining later) if it doesn't name one. It reports
+// whether the access of a client connected to this instance changed.
+func (r *room) setAccess(msg commons.Message) bool {
+	readOnly := msg.Text == commons.AccessReadOnly
+	if msg.Username == "" {
+		r.mu.Lock()
+		r.readOnly = readOnly
+		r.mu.Unlock()
+	}
+
+	changed := false
+	for client := range r.clients.getAll() {
+		if client.interviewer {
+			continue
+		}
+		client.mu.Lock()
+		if (msg.Username == "" || client.Username == msg.Username) && client.readOnly != readOnly {
+			client.readOnly = readOnly
+			changed = true
+		}
+		client.mu.Unlock()
+	}
+	return changed
+}
+
+// candidateAccess reports whether candidates joining the room are read-only.
+func (r *room) candidateAccess() (readOnly bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.readOnly
+}
+
+// addInterviewer records that the client with the given ID is an interviewer, so that its
+// join and leave messages are only relayed to the other interviewers.
+func (r *room) addInterviewer(id uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.interviewers == nil {
+		r.interviewers = make(map[uuid.UUID]bool)
+	}
+	r.interviewers[id] = true
+}
+
+// isInterviewer reports whether the client with the given ID is an interviewer. Once the
+// client's leave message has been relayed, forget is set to forget about the client.
+func (r *room) isInterviewer(id uuid.UUID, forget bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	interviewer := r.interviewers[id]
+	if forget {
+		delete(r.interviewers, id)
+	}
+	return interviewer
+}
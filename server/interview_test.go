@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+)
+
+// TestInterview checks that interviewers are hidden from candidates, and that candidates
+// can't edit prompts, or the document once their edit access is removed.
+func TestInterview(t *testing.T) {
+	ts := httptest.NewServer(New(Config{InterviewerToken: "secret"}).Handler())
+	defer ts.Close()
+
+	if msg := readUntil(t, dial(t, ts.URL+"?interviewer=wrong"), commons.ErrorMessage); msg.Code != commons.ErrorAuthFailed {
+		t.Errorf("got error code %q joining with an invalid interviewer token, expected %q", msg.Code, commons.ErrorAuthFailed)
+	}
+
+	candidate := dial(t, ts.URL)
+	readUntil(t, candidate, commons.SiteIDMessage)
+	_ = candidate.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "candidate"})
+	readUntil(t, candidate, commons.JoinAckMessage)
+
+	interviewer := dial(t, ts.URL+"?interviewer=secret")
+	readUntil(t, interviewer, commons.SiteIDMessage)
+	_ = interviewer.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "interviewer"})
+	readUntil(t, interviewer, commons.JoinAckMessage)
+	for {
+		// Interviewers see everyone.
+		if users := readUntil(t, interviewer, commons.UsersMessage); len(users.Users) == 2 {
+			if !users.Users[1].Hidden {
+				t.Errorf("interviewer isn't hidden: %+v", users.Users[1])
+			}
+			break
+		}
+	}
+
+	// The interviewer asks a question, which is a prompt block.
+	_ = interviewer.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: 1, Value: "Q?\n"}})
+	_ = interviewer.WriteJSON(commons.Message{Type: commons.SelectionMessage, Username: "interviewer", Selection: &commons.Selection{Start: 1, End: 2}})
+	_ = interviewer.WriteJSON(commons.Message{Type: commons.PingMessage, Username: "interviewer"})
+	_ = interviewer.WriteJSON(commons.Message{Type: commons.PromptMessage, Annotation: &commons.Annotation{ID: "q", Start: 1, End: 3}})
+
+	// Candidates don't see the interviewer join, nor in the list of users, nor the
+	// interviewer's selection and pings.
+	for {
+		var msg commons.Message
+		if err := candidate.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if hiddenFromCandidates(msg.Type) {
+			t.Errorf("candidate got the interviewer's %s", msg.Type)
+		}
+		if msg.Type == commons.UsersMessage && len(msg.Users) != 1 {
+			t.Errorf("candidate got users %+v, expected only itself", msg.Users)
+		}
+		if msg.Type == commons.PromptMessage {
+			break
+		}
+	}
+
+	tests := []struct {
+		description string
+		msg         commons.Message
+		expected    string // The error, or "" if the message is accepted.
+	}{
+		{"edit inside prompt", commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "delete", Position: 2}}, "prompts are read-only"},
+		{"insert inside prompt", commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: 3, Value: "x"}}, "prompts are read-only"},
+		{"insert after prompt", commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: 4, Value: "x"}}, ""},
+		{"insert before prompt", commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: 1, Value: "x"}}, ""},
+		{"edit moved prompt", commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "delete", Position: 4}}, "prompts are read-only"},
+		{"prompt from candidate", commons.Message{Type: commons.PromptMessage, Annotation: &commons.Annotation{ID: "c", Start: 1, End: 1}}, "only interviewers can add prompts"},
+		{"selection from candidate", commons.Message{Type: commons.SelectionMessage, Selection: &commons.Selection{Start: 1, End: 3}}, ""},
+		{"access from candidate", commons.Message{Type: commons.AccessMessage, Text: commons.AccessEdit}, "only interviewers can change edit access"},
+	}
+
+	for _, tc := range tests {
+		_ = candidate.WriteJSON(tc.msg)
+		if tc.expected == "" {
+			if got := readUntil(t, interviewer, tc.msg.Type); got.Operation != tc.msg.Operation {
+				t.Errorf("(%s) got %+v, expected %+v\n", tc.description, got.Operation, tc.msg.Operation)
+			}
+			continue
+		}
+		if got := readUntil(t, candidate, commons.ErrorMessage); got.Text != tc.expected {
+			t.Errorf("(%s) got error %q, expected %q\n", tc.description, got.Text, tc.expected)
+		}
+	}
+
+	// Once the interviewer removes the candidates' edit access, their edits are rejected.
+	_ = interviewer.WriteJSON(commons.Message{Type: commons.AccessMessage, Text: commons.AccessReadOnly})
+	for {
+		// The list of users is updated too, but may be sent first.
+		if users := readUntil(t, candidate, commons.UsersMessage); len(users.Users) == 1 && users.Users[0].ReadOnly {
+			break
+		}
+	}
+	_ = candidate.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: 1, Value: "x"}})
+	if got := readUntil(t, candidate, commons.ErrorMessage); got.Text != "you don't have edit access" {
+		t.Errorf("got error %q, expected %q", got.Text, "you don't have edit access")
+	}
+}
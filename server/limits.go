@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// limitSweepInterval is how often the limiter forgets the addresses without connections
+// whose rate limit has been reset.
+const limitSweepInterval = time.Minute
+
+// An ipLimiter limits the number of connections from each IP address, and the rate at
+// which they're opened.
+type ipLimiter struct {
+	// maxConns is the maximum number of connections open at once from an address, and
+	// rate the maximum number of connections opened per minute. Zero means no limit.
+	maxConns, rate int
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// conns holds the number of open connections from each address.
+	conns map[string]int
+
+	// buckets holds the token buckets limiting the rate of each address: a token is
+	// taken by each connection, and they're given back at rate per minute.
+	buckets map[string]*bucket
+
+	// lastSweep is the last time the limiter forgot the addresses which are back to their
+	// full rate, without connections.
+	lastSweep time.Time
+}
+
+// A bucket holds the tokens left to an address, as of when they were last counted.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newIPLimiter returns a limiter allowing maxConns connections at once and rate
+// connections per minute from each address. Zero means no limit.
+func newIPLimiter(maxConns, rate int) *ipLimiter {
+	return &ipLimiter{
+		maxConns: maxConns,
+		rate:     rate,
+		conns:    make(map[string]int),
+		buckets:  make(map[string]*bucket),
+	}
+}
+
+// acquire reserves a connection from ip, as of now, and returns a function releasing it.
+// It returns an error if the address has too many connections open, or has opened them
+// too fast.
+func (l *ipLimiter) acquire(ip string, now time.Time) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= limitSweepInterval {
+		l.sweep(now)
+	}
+
+	if l.maxConns > 0 && l.conns[ip] >= l.maxConns {
+		return nil, fmt.Errorf("too many connections from %s (maximum is %d)", ip, l.maxConns)
+	}
+	if l.rate > 0 {
+		b := l.refill(ip, now)
+		if b.tokens < 1 {
+			return nil, fmt.Errorf("too many connections from %s (maximum is %d per minute)", ip, l.rate)
+		}
+		b.tokens--
+	}
+
+	l.conns[ip]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.conns[ip]--; l.conns[ip] <= 0 {
+				delete(l.conns, ip)
+			}
+		})
+	}, nil
+}
+
+// refill returns the bucket of ip, with the tokens given back since it was last counted.
+// l.mu must be held.
+func (l *ipLimiter) refill(ip string, now time.Time) *bucket {
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate), last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens += now.Sub(b.last).Minutes() * float64(l.rate)
+	if b.tokens > float64(l.rate) {
+		b.tokens = float64(l.rate)
+	}
+	b.last = now
+	return b
+}
+
+// sweep forgets the buckets which are full again, as of now, so the limiter's memory
+// doesn't grow with every address it has seen. l.mu must be held.
+func (l *ipLimiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for ip := range l.buckets {
+		if l.refill(ip, now).tokens >= float64(l.rate) && l.conns[ip] == 0 {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// remoteIP returns the IP address of the client which sent r.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+)
+
+// TestIPLimiter checks that the connections from an address are limited, both at once and
+// per minute, and that the limits don't apply across addresses.
+func TestIPLimiter(t *testing.T) {
+	now := time.Now()
+
+	l := newIPLimiter(2, 0)
+	release, err := l.acquire("192.0.2.1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.acquire("192.0.2.1", now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.acquire("192.0.2.1", now); err == nil {
+		t.Error("got a third connection, expected the limit of 2 connections")
+	}
+	if _, err := l.acquire("192.0.2.2", now); err != nil {
+		t.Errorf("got error %v for another address, expected none", err)
+	}
+	release()
+	release()
+	if _, err := l.acquire("192.0.2.1", now); err != nil {
+		t.Errorf("got error %v after a connection was closed, expected none", err)
+	}
+
+	l = newIPLimiter(0, 2)
+	for i := 0; i < 2; i++ {
+		release, err := l.acquire("192.0.2.1", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	if _, err := l.acquire("192.0.2.1", now.Add(10*time.Second)); err == nil {
+		t.Error("got a third connection within a minute, expected the limit of 2 per minute")
+	}
+	release, err = l.acquire("192.0.2.1", now.Add(40*time.Second))
+	if err != nil {
+		t.Fatalf("got error %v after half a minute, expected a connection to be allowed", err)
+	}
+	release()
+
+	// Addresses back to their full rate, without connections, are forgotten.
+	l.sweep(now.Add(5 * time.Minute))
+	if len(l.buckets) != 0 {
+		t.Errorf("got %d buckets, expected none", len(l.buckets))
+	}
+}
+
+// TestConnLimit checks that a client over the limit of connections from its address is
+// turned away with an error it can retry after.
+func TestConnLimit(t *testing.T) {
+	ts := httptest.NewServer(New(Config{MaxConnsPerIP: 1}).Handler())
+	defer ts.Close()
+
+	readUntil(t, dial(t, ts.URL), commons.SiteIDMessage)
+	if msg := readUntil(t, dial(t, ts.URL), commons.ErrorMessage); msg.Code != commons.ErrorRateLimited {
+		t.Errorf("got error code %q, expected %q", msg.Code, commons.ErrorRateLimited)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// metrics holds the server's counters, updated atomically.
+type metrics struct {
+	// joined is the number of clients which have joined a room.
+	joined int64
+
+	// rejected is the number of clients turned away before joining a room.
+	rejected int64
+
+	// ipLimited is the number of clients turned away for going over the limits on the
+	// connections from their IP address. They're counted in rejected too.
+	ipLimited int64
+
+	// messages is the number of messages read from clients, including rejected ones.
+	messages int64
+}
+
+// MetricsHandler returns an HTTP handler serving the server's metrics, in the Prometheus
+// text format. It's meant to be served on a private address, apart from Handler.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		rooms, clients := len(s.rooms), 0
+		for _, room := range s.rooms {
+			room.mu.Lock()
+			clients += room.numClients
+			room.mu.Unlock()
+		}
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, m := range []struct {
+			name, help, typ string
+			value           int64
+		}{
+			{"pairpad_rooms", "Number of open rooms.", "gauge", int64(rooms)},
+			{"pairpad_clients", "Number of clients in the rooms, including those joining.", "gauge", int64(clients)},
+			{"pairpad_joins_total", "Number of clients which have joined a room.", "counter", atomic.LoadInt64(&s.metrics.joined)},
+			{"pairpad_rejected_total", "Number of clients turned away before joining a room.", "counter", atomic.LoadInt64(&s.metrics.rejected)},
+			{"pairpad_ip_limited_total", "Number of clients turned away for going over the connection limits of their IP address.", "counter", atomic.LoadInt64(&s.metrics.ipLimited)},
+			{"pairpad_messages_total", "Number of messages read from clients.", "counter", atomic.LoadInt64(&s.metrics.messages)},
+		} {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.typ, m.name, m.value)
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+)
+
+// TestMetrics checks that the metrics count the rooms, their clients and the clients
+// turned away.
+func TestMetrics(t *testing.T) {
+	s := New(Config{MaxClientsPerRoom: 1})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	alice := dial(t, ts.URL)
+	readUntil(t, alice, commons.SiteIDMessage)
+	bob := dial(t, ts.URL)
+	readUntil(t, bob, commons.ErrorMessage)
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, line := range []string{"pairpad_rooms 1", "pairpad_clients 1", "pairpad_joins_total 1", "pairpad_rejected_total 1"} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("got metrics %q, expected them to contain %q", body, line)
+		}
+	}
+}
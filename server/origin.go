@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// localhostNames are the hosts which are allowed when no origins have been configured.
+var localhostNames = []string{"localhost", "127.0.0.1", "::1"}
+
+// originAllowed reports whether origin may connect to the server.
+//
+// Each entry in allowed is either "*" (allow every origin), a full origin
+// (e.g. "https://pairpad.example.com"), or a bare host with an optional port (e.g.
+// "pairpad.example.com"), which matches that host over any scheme. If allowed is empty,
+// only localhost origins are allowed.
+//
+// Requests without an Origin header are not made by browsers, and are always
+// allowed, since cross-site WebSocket hijacking requires a browser.
+func originAllowed(origin string, allowed []string) bool {
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if len(allowed) == 0 {
+		hostname := u.Hostname()
+		for _, name := range localhostNames {
+			if strings.EqualFold(hostname, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, a := range allowed {
+		a = strings.TrimSpace(a)
+		switch {
+		case a == "*":
+			return true
+		case strings.Contains(a, "://"):
+			if strings.EqualFold(strings.TrimSuffix(a, "/"), u.Scheme+"://"+u.Host) {
+				return true
+			}
+		default:
+			if strings.EqualFold(a, u.Host) {
+				return true
+			}
+			// A bare host without a port matches that host on any port.
+			if _, _, err := net.SplitHostPort(a); err != nil && strings.EqualFold(a, u.Hostname()) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// sameOrigin reports whether origin has the same host as the request, which is the case
+// for the web client served by the server itself.
+func sameOrigin(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// checkOrigin is used by the WebSocket upgrader to reject connections from origins
+// which aren't allowed. Requests from the server's own origin are always allowed.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return sameOrigin(origin, r) || originAllowed(origin, s.conf.AllowedOrigins)
+}
+
+// cors sets CORS headers for requests from allowed origins, and answers preflight requests.
+func (s *Server) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && originAllowed(origin, s.conf.AllowedOrigins) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		}
+
+		// Answer preflight requests without passing them on.
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		description string
+		origin      string
+		allowed     []string
+		expected    bool
+	}{
+		{description: "no origin header", origin: "", expected: true},
+		{description: "localhost by default", origin: "http://localhost:3000", expected: true},
+		{description: "loopback IP by default", origin: "http://127.0.0.1:8080", expected: true},
+		{description: "IPv6 loopback by default", origin: "http://[::1]:8080", expected: true},
+		{description: "remote origin by default", origin: "https://evil.example", expected: false},
+		{description: "invalid origin", origin: "null", expected: false},
+		{description: "wildcard", origin: "https://evil.example", allowed: []string{"*"}, expected: true},
+		{description: "full origin match", origin: "https://pairpad.example.com",
+			allowed: []string{"https://pairpad.example.com"}, expected: true},
+		{description: "full origin scheme mismatch", origin: "http://pairpad.example.com",
+			allowed: []string{"https://pairpad.example.com"}, expected: false},
+		{description: "bare host on any port", origin: "https://pairpad.example.com:8443",
+			allowed: []string{"pairpad.example.com"}, expected: true},
+		{description: "host and port mismatch", origin: "https://pairpad.example.com:8443",
+			allowed: []string{"pairpad.example.com:443"}, expected: false},
+		{description: "localhost not allowed when origins are configured", origin: "http://localhost:3000",
+			allowed: []string{"pairpad.example.com"}, expected: false},
+	}
+
+	for _, tc := range tests {
+		got := originAllowed(tc.origin, tc.allowed)
+		if got != tc.expected {
+			t.Errorf("(%s) got != expected; got = %v, expected = %v\n", tc.description, got, tc.expected)
+		}
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		description string
+		origin      string
+		host        string
+		expected    bool
+	}{
+		{description: "same host and port", origin: "https://pairpad.example.com:8443", host: "pairpad.example.com:8443", expected: true},
+		{description: "case-insensitive host", origin: "https://PairPad.example.com", host: "pairpad.example.com", expected: true},
+		{description: "different port", origin: "https://pairpad.example.com:8443", host: "pairpad.example.com:8080", expected: false},
+		{description: "different host", origin: "https://evil.example", host: "pairpad.example.com", expected: false},
+		{description: "no origin header", origin: "", host: "pairpad.example.com", expected: false},
+		{description: "invalid origin", origin: "null", host: "pairpad.example.com", expected: false},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = tc.host
+		got := sameOrigin(tc.origin, r)
+		if got != tc.expected {
+			t.Errorf("(%s) got != expected; got = %v, expected = %v\n", tc.description, got, tc.expected)
+		}
+	}
+}
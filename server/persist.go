@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/burntcarrot/pairpad/server/store"
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+)
+
+// defaultSaveInterval is used when Config.SaveInterval is zero.
+const defaultSaveInterval = 10 * time.Second
+
+// defaultSnapshotOps is used when Config.SnapshotOps is zero.
+const defaultSnapshotOps = 1000
+
+// storeTimeout bounds the time spent loading or saving a document.
+const storeTimeout = 30 * time.Second
+
+// loadDocument loads the room's document from the store, if it hasn't been loaded yet.
+// It returns false if documents aren't persisted, or the document couldn't be loaded.
+// r.docMu must be held by the caller.
+func (r *room) loadDocument() bool {
+	if r.conf.Store == nil {
+		return false
+	}
+
+	if r.docState == docUnloaded {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+
+		doc, logged, err := loadStored(ctx, r.conf.Store, r.name)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			r.doc = crdt.New()
+			r.docState = docLoaded
+		case err != nil:
+			color.Red("[%s] Failed to load the document, it won't be saved: %s", r.name, err)
+			r.docState = docFailed
+		default:
+			r.doc = doc
+			r.logged = logged
+			r.docState = docLoaded
+
+			r.mu.Lock()
+			r.docLength = utf8.RuneCountInString(crdt.Content(r.doc))
+			r.mu.Unlock()
+		}
+	}
+
+	return r.docState == docLoaded
+}
+
+// loadStored loads a room's document from st, with the operations logged since it was
+// saved applied, if st is a store.Log. It also returns the number of logged operations.
+func loadStored(ctx context.Context, st store.Store, room string) (crdt.Document, int, error) {
+	var doc crdt.Document
+	var ops []commons.Operation
+	var err error
+	if log, ok := st.(store.Log); ok {
+		doc, ops, err = log.LoadLog(ctx, room)
+	} else {
+		doc, err = st.Load(ctx, room)
+	}
+	if err != nil {
+		return crdt.Document{}, 0, err
+	}
+
+	// The document keeps its character IDs, which the logged operations, and the clients
+	// which edited it, refer to. The characters the server generated before it restarted
+	// may have the IDs it would generate now, so its clock is moved past them; clients
+	// given the site IDs of earlier clients do the same when they get the document.
+	crdt.SyncClock(doc)
+	for _, op := range ops {
+		applyOperation(&doc, op)
+	}
+	return doc, len(ops), nil
+}
+
+// sendDocument sends the room's persisted document to the client with the given ID. It
+// returns false if documents aren't persisted, or the document couldn't be loaded.
+func (r *room) sendDocument(id uuid.UUID) bool {
+	r.docMu.Lock()
+	if !r.loadDocument() {
+		r.docMu.Unlock()
+		return false
+	}
+	doc := copyDocument(r.doc)
+	r.docMu.Unlock()
+
+	r.clients.broadcastOne(commons.NewDocSyncMessage(doc, id), id)
+	return true
+}
+
+// deliverDocument sends the document to a client which has just joined the room, or asked
+// for the document again. If documents are persisted, the server's own copy is sent, rather
+// than one asked from a client which may be slow to answer, or about to leave. Otherwise, or
+// if the room's document couldn't be loaded, it's requested from the other clients.
+//
+// It's called by handleMsg, so the document is ordered with the operations relayed to the
+// client: either the document holds an operation, or the operation is relayed after it.
+func (r *room) deliverDocument(id uuid.UUID) {
+	if !r.sendDocument(id) {
+		r.requestDocument(id)
+	}
+}
+
+// updateDocument applies update to the room's persisted document, if any.
+func (r *room) updateDocument(update func(doc *crdt.Document)) {
+	r.docMu.Lock()
+	defer r.docMu.Unlock()
+
+	if !r.loadDocument() {
+		return
+	}
+	update(&r.doc)
+	r.docChanged = true
+}
+
+// addOperation applies an operation relayed through the room to its persisted document, if
+// any, and keeps it to be logged.
+func (r *room) addOperation(op commons.Operation) {
+	r.updateDocument(func(doc *crdt.Document) {
+		applyOperation(doc, op)
+		if _, ok := r.conf.Store.(store.Log); ok && !r.snapshotDue {
+			r.unlogged = append(r.unlogged, op)
+		}
+	})
+}
+
+// setDocument replaces the room's persisted document, if any, with a document sent by a
+// client.
+func (r *room) setDocument(doc crdt.Document) {
+	r.updateDocument(func(d *crdt.Document) {
+		// The document is also written to clients later, so the room keeps its own copy.
+		*d = copyDocument(doc)
+
+		// The document may hold characters generated by the server before it restarted,
+		// with the IDs it would generate now.
+		crdt.SyncClock(*d)
+
+		// The logged operations don't lead to the new document.
+		r.unlogged = nil
+		r.snapshotDue = true
+	})
+}
+
+// copyDocument returns a copy of doc, which can be used while doc changes.
+func copyDocument(doc crdt.Document) crdt.Document {
+	return crdt.Document{Characters: append([]crdt.Character(nil), doc.Characters...)}
+}
+
+// applyOperation applies an operation to doc, as a client receiving it would.
+func applyOperation(doc *crdt.Document, op commons.Operation) {
+	if err := doc.ApplyRemote(op); err != nil {
+		color.Red("Failed to apply operation to the room's document: %s", err)
+	}
+}
+
+// save saves the room's document, if it has changed since it was last saved. If the store
+// is a store.Log, the operations applied since then are logged instead, until
+// conf.SnapshotOps have been logged, or snapshot is set, as when the room is closed.
+func (r *room) save(ctx context.Context, snapshot bool) error {
+	snapshotOps := r.conf.SnapshotOps
+	if snapshotOps == 0 {
+		snapshotOps = defaultSnapshotOps
+	}
+
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	r.docMu.Lock()
+	if r.docState != docLoaded || !r.docChanged && (!snapshot || r.logged == 0) {
+		r.docMu.Unlock()
+		return nil
+	}
+	r.docChanged = false
+
+	if log, ok := r.conf.Store.(store.Log); ok && !snapshot && !r.snapshotDue && r.logged+len(r.unlogged) < snapshotOps {
+		ops := r.unlogged
+		r.unlogged = nil
+		r.docMu.Unlock()
+
+		err := log.Append(ctx, r.name, ops)
+		r.docMu.Lock()
+		if err != nil {
+			// Try again next time, unless the document has been replaced meanwhile.
+			if !r.snapshotDue {
+				r.unlogged = append(ops, r.unlogged...)
+			}
+			r.docChanged = true
+		} else {
+			r.logged += len(ops)
+		}
+		r.docMu.Unlock()
+		return err
+	}
+
+	doc := copyDocument(r.doc)
+	r.unlogged = nil
+	r.snapshotDue = false
+	r.docMu.Unlock()
+
+	if err := r.conf.Store.Save(ctx, r.name, doc); err != nil {
+		// Try again next time. The operations applied since the document was copied are
+		// in the next snapshot.
+		r.docMu.Lock()
+		r.docChanged = true
+		r.unlogged = nil
+		r.snapshotDue = true
+		r.docMu.Unlock()
+		return err
+	}
+
+	r.docMu.Lock()
+	r.logged = 0
+	r.docMu.Unlock()
+	return nil
+}
+
+// persist saves the room's document every conf.SaveInterval while it changes, until done
+// is closed.
+func (r *room) persist(done <-chan struct{}) {
+	interval := r.conf.SaveInterval
+	if interval == 0 {
+		interval = defaultSaveInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+			if err := r.save(ctx, false); err != nil {
+				color.Red("[%s] Failed to save the document: %s", r.name, err)
+			}
+			cancel()
+		}
+	}
+}
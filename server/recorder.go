@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/fatih/color"
+)
+
+// A recorder writes the operations relayed by the server to a session recording.
+type recorder struct {
+	// mu protects against concurrent writes from several rooms.
+	mu sync.Mutex
+
+	// enc writes the records to the recording.
+	enc *json.Encoder
+}
+
+// newRecorder returns a recorder which writes to w. If w is nil, it returns nil, and
+// nothing is recorded.
+func newRecorder(w io.Writer) *recorder {
+	if w == nil {
+		return nil
+	}
+	return &recorder{enc: json.NewEncoder(w)}
+}
+
+// record appends an operation made by sender in the given room to the recording.
+// Failed writes are logged, and don't interrupt the session.
+func (r *recorder) record(room string, sender *client, op commons.Operation) {
+	if r == nil {
+		return
+	}
+
+	rec := commons.Record{Time: time.Now().UTC(), Room: room, Operation: op}
+	if sender != nil {
+		sender.mu.Lock()
+		rec.SiteID, rec.Username = sender.SiteID, sender.Username
+		sender.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(rec); err != nil {
+		color.Red("Failed to record operation: %s", err)
+	}
+}
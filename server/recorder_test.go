@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// A lockedBuffer is a bytes.Buffer which can be written by the server while the test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// TestRecordReplay checks that replaying a session recording, as cmd/replay does, rebuilds
+// the document the recorded client edited.
+func TestRecordReplay(t *testing.T) {
+	var recording lockedBuffer
+	ts := httptest.NewServer(New(Config{Record: &recording}).Handler())
+	defer ts.Close()
+
+	alice := dial(t, ts.URL)
+	site, err := strconv.Atoi(readUntil(t, alice, commons.SiteIDMessage).Text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = alice.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+	readUntil(t, alice, commons.JoinAckMessage)
+
+	// Alice's edits are generated as her client generates them.
+	prevSiteID, prevClock := crdt.SiteID, crdt.LocalClock
+	defer func() { crdt.SiteID, crdt.LocalClock = prevSiteID, prevClock }()
+	crdt.SiteID, crdt.LocalClock = site, 0
+
+	edits := []commons.Operation{
+		{Type: "insert", Position: 1, Value: "hello"},
+		{Type: "delete", Position: 1},
+		{Type: "insert", Position: 1, Value: "J"},
+		{Type: "insert", Position: 6, Value: ", world"},
+		{Type: "delete", Position: 12},
+	}
+	doc := crdt.New()
+	for i, edit := range edits {
+		op, err := doc.GenerateOperation(edit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = alice.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: op, Seq: i + 1})
+		readUntil(t, alice, commons.AckMessage)
+	}
+
+	records, err := commons.ReadRecords(bytes.NewReader(recording.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(edits) {
+		t.Fatalf("got %d records, expected %d", len(records), len(edits))
+	}
+
+	replayed := crdt.New()
+	for i, rec := range records {
+		if rec.Room != "default" || rec.SiteID != strconv.Itoa(site) || rec.Username != "alice" || rec.Time.IsZero() {
+			t.Errorf("record %d: got room %q, site %q, user %q at %v, expected alice's operation in the default room", i, rec.Room, rec.SiteID, rec.Username, rec.Time)
+		}
+		if _, err := rec.Operation.Apply(&replayed); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+	}
+	if got, expected := crdt.Content(replayed), crdt.Content(doc); got != expected {
+		t.Errorf("got %q replayed, expected %q", got, expected)
+	}
+	if got := crdt.Content(replayed); got != "Jello, worl" {
+		t.Errorf("got %q replayed, expected %q", got, "Jello, worl")
+	}
+}
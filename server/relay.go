@@ -0,0 +1,342 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+)
+
+// presenceInterval is how often an instance publishes the list of its clients, when rooms
+// are shared through a broker. Lists which haven't been published for three intervals
+// are dropped, so the clients of instances which have stopped disappear.
+const presenceInterval = 10 * time.Second
+
+// publishTimeout bounds the time spent publishing a message to the broker.
+const publishTimeout = 5 * time.Second
+
+// An envelope carries a message between the server instances sharing a room through a
+// broker.
+type envelope struct {
+	// Instance identifies the instance which published the message.
+	Instance string `json:"instance"`
+
+	// Message is the relayed message. For users messages, it lists the clients connected
+	// to the publishing instance.
+	Message commons.Message `json:"message"`
+
+	// Interviewer is set for messages sent by interviewers.
+	Interviewer bool `json:"interviewer,omitempty"`
+}
+
+// presence holds the clients in a room, on this and the other instances.
+type presence struct {
+	mu sync.Mutex
+
+	// local lists the clients connected to this instance.
+	local []commons.User
+
+	// remote lists the clients connected to the other instances, keyed by instance.
+	remote map[string]remoteUsers
+
+	// pending holds the IDs of the clients waiting for a document from another instance.
+	// Every instance with clients in the room answers, so only the first document is sent.
+	pending map[uuid.UUID]bool
+}
+
+// remoteUsers are the clients connected to another instance.
+type remoteUsers struct {
+	users []commons.User
+
+	// seen is the time at which the instance last published its clients.
+	seen time.Time
+}
+
+// publish sends a message to the other instances, if the room is shared through a broker.
+func (r *room) publish(env envelope) {
+	if r.conf.Broker == nil {
+		return
+	}
+
+	env.Instance = r.instance
+	data, err := json.Marshal(env)
+	if err != nil {
+		color.Red("[%s] Failed to encode a message for the broker: %s", r.name, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := r.conf.Broker.Publish(ctx, r.name, data); err != nil {
+		color.Red("[%s] Failed to publish a message: %s", r.name, err)
+	}
+}
+
+// relay receives the messages published by the other instances sharing the room, and
+// relays them to the room's clients, until done is closed. It also publishes the room's
+// clients every presenceInterval.
+func (r *room) relay(done <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	sub, err := r.conf.Broker.Subscribe(ctx, r.name)
+	if err != nil {
+		color.Red("[%s] Failed to subscribe to the room: %s", r.name, err)
+		return
+	}
+
+	// Tell the other instances about this one, so they tell it about their clients.
+	r.publishUsers()
+
+	ticker := time.NewTicker(presenceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data, ok := <-sub:
+			if !ok {
+				return
+			}
+			var env envelope
+			if err := json.Unmarshal(data, &env); err != nil {
+				color.Red("[%s] Failed to decode a message from the broker: %s", r.name, err)
+				continue
+			}
+			if env.Instance != r.instance {
+				r.handleRemote(env)
+			}
+
+		case <-ticker.C:
+			r.presence.mu.Lock()
+			expired := false
+			for instance, users := range r.presence.remote {
+				if time.Since(users.seen) > 3*presenceInterval {
+					delete(r.presence.remote, instance)
+					expired = true
+				}
+			}
+			r.presence.mu.Unlock()
+
+			r.publishUsers()
+			if expired {
+				r.broadcastUsers()
+			}
+		}
+	}
+}
+
+// handleRemote relays a message published by another instance to the room's clients.
+func (r *room) handleRemote(env envelope) {
+	msg := env.Message
+
+	switch msg.Type {
+	case commons.UsersMessage:
+		r.presence.mu.Lock()
+		if r.presence.remote == nil {
+			r.presence.remote = make(map[string]remoteUsers)
+		}
+		_, known := r.presence.remote[env.Instance]
+		r.presence.remote[env.Instance] = remoteUsers{users: msg.Users, seen: time.Now()}
+		r.presence.mu.Unlock()
+
+		// Tell instances which have just joined the room about this one's clients.
+		if !known {
+			r.publishUsers()
+		}
+		r.broadcastUsers()
+
+	case commons.DocReqMessage:
+		r.askDocument(msg.ID)
+
+	case commons.DocSyncMessage:
+		r.presence.mu.Lock()
+		pending := r.presence.pending[msg.ID]
+		delete(r.presence.pending, msg.ID)
+		r.presence.mu.Unlock()
+
+		if pending {
+			r.mu.Lock()
+			r.setPrompts(msg.Prompts)
+			r.mu.Unlock()
+			r.setDocument(msg.Document)
+			r.clients.broadcastOne(msg, msg.ID)
+		}
+
+	case commons.OperationMessage:
+		r.trackOperation(msg.Operation)
+		op := msg.Operation
+		r.addOperation(op)
+		r.clients.broadcastAll(msg)
+
+	case commons.JoinMessage, commons.LeaveMessage, commons.SelectionMessage, commons.PingMessage:
+		if env.Interviewer {
+			r.clients.broadcastRole(msg, uuid.Nil, true)
+		} else {
+			r.clients.broadcastAll(msg)
+		}
+
+	case commons.AnnotationMessage:
+		r.clients.broadcastAll(msg)
+
+	case commons.PromptMessage:
+		r.mu.Lock()
+		r.setPrompt(*msg.Annotation)
+		r.mu.Unlock()
+		r.clients.broadcastAll(msg)
+
+	case commons.AccessMessage:
+		changed := r.setAccess(msg)
+		r.clients.broadcastAll(msg)
+		if changed {
+			r.clients.sendUsernames()
+		}
+	}
+}
+
+// requestDocument asks a client to send the document to the client with the given ID: a
+// client connected to this instance if there's one, or else the clients connected to the
+// other instances. It's used when the server has no copy of the document.
+func (r *room) requestDocument(id uuid.UUID) {
+	if r.askDocument(id) || r.conf.Broker == nil {
+		return
+	}
+
+	r.presence.mu.Lock()
+	if r.presence.pending == nil {
+		r.presence.pending = make(map[uuid.UUID]bool)
+	}
+	r.presence.pending[id] = true
+	r.presence.mu.Unlock()
+
+	r.publish(envelope{Message: commons.Message{Type: commons.DocReqMessage, ID: id}})
+}
+
+// askDocument asks one of the clients connected to this instance which can edit the
+// document to send it to the client with the given ID. The request is kept until it's
+// answered, as documents are only accepted in answer to one. It returns false if there's
+// no such client.
+func (r *room) askDocument(id uuid.UUID) bool {
+	msg := commons.Message{Type: commons.DocReqMessage, ID: id}
+	for client := range r.clients.getAll() {
+		client.mu.Lock()
+		readOnly := client.readOnly
+		client.mu.Unlock()
+		if client.id == id || readOnly {
+			continue
+		}
+
+		// The request is kept before it's sent, so the answer can't come first.
+		req := docRequest{from: client.id, to: id}
+		r.mu.Lock()
+		if r.docRequests == nil {
+			r.docRequests = make(map[docRequest]bool)
+		}
+		r.docRequests[req] = true
+		r.mu.Unlock()
+
+		if err := client.send(msg); err != nil {
+			color.Red("ERROR: %s", err)
+			r.mu.Lock()
+			delete(r.docRequests, req)
+			r.mu.Unlock()
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// forgetDocRequests drops the document requests sent to a client which has left.
+func (r *room) forgetDocRequests(id uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for req := range r.docRequests {
+		if req.from == id {
+			delete(r.docRequests, req)
+		}
+	}
+}
+
+// sendDocumentSync sends a document sync to the client it's meant for, which may be
+// connected to another instance.
+func (r *room) sendDocumentSync(msg commons.Message) {
+	if r.conf.Broker != nil {
+		if client := <-r.clients.get(msg.ID); client == nil {
+			r.publish(envelope{Message: msg})
+			return
+		}
+
+		// Ignore the documents sent by other instances from now on.
+		r.presence.mu.Lock()
+		delete(r.presence.pending, msg.ID)
+		r.presence.mu.Unlock()
+	}
+	r.clients.broadcastOne(msg, msg.ID)
+}
+
+// setLocalUsers updates the list of the room's clients connected to this instance, and
+// tells the other instances.
+func (r *room) setLocalUsers(users []commons.User) {
+	r.presence.mu.Lock()
+	r.presence.local = users
+	r.presence.mu.Unlock()
+
+	r.publishUsers()
+}
+
+// publishUsers tells the other instances about the room's clients connected to this one.
+func (r *room) publishUsers() {
+	r.presence.mu.Lock()
+	users := r.presence.local
+	r.presence.mu.Unlock()
+
+	r.publish(envelope{Message: commons.Message{Type: commons.UsersMessage, Users: users}})
+}
+
+// broadcastUsers sends the list of the room's clients, on all instances, to the clients
+// connected to this instance.
+func (r *room) broadcastUsers() {
+	r.presence.mu.Lock()
+	users := append([]commons.User(nil), r.presence.local...)
+	for _, remote := range r.presence.remote {
+		users = append(users, remote.users...)
+	}
+	r.presence.mu.Unlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		a, _ := strconv.Atoi(users[i].SiteID)
+		b, _ := strconv.Atoi(users[j].SiteID)
+		return a < b
+	})
+
+	// Interviewers see everyone, and candidates everyone but the interviewers.
+	var visible []commons.User
+	for _, u := range users {
+		if !u.Hidden {
+			visible = append(visible, u)
+		}
+	}
+
+	color.Blue("[%s] usernames: %s", r.name, userNames(users))
+	r.clients.broadcastRole(commons.Message{Type: commons.UsersMessage, Text: userNames(users), Users: users}, uuid.Nil, true)
+	r.clients.broadcastRole(commons.Message{Type: commons.UsersMessage, Text: userNames(visible), Users: visible}, uuid.Nil, false)
+}
+
+// userNames returns the names of users, as sent in the text of users messages.
+func userNames(users []commons.User) string {
+	var names string
+	for _, u := range users {
+		names += u.Name + ","
+	}
+	return names
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/burntcarrot/pairpad/server/broker"
+)
+
+// TestRelay checks that clients connected to two servers sharing a broker collaborate.
+func TestRelay(t *testing.T) {
+	b := &broker.Memory{}
+	s1 := httptest.NewServer(New(Config{Broker: b}).Handler())
+	defer s1.Close()
+	s2 := httptest.NewServer(New(Config{Broker: b}).Handler())
+	defer s2.Close()
+
+	alice := dial(t, s1.URL)
+	aliceSite := readUntil(t, alice, commons.SiteIDMessage)
+	_ = alice.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+	readUntil(t, alice, commons.JoinAckMessage)
+
+	bob := dial(t, s2.URL)
+	bobSite := readUntil(t, bob, commons.SiteIDMessage)
+	if aliceSite.Text == bobSite.Text {
+		t.Errorf("both clients got the site ID %s", aliceSite.Text)
+	}
+
+	// Alice is asked for the document on Bob's behalf.
+	req := readUntil(t, alice, commons.DocReqMessage)
+	doc, _ := crdt.FromText("hi")
+	_ = alice.WriteJSON(commons.NewDocSyncMessage(doc, req.ID))
+	if sync := readUntil(t, bob, commons.DocSyncMessage); crdt.Content(sync.Document) != "hi" {
+		t.Errorf("got document %q, expected %q", crdt.Content(sync.Document), "hi")
+	}
+
+	_ = bob.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "bob"})
+	for {
+		// The list of users includes the clients of both servers.
+		if users := readUntil(t, bob, commons.UsersMessage); len(users.Users) == 2 {
+			break
+		}
+	}
+	if join := readUntil(t, alice, commons.JoinMessage); join.Username != "bob" {
+		t.Errorf("got join from %q, expected bob", join.Username)
+	}
+
+	op := commons.Operation{Type: "insert", Position: 3, Value: "!"}
+	_ = bob.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: op})
+	if got := readUntil(t, alice, commons.OperationMessage).Operation; got != op {
+		t.Errorf("got operation %+v, expected %+v", got, op)
+	}
+}
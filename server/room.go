@@ -0,0 +1,569 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/burntcarrot/pairpad/server/trace"
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// defaultRoom is the room clients join if they don't ask for one.
+const defaultRoom = "default"
+
+// roomNameRegexp matches the valid room names.
+var roomNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// An incoming message is one of a client's messages, queued for the room's handleMsg.
+type incoming struct {
+	commons.Message
+
+	// span is the span of the work done on the message, and queued the span of the time
+	// it waits in the queue. They're nil if the message isn't traced.
+	span, queued *trace.Span
+}
+
+// startSpan starts the span of the work done on a message received from the client with
+// the given site ID, if the room is traced.
+func (r *room) startSpan(msg commons.Message, siteID string) *trace.Span {
+	if !r.traced {
+		return nil
+	}
+	return r.conf.Tracer.Start("pairpad.message",
+		trace.String("pairpad.room", r.name),
+		trace.String("pairpad.message.type", string(msg.Type)),
+		trace.String("pairpad.site_id", siteID),
+	)
+}
+
+// A room is an editing session shared by the clients connected to it. Each room has its
+// own list of clients and message channels, so messages are only relayed within a room.
+type room struct {
+	// name identifies the room. Clients choose a room with the "room" query parameter.
+	name string
+
+	// conf holds the server's options.
+	conf Config
+
+	// Channel for client messages.
+	messageChan chan incoming
+
+	// traced is set if the messages of the room's clients are traced by conf.Tracer.
+	traced bool
+
+	// Channel for document sync messages.
+	syncChan chan commons.Message
+
+	// rec records the operations relayed through the room. It's nil if recording is disabled.
+	rec *recorder
+
+	// key signs the names given to the room's clients, in the tokens with which they keep
+	// their names when they reconnect.
+	key []byte
+
+	// instance identifies the server instance, when the room is shared with other
+	// instances through conf.Broker.
+	instance string
+
+	// presence holds the room's clients, on this and the other instances.
+	presence presence
+
+	// Holds information about all clients in the room.
+	clients *Clients
+
+	// mu protects numClients, lastActive, ended, docLength, prompts, readOnly,
+	// interviewers, participants and docRequests.
+	mu sync.Mutex
+
+	// session identifies the room's session, in watermarks. It's generated when the room
+	// is opened, at started.
+	session string
+	started time.Time
+
+	// participants holds the users who joined the session, in the order they joined.
+	participants []participant
+
+	// numClients is the number of clients in the room, including those that are still joining.
+	numClients int
+
+	// lastActive is the time at which a client last joined the room, or sent a message.
+	lastActive time.Time
+
+	// deadline is the end of the session, if conf.MaxSessionDuration is set. Otherwise,
+	// it's the zero time.
+	deadline time.Time
+
+	// ended is set once the session has reached its deadline, and stops clients from
+	// joining the room.
+	ended bool
+
+	// stopped is closed by stop, to stop the room's goroutines.
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	// docLength is the number of visible characters in the room's document, tracked from
+	// the operations and document syncs relayed through the room.
+	docLength int
+
+	// prompts holds the read-only prompt blocks added by interviewers.
+	prompts []promptBlock
+
+	// readOnly is set once an interviewer has removed the edit access of all candidates,
+	// and applies to the candidates joining later too.
+	readOnly bool
+
+	// interviewers holds the IDs of the interviewers in the room.
+	interviewers map[uuid.UUID]bool
+
+	// docRequests holds the document requests sent to the room's clients, which haven't
+	// been answered yet.
+	docRequests map[docRequest]bool
+
+	// saveMu serializes the calls to save, so the log and the snapshots of the document
+	// are written in order.
+	saveMu sync.Mutex
+
+	// docMu protects doc, docState, docChanged, unlogged, logged and snapshotDue.
+	docMu sync.Mutex
+
+	// doc is the room's document, kept when documents are persisted (conf.Store isn't nil).
+	// It's loaded from the store when the first client joins, and then kept up to date with
+	// the operations and document syncs relayed through the room.
+	doc crdt.Document
+
+	// docState tells whether doc has been loaded.
+	docState docState
+
+	// docChanged is set when doc changes, and cleared when it's saved.
+	docChanged bool
+
+	// unlogged holds the operations applied to doc since it was last saved or logged, when
+	// the store is a store.Log.
+	unlogged []commons.Operation
+
+	// logged is the number of operations in the store's log.
+	logged int
+
+	// snapshotDue is set when doc must be saved whole, rather than logged: when it's
+	// replaced by a document sync, which isn't an operation.
+	snapshotDue bool
+}
+
+// A docRequest is a docReq message sent to the client with the ID from, asking it for the
+// document of the client with the ID to.
+type docRequest struct {
+	from, to uuid.UUID
+}
+
+// docState is the state of a room's persisted document.
+type docState int
+
+const (
+	// docUnloaded means the document hasn't been loaded from the store yet.
+	docUnloaded docState = iota
+
+	// docLoaded means the document has been loaded, or the store had none.
+	docLoaded
+
+	// docFailed means the document couldn't be loaded. It isn't saved, so the stored
+	// document isn't overwritten.
+	docFailed
+)
+
+// latencyInterval is how often the list of users is sent again, with their latencies, if
+// they have changed.
+const latencyInterval = 30 * time.Second
+
+// newRoom returns a new room, and starts the goroutines which handle its clients and
+// messages. Operations are recorded by rec, if it isn't nil. Names are signed with key.
+// instance identifies the server instance to the others sharing the room through
+// conf.Broker. The goroutines return when serverDone is closed, or the room is stopped.
+func newRoom(name string, conf Config, rec *recorder, key []byte, instance string, serverDone <-chan struct{}) *room {
+	syncChan := make(chan commons.Message)
+
+	r := &room{
+		name:        name,
+		conf:        conf,
+		messageChan: make(chan incoming),
+		syncChan:    syncChan,
+		rec:         rec,
+		key:         key,
+		instance:    instance,
+		clients:     NewClients(syncChan),
+		lastActive:  time.Now(),
+		stopped:     make(chan struct{}),
+		session:     uuid.NewString(),
+	}
+	r.started = r.lastActive
+	if conf.Tracer != nil {
+		matched, _ := path.Match(conf.TraceRooms, name)
+		r.traced = conf.TraceRooms == "" || matched
+	}
+	if conf.MaxSessionDuration > 0 {
+		r.deadline = r.lastActive.Add(conf.MaxSessionDuration)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-serverDone:
+		case <-r.stopped:
+		}
+		close(done)
+	}()
+
+	// Handle state of client information.
+	go r.clients.handle(done)
+
+	// Handle incoming messages.
+	go r.handleMsg(done)
+
+	// Handle document syncing
+	go r.handleSync(done)
+
+	// Save the document periodically.
+	if conf.Store != nil {
+		go r.persist(done)
+	}
+
+	// Relay messages from the other instances sharing the room.
+	if conf.Broker != nil {
+		go r.relay(done)
+	}
+
+	return r
+}
+
+// A joinError tells a client why it can't join a room.
+type joinError struct {
+	code commons.ErrorCode
+	text string
+}
+
+func (e *joinError) Error() string {
+	return e.text
+}
+
+// join reserves a place in the room for a new client. It returns an error if the room is
+// full, or its session has ended.
+func (r *room) join() *joinError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ended {
+		return &joinError{commons.ErrorSessionEnded, fmt.Sprintf("the session in room %q has ended", r.name)}
+	}
+	if r.conf.MaxClientsPerRoom > 0 && r.numClients >= r.conf.MaxClientsPerRoom {
+		return &joinError{commons.ErrorRoomFull, fmt.Sprintf("room %q is full (maximum is %d clients)", r.name, r.conf.MaxClientsPerRoom)}
+	}
+	r.numClients++
+	r.lastActive = time.Now()
+	return nil
+}
+
+// leave frees the place of a client which has left the room.
+func (r *room) leave() {
+	r.mu.Lock()
+	r.numClients--
+	r.mu.Unlock()
+}
+
+// accept validates a message from a client, checks it against the room's limits, the
+// username rules and the client's access, and keeps track of the document's length and
+// prompt blocks. It returns an error if the message should be rejected.
+func (r *room) accept(c *client, msg commons.Message) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastActive = time.Now()
+
+	switch msg.Type {
+	case commons.JoinMessage:
+		return validateUsername(msg.Username)
+
+	case commons.AnnotationMessage:
+		return validateAnnotation(msg.Annotation)
+
+	case commons.PromptMessage:
+		if !c.interviewer {
+			return errors.New("only interviewers can add prompts")
+		}
+		r.setPrompt(*msg.Annotation)
+
+	case commons.AccessMessage:
+		if !c.interviewer {
+			return errors.New("only interviewers can change edit access")
+		}
+
+	case commons.SiteIDMessage, commons.JoinAckMessage, commons.UsersMessage, commons.ErrorMessage, commons.LeaveMessage, commons.NoticeMessage, commons.AckMessage:
+		return &commons.ValidationError{Field: "type", Reason: fmt.Sprintf("message type %q is only sent by the server", msg.Type)}
+
+	case commons.DocSyncMessage:
+		// Documents replace the room's, so they're only accepted from clients which can
+		// edit it, in answer to the room's requests.
+		c.mu.Lock()
+		readOnly := c.readOnly
+		c.mu.Unlock()
+		if readOnly {
+			return errors.New("you don't have edit access")
+		}
+		req := docRequest{from: c.id, to: msg.ID}
+		if !r.docRequests[req] {
+			return errors.New("the document wasn't requested from you")
+		}
+		delete(r.docRequests, req)
+
+		r.docLength = utf8.RuneCountInString(crdt.Content(msg.Document))
+		r.setPrompts(msg.Prompts)
+
+	case commons.OperationMessage:
+		if err := r.checkEdit(c, msg.Operation); err != nil {
+			return err
+		}
+
+		// Clients only insert characters with their own site ID, so the characters of
+		// different clients can't have the same IDs.
+		if insert := msg.Operation.Insert; insert != nil {
+			for _, char := range insert.Characters {
+				if strconv.Itoa(char.ID.SiteID) != c.SiteID {
+					return fmt.Errorf("character %v wasn't generated by site %s", char.ID, c.SiteID)
+				}
+			}
+		}
+
+		switch msg.Operation.Type {
+		case "insert":
+			n := utf8.RuneCountInString(msg.Operation.Value)
+			if r.conf.MaxDocumentSize > 0 && r.docLength+n > r.conf.MaxDocumentSize {
+				return fmt.Errorf("document has reached the maximum size of %d characters", r.conf.MaxDocumentSize)
+			}
+			r.docLength += n
+		case "delete":
+			r.trackDelete(msg.Operation)
+		}
+		r.shiftPrompts(msg.Operation)
+	}
+
+	return nil
+}
+
+// idle reports whether the room has had no activity for longer than timeout, as of now,
+// and how many clients are still in it.
+func (r *room) idle(now time.Time, timeout time.Duration) (idle bool, numClients int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return now.Sub(r.lastActive) > timeout, r.numClients
+}
+
+// disconnect closes the connections of the room's clients, with the given close code and
+// reason.
+func (r *room) disconnect(code int, reason string) {
+	closeMsg := websocket.FormatCloseMessage(code, reason)
+	for client := range r.clients.getAll() {
+		_ = client.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		_ = client.Conn.Close()
+	}
+}
+
+// close saves the room's document, if documents are persisted, and stops the room's
+// goroutines. It must only be called once the room has no clients, and can't get new ones.
+func (r *room) close() {
+	if r.conf.Store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		if err := r.save(ctx, true); err != nil {
+			color.Red("[%s] Failed to save the document: %s", r.name, err)
+		}
+		cancel()
+	}
+	r.stopOnce.Do(func() { close(r.stopped) })
+}
+
+// trackOperation keeps track of the document's length, its prompt blocks and the room's
+// activity for an operation relayed from another instance, which has already been
+// accepted there.
+func (r *room) trackOperation(op commons.Operation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastActive = time.Now()
+	r.shiftPrompts(op)
+
+	switch op.Type {
+	case "insert":
+		r.docLength += utf8.RuneCountInString(op.Value)
+	case "delete":
+		r.trackDelete(op)
+	}
+}
+
+// trackDelete keeps track of the document's length for a delete. Deletes past the end of
+// the document don't remove anything, and aren't counted. r.mu must be held.
+func (r *room) trackDelete(op commons.Operation) {
+	if op.Position >= 1 && op.Position <= r.docLength {
+		r.docLength--
+	}
+}
+
+// maxAnnotationLength is the maximum number of characters in an annotation's text.
+const maxAnnotationLength = 500
+
+// validateAnnotation checks the length of an annotation sent by a client. The rest of the
+// annotation has already been checked by Message.Validate.
+func validateAnnotation(a *commons.Annotation) error {
+	if !a.Deleted && utf8.RuneCountInString(a.Text) > maxAnnotationLength {
+		return fmt.Errorf("invalid annotation: longer than %d characters", maxAnnotationLength)
+	}
+	return nil
+}
+
+// hiddenFromCandidates reports whether messages of type t, when sent by interviewers, are
+// only relayed to the other interviewers, since they'd tell candidates who's in the room.
+func hiddenFromCandidates(t commons.MessageType) bool {
+	switch t {
+	case commons.JoinMessage, commons.LeaveMessage, commons.SelectionMessage, commons.PingMessage:
+		return true
+	}
+	return false
+}
+
+// handleMsg listens to the messageChan channel and broadcasts messages to other clients.
+func (r *room) handleMsg(done <-chan struct{}) {
+	for {
+		// Get message from messageChan.
+		var in incoming
+		select {
+		case in = <-r.messageChan:
+		case <-done:
+			return
+		}
+		in.queued.End()
+		r.handleMessage(in.Message, in.span)
+		in.span.End()
+	}
+}
+
+// handleMessage logs a message from messageChan, and broadcasts it. span is the span of
+// the work done on the message, if it's traced.
+func (r *room) handleMessage(msg commons.Message, span *trace.Span) {
+	// Log each message to stdout.
+	t := time.Now().Format(time.ANSIC)
+	if msg.Type == commons.JoinMessage {
+		// Tell the client which name it was given, with the token it can take the name
+		// back with. The token of the name it asked for isn't relayed.
+		verified := validNameToken(r.key, r.name, msg.Username, msg.Token)
+		msg.Token = ""
+		msg.Username = r.clients.updateName(msg.ID, msg.Username, verified)
+		if msg.Username == "" {
+			// The client has already left.
+			return
+		}
+		token := nameToken(r.key, r.name, msg.Username)
+		r.clients.broadcastOne(commons.Message{Type: commons.JoinAckMessage, Username: msg.Username, ID: msg.ID, Token: token}, msg.ID)
+		r.addParticipant(msg.Username, r.isInterviewer(msg.ID, false))
+		color.Green("%s >> [%s] %s %s (ID: %s)\n", t, r.name, msg.Username, msg.Text, msg.ID)
+		r.clients.sendUsernames()
+	} else if msg.Type == commons.DocReqMessage {
+		// Queued for a client joining the room, or requesting the document again.
+		r.deliverDocument(msg.ID)
+		return
+	} else if msg.Type == commons.LeaveMessage {
+		color.Yellow("%s >> [%s] %s left: %s (ID: %s)\n", t, r.name, msg.Username, msg.Text, msg.ID)
+		r.forgetDocRequests(msg.ID)
+	} else if msg.Type == commons.AnnotationMessage {
+		color.Green("annotation >> [%s] %+v from ID=%s\n", r.name, *msg.Annotation, msg.ID)
+	} else if msg.Type == commons.PromptMessage {
+		color.Green("prompt >> [%s] %+v from ID=%s\n", r.name, *msg.Annotation, msg.ID)
+	} else if msg.Type == commons.PingMessage {
+		color.Yellow("%s >> [%s] %s is asking for attention (ID: %s)\n", t, r.name, msg.Username, msg.ID)
+	} else if msg.Type == commons.SelectionMessage {
+		// Selections change with every cursor move, so they aren't logged.
+	} else if msg.Type == commons.AccessMessage {
+		color.Yellow("%s >> [%s] access of %q set to %s by ID=%s\n", t, r.name, msg.Username, msg.Text, msg.ID)
+		if r.setAccess(msg) {
+			r.clients.sendUsernames()
+		}
+	} else if msg.Type == commons.OperationMessage {
+		color.Green("operation >> [%s] %+v from ID=%s\n", r.name, msg.Operation, msg.ID)
+
+		// Operations are recorded here, so the recording and the audit log have the
+		// order in which they're relayed.
+		if r.rec != nil || r.conf.AuditLog != nil {
+			sender := <-r.clients.get(msg.ID)
+			r.rec.record(r.name, sender, msg.Operation)
+			r.conf.AuditLog.record(r.name, sender, msg.Operation)
+		}
+		op := msg.Operation
+		r.addOperation(op)
+	} else {
+		color.Green("%s >> [%s] unknown message type:  %v\n", t, r.name, msg)
+		r.clients.sendUsernames()
+		return
+	}
+
+	// Acknowledge numbered operations to their sender. The numbers are the sender's, so
+	// they aren't relayed.
+	seq := msg.Seq
+	msg.Seq = 0
+
+	interviewer := r.isInterviewer(msg.ID, msg.Type == commons.LeaveMessage)
+	broadcast := span.Child("broadcast")
+	if interviewer && hiddenFromCandidates(msg.Type) {
+		// Interviewers are only visible to the other interviewers.
+		r.clients.broadcastRole(msg, msg.ID, true)
+	} else {
+		r.clients.broadcastAllExcept(msg, msg.ID)
+	}
+	broadcast.End()
+	if r.conf.Broker != nil {
+		published := span.Child("publish")
+		r.publish(envelope{Message: msg, Interviewer: interviewer})
+		published.End()
+	}
+	if seq > 0 {
+		r.clients.broadcastOne(commons.Message{Type: commons.AckMessage, Seq: seq}, msg.ID)
+	}
+}
+
+// handleSync reads from the syncChan and sends the message to the appropriate user(s). It
+// also sends the list of users again every latencyInterval, if their latencies have
+// changed.
+func (r *room) handleSync(done <-chan struct{}) {
+	ticker := time.NewTicker(latencyInterval)
+	defer ticker.Stop()
+
+	for {
+		var syncMsg commons.Message
+		select {
+		case syncMsg = <-r.syncChan:
+		case <-ticker.C:
+			if !r.clients.latencyChanged() {
+				continue
+			}
+			syncMsg = r.clients.usersMessage()
+		case <-done:
+			return
+		}
+
+		switch syncMsg.Type {
+		case commons.DocSyncMessage:
+			r.setDocument(syncMsg.Document)
+			r.sendDocumentSync(syncMsg)
+		case commons.UsersMessage:
+			r.setLocalUsers(syncMsg.Users)
+			r.broadcastUsers()
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// TestMaxClientsPerRoom checks that clients are turned away from full rooms, and only from
+// those.
+func TestMaxClientsPerRoom(t *testing.T) {
+	tests := []struct {
+		description string
+		max         int
+		clients     int
+		rejected    bool
+	}{
+		{description: "no limit", max: 0, clients: 5, rejected: false},
+		{description: "below the limit", max: 3, clients: 3, rejected: false},
+		{description: "above the limit", max: 2, clients: 3, rejected: true},
+	}
+
+	for _, tc := range tests {
+		ts := httptest.NewServer(New(Config{MaxClientsPerRoom: tc.max}).Handler())
+
+		for i := 1; i < tc.clients; i++ {
+			readUntil(t, dial(t, ts.URL), commons.SiteIDMessage)
+		}
+
+		conn := dial(t, ts.URL)
+		var msg commons.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("(%s) %v", tc.description, err)
+		}
+		if rejected := msg.Type == commons.ErrorMessage && msg.Code == commons.ErrorRoomFull; rejected != tc.rejected {
+			t.Errorf("(%s) got %q message, expected rejection: %t", tc.description, msg.Type, tc.rejected)
+		}
+		ts.Close()
+	}
+}
+
+// TestMaxDocumentSize checks that inserts are rejected once the document is full, and that
+// only deletes removing a character make room for more.
+func TestMaxDocumentSize(t *testing.T) {
+	r := &room{conf: Config{MaxDocumentSize: 3}}
+	c := &client{SiteID: "1"}
+
+	tests := []struct {
+		description string
+		op          commons.Operation
+		accepted    bool
+		length      int
+	}{
+		{description: "insert", op: commons.Operation{Type: "insert", Position: 1, Value: "ab"}, accepted: true, length: 2},
+		{description: "insert up to the limit", op: commons.Operation{Type: "insert", Position: 3, Value: "c"}, accepted: true, length: 3},
+		{description: "insert past the limit", op: commons.Operation{Type: "insert", Position: 4, Value: "d"}, accepted: false, length: 3},
+		{description: "delete past the end", op: commons.Operation{Type: "delete", Position: 9}, accepted: true, length: 3},
+		{description: "insert after deleting nothing", op: commons.Operation{Type: "insert", Position: 4, Value: "d"}, accepted: false, length: 3},
+		{description: "delete", op: commons.Operation{Type: "delete", Position: 1}, accepted: true, length: 2},
+		{description: "insert after deleting", op: commons.Operation{Type: "insert", Position: 3, Value: "d"}, accepted: true, length: 3},
+	}
+
+	for _, tc := range tests {
+		err := r.accept(c, commons.Message{Type: commons.OperationMessage, Operation: tc.op})
+		if accepted := err == nil; accepted != tc.accepted {
+			t.Errorf("(%s) got error %v, expected acceptance: %t", tc.description, err, tc.accepted)
+		}
+		if r.docLength != tc.length {
+			t.Errorf("(%s) got document length %d, expected %d", tc.description, r.docLength, tc.length)
+		}
+	}
+}
+
+// TestMaxMessageSize checks that clients sending messages larger than the limit are
+// disconnected with the close code for messages too big, and that others aren't.
+func TestMaxMessageSize(t *testing.T) {
+	ts := httptest.NewServer(New(Config{MaxMessageSize: 1024}).Handler())
+	defer ts.Close()
+
+	tests := []struct {
+		description  string
+		size         int
+		disconnected bool
+	}{
+		{description: "small message", size: 10, disconnected: false},
+		{description: "message below the limit", size: 500, disconnected: false},
+		{description: "message above the limit", size: 2048, disconnected: true},
+	}
+
+	for _, tc := range tests {
+		conn := dial(t, ts.URL)
+		readUntil(t, conn, commons.SiteIDMessage)
+
+		// The username is replaced by the server, so it only pads the message.
+		op := commons.Operation{Type: "insert", Position: 1, Value: "a"}
+		msg := commons.Message{Type: commons.OperationMessage, Operation: op, Seq: 1, Username: strings.Repeat("a", tc.size)}
+		if err := conn.WriteJSON(msg); err != nil {
+			t.Fatalf("(%s) %v", tc.description, err)
+		}
+
+		var got commons.Message
+		for {
+			err := conn.ReadJSON(&got)
+			if err == nil {
+				if got.Type == commons.AckMessage {
+					break
+				}
+				continue
+			}
+
+			var closeErr *websocket.CloseError
+			if !tc.disconnected || !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+				t.Errorf("(%s) got %v, expected disconnection: %t", tc.description, err, tc.disconnected)
+			}
+			break
+		}
+		if got.Type == commons.AckMessage && tc.disconnected {
+			t.Errorf("(%s) got ack, expected disconnection", tc.description)
+		}
+		conn.Close()
+	}
+}
+
+// TestDocSync checks that documents are only accepted in answer to the room's requests, once,
+// and never from read-only candidates, who aren't asked for them.
+func TestDocSync(t *testing.T) {
+	ts := httptest.NewServer(New(Config{}).Handler())
+	defer ts.Close()
+
+	alice := dial(t, ts.URL)
+	_ = alice.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+	readUntil(t, alice, commons.JoinAckMessage)
+	bob := dial(t, ts.URL)
+	bobID := readUntil(t, bob, commons.SiteIDMessage).ID
+	_ = bob.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "bob"})
+	readUntil(t, bob, commons.JoinAckMessage)
+
+	doc, err := crdt.FromText("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := readUntil(t, alice, commons.DocReqMessage); req.ID != bobID {
+		t.Fatalf("got document request for %s, expected one for bob (%s)", req.ID, bobID)
+	}
+
+	tests := []struct {
+		description string
+		conn        *websocket.Conn
+		to          uuid.UUID
+		accepted    bool
+	}{
+		{description: "answer", conn: alice, to: bobID, accepted: true},
+		{description: "second answer", conn: alice, to: bobID, accepted: false},
+		{description: "unrequested", conn: bob, to: uuid.Nil, accepted: false},
+	}
+	for _, tc := range tests {
+		_ = tc.conn.WriteJSON(commons.NewDocSyncMessage(doc, tc.to))
+		if tc.accepted {
+			if got := readUntil(t, bob, commons.DocSyncMessage); crdt.Content(got.Document) != "hello" {
+				t.Errorf("(%s) got document %q, expected %q", tc.description, crdt.Content(got.Document), "hello")
+			}
+		} else if msg := readUntil(t, tc.conn, commons.ErrorMessage); msg.Code != commons.ErrorInvalidOperation {
+			t.Errorf("(%s) got error code %q, expected %q", tc.description, msg.Code, commons.ErrorInvalidOperation)
+		}
+	}
+
+	// Read-only candidates can't send the document, even when it was requested from them.
+	r := &room{docRequests: map[docRequest]bool{{from: bobID, to: uuid.Nil}: true}}
+	c := &client{id: bobID, readOnly: true}
+	if err := r.accept(c, commons.NewDocSyncMessage(doc, uuid.Nil)); err == nil {
+		t.Error("got document accepted from a read-only client, expected it rejected")
+	}
+}
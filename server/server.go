@@ -0,0 +1,712 @@
+// Package server implements pairpad's collaboration server.
+//
+// A Server upgrades incoming HTTP connections to WebSockets and relays CRDT
+// operations and document syncs between the connected clients. It can be run
+// standalone (see cmd/pairpad-server), or mounted on an existing mux:
+//
+//	s := server.New(server.Config{})
+//	mux.Handle("/pairpad/", http.StripPrefix("/pairpad", s.Handler()))
+//	...
+//	s.Shutdown(ctx)
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/server/broker"
+	"github.com/burntcarrot/pairpad/server/store"
+	"github.com/burntcarrot/pairpad/server/trace"
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrServerClosed is returned by Shutdown if the server has already been shut down.
+	ErrServerClosed = errors.New("pairpad: server closed")
+
+	// ErrClientNotFound is returned by Kick if there's no matching client.
+	ErrClientNotFound = errors.New("pairpad: client not found")
+)
+
+// Config holds the options used to create a Server.
+type Config struct {
+	// AllowedOrigins lists the origins which may open WebSocket connections. See
+	// originAllowed for the accepted formats. If empty, only localhost origins are allowed.
+	AllowedOrigins []string
+
+	// MaxClientsPerRoom is the maximum number of clients in a room. Zero means no limit.
+	MaxClientsPerRoom int
+
+	// MaxConnsPerIP is the maximum number of WebSocket connections open at once from an IP
+	// address. Clients over the limit are turned away with commons.ErrorRateLimited. Zero
+	// means no limit. Behind a proxy, all of the clients share the proxy's address.
+	MaxConnsPerIP int
+
+	// ConnRatePerIP is the maximum number of WebSocket connections opened per minute from
+	// an IP address, in bursts of up to as many. Clients over the limit are turned away
+	// with commons.ErrorRateLimited. Zero means no limit.
+	ConnRatePerIP int
+
+	// MaxDocumentSize is the maximum number of characters in a room's document. Inserts
+	// which would grow the document past the limit are rejected. Zero means no limit.
+	MaxDocumentSize int
+
+	// MaxMessageSize is the maximum size of a message read from a client, in bytes.
+	// Clients sending larger messages are disconnected, with the close code 1009 (message
+	// too big). Zero means no limit.
+	MaxMessageSize int64
+
+	// AccessLog, if not nil, receives a line for every request, as a JSON object: the
+	// client's address and user agent, the response's status (101 for upgraded
+	// connections), the error code of clients turned away, the room, site ID and name of
+	// clients who joined, and the duration and bytes sent and received, counting the
+	// WebSocket frames. WebSocket connections are logged when they're closed. Writes are
+	// serialized by the server.
+	AccessLog io.Writer
+
+	// Record, if not nil, receives a recording of every operation relayed by the server,
+	// as one JSON-encoded commons.Record per line. Writes are serialized by the server.
+	Record io.Writer
+
+	// AuditLog, if not nil, records who performed each operation, and when. It's queried
+	// through the API, at /rooms/{room}/audit.
+	AuditLog *AuditLog
+
+	// DisableWebClient stops the server from serving the web client at /web/.
+	DisableWebClient bool
+
+	// Store, if not nil, persists the documents of rooms, so they survive server restarts.
+	// A room's document is loaded when its first client joins, and sent by the server to
+	// the clients joining the room. It's saved every SaveInterval while it changes, and
+	// when the server shuts down. If Store is a store.Log, only the operations applied
+	// since the last save are appended to the log every SaveInterval, and the document is
+	// saved whole once SnapshotOps operations have been logged.
+	Store store.Store
+
+	// SaveInterval is how often changed documents are saved to Store. Zero means every 10 seconds.
+	SaveInterval time.Duration
+
+	// SnapshotOps is the number of operations logged, when Store is a store.Log, after
+	// which the document is saved whole. Zero means 1000.
+	SnapshotOps int
+
+	// IdleTimeout, if positive, is how long a room is kept without activity (no clients
+	// joining, and no messages from them). Clients still in an idle room are disconnected,
+	// then the room's document is saved (if documents are persisted), and the room is
+	// closed; clients joining it later start a new session. Zero means rooms are kept
+	// until the server shuts down.
+	IdleTimeout time.Duration
+
+	// MaxSessionDuration, if positive, is how long the session in a room lasts, from its
+	// creation. Clients are warned 10 minutes and 1 minute before the end; then they're
+	// disconnected, the room's document is saved (if documents are persisted), and the
+	// room is closed. Clients joining it later start a new session.
+	MaxSessionDuration time.Duration
+
+	// InterviewerToken, if not empty, enables interview mode: clients connecting with the
+	// query parameter interviewer=<token> join as interviewers. Interviewers are hidden
+	// from the other clients' lists of users, and can add read-only prompt blocks to the
+	// document and change the other clients' edit access.
+	InterviewerToken string
+
+	// APIToken, if not empty, enables the HTTP API, which reads and seeds the rooms'
+	// documents at /rooms/{room}/content, and the operations of the audit log at
+	// /rooms/{room}/audit. Requests must carry the token in an "Authorization: Bearer
+	// <token>" header. The content needs Store, since the server only keeps the rooms'
+	// documents when they're persisted.
+	APIToken string
+
+	// Watermark, if not nil, embeds the metadata of the rooms' sessions in the documents
+	// exported through the API, for the rooms it matches. The stored documents aren't
+	// watermarked, since clients joining the rooms get them.
+	Watermark *Watermark
+
+	// WriteTimeout bounds the time a client may take to accept the next part of a message
+	// written to it, so slow clients can receive large documents, as long as they keep up.
+	// Clients whose writes time out are disconnected. Zero means 10 seconds.
+	WriteTimeout time.Duration
+
+	// Tracer, if not nil, traces the work done on the messages of the clients in the rooms
+	// matching TraceRooms: each message gets a span, from its receipt to the end of its
+	// broadcast to the room, with children for the time spent queued, broadcasting it to
+	// the room's clients, and publishing it to the broker.
+	Tracer *trace.Tracer
+
+	// TraceRooms is a pattern, in the syntax of path.Match, of the names of the rooms
+	// traced by Tracer. All rooms are traced if it's empty.
+	TraceRooms string
+
+	// ReadTimeout is how long a client may stay silent, sending neither messages nor
+	// answers to the pings the server sends every 10 seconds, before it's disconnected.
+	// Zero means a minute.
+	ReadTimeout time.Duration
+
+	// OutboxSize is the number of messages which can wait to be written to a client.
+	// Messages are queued without waiting, so a slow client never delays the others: a
+	// client whose queue is full is disconnected instead. Zero means 1024.
+	OutboxSize int
+
+	// Broker, if not nil, shares the rooms with the other server instances using the same
+	// broker, so clients connected to different instances (e.g. behind a load balancer)
+	// can collaborate. Site IDs are then given by the broker, so they're unique across
+	// instances. Room limits are enforced by each instance for its own clients.
+	Broker broker.Broker
+}
+
+// Server is a pairpad collaboration server.
+type Server struct {
+	// conf holds the server's options.
+	conf Config
+
+	// Monotonically increasing site ID, unique to each client.
+	siteID int
+
+	// sites holds the site IDs of the connected clients, so a site ID presented by a
+	// reconnecting client is only reused if no other client has it.
+	sites map[int]bool
+
+	// siteKey signs the site IDs and names given to clients, so they can keep them when
+	// they reconnect.
+	siteKey []byte
+
+	// mu protects site ID increment operations, the rooms, and the closing state.
+	mu sync.Mutex
+
+	// Upgrader instance to upgrade all HTTP connections to a WebSocket.
+	upgrader websocket.Upgrader
+
+	// rooms holds the active rooms, keyed by name.
+	rooms map[string]*room
+
+	// closing is set once Shutdown has been called, and stops new connections from being accepted.
+	closing bool
+
+	// conns tracks the connection handlers which are still running.
+	conns sync.WaitGroup
+
+	// rec writes the session recording, if any.
+	rec *recorder
+
+	// instance identifies the server to the other instances sharing its rooms through conf.Broker.
+	instance string
+
+	// done is closed after all connections have been closed, and stops the server's goroutines.
+	done chan struct{}
+
+	// metrics holds the counters served by MetricsHandler.
+	metrics metrics
+
+	// access writes the access log, if any.
+	access *accessLog
+
+	// limiter limits the connections from each IP address, if any limit is set.
+	limiter *ipLimiter
+}
+
+const (
+	// defaultWriteTimeout is used when Config.WriteTimeout is zero.
+	defaultWriteTimeout = 10 * time.Second
+
+	// defaultReadTimeout is used when Config.ReadTimeout is zero.
+	defaultReadTimeout = time.Minute
+
+	// defaultOutboxSize is used when Config.OutboxSize is zero.
+	defaultOutboxSize = 1024
+)
+
+// New returns a new Server.
+func New(conf Config) *Server {
+	s := &Server{
+		conf:     conf,
+		rooms:    make(map[string]*room),
+		rec:      newRecorder(conf.Record),
+		access:   newAccessLog(conf.AccessLog),
+		instance: uuid.NewString(),
+		sites:    make(map[int]bool),
+		siteKey:  newSiteKey(),
+		done:     make(chan struct{}),
+	}
+	// The read buffer is allocated, rather than reused from the HTTP server, so all reads go
+	// through the hijacked connection, where the access log counts them.
+	s.upgrader = websocket.Upgrader{CheckOrigin: s.checkOrigin, ReadBufferSize: 4096}
+
+	if conf.MaxConnsPerIP > 0 || conf.ConnRatePerIP > 0 {
+		s.limiter = newIPLimiter(conf.MaxConnsPerIP, conf.ConnRatePerIP)
+	}
+
+	if conf.IdleTimeout > 0 {
+		go s.collectIdleRooms()
+	}
+
+	return s
+}
+
+// Handler returns the HTTP handler which serves pairpad clients. WebSocket connections
+// are accepted at the root, and the web client is served at /web/, unless it's disabled.
+// The rooms' content and audit log are served at /rooms/{room}/ if Config.APIToken is set.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleConn)
+	if s.conf.APIToken != "" {
+		mux.HandleFunc("/rooms/", s.handleAPI)
+	}
+	if !s.conf.DisableWebClient {
+		mux.Handle("/web/", http.StripPrefix("/web/", webHandler()))
+	}
+	return s.access.handler(s.cors(mux))
+}
+
+// Shutdown closes all client connections and waits for their handlers to return, then saves
+// the rooms' documents if they're persisted. Once Shutdown has been called, new connections
+// are refused. If ctx expires before all handlers have returned, Shutdown returns the
+// context's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.closing = true
+	rooms := make([]*room, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		rooms = append(rooms, r)
+	}
+	s.mu.Unlock()
+
+	// Closing the connections makes the handlers' reads fail, which removes the clients.
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for _, r := range rooms {
+		for client := range r.clients.getAll() {
+			_ = client.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+			_ = client.Conn.Close()
+		}
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		err := saveRooms(ctx, rooms)
+		close(s.done)
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// saveRooms saves the changed documents of rooms, when documents are persisted. It
+// returns the first error, after trying to save all of the rooms.
+func saveRooms(ctx context.Context, rooms []*room) error {
+	var firstErr error
+	for _, r := range rooms {
+		if r.conf.Store == nil {
+			continue
+		}
+		if err := r.save(ctx, true); err != nil {
+			color.Red("[%s] Failed to save the document: %s", r.name, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("saving room %q: %w", r.name, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// Kick disconnects the client with the given site ID from a room. The other clients in the
+// room are told that it was kicked.
+func (s *Server) Kick(roomName, siteID string) error {
+	s.mu.Lock()
+	r, ok := s.rooms[roomName]
+	s.mu.Unlock()
+	if !ok {
+		return ErrClientNotFound
+	}
+
+	for client := range r.clients.getAll() {
+		if client.SiteID == siteID {
+			client.kick()
+			return nil
+		}
+	}
+	return ErrClientNotFound
+}
+
+// collectIdleRooms closes the rooms which have been idle for longer than conf.IdleTimeout,
+// until the server shuts down.
+func (s *Server) collectIdleRooms() {
+	interval := s.conf.IdleTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.collect(now)
+		}
+	}
+}
+
+// collect closes the rooms which have been idle for longer than conf.IdleTimeout, as of now.
+// The clients of idle rooms are disconnected first, and the rooms are closed once they've
+// left, the next time collect is called.
+func (s *Server) collect(now time.Time) {
+	var empty, occupied []*room
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return
+	}
+	for name, r := range s.rooms {
+		idle, numClients := r.idle(now, s.conf.IdleTimeout)
+		switch {
+		case !idle:
+		case numClients == 0:
+			// New clients can only join rooms listed in s.rooms, so the room stays empty.
+			delete(s.rooms, name)
+			empty = append(empty, r)
+		default:
+			occupied = append(occupied, r)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, r := range occupied {
+		color.Yellow("[%s] Room idle for %s, disconnecting its clients", r.name, s.conf.IdleTimeout)
+		r.disconnect(websocket.CloseGoingAway, "session idle")
+	}
+
+	for _, r := range empty {
+		r.close()
+		color.Yellow("[%s] Closed idle room", r.name)
+	}
+}
+
+// nextSiteID returns a new site ID. If rooms are shared through a broker, the site ID is
+// given by the broker, so it's unique across instances.
+func (s *Server) nextSiteID() (int, error) {
+	if s.conf.Broker != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		defer cancel()
+		return s.conf.Broker.NextSiteID(ctx)
+	}
+
+	// Carefully increment and assign site ID with mutexes.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.siteID++
+	return s.siteID, nil
+}
+
+// room returns the room with the given name, creating it if it doesn't exist.
+// s.mu must be held by the caller.
+func (s *Server) room(name string) *room {
+	r, ok := s.rooms[name]
+	if !ok {
+		r = newRoom(name, s.conf, s.rec, s.siteKey, s.instance, s.done)
+		s.rooms[name] = r
+		if !r.deadline.IsZero() {
+			go s.endSession(r)
+		}
+	}
+	return r
+}
+
+// protocolParam is the query parameter holding the client's protocol version.
+const protocolParam = "protocol"
+
+// handshakeHeader holds the headers of the responses upgrading connections to WebSockets.
+var handshakeHeader = http.Header{commons.ProtocolHeader: {strconv.Itoa(commons.ProtocolVersion)}}
+
+// reject sends an error message with the given code to a client which is turned away, and
+// closes its connection with the given status. The error is also the close reason.
+func reject(conn *websocket.Conn, code commons.ErrorCode, text string, status int) {
+	_ = conn.WriteJSON(commons.Message{Type: commons.ErrorMessage, Code: code, Text: text})
+	closeMsg := websocket.FormatCloseMessage(status, text)
+	_ = conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+}
+
+// rejectConn turns away a client before it joins a room. The connection is upgraded, so
+// the client is told why with an error message: browsers can't read HTTP errors.
+func (s *Server) rejectConn(w http.ResponseWriter, r *http.Request, code commons.ErrorCode, text string, status int) {
+	conn, err := s.upgrader.Upgrade(w, r, handshakeHeader)
+	if err != nil {
+		color.Red("Error upgrading connection to websocket: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	color.Red("Rejecting client: %s", text)
+	atomic.AddInt64(&s.metrics.rejected, 1)
+	setAccessInfo(r.Context(), string(code), "", "", "")
+	reject(conn, code, text, status)
+}
+
+// handleConn handles incoming HTTP connections by adding the connection to activeClients and reads messages from the connection.
+func (s *Server) handleConn(w http.ResponseWriter, r *http.Request) {
+	// Send browsers opening the server's address to the web client.
+	if !s.conf.DisableWebClient && r.URL.Path == "/" && !websocket.IsWebSocketUpgrade(r) {
+		target := "web/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		// The location is kept relative (unlike with http.Redirect), so it works when the
+		// handler is mounted below a prefix.
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+
+	// Connections from addresses over their limits are turned away before anything else.
+	if s.limiter != nil && websocket.IsWebSocketUpgrade(r) {
+		release, err := s.limiter.acquire(remoteIP(r), time.Now())
+		if err != nil {
+			atomic.AddInt64(&s.metrics.ipLimited, 1)
+			s.rejectConn(w, r, commons.ErrorRateLimited, err.Error(), websocket.CloseTryAgainLater)
+			return
+		}
+		defer release()
+	}
+
+	roomName := r.URL.Query().Get("room")
+	if roomName == "" {
+		roomName = defaultRoom
+	}
+	if !roomNameRegexp.MatchString(roomName) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+	setAccessInfo(r.Context(), "", roomName, "", "")
+
+	// Clients which don't send their protocol version predate it, and speak version 1.
+	if v := r.URL.Query().Get(protocolParam); v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 1 || n > commons.ProtocolVersion {
+			s.rejectConn(w, r, commons.ErrorVersionMismatch, fmt.Sprintf("unsupported protocol version %q (the server speaks version %d)", v, commons.ProtocolVersion), websocket.CloseProtocolError)
+			return
+		}
+	}
+
+	// Interviewers join with the server's interviewer token.
+	interviewer := false
+	if token := r.URL.Query().Get(interviewerParam); token != "" {
+		if !s.validInterviewerToken(token) {
+			s.rejectConn(w, r, commons.ErrorAuthFailed, "invalid interviewer token", websocket.ClosePolicyViolation)
+			return
+		}
+		interviewer = true
+	}
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	s.conns.Add(1)
+	room := s.room(roomName)
+	// The place in the room is reserved while s.mu is held, so the room isn't collected
+	// while the connection is upgraded.
+	joinErr := room.join()
+	s.mu.Unlock()
+	defer s.conns.Done()
+
+	conn, err := s.upgrader.Upgrade(w, r, handshakeHeader)
+	if err != nil {
+		color.Red("Error upgrading connection to websocket: %v\n", err)
+		if joinErr == nil {
+			room.leave()
+		}
+		return
+	}
+	defer conn.Close()
+
+	// Reject the client with an error message if the room is full.
+	if joinErr != nil {
+		color.Red("Rejecting client: %s", joinErr)
+		atomic.AddInt64(&s.metrics.rejected, 1)
+		setAccessInfo(r.Context(), string(joinErr.code), "", "", "")
+		reject(conn, joinErr.code, joinErr.text, websocket.CloseTryAgainLater)
+		return
+	}
+	defer room.leave()
+
+	if s.conf.MaxMessageSize > 0 {
+		conn.SetReadLimit(s.conf.MaxMessageSize)
+	}
+
+	clientID := uuid.New()
+
+	siteID, err := s.claimSiteID(r.URL.Query().Get(siteParam), r.URL.Query().Get(siteTokenParam))
+	if err != nil {
+		color.Red("Rejecting client: failed to get a site ID: %s", err)
+		atomic.AddInt64(&s.metrics.rejected, 1)
+		setAccessInfo(r.Context(), string(commons.ErrorInternal), "", "", "")
+		reject(conn, commons.ErrorInternal, "failed to get a site ID", websocket.CloseInternalServerErr)
+		return
+	}
+	defer s.releaseSiteID(siteID)
+
+	writeTimeout := s.conf.WriteTimeout
+	if writeTimeout == 0 {
+		writeTimeout = defaultWriteTimeout
+	}
+	readTimeout := s.conf.ReadTimeout
+	if readTimeout == 0 {
+		readTimeout = defaultReadTimeout
+	}
+	outboxSize := s.conf.OutboxSize
+	if outboxSize == 0 {
+		outboxSize = defaultOutboxSize
+	}
+	client := &client{
+		Conn:         conn,
+		SiteID:       strconv.Itoa(siteID),
+		id:           clientID,
+		outbox:       make(chan interface{}, outboxSize),
+		writeTimeout: writeTimeout,
+		readTimeout:  readTimeout,
+		writeDone:    make(chan struct{}),
+		mu:           sync.Mutex{},
+		interviewer:  interviewer,
+		readOnly:     !interviewer && room.candidateAccess(),
+	}
+	// Every pong shows that the client is still there.
+	conn.SetPongHandler(func(payload string) error {
+		client.extendReadDeadline()
+		return client.pong(payload)
+	})
+	client.extendReadDeadline()
+	setAccessInfo(r.Context(), "", "", client.SiteID, "")
+	defer func() {
+		client.mu.Lock()
+		name := client.Username
+		client.mu.Unlock()
+		setAccessInfo(r.Context(), "", "", "", name)
+	}()
+
+	// Messages are only queued by the room's goroutines, and written by the client's own
+	// goroutine, so a slow client doesn't delay the messages to the others.
+	stopWrites := make(chan struct{})
+	defer close(stopWrites)
+	go client.writeLoop(stopWrites)
+
+	if interviewer {
+		room.addInterviewer(clientID)
+	}
+	room.clients.add(client)
+	atomic.AddInt64(&s.metrics.joined, 1)
+
+	siteIDMsg := commons.Message{Type: commons.SiteIDMessage, Text: client.SiteID, ID: clientID, Token: s.siteToken(siteID)}
+	room.clients.broadcastOne(siteIDMsg, clientID)
+
+	// The document is sent by handleMsg, in order with the operations relayed to the client.
+	room.messageChan <- incoming{Message: commons.Message{Type: commons.DocReqMessage, ID: clientID}}
+
+	if !room.deadline.IsZero() {
+		notice := commons.Message{Type: commons.NoticeMessage, Text: "The session ends in " + formatRemaining(time.Until(room.deadline))}
+		room.clients.broadcastOne(notice, clientID)
+	}
+
+	room.clients.sendUsernames()
+
+	// refused is set once the client has been refused, and its connection is being closed.
+	refused := false
+
+	// Read messages from the connection and send to channel to broadcast
+	for {
+		var msg commons.Message
+		err := client.read(&msg)
+		if err == nil || errors.Is(err, websocket.ErrReadLimit) {
+			atomic.AddInt64(&s.metrics.messages, 1)
+		}
+		if errors.Is(err, websocket.ErrReadLimit) {
+			color.Red("Disconnecting %s: message larger than %d bytes", client.Username, s.conf.MaxMessageSize)
+		}
+		if err != nil {
+			color.Red("Failed to read message. closing client connection with %s. Error: %s", client.Username, err)
+			room.clients.delete(clientID)
+
+			// Tell the other clients why the client left, unless everyone is being
+			// disconnected by Shutdown.
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if !closing {
+				client.mu.Lock()
+				name := client.Username
+				client.mu.Unlock()
+				room.messageChan <- incoming{Message: commons.Message{Type: commons.LeaveMessage, Username: name, Text: client.leaveReason(err), ID: clientID}}
+			}
+			return
+		}
+
+		if refused {
+			continue
+		}
+
+		span := room.startSpan(msg, client.SiteID)
+
+		// Check the message against the room's limits. Rejected operations are sent back
+		// to the client, which can then undo them.
+		if err := room.accept(client, msg); err != nil {
+			color.Red("Rejecting message from %s: %s", client.Username, err)
+			span.SetAttr(trace.String("pairpad.rejected", err.Error()))
+			span.End()
+
+			// Clients can't stay in the session under a name which was refused, so they're
+			// disconnected, and can connect again with another one.
+			if msg.Type == commons.JoinMessage {
+				client.refuse(commons.ErrorInvalidUsername, err.Error(), websocket.ClosePolicyViolation)
+				refused = true
+				continue
+			}
+			client.sendError(err.Error(), msg)
+			continue
+		}
+
+		// A client requesting the document again (for example, after receiving a
+		// corrupted one) gets it as a joining client does.
+		if msg.Type == commons.DocReqMessage {
+			room.messageChan <- incoming{Message: commons.Message{Type: commons.DocReqMessage, ID: clientID}, span: span, queued: span.Child("queue")}
+			continue
+		}
+
+		// Send docSync to handleSync function. DocSync message IDs refer to
+		// their destination. This channel send should happen before reassigning the
+		// msg.ID
+		if msg.Type == commons.DocSyncMessage {
+			queued := span.Child("queue")
+			room.syncChan <- msg
+			queued.End()
+			span.End()
+			continue
+		}
+
+		// Set message ID as the ID of the sending client. Most message IDs refer to
+		// their origin.
+		msg.ID = clientID
+		client.attribute(&msg)
+
+		// Send message to messageChan for logging and broadcasting
+		room.messageChan <- incoming{Message: msg, span: span, queued: span.Child("queue")}
+	}
+}
@@ -0,0 +1,745 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+	"github.com/burntcarrot/pairpad/server/store"
+	"github.com/burntcarrot/pairpad/server/trace"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// dial opens a connection to the test server at url, which is closed when the test ends.
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntil reads messages from conn until it gets one of type typ.
+func readUntil(t *testing.T, conn *websocket.Conn, typ commons.MessageType) commons.Message {
+	t.Helper()
+	for {
+		var msg commons.Message
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for a %s message: %v", typ, err)
+		}
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+// TestCollect checks that idle rooms are closed, and their documents saved.
+func TestCollect(t *testing.T) {
+	st := &store.Dir{Path: t.TempDir()}
+	s := New(Config{Store: st, IdleTimeout: time.Hour})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	conn := dial(t, ts.URL)
+	_ = conn.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+	for _, op := range []commons.Operation{{Type: "insert", Position: 1, Value: "h"}, {Type: "insert", Position: 2, Value: "i"}} {
+		_ = conn.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: op})
+	}
+
+	// Wait for the operations to be applied.
+	s.mu.Lock()
+	r := s.rooms[defaultRoom]
+	s.mu.Unlock()
+	if r == nil {
+		t.Fatal("room wasn't created")
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		r.mu.Lock()
+		length := r.docLength
+		r.mu.Unlock()
+		if length == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got document length %d, expected 2", length)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Active rooms are kept.
+	s.collect(time.Now())
+	if idle, _ := r.idle(time.Now(), time.Hour); idle {
+		t.Error("active room is idle")
+	}
+
+	// The clients of idle rooms are disconnected first.
+	later := time.Now().Add(2 * time.Hour)
+	s.collect(later)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg commons.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("got error %v, expected close with status %d", err, websocket.CloseGoingAway)
+			}
+			break
+		}
+	}
+
+	// Then the room is closed, once its clients have left.
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if _, numClients := r.idle(later, time.Hour); numClients == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client didn't leave the room")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.collect(later)
+
+	s.mu.Lock()
+	_, ok := s.rooms[defaultRoom]
+	s.mu.Unlock()
+	if ok {
+		t.Error("idle room wasn't closed")
+	}
+
+	doc, err := st.Load(context.Background(), defaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := crdt.Content(doc); got != "hi" {
+		t.Errorf("got saved document %q, expected %q", got, "hi")
+	}
+}
+
+// TestEndSession checks that clients are warned before the end of a session, and
+// disconnected at its end.
+func TestEndSession(t *testing.T) {
+	defer func(warnings []time.Duration) { sessionWarnings = warnings }(sessionWarnings)
+	sessionWarnings = []time.Duration{time.Second}
+
+	st := &store.Dir{Path: t.TempDir()}
+	s := New(Config{Store: st, MaxSessionDuration: 2 * time.Second})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	conn := dial(t, ts.URL)
+	_ = conn.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: 1, Value: "a"}})
+
+	// Clients are told when the session ends as they join, then warned.
+	var notices []string
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg commons.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("got error %v, expected close with status %d", err, websocket.CloseNormalClosure)
+			}
+			break
+		}
+		if msg.Type == commons.NoticeMessage {
+			notices = append(notices, msg.Text)
+		}
+	}
+	expected := []string{"The session ends in less than a minute", "The session ends in less than a minute"}
+	if !reflect.DeepEqual(notices, expected) {
+		t.Errorf("got notices %q, expected %q", notices, expected)
+	}
+
+	// The room is closed once its client has left, and its document saved.
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		s.mu.Lock()
+		_, ok := s.rooms[defaultRoom]
+		s.mu.Unlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("ended session wasn't closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	doc, err := st.Load(context.Background(), defaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := crdt.Content(doc); got != "a" {
+		t.Errorf("got saved document %q, expected %q", got, "a")
+	}
+}
+
+// TestJoinFromStore checks that clients joining a room, or requesting its document again,
+// get the document from the server when documents are persisted, rather than from the
+// other clients.
+func TestJoinFromStore(t *testing.T) {
+	st := store.Dir{Path: t.TempDir()}
+	saved, _ := crdt.FromText("hello")
+	if err := st.Save(context.Background(), defaultRoom, saved); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(New(Config{Store: st}).Handler())
+	defer ts.Close()
+
+	alice := dial(t, ts.URL)
+	if sync := readUntil(t, alice, commons.DocSyncMessage); crdt.Content(sync.Document) != "hello" {
+		t.Errorf("got document %q, expected %q", crdt.Content(sync.Document), "hello")
+	}
+	_ = alice.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+	readUntil(t, alice, commons.JoinAckMessage)
+	_ = alice.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: 6, Value: "!"}, Seq: 1})
+	readUntil(t, alice, commons.AckMessage)
+
+	bob := dial(t, ts.URL)
+	if sync := readUntil(t, bob, commons.DocSyncMessage); crdt.Content(sync.Document) != "hello!" {
+		t.Errorf("got document %q, expected %q", crdt.Content(sync.Document), "hello!")
+	}
+
+	// Requesting the document again gets the server's copy too.
+	_ = bob.WriteJSON(commons.Message{Type: commons.DocReqMessage})
+	if sync := readUntil(t, bob, commons.DocSyncMessage); crdt.Content(sync.Document) != "hello!" {
+		t.Errorf("got document %q, expected %q", crdt.Content(sync.Document), "hello!")
+	}
+
+	// Alice isn't asked for the document.
+	_ = bob.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "bob"})
+	for {
+		var msg commons.Message
+		_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := alice.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for Bob to join: %v", err)
+		}
+		if msg.Type == commons.DocReqMessage {
+			t.Error("got a document request")
+		}
+		if msg.Type == commons.JoinMessage {
+			break
+		}
+	}
+}
+
+// TestSaveLog checks that the operations applied to a document are logged when the store
+// is a store.Log, and that the document is saved whole once enough have been logged.
+func TestSaveLog(t *testing.T) {
+	ctx := context.Background()
+	st := store.Dir{Path: t.TempDir()}
+	r := newRoom("log", Config{Store: st, SnapshotOps: 3}, nil, nil, "", nil)
+	defer r.close()
+
+	for i, text := range []string{"a", "b", "c", "d"} {
+		r.addOperation(commons.Operation{Type: "insert", Position: i + 1, Value: text})
+		if i == 0 {
+			continue
+		}
+		if err := r.save(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// "a" and "b" were logged, "c" was saved with a snapshot, and "d" was logged.
+	doc, ops, err := st.LoadLog(ctx, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []commons.Operation{{Type: "insert", Position: 4, Value: "d"}}
+	if crdt.Content(doc) != "abc" || !reflect.DeepEqual(ops, expected) {
+		t.Errorf("got document %q and log %+v, expected %q and %+v", crdt.Content(doc), ops, "abc", expected)
+	}
+
+	// The log is applied to the document when it's loaded.
+	loaded := newRoom("log", Config{Store: st}, nil, nil, "", nil)
+	defer loaded.close()
+	loaded.docMu.Lock()
+	defer loaded.docMu.Unlock()
+	if !loaded.loadDocument() {
+		t.Fatal("failed to load the document")
+	}
+	if got := crdt.Content(loaded.doc); got != "abcd" {
+		t.Errorf("got document %q, expected %q", got, "abcd")
+	}
+}
+
+// TestLoadLog checks that a document loaded with its log keeps the IDs of its characters,
+// which the logged operations refer to, and that the room's length is counted in runes.
+func TestLoadLog(t *testing.T) {
+	ctx := context.Background()
+	st := store.Dir{Path: t.TempDir()}
+
+	saved := crdt.New()
+	h := crdt.Character{ID: crdt.CharacterID{SiteID: 5, Clock: 1}, Visible: true, Value: "h", IDPrevious: crdt.IDStart, IDNext: crdt.IDEnd}
+	if _, err := saved.IntegrateInsert(h, saved.Find(crdt.IDStart), saved.Find(crdt.IDEnd)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Save(ctx, "log", saved); err != nil {
+		t.Fatal(err)
+	}
+	e := crdt.Character{ID: crdt.CharacterID{SiteID: 5, Clock: 2}, Visible: true, Value: "é", IDPrevious: h.ID, IDNext: crdt.IDEnd}
+	op := commons.Operation{Type: "insert", Position: 2, Value: "é", Insert: &crdt.InsertOp{Characters: []crdt.Character{e}}}
+	if err := st.Append(ctx, "log", []commons.Operation{op}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRoom("log", Config{Store: st}, nil, nil, "", nil)
+	defer r.close()
+	r.docMu.Lock()
+	defer r.docMu.Unlock()
+	if !r.loadDocument() {
+		t.Fatal("failed to load the document")
+	}
+	if got := crdt.Content(r.doc); got != "hé" {
+		t.Errorf("got document %q, expected %q", got, "hé")
+	}
+	if got := []crdt.CharacterID{crdt.IthVisible(r.doc, 1).ID, crdt.IthVisible(r.doc, 2).ID}; !reflect.DeepEqual(got, []crdt.CharacterID{h.ID, e.ID}) {
+		t.Errorf("got IDs %v, expected %v", got, []crdt.CharacterID{h.ID, e.ID})
+	}
+	if r.docLength != 2 {
+		t.Errorf("got length %d, expected 2", r.docLength)
+	}
+}
+
+// TestReject checks that clients turned away are told why, with an error code.
+func TestReject(t *testing.T) {
+	ts := httptest.NewServer(New(Config{MaxClientsPerRoom: 1}).Handler())
+	defer ts.Close()
+
+	alice := dial(t, ts.URL)
+	readUntil(t, alice, commons.SiteIDMessage)
+
+	tests := []struct {
+		description string
+		query       string
+		code        commons.ErrorCode
+	}{
+		{description: "full room", query: "", code: commons.ErrorRoomFull},
+		{description: "newer protocol", query: "?protocol=2", code: commons.ErrorVersionMismatch},
+		{description: "invalid protocol", query: "?protocol=one", code: commons.ErrorVersionMismatch},
+	}
+	for _, tc := range tests {
+		msg := readUntil(t, dial(t, ts.URL+tc.query), commons.ErrorMessage)
+		if msg.Code != tc.code {
+			t.Errorf("(%s) got error code %q, expected %q", tc.description, msg.Code, tc.code)
+		}
+	}
+
+	// The handshake tells clients which protocol version the server speaks, even when
+	// they're turned away.
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?protocol=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if got, expected := resp.Header.Get(commons.ProtocolHeader), strconv.Itoa(commons.ProtocolVersion); got != expected {
+		t.Errorf("got protocol header %q, expected %q", got, expected)
+	}
+
+	// Rejected operations are invalid operations.
+	_ = alice.WriteJSON(commons.Message{Type: commons.PromptMessage, Annotation: &commons.Annotation{ID: "p", Start: 1, End: 1}})
+	if msg := readUntil(t, alice, commons.ErrorMessage); msg.Code != commons.ErrorInvalidOperation {
+		t.Errorf("got error code %q for a rejected message, expected %q", msg.Code, commons.ErrorInvalidOperation)
+	}
+}
+
+// TestAck checks that numbered operations are acknowledged to their sender, and that the
+// numbers aren't relayed.
+func TestAck(t *testing.T) {
+	ts := httptest.NewServer(New(Config{MaxDocumentSize: 1}).Handler())
+	defer ts.Close()
+
+	alice := dial(t, ts.URL)
+	_ = alice.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+	readUntil(t, alice, commons.JoinAckMessage)
+	bob := dial(t, ts.URL)
+	_ = bob.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "bob"})
+	readUntil(t, bob, commons.JoinAckMessage)
+
+	op := commons.Operation{Type: "insert", Position: 1, Value: "a"}
+	_ = alice.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: op, Seq: 1})
+	if ack := readUntil(t, alice, commons.AckMessage); ack.Seq != 1 {
+		t.Errorf("got ack for seq %d, expected 1", ack.Seq)
+	}
+	if got := readUntil(t, bob, commons.OperationMessage); got.Seq != 0 {
+		t.Errorf("got relayed operation with seq %d, expected 0", got.Seq)
+	}
+
+	// Rejected operations are reported with their seq.
+	_ = alice.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: op, Seq: 2})
+	if got := readUntil(t, alice, commons.ErrorMessage); got.Seq != 2 {
+		t.Errorf("got error for seq %d, expected 2", got.Seq)
+	}
+}
+
+// TestOperationCharacters checks that the characters of operations are relayed, and that
+// inserts of characters with another site's ID are rejected.
+func TestOperationCharacters(t *testing.T) {
+	ts := httptest.NewServer(New(Config{}).Handler())
+	defer ts.Close()
+
+	alice := dial(t, ts.URL)
+	site, err := strconv.Atoi(readUntil(t, alice, commons.SiteIDMessage).Text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = alice.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+	readUntil(t, alice, commons.JoinAckMessage)
+	bob := dial(t, ts.URL)
+	_ = bob.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "bob"})
+	readUntil(t, bob, commons.JoinAckMessage)
+
+	insert := func(siteID int) commons.Operation {
+		char := crdt.Character{ID: crdt.CharacterID{SiteID: siteID, Clock: 1}, Visible: true, Value: "a", IDPrevious: crdt.IDStart, IDNext: crdt.IDEnd}
+		return commons.Operation{Type: "insert", Position: 1, Value: "a", Insert: &crdt.InsertOp{Characters: []crdt.Character{char}}}
+	}
+
+	_ = alice.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: insert(site + 1)})
+	if msg := readUntil(t, alice, commons.ErrorMessage); msg.Code != commons.ErrorInvalidOperation {
+		t.Errorf("got error code %q, expected %q", msg.Code, commons.ErrorInvalidOperation)
+	}
+
+	op := insert(site)
+	_ = alice.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: op})
+	if got := readUntil(t, bob, commons.OperationMessage).Operation; !reflect.DeepEqual(got, op) {
+		t.Errorf("got relayed operation %+v, expected %+v", got, op)
+	}
+}
+
+// TestSlowClient checks that a client which stops reading its messages doesn't delay the
+// messages to the others, and is disconnected once it has fallen too far behind.
+func TestSlowClient(t *testing.T) {
+	ts := httptest.NewServer(New(Config{WriteTimeout: time.Hour, OutboxSize: 16}).Handler())
+	defer ts.Close()
+
+	// Alice's connection has a small receive buffer, so that it's soon full.
+	dialer := websocket.Dialer{NetDial: func(network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			err = conn.(*net.TCPConn).SetReadBuffer(4096)
+		}
+		return conn, err
+	}}
+	alice, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.Close()
+
+	conns := []*websocket.Conn{alice, dial(t, ts.URL), dial(t, ts.URL)}
+	for i, name := range []string{"alice", "bob", "carol"} {
+		_ = conns[i].WriteJSON(commons.Message{Type: commons.JoinMessage, Username: name})
+		readUntil(t, conns[i], commons.JoinAckMessage)
+	}
+	bob, carol := conns[1], conns[2]
+
+	// Alice doesn't read the operations, which soon fill her connection's buffers, and
+	// then her outbox. Carol still gets each of them in time.
+	value := strings.Repeat("a", 64<<10)
+	var leave commons.Message
+	for i := 0; i < 200; i++ {
+		op := commons.Operation{Type: "insert", Position: 1, Value: value}
+		_ = bob.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: op})
+		for {
+			var msg commons.Message
+			_ = carol.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if err := carol.ReadJSON(&msg); err != nil {
+				t.Fatalf("waiting for operation %d: %v", i+1, err)
+			}
+			if msg.Type == commons.LeaveMessage {
+				leave = msg
+			}
+			if msg.Type == commons.OperationMessage {
+				break
+			}
+		}
+	}
+	if leave.Type == "" {
+		leave = readUntil(t, carol, commons.LeaveMessage)
+	}
+	if leave.Username != "alice" || leave.Text != commons.LeaveReasonConnectionLost {
+		t.Errorf("got %s leaving with reason %q, expected alice with reason %q", leave.Username, leave.Text, commons.LeaveReasonConnectionLost)
+	}
+}
+
+// TestLeaveReasons checks that the other clients are told why a client left: because it
+// closed its connection, because the connection broke, or because it was kicked.
+func TestLeaveReasons(t *testing.T) {
+	s := New(Config{})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	if err := s.Kick("default", "1"); !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("got %v kicking from an unknown room, expected %v", err, ErrClientNotFound)
+	}
+
+	bob := dial(t, ts.URL)
+	_ = bob.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "bob"})
+	readUntil(t, bob, commons.JoinAckMessage)
+
+	tests := []struct {
+		description string
+		username    string
+		leave       func(conn *websocket.Conn, siteID string) error
+		reason      string
+	}{
+		{description: "close", username: "alice", leave: func(conn *websocket.Conn, _ string) error {
+			return conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		}, reason: commons.LeaveReasonLeft},
+		{description: "broken connection", username: "carol", leave: func(conn *websocket.Conn, _ string) error {
+			return conn.UnderlyingConn().Close()
+		}, reason: commons.LeaveReasonConnectionLost},
+		{description: "kick", username: "dave", leave: func(_ *websocket.Conn, siteID string) error {
+			return s.Kick("default", siteID)
+		}, reason: commons.LeaveReasonKicked},
+	}
+	for _, tc := range tests {
+		conn := dial(t, ts.URL)
+		siteID := readUntil(t, conn, commons.SiteIDMessage).Text
+		_ = conn.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: tc.username})
+		readUntil(t, conn, commons.JoinAckMessage)
+
+		if err := tc.leave(conn, siteID); err != nil {
+			t.Fatalf("(%s) %v", tc.description, err)
+		}
+		leave := readUntil(t, bob, commons.LeaveMessage)
+		if leave.Username != tc.username || leave.Text != tc.reason {
+			t.Errorf("(%s) got %s leaving with reason %q, expected %s with reason %q", tc.description, leave.Username, leave.Text, tc.username, tc.reason)
+		}
+		conn.Close()
+	}
+
+	if err := s.Kick("default", "999"); !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("got %v kicking an unknown client, expected %v", err, ErrClientNotFound)
+	}
+}
+
+// TestInvalidUsername checks that a client joining with an invalid name is told why, and
+// disconnected, without any of its other messages reaching the room.
+func TestInvalidUsername(t *testing.T) {
+	ts := httptest.NewServer(New(Config{}).Handler())
+	defer ts.Close()
+
+	bob := dial(t, ts.URL)
+	_ = bob.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "bob"})
+	readUntil(t, bob, commons.JoinAckMessage)
+
+	for _, name := range []string{"", " alice", "alice<script>"} {
+		conn := dial(t, ts.URL)
+		readUntil(t, conn, commons.SiteIDMessage)
+		_ = conn.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: name})
+		op := commons.Operation{Type: "insert", Position: 1, Value: "a"}
+		_ = conn.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: op})
+
+		if msg := readUntil(t, conn, commons.ErrorMessage); msg.Code != commons.ErrorInvalidUsername {
+			t.Errorf("(%q) got error code %q, expected %q", name, msg.Code, commons.ErrorInvalidUsername)
+		}
+		var closeErr *websocket.CloseError
+		for {
+			var msg commons.Message
+			err := conn.ReadJSON(&msg)
+			if err == nil {
+				continue
+			}
+			if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+				t.Errorf("(%q) got %v, expected the connection closed with status %d", name, err, websocket.ClosePolicyViolation)
+			}
+			break
+		}
+		conn.Close()
+	}
+
+	// Bob only sees the operations of clients which joined.
+	alice := dial(t, ts.URL)
+	_ = alice.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+	readUntil(t, alice, commons.JoinAckMessage)
+	op := commons.Operation{Type: "insert", Position: 1, Value: "b"}
+	_ = alice.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: op})
+	if got := readUntil(t, bob, commons.OperationMessage); got.Operation.Value != "b" {
+		t.Errorf("got operation %+v relayed, expected alice's", got.Operation)
+	}
+}
+
+// TestSilentClient checks that a client which sends nothing for the read timeout is
+// disconnected, while one sending messages stays.
+func TestSilentClient(t *testing.T) {
+	ts := httptest.NewServer(New(Config{ReadTimeout: 200 * time.Millisecond}).Handler())
+	defer ts.Close()
+
+	alice, bob := dial(t, ts.URL), dial(t, ts.URL)
+	for _, c := range []struct {
+		conn *websocket.Conn
+		name string
+	}{{alice, "alice"}, {bob, "bob"}} {
+		_ = c.conn.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: c.name})
+		readUntil(t, c.conn, commons.JoinAckMessage)
+	}
+
+	// Bob keeps sending his selection, while alice goes silent.
+	start := time.Now()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_ = bob.WriteJSON(commons.Message{Type: commons.SelectionMessage, Username: "bob", Selection: &commons.Selection{Start: 1, End: 1}})
+			}
+		}
+	}()
+
+	leave := readUntil(t, bob, commons.LeaveMessage)
+	if leave.Username != "alice" || leave.Text != commons.LeaveReasonConnectionLost {
+		t.Errorf("got %s leaving with reason %q, expected alice with reason %q", leave.Username, leave.Text, commons.LeaveReasonConnectionLost)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("got alice disconnected after %s, expected it after the read timeout", elapsed)
+	}
+}
+
+// TestTrace checks that the messages of the traced rooms get spans, from their receipt
+// to their broadcast, exported to the collector, and that the other rooms' don't.
+func TestTrace(t *testing.T) {
+	type span struct {
+		SpanID, ParentSpanID, Name string
+		Attributes                 []struct {
+			Key   string
+			Value struct{ StringValue string }
+		}
+	}
+	var (
+		mu    sync.Mutex
+		spans []span
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct{ ScopeSpans []struct{ Spans []span } }
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+
+	tracer, err := trace.New(collector.URL, "pairpad-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(New(Config{Tracer: tracer, TraceRooms: "team-*"}).Handler())
+	defer ts.Close()
+
+	for _, room := range []string{"team-a", "other"} {
+		conn := dial(t, ts.URL+"?room="+room)
+		_ = conn.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+		readUntil(t, conn, commons.JoinAckMessage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var root *span
+	children := map[string]string{}
+	for i, s := range spans {
+		if s.Name == "pairpad.message" {
+			for _, a := range s.Attributes {
+				if a.Key == "pairpad.room" && a.Value.StringValue != "team-a" {
+					t.Errorf("got a span of room %q, expected only team-a to be traced", a.Value.StringValue)
+				}
+			}
+			root = &spans[i]
+		} else {
+			children[s.Name] = s.ParentSpanID
+		}
+	}
+	if root == nil {
+		t.Fatalf("got spans %+v, expected the join message's", spans)
+	}
+	for _, name := range []string{"queue", "broadcast"} {
+		if parent, ok := children[name]; !ok || parent != root.SpanID {
+			t.Errorf("got spans %+v, expected a %s span in the message's", spans, name)
+		}
+	}
+}
+
+// FuzzAccept feeds a stream of arbitrary messages to a room, the way handleConn and
+// handleMsg do, and checks that it doesn't panic, and that the room's document stays
+// well-formed.
+func FuzzAccept(f *testing.F) {
+	doc, _ := crdt.FromText("hello")
+	var seed []byte
+	for _, msg := range []commons.Message{
+		commons.NewDocSyncMessage(doc, uuid.Nil),
+		{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: 6, Value: "!"}},
+		{Type: commons.PromptMessage, Annotation: &commons.Annotation{ID: "p", Start: 1, End: 5}},
+		{Type: commons.OperationMessage, Operation: commons.Operation{Type: "delete", Position: 2}},
+		{Type: commons.AccessMessage, Text: commons.AccessReadOnly},
+	} {
+		data, _ := json.Marshal(msg)
+		seed = append(seed, data...)
+	}
+	f.Add(seed, true)
+	f.Add(seed, false)
+
+	f.Fuzz(func(t *testing.T, data []byte, interviewer bool) {
+		// The room's document is only kept if it's persisted.
+		r := newRoom("fuzz", Config{MaxDocumentSize: 1000, Store: store.Dir{Path: t.TempDir()}}, nil, nil, "", nil)
+		defer r.close()
+		c := &client{id: uuid.New(), interviewer: interviewer}
+
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var msg commons.Message
+			if err := dec.Decode(&msg); err != nil {
+				break
+			}
+			if err := r.accept(c, msg); err != nil {
+				continue
+			}
+
+			switch msg.Type {
+			case commons.OperationMessage:
+				r.addOperation(msg.Operation)
+			case commons.DocSyncMessage:
+				r.setDocument(msg.Document)
+			case commons.AccessMessage:
+				r.setAccess(msg)
+			}
+		}
+
+		r.docMu.Lock()
+		defer r.docMu.Unlock()
+		if !r.loadDocument() {
+			t.Fatal("failed to load the document")
+		}
+		if err := commons.NewDocSyncMessage(r.doc, uuid.Nil).Validate(); err != nil {
+			t.Errorf("invalid document after %s: %v", data, err)
+		}
+	})
+}
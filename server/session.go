@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/fatih/color"
+	"github.com/gorilla/websocket"
+)
+
+// sessionWarnings lists how long before the end of a session its clients are warned.
+var sessionWarnings = []time.Duration{10 * time.Minute, time.Minute}
+
+// endSession warns the clients of a room with a maximum session duration as the end of the
+// session approaches, and closes the room at its deadline. It returns early if the room is
+// closed for being idle, or the server shuts down.
+func (s *Server) endSession(r *room) {
+	for _, before := range sessionWarnings {
+		at := r.deadline.Add(-before)
+		if time.Now().After(at) {
+			// The session is shorter than the warning period.
+			continue
+		}
+		if !s.waitSession(r, time.Until(at)) {
+			return
+		}
+		notice := commons.Message{Type: commons.NoticeMessage, Text: "The session ends in " + formatRemaining(before)}
+		r.clients.broadcastAll(notice)
+	}
+	if !s.waitSession(r, time.Until(r.deadline)) {
+		return
+	}
+
+	r.mu.Lock()
+	r.ended = true
+	r.mu.Unlock()
+	color.Yellow("[%s] Session reached its maximum duration of %s, disconnecting its clients", r.name, s.conf.MaxSessionDuration)
+
+	// Clients which were joining when the session ended are disconnected once they've
+	// been added to the room.
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		r.disconnect(websocket.CloseNormalClosure, "session ended")
+		if _, numClients := r.idle(time.Now(), 0); numClients == 0 {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+
+	// Clients can't join the room anymore, so it's closed before it's removed, and a new
+	// session only starts once the document has been saved.
+	r.close()
+	s.mu.Lock()
+	if s.rooms[r.name] == r {
+		delete(s.rooms, r.name)
+	}
+	s.mu.Unlock()
+	color.Yellow("[%s] Closed ended session", r.name)
+}
+
+// waitSession waits for d, and reports whether the room's session is still running.
+func (s *Server) waitSession(r *room, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-r.stopped:
+		return false
+	case <-s.done:
+		return false
+	}
+}
+
+// formatRemaining formats the time remaining in a session, in minutes.
+func formatRemaining(d time.Duration) string {
+	switch minutes := int(d.Round(time.Minute) / time.Minute); {
+	case minutes < 1:
+		return "less than a minute"
+	case minutes == 1:
+		return "1 minute"
+	default:
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// The query parameters with which a reconnecting client presents the site ID it had, and
+// its token.
+const (
+	siteParam      = "site"
+	siteTokenParam = "token"
+)
+
+// newSiteKey returns a random key to sign site IDs and names with.
+func newSiteKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("pairpad: failed to generate the site ID key: " + err.Error())
+	}
+	return key
+}
+
+// siteToken returns the token given to the client with the site ID, which proves that the
+// server gave it the site ID.
+func (s *Server) siteToken(siteID int) string {
+	mac := hmac.New(sha256.New, s.siteKey)
+	mac.Write([]byte(strconv.Itoa(siteID)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nameToken returns the token given to the client which joined the room with the name,
+// which proves that the server gave it the name, signed with key. Names are compared
+// case-insensitively, as by uniqueName.
+func nameToken(key []byte, room, name string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("name\x00" + room + "\x00" + strings.ToLower(name)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validNameToken reports whether token is the token of the name in the room.
+func validNameToken(key []byte, room, name, token string) bool {
+	return token != "" && hmac.Equal([]byte(token), []byte(nameToken(key, room, name)))
+}
+
+// claimSiteID returns the site ID of a connecting client. A client reconnecting with the
+// site ID it had, and its token, keeps it, unless another client is using it; otherwise,
+// the client gets a new site ID. The site ID is in use until it's released with
+// releaseSiteID.
+func (s *Server) claimSiteID(site, token string) (int, error) {
+	if id, err := strconv.Atoi(site); err == nil && hmac.Equal([]byte(token), []byte(s.siteToken(id))) {
+		s.mu.Lock()
+		inUse := s.sites[id]
+		s.sites[id] = true
+		s.mu.Unlock()
+		if !inUse {
+			return id, nil
+		}
+	}
+
+	id, err := s.nextSiteID()
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	s.sites[id] = true
+	s.mu.Unlock()
+	return id, nil
+}
+
+// releaseSiteID releases the site ID of a client which has disconnected.
+func (s *Server) releaseSiteID(id int) {
+	s.mu.Lock()
+	delete(s.sites, id)
+	s.mu.Unlock()
+}
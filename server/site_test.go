@@ -0,0 +1,133 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+)
+
+// TestSiteID checks that reconnecting clients keep their site ID if they present its
+// token, and nobody else is using it.
+func TestSiteID(t *testing.T) {
+	s := New(Config{})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	alice := dial(t, ts.URL)
+	first := readUntil(t, alice, commons.SiteIDMessage)
+	if first.Token == "" {
+		t.Fatal("got no site ID token")
+	}
+	reconnect := "?" + url.Values{siteParam: {first.Text}, siteTokenParam: {first.Token}}.Encode()
+
+	// The site ID is in use, so another client presenting it gets a new one.
+	if got := readUntil(t, dial(t, ts.URL+reconnect), commons.SiteIDMessage); got.Text == first.Text {
+		t.Errorf("got site ID %s, which is in use", got.Text)
+	}
+
+	alice.Close()
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		s.mu.Lock()
+		id := s.siteID
+		inUse := len(s.sites)
+		s.mu.Unlock()
+		if id == 2 && inUse == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("alice's site ID wasn't released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		reused bool
+	}{
+		{"reconnect", reconnect, true},
+		{"wrong token", "?" + url.Values{siteParam: {first.Text}, siteTokenParam: {"0000"}}.Encode(), false},
+		{"no token", "?" + url.Values{siteParam: {first.Text}}.Encode(), false},
+	}
+	for _, tc := range tests {
+		conn := dial(t, ts.URL+tc.query)
+		got := readUntil(t, conn, commons.SiteIDMessage)
+		if reused := got.Text == first.Text; reused != tc.reused {
+			t.Errorf("%s: got site ID %s, reused %v, expected reused %v", tc.name, got.Text, reused, tc.reused)
+		}
+		id, _ := strconv.Atoi(got.Text)
+		if expected := s.siteToken(id); got.Token != expected {
+			t.Errorf("%s: got token %q, expected %q", tc.name, got.Token, expected)
+		}
+		conn.Close()
+	}
+}
+
+// TestNameToken checks that the name of a client which left is kept for it: others joining
+// with the name get another one, unless they present its token.
+func TestNameToken(t *testing.T) {
+	ts := httptest.NewServer(New(Config{}).Handler())
+	defer ts.Close()
+
+	join := func(name, token string) commons.Message {
+		t.Helper()
+		conn := dial(t, ts.URL)
+		_ = conn.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: name, Token: token})
+		ack := readUntil(t, conn, commons.JoinAckMessage)
+		conn.Close()
+		return ack
+	}
+
+	first := join("alice", "")
+	if first.Token == "" {
+		t.Fatal("got no name token")
+	}
+
+	tests := []struct {
+		description string
+		name, token string
+		expected    string
+	}{
+		{description: "no token", name: "alice", expected: "alice-2"},
+		// alice-2's name is kept for them too.
+		{description: "wrong token", name: "alice", token: "0000", expected: "alice-3"},
+		{description: "token of the name, in another case", name: "Alice", token: first.Token, expected: "Alice"},
+	}
+	for _, tc := range tests {
+		// Each client leaves before the next one joins, once its name is kept.
+		time.Sleep(100 * time.Millisecond)
+		if got := join(tc.name, tc.token); got.Username != tc.expected {
+			t.Errorf("%s: got name %q, expected %q", tc.description, got.Username, tc.expected)
+		}
+	}
+}
+
+// TestAttribute checks that the server sets the sender's name in the messages it relays.
+func TestAttribute(t *testing.T) {
+	ts := httptest.NewServer(New(Config{}).Handler())
+	defer ts.Close()
+
+	alice := dial(t, ts.URL)
+	_ = alice.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+	readUntil(t, alice, commons.JoinAckMessage)
+	mallory := dial(t, ts.URL)
+	_ = mallory.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "mallory"})
+	readUntil(t, mallory, commons.JoinAckMessage)
+
+	_ = mallory.WriteJSON(commons.Message{Type: commons.PingMessage, Username: "alice"})
+	if got := readUntil(t, alice, commons.PingMessage); got.Username != "mallory" {
+		t.Errorf("got ping from %q, expected mallory", got.Username)
+	}
+	_ = mallory.WriteJSON(commons.Message{Type: commons.AnnotationMessage, Annotation: &commons.Annotation{ID: "1", Author: "alice", Text: "hi", Start: 1, End: 1}})
+	if got := readUntil(t, alice, commons.AnnotationMessage); got.Annotation.Author != "mallory" {
+		t.Errorf("got comment by %q, expected mallory", got.Annotation.Author)
+	}
+	_ = mallory.WriteJSON(commons.Message{Type: commons.OperationMessage, Username: "alice", Operation: commons.Operation{Type: "insert", Position: 1, Value: "x"}})
+	if got := readUntil(t, alice, commons.OperationMessage); got.Username != "mallory" {
+		t.Errorf("got operation from %q, expected mallory", got.Username)
+	}
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// Dir stores each room's document as a .pairpad file in a directory on local disk, and its
+// log as a .log file next to it, holding one JSON operation per line.
+type Dir struct {
+	// Path is the directory holding the documents. It's created when the first document is saved.
+	Path string
+}
+
+// fileName returns the name of the file holding a room's document.
+func (d Dir) fileName(room string) string {
+	return filepath.Join(d.Path, room+".pairpad")
+}
+
+// logName returns the name of the file holding the log of a room's document.
+func (d Dir) logName(room string) string {
+	return filepath.Join(d.Path, room+".log")
+}
+
+// a logHeader is the first line of a log file.
+type logHeader struct {
+	// Base is the state checksum of the document the log applies to, or empty if there
+	// was no saved document when the log was started. A log whose base isn't the saved
+	// document is left over from before the document was saved, and its operations are
+	// already in the document.
+	Base string `json:"base"`
+}
+
+// Load reads the document of a room.
+func (d Dir) Load(ctx context.Context, room string) (crdt.Document, error) {
+	data, err := os.ReadFile(d.fileName(room))
+	if errors.Is(err, fs.ErrNotExist) {
+		return crdt.Document{}, ErrNotFound
+	}
+	if err != nil {
+		return crdt.Document{}, err
+	}
+	return decode(data)
+}
+
+// Save writes the document of a room. The file is replaced atomically, so a crash while
+// saving leaves the previous version in place.
+func (d Dir) Save(ctx context.Context, room string, doc crdt.Document) error {
+	data, err := crdt.EncodeFile(&doc)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(d.Path, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(d.Path, room+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), d.fileName(room)); err != nil {
+		return err
+	}
+
+	err = os.Remove(d.logName(room))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// LoadLog reads the document of a room and its log.
+func (d Dir) LoadLog(ctx context.Context, room string) (crdt.Document, []commons.Operation, error) {
+	doc, err := d.Load(ctx, room)
+	found := err == nil
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return crdt.Document{}, nil, err
+	}
+
+	data, err := os.ReadFile(d.logName(room))
+	if errors.Is(err, fs.ErrNotExist) {
+		if !found {
+			return crdt.Document{}, nil, ErrNotFound
+		}
+		return doc, nil, nil
+	}
+	if err != nil {
+		return crdt.Document{}, nil, err
+	}
+
+	// The last line is incomplete if the server stopped while appending it.
+	lines := bytes.Split(data, []byte("\n"))
+	lines = lines[:len(lines)-1]
+	if len(lines) == 0 {
+		return doc, nil, nil
+	}
+
+	var h logHeader
+	if err := json.Unmarshal(lines[0], &h); err != nil {
+		return crdt.Document{}, nil, err
+	}
+	if h.Base != base(doc, found) {
+		if !found {
+			return crdt.Document{}, nil, ErrNotFound
+		}
+		return doc, nil, nil
+	}
+
+	ops := make([]commons.Operation, 0, len(lines)-1)
+	for _, line := range lines[1:] {
+		var op commons.Operation
+		if err := json.Unmarshal(line, &op); err != nil {
+			return crdt.Document{}, nil, err
+		}
+		ops = append(ops, op)
+	}
+	return doc, ops, nil
+}
+
+// Append appends operations to the log of a room's document.
+func (d Dir) Append(ctx context.Context, room string, ops []commons.Operation) error {
+	if err := os.MkdirAll(d.Path, 0755); err != nil {
+		return err
+	}
+
+	var data []byte
+	if _, err := os.Stat(d.logName(room)); errors.Is(err, fs.ErrNotExist) {
+		doc, err := d.Load(ctx, room)
+		found := err == nil
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		data, err = json.Marshal(logHeader{Base: base(doc, found)})
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	}
+	for _, op := range ops {
+		line, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	f, err := os.OpenFile(d.logName(room), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// base returns the base of the logs applying to doc, in log headers.
+func base(doc crdt.Document, found bool) string {
+	if !found {
+		return ""
+	}
+	return crdt.StateChecksum(doc)
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// SQLite stores documents in the "documents" table of an SQLite database, and their logs in
+// the "operations" table.
+//
+// The database is opened by the caller, so the package doesn't depend on a particular
+// driver; pairpad-server uses modernc.org/sqlite.
+type SQLite struct {
+	db *sql.DB
+}
+
+// NewSQLite returns a store using db, creating the documents and operations tables if they
+// don't exist.
+func NewSQLite(ctx context.Context, db *sql.DB) (*SQLite, error) {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS documents (
+		room     TEXT PRIMARY KEY,
+		data     BLOB NOT NULL,
+		saved_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS operations (
+		seq  INTEGER PRIMARY KEY AUTOINCREMENT,
+		room TEXT NOT NULL,
+		data BLOB NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLite{db: db}, nil
+}
+
+// Load reads the document of a room.
+func (s *SQLite) Load(ctx context.Context, room string) (crdt.Document, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM documents WHERE room = ?`, room).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return crdt.Document{}, ErrNotFound
+	}
+	if err != nil {
+		return crdt.Document{}, err
+	}
+	return decode(data)
+}
+
+// Save writes the document of a room, and clears its log in the same transaction.
+func (s *SQLite) Save(ctx context.Context, room string, doc crdt.Document) error {
+	data, err := crdt.EncodeFile(&doc)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO documents (room, data, saved_at) VALUES (?, ?, ?)
+		ON CONFLICT (room) DO UPDATE SET data = excluded.data, saved_at = excluded.saved_at`,
+		room, data, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM operations WHERE room = ?`, room); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LoadLog reads the document of a room and its log.
+func (s *SQLite) LoadLog(ctx context.Context, room string) (crdt.Document, []commons.Operation, error) {
+	doc, err := s.Load(ctx, room)
+	found := err == nil
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return crdt.Document{}, nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM operations WHERE room = ? ORDER BY seq`, room)
+	if err != nil {
+		return crdt.Document{}, nil, err
+	}
+	defer rows.Close()
+
+	var ops []commons.Operation
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return crdt.Document{}, nil, err
+		}
+		var op commons.Operation
+		if err := json.Unmarshal(data, &op); err != nil {
+			return crdt.Document{}, nil, err
+		}
+		ops = append(ops, op)
+	}
+	if err := rows.Err(); err != nil {
+		return crdt.Document{}, nil, err
+	}
+
+	if !found && len(ops) == 0 {
+		return crdt.Document{}, nil, ErrNotFound
+	}
+	return doc, ops, nil
+}
+
+// Append adds operations to the log of a room's document.
+func (s *SQLite) Append(ctx context.Context, room string, ops []commons.Operation) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, op := range ops {
+		data, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO operations (room, data) VALUES (?, ?)`, room, data); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
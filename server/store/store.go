@@ -0,0 +1,55 @@
+// Package store provides the storage backends in which pairpad-server persists the
+// documents of rooms, so they survive server restarts.
+//
+// Documents are stored in the versioned format of crdt.EncodeFile, keyed by room name.
+// Three backends are available: Dir (a directory on local disk), S3 (an S3-compatible
+// object storage bucket) and SQLite (a table in an SQLite database).
+//
+// Dir and SQLite are also a Log: they keep the operations applied to each document since
+// it was saved, so documents only need to be saved whole once in a while, as snapshots.
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/crdt"
+)
+
+// ErrNotFound is returned by Load when a room has no saved document.
+var ErrNotFound = errors.New("document not found")
+
+// A Store persists the documents of rooms. Implementations must be safe for concurrent use.
+type Store interface {
+	// Load returns the saved document of a room, or ErrNotFound if there's none.
+	Load(ctx context.Context, room string) (crdt.Document, error)
+
+	// Save saves the document of a room, replacing the previous one.
+	Save(ctx context.Context, room string, doc crdt.Document) error
+}
+
+// A Log is a Store which also keeps a log of the operations applied to the document of
+// a room since it was last saved. Appending operations is cheaper than saving the whole
+// document, so they can be persisted as they come, and the document itself is saved less
+// often. Save clears the room's log.
+type Log interface {
+	Store
+
+	// LoadLog returns the saved document of a room and the operations logged since it
+	// was saved, or ErrNotFound if there's neither. If the room has no saved document,
+	// the operations apply to an empty document.
+	LoadLog(ctx context.Context, room string) (crdt.Document, []commons.Operation, error)
+
+	// Append adds operations to the log of a room's document.
+	Append(ctx context.Context, room string, ops []commons.Operation) error
+}
+
+// decode decodes a document saved by a Store.
+func decode(data []byte) (crdt.Document, error) {
+	f, err := crdt.DecodeFile(data)
+	if err != nil {
+		return crdt.Document{}, err
+	}
+	return f.Document, nil
+}
@@ -0,0 +1,348 @@
+// Package trace records spans of the work a pairpad server does on messages, and exports
+// them to an OpenTelemetry collector with OTLP, over HTTP with the JSON encoding.
+//
+// Only what the server needs is implemented: spans with string and integer attributes,
+// children in the same process, and a batching exporter. A nil *Tracer, and the nil spans
+// it starts, do nothing, so tracing can be left unset without checks at every span.
+package trace
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// batchSize is the number of spans sent to the collector at once, at most.
+	batchSize = 512
+
+	// flushInterval is how often the spans ended since the last batch are sent.
+	flushInterval = 5 * time.Second
+
+	// queueSize is the number of ended spans waiting to be sent. Spans ended while it's
+	// full are dropped, rather than slowing down the server.
+	queueSize = 4096
+
+	// exportTimeout bounds the time spent sending a batch to the collector.
+	exportTimeout = 10 * time.Second
+)
+
+// Span kinds, as numbered by OTLP.
+const (
+	kindInternal = 1
+	kindServer   = 2
+)
+
+// An Attr is an attribute of a span, with a string or integer value.
+type Attr struct {
+	Key   string
+	value attrValue
+}
+
+// String returns a string attribute.
+func String(key, value string) Attr {
+	return Attr{Key: key, value: attrValue{StringValue: &value}}
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int) Attr {
+	v := strconv.Itoa(value)
+	return Attr{Key: key, value: attrValue{IntValue: &v}}
+}
+
+// A Tracer starts spans, and exports them once they end. It's safe for concurrent use.
+type Tracer struct {
+	endpoint string
+	service  string
+	client   *http.Client
+
+	queue   chan *Span
+	flush   chan chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+
+	// dropped counts the spans dropped because the queue was full, or their export failed.
+	dropped int64
+
+	closeOnce sync.Once
+}
+
+// New returns a tracer exporting its spans to the OTLP/HTTP collector at endpoint, such
+// as "http://localhost:4318", as those of the named service. Spans are sent to the
+// endpoint's path, or to /v1/traces if it has none.
+func New(endpoint, service string) (*Tracer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("OTLP endpoint %q must be an http:// or https:// URL", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+
+	t := &Tracer{
+		endpoint: u.String(),
+		service:  service,
+		client:   &http.Client{Timeout: exportTimeout},
+		queue:    make(chan *Span, queueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go t.run()
+	return t, nil
+}
+
+// Dropped returns the number of spans which were dropped, rather than exported.
+func (t *Tracer) Dropped() int64 {
+	if t == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&t.dropped)
+}
+
+// Flush sends the spans ended so far, and waits until they're sent, or ctx is done.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	sent := make(chan struct{})
+	select {
+	case t.flush <- sent:
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-sent:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close sends the spans ended so far, and stops the tracer. Spans ended afterwards are
+// dropped.
+func (t *Tracer) Close(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	err := t.Flush(ctx)
+	t.closeOnce.Do(func() { close(t.done) })
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// run sends the ended spans in batches, every flushInterval or once batchSize of them
+// have ended, until the tracer is closed.
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	send := func() {
+		if len(batch) > 0 {
+			t.export(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) == batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case sent := <-t.flush:
+			// Take the spans queued before the flush.
+			for n := len(t.queue); n > 0; n-- {
+				batch = append(batch, <-t.queue)
+			}
+			for len(batch) > batchSize {
+				rest := batch[batchSize:]
+				batch = batch[:batchSize]
+				send()
+				batch = rest
+			}
+			send()
+			close(sent)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Start starts a root span, of work done on behalf of a client, such as handling one of
+// its messages.
+func (t *Tracer) Start(name string, attrs ...Attr) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t, name: name, kind: kindServer, start: time.Now(), attrs: attrs}
+	_, _ = rand.Read(s.traceID[:])
+	_, _ = rand.Read(s.id[:])
+	return s
+}
+
+// A Span is a part of the work done on a message, which takes some time.
+type Span struct {
+	tracer  *Tracer
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte
+	name    string
+	kind    int
+
+	mu    sync.Mutex
+	start time.Time
+	end   time.Time
+	attrs []Attr
+	ended bool
+}
+
+// Child starts a span of a part of the span's work.
+func (s *Span) Child(name string, attrs ...Attr) *Span {
+	if s == nil {
+		return nil
+	}
+	c := &Span{tracer: s.tracer, traceID: s.traceID, parent: s.id, name: name, kind: kindInternal, start: time.Now(), attrs: attrs}
+	_, _ = rand.Read(c.id[:])
+	return c
+}
+
+// SetAttr adds attributes to the span.
+func (s *Span) SetAttr(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// End ends the span, which is then exported. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	s.mu.Unlock()
+
+	select {
+	case <-s.tracer.done:
+		atomic.AddInt64(&s.tracer.dropped, 1)
+	case s.tracer.queue <- s:
+	default:
+		atomic.AddInt64(&s.tracer.dropped, 1)
+	}
+}
+
+// export sends a batch of spans to the collector. Failed batches are dropped.
+func (t *Tracer) export(batch []*Span) {
+	data, err := json.Marshal(t.request(batch))
+	if err == nil {
+		var resp *http.Response
+		resp, err = t.client.Post(t.endpoint, "application/json", bytes.NewReader(data))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = fmt.Errorf("collector answered %s", resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		atomic.AddInt64(&t.dropped, int64(len(batch)))
+	}
+}
+
+// The OTLP/JSON request sending spans, with only the fields set by the tracer.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []spanData `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	spanData struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+	}
+	keyValue struct {
+		Key   string    `json:"key"`
+		Value attrValue `json:"value"`
+	}
+	attrValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+)
+
+// request returns the export request sending the spans.
+func (t *Tracer) request(batch []*Span) exportRequest {
+	spans := make([]spanData, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		d := spanData{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.id[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			d.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, a := range s.attrs {
+			d.Attributes = append(d.Attributes, keyValue{Key: a.Key, Value: a.value})
+		}
+		s.mu.Unlock()
+		spans = append(spans, d)
+	}
+
+	service := t.service
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: []keyValue{{Key: "service.name", Value: attrValue{StringValue: &service}}}},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "github.com/burntcarrot/pairpad/server"}, Spans: spans}},
+	}}}
+}
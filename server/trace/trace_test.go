@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is an OTLP/HTTP collector receiving the spans exported in tests.
+type collector struct {
+	mu    sync.Mutex
+	paths []string
+	spans []spanData
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req exportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths = append(c.paths, r.URL.Path)
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+// TestExport checks that ended spans are sent to the collector, with their parents and
+// attributes.
+func TestExport(t *testing.T) {
+	c := &collector{}
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+
+	tracer, err := New(ts.URL, "pairpad-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tracer.Start("message", String("pairpad.room", "team-a"))
+	child := root.Child("broadcast")
+	child.SetAttr(Int("pairpad.recipients", 3))
+	child.End()
+	root.End()
+	root.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.paths) != 1 || c.paths[0] != "/v1/traces" {
+		t.Errorf("got requests to %v, expected one to /v1/traces", c.paths)
+	}
+	if len(c.spans) != 2 {
+		t.Fatalf("got %d spans, expected 2", len(c.spans))
+	}
+	gotChild, gotRoot := c.spans[0], c.spans[1]
+	if gotRoot.Name != "message" || gotRoot.ParentSpanID != "" || gotRoot.Kind != kindServer {
+		t.Errorf("got root span %+v, expected the message span, without a parent", gotRoot)
+	}
+	if gotChild.Name != "broadcast" || gotChild.ParentSpanID != gotRoot.SpanID || gotChild.TraceID != gotRoot.TraceID {
+		t.Errorf("got child span %+v, expected the broadcast span, in the root's trace", gotChild)
+	}
+	if len(gotChild.Attributes) != 1 || *gotChild.Attributes[0].Value.IntValue != "3" {
+		t.Errorf("got attributes %+v, expected the number of recipients", gotChild.Attributes)
+	}
+	if tracer.Dropped() != 0 {
+		t.Errorf("got %d spans dropped, expected none", tracer.Dropped())
+	}
+}
+
+// TestNilTracer checks that a nil tracer's spans do nothing.
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.Start("message")
+	span.Child("broadcast").End()
+	span.SetAttr(String("k", "v"))
+	span.End()
+	if err := tracer.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxUsernameLength is the maximum number of characters in a username.
+const maxUsernameLength = 32
+
+// usernamePunct holds the punctuation allowed in usernames.
+const usernamePunct = "-_.'"
+
+var (
+	errUsernameEmpty   = errors.New("invalid username: the name is empty")
+	errUsernameSpaces  = errors.New("invalid username: the name can't start or end with a space")
+	errUsernameTooLong = fmt.Errorf("invalid username: the name is longer than %d characters", maxUsernameLength)
+)
+
+// validateUsername checks that a username is at most maxUsernameLength characters long,
+// and only contains letters, digits, spaces (except at the start and end) and the
+// punctuation in usernamePunct.
+func validateUsername(name string) error {
+	switch {
+	case name == "":
+		return errUsernameEmpty
+	case strings.TrimSpace(name) != name:
+		return errUsernameSpaces
+	case utf8.RuneCountInString(name) > maxUsernameLength:
+		return errUsernameTooLong
+	}
+
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == ' ' || strings.ContainsRune(usernamePunct, r) {
+			continue
+		}
+		return fmt.Errorf("invalid username: %q isn't allowed in names", r)
+	}
+
+	return nil
+}
+
+// uniqueName returns name, or if it's taken, name followed by the lowest numeric suffix
+// ("-2", "-3", ...) which gives a name that isn't taken. Names are compared
+// case-insensitively, and taken holds lowercase names.
+func uniqueName(name string, taken map[string]bool) string {
+	unique := name
+	for n := 2; taken[strings.ToLower(unique)]; n++ {
+		unique = fmt.Sprintf("%s-%d", name, n)
+	}
+	return unique
+}
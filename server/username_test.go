@@ -0,0 +1,48 @@
+package server
+
+import "testing"
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		description string
+		name        string
+		valid       bool
+	}{
+		{description: "simple name", name: "alice", valid: true},
+		{description: "spaces and punctuation", name: "Mary-Jane O'Neil", valid: true},
+		{description: "non-ASCII letters", name: "Zoë 李", valid: true},
+		{description: "empty", name: "", valid: false},
+		{description: "leading space", name: " bob", valid: false},
+		{description: "trailing space", name: "bob ", valid: false},
+		{description: "too long", name: "abcdefghijklmnopqrstuvwxyzabcdefg", valid: false},
+		{description: "separator", name: "a,b", valid: false},
+		{description: "control character", name: "a\nb", valid: false},
+	}
+
+	for _, tc := range tests {
+		err := validateUsername(tc.name)
+		if (err == nil) != tc.valid {
+			t.Errorf("(%s) got error %v, expected valid = %v\n", tc.description, err, tc.valid)
+		}
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	tests := []struct {
+		description string
+		name        string
+		taken       map[string]bool
+		expected    string
+	}{
+		{description: "free name", name: "alice", taken: map[string]bool{"bob": true}, expected: "alice"},
+		{description: "taken name", name: "alice", taken: map[string]bool{"alice": true}, expected: "alice-2"},
+		{description: "different case", name: "Alice", taken: map[string]bool{"alice": true}, expected: "Alice-2"},
+		{description: "taken suffix", name: "alice", taken: map[string]bool{"alice": true, "alice-2": true}, expected: "alice-3"},
+	}
+
+	for _, tc := range tests {
+		if got := uniqueName(tc.name, tc.taken); got != tc.expected {
+			t.Errorf("(%s) got %q, expected %q\n", tc.description, got, tc.expected)
+		}
+	}
+}
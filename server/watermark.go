@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultWatermarkComment starts the lines of watermarks without a Comment.
+const defaultWatermarkComment = "//"
+
+// A Watermark embeds the metadata of a room's session (its ID, participants and the time
+// of the export) in the documents exported through the API, as a comment, so archived
+// interviews can be traced back to their session.
+type Watermark struct {
+	// Rooms is a pattern, in the syntax of path.Match, of the names of the rooms whose
+	// exports are watermarked, such as "interview-*". Empty matches every room.
+	Rooms string
+
+	// Comment starts the lines of the watermark, such as "#" or "--". Empty means "//".
+	// Exports can choose another with the "comment" query parameter, to match the
+	// language of the room's document.
+	Comment string
+
+	// Footer puts the watermark after the document, rather than before it.
+	Footer bool
+}
+
+// matches reports whether the exports of the room name are watermarked.
+func (w *Watermark) matches(name string) bool {
+	if w == nil {
+		return false
+	}
+	if w.Rooms == "" {
+		return true
+	}
+	matched, _ := path.Match(w.Rooms, name)
+	return matched
+}
+
+// A participant is a user who joined a room's session.
+type participant struct {
+	name        string
+	interviewer bool
+}
+
+// sessionInfo describes a room's session, for watermarks.
+type sessionInfo struct {
+	id           string
+	started      time.Time
+	participants []participant
+}
+
+// addParticipant adds a user who joined the room to its session's participants, unless a
+// user with the same name has already joined.
+func (r *room) addParticipant(name string, interviewer bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.participants {
+		if p.name == name {
+			return
+		}
+	}
+	r.participants = append(r.participants, participant{name: name, interviewer: interviewer})
+}
+
+// sessionInfo returns the description of the room's session.
+func (r *room) sessionInfo() sessionInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return sessionInfo{id: r.session, started: r.started, participants: append([]participant(nil), r.participants...)}
+}
+
+// watermark returns text with the watermark of the room name, whose session is described
+// by info if it's open, exported at now. comment overrides the watermark's Comment if it
+// isn't empty.
+func (w *Watermark) watermark(text, name string, info *sessionInfo, comment string, now time.Time) string {
+	if comment == "" {
+		comment = w.Comment
+	}
+	if comment == "" {
+		comment = defaultWatermarkComment
+	}
+
+	lines := []string{"pairpad room: " + name}
+	if info != nil {
+		lines = append(lines, fmt.Sprintf("session: %s, started %s", info.id, info.started.UTC().Format(time.RFC3339)))
+		names := make([]string, len(info.participants))
+		for i, p := range info.participants {
+			names[i] = p.name
+			if p.interviewer {
+				names[i] += " (interviewer)"
+			}
+		}
+		if len(names) > 0 {
+			lines = append(lines, "participants: "+strings.Join(names, ", "))
+		}
+	}
+	lines = append(lines, "exported: "+now.UTC().Format(time.RFC3339))
+
+	var b strings.Builder
+	if w.Footer && text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	if w.Footer {
+		b.WriteString(text)
+	}
+	for _, line := range lines {
+		b.WriteString(comment + " " + line + "\n")
+	}
+	if !w.Footer {
+		b.WriteString(text)
+	}
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/burntcarrot/pairpad/commons"
+	"github.com/burntcarrot/pairpad/server/store"
+)
+
+// TestWatermark checks the watermarks of exported documents.
+func TestWatermark(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	info := &sessionInfo{
+		id:           "s1",
+		started:      time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC),
+		participants: []participant{{name: "alice", interviewer: true}, {name: "bob"}},
+	}
+
+	tests := []struct {
+		description string
+		watermark   Watermark
+		text        string
+		info        *sessionInfo
+		comment     string
+		expected    string
+	}{
+		{
+			description: "header",
+			text:        "x := 1\n",
+			info:        info,
+			expected:    "// pairpad room: team\n// session: s1, started 2024-05-01T09:30:00Z\n// participants: alice (interviewer), bob\n// exported: 2024-05-01T10:30:00Z\nx := 1\n",
+		},
+		{
+			description: "footer without final newline",
+			watermark:   Watermark{Comment: "#", Footer: true},
+			text:        "x = 1",
+			expected:    "x = 1\n# pairpad room: team\n# exported: 2024-05-01T10:30:00Z\n",
+		},
+		{
+			description: "comment of the export",
+			watermark:   Watermark{Comment: "#"},
+			comment:     "--",
+			expected:    "-- pairpad room: team\n-- exported: 2024-05-01T10:30:00Z\n",
+		},
+	}
+
+	for _, tc := range tests {
+		if got := tc.watermark.watermark(tc.text, "team", tc.info, tc.comment, now); got != tc.expected {
+			t.Errorf("%s: got %q, expected %q", tc.description, got, tc.expected)
+		}
+	}
+}
+
+// TestWatermarkAPI checks that only the documents of the rooms matching the watermark's
+// pattern are watermarked, with the session's participants.
+func TestWatermarkAPI(t *testing.T) {
+	ts := httptest.NewServer(New(Config{Store: store.Dir{Path: t.TempDir()}, APIToken: "secret", Watermark: &Watermark{Rooms: "interview-*", Comment: "#"}}).Handler())
+	defer ts.Close()
+
+	for _, room := range []string{"interview-1", "team"} {
+		conn := dial(t, ts.URL+"/?room="+room)
+		_ = conn.WriteJSON(commons.Message{Type: commons.JoinMessage, Username: "alice"})
+		readUntil(t, conn, commons.JoinAckMessage)
+		_ = conn.WriteJSON(commons.Message{Type: commons.OperationMessage, Operation: commons.Operation{Type: "insert", Position: 1, Value: "x"}, Seq: 1})
+		readUntil(t, conn, commons.AckMessage)
+	}
+
+	get := func(path string) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return string(data)
+	}
+
+	if got := get("/rooms/team/content"); got != "x" {
+		t.Errorf("got %q, expected the document without a watermark", got)
+	}
+	got := get("/rooms/interview-1/content?comment=%2F%2F")
+	if !strings.HasPrefix(got, "// pairpad room: interview-1\n// session: ") || !strings.Contains(got, "\n// participants: alice\n") || !strings.HasSuffix(got, "\nx") {
+		t.Errorf("got %q, expected the document with a watermark", got)
+	}
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// webFiles holds the web client, which speaks the same protocol as the terminal client.
+//
+//go:embed web
+var webFiles embed.FS
+
+// webHandler returns a handler serving the web client's files.
+func webHandler() http.Handler {
+	sub, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}
@@ -0,0 +1,516 @@
+// pairpad's web client. It speaks the JSON protocol described in PROTOCOL.md, like the
+// terminal client, and keeps the same WOOT document: the characters its operations insert
+// or delete are sent with them, and integrated by their IDs when they're received.
+"use strict";
+
+// protocolVersion is the version of the protocol spoken by the client.
+const protocolVersion = 1;
+
+// Clients turned away with these error codes, such as for a full room, try joining again
+// after joinRetryDelay milliseconds, up to maxJoinRetries times.
+const retryableErrors = ["room-full", "rate-limited"];
+const joinRetryDelay = 5000;
+const maxJoinRetries = 5;
+
+// The colors of the users, indexed by the color assigned by the server.
+const userColors = ["#4c4", "#cc4", "#48f", "#c4c", "#4cc", "#ee8", "#e8e", "#8e8", "#e88", "#c44"];
+
+const editor = document.getElementById("editor");
+const statusBar = document.getElementById("status");
+const usersBar = document.getElementById("users");
+
+// doc holds the characters of the document, including the deleted ones and the start and
+// end characters, in the order of the document, as they're encoded in docSync messages.
+let doc = newDocument();
+
+// text holds the document's content, as an array of code points (the server and the
+// other clients count positions in code points, not UTF-16 code units). It's the values of
+// the visible characters of doc.
+let text = [];
+
+// clock is the clock of the last character generated by the tab.
+let clock = 0;
+
+// siteID is the site ID assigned by the server.
+let siteID = "";
+
+// currentRoom is the room the tab joined.
+let currentRoom = "";
+
+// username is the name given by the server, which may differ from the one asked for.
+let username = "";
+
+let ws = null;
+
+// joinRetries is the number of times the tab tried joining again since it last joined.
+let joinRetries = 0;
+
+// retryJoin is set when the server turned the tab away with an error which can be retried.
+let retryJoin = false;
+
+// siteStorageKey returns the key of the session storage item holding the tab's site ID in
+// a room, with its token.
+function siteStorageKey(room) {
+  return `pairpad-site/${room}`;
+}
+
+// nameStorageKey returns the key of the session storage item holding the name the tab was
+// given in a room, with the token with which it keeps the name when it joins again.
+function nameStorageKey(room) {
+  return `pairpad-name/${room}`;
+}
+
+// wsURL returns the URL of the server's WebSocket endpoint. The web client is served
+// from the "web/" directory below it.
+function wsURL(room) {
+  const url = new URL("../", location.href);
+  url.protocol = url.protocol === "https:" ? "wss:" : "ws:";
+  const params = new URLSearchParams();
+  params.set("protocol", protocolVersion);
+  if (room) {
+    params.set("room", room);
+  }
+  // Interviewers open the page with their token, e.g. /web/?interviewer=TOKEN.
+  const token = new URLSearchParams(location.search).get("interviewer");
+  if (token) {
+    params.set("interviewer", token);
+  }
+  // Keep the site ID the tab had in the room, e.g. when the page is reloaded.
+  const site = JSON.parse(sessionStorage.getItem(siteStorageKey(room)) || "null");
+  if (site) {
+    params.set("site", site.siteID);
+    params.set("token", site.token);
+  }
+  url.search = params.toString();
+  url.hash = "";
+  return url.href;
+}
+
+function send(msg) {
+  if (ws && ws.readyState === WebSocket.OPEN) {
+    ws.send(JSON.stringify(msg));
+  }
+}
+
+function setStatus(msg) {
+  statusBar.textContent = msg;
+}
+
+// toCodePoints converts a UTF-16 offset in the editor's value to a code point index.
+function toCodePoints(offset) {
+  return Array.from(editor.value.slice(0, offset)).length;
+}
+
+// toOffset converts a code point index to a UTF-16 offset in the editor's value.
+function toOffset(index) {
+  return text.slice(0, index).join("").length;
+}
+
+// render shows the document, with the selection between the code point indexes start and end.
+function render(start, end) {
+  editor.value = text.join("");
+  editor.setSelectionRange(toOffset(start), toOffset(end));
+}
+
+// newDocument returns the characters of an empty document.
+function newDocument() {
+  return [
+    { ID: "start", Visible: false, Value: "", IDPrevious: "", IDNext: "end" },
+    { ID: "end", Visible: false, Value: "", IDPrevious: "start", IDNext: "" },
+  ];
+}
+
+// parseID returns the site ID and clock of a character ID, as encoded by crdt.CharacterID.
+// The start and end characters have site -1, and the IDs of older versions of pairpad,
+// which have no site, have site -2.
+function parseID(id) {
+  if (id === "start") return [-1, 0];
+  if (id === "end") return [-1, 1];
+  const dot = id.indexOf(".");
+  if (dot < 0) return [-2, Number(id)];
+  return [Number(id.slice(0, dot)), Number(id.slice(dot + 1))];
+}
+
+// compareIDs orders character IDs by site ID, then by clock, as crdt.CharacterID.Compare.
+function compareIDs(a, b) {
+  const [siteA, clockA] = parseID(a);
+  const [siteB, clockB] = parseID(b);
+  return siteA !== siteB ? siteA - siteB : clockA - clockB;
+}
+
+// indexOf returns the index of the character with the given ID in doc, or -1.
+function indexOf(id) {
+  return doc.findIndex((c) => c.ID === id);
+}
+
+// visibleAt returns the index in doc of the visible character at the code point index i,
+// or -1 if there's none.
+function visibleAt(i) {
+  let n = 0;
+  for (let j = 0; j < doc.length; j++) {
+    if (doc[j].Visible) {
+      if (n === i) return j;
+      n++;
+    }
+  }
+  return -1;
+}
+
+// visibleIndex returns the code point index of the character at index j of doc, counting
+// the visible characters before it.
+function visibleIndex(j) {
+  return doc.slice(0, j).filter((c) => c.Visible).length;
+}
+
+// refreshText sets text to the content of doc.
+function refreshText() {
+  text = doc.filter((c) => c.Visible).map((c) => c.Value);
+}
+
+// syncClock sets clock past the clocks of the characters of doc generated by the tab, whose
+// site ID may have been used before, e.g. by the tab before it was reloaded.
+function syncClock() {
+  doc.forEach((c) => {
+    const [site, n] = parseID(c.ID);
+    if (String(site) === siteID && n > clock) clock = n;
+  });
+}
+
+// integrate inserts c between the characters with the IDs prev and next, ordering it by ID
+// among the characters between them as crdt.Document.IntegrateInsert does, so that every
+// client puts concurrent inserts in the same order.
+function integrate(c, prev, next) {
+  const from = indexOf(prev);
+  const to = indexOf(next);
+  if (from < 0 || to < 0 || from >= to) {
+    throw new Error(`${c.ID} was inserted between ${prev} and ${next}, which aren't in the document`);
+  }
+  const between = doc.slice(from + 1, to);
+  if (between.length === 0) {
+    doc.splice(to, 0, c);
+    return;
+  }
+
+  // Order c among the characters whose own neighbours are outside the range, and integrate
+  // it recursively between the two it falls between.
+  const inside = new Set(between.map((x) => x.ID));
+  const bounded = [prev, ...between.filter((x) => !inside.has(x.IDPrevious) && !inside.has(x.IDNext)).map((x) => x.ID), next];
+  if (bounded.length === 2) {
+    throw new Error(`none of the characters between ${prev} and ${next} were inserted between them`);
+  }
+  let i = 1;
+  while (i < bounded.length - 1 && compareIDs(bounded[i], c.ID) < 0) {
+    i++;
+  }
+  integrate(c, bounded[i - 1], bounded[i]);
+}
+
+// generateInsert inserts value at the code point index i, with a new character of the tab,
+// and returns the character.
+function generateInsert(i, value) {
+  const prev = i > 0 ? visibleAt(i - 1) : -1;
+  const next = visibleAt(i);
+  clock++;
+  const c = {
+    ID: `${siteID}.${clock}`,
+    Visible: true,
+    Value: value,
+    IDPrevious: prev >= 0 ? doc[prev].ID : "start",
+    IDNext: next >= 0 ? doc[next].ID : "end",
+  };
+  integrate(c, c.IDPrevious, c.IDNext);
+  return c;
+}
+
+// generateDelete deletes the visible character at the code point index i, and returns a
+// copy of it as it was, or null if there's none.
+function generateDelete(i) {
+  const j = visibleAt(i);
+  if (j < 0) return null;
+  const c = { ...doc[j] };
+  doc[j].Visible = false;
+  return c;
+}
+
+// applyOperation applies an operation to doc, and returns the code point index of the first
+// character it inserted or deleted, or -1 if it changed nothing. The characters of
+// operations carrying them are integrated by ID; the operations of older clients, which
+// don't, are applied by position, as the terminal client does. It throws if the operation
+// refers to characters doc doesn't have.
+function applyOperation(op) {
+  if (op.type === "insert" && op.insert) {
+    let at = -1;
+    for (const c of op.insert.characters) {
+      if (indexOf(c.ID) >= 0) continue;
+      integrate({ ...c }, c.IDPrevious, c.IDNext);
+      if (at < 0) at = visibleIndex(indexOf(c.ID));
+    }
+    return at;
+  }
+  if (op.type === "delete" && op.delete) {
+    const j = indexOf(op.delete.character.ID);
+    if (j < 0) {
+      throw new Error(`${op.delete.character.ID} isn't in the document`);
+    }
+    if (!doc[j].Visible) return -1;
+    doc[j].Visible = false;
+    return visibleIndex(j);
+  }
+
+  const at = op.position - 1;
+  if (op.type === "insert") {
+    if (at < 0 || at > text.length) return -1;
+    Array.from(op.value).forEach((c, i) => generateInsert(at + i, c));
+    return at;
+  }
+  if (op.type === "delete") {
+    return generateDelete(at) ? at : -1;
+  }
+  return -1;
+}
+
+// applyRemote applies an operation received from another client, keeping the local
+// selection. If the operation refers to characters the tab doesn't have, its document is
+// out of date, and it's requested again.
+function applyRemote(op) {
+  let start = toCodePoints(editor.selectionStart);
+  let end = toCodePoints(editor.selectionEnd);
+
+  let at;
+  try {
+    at = applyOperation(op);
+  } catch (err) {
+    setStatus(`Missed an edit (${err.message}), requesting the document again`);
+    send({ type: "docReq" });
+    return;
+  }
+  refreshText();
+
+  if (at >= 0 && op.type === "insert") {
+    const n = Array.from(op.value).length;
+    if (at <= start) start += n;
+    if (at <= end) end += n;
+  } else if (at >= 0 && op.type === "delete") {
+    if (at < start) start--;
+    if (at < end) end--;
+  }
+
+  render(start, end);
+}
+
+// sendEdits compares the editor's value with the document, and sends the difference as
+// delete and insert operations, with the characters they delete or insert.
+function sendEdits() {
+  const next = Array.from(editor.value);
+
+  let prefix = 0;
+  while (prefix < text.length && prefix < next.length && text[prefix] === next[prefix]) {
+    prefix++;
+  }
+  let suffix = 0;
+  while (suffix < text.length - prefix && suffix < next.length - prefix &&
+    text[text.length - 1 - suffix] === next[next.length - 1 - suffix]) {
+    suffix++;
+  }
+
+  const removed = text.length - prefix - suffix;
+  for (let i = 0; i < removed; i++) {
+    const c = generateDelete(prefix);
+    send({ type: "operation", operation: { type: "delete", position: prefix + 1, delete: { character: c } } });
+  }
+  next.slice(prefix, next.length - suffix).forEach((value, i) => {
+    const c = generateInsert(prefix + i, value);
+    send({ type: "operation", operation: { type: "insert", position: prefix + i + 1, value, insert: { characters: [c] } } });
+  });
+
+  refreshText();
+}
+
+// sentSelection is the last selection sent to the other clients, as "start-end".
+let sentSelection = "0-0";
+
+// sendSelection sends the editor's selection to the other clients, as the positions of the
+// first and last selected characters, if it has changed since it was last sent.
+function sendSelection() {
+  const start = toCodePoints(editor.selectionStart);
+  const end = toCodePoints(editor.selectionEnd);
+  const selection = start < end ? { start: start + 1, end } : { start: 0, end: 0 };
+  const key = `${selection.start}-${selection.end}`;
+  if (key !== sentSelection) {
+    sentSelection = key;
+    send({ type: "selection", username, selection });
+  }
+}
+
+// setReadOnly stops the user from editing the document while an interviewer has removed
+// their edit access.
+function setReadOnly(readOnly) {
+  editor.readOnly = readOnly;
+}
+
+function renderUsers(msg) {
+  const users = msg.users || msg.text.split(",").filter((name) => name).map((name, i) => ({ name, color: i }));
+  usersBar.replaceChildren(...users.map((u) => {
+    const span = document.createElement("span");
+    span.textContent = u.name;
+    span.style.color = userColors[u.color % userColors.length];
+    return span;
+  }));
+}
+
+function handleMsg(msg) {
+  switch (msg.type) {
+    case "SiteID":
+      siteID = msg.text;
+      joinRetries = 0;
+      if (msg.token) {
+        sessionStorage.setItem(siteStorageKey(currentRoom), JSON.stringify({ siteID, token: msg.token }));
+      }
+      break;
+
+    case "docSync":
+      doc = (msg.document && msg.document.Characters) || newDocument();
+      syncClock();
+      refreshText();
+      render(0, 0);
+      break;
+
+    case "docReq":
+      send({ type: "docSync", ID: msg.ID, document: { Characters: doc } });
+      break;
+
+    case "join":
+      setStatus(`${msg.username} has joined the session!`);
+      break;
+
+    case "joinAck":
+      if (msg.username !== username) {
+        setStatus(`The name ${username} is already taken, so you joined as ${msg.username}`);
+      }
+      username = msg.username;
+      if (msg.token) {
+        sessionStorage.setItem(nameStorageKey(currentRoom), JSON.stringify({ name: username, token: msg.token }));
+      }
+      break;
+
+    case "leave":
+      setStatus(`${msg.username} has left the session (${msg.text})`);
+      break;
+
+    case "users":
+      renderUsers(msg);
+      // Clients joining while candidates are read-only learn it from the list.
+      (msg.users || []).filter((u) => u.siteID === siteID).forEach((u) => setReadOnly(!!u.readOnly));
+      break;
+
+    case "prompt":
+      if (!msg.annotation.deleted) {
+        setStatus(`${msg.annotation.author} asked: ${msg.annotation.text}`);
+      }
+      break;
+
+    case "access":
+      if (!msg.username || msg.username === username) {
+        setReadOnly(msg.text === "read-only");
+        setStatus(msg.text === "read-only" ? "An interviewer made the document read-only" : "An interviewer gave you edit access");
+      }
+      break;
+
+    case "ping":
+      setStatus(`${msg.username} is asking for your attention`);
+      break;
+
+    case "notice":
+      setStatus(msg.text);
+      break;
+
+    case "error":
+      if (retryableErrors.includes(msg.code) && joinRetries < maxJoinRetries) {
+        retryJoin = true;
+        setStatus(`${msg.text}, trying again in ${joinRetryDelay / 1000} seconds`);
+        break;
+      }
+      setStatus(`Server error: ${msg.text}`);
+      // Undo inserts rejected by the server, since the other clients never received them.
+      // Deleted characters can't be restored locally, so the document is requested again.
+      if (msg.operation && msg.operation.type === "insert" && msg.operation.insert) {
+        msg.operation.insert.characters.forEach((c) => applyRemote({ type: "delete", position: 0, delete: { character: c } }));
+      } else if (msg.operation && msg.operation.type === "insert") {
+        applyRemote({ type: "delete", position: msg.operation.position });
+      } else if (msg.operation && msg.operation.type === "delete") {
+        send({ type: "docReq" });
+      }
+      break;
+
+    case "operation":
+      applyRemote(msg.operation);
+      break;
+  }
+}
+
+function join(name, room) {
+  username = name;
+  currentRoom = room;
+  ws = new WebSocket(wsURL(room));
+
+  ws.addEventListener("open", () => {
+    document.body.classList.add("connected");
+    // Present the token of the name the tab had in the room, if it asks for it again.
+    const prev = JSON.parse(sessionStorage.getItem(nameStorageKey(room)) || "null");
+    const token = prev && prev.name.toLowerCase() === name.toLowerCase() ? prev.token : undefined;
+    send({ type: "join", username: name, text: "has joined the session.", token });
+  });
+  ws.addEventListener("message", (ev) => handleMsg(JSON.parse(ev.data)));
+  ws.addEventListener("close", (ev) => {
+    document.body.classList.remove("connected");
+    editor.readOnly = true;
+    if (retryJoin) {
+      retryJoin = false;
+      joinRetries++;
+      setTimeout(() => join(username, currentRoom), joinRetryDelay);
+      return;
+    }
+    setStatus(`Disconnected${ev.reason ? ": " + ev.reason : ""}`);
+  });
+
+  document.getElementById("join").style.display = "none";
+  document.getElementById("session").style.display = "flex";
+  editor.focus();
+}
+
+// Input is sent once an IME composition has been committed.
+editor.addEventListener("input", (ev) => {
+  if (!ev.isComposing) {
+    sendEdits();
+  }
+});
+editor.addEventListener("compositionend", sendEdits);
+
+// Insert spaces for Tab, like the terminal client, instead of moving the focus.
+editor.addEventListener("keydown", (ev) => {
+  if (ev.key === "Tab") {
+    ev.preventDefault();
+    document.execCommand("insertText", false, "    ");
+  }
+});
+
+// Show the other users what's selected in the editor.
+document.addEventListener("selectionchange", () => {
+  if (document.activeElement === editor) {
+    sendSelection();
+  }
+});
+
+window.addEventListener("beforeunload", () => {
+  if (ws) {
+    ws.close(1000);
+  }
+});
+
+const params = new URLSearchParams(location.search);
+document.getElementById("name").value = params.get("name") || "";
+document.getElementById("room").value = params.get("room") || "";
+document.getElementById("join").addEventListener("submit", (ev) => {
+  ev.preventDefault();
+  join(document.getElementById("name").value.trim(), document.getElementById("room").value.trim());
+});